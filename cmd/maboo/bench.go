@@ -0,0 +1,718 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/server"
+	"github.com/sadewadee/maboo/internal/worker"
+)
+
+// exitThreshold is the bench exit code when a --max-*/--min-* threshold
+// is not met.
+const exitThreshold = 1
+
+// benchOptions describes the load bench generates.
+type benchOptions struct {
+	target      string // base URL, no trailing slash
+	paths       []string
+	method      string
+	concurrency int
+	duration    time.Duration
+	bodySize    int
+	timeout     time.Duration
+}
+
+// benchThresholds fail the run for CI; zero values are not checked.
+type benchThresholds struct {
+	maxP99       time.Duration
+	maxErrorRate float64
+	minRPS       float64
+}
+
+// benchReport is the result of a run, printed as a table or JSON.
+type benchReport struct {
+	Target          string           `json:"target"`
+	InProcess       bool             `json:"in_process"`
+	Concurrency     int              `json:"concurrency"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	Requests        int64            `json:"requests"`
+	RPS             float64          `json:"rps"`
+	BytesReceived   int64            `json:"bytes_received"`
+	Latency         benchLatency     `json:"latency_ms"`
+	Errors          map[string]int64 `json:"errors"` // by class: status_502, timeout, refused, reset, other
+	ErrorRate       float64          `json:"error_rate"`
+	Pool            *benchPoolStats  `json:"pool,omitempty"`
+	Failures        []string         `json:"threshold_failures,omitempty"`
+}
+
+// benchLatency summarizes request latencies in milliseconds.
+type benchLatency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// benchPoolStats is the PHP pool as sampled during the run.
+type benchPoolStats struct {
+	Samples     int      `json:"samples"`
+	Workers     int      `json:"workers"`
+	BusyMean    float64  `json:"busy_mean"`
+	BusyPeak    int      `json:"busy_peak"`
+	Utilization float64  `json:"utilization"` // mean busy / workers
+	Requests    int64    `json:"requests"`    // served by the pool during the run
+	QueueWaitMS *float64 `json:"queue_wait_mean_ms,omitempty"`
+	Recycles    *int64   `json:"recycles,omitempty"`
+}
+
+// runBench drives load against a running maboo, or one started in this
+// process with a mock engine, and returns the exit code: 0, exitThreshold
+// when a threshold failed, or exitUsage.
+func runBench(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	cfgPath := fs.String("config", "maboo.yaml", "config naming the server, admin and metrics addresses")
+	target := fs.String("url", "", "base URL to load (default: server.address from the config)")
+	var paths []string
+	fs.Func("path", "path to request, repeatable; requests cycle through them (default /)", func(s string) error {
+		if !strings.HasPrefix(s, "/") {
+			return fmt.Errorf("path %q must start with /", s)
+		}
+		paths = append(paths, s)
+		return nil
+	})
+	method := fs.String("method", "", "request method (default GET, or POST with --body-size)")
+	concurrency := fs.Int("c", 10, "concurrent connections")
+	duration := fs.Duration("duration", 10*time.Second, "how long to run")
+	bodySize := fs.Int("body-size", 0, "request body size in bytes")
+	timeout := fs.Duration("timeout", 30*time.Second, "per-request timeout")
+	admin := fs.String("admin", "", "admin API address to sample pool stats from (default: admin.address when enabled)")
+	token := fs.String("token", "", "admin API token (default: admin.token from the config)")
+	interval := fs.Duration("sample-interval", time.Second, "how often pool stats are sampled")
+	inProcess := fs.Bool("in-process", false, "start maboo in this process with a mock PHP engine and load it")
+	mockLatency := fs.Duration("mock-latency", 0, "--in-process: time the mock engine spends on each request")
+	mockBody := fs.Int("mock-body", 1024, "--in-process: response body size in bytes")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	maxP99 := fs.Duration("max-p99", 0, "fail if the p99 latency is above this")
+	maxErrorRate := fs.Float64("max-error-rate", -1, "fail if the share of failed requests (0-1) is above this")
+	minRPS := fs.Float64("min-rps", 0, "fail if fewer requests per second were served")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *concurrency < 1 || *duration <= 0 || *bodySize < 0 || *interval <= 0 {
+		fmt.Fprintln(errOut, "Error: -c, --duration and --sample-interval must be positive, --body-size not negative")
+		return exitUsage
+	}
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	if *method == "" {
+		*method = http.MethodGet
+		if *bodySize > 0 {
+			*method = http.MethodPost
+		}
+	}
+
+	cfg := config.Default()
+	if _, err := os.Stat(*cfgPath); err == nil {
+		if cfg, err = config.LoadWithOptions(*cfgPath, config.Options{NonStrict: true}); err != nil {
+			fmt.Fprintf(errOut, "Error: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	opts := benchOptions{
+		paths:       paths,
+		method:      strings.ToUpper(*method),
+		concurrency: *concurrency,
+		duration:    *duration,
+		bodySize:    *bodySize,
+		timeout:     *timeout,
+	}
+	var sample poolSampler
+	var recycles func() (int64, error)
+	if *inProcess {
+		inst, err := startInProcess(cfg, *mockLatency, *mockBody)
+		if err != nil {
+			fmt.Fprintf(errOut, "Error: starting in-process server: %v\n", err)
+			return exitUsage
+		}
+		defer inst.stop()
+		opts.target = inst.target
+		sample = inst.pool.sample
+	} else {
+		opts.target = strings.TrimSuffix(*target, "/")
+		if opts.target == "" {
+			opts.target = serverURL(cfg)
+		}
+		st := resolveStatusTarget(cfg, *admin, *token)
+		if st.admin {
+			sample = adminSampler(st, *timeout)
+		}
+		if url := metricsURL(cfg, opts.target); url != "" {
+			recycles = metricsCounter(url, cfg.Metrics.Token.Value(), "maboo_worker_recycled_total", *timeout)
+		}
+	}
+
+	report := bench(opts, sample, recycles, *interval)
+	report.InProcess = *inProcess
+	report.check(benchThresholds{maxP99: *maxP99, maxErrorRate: *maxErrorRate, minRPS: *minRPS})
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printBench(out, report)
+	}
+	if len(report.Failures) > 0 {
+		return exitThreshold
+	}
+	return 0
+}
+
+// bench runs the load described by opts, sampling the pool every interval
+// when sample is set, and summarizes it.
+func bench(opts benchOptions, sample poolSampler, recycles func() (int64, error), interval time.Duration) *benchReport {
+	client := &http.Client{
+		Timeout: opts.timeout,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: opts.concurrency,
+			// Like status, bench usually targets the local host by IP,
+			// where the certificate name cannot match.
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	defer client.CloseIdleConnections()
+	body := bytes.Repeat([]byte("x"), opts.bodySize)
+
+	var recyclesBefore int64
+	var recyclesErr error
+	if recycles != nil {
+		recyclesBefore, recyclesErr = recycles()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.duration)
+	defer cancel()
+
+	var sampler *poolSamples
+	samplerDone := make(chan struct{})
+	if sample != nil {
+		sampler = &poolSamples{}
+		go func() {
+			defer close(samplerDone)
+			sampler.run(ctx, sample, interval)
+		}()
+	} else {
+		close(samplerDone)
+	}
+
+	var next atomic.Int64
+	results := make([]benchResults, opts.concurrency)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(res *benchResults) {
+			defer wg.Done()
+			res.errors = make(map[string]int64)
+			for ctx.Err() == nil {
+				path := opts.paths[int(next.Add(1)-1)%len(opts.paths)]
+				res.do(ctx, client, opts.method, opts.target+path, body)
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	<-samplerDone
+
+	report := summarize(results, elapsed)
+	report.Target = opts.target
+	report.Concurrency = opts.concurrency
+	if sampler != nil {
+		report.Pool = sampler.stats()
+	}
+	if recycles != nil && recyclesErr == nil {
+		if after, err := recycles(); err == nil {
+			if report.Pool == nil {
+				report.Pool = &benchPoolStats{}
+			}
+			n := after - recyclesBefore
+			report.Pool.Recycles = &n
+		}
+	}
+	return report
+}
+
+// benchResults is what one connection saw.
+type benchResults struct {
+	latencies []time.Duration // of the requests answered, errors included
+	errors    map[string]int64
+	bytes     int64
+}
+
+func (r *benchResults) do(ctx context.Context, client *http.Client, method, url string, body []byte) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		r.errors["other"]++
+		return
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if ctx.Err() != nil {
+		return // cut short by the end of the run
+	}
+	r.bytes += n
+	r.latencies = append(r.latencies, time.Since(start))
+	if class := errorClass(resp, err); class != "" {
+		r.errors[class]++
+	}
+}
+
+// errorClass names what went wrong with a request, or returns "" when it
+// succeeded.
+func errorClass(resp *http.Response, err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil && resp.StatusCode >= 400:
+		return "status_" + strconv.Itoa(resp.StatusCode)
+	case err == nil:
+		return ""
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "reset"
+	default:
+		return "other"
+	}
+}
+
+func summarize(results []benchResults, elapsed time.Duration) *benchReport {
+	r := &benchReport{DurationSeconds: elapsed.Seconds(), Errors: make(map[string]int64)}
+	var all []time.Duration
+	var failed int64
+	for _, res := range results {
+		all = append(all, res.latencies...)
+		r.BytesReceived += res.bytes
+		for class, n := range res.errors {
+			r.Errors[class] += n
+			failed += n
+		}
+	}
+	r.Requests = int64(len(all))
+	if r.Requests > 0 {
+		r.RPS = float64(r.Requests) / elapsed.Seconds()
+		r.ErrorRate = float64(failed) / float64(r.Requests)
+	}
+	if len(all) == 0 {
+		return r
+	}
+	slices.Sort(all)
+	var sum time.Duration
+	for _, d := range all {
+		sum += d
+	}
+	r.Latency = benchLatency{
+		Min:  ms(all[0]),
+		Mean: ms(sum / time.Duration(len(all))),
+		P50:  ms(percentile(all, 0.50)),
+		P90:  ms(percentile(all, 0.90)),
+		P99:  ms(percentile(all, 0.99)),
+		Max:  ms(all[len(all)-1]),
+	}
+	return r
+}
+
+// percentile returns the q-th quantile of sorted, nearest-rank.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+func ms(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// check records the thresholds r does not meet. A negative maxErrorRate is
+// not checked.
+func (r *benchReport) check(t benchThresholds) {
+	if t.maxP99 > 0 && r.Latency.P99 > ms(t.maxP99) {
+		r.Failures = append(r.Failures, fmt.Sprintf("p99 latency %.1fms is above %s", r.Latency.P99, t.maxP99))
+	}
+	if t.maxErrorRate >= 0 && r.ErrorRate > t.maxErrorRate {
+		r.Failures = append(r.Failures, fmt.Sprintf("error rate %.4f is above %g", r.ErrorRate, t.maxErrorRate))
+	}
+	if t.minRPS > 0 && r.RPS < t.minRPS {
+		r.Failures = append(r.Failures, fmt.Sprintf("%.1f requests/s is below %g", r.RPS, t.minRPS))
+	}
+}
+
+// poolSampler reads the state of the PHP pool.
+type poolSampler func() (poolSample, error)
+
+type poolSample struct {
+	workers, busy int
+	requests      int64
+	queueWait     *time.Duration // mean since start, when the pool measures it
+}
+
+// poolSamples accumulates the samples taken during a run.
+type poolSamples struct {
+	n          int
+	first      poolSample
+	last       poolSample
+	busySum    int
+	busyPeak   int
+	workersMax int
+}
+
+func (s *poolSamples) run(ctx context.Context, sample poolSampler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	s.take(sample) // baseline for the request count
+	for {
+		select {
+		case <-ctx.Done():
+			s.take(sample)
+			return
+		case <-ticker.C:
+			s.take(sample)
+		}
+	}
+}
+
+func (s *poolSamples) take(sample poolSampler) {
+	p, err := sample()
+	if err != nil {
+		return
+	}
+	if s.n == 0 {
+		s.first = p
+	}
+	s.n++
+	s.last = p
+	s.busySum += p.busy
+	s.busyPeak = max(s.busyPeak, p.busy)
+	s.workersMax = max(s.workersMax, p.workers)
+}
+
+func (s *poolSamples) stats() *benchPoolStats {
+	if s.n == 0 {
+		return nil
+	}
+	st := &benchPoolStats{
+		Samples:  s.n,
+		Workers:  s.workersMax,
+		BusyMean: float64(s.busySum) / float64(s.n),
+		BusyPeak: s.busyPeak,
+		Requests: s.last.requests - s.first.requests,
+	}
+	if st.Workers > 0 {
+		st.Utilization = st.BusyMean / float64(st.Workers)
+	}
+	if s.last.queueWait != nil {
+		wait := ms(*s.last.queueWait)
+		st.QueueWaitMS = &wait
+	}
+	return st
+}
+
+// adminSampler samples the default pool from the admin API's /status.
+func adminSampler(target statusTarget, timeout time.Duration) poolSampler {
+	cfg := config.Default()
+	return func() (poolSample, error) {
+		report, err := fetchStatus(target, cfg, timeout)
+		if err != nil {
+			return poolSample{}, err
+		}
+		for _, p := range report.Pools {
+			if p.Name == "default" {
+				return poolSample{workers: p.Workers, busy: p.Busy, requests: p.Requests}, nil
+			}
+		}
+		return poolSample{}, fmt.Errorf("status has no default pool")
+	}
+}
+
+// metricsURL is where bench reads the server's metrics: metrics.path on
+// target, or else a TCP metrics.address on this host; "" when neither.
+func metricsURL(cfg *config.Config, target string) string {
+	m := cfg.Metrics
+	switch {
+	case !m.Enabled:
+		return ""
+	case m.Path != "":
+		return target + m.Path
+	case m.Address != "" && !strings.HasPrefix(m.Address, config.MetricsUnixPrefix):
+		return "http://" + dialAddress(m.Address) + server.MetricsListenerPath
+	}
+	return ""
+}
+
+// metricsCounter returns a function reading the sum of a counter's series
+// from a Prometheus text endpoint.
+func metricsCounter(url, token, name string, timeout time.Duration) func() (int64, error) {
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	return func() (int64, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return 0, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+		}
+		var sum float64
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			rest, ok := strings.CutPrefix(line, name)
+			if !ok || rest == "" || (rest[0] != ' ' && rest[0] != '{') {
+				continue
+			}
+			v, err := strconv.ParseFloat(line[strings.LastIndexByte(line, ' ')+1:], 64)
+			if err == nil {
+				sum += v
+			}
+		}
+		return int64(sum), sc.Err()
+	}
+}
+
+// inProcess is a maboo server started by bench --in-process.
+type inProcess struct {
+	target string
+	pool   *mockPool
+	srv    *server.Server
+	root   string // temporary app.root, if one was made
+}
+
+// startInProcess serves cfg on a free loopback port, with the PHP engine
+// replaced by a mock that takes latency per request and answers with
+// bodySize bytes. The request still goes through maboo's middleware and
+// router, so the run measures maboo's own overhead.
+func startInProcess(cfg *config.Config, latency time.Duration, bodySize int) (*inProcess, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg.Server.Address = addr
+	cfg.Server.TLS = config.TLSConfig{}
+	cfg.Server.HTTP3 = false
+	cfg.Server.ReusePort = false
+	cfg.Admin.Enabled = false
+	cfg.WebSocket.Enabled = false
+
+	// The mock never runs the entry script, but the router answers 503
+	// without one; an empty one stands in when the app has none.
+	var root string
+	if _, err := phpengine.CheckEntryPoint(cfg.App.Root, cfg.App.Entry); err != nil {
+		if root, err = os.MkdirTemp("", "maboo-bench-"); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(root, "index.php"), nil, 0o644); err != nil {
+			os.RemoveAll(root)
+			return nil, err
+		}
+		cfg.App.Root, cfg.App.Entry = root, "index.php"
+	}
+
+	pool := newMockPool(max(cfg.Pool.MaxWorkers, 1), latency, bodySize)
+	srv := server.New(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Start() }()
+	select {
+	case <-srv.Listening():
+	case err := <-errc:
+		if root != "" {
+			os.RemoveAll(root)
+		}
+		return nil, err
+	}
+	return &inProcess{target: "http://" + addr, pool: pool, srv: srv, root: root}, nil
+}
+
+func (p *inProcess) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p.srv.Stop(ctx)
+	if p.root != "" {
+		os.RemoveAll(p.root)
+	}
+}
+
+// mockPool stands in for the PHP workers: it serves at most workers
+// requests at once, each taking latency, and measures how long requests
+// wait for a free worker.
+type mockPool struct {
+	slots     chan struct{}
+	latency   time.Duration
+	body      []byte
+	busy      atomic.Int32
+	requests  atomic.Int64
+	waitNanos atomic.Int64
+}
+
+func newMockPool(workers int, latency time.Duration, bodySize int) *mockPool {
+	return &mockPool{
+		slots:   make(chan struct{}, workers),
+		latency: latency,
+		body:    bytes.Repeat([]byte("x"), bodySize),
+	}
+}
+
+func (p *mockPool) Start() error { return nil }
+func (p *mockPool) Stop() error  { return nil }
+func (p *mockPool) Mode() string { return "worker" }
+
+func (p *mockPool) SetConfig(*config.Config) error { return nil }
+func (p *mockPool) Reload() error                  { return nil }
+func (p *mockPool) Invalidate([]string) error      { return nil }
+
+func (p *mockPool) Exec(*phpengine.Context, string) (*phpengine.Response, error) {
+	start := time.Now()
+	p.slots <- struct{}{}
+	p.waitNanos.Add(int64(time.Since(start)))
+	p.busy.Add(1)
+	time.Sleep(p.latency)
+	p.busy.Add(-1)
+	<-p.slots
+	p.requests.Add(1)
+	return &phpengine.Response{
+		Status:  http.StatusOK,
+		Headers: map[string]string{"Content-Type": "text/html; charset=UTF-8"},
+		Body:    p.body,
+	}, nil
+}
+
+func (p *mockPool) Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	return phpengine.Invoke(ctx, p, "", r)
+}
+
+func (p *mockPool) Stats() worker.StatsGetter {
+	return mockStats{workers: cap(p.slots), busy: int(p.busy.Load()), requests: p.requests.Load()}
+}
+
+func (p *mockPool) sample() (poolSample, error) {
+	s := poolSample{workers: cap(p.slots), busy: int(p.busy.Load()), requests: p.requests.Load()}
+	if s.requests > 0 {
+		wait := time.Duration(p.waitNanos.Load() / s.requests)
+		s.queueWait = &wait
+	}
+	return s, nil
+}
+
+type mockStats struct {
+	workers, busy int
+	requests      int64
+}
+
+func (s mockStats) TotalWorkers() int    { return s.workers }
+func (s mockStats) BusyWorkers() int     { return s.busy }
+func (s mockStats) IdleWorkers() int     { return s.workers - s.busy }
+func (s mockStats) TotalRequests() int64 { return s.requests }
+
+func printBench(out io.Writer, r *benchReport) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	target := r.Target
+	if r.InProcess {
+		target += " (in-process, mock engine)"
+	}
+	fmt.Fprintf(tw, "target\t%s\n", target)
+	fmt.Fprintf(tw, "load\t%d connections for %s\n", r.Concurrency, (time.Duration(r.DurationSeconds * float64(time.Second))).Round(time.Millisecond))
+	fmt.Fprintf(tw, "requests\t%d (%.1f/s), %d bytes received\n", r.Requests, r.RPS, r.BytesReceived)
+	fmt.Fprintf(tw, "errors\t%.2f%%\n", r.ErrorRate*100)
+	tw.Flush()
+
+	fmt.Fprintln(out)
+	tw = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LATENCY\tMIN\tMEAN\tP50\tP90\tP99\tMAX")
+	l := r.Latency
+	fmt.Fprintf(tw, "ms\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\n", l.Min, l.Mean, l.P50, l.P90, l.P99, l.Max)
+	tw.Flush()
+
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(out)
+		tw = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ERROR\tCOUNT")
+		classes := make([]string, 0, len(r.Errors))
+		for class := range r.Errors {
+			classes = append(classes, class)
+		}
+		slices.Sort(classes)
+		for _, class := range classes {
+			fmt.Fprintf(tw, "%s\t%d\n", class, r.Errors[class])
+		}
+		tw.Flush()
+	}
+
+	if p := r.Pool; p != nil {
+		fmt.Fprintln(out)
+		tw = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		if p.Samples > 0 {
+			fmt.Fprintf(tw, "pool workers\t%d\n", p.Workers)
+			fmt.Fprintf(tw, "pool busy\tmean %.1f, peak %d (%.0f%% utilization, %d samples)\n", p.BusyMean, p.BusyPeak, p.Utilization*100, p.Samples)
+			fmt.Fprintf(tw, "pool requests\t%d\n", p.Requests)
+		}
+		if p.QueueWaitMS != nil {
+			fmt.Fprintf(tw, "queue wait\t%.2fms mean\n", *p.QueueWaitMS)
+		}
+		if p.Recycles != nil {
+			fmt.Fprintf(tw, "recycles\t%d\n", *p.Recycles)
+		}
+		tw.Flush()
+	}
+
+	for _, f := range r.Failures {
+		fmt.Fprintf(out, "\nFAIL: %s", f)
+	}
+	if len(r.Failures) > 0 {
+		fmt.Fprintln(out)
+	}
+}
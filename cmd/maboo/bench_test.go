@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/server"
+)
+
+func TestBenchReportsErrorsAndPoolStats(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "hello") })
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) })
+	var requests, scrapes atomic.Int64
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(server.StatusReport{Status: "ready", Pools: []server.PoolStatus{
+			{Name: "default", Workers: 4, Busy: 2, Requests: requests.Add(10)},
+		}})
+	})
+	// Every scrape sees one more crash recycle.
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "# TYPE maboo_worker_recycled_total counter\n"+
+			"maboo_worker_recycled_total{cause=\"crash\"} %d\nmaboo_worker_recycled_total{cause=\"max_jobs\"} 3\n"+
+			"maboo_worker_recycled_total_other 100\n", scrapes.Add(1))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var out, errOut bytes.Buffer
+	code := runBench([]string{"--config", "missing.yaml", "--url", srv.URL, "--admin", srv.Listener.Addr().String(),
+		"--path", "/ok", "--path", "/fail", "-c", "2", "--duration", "300ms", "--sample-interval", "50ms", "--json"}, &out, &errOut)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut.String())
+	}
+	var r benchReport
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if r.Requests == 0 || r.Errors["status_502"] == 0 || r.Errors["status_502"] > r.Requests/2+2 {
+		t.Errorf("%d requests, errors %v: want about half to fail", r.Requests, r.Errors)
+	}
+	if r.Latency.P99 < r.Latency.P50 || r.Latency.Max < r.Latency.P99 {
+		t.Errorf("latency percentiles out of order: %+v", r.Latency)
+	}
+	if p := r.Pool; p == nil || p.Samples < 2 || p.Workers != 4 || p.Utilization != 0.5 || p.Requests <= 0 {
+		t.Errorf("pool stats = %+v", r.Pool)
+	}
+	if p := r.Pool; p == nil || p.Recycles == nil || *p.Recycles != 1 {
+		t.Errorf("recycles not read from the metrics endpoint: %+v", r.Pool)
+	}
+	if recycled, err := metricsCounter(srv.URL+"/metrics", "", "maboo_worker_recycled_total", time.Second)(); err != nil || recycled != 6 {
+		t.Errorf("recycled = %d, %v; want 6", recycled, err)
+	}
+}
+
+func TestBenchThresholds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	var out, errOut bytes.Buffer
+	code := runBench([]string{"--config", "missing.yaml", "--url", srv.URL, "-c", "1", "--duration", "100ms",
+		"--max-p99", "1ms", "--max-error-rate", "0.01", "--min-rps", "1000000"}, &out, &errOut)
+	if code != exitThreshold {
+		t.Fatalf("exit code %d, want %d: %s", code, exitThreshold, errOut.String())
+	}
+	for _, want := range []string{"status_500", "FAIL: p99 latency", "FAIL: error rate 1.0000 is above 0.01", "is below 1e+06"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestBenchInProcess(t *testing.T) {
+	var out, errOut bytes.Buffer
+	code := runBench([]string{"--config", "missing.yaml", "--in-process", "--mock-latency", "1ms", "--mock-body", "100",
+		"-c", "4", "--duration", "300ms", "--body-size", "64", "--json", "--max-error-rate", "0"}, &out, &errOut)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s\n%s", code, errOut.String(), out.String())
+	}
+	var r benchReport
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if !r.InProcess || r.Requests == 0 || r.BytesReceived != r.Requests*100 {
+		t.Errorf("%d requests, %d bytes", r.Requests, r.BytesReceived)
+	}
+	if p := r.Pool; p == nil || p.QueueWaitMS == nil || p.Requests < r.Requests {
+		t.Errorf("pool stats = %+v", r.Pool)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// finding is one problem reported by maboo check.
+type finding struct {
+	Key     string `json:"key,omitempty"`  // config key the finding is about
+	Line    int    `json:"line,omitempty"` // line in the config file, when known
+	Message string `json:"message"`
+}
+
+func (f finding) String() string {
+	switch {
+	case f.Key != "" && f.Line > 0:
+		return fmt.Sprintf("%s (line %d): %s", f.Key, f.Line, f.Message)
+	case f.Key != "":
+		return fmt.Sprintf("%s: %s", f.Key, f.Message)
+	}
+	return f.Message
+}
+
+// checkReport collects the findings for one config file.
+type checkReport struct {
+	Config   string    `json:"config"`
+	Errors   []finding `json:"errors"`
+	Warnings []finding `json:"warnings"`
+}
+
+func (r *checkReport) errorf(key, format string, args ...any) {
+	r.Errors = append(r.Errors, finding{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *checkReport) warnf(key, format string, args ...any) {
+	r.Warnings = append(r.Warnings, finding{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// runCheck validates a config file and the environment it refers to. It
+// reports whether any errors were found; warnings alone do not fail.
+func runCheck(args []string, out io.Writer) (failed bool, err error) {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	cfgPath := fs.String("config", "maboo.yaml", "config file to check")
+	asJSON := fs.Bool("json", false, "print findings as JSON")
+	nonStrict := fs.Bool("no-strict", false, "treat unknown keys as warnings")
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+	if fs.NArg() > 0 {
+		*cfgPath = fs.Arg(0)
+	}
+
+	report := checkConfig(*cfgPath, config.Options{NonStrict: *nonStrict})
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return len(report.Errors) > 0, enc.Encode(report)
+	}
+	printReport(out, report)
+	return len(report.Errors) > 0, nil
+}
+
+func printReport(out io.Writer, r *checkReport) {
+	for _, group := range []struct {
+		title    string
+		findings []finding
+	}{{"Errors", r.Errors}, {"Warnings", r.Warnings}} {
+		if len(group.findings) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "%s:\n", group.title)
+		for _, f := range group.findings {
+			fmt.Fprintf(out, "  %s\n", f)
+		}
+	}
+	if len(r.Errors) == 0 && len(r.Warnings) == 0 {
+		fmt.Fprintf(out, "%s: OK\n", r.Config)
+		return
+	}
+	fmt.Fprintf(out, "%s: %d error(s), %d warning(s)\n", r.Config, len(r.Errors), len(r.Warnings))
+}
+
+// checkConfig loads path and, if it is valid, checks the files, addresses
+// and PHP setup it references. Relative paths resolve against the working
+// directory, as they do for serve.
+func checkConfig(path string, opts config.Options) *checkReport {
+	r := &checkReport{Config: path, Errors: []finding{}, Warnings: []finding{}}
+
+	cfg, err := config.LoadWithOptions(path, opts)
+	if err != nil {
+		for _, e := range splitErrors(err) {
+			r.Errors = append(r.Errors, finding{Message: e.Error()})
+		}
+		return r
+	}
+	for _, w := range cfg.Warnings() {
+		r.Warnings = append(r.Warnings, finding{Key: w.Path, Line: w.Line, Message: w.Message})
+	}
+
+	checkAddress(r, "server.address", cfg.Server.Address)
+	checkPaths(r, cfg)
+	checkPHP(r, cfg)
+	checkACMEDomains(r, cfg.Server.TLS.ACME.Domains)
+	return r
+}
+
+// splitErrors flattens an errors.Join tree so each problem is reported on
+// its own.
+func splitErrors(err error) []error {
+	var multi interface{ Unwrap() []error }
+	if !errors.As(err, &multi) {
+		return []error{err}
+	}
+	var out []error
+	for _, e := range multi.Unwrap() {
+		out = append(out, splitErrors(e)...)
+	}
+	return out
+}
+
+func checkAddress(r *checkReport, key, addr string) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		r.errorf(key, "%v", err)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		r.errorf(key, "invalid port %q", port)
+	}
+}
+
+func checkPaths(r *checkReport, cfg *config.Config) {
+	rootOK := requirePath(r, "app.root", cfg.App.Root, true)
+	if rootOK {
+		if _, err := phpengine.CheckEntryPoint(cfg.App.Root, cfg.App.Entry); err != nil {
+			r.errorf("app.entry", "%v", err)
+		}
+	}
+
+	tls := cfg.Server.TLS
+	if tls.Cert != "" || tls.Key != "" {
+		requirePath(r, "server.tls.cert", tls.Cert, false)
+		requirePath(r, "server.tls.key", tls.Key, false)
+	}
+
+	if cfg.Static.Root != "" {
+		if fi, err := os.Stat(cfg.Static.Root); err != nil || !fi.IsDir() {
+			r.warnf("static.root", "directory %s not found, static files will not be served", cfg.Static.Root)
+		}
+	}
+
+	if cfg.PHP.Worker != "" {
+		requirePath(r, "php.worker", cfg.PHP.Worker, false)
+	}
+	for i, w := range cfg.Workers {
+		requirePath(r, fmt.Sprintf("workers[%d].script", i), w.Script, false)
+	}
+	for i, j := range cfg.Schedule.Jobs {
+		script := j.Script
+		if !filepath.IsAbs(script) {
+			script = filepath.Join(cfg.App.Root, script)
+		}
+		requirePath(r, fmt.Sprintf("schedule.jobs[%d].script", i), script, false)
+	}
+	for i, j := range cfg.Jobs {
+		script := j.Script
+		if !filepath.IsAbs(script) {
+			script = filepath.Join(cfg.App.Root, script)
+		}
+		requirePath(r, fmt.Sprintf("jobs[%d].script", i), script, false)
+	}
+}
+
+// requirePath records an error unless path exists and is a directory (dir)
+// or a regular file (!dir).
+func requirePath(r *checkReport, key, path string, dir bool) bool {
+	if path == "" {
+		r.errorf(key, "must be set")
+		return false
+	}
+	fi, err := os.Stat(path)
+	switch {
+	case err != nil:
+		r.errorf(key, "%v", err)
+		return false
+	case dir && !fi.IsDir():
+		r.errorf(key, "%s is not a directory", path)
+		return false
+	case !dir && fi.IsDir():
+		r.errorf(key, "%s is a directory", path)
+		return false
+	}
+	return true
+}
+
+// bundledSummary lists the PHP versions this build can run, for messages.
+func bundledSummary() string {
+	if versions := phpengine.BundledVersions(); len(versions) > 0 {
+		return strings.Join(versions, ", ")
+	}
+	return "none, no libphp found in " + strings.Join(phpengine.LibDirs(), ", ")
+}
+
+func checkPHP(r *checkReport, cfg *config.Config) {
+	if cfg.PHP.Binary != "" {
+		if _, err := exec.LookPath(cfg.PHP.Binary); err != nil {
+			r.errorf("php.binary", "%v", err)
+		}
+	} else {
+		version := phpengine.SelectVersion(cfg.App.Root, cfg.PHP.Version)
+		if !phpengine.IsBundled(version) {
+			r.errorf("php.version", "PHP %s is not bundled in this build (available: %s)",
+				version, bundledSummary())
+		}
+	}
+
+	names := iniExtensions(cfg.PHP.INI)
+	if len(names) == 0 {
+		return
+	}
+	dir := cfg.PHP.INI["extension_dir"]
+	if dir == "" {
+		r.warnf("php.ini.extension_dir", "not set, cannot verify extensions %s", strings.Join(names, ", "))
+		return
+	}
+	for _, name := range names {
+		if !extensionExists(dir, name) {
+			r.errorf("php.ini.extension", "%s not found in %s", name, dir)
+		}
+	}
+}
+
+// iniExtensions lists the extensions named by the extension and
+// zend_extension ini settings (comma-separated).
+func iniExtensions(ini map[string]string) []string {
+	var names []string
+	for _, key := range []string{"extension", "zend_extension"} {
+		for _, name := range strings.Split(ini[key], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// extensionExists resolves an extension the way PHP does: an absolute
+// path, a file name in dir, or a bare name with the platform suffix.
+func extensionExists(dir, name string) bool {
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = []string{filepath.Join(dir, name), filepath.Join(dir, name+".so"), filepath.Join(dir, "php_"+name+".dll")}
+	}
+	for _, c := range candidates {
+		if fi, err := os.Stat(c); err == nil && !fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkACMEDomains verifies each domain is a hostname Let's Encrypt can
+// issue an HTTP-01 certificate for.
+func checkACMEDomains(r *checkReport, domains []string) {
+	for i, d := range domains {
+		key := fmt.Sprintf("server.tls.acme.domains[%d]", i)
+		if err := validateDomain(d); err != nil {
+			r.errorf(key, "%q: %v", d, err)
+		}
+	}
+}
+
+func validateDomain(d string) error {
+	if net.ParseIP(d) != nil {
+		return errors.New("IP addresses are not supported")
+	}
+	if strings.HasPrefix(d, "*.") {
+		return errors.New("wildcard domains need a DNS-01 challenge, which autocert does not support")
+	}
+	if len(d) == 0 || len(d) > 253 {
+		return errors.New("must be 1 to 253 characters")
+	}
+	labels := strings.Split(d, ".")
+	if len(labels) < 2 {
+		return errors.New("must be a fully qualified domain name")
+	}
+	for _, l := range labels {
+		if len(l) == 0 || len(l) > 63 {
+			return errors.New("labels must be 1 to 63 characters")
+		}
+		if l[0] == '-' || l[len(l)-1] == '-' {
+			return fmt.Errorf("label %q must not start or end with a hyphen", l)
+		}
+		for _, c := range l {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("label %q contains invalid character %q", l, c)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+// writeProject creates files under dir; a trailing slash makes a directory.
+func writeProject(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckValidProject(t *testing.T) {
+	dir := t.TempDir()
+	writeProject(t, dir, map[string]string{
+		"public/index.php": "<?php",
+		"artisan":          "<?php",
+		"ext/redis.so":     "",
+		"maboo.yaml": `app:
+  root: ` + dir + `
+static:
+  root: ` + filepath.Join(dir, "public") + `
+php:
+  ini:
+    extension_dir: ` + filepath.Join(dir, "ext") + `
+    extension: redis
+server:
+  tls:
+    acme:
+      domains: [example.com, www.example.com]
+schedule:
+  jobs:
+    - name: laravel
+      cron: "* * * * *"
+      script: artisan
+      args: [schedule:run]
+`,
+	})
+
+	r := checkConfig(filepath.Join(dir, "maboo.yaml"), config.Options{})
+	if len(r.Errors) != 0 || len(r.Warnings) != 0 {
+		t.Errorf("errors = %v, warnings = %v", r.Errors, r.Warnings)
+	}
+}
+
+func TestCheckReportsEveryProblem(t *testing.T) {
+	dir := t.TempDir()
+	writeProject(t, dir, map[string]string{
+		"maboo.yaml": `server:
+  address: "0.0.0.0:http-alt"
+  tls:
+    cert: ` + filepath.Join(dir, "missing.crt") + `
+    key: ` + filepath.Join(dir, "missing.key") + `
+    acme:
+      domains: ["*.example.com", "bad_host.example.com", localhost, 10.0.0.1]
+app:
+  root: ` + dir + `
+static:
+  root: ` + filepath.Join(dir, "public") + `
+php:
+  version: "7.3"
+  ini:
+    extension: redis
+schedule:
+  jobs:
+    - name: laravel
+      cron: "* * * * *"
+      script: artisan
+`,
+	})
+
+	var out bytes.Buffer
+	failed, err := runCheck([]string{"--config", filepath.Join(dir, "maboo.yaml")}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// php.version 7.3 fails config validation, so only that is reported.
+	if !failed || !strings.Contains(out.String(), "php.version must be") {
+		t.Fatalf("failed = %v, output:\n%s", failed, out.String())
+	}
+
+	writeProject(t, dir, map[string]string{"maboo.yaml": strings.Replace(readFile(t, filepath.Join(dir, "maboo.yaml")), `version: "7.3"`, `version: "8.3"`, 1)})
+	r := checkConfig(filepath.Join(dir, "maboo.yaml"), config.Options{})
+	for _, key := range []string{
+		"server.address",
+		"server.tls.cert",
+		"server.tls.key",
+		"app.entry",
+		"server.tls.acme.domains[0]",
+		"server.tls.acme.domains[1]",
+		"server.tls.acme.domains[2]",
+		"server.tls.acme.domains[3]",
+		"schedule.jobs[0].script",
+	} {
+		if !hasFinding(r.Errors, key) {
+			t.Errorf("no error for %s in %v", key, r.Errors)
+		}
+	}
+	for _, key := range []string{"static.root", "php.ini.extension_dir"} {
+		if !hasFinding(r.Warnings, key) {
+			t.Errorf("no warning for %s in %v", key, r.Warnings)
+		}
+	}
+}
+
+func TestCheckJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeProject(t, dir, map[string]string{
+		"index.php":  "<?php",
+		"maboo.yaml": "strict: false\napp:\n  root: " + dir + "\nstatic:\n  root: " + dir + "\npool:\n  max_worker: 8\n",
+	})
+
+	var out bytes.Buffer
+	failed, err := runCheck([]string{"--json", "--config", filepath.Join(dir, "maboo.yaml")}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed {
+		t.Errorf("warnings alone must not fail the check:\n%s", out.String())
+	}
+	var r checkReport
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	if len(r.Warnings) != 1 || r.Warnings[0].Key != "pool.max_worker" || r.Warnings[0].Line != 7 {
+		t.Errorf("warnings = %+v", r.Warnings)
+	}
+}
+
+func hasFinding(findings []finding, key string) bool {
+	for _, f := range findings {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
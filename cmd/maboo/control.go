@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/pidfile"
+)
+
+// Exit codes for reload and stop.
+const (
+	exitFailed     = 1 // signal could not be delivered, or stop timed out
+	exitUsage      = 2
+	exitNotRunning = 3 // no pid file, or its process is gone
+)
+
+// acquirePIDFile locks the configured pid file. Another live instance is
+// fatal; a path this user cannot write (the /var/run default when not
+// root) only disables the pid file.
+func acquirePIDFile(path string, logger *slog.Logger) (*pidfile.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+	pid, err := pidfile.Acquire(path)
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("pid file disabled, maboo reload/stop will not find this instance", "path", path, "error", err)
+		return nil, nil
+	}
+	return pid, err
+}
+
+// takeOverPIDFile acquires the pid file after a binary upgrade, waiting
+// for the previous process to hand it over.
+func takeOverPIDFile(path string, logger *slog.Logger) *pidfile.File {
+	if path == "" {
+		return nil
+	}
+	pid, err := pidfile.AcquireWait(path)
+	if err != nil {
+		logger.Warn("pid file not taken over", "path", path, "error", err)
+		return nil
+	}
+	return pid
+}
+
+func releasePIDFile(pid *pidfile.File, logger *slog.Logger) {
+	if pid == nil {
+		return
+	}
+	if err := pid.Release(); err != nil {
+		logger.Warn("removing pid file", "path", pid.Path(), "error", err)
+	}
+}
+
+// controlOptions are the flags shared by reload and stop.
+type controlOptions struct {
+	pidPath string
+	timeout time.Duration // stop only
+}
+
+// parseControlArgs resolves the pid file: --pidfile, else server.pid_file
+// from the config, else the default location.
+func parseControlArgs(name string, args []string, out io.Writer) (controlOptions, error) {
+	var opts controlOptions
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.StringVar(&opts.pidPath, "pidfile", "", "pid file of the running server")
+	cfgPath := fs.String("config", "maboo.yaml", "config file naming server.pid_file")
+	if name == "stop" {
+		fs.DurationVar(&opts.timeout, "timeout", 30*time.Second, "how long to wait for the server to exit")
+	}
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if opts.pidPath != "" {
+		return opts, nil
+	}
+
+	opts.pidPath = config.DefaultPIDFile
+	if _, err := os.Stat(*cfgPath); err == nil {
+		cfg, err := config.LoadWithOptions(*cfgPath, config.Options{NonStrict: true})
+		if err != nil {
+			return opts, fmt.Errorf("reading pid_file from %s: %w", *cfgPath, err)
+		}
+		if cfg.Server.PIDFile == "" {
+			return opts, fmt.Errorf("%s sets no server.pid_file; pass --pidfile", *cfgPath)
+		}
+		opts.pidPath = cfg.Server.PIDFile
+	}
+	return opts, nil
+}
+
+// runReload asks the running server for a graceful worker reload.
+func runReload(args []string, out io.Writer) int {
+	opts, err := parseControlArgs("reload", args, out)
+	if err != nil {
+		fmt.Fprintf(out, "Error: %v\n", err)
+		return exitUsage
+	}
+	pid, err := pidfile.Read(opts.pidPath)
+	if err != nil {
+		return reportControlError(out, err)
+	}
+	if err := syscall.Kill(pid, syscall.SIGUSR1); err != nil {
+		return reportControlError(out, fmt.Errorf("signalling pid %d: %w", pid, err))
+	}
+	fmt.Fprintf(out, "Sent SIGUSR1 to maboo (pid %d), workers are reloading\n", pid)
+	return 0
+}
+
+// runStop sends SIGTERM and waits for the server to exit. The server
+// holds the pid file lock until it releases the file on its way out, so
+// once the lock is gone the process itself is waited for.
+func runStop(args []string, out io.Writer) int {
+	opts, err := parseControlArgs("stop", args, out)
+	if err != nil {
+		fmt.Fprintf(out, "Error: %v\n", err)
+		return exitUsage
+	}
+	pid, err := pidfile.Read(opts.pidPath)
+	if err != nil {
+		return reportControlError(out, err)
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return reportControlError(out, fmt.Errorf("signalling pid %d: %w", pid, err))
+	}
+
+	deadline := time.Now().Add(opts.timeout)
+	for pidfile.Locked(opts.pidPath) || processAlive(pid) {
+		if time.Now().After(deadline) {
+			fmt.Fprintf(out, "Error: maboo (pid %d) did not exit within %s\n", pid, opts.timeout)
+			return exitFailed
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	fmt.Fprintf(out, "maboo (pid %d) stopped\n", pid)
+	return 0
+}
+
+// processAlive reports whether a process with pid exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func reportControlError(out io.Writer, err error) int {
+	fmt.Fprintf(out, "Error: %v\n", err)
+	if errors.Is(err, pidfile.ErrNotRunning) || errors.Is(err, syscall.ESRCH) {
+		return exitNotRunning
+	}
+	return exitFailed
+}
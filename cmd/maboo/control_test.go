@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/pidfile"
+)
+
+func TestReloadSignalsRunningInstance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maboo.pid")
+	p, err := pidfile.Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+
+	var out bytes.Buffer
+	if code := runReload([]string{"--pidfile", path}, &out); code != 0 {
+		t.Fatalf("exit code %d: %s", code, out.String())
+	}
+	select {
+	case <-sig:
+	case <-time.After(time.Second):
+		t.Fatal("SIGUSR1 not delivered")
+	}
+}
+
+func TestStopWaitsForExit(t *testing.T) {
+	// A server that releases its pid file on SIGTERM, then takes a while
+	// to exit.
+	cmd := exec.Command("sh", "-c", `trap 'sleep 0.5; exit 0' TERM; while :; do sleep 0.05; done`)
+	if err := cmd.Start(); err != nil {
+		t.Skip("no shell:", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	time.Sleep(100 * time.Millisecond) // for the trap to be set
+
+	path := filepath.Join(t.TempDir(), "maboo.pid")
+	pid, err := pidfile.Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		pid.Release()
+	}()
+
+	var out bytes.Buffer
+	if code := runStop([]string{"--pidfile", path, "--timeout", "5s"}, &out); code != 0 {
+		t.Fatalf("exit code %d: %s", code, out.String())
+	}
+	select {
+	case <-exited:
+	default:
+		t.Errorf("reported %q before the process exited", out.String())
+	}
+}
+
+func TestControlNotRunning(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "stale.pid")
+	if err := os.WriteFile(stale, []byte("999999\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		run  func([]string, *bytes.Buffer) int
+		path string
+		want string
+	}{
+		{"reload missing", func(a []string, b *bytes.Buffer) int { return runReload(a, b) }, filepath.Join(dir, "none.pid"), "no pid file"},
+		{"stop missing", func(a []string, b *bytes.Buffer) int { return runStop(a, b) }, filepath.Join(dir, "none.pid"), "no pid file"},
+		{"stop stale", func(a []string, b *bytes.Buffer) int { return runStop(a, b) }, stale, "stale pid file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if code := tt.run([]string{"--pidfile", tt.path}, &out); code != exitNotRunning {
+				t.Errorf("exit code = %d, want %d", code, exitNotRunning)
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("output %q does not mention %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestControlReadsPIDFileFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "maboo.yaml")
+	pidPath := filepath.Join(dir, "from-config.pid")
+	if err := os.WriteFile(cfgPath, []byte("server:\n  pid_file: "+pidPath+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseControlArgs("stop", []string{"--config", cfgPath, "--timeout", "5s"}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.pidPath != pidPath || opts.timeout != 5*time.Second {
+		t.Errorf("opts = %+v", opts)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/buildinfo"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// devOverrides is the preset behind serve --dev. Every entry is an
+// ordinary config key, so each behavior can also be set on its own in
+// maboo.yaml.
+func devOverrides(cfg *config.Config) map[string]string {
+	o := map[string]string{
+		"watch.enabled":          "true",
+		"logging.level":          "debug",
+		"logging.format":         "console",
+		"static.cache_control":   "no-cache",
+		"compression.enabled":    "false",
+		"app.debug":              "true",
+		"php.ini.display_errors": "1",
+	}
+	if len(cfg.Watch.Dirs) == 0 {
+		o["watch.dirs"] = "[" + strconv.Quote(cfg.App.Root) + "]"
+	}
+	// Real certificates and ACME need a public hostname; keep HTTPS but
+	// serve it with a self-signed certificate.
+	tls := cfg.Server.TLS
+	if tls.Cert != "" || tls.Key != "" || tls.ACME.Email != "" {
+		o["server.tls.auto"] = "true"
+		o["server.tls.cert"] = `""`
+		o["server.tls.key"] = `""`
+		o["server.tls.acme.email"] = `""`
+		o["server.http_redirect"] = "false"
+	}
+	return o
+}
+
+// printDevBanner summarizes what serve --dev is running.
+func printDevBanner(out io.Writer, cfg *config.Config) {
+	root := cfg.App.Root
+	scheme := "http"
+	if cfg.Server.TLS.Auto {
+		scheme = "https"
+	}
+	fmt.Fprintf(out, "\n  maboo %s (development mode)\n\n", buildinfo.Get().Version)
+	fmt.Fprintf(out, "  Framework:  %s\n", phpengine.DetectFramework(root))
+	fmt.Fprintf(out, "  Entry:      %s\n", phpengine.DetectEntryPoint(root, cfg.App.Entry))
+	fmt.Fprintf(out, "  PHP:        %s\n", phpengine.SelectVersion(root, cfg.PHP.Version))
+	fmt.Fprintf(out, "  Watching:   %s\n", strings.Join(cfg.Watch.Dirs, ", "))
+	fmt.Fprintf(out, "  Local:      %s://%s/\n", scheme, browserAddress(cfg.Server.Address))
+	if cfg.Admin.Enabled {
+		fmt.Fprintf(out, "  Admin:      http://%s/status\n", browserAddress(cfg.Admin.Address))
+	}
+	fmt.Fprintln(out)
+}
+
+// browserAddress is a listen address as a developer would type it.
+func browserAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" || host == "127.0.0.1" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
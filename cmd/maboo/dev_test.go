@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestDevOverrides(t *testing.T) {
+	cfg := config.Default()
+	cfg.App.Root = "/srv/app"
+	cfg.Server.TLS.ACME.Email = "ops@example.com"
+	cfg.Server.TLS.ACME.Domains = []string{"example.com"}
+
+	if err := cfg.ApplyOverrides(devOverrides(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("dev preset produced an invalid config: %v", err)
+	}
+
+	if !cfg.Watch.Enabled || len(cfg.Watch.Dirs) != 1 || cfg.Watch.Dirs[0] != "/srv/app" {
+		t.Errorf("watch = %+v, want app.root watched", cfg.Watch)
+	}
+	if cfg.Logging.Level != "debug" || cfg.Logging.Format != "console" {
+		t.Errorf("logging = %s/%s", cfg.Logging.Level, cfg.Logging.Format)
+	}
+	if cfg.Compression.Enabled || !cfg.App.Debug || cfg.Static.CacheControl != "no-cache" {
+		t.Errorf("compression %v, debug %v, cache_control %q", cfg.Compression.Enabled, cfg.App.Debug, cfg.Static.CacheControl)
+	}
+	if !cfg.Server.TLS.Auto || cfg.Server.TLS.ACME.Email != "" {
+		t.Errorf("tls = %+v, want self-signed instead of ACME", cfg.Server.TLS)
+	}
+}
+
+func TestDevOverridesKeepConfiguredWatchDirs(t *testing.T) {
+	cfg := config.Default()
+	cfg.Watch.Dirs = []string{"src"}
+	o := devOverrides(cfg)
+	if _, ok := o["watch.dirs"]; ok {
+		t.Error("preset replaced watch.dirs set in the config")
+	}
+	if _, ok := o["server.tls.auto"]; ok {
+		t.Error("preset enabled TLS for a plain HTTP config")
+	}
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/cgroup"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// Diagnostic results.
+const (
+	diagPass = "pass"
+	diagWarn = "warn"
+	diagFail = "fail"
+)
+
+// diagnostic is the outcome of one doctor check.
+type diagnostic struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"` // pass, warn, fail
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"` // how to fix a warn or fail
+}
+
+// doctor runs the environment checks against one config.
+type doctor struct {
+	cfg     *config.Config
+	results []diagnostic
+	limits  cgroup.Limits // the container's, as serve detects them
+
+	// clockSkew measures local time against a trusted clock. It is a field
+	// so tests can avoid the network.
+	clockSkew func(url string) (time.Duration, error)
+
+	// eval runs PHP on the embedded engine checkEngine started, and is nil
+	// when it started none. It is a field so tests can stand in for the
+	// engine.
+	eval   func(ctx context.Context, code string) ([]byte, error)
+	engine *phpengine.Engine
+}
+
+func (d *doctor) pass(check, format string, args ...any) {
+	d.results = append(d.results, diagnostic{Check: check, Status: diagPass, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *doctor) warn(check, hint, format string, args ...any) {
+	d.results = append(d.results, diagnostic{Check: check, Status: diagWarn, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+func (d *doctor) fail(check, hint, format string, args ...any) {
+	d.results = append(d.results, diagnostic{Check: check, Status: diagFail, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+// runDoctor diagnoses the environment maboo would run in and returns the
+// exit code: 1 if any check failed, 2 for usage errors.
+func runDoctor(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	cfgPath := fs.String("config", "maboo.yaml", "config file to diagnose")
+	asJSON := fs.Bool("json", false, "print results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	d := &doctor{clockSkew: httpClockSkew, limits: cgroup.Detect()}
+	d.loadConfig(*cfgPath)
+	if d.cfg != nil {
+		d.run()
+	}
+
+	failed := false
+	for _, r := range d.results {
+		failed = failed || r.Status == diagFail
+	}
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.Encode(d.results)
+	} else {
+		printDiagnostics(out, d.results)
+	}
+	if failed {
+		return exitFailed
+	}
+	return 0
+}
+
+func (d *doctor) loadConfig(path string) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		d.cfg = config.Default()
+		d.warn("config", "run \"maboo init\" to generate one", "%s not found, checking the defaults", path)
+		return
+	}
+	cfg, err := config.LoadWithOptions(path, config.Options{NonStrict: true, Limits: &d.limits})
+	if err != nil {
+		d.fail("config", "run \"maboo check\" for every config problem", "%v", err)
+		return
+	}
+	d.cfg = cfg
+	d.pass("config", "%s loaded", path)
+}
+
+func (d *doctor) run() {
+	d.checkEngine()
+	if d.engine != nil {
+		defer d.engine.Shutdown()
+	}
+	d.checkExtensions()
+	d.checkBind()
+	d.checkPaths()
+	d.checkClock()
+	d.checkFDLimit()
+	d.checkResources()
+}
+
+func (d *doctor) checkEngine() {
+	cfg := d.cfg
+	if cfg.PHP.Binary != "" {
+		out, err := exec.Command(cfg.PHP.Binary, "-v").Output()
+		if err != nil {
+			d.fail("php", "install PHP or fix php.binary", "%s -v: %v", cfg.PHP.Binary, err)
+			return
+		}
+		first, _, _ := strings.Cut(string(out), "\n")
+		d.pass("php", "%s", first)
+		return
+	}
+
+	version := phpengine.SelectVersion(cfg.App.Root, cfg.PHP.Version)
+	engine, err := phpengine.NewEngine(version)
+	if err != nil {
+		d.fail("php", "set php.version to one of the bundled versions ("+bundledSummary()+")", "%v", err)
+		return
+	}
+	if err := engine.Startup(); err != nil {
+		d.fail("php", "check that libphp for PHP "+version+" is installed and on the library path", "starting PHP %s: %v", version, err)
+		return
+	}
+	d.engine = engine
+	d.eval = func(ctx context.Context, code string) ([]byte, error) {
+		return engine.Eval(ctx, code, doctorEvalOutput)
+	}
+	d.pass("php", "embedded PHP %s starts", version)
+}
+
+// Limits on the PHP the doctor evaluates on the engine.
+const (
+	doctorEvalTimeout = 10 * time.Second
+	doctorEvalOutput  = 1 << 20
+)
+
+// checkExtensions verifies the extensions php.ini loads: against the
+// engine itself when it can evaluate PHP, and otherwise by looking for
+// their files in extension_dir.
+func (d *doctor) checkExtensions() {
+	if d.eval != nil && d.checkEngineSees() {
+		return
+	}
+	ini := d.cfg.PHP.INI
+	names := iniExtensions(ini)
+	dir := ini["extension_dir"]
+	if dir == "" {
+		if len(names) > 0 {
+			d.warn("extensions", "set php.ini.extension_dir", "extension_dir not set, cannot verify %s", strings.Join(names, ", "))
+		}
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		d.fail("extensions", "point php.ini.extension_dir at the directory holding the .so files", "%v", err)
+		return
+	}
+	var available []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			available = append(available, e.Name())
+		}
+	}
+	sort.Strings(available)
+
+	var missing []string
+	for _, name := range names {
+		if !extensionExists(dir, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		d.fail("extensions", "install the extensions or remove them from php.ini",
+			"missing in %s: %s (available: %s)", dir, strings.Join(missing, ", "), strings.Join(available, ", "))
+		return
+	}
+	d.pass("extensions", "%d file(s) in %s, all %d required present", len(available), dir, len(names))
+}
+
+// engineReport is what engineReportCode prints.
+type engineReport struct {
+	Extensions []string           `json:"extensions"`
+	INI        map[string]*string `json:"ini"` // nil: PHP does not know the key
+}
+
+// engineReportCode is PHP printing the extensions the engine loaded and
+// its values for the php.ini keys given, as an engineReport.
+func engineReportCode(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, k := range keys {
+		quoted[i] = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(k) + "'"
+	}
+	return `$ini = [];
+foreach ([` + strings.Join(quoted, ", ") + `] as $k) {
+    $v = ini_get($k);
+    $ini[$k] = $v === false ? null : $v;
+}
+echo json_encode([
+    'extensions' => array_merge(get_loaded_extensions(), get_loaded_extensions(true)),
+    'ini' => (object) $ini,
+]);`
+}
+
+// checkEngineSees asks the engine which extensions it loaded and which
+// values it sees for php.ini, which is what the workers will run with. It
+// returns false when the engine cannot evaluate PHP, for the file checks
+// to stand in; the php.ini values are then reported as not checked.
+func (d *doctor) checkEngineSees() bool {
+	ini := d.cfg.PHP.INI
+	var keys []string
+	for k := range ini {
+		if k != "extension" && k != "zend_extension" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorEvalTimeout)
+	defer cancel()
+	out, err := d.eval(ctx, engineReportCode(keys))
+	if errors.Is(err, phpengine.ErrEvalUnavailable) {
+		if len(keys) > 0 {
+			d.warn("ini", "compare them with the output of php -i for the same php.ini",
+				"%d php.ini value(s) not checked: %v", len(keys), err)
+		}
+		return false
+	}
+	var report engineReport
+	if err == nil {
+		err = json.Unmarshal(out, &report)
+	}
+	if err != nil {
+		d.fail("extensions", "check that PHP starts with this php.ini", "asking PHP for its extensions: %v", err)
+		return true
+	}
+
+	loaded := make(map[string]bool, len(report.Extensions))
+	for _, name := range report.Extensions {
+		loaded[strings.TrimPrefix(strings.ToLower(name), "zend ")] = true
+	}
+	var missing []string
+	names := iniExtensions(ini)
+	for _, name := range names {
+		if !loaded[extensionName(name)] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		d.fail("extensions", "install the extensions, fix php.ini.extension_dir, or remove them from php.ini",
+			"not loaded by PHP: %s", strings.Join(missing, ", "))
+	} else {
+		d.pass("extensions", "PHP loaded %d extension(s), all %d required", len(report.Extensions), len(names))
+	}
+
+	var wrong []string
+	for _, k := range keys {
+		switch got := report.INI[k]; {
+		case got == nil:
+			wrong = append(wrong, fmt.Sprintf("%s unknown", k))
+		case !iniEqual(ini[k], *got):
+			wrong = append(wrong, fmt.Sprintf("%s=%q, not %q", k, *got, ini[k]))
+		}
+	}
+	switch {
+	case len(wrong) > 0:
+		d.warn("ini", "check the keys' spelling, and that no extension or php.ini file overrides them",
+			"PHP sees %s", strings.Join(wrong, ", "))
+	case len(keys) > 0:
+		d.pass("ini", "PHP sees all %d php.ini value(s)", len(keys))
+	}
+	return true
+}
+
+// extensionName is the module name PHP reports for an extension listed in
+// php.ini: "/usr/lib/php/redis.so" and "php_redis.dll" are redis.
+func extensionName(name string) string {
+	name = strings.ToLower(filepath.Base(name))
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".so"), ".dll")
+	return strings.TrimPrefix(name, "php_")
+}
+
+// iniEqual reports whether PHP's value got is the php.ini value want,
+// reading the boolean spellings PHP accepts as the same.
+func iniEqual(want, got string) bool {
+	if strings.EqualFold(want, got) {
+		return true
+	}
+	truth := func(v string) string {
+		switch strings.ToLower(v) {
+		case "1", "on", "yes", "true":
+			return "1"
+		case "", "0", "off", "no", "false", "none":
+			return "0"
+		}
+		return v
+	}
+	return truth(want) == truth(got)
+}
+
+// checkBind test-binds every address maboo would listen on.
+func (d *doctor) checkBind() {
+	cfg := d.cfg
+	type listen struct{ name, network, addr string }
+	addrs := []listen{{"server.address", "tcp", cfg.Server.Address}}
+	if cfg.Server.HTTP3 {
+		addrs = append(addrs, listen{"server.http3", "udp", cfg.Server.Address})
+	}
+	if cfg.Server.TLS.ACME.Email != "" && cfg.Server.HTTPRedirect {
+		addrs = append(addrs, listen{"server.http_redirect", "tcp", ":80"})
+	}
+	if cfg.Admin.Enabled {
+		addrs = append(addrs, listen{"admin.address", "tcp", cfg.Admin.Address})
+	}
+	if m := cfg.Metrics; m.Enabled && m.Address != "" && !strings.HasPrefix(m.Address, config.MetricsUnixPrefix) {
+		addrs = append(addrs, listen{"metrics.address", "tcp", m.Address})
+	}
+
+	for _, a := range addrs {
+		check := "bind " + a.name
+		var err error
+		if a.network == "udp" {
+			var pc net.PacketConn
+			if pc, err = net.ListenPacket(a.network, a.addr); err == nil {
+				pc.Close()
+			}
+		} else {
+			var ln net.Listener
+			if ln, err = net.Listen(a.network, a.addr); err == nil {
+				ln.Close()
+			}
+		}
+		switch {
+		case err == nil:
+			d.pass(check, "%s/%s is free", a.addr, a.network)
+		case errors.Is(err, syscall.EADDRINUSE):
+			d.fail(check, "stop the other process (maboo status shows whether it is maboo) or change the address",
+				"%s/%s is already in use", a.addr, a.network)
+		case errors.Is(err, syscall.EACCES):
+			d.fail(check, "ports below 1024 need root or: sudo setcap cap_net_bind_service=+ep $(command -v maboo)",
+				"%s/%s: permission denied", a.addr, a.network)
+		default:
+			d.fail(check, "check the address syntax and that the host IP exists", "%s/%s: %v", a.addr, a.network, err)
+		}
+	}
+}
+
+// checkPaths verifies maboo can read what it serves and write what it
+// caches and spills to disk.
+func (d *doctor) checkPaths() {
+	cfg := d.cfg
+	d.checkReadableDir("app.root", cfg.App.Root, true)
+	if cfg.Static.Root != "" {
+		d.checkReadableDir("static.root", cfg.Static.Root, false)
+	}
+	temp := cfg.Temp.Dir
+	if temp == "" {
+		temp = os.TempDir()
+	}
+	d.checkWritableDir("temp.dir", temp)
+
+	tls := cfg.Server.TLS
+	if tls.Cert != "" {
+		d.checkReadableFile("server.tls.cert", tls.Cert)
+	}
+	if tls.Key != "" && d.checkReadableFile("server.tls.key", tls.Key) {
+		if fi, err := os.Stat(tls.Key); err == nil && fi.Mode().Perm()&0o077 != 0 {
+			d.warn("server.tls.key", "chmod 600 "+tls.Key, "%s is accessible by other users (mode %s)", tls.Key, fi.Mode().Perm())
+		}
+	}
+
+	if acme := tls.ACME; acme.Email != "" {
+		dir := acme.CacheDir
+		if dir == "" {
+			dir = "/var/lib/maboo/certs"
+		}
+		d.checkWritableDir("server.tls.acme.cache_dir", dir)
+	}
+}
+
+// checkWritableDir verifies dir can be written to. maboo creates a
+// missing dir, so it checks whichever ancestor it would be created in.
+func (d *doctor) checkWritableDir(check, dir string) {
+	target := dir
+	for {
+		if _, err := os.Stat(target); err == nil || filepath.Dir(target) == target {
+			break
+		}
+		target = filepath.Dir(target)
+	}
+	if err := syscall.Access(target, 2 /* W_OK */); err != nil {
+		d.fail(check, "make "+target+" writable by the maboo user", "%s: %v", target, err)
+		return
+	}
+	d.pass(check, "%s is writable", dir)
+}
+
+func (d *doctor) checkReadableDir(check, dir string, required bool) {
+	f, err := os.Open(dir)
+	if err == nil {
+		_, err = f.Readdirnames(1)
+		f.Close()
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	switch {
+	case err == nil:
+		d.pass(check, "%s is readable", dir)
+	case !required && errors.Is(err, os.ErrNotExist):
+		d.warn(check, "create it or clear static.root", "%s does not exist", dir)
+	default:
+		d.fail(check, "check the path and that the maboo user can read it", "%v", err)
+	}
+}
+
+func (d *doctor) checkReadableFile(check, path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		d.fail(check, "check the path and that the maboo user can read it", "%v", err)
+		return false
+	}
+	f.Close()
+	d.pass(check, "%s is readable", path)
+	return true
+}
+
+// maxClockSkew is how far the local clock may drift before certificate
+// validity checks and ACME requests start failing.
+const maxClockSkew = time.Minute
+
+// checkClock compares the local clock with the ACME directory's Date
+// header. Only relevant when ACME is configured.
+func (d *doctor) checkClock() {
+	acme := d.cfg.Server.TLS.ACME
+	if acme.Email == "" {
+		return
+	}
+	url := "https://acme-v02.api.letsencrypt.org/directory"
+	if acme.Staging {
+		url = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	}
+	skew, err := d.clockSkew(url)
+	if err != nil {
+		d.warn("clock", "check outbound HTTPS access to the ACME server", "could not compare clocks: %v", err)
+		return
+	}
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxClockSkew {
+		d.fail("clock", "enable time sync (timedatectl set-ntp true, or chrony)", "local clock is off by %s", skew.Round(time.Second))
+		return
+	}
+	d.pass("clock", "within %s of the ACME server", skew.Round(time.Second))
+}
+
+// httpClockSkew returns local time minus the Date header from url.
+func httpClockSkew(url string) (time.Duration, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Head(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("no usable Date header: %w", err)
+	}
+	return time.Since(remote), nil
+}
+
+// fdsNeeded estimates the descriptors maboo uses at full load: external
+// PHP workers hold a pipe pair and the process handles, every WebSocket is
+// a socket, and the rest covers HTTP connections, logs and listeners.
+func fdsNeeded(cfg *config.Config) uint64 {
+	const baseline = 1024
+	n := uint64(baseline)
+	if cfg.PHP.Binary != "" {
+		n += uint64(cfg.Pool.MaxWorkers) * 4
+	} else {
+		n += uint64(cfg.Pool.MaxWorkers)
+	}
+	if cfg.WebSocket.Enabled {
+		n += uint64(cfg.WebSocket.MaxConnections)
+	}
+	return n
+}
+
+func (d *doctor) checkFDLimit() {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		d.warn("fd limit", "", "cannot read RLIMIT_NOFILE: %v", err)
+		return
+	}
+	d.evalFDLimit(lim.Cur, lim.Max, fdsNeeded(d.cfg))
+}
+
+func (d *doctor) evalFDLimit(soft, hard, need uint64) {
+	hint := fmt.Sprintf("raise it to at least %d (ulimit -n %d, or LimitNOFILE=%d in the systemd unit)", need, need, need)
+	switch {
+	case hard < need:
+		d.fail("fd limit", hint, "hard limit %d is below the ~%d needed for pool.max_workers=%d", hard, need, d.cfg.Pool.MaxWorkers)
+	case soft < need:
+		d.warn("fd limit", hint, "soft limit %d is below the ~%d needed for pool.max_workers=%d", soft, need, d.cfg.Pool.MaxWorkers)
+	default:
+		d.pass("fd limit", "%d open files allowed, ~%d needed", soft, need)
+	}
+}
+
+// checkResources reports the container limits, the GOMAXPROCS serve would
+// run with, and whether the pool fits the memory limit.
+func (d *doctor) checkResources() {
+	l := d.limits
+	procs := maxProcs(l)
+	switch {
+	case l.Version == 0:
+		d.pass("container limits", "none found; %d CPUs, GOMAXPROCS %d", runtime.NumCPU(), procs)
+	default:
+		cpu, mem := "none", "none"
+		if l.CPU > 0 {
+			cpu = strconv.FormatFloat(l.CPU, 'g', -1, 64)
+		}
+		if l.Memory > 0 {
+			mem = config.Size(l.Memory).String()
+		}
+		d.pass("container limits", "cgroup v%d: CPU quota %s, memory %s; GOMAXPROCS %d", l.Version, cpu, mem, procs)
+	}
+
+	pool := d.cfg.Pool
+	per := pool.WorkerMemoryEstimate()
+	if s := d.cfg.PoolSizing(); s.Derived {
+		d.pass("pool size", "%d-%d workers, derived from the %s memory limit at %s per worker",
+			s.MinWorkers, s.MaxWorkers, config.Size(l.Memory), config.Size(s.WorkerMemory))
+		return
+	}
+	if l.Memory > 0 && per > 0 && int64(pool.MaxWorkers)*per > l.Memory {
+		d.warn("pool size", "lower pool.max_workers, or leave pool.min_workers and pool.max_workers out to size the pool from the limit",
+			"pool.max_workers=%d at %s per worker needs %s, over the %s memory limit",
+			pool.MaxWorkers, config.Size(per), config.Size(int64(pool.MaxWorkers)*per), config.Size(l.Memory))
+		return
+	}
+	d.pass("pool size", "%d-%d workers", pool.MinWorkers, pool.MaxWorkers)
+}
+
+func printDiagnostics(out io.Writer, results []diagnostic) {
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(out, "[%s] %s: %s\n", strings.ToUpper(r.Status), r.Check, r.Message)
+		if r.Hint != "" && r.Status != diagPass {
+			fmt.Fprintf(out, "       hint: %s\n", r.Hint)
+		}
+	}
+	fmt.Fprintf(out, "\n%d passed, %d warning(s), %d failed\n", counts[diagPass], counts[diagWarn], counts[diagFail])
+}
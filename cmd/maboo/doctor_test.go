@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/cgroup"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+func diagnosticFor(results []diagnostic, check string) (diagnostic, bool) {
+	for _, r := range results {
+		if r.Check == check {
+			return r, true
+		}
+	}
+	return diagnostic{}, false
+}
+
+func TestDoctorPortInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := config.Default()
+	cfg.Server.Address = ln.Addr().String()
+	d := &doctor{cfg: cfg}
+	d.checkBind()
+
+	r, ok := diagnosticFor(d.results, "bind server.address")
+	if !ok || r.Status != diagFail || r.Hint == "" {
+		t.Errorf("result = %+v, want a fail with a hint", r)
+	}
+}
+
+func TestDoctorKeyPermissions(t *testing.T) {
+	dir := t.TempDir()
+	writeProject(t, dir, map[string]string{"cert.pem": "cert", "key.pem": "key"})
+	key := filepath.Join(dir, "key.pem")
+
+	cfg := config.Default()
+	cfg.App.Root = dir
+	cfg.Static.Root = ""
+	cfg.Server.TLS.Cert = filepath.Join(dir, "cert.pem")
+	cfg.Server.TLS.Key = key
+
+	d := &doctor{cfg: cfg}
+	d.checkPaths()
+	if last := d.results[len(d.results)-1]; last.Check != "server.tls.key" || last.Status != diagWarn {
+		t.Errorf("0644 key: last result = %+v, want a warning", last)
+	}
+
+	if err := os.Chmod(key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d = &doctor{cfg: cfg}
+	d.checkPaths()
+	for _, r := range d.results {
+		if r.Status != diagPass {
+			t.Errorf("0600 key: %+v", r)
+		}
+	}
+}
+
+func TestDoctorFDLimit(t *testing.T) {
+	cfg := config.Default()
+	cfg.Pool.MaxWorkers = 100
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.MaxConnections = 5000
+	need := fdsNeeded(cfg)
+	if need < 6000 {
+		t.Fatalf("fdsNeeded = %d, want room for workers and websocket connections", need)
+	}
+
+	tests := []struct {
+		soft, hard uint64
+		want       string
+	}{
+		{need, need, diagPass},
+		{1024, need, diagWarn},
+		{1024, 1024, diagFail},
+	}
+	for _, tt := range tests {
+		d := &doctor{cfg: cfg}
+		d.evalFDLimit(tt.soft, tt.hard, need)
+		if got := d.results[0].Status; got != tt.want {
+			t.Errorf("soft=%d hard=%d: status = %s, want %s", tt.soft, tt.hard, got, tt.want)
+		}
+	}
+}
+
+func TestDoctorClockSkew(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.TLS.ACME.Email = "ops@example.com"
+
+	tests := []struct {
+		skew time.Duration
+		err  error
+		want string
+	}{
+		{2 * time.Second, nil, diagPass},
+		{-10 * time.Minute, nil, diagFail},
+		{0, errors.New("no route to host"), diagWarn},
+	}
+	for _, tt := range tests {
+		d := &doctor{cfg: cfg, clockSkew: func(string) (time.Duration, error) { return tt.skew, tt.err }}
+		d.checkClock()
+		if got := d.results[0].Status; got != tt.want {
+			t.Errorf("skew=%s err=%v: status = %s, want %s", tt.skew, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDoctorJSON(t *testing.T) {
+	dir := t.TempDir()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	writeProject(t, dir, map[string]string{
+		"maboo.yaml": "app:\n  root: " + dir + "\nserver:\n  address: " + ln.Addr().String() + "\n",
+	})
+
+	var out, errOut bytes.Buffer
+	code := runDoctor([]string{"--json", "--config", filepath.Join(dir, "maboo.yaml")}, &out, &errOut)
+	if code != exitFailed {
+		t.Errorf("exit = %d, want %d (port in use)", code, exitFailed)
+	}
+	var results []diagnostic
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	if r, ok := diagnosticFor(results, "config"); !ok || r.Status != diagPass {
+		t.Errorf("config result = %+v", r)
+	}
+	if r, ok := diagnosticFor(results, "bind server.address"); !ok || r.Status != diagFail {
+		t.Errorf("bind result = %+v", r)
+	}
+}
+
+func TestDoctorResources(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "maboo.yaml")
+	limits := cgroup.Limits{Version: 2, CPU: 2, Memory: 1 << 30}
+
+	writeProject(t, dir, map[string]string{"maboo.yaml": "app:\n  root: .\n"})
+	d := &doctor{limits: limits}
+	d.loadConfig(path)
+	d.checkResources()
+	if r, ok := diagnosticFor(d.results, "container limits"); !ok || r.Status != diagPass || !strings.Contains(r.Message, "cgroup v2: CPU quota 2, memory 1G") {
+		t.Errorf("container limits = %+v", r)
+	}
+	if r, ok := diagnosticFor(d.results, "pool size"); !ok || r.Status != diagPass || !strings.HasPrefix(r.Message, "4-8 workers, derived") {
+		t.Errorf("derived pool size = %+v", r)
+	}
+
+	writeProject(t, dir, map[string]string{"maboo.yaml": "app:\n  root: .\npool:\n  max_workers: 32\n"})
+	d = &doctor{limits: limits}
+	d.loadConfig(path)
+	d.checkResources()
+	if r, ok := diagnosticFor(d.results, "pool size"); !ok || r.Status != diagWarn || r.Hint == "" {
+		t.Errorf("explicit pool size = %+v, want a warning with a hint", r)
+	}
+}
+
+func TestDoctorEngineExtensions(t *testing.T) {
+	cfg := config.Default()
+	cfg.PHP.INI = map[string]string{
+		"extension":      "redis, php_intl.dll",
+		"zend_extension": "opcache",
+		"memory_limit":   "256M",
+		"display_errors": "Off",
+		"opcache.enable": "1",
+		"bogus.key":      "1",
+	}
+	var code string
+	d := &doctor{cfg: cfg, eval: func(_ context.Context, c string) ([]byte, error) {
+		code = c
+		return []byte(`{"extensions":["Core","redis","Zend OPcache"],
+			"ini":{"bogus.key":null,"display_errors":"","memory_limit":"256M","opcache.enable":"0"}}`), nil
+	}}
+	d.checkExtensions()
+
+	if !strings.Contains(code, "'memory_limit'") || strings.Contains(code, "'extension'") {
+		t.Errorf("code does not ask for the php.ini keys alone:\n%s", code)
+	}
+	if r, _ := diagnosticFor(d.results, "extensions"); r.Status != diagFail || r.Message != "not loaded by PHP: php_intl.dll" {
+		t.Errorf("extensions = %+v", r)
+	}
+	r, _ := diagnosticFor(d.results, "ini")
+	if r.Status != diagWarn || !strings.Contains(r.Message, "bogus.key unknown") || !strings.Contains(r.Message, `opcache.enable="0", not "1"`) ||
+		strings.Contains(r.Message, "display_errors") || strings.Contains(r.Message, "memory_limit") {
+		t.Errorf("ini = %+v", r)
+	}
+
+	// An engine that cannot evaluate PHP leaves it to the file checks.
+	d = &doctor{cfg: cfg, eval: func(context.Context, string) ([]byte, error) { return nil, phpengine.ErrEvalUnavailable }}
+	d.checkExtensions()
+	if r, _ := diagnosticFor(d.results, "extensions"); r.Status != diagWarn || !strings.Contains(r.Message, "extension_dir not set") {
+		t.Errorf("fallback = %+v", r)
+	}
+	if r, _ := diagnosticFor(d.results, "ini"); r.Status != diagWarn || !strings.Contains(r.Message, "not checked") {
+		t.Errorf("ini without the engine = %+v", r)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readyHandler(code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(code)
+	})
+}
+
+func TestHealthcheckTCP(t *testing.T) {
+	ready := httptest.NewServer(readyHandler(http.StatusOK))
+	defer ready.Close()
+	notReady := httptest.NewServer(readyHandler(http.StatusServiceUnavailable))
+	defer notReady.Close()
+
+	var errOut bytes.Buffer
+	if code := runHealthcheck([]string{"--url", ready.URL + "/readyz"}, &errOut); code != 0 {
+		t.Errorf("ready: exit code %d: %s", code, errOut.String())
+	}
+
+	errOut.Reset()
+	if code := runHealthcheck([]string{"--url", notReady.URL + "/readyz"}, &errOut); code != exitUnhealthyCheck {
+		t.Errorf("not ready: exit code %d", code)
+	}
+	if got := errOut.String(); !strings.Contains(got, "503 Service Unavailable") || strings.Count(got, "\n") != 1 {
+		t.Errorf("reason = %q", got)
+	}
+
+	// Without --url, /readyz on server.address from the config.
+	cfgPath := filepath.Join(t.TempDir(), "maboo.yaml")
+	if err := os.WriteFile(cfgPath, []byte("server:\n  address: "+ready.Listener.Addr().String()+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	errOut.Reset()
+	if code := runHealthcheck([]string{"--config", cfgPath}, &errOut); code != 0 {
+		t.Errorf("from config: exit code %d: %s", code, errOut.String())
+	}
+}
+
+func TestHealthcheckUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "maboo.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: readyHandler(http.StatusOK)}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	var errOut bytes.Buffer
+	if code := runHealthcheck([]string{"--socket", socket, "--url", "http://localhost/readyz"}, &errOut); code != 0 {
+		t.Errorf("exit code %d: %s", code, errOut.String())
+	}
+	errOut.Reset()
+	if code := runHealthcheck([]string{"--socket", socket + ".missing", "--url", "http://localhost/readyz"}, &errOut); code != exitUnhealthyCheck {
+		t.Errorf("missing socket: exit code %d", code)
+	}
+}
+
+func TestHealthcheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer srv.Close()
+	defer close(release)
+
+	var errOut bytes.Buffer
+	start := time.Now()
+	if code := runHealthcheck([]string{"--url", srv.URL + "/readyz", "--timeout", "50ms"}, &errOut); code != exitUnhealthyCheck {
+		t.Errorf("exit code %d", code)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %s with a 50ms timeout", d)
+	}
+	if !strings.Contains(errOut.String(), "no answer within 50ms") {
+		t.Errorf("reason = %q", errOut.String())
+	}
+}
+
+func TestHealthcheckSelfSigned(t *testing.T) {
+	srv := httptest.NewTLSServer(readyHandler(http.StatusOK))
+	defer srv.Close()
+
+	var errOut bytes.Buffer
+	if code := runHealthcheck([]string{"--url", srv.URL + "/readyz"}, &errOut); code != exitUnhealthyCheck {
+		t.Errorf("untrusted certificate: exit code %d", code)
+	}
+	if !strings.Contains(errOut.String(), "--insecure") {
+		t.Errorf("reason = %q", errOut.String())
+	}
+	errOut.Reset()
+	if code := runHealthcheck([]string{"--url", srv.URL + "/readyz", "--insecure"}, &errOut); code != 0 {
+		t.Errorf("--insecure: exit code %d: %s", code, errOut.String())
+	}
+}
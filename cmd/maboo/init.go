@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// frameworkProfile holds the settings init writes for a framework.
+type frameworkProfile struct {
+	Entry      string   // entry point used when --framework skips detection
+	StaticRoot string   // directory served directly
+	WatchDirs  []string // source directories worth watching in development
+}
+
+var frameworkProfiles = map[string]frameworkProfile{
+	"laravel":   {Entry: "public/index.php", StaticRoot: "public", WatchDirs: []string{"app", "config", "routes", "resources/views"}},
+	"symfony":   {Entry: "public/index.php", StaticRoot: "public", WatchDirs: []string{"src", "config", "templates"}},
+	"wordpress": {Entry: "index.php", StaticRoot: ".", WatchDirs: []string{"wp-content/themes", "wp-content/plugins"}},
+	"drupal":    {Entry: "index.php", StaticRoot: ".", WatchDirs: []string{"modules", "themes"}},
+	"generic":   {Entry: "index.php", StaticRoot: "public", WatchDirs: []string{"."}},
+}
+
+// initParams are the values substituted into the starter config.
+type initParams struct {
+	Framework     string
+	Entry         string
+	PHPVersion    string
+	VersionSource string
+	MinWorkers    int
+	MaxWorkers    int
+	StaticRoot    string
+	WatchDirs     []string
+}
+
+// detectInitParams inspects dir. A non-empty framework bypasses framework
+// and entry point detection.
+func detectInitParams(dir, framework string) (initParams, error) {
+	detected := framework == ""
+	if detected {
+		framework = phpengine.DetectFramework(dir)
+	}
+	profile, ok := frameworkProfiles[framework]
+	if !ok {
+		return initParams{}, fmt.Errorf("unknown framework %q (use laravel, symfony, wordpress, drupal or generic)", framework)
+	}
+
+	p := initParams{
+		Framework:     framework,
+		Entry:         profile.Entry,
+		PHPVersion:    "auto",
+		VersionSource: "no composer.json found, picked at startup",
+		MinWorkers:    runtime.NumCPU(),
+		MaxWorkers:    runtime.NumCPU() * 4,
+		StaticRoot:    profile.StaticRoot,
+		WatchDirs:     profile.WatchDirs,
+	}
+	if detected {
+		p.Entry = phpengine.DetectEntryPoint(dir, "")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "composer.json")); err == nil {
+		p.PHPVersion = phpengine.SelectVersion(dir, "")
+		p.VersionSource = "from composer.json"
+	}
+	if framework == "generic" {
+		if fi, err := os.Stat(filepath.Join(dir, "public")); err != nil || !fi.IsDir() {
+			p.StaticRoot = "."
+		}
+	}
+	return p, nil
+}
+
+var starterConfig = template.Must(template.New("maboo.yaml").Parse(`# Maboo configuration generated by "maboo init" ({{.Framework}} project).
+# See maboo.yaml.example for every available setting.
+
+server:
+  address: "0.0.0.0:8080"
+  http2: true
+
+php:
+  version: "{{.PHPVersion}}"   # {{.VersionSource}}
+  mode: "worker"
+
+pool:
+  min_workers: {{.MinWorkers}}   # one per CPU
+  max_workers: {{.MaxWorkers}}   # scale up to 4x CPUs under load
+  max_jobs: 10000   # recycle a worker after this many requests
+  max_memory: "128M"
+  request_timeout: "30s"
+
+app:
+  root: "."
+  entry: "{{.Entry}}"
+
+static:
+  root: "{{.StaticRoot}}"
+  cache_control: "public, max-age=3600"
+
+logging:
+  level: "info"
+  format: "json"
+
+# Reload workers when PHP sources change (development only).
+watch:
+  enabled: false
+  dirs:{{range .WatchDirs}}
+    - "{{.}}"{{end}}
+  interval: "2s"
+`))
+
+func renderStarterConfig(p initParams) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := starterConfig.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runInit writes a starter maboo.yaml for the project in the current
+// directory.
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	force := fs.Bool("force", false, "overwrite an existing file")
+	stdout := fs.Bool("stdout", false, "print the config instead of writing it")
+	framework := fs.String("framework", "", "skip detection and use this framework")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path := "maboo.yaml"
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+
+	p, err := detectInitParams(".", strings.ToLower(*framework))
+	if err != nil {
+		return err
+	}
+	out, err := renderStarterConfig(p)
+	if err != nil {
+		return err
+	}
+
+	if *stdout {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(out); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%s, entry %s, PHP %s)\n", path, p.Framework, p.Entry, p.PHPVersion)
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestStarterConfigLoads(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     []string
+		framework string
+		want      initParams
+	}{
+		{"laravel", []string{"artisan", "public/index.php", "composer.json"}, "",
+			initParams{Framework: "laravel", Entry: "public/index.php", PHPVersion: "8.3", StaticRoot: "public"}},
+		{"wordpress", []string{"wp-config.php", "index.php"}, "",
+			initParams{Framework: "wordpress", Entry: "index.php", PHPVersion: "auto", StaticRoot: "."}},
+		{"generic without public", []string{"main.php"}, "",
+			initParams{Framework: "generic", Entry: "main.php", PHPVersion: "auto", StaticRoot: "."}},
+		{"forced framework", nil, "symfony",
+			initParams{Framework: "symfony", Entry: "public/index.php", PHPVersion: "auto", StaticRoot: "public"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				path := filepath.Join(dir, f)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				body := ""
+				if f == "composer.json" {
+					body = `{"require": {"php": "^8.2"}}`
+				}
+				if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p, err := detectInitParams(dir, tt.framework)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Framework != tt.want.Framework || p.Entry != tt.want.Entry ||
+				p.PHPVersion != tt.want.PHPVersion || p.StaticRoot != tt.want.StaticRoot {
+				t.Errorf("params = %+v, want %+v", p, tt.want)
+			}
+
+			out, err := renderStarterConfig(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "maboo.yaml")
+			if err := os.WriteFile(path, out, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := config.Load(path)
+			if err != nil {
+				t.Fatalf("generated config does not load: %v\n%s", err, out)
+			}
+			if cfg.App.Entry != p.Entry || cfg.PHP.Version != p.PHPVersion || cfg.Static.Root != p.StaticRoot {
+				t.Errorf("loaded config does not match params %+v", p)
+			}
+			if len(cfg.Watch.Dirs) == 0 {
+				t.Error("watch.dirs is empty")
+			}
+			if len(cfg.Warnings()) != 0 {
+				t.Errorf("unexpected warnings: %v", cfg.Warnings())
+			}
+		})
+	}
+}
+
+func TestInitUnknownFramework(t *testing.T) {
+	if _, err := detectInitParams(t.TempDir(), "rails"); err == nil {
+		t.Error("expected unknown framework to be rejected")
+	}
+}
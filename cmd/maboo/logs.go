@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/logging"
+)
+
+// logOutputs are the destinations behind the app, access and audit
+// loggers. access is nil when request records share the app log, and
+// audit when audit records share the app or access log.
+type logOutputs struct {
+	app    *logging.Output
+	access *logging.Output
+	audit  *logging.Output
+}
+
+// openLogOutputs opens logging.output and, when they name different
+// destinations, logging.access_output and logging.audit.output. Each file
+// rotates on its own.
+func openLogOutputs(cfg config.LogConfig) (*logOutputs, error) {
+	opts := logging.RotateOptions{
+		MaxSize:    cfg.MaxSize.Bytes(),
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge.Duration(),
+		Compress:   cfg.Compress,
+	}
+	app, err := logging.Open(cfg.Output, opts)
+	if err != nil {
+		return nil, fmt.Errorf("logging.output: %w", err)
+	}
+	out := &logOutputs{app: app}
+	if cfg.AccessOutput != "" && cfg.AccessOutput != cfg.Output {
+		if out.access, err = logging.Open(cfg.AccessOutput, opts); err != nil {
+			out.close()
+			return nil, fmt.Errorf("logging.access_output: %w", err)
+		}
+	}
+	if a := cfg.Audit.Output; a != "" && a != cfg.Output && a != cfg.AccessOutput {
+		if out.audit, err = logging.Open(a, opts); err != nil {
+			out.close()
+			return nil, fmt.Errorf("logging.audit.output: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// auditOutput is where audit records go: logging.audit.output, else the
+// output it names or logging.output.
+func (o *logOutputs) auditOutput(cfg config.LogConfig) *logging.Output {
+	switch {
+	case o.audit != nil:
+		return o.audit
+	case o.access != nil && cfg.Audit.Output == cfg.AccessOutput:
+		return o.access
+	}
+	return o.app
+}
+
+// reopen reopens every file output, for SIGUSR2 after external rotation.
+func (o *logOutputs) reopen() error {
+	err := o.app.Reopen()
+	if o.access != nil {
+		err = errors.Join(err, o.access.Reopen())
+	}
+	if o.audit != nil {
+		err = errors.Join(err, o.audit.Reopen())
+	}
+	return err
+}
+
+func (o *logOutputs) close() {
+	o.app.Close()
+	if o.access != nil {
+		o.access.Close()
+	}
+	if o.audit != nil {
+		o.audit.Close()
+	}
+}
@@ -2,19 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/sadewadee/maboo/internal/audit"
+	"github.com/sadewadee/maboo/internal/buildinfo"
+	"github.com/sadewadee/maboo/internal/cgroup"
 	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/handoff"
+	"github.com/sadewadee/maboo/internal/jobs"
+	"github.com/sadewadee/maboo/internal/logging"
+	"github.com/sadewadee/maboo/internal/notify"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/pidfile"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/schedule"
+	"github.com/sadewadee/maboo/internal/scratch"
 	"github.com/sadewadee/maboo/internal/server"
-	"github.com/sadewadee/maboo/internal/worker"
+	"github.com/sadewadee/maboo/internal/websocket"
 )
 
-var version = "0.2.0-dev"
+// upgradeTimeout bounds how long a binary upgrade waits for the new process
+// to start serving.
+const upgradeTimeout = 30 * time.Second
 
 func main() {
 	if len(os.Args) < 2 {
@@ -24,9 +46,41 @@ func main() {
 
 	switch os.Args[1] {
 	case "serve", "start":
-		serve()
+		serve(false)
+	case "dev":
+		serve(true)
+	case "config":
+		dumpConfig()
+	case "check":
+		failed, err := runCheck(os.Args[2:], os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "doctor":
+		os.Exit(runDoctor(os.Args[2:], os.Stdout, os.Stderr))
+	case "status":
+		os.Exit(runStatus(os.Args[2:], os.Stdout, os.Stderr))
+	case "healthcheck":
+		os.Exit(runHealthcheck(os.Args[2:], os.Stderr))
+	case "reload":
+		os.Exit(runReload(os.Args[2:], os.Stderr))
+	case "stop":
+		os.Exit(runStop(os.Args[2:], os.Stderr))
+	case "bench":
+		os.Exit(runBench(os.Args[2:], os.Stdout, os.Stderr))
+	case "upgrade":
+		os.Exit(runUpgrade(os.Args[2:], os.Stdout, os.Stderr))
+	case "init":
+		if err := runInit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	case "version":
-		fmt.Printf("maboo v%s\n", version)
+		os.Exit(runVersion(os.Args[2:], os.Stdout, os.Stderr))
 	case "help":
 		printUsage()
 	default:
@@ -36,101 +90,635 @@ func main() {
 	}
 }
 
-func serve() {
+// parseConfigArgs reads the optional config path and --no-strict flag that
+// follow a command.
+func parseConfigArgs(args []string) (string, config.Options) {
 	cfgPath := "maboo.yaml"
-	if len(os.Args) > 2 {
-		cfgPath = os.Args[2]
+	var opts config.Options
+	for _, arg := range args {
+		switch {
+		case arg == "--no-strict":
+			opts.NonStrict = true
+		case !strings.HasPrefix(arg, "-"):
+			cfgPath = arg
+		}
+	}
+	return cfgPath, opts
+}
+
+// logConfigWarnings reports deprecated and ignored keys.
+func logConfigWarnings(logger *slog.Logger, cfg *config.Config) {
+	for _, w := range cfg.Warnings() {
+		logger.Warn("config warning",
+			"key", w.Path,
+			"line", w.Line,
+			"message", w.Message,
+			"replacement", w.Replacement,
+		)
+	}
+}
+
+// fitMaxProcs sets GOMAXPROCS to maxProcs(limits) and returns it.
+func fitMaxProcs(limits cgroup.Limits) int {
+	n := maxProcs(limits)
+	runtime.GOMAXPROCS(n)
+	return n
+}
+
+// maxProcs is the GOMAXPROCS to run with: the container's CPU quota,
+// rounded down, unless the GOMAXPROCS variable sets it.
+func maxProcs(limits cgroup.Limits) int {
+	if n := limits.MaxProcs(); n > 0 && os.Getenv("GOMAXPROCS") == "" {
+		return n
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// loadConfig loads the config file and applies preset, if any, on top.
+func loadConfig(path string, opts config.Options, preset func(*config.Config) map[string]string) (*config.Config, error) {
+	cfg, err := config.LoadWithOptions(path, opts)
+	if err != nil || preset == nil {
+		return cfg, err
+	}
+	if err := cfg.ApplyOverrides(preset(cfg)); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+func serve(dev bool) {
+	cfgPath, cfgOpts := parseConfigArgs(os.Args[2:])
+	var preset func(*config.Config) map[string]string
+	if dev || slices.Contains(os.Args[2:], "--dev") {
+		preset = devOverrides
 	}
 
-	logger := setupLogger("info", "json")
-	logger.Info("maboo starting", "version", version)
+	logs := logging.New(os.Stdout, "info", "json")
+	logger := logs.Slog()
+	build := buildinfo.Get()
+	logger.Info("maboo starting", "version", build.Version, "commit", build.Commit,
+		"php_embed", build.PHPEmbed, "php_versions", build.PHPVersions)
+	buildinfo.ExportMetric(build)
 
-	cfg, err := config.Load(cfgPath)
+	limits := cgroup.Detect()
+	cfgOpts.Limits = &limits
+	if limits.Version != 0 {
+		logger.Info("container limits", "cgroup", limits.Version, "cpu", limits.CPU,
+			"memory", limits.Memory, "gomaxprocs", fitMaxProcs(limits))
+	}
+
+	cfg, err := loadConfig(cfgPath, cfgOpts, preset)
 	if err != nil {
 		logger.Error("failed to load config", "path", cfgPath, "error", err)
 		os.Exit(1)
 	}
+	if _, err := phpengine.CheckEntryPoint(cfg.App.Root, cfg.App.Entry); err != nil {
+		logger.Error("refusing to start", "error", err)
+		os.Exit(1)
+	}
+	if s := cfg.PoolSizing(); s.Derived {
+		logger.Info("pool sized from memory limit", "min_workers", s.MinWorkers, "max_workers", s.MaxWorkers,
+			"worker_memory", s.WorkerMemory)
+	}
+	if preset != nil {
+		printDevBanner(os.Stdout, cfg)
+	}
 
-	logger = setupLogger(cfg.Logging.Level, cfg.Logging.Format)
+	logs.Configure(cfg.Logging.Level, cfg.Logging.Format)
+	outputs, err := openLogOutputs(cfg.Logging)
+	if err != nil {
+		logger.Error("failed to open log output", "error", err)
+		os.Exit(1)
+	}
+	defer outputs.close()
+	logs.SetOutput(outputs.app)
+	accessLogs := logs
+	if outputs.access != nil {
+		accessLogs = logging.New(outputs.access, cfg.Logging.Level, cfg.Logging.Format)
+	}
+	// Audit records are written whatever logging.level says.
+	audits := audit.New(logging.New(outputs.auditOutput(cfg.Logging), "info", cfg.Logging.Format).Slog(), cfg.Logging.Audit.Keep)
+	logConfigWarnings(logger, cfg)
 
-	// Create embedded worker pool
-	workerPool := worker.NewPool(cfg)
-	workerPool.SetLogger(logger)
+	sockets, err := handoff.New()
+	if err != nil {
+		logger.Error("reading inherited listeners", "error", err)
+		os.Exit(1)
+	}
+
+	// After an upgrade the previous process still holds the pid file; it
+	// is taken over once this process is serving.
+	var pid atomic.Pointer[pidfile.File]
+	if !sockets.Upgraded() {
+		p, err := acquirePIDFile(cfg.Server.PIDFile, logger)
+		if err != nil {
+			logger.Error("refusing to start", "pid_file", cfg.Server.PIDFile, "error", err)
+			os.Exit(1)
+		}
+		pid.Store(p)
+	}
+
+	// Temporary files, such as request bodies too large to keep in
+	// memory, are written within temp.max_bytes
+	temp, err := scratch.New(scratch.Options{
+		Dir:           cfg.Temp.Dir,
+		MaxBytes:      cfg.Temp.MaxBytes.Bytes(),
+		SweepInterval: cfg.Temp.SweepInterval.Duration(),
+		OrphanAge:     cfg.Temp.OrphanAge.Duration(),
+	}, logger)
+	if err != nil {
+		logger.Error("refusing to start", "error", err)
+		releasePIDFile(pid.Load(), logger)
+		os.Exit(1)
+	}
+
+	// Create the worker pool: embedded, or PHP processes when an HTTP
+	// worker script runs under php.binary
+	workerPool := server.NewPool(cfg, logger)
 
 	if err := workerPool.Start(); err != nil {
 		logger.Error("failed to start worker pool", "error", err)
+		releasePIDFile(pid.Load(), logger)
 		os.Exit(1)
 	}
 
+	notifier := newNotifier(cfg.Notify, logger)
+	reporter := notify.Reporter(notifier, newReporter(cfg.Errors, build.Version, logger))
+
 	// Create HTTP server
 	srv := server.New(cfg, workerPool, logger)
+	srv.SetVersion(build.Version)
+	srv.SetReporter(reporter)
+	if accessLogs != logs {
+		srv.SetAccessLogger(accessLogs.Slog())
+	}
+	srv.SetListeners(sockets)
+	srv.SetAudit(audits)
+	srv.SetNotifier(notifier)
+	srv.SetScratch(temp)
+
+	// WebSocket events get workers of their own when PHP runs as an
+	// external binary, and so does each subprotocol with its own worker;
+	// the embedded engine serves them from its pool.
+	var wsWorkers []*pool.Pool
+	if cfg.WebSocket.Enabled && cfg.PHP.Binary != "" {
+		wsWorkers, err = startWebSocketWorkers(cfg, srv, reporter, logger)
+		if err != nil {
+			logger.Error("failed to start websocket worker group", "error", err)
+			workerPool.Stop()
+			releasePIDFile(pid.Load(), logger)
+			os.Exit(1)
+		}
+	}
+
+	// Bind before reporting readiness, so a taken port fails startup
+	// here rather than after "maboo ready".
+	if err := srv.Bind(); err != nil {
+		var bindErr *server.BindError
+		if errors.As(err, &bindErr) {
+			logger.Error("cannot bind listener", "listener", bindErr.Listener, "address", bindErr.Addr,
+				"error", bindErr.Err, "hint", bindErr.Hint())
+		} else {
+			logger.Error("failed to start server", "error", err)
+		}
+		stopPools(wsWorkers)
+		workerPool.Stop()
+		releasePIDFile(pid.Load(), logger)
+		os.Exit(1)
+	}
+
+	// Binary upgrade: start the new binary on our sockets, then hand over
+	// the pid file and drain.
+	handedOver := make(chan int, 1)
+	srv.SetUpgrade(func() (int, error) {
+		logger.Info("upgrade requested, starting new binary")
+		child, err := sockets.Upgrade(upgradeTimeout)
+		if err != nil {
+			logger.Error("upgrade failed, still serving", "error", err)
+			return 0, err
+		}
+		if p := pid.Swap(nil); p != nil {
+			if err := p.Handover(); err != nil {
+				logger.Warn("handing over pid file", "error", err)
+			}
+		}
+		handedOver <- child
+		return child, nil
+	})
+
+	// Long-running jobs start once this process is serving and restart
+	// after the HTTP workers whenever those reload.
+	var jobManager *jobs.Manager
+	if len(cfg.Jobs) > 0 {
+		jobManager, err = jobs.New(cfg.Jobs, jobs.NewRunner(cfg, logger), logger)
+		if err != nil {
+			logger.Error("failed to create job manager", "error", err)
+			stopPools(wsWorkers)
+			workerPool.Stop()
+			releasePIDFile(pid.Load(), logger)
+			os.Exit(1)
+		}
+		srv.SetJobs(jobManager)
+	}
+
+	// Config reloads asked for by the file watcher, run by the SIGHUP
+	// handler below so they never overlap with one.
+	configChanged := make(chan struct{}, 1)
+
+	// Worker reloads, asked for by SIGUSR1 or the file watcher.
+	reloadWorkers := func() error {
+		if err := srv.ReloadWorkerGroup(""); err != nil {
+			return err
+		}
+		if jobManager != nil {
+			jobManager.Restart()
+		}
+		srv.RetrySelfTest(context.Background())
+		return nil
+	}
+
+	watch := &fileWatch{logger: logger, attach: srv.SetWatcher, onChange: func(action pool.Action, paths []string) {
+		switch action {
+		case pool.ActionConfig:
+			select {
+			case configChanged <- struct{}{}:
+			default: // a config reload is already pending
+			}
+			return
+		case pool.ActionInvalidate:
+			err := workerPool.Invalidate(paths)
+			if err == nil {
+				return
+			}
+			logger.Warn("opcache invalidation unavailable, reloading the workers instead", "error", err)
+		}
+		params := map[string]any{"paths": paths}
+		if err := audits.Do(audit.Actor{Kind: audit.ActorWatcher}, audit.ActionReloadWorkers, params, reloadWorkers); err != nil {
+			logger.Error("reload after file change failed", "error", err)
+		}
+	}}
+	watch.apply(cfg)
+	defer watch.stop()
+
+	for _, w := range startGroupWatchers(cfg, srv, audits, logger) {
+		defer w.Stop()
+	}
+
+	// Scheduled jobs start once this process is serving. During an upgrade
+	// the previous process stops triggering as soon as it hands over.
+	var scheduler *schedule.Scheduler
+	if len(cfg.Schedule.Jobs) > 0 {
+		scheduler, err = schedule.New(cfg.Schedule, schedule.NewRunner(cfg, workerPool), logger)
+		if err != nil {
+			logger.Error("failed to create scheduler", "error", err)
+			workerPool.Stop()
+			releasePIDFile(pid.Load(), logger)
+			os.Exit(1)
+		}
+	}
 
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	// SIGQUIT shuts down at once, even during a graceful shutdown, after
+	// logging what the process was doing. Requests in flight are cut off.
+	diag := &diagnostics{logger: logger, pool: workerPool, ws: wsWorkers}
+	abort := make(chan os.Signal, 1)
+	signal.Notify(abort, syscall.SIGQUIT)
+	go func() {
+		<-abort
+		logger.Warn("SIGQUIT received, shutting down immediately")
+		audits.Do(audit.Signal("SIGQUIT"), audit.ActionShutdown, map[string]any{"immediate": true}, func() error {
+			diag.log("SIGQUIT")
+			diag.abort()
+			return nil
+		})
+		releasePIDFile(pid.Load(), logger)
+		os.Exit(1)
+	}()
+
 	// Handle SIGUSR1 for graceful reload
 	reload := make(chan os.Signal, 1)
 	signal.Notify(reload, syscall.SIGUSR1)
 	go func() {
 		for range reload {
 			logger.Info("SIGUSR1 received, reloading workers")
-			if err := workerPool.Reload(); err != nil {
+			if err := audits.Do(audit.Signal("SIGUSR1"), audit.ActionReloadWorkers, nil, reloadWorkers); err != nil {
 				logger.Error("reload failed", "error", err)
 			}
 		}
 	}()
 
-	// Start server
+	// Handle SIGUSR2 to reopen log files after external rotation
+	reopen := make(chan os.Signal, 1)
+	signal.Notify(reopen, syscall.SIGUSR2)
+	go func() {
+		for range reopen {
+			if err := audits.Do(audit.Signal("SIGUSR2"), audit.ActionReopenLogs, nil, outputs.reopen); err != nil {
+				logger.Error("reopening log files failed", "error", err)
+				continue
+			}
+			logger.Info("SIGUSR2 received, log files reopened")
+		}
+	}()
+
+	// Handle SIGHUP for config reload
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		r := &reloader{path: cfgPath, opts: cfgOpts, preset: preset, cfg: cfg, logs: logs, access: accessLogs, logger: logger, pool: workerPool, srv: srv, jobs: jobManager, watch: watch}
+		for {
+			actor := audit.Signal("SIGHUP")
+			select {
+			case <-hup:
+				logger.Info("SIGHUP received, reloading config", "path", cfgPath)
+			case <-configChanged:
+				logger.Info("watched file changed, reloading config", "path", cfgPath)
+				actor = audit.Actor{Kind: audit.ActorWatcher}
+			}
+			params := make(map[string]any)
+			audits.Do(actor, audit.ActionReloadConfig, params, func() error { return r.reload(params) })
+		}
+	}()
+
+	// One real request through the pool before serving: a broken deploy
+	// should not look ready. During an upgrade a failure always exits, so
+	// the previous process keeps serving.
+	ready := true
+	if cfg.Health.SelfTest.Enabled {
+		if err := srv.SelfTest(context.Background()); err != nil {
+			if cfg.Health.SelfTest.Strict || sockets.Upgraded() {
+				logger.Error("refusing to start", "error", err)
+				stopPools(wsWorkers)
+				workerPool.Stop()
+				releasePIDFile(pid.Load(), logger)
+				os.Exit(1)
+			}
+			ready = false
+		}
+	}
+
+	// Start serving
 	go func() {
-		if err := srv.Start(); err != nil {
+		if err := srv.Serve(); err != nil && err != http.ErrServerClosed {
 			logger.Error("server error", "error", err)
 			quit <- syscall.SIGTERM
 		}
 	}()
+	go func() {
+		<-srv.Listening()
+		if err := sockets.Ready(); err != nil {
+			logger.Warn("signalling readiness", "error", err)
+		}
+		if sockets.Upgraded() {
+			logger.Info("upgrade complete, previous process draining", "previous_pid", sockets.ParentPID())
+			pid.Store(takeOverPIDFile(cfg.Server.PIDFile, logger))
+		}
+		if scheduler != nil {
+			scheduler.Start()
+		}
+		if jobManager != nil {
+			jobManager.Start()
+		}
+	}()
 
-	logger.Info("maboo ready", "address", cfg.Server.Address)
+	if ready {
+		logger.Info("maboo ready", "address", cfg.Server.Address)
+	} else {
+		logger.Warn("maboo serving but not ready until a worker reload passes the self-test", "address", cfg.Server.Address)
+	}
 
-	<-quit
-	logger.Info("shutdown signal received")
+	var stopBy audit.Actor
+	var stopParams map[string]any
+	select {
+	case sig := <-quit:
+		logger.Info("shutdown signal received")
+		stopBy = audit.Signal("SIGTERM")
+		if sig == syscall.SIGINT {
+			stopBy = audit.Signal("SIGINT")
+		}
+	case child := <-handedOver:
+		logger.Info("handed over to new process, draining", "pid", child)
+		stopBy, stopParams = audit.Actor{Kind: audit.ActorSystem}, map[string]any{"new_pid": child}
+	}
+
+	// Shutdown runs in a strict order: the server turns readiness off,
+	// stops accepting, drains its connections and waits for every request
+	// in flight; only then are the pools behind it stopped, and the logs
+	// are closed last, as main returns.
+	if scheduler != nil {
+		scheduler.Stop()
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
+	stopped := make(chan struct{})
+	go diag.watch(ctx, stopped, func() {
+		releasePIDFile(pid.Load(), logger)
+		os.Exit(1)
+	})
+
+	// Jobs finish what they are doing while the server drains.
+	var jobsStopped sync.WaitGroup
+	if jobManager != nil {
+		jobsStopped.Go(func() { jobManager.Stop(ctx) })
+	}
 
-	if err := srv.Stop(ctx); err != nil {
+	if err := audits.Do(stopBy, audit.ActionShutdown, stopParams, func() error { return srv.Stop(ctx) }); err != nil {
 		logger.Error("server shutdown error", "error", err)
 	}
 
+	if scheduler != nil {
+		scheduler.Wait(ctx)
+	}
+	jobsStopped.Wait()
+
+	for _, p := range wsWorkers {
+		if err := p.Stop(); err != nil {
+			logger.Error("websocket pool shutdown error", "error", err)
+		}
+	}
 	if err := workerPool.Stop(); err != nil {
 		logger.Error("pool shutdown error", "error", err)
 	}
 
+	if err := reporter.Close(ctx); err != nil {
+		logger.Warn("error reports not sent before shutdown", "error", err)
+	}
+	if err := notifier.Close(ctx); err != nil {
+		logger.Warn("notifications not sent before shutdown", "error", err)
+	}
+	temp.Close(ctx)
+	close(stopped)
+
+	releasePIDFile(pid.Load(), logger)
 	logger.Info("maboo stopped")
 }
 
-func setupLogger(level, format string) *slog.Logger {
-	var lvl slog.Level
-	switch level {
-	case "debug":
-		lvl = slog.LevelDebug
-	case "warn":
-		lvl = slog.LevelWarn
-	case "error":
-		lvl = slog.LevelError
-	default:
-		lvl = slog.LevelInfo
+// startWebSocketWorkers starts the websocket worker group and those of
+// the subprotocols with their own worker, and attaches them to srv. On
+// failure it stops the ones it started.
+func startWebSocketWorkers(cfg *config.Config, srv *server.Server, reporter errreport.Reporter, logger *slog.Logger) ([]*pool.Pool, error) {
+	group, err := websocket.NewWorkerGroup(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	byProtocol, err := websocket.NewProtocolWorkerGroups(cfg, logger)
+	if err != nil {
+		return nil, err
 	}
+	started := make([]*pool.Pool, 0, 1+len(byProtocol))
+	start := func(p *pool.Pool) error {
+		p.SetReporter(reporter)
+		if err := p.Start(); err != nil {
+			stopPools(started)
+			return err
+		}
+		started = append(started, p)
+		return nil
+	}
+	if err := start(group); err != nil {
+		return nil, err
+	}
+	srv.SetWebSocketWorkers(group)
+	for name, p := range byProtocol {
+		if err := start(p); err != nil {
+			return nil, fmt.Errorf("subprotocol %s: %w", name, err)
+		}
+		srv.SetWebSocketProtocolWorkers(name, p)
+	}
+	return started, nil
+}
 
-	opts := &slog.HandlerOptions{Level: lvl}
+// stopPools stops worker pools on the way out.
+// startGroupWatchers starts a file watcher for each worker group with
+// watch globs of its own. A change reloads that group alone, whatever
+// watch.enabled says; the watch section's watcher handles the rest.
+func startGroupWatchers(cfg *config.Config, srv *server.Server, audits *audit.Log, logger *slog.Logger) []*pool.Watcher {
+	var watchers []*pool.Watcher
+	_, _, httpWorkers := cfg.HTTPWorkers()
+	for i, w := range cfg.Workers {
+		if len(w.Watch) == 0 {
+			continue
+		}
+		// Only the group without a pattern serves requests yet, as the
+		// default group.
+		if !httpWorkers || w.Pattern != "" {
+			logger.Warn("worker group does not run, its watch globs are ignored", "group", fmt.Sprintf("workers[%d]", i), "pattern", w.Pattern)
+			continue
+		}
+		const group = "default"
+		glogger := logger.With("group", group)
+		watcher, err := pool.NewWatcher(cfg.GroupWatch(w), glogger, func(_ pool.Action, paths []string) {
+			params := map[string]any{"group": group, "paths": paths}
+			if err := audits.Do(audit.Actor{Kind: audit.ActorWatcher}, audit.ActionReloadWorkers, params, func() error {
+				return srv.ReloadWorkerGroup(group)
+			}); err != nil {
+				glogger.Error("reload after file change failed", "error", err)
+			}
+		})
+		if err == nil {
+			err = watcher.Start()
+		}
+		if err != nil {
+			glogger.Error("group file watcher failed to start, its files are not watched", "error", err)
+			continue
+		}
+		watchers = append(watchers, watcher)
+	}
+	return watchers
+}
 
-	var handler slog.Handler
-	if format == "text" {
-		handler = slog.NewTextHandler(os.Stdout, opts)
-	} else {
-		handler = slog.NewJSONHandler(os.Stdout, opts)
+func stopPools(pools []*pool.Pool) {
+	for _, p := range pools {
+		p.Stop()
+	}
+}
+
+// newReporter returns the Sentry reporter configured by error_reporting,
+// or a no-op one without a DSN.
+func newReporter(cfg config.ErrorsConfig, version string, logger *slog.Logger) errreport.Reporter {
+	if cfg.DSN == "" {
+		return errreport.Nop{}
+	}
+	r, err := errreport.NewSentry(cfg.DSN.Value(), errreport.Options{
+		Release:     version,
+		Environment: cfg.Environment,
+		SampleRate:  cfg.SampleRate,
+		QueueSize:   cfg.QueueSize,
+		Timeout:     cfg.Timeout.Duration(),
+	}, logger)
+	if err != nil {
+		// Validate has checked the DSN; this is not expected.
+		logger.Error("error reporting disabled", "error", err)
+		return errreport.Nop{}
 	}
+	logger.Info("reporting errors", "environment", cfg.Environment, "sample_rate", cfg.SampleRate)
+	return r
+}
+
+// newNotifier returns the notifier posting to notifications.webhooks, or
+// nil without any.
+func newNotifier(cfg config.NotifyConfig, logger *slog.Logger) *notify.Notifier {
+	if len(cfg.Webhooks) == 0 {
+		return nil
+	}
+	hooks := make([]notify.Webhook, len(cfg.Webhooks))
+	for i, w := range cfg.Webhooks {
+		hooks[i] = notify.Webhook{
+			Name:    w.Name,
+			URL:     w.URL.Value(),
+			Events:  w.Events,
+			Timeout: w.Timeout.Duration(),
+		}
+		if hooks[i].Name == "" {
+			hooks[i].Name = fmt.Sprintf("webhooks[%d]", i)
+		}
+		if hooks[i].Timeout == 0 {
+			hooks[i].Timeout = cfg.Timeout.Duration()
+		}
+		if w.Template != "" {
+			// Validate has parsed it.
+			hooks[i].Template, _ = notify.ParseTemplate(w.Template)
+		}
+	}
+	instance := cfg.InstanceID
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	logger.Info("sending notifications", "webhooks", len(hooks), "instance_id", instance)
+	return notify.New(hooks, notify.Options{
+		InstanceID: instance,
+		QueueSize:  cfg.QueueSize,
+		Retries:    cfg.Retries,
+		Backoff:    cfg.Backoff.Duration(),
+	}, logger)
+}
 
-	return slog.New(handler)
+// dumpConfig prints the effective configuration (defaults merged with the
+// file). Values taken from environment variables are redacted.
+func dumpConfig() {
+	cfgPath, cfgOpts := parseConfigArgs(os.Args[2:])
+
+	cfg, err := config.LoadWithOptions(cfgPath, cfgOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, w := range cfg.Warnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
+	out, err := cfg.Dump()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(out)
 }
 
 func printUsage() {
@@ -141,19 +729,56 @@ Usage:
 
 Commands:
   serve [config]   Start the server (default config: maboo.yaml)
+                   --no-strict  warn about unknown config keys instead of failing
+                   --dev  development preset (watch, debug logs, error details)
   start [config]   Alias for serve
-  version          Show version
+  dev [config]     Alias for serve --dev
+  config [config]  Print the effective configuration (env values redacted)
+  check [config]   Validate the config and the files, ports and PHP setup it references
+                   --config <path>  config file   --json  machine-readable output
+                   --no-strict  treat unknown keys as warnings
+  init [path]      Write a starter maboo.yaml for the project in this directory
+                   --framework <name>  skip detection (laravel, symfony, wordpress, drupal, generic)
+                   --force  overwrite an existing file   --stdout  print instead of writing
+  doctor           Diagnose the host: PHP, extensions, ports, permissions, clock, fd limits
+                   --config <path>  config file   --json  machine-readable   exit 1 = a check failed
+                   extensions are checked by file until the engine can evaluate PHP
+  status           Show health, workers, PHP version and uptime of the running server
+                   --addr <host:port>  admin API (default: admin.address, else the health endpoint)
+                   --json  machine-readable   exit 1 = not ready, 3 = unreachable
+  healthcheck      Exit 0 if the server is ready, else print why and exit 1 (for HEALTHCHECK)
+                   --url <url>  (default: /readyz on server.address)   --socket <path>  unix socket
+                   --timeout <dur>  (default 2s)   --insecure  accept self-signed certificates
+  reload           Gracefully reload the workers of the running server (SIGUSR1)
+  stop             Stop the running server and wait for it to exit (SIGTERM)
+                   --pidfile <path>  pid file (default: server.pid_file from --config maboo.yaml)
+                   --timeout <dur>   stop: how long to wait (default 30s)
+                   exit status 3 means no running server was found
+  bench            Load the running server and report latency, RPS, errors and pool stats
+                   --url <url>  target (default: server.address)   --path <path>  repeatable
+                   -c <n>  connections   --duration <dur>   --body-size <bytes>   --json
+                   --in-process  load maboo started here with a mock PHP engine
+                   --max-p99 <dur>  --max-error-rate <0-1>  --min-rps <n>  exit 1 when not met
+  upgrade          Replace the running binary without dropping connections (needs admin)
+                   --addr <host:port>  admin API   exit 1 = failed, old process still serving
+  version          Show version, commit, build date, Go and bundled PHP versions
+                   --json  machine-readable
   help             Show this help
 
 Signals:
+  SIGHUP           Reload maboo.yaml (hot settings applied in place)
   SIGUSR1          Graceful worker reload (zero-downtime)
+  SIGUSR2          Reopen log files (after logrotate)
   SIGINT/SIGTERM   Graceful shutdown
+  SIGQUIT          Immediate shutdown, logging busy workers and goroutine stacks
 
 Examples:
+  maboo init
   maboo serve
   maboo serve /etc/maboo/maboo.yaml
-  maboo version
-  kill -USR1 $(pidof maboo)   # Reload workers
+  maboo version --json
+  maboo bench -c 50 --duration 30s --path / --path /api/users
+  maboo reload --pidfile /run/maboo.pid   # Reload workers
 
 Embedded PHP Version: 7.4, 8.0, 8.1, 8.2, 8.3, 8.4`)
 }
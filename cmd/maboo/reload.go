@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/jobs"
+	"github.com/sadewadee/maboo/internal/logging"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/server"
+)
+
+// reloader applies a re-read config file to the running process.
+type reloader struct {
+	path   string
+	opts   config.Options
+	preset func(*config.Config) map[string]string // serve --dev overrides, reapplied on every reload
+	cfg    *config.Config                         // effective running config
+	logs   *logging.Logger
+	access *logging.Logger // same as logs unless logging.access_output is set
+	logger *slog.Logger
+	pool   server.Pool
+	srv    *server.Server
+	jobs   *jobs.Manager // restarted after the workers; nil without jobs
+	watch  *fileWatch    // restarted when the watch section changes
+}
+
+// reload loads and validates the config file, then applies whatever can
+// change without a restart. A config that fails to load, one moving
+// app.root or app.entry to a script that does not exist, or a change the
+// pool cannot take, leaves the running config untouched and is returned
+// as the error. What changed is added to params for the audit log.
+func (r *reloader) reload(params map[string]any) error {
+	params["path"] = r.path
+	next, err := loadConfig(r.path, r.opts, r.preset)
+	if err != nil {
+		r.logger.Error("config reload rejected", "path", r.path, "error", err)
+		return err
+	}
+	logConfigWarnings(r.logger, next)
+
+	changes := config.Diff(r.cfg, next)
+	if len(changes) == 0 {
+		r.logger.Info("config reload: no changes")
+		return nil
+	}
+
+	var applied, restart []string
+	reloadWorkers := false
+	for _, c := range changes {
+		switch c.Kind {
+		case config.ChangeHot:
+			applied = append(applied, c.Path)
+		case config.ChangeWorkerReload:
+			applied = append(applied, c.Path)
+			reloadWorkers = true
+		default:
+			restart = append(restart, c.Path)
+		}
+	}
+
+	effective := config.Reloadable(r.cfg, next)
+	if effective.App.Root != r.cfg.App.Root || effective.App.Entry != r.cfg.App.Entry {
+		if _, err := phpengine.CheckEntryPoint(effective.App.Root, effective.App.Entry); err != nil {
+			r.logger.Error("config reload rejected", "path", r.path, "error", err)
+			return err
+		}
+	}
+	if err := r.pool.SetConfig(effective); err != nil {
+		r.logger.Error("config reload rejected", "path", r.path, "error", err)
+		return err
+	}
+	r.srv.UpdateConfig(effective)
+	if r.watch != nil {
+		r.watch.apply(effective)
+	}
+	r.srv.RecordReload()
+	r.logs.Configure(effective.Logging.Level, effective.Logging.Format)
+	if r.access != r.logs {
+		r.access.Configure(effective.Logging.Level, effective.Logging.Format)
+	}
+	r.cfg = effective
+
+	params["applied"], params["restart_required"], params["workers_reloaded"] = applied, restart, reloadWorkers
+	if reloadWorkers {
+		if err = r.srv.ReloadWorkerGroup(""); err != nil {
+			r.logger.Error("worker reload after config change failed", "error", err)
+			err = fmt.Errorf("config applied, but reloading the workers failed: %w", err)
+		} else {
+			if r.jobs != nil {
+				r.jobs.Restart()
+			}
+			r.srv.RetrySelfTest(context.Background())
+		}
+	}
+
+	r.logger.Info("config reloaded",
+		"applied", applied,
+		"restart_required", restart,
+		"workers_reloaded", reloadWorkers,
+	)
+	return err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/server"
+	"github.com/sadewadee/maboo/internal/worker"
+)
+
+const (
+	// shutdownTimeout bounds a graceful shutdown: draining the server,
+	// then stopping the pools behind it.
+	shutdownTimeout = 30 * time.Second
+	// shutdownGrace is how long after shutdownTimeout the watchdog gives
+	// the pools, which only start stopping once the server gives up.
+	shutdownGrace = 2 * time.Second
+	// diagnosticsWait bounds how long the diagnostics wait for a pool to
+	// report its workers; a stuck pool may hold its own locks.
+	diagnosticsWait = 5 * time.Second
+)
+
+// busyPool is a Pool that reports the workers serving a request.
+type busyPool interface {
+	Busy() []worker.Dump
+}
+
+// abortPool is a Pool whose workers can be killed without waiting.
+type abortPool interface {
+	Abort()
+}
+
+// diagnostics logs what the process is doing when it does not shut down
+// gracefully: what the pools' busy workers are serving and for how long,
+// then the stack of every goroutine.
+type diagnostics struct {
+	logger *slog.Logger
+	pool   server.Pool
+	ws     []*pool.Pool
+}
+
+// log logs the diagnostics, reason saying why they were taken. The
+// workers of pools that do not answer within diagnosticsWait are left
+// out.
+func (d *diagnostics) log(reason string) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.logWorkers(reason)
+	}()
+	select {
+	case <-done:
+	case <-time.After(diagnosticsWait):
+		d.logger.Error("worker state unavailable", "reason", reason, "waited", diagnosticsWait.String())
+	}
+
+	var stacks bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&stacks, 2)
+	d.logger.Error("goroutine stacks", "reason", reason, "goroutines", runtime.NumGoroutine(), "stacks", stacks.String())
+}
+
+func (d *diagnostics) logWorkers(reason string) {
+	stats := d.pool.Stats()
+	d.logger.Error("worker pool state", "reason", reason, "pool", "http", "mode", d.pool.Mode(),
+		"workers", stats.TotalWorkers(), "busy", stats.BusyWorkers())
+	if p, ok := d.pool.(busyPool); ok {
+		for _, w := range p.Busy() {
+			d.logger.Error("worker busy", "reason", reason, "pool", "http", "worker_id", w.ID, "state", w.State,
+				"method", w.Request.Method, "path", w.Request.Path, "request_id", w.Request.RequestID,
+				"busy_for", time.Since(w.Request.Started).Round(time.Millisecond).String())
+		}
+	}
+	for _, p := range d.ws {
+		stats := p.Stats()
+		d.logger.Error("worker pool state", "reason", reason, "pool", "websocket",
+			"workers", stats.TotalWorkers, "busy", stats.BusyWorkers)
+		for _, w := range p.Busy() {
+			args := []any{"reason", reason, "pool", "websocket", "worker_id", w.ID, "state", w.State.String(),
+				"busy_for", time.Since(w.Started).Round(time.Millisecond).String()}
+			if w.Request != nil {
+				args = append(args, "method", w.Request.Method, "path", w.Request.URI)
+			}
+			d.logger.Error("worker busy", args...)
+		}
+	}
+}
+
+// abort kills the worker processes of every pool, for an immediate
+// shutdown. Embedded workers end with the process.
+func (d *diagnostics) abort() {
+	if p, ok := d.pool.(abortPool); ok {
+		p.Abort()
+	}
+	for _, p := range d.ws {
+		p.Abort()
+	}
+}
+
+// watch calls exit, after logging the diagnostics, if the graceful
+// shutdown under ctx has not closed done shutdownGrace after ctx ends.
+// A supervisor would otherwise kill the process without a word.
+func (d *diagnostics) watch(ctx context.Context, done <-chan struct{}, exit func()) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	select {
+	case <-done:
+		return
+	case <-time.After(shutdownGrace):
+	}
+	d.logger.Error("shutdown did not finish in time, exiting", "timeout", (shutdownTimeout + shutdownGrace).String())
+	d.log("shutdown timeout")
+	exit()
+}
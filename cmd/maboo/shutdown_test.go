@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/worker"
+)
+
+// stuckPool has one worker stuck on a request.
+type stuckPool struct {
+	*mockPool
+	since   time.Time
+	aborted bool
+}
+
+func (p *stuckPool) Busy() []worker.Dump {
+	d := worker.Dump{ID: 3, State: "busy", Jobs: 7}
+	d.SetRequest(&worker.Activity{Method: "POST", Path: "/checkout", RequestID: "req-1", Started: p.since})
+	return []worker.Dump{d}
+}
+
+func (p *stuckPool) Abort() { p.aborted = true }
+
+func TestDiagnostics(t *testing.T) {
+	var logs bytes.Buffer
+	pool := &stuckPool{mockPool: newMockPool(2, 0, 0), since: time.Now().Add(-42 * time.Second)}
+	d := &diagnostics{logger: slog.New(slog.NewTextHandler(&logs, nil)), pool: pool}
+	d.log("SIGQUIT")
+	for _, want := range []string{
+		`msg="worker pool state" reason=SIGQUIT pool=http mode=worker workers=2 busy=0`,
+		`msg="worker busy" reason=SIGQUIT pool=http worker_id=3 state=busy method=POST path=/checkout request_id=req-1 busy_for=42`,
+		`msg="goroutine stacks" reason=SIGQUIT`,
+		"TestDiagnostics",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logs.String())
+		}
+	}
+
+	d.abort()
+	if !pool.aborted {
+		t.Error("abort did not reach the pool")
+	}
+}
+
+func TestShutdownWatchdog(t *testing.T) {
+	var logs bytes.Buffer
+	d := &diagnostics{logger: slog.New(slog.NewTextHandler(&logs, nil)), pool: newMockPool(1, 0, 0)}
+
+	// A shutdown that finishes in time is left alone.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	close(done)
+	cancel()
+	d.watch(ctx, done, func() { t.Error("exited after the shutdown finished") })
+
+	// One that does not is cut short, with diagnostics.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	exited := make(chan struct{})
+	start := time.Now()
+	go d.watch(ctx, make(chan struct{}), func() { close(exited) })
+	select {
+	case <-exited:
+	case <-time.After(shutdownGrace + 5*time.Second):
+		t.Fatal("watchdog did not exit")
+	}
+	if elapsed := time.Since(start); elapsed < shutdownGrace {
+		t.Errorf("exited after %s, before the grace period", elapsed)
+	}
+	for _, want := range []string{"shutdown did not finish in time", `msg="goroutine stacks" reason="shutdown timeout"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logs.String())
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/jobs"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/server"
+)
+
+// Exit codes for status, shared with reload and stop where they overlap.
+const (
+	exitUnhealthy   = 1 // the server answered but is not ready
+	exitUnreachable = 3 // nothing answered at the address
+)
+
+// errUnreachable marks transport failures, as opposed to a server that
+// answers and reports itself unhealthy.
+var errUnreachable = errors.New("cannot reach maboo")
+
+// statusTarget is the endpoint maboo status queries.
+type statusTarget struct {
+	url   string
+	token string
+	admin bool // admin /status rather than the health endpoint
+}
+
+// healthBody is the subset of /readyz?verbose=1 used when the admin API is
+// disabled.
+type healthBody struct {
+	Status        string  `json:"status"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	GoVersion     string  `json:"go_version"`
+	Workers       struct {
+		Total    int   `json:"total"`
+		Busy     int   `json:"busy"`
+		Idle     int   `json:"idle"`
+		Requests int64 `json:"requests"`
+	} `json:"workers"`
+	Groups  []server.PoolStatus  `json:"groups"`
+	Checks  []server.ProbeStatus `json:"checks"`
+	Watcher *pool.WatcherStatus  `json:"watcher"`
+	Jobs    []jobs.Status        `json:"jobs"`
+}
+
+// runStatus prints the state of the running server and returns the exit
+// code: 0 when ready, exitUnhealthy when not, exitUnreachable when the
+// server cannot be reached.
+func runStatus(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	cfgPath := fs.String("config", "maboo.yaml", "config file naming the admin or server address")
+	addr := fs.String("addr", "", "admin API address (overrides the config)")
+	token := fs.String("token", "", "admin API token (default: admin.token from the config)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	timeout := fs.Duration("timeout", 5*time.Second, "request timeout")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	cfg := config.Default()
+	if _, err := os.Stat(*cfgPath); err == nil {
+		if cfg, err = config.LoadWithOptions(*cfgPath, config.Options{NonStrict: true}); err != nil {
+			fmt.Fprintf(errOut, "Error: %v\n", err)
+			return exitUsage
+		}
+	}
+	target := resolveStatusTarget(cfg, *addr, *token)
+
+	report, err := fetchStatus(target, cfg, *timeout)
+	switch {
+	case errors.Is(err, errUnreachable):
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitUnreachable
+	case err != nil:
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printStatus(out, report, target.admin)
+	}
+	if report.Status != "ready" {
+		return exitUnhealthy
+	}
+	return 0
+}
+
+func resolveStatusTarget(cfg *config.Config, addr, token string) statusTarget {
+	if token == "" {
+		token = cfg.Admin.Token.Value()
+	}
+	if addr == "" && cfg.Admin.Enabled {
+		addr = cfg.Admin.Address
+	}
+	if addr != "" {
+		return statusTarget{url: "http://" + dialAddress(addr) + "/status", token: token, admin: true}
+	}
+	return statusTarget{url: serverURL(cfg) + "/readyz?verbose=1"}
+}
+
+// serverURL is the base URL a local client reaches the main listener at.
+func serverURL(cfg *config.Config) string {
+	scheme := "http"
+	t := cfg.Server.TLS
+	if t.Auto || (t.Cert != "" && t.Key != "") || t.ACME.Email != "" {
+		scheme = "https"
+	}
+	return scheme + "://" + dialAddress(cfg.Server.Address)
+}
+
+// dialAddress turns a listen address into one a local client can dial.
+func dialAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func fetchStatus(target statusTarget, cfg *config.Config, timeout time.Duration) (*server.StatusReport, error) {
+	client := &http.Client{
+		Timeout: timeout,
+		// The health endpoint is dialled by IP on the local host, where the
+		// certificate name cannot match; status is a read-only probe.
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	req, err := http.NewRequest("GET", target.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if target.token != "" {
+		req.Header.Set("Authorization", "Bearer "+target.token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusServiceUnavailable:
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("%s: unauthorized (check admin.token or --token)", target.url)
+	default:
+		return nil, fmt.Errorf("%s: unexpected status %s", target.url, resp.Status)
+	}
+
+	if target.admin {
+		var report server.StatusReport
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			return nil, fmt.Errorf("%s: invalid response: %w", target.url, err)
+		}
+		return &report, nil
+	}
+
+	var h healthBody
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		return nil, fmt.Errorf("%s: invalid response: %w", target.url, err)
+	}
+	engine := "embedded"
+	if cfg.PHP.Binary != "" {
+		engine = cfg.PHP.Binary
+	}
+	report := &server.StatusReport{
+		Status:        h.Status,
+		UptimeSeconds: h.UptimeSeconds,
+		Listeners:     []server.Listener{{Name: "http", Address: cfg.Server.Address}},
+		PHP: server.PHPStatus{
+			Version: phpengine.SelectVersion(cfg.App.Root, cfg.PHP.Version),
+			Engine:  engine,
+			Mode:    cfg.PHP.Mode,
+		},
+		GoVersion: h.GoVersion,
+		Pools:     h.Groups,
+		Checks:    h.Checks,
+		Watcher:   h.Watcher,
+		Jobs:      h.Jobs,
+	}
+	if report.Pools == nil { // a server predating per-group health
+		report.Pools = []server.PoolStatus{{
+			Name:     "default",
+			Workers:  h.Workers.Total,
+			Busy:     h.Workers.Busy,
+			Idle:     h.Workers.Idle,
+			Requests: h.Workers.Requests,
+		}}
+	}
+	return report, nil
+}
+
+func printStatus(out io.Writer, r *server.StatusReport, admin bool) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	version := r.Version
+	if version == "" {
+		version = "unknown"
+	}
+	fmt.Fprintf(tw, "status\t%s\n", r.Status)
+	fmt.Fprintf(tw, "version\tmaboo %s, %s\n", version, r.GoVersion)
+	fmt.Fprintf(tw, "uptime\t%s\n", (time.Duration(r.UptimeSeconds) * time.Second).String())
+	if r.PID != 0 {
+		fmt.Fprintf(tw, "pid\t%d\n", r.PID)
+	}
+	listeners := make([]string, len(r.Listeners))
+	for i, l := range r.Listeners {
+		listeners[i] = l.Name + " " + l.Address
+	}
+	fmt.Fprintf(tw, "listeners\t%s\n", strings.Join(listeners, ", "))
+	fmt.Fprintf(tw, "php\t%s (%s, %s mode)\n", r.PHP.Version, r.PHP.Engine, r.PHP.Mode)
+	if admin {
+		reload := "never"
+		if r.LastReload != nil {
+			reload = fmt.Sprintf("%s (%s ago)", r.LastReload.Format(time.RFC3339), time.Since(*r.LastReload).Round(time.Second))
+		}
+		fmt.Fprintf(tw, "last reload\t%s\n", reload)
+		fmt.Fprintf(tw, "connections\t%d open\n", r.Connections.Open)
+	}
+	if r.WebSocket != nil {
+		fmt.Fprintf(tw, "websocket\t%d connections, %d rooms\n", r.WebSocket.Connections, r.WebSocket.Rooms)
+	}
+	if w := r.Watcher; w != nil {
+		state := "stopped"
+		if w.Running {
+			state = "running"
+		}
+		fmt.Fprintf(tw, "watcher\t%s (%s), %d files, %d pending changes\n", state, w.Backend, w.TrackedFiles, w.PendingEvents)
+		if w.LastReload != nil {
+			fmt.Fprintf(tw, "  reloaded\t%s ago: %s\n", time.Since(*w.LastReload).Round(time.Second), strings.Join(w.LastReloadPaths, ", "))
+		}
+		if w.LastError != "" {
+			fmt.Fprintf(tw, "  error\t%s\n", w.LastError)
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintln(out)
+	tw = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POOL\tWORKERS\tBUSY\tIDLE\tREQUESTS\tSTATE\tVHOST")
+	for _, p := range r.Pools {
+		state, vhost := p.Status, p.VHost
+		if state == "" {
+			state = "-"
+		}
+		if vhost == "" {
+			vhost = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t%s\n", p.Name, p.Workers, p.Busy, p.Idle, p.Requests, state, vhost)
+	}
+	tw.Flush()
+
+	if len(r.Checks) > 0 {
+		fmt.Fprintln(out)
+		tw = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHECK\tTYPE\tSTATE\tLATENCY\tERROR")
+		for _, c := range r.Checks {
+			state := "up"
+			if !c.Healthy {
+				state = "down"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.1fms\t%s\n", c.Name, c.Type, state, c.LatencyMS, c.LastError)
+		}
+		tw.Flush()
+	}
+	if len(r.Jobs) > 0 {
+		fmt.Fprintln(out)
+		tw = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "JOB\tRUNNING\tRESTARTS\tLAST EXIT")
+		for _, j := range r.Jobs {
+			last := "-"
+			var at time.Time
+			for _, p := range j.Processes {
+				if p.LastExitAt != nil && p.LastExitAt.After(at) {
+					at = *p.LastExitAt
+					last = fmt.Sprintf("%d (%s ago)", *p.LastExitCode, time.Since(at).Round(time.Second))
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%d/%d\t%d\t%s\n", j.Name, j.Running, j.Count, j.Restarts, last)
+		}
+		tw.Flush()
+	}
+	if !admin {
+		fmt.Fprintln(out, "\n(admin API disabled: enable admin for version, reload and websocket details)")
+	}
+}
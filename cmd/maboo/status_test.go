@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/jobs"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/server"
+)
+
+func serveJSON(t *testing.T, path string, code int, body any) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+func TestStatusAdmin(t *testing.T) {
+	exitCode, exitedAt := 255, time.Now().Add(-time.Minute)
+	report := server.StatusReport{
+		Status:    "ready",
+		Version:   "1.2.3",
+		Listeners: []server.Listener{{Name: "http", Address: ":8080"}},
+		PHP:       server.PHPStatus{Version: "8.3", Engine: "embedded", Mode: "worker"},
+		Pools:     []server.PoolStatus{{Name: "default", Workers: 4, Idle: 4, Requests: 10}},
+		WebSocket: &server.WebSocketStatus{Connections: 7, Rooms: 2},
+		Watcher: &pool.WatcherStatus{Running: true, Backend: "fsnotify", TrackedFiles: 120,
+			LastError: "inotify limit fs.inotify.max_user_watches (currently 8192) reached"},
+		Jobs: []jobs.Status{{Name: "queue", Count: 2, Running: 1, Restarts: 3, Processes: []jobs.ProcessStatus{
+			{State: jobs.StateRunning, PID: 41},
+			{State: jobs.StateBackoff, Restarts: 3, LastExitCode: &exitCode, LastExitAt: &exitedAt},
+		}}},
+	}
+	addr := serveJSON(t, "/status", http.StatusOK, report)
+
+	var out, errOut bytes.Buffer
+	if code := runStatus([]string{"--config", "missing.yaml", "--addr", addr}, &out, &errOut); code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut.String())
+	}
+	for _, want := range []string{"ready", "maboo 1.2.3", "8.3 (embedded, worker mode)", "last reload  never", "7 connections, 2 rooms", "running (fsnotify), 120 files", "max_user_watches", "default  4", "queue  1/2      3         255 (1m0s ago)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	runStatus([]string{"--config", "missing.yaml", "--addr", addr, "--json"}, &out, &errOut)
+	var got server.StatusReport
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil || got.Version != "1.2.3" {
+		t.Errorf("--json output %q: %v", out.String(), err)
+	}
+}
+
+func TestStatusExitCodes(t *testing.T) {
+	unhealthy := serveJSON(t, "/status", http.StatusServiceUnavailable, server.StatusReport{Status: "not_ready"})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		name string
+		addr string
+		want int
+	}{
+		{"unhealthy", unhealthy, exitUnhealthy},
+		{"unreachable", closed, exitUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			if code := runStatus([]string{"--config", "missing.yaml", "--addr", tt.addr}, &out, &errOut); code != tt.want {
+				t.Errorf("exit code = %d, want %d (stderr %q)", code, tt.want, errOut.String())
+			}
+		})
+	}
+}
+
+func TestStatusFallsBackToHealth(t *testing.T) {
+	addr := serveJSON(t, "/readyz", http.StatusOK, map[string]any{
+		"status":         "ready",
+		"uptime_seconds": 90,
+		"workers":        map[string]any{"total": 3, "busy": 1, "idle": 2, "requests": 5},
+	})
+	cfgPath := filepath.Join(t.TempDir(), "maboo.yaml")
+	if err := os.WriteFile(cfgPath, []byte("server:\n  address: "+addr+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errOut bytes.Buffer
+	if code := runStatus([]string{"--config", cfgPath}, &out, &errOut); code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut.String())
+	}
+	if !strings.Contains(out.String(), "1m30s") || !strings.Contains(out.String(), "default  3        1     2     5") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
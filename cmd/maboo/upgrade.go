@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/server"
+)
+
+// runUpgrade asks the running server, through the admin API, to exec the
+// maboo binary now on disk and hand its listeners over. Exit codes follow
+// status: 1 when the upgrade failed (the old process keeps serving), 2 for
+// usage, 3 when the server cannot be reached.
+func runUpgrade(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	cfgPath := fs.String("config", "maboo.yaml", "config file naming the admin address")
+	addr := fs.String("addr", "", "admin API address (overrides the config)")
+	token := fs.String("token", "", "admin API token (default: admin.token from the config)")
+	timeout := fs.Duration("timeout", upgradeTimeout+10*time.Second, "how long to wait for the new process")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	cfg := config.Default()
+	if _, err := os.Stat(*cfgPath); err == nil {
+		if cfg, err = config.LoadWithOptions(*cfgPath, config.Options{NonStrict: true}); err != nil {
+			fmt.Fprintf(errOut, "Error: %v\n", err)
+			return exitUsage
+		}
+	}
+	target := resolveStatusTarget(cfg, *addr, *token)
+	if !target.admin {
+		fmt.Fprintln(errOut, "Error: maboo upgrade needs the admin API (set admin.enabled or pass --addr)")
+		return exitUsage
+	}
+	url := strings.TrimSuffix(target.url, "/status") + "/upgrade"
+
+	result, err := postUpgrade(url, target.token, *timeout)
+	switch {
+	case errors.Is(err, errUnreachable):
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitUnreachable
+	case err != nil:
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+	fmt.Fprintf(out, "maboo upgraded: new process %d is serving, the previous one is draining\n", result.PID)
+	return 0
+}
+
+func postUpgrade(url, token string, timeout time.Duration) (*server.UpgradeResult, error) {
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("User-Agent", server.CLIUserAgent)
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("upgrade failed (%s): %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result server.UpgradeResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%s: invalid response: %w", url, err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sadewadee/maboo/internal/buildinfo"
+)
+
+// runVersion prints what this binary is: the version on the first line,
+// then the build details bug reports need. --json prints buildinfo.Info.
+func runVersion(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	asJSON := fs.Bool("json", false, "print machine-readable JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	info := buildinfo.Get()
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+		return 0
+	}
+	printVersion(out, info)
+	return 0
+}
+
+func printVersion(out io.Writer, info buildinfo.Info) {
+	fmt.Fprintf(out, "maboo v%s\n", info.Version)
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	commit := orUnknown(info.Commit)
+	if info.Modified {
+		commit += " (modified)"
+	}
+	fmt.Fprintf(tw, "  commit\t%s\n", commit)
+	fmt.Fprintf(tw, "  built\t%s\n", orUnknown(info.Date))
+	fmt.Fprintf(tw, "  go\t%s\n", info.GoVersion)
+	if info.PHPEmbed {
+		fmt.Fprintf(tw, "  php_embed\tyes\n")
+	} else {
+		fmt.Fprintf(tw, "  php_embed\tno (placeholder engine)\n")
+	}
+	php := strings.Join(info.PHPVersions, ", ")
+	if php == "" {
+		php = "none found"
+	}
+	fmt.Fprintf(tw, "  php\t%s\n", php)
+	tw.Flush()
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
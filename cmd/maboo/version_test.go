@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/buildinfo"
+)
+
+func TestVersionJSON(t *testing.T) {
+	var out bytes.Buffer
+	if code := runVersion([]string{"--json"}, &out, &out); code != 0 {
+		t.Fatalf("exit %d: %s", code, out.String())
+	}
+	var info buildinfo.Info
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		t.Fatalf("decoding %q: %v", out.String(), err)
+	}
+	if info.Version == "" || info.GoVersion == "" || info.PHPVersions == nil {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestPrintVersion(t *testing.T) {
+	var out bytes.Buffer
+	printVersion(&out, buildinfo.Info{Version: "1.2.0", Commit: "abc123", Modified: true, GoVersion: "go1.25.1"})
+	got := out.String()
+	// The first line stays the plain version for scripts.
+	if first, _, _ := strings.Cut(got, "\n"); first != "maboo v1.2.0" {
+		t.Errorf("first line = %q", first)
+	}
+	for _, want := range []string{"abc123 (modified)", "built      unknown", "no (placeholder engine)", "none found"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/pool"
+)
+
+// fileWatch runs the watch section's file watcher, and replaces it when a
+// config reload changes the section.
+type fileWatch struct {
+	logger   *slog.Logger
+	onChange func(action pool.Action, paths []string)
+	attach   func(*pool.Watcher) // reports the watcher, nil while there is none
+
+	mu      sync.Mutex
+	cfg     config.WatchConfig // in force, with the directories defaulted
+	watcher *pool.Watcher      // nil while watching is off or failed to start
+}
+
+// watchConfig returns cfg's watch section, watching app.root when it
+// names no directories.
+func watchConfig(cfg *config.Config) config.WatchConfig {
+	wc := cfg.Watch
+	if len(wc.Dirs) == 0 {
+		wc.Dirs = []string{cfg.App.Root}
+	}
+	return wc
+}
+
+// apply starts the watcher cfg asks for, stopping the running one first
+// when it differs. A watcher that fails to start is reported with its
+// error; files are then not watched until the next reload.
+func (fw *fileWatch) apply(cfg *config.Config) {
+	wc := watchConfig(cfg)
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.watcher != nil && reflect.DeepEqual(wc, fw.cfg) {
+		return
+	}
+	if fw.watcher != nil {
+		fw.watcher.Stop()
+		fw.watcher = nil
+		fw.logger.Info("file watcher stopped for the new watch settings")
+	}
+	fw.cfg = wc
+	if !wc.Enabled {
+		fw.attach(nil)
+		return
+	}
+	watcher, err := pool.NewWatcher(wc, fw.logger, fw.onChange)
+	if err != nil {
+		fw.logger.Error("file watcher failed to start, files are not watched", "error", err)
+		fw.attach(nil)
+		return
+	}
+	if err := watcher.Start(); err != nil {
+		fw.logger.Error("file watcher failed to start, files are not watched", "backend", wc.Backend, "error", err)
+	} else {
+		fw.watcher = watcher
+	}
+	// Reported even when it failed to start, with the error.
+	fw.attach(watcher)
+}
+
+// stop stops the running watcher, if any.
+func (fw *fileWatch) stop() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.watcher != nil {
+		fw.watcher.Stop()
+		fw.watcher = nil
+	}
+}
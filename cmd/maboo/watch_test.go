@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/pool"
+)
+
+func TestFileWatchApply(t *testing.T) {
+	var attached *pool.Watcher
+	fw := &fileWatch{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		onChange: func(pool.Action, []string) {},
+		attach:   func(w *pool.Watcher) { attached = w },
+	}
+	defer fw.stop()
+	cfg := config.Default()
+	cfg.App.Root = t.TempDir()
+	cfg.Watch.Enabled = true
+	cfg.Watch.Backend = pool.BackendPoll
+
+	fw.apply(cfg)
+	first := attached
+	if first == nil || !first.Status().Running {
+		t.Fatal("no running watcher after apply")
+	}
+	if dirs := first.Status().Dirs; len(dirs) != 1 || dirs[0] != cfg.App.Root {
+		t.Errorf("watching %v, want app.root", dirs)
+	}
+
+	// Unchanged settings keep the watcher.
+	fw.apply(cfg)
+	if attached != first {
+		t.Error("watcher replaced without a change")
+	}
+
+	// New ones replace it.
+	cfg.Watch.Interval = config.Duration(time.Minute)
+	fw.apply(cfg)
+	if attached == first || attached == nil || !attached.Status().Running {
+		t.Fatal("watcher not replaced for a new interval")
+	}
+	if first.Status().Running {
+		t.Error("replaced watcher still running")
+	}
+
+	second := attached
+	cfg.Watch.Enabled = false
+	fw.apply(cfg)
+	if attached != nil || second.Status().Running {
+		t.Error("watcher kept after watch.enabled was turned off")
+	}
+}
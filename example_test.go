@@ -0,0 +1,116 @@
+package maboo_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sadewadee/maboo"
+)
+
+// newApp writes a one-page PHP application to a temporary directory.
+func newApp() string {
+	dir, err := os.MkdirTemp("", "maboo-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.php"), []byte("<?php echo 'hello';\n"), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	return dir
+}
+
+func ExampleEngine() {
+	root := newApp()
+	defer os.RemoveAll(root)
+
+	engine, err := maboo.NewEngine("8.3")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := engine.Startup(); err != nil {
+		log.Fatal(err)
+	}
+	defer engine.Shutdown()
+
+	req := httptest.NewRequest("GET", "/?name=maboo", nil)
+	ctx := maboo.NewContext(req, root, "index.php")
+	resp, err := engine.Execute(ctx, ctx.ScriptFilename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(resp.Status, resp.Headers["Content-Type"])
+	// Output: 200 text/html; charset=utf-8
+}
+
+func ExamplePool_Handler() {
+	root := newApp()
+	defer os.RemoveAll(root)
+
+	pool, err := maboo.NewPool(
+		maboo.WithRoot(root),
+		maboo.WithEntry("index.php"),
+		maboo.WithWorkers(1, 4),
+		maboo.WithRequestTimeout(10*time.Second),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := pool.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Stop()
+
+	mux := http.NewServeMux()
+	mux.Handle("/php/", http.StripPrefix("/php", pool.Handler()))
+	mux.HandleFunc("/go", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "served by Go")
+	})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/php/", nil))
+	fmt.Println(w.Code, w.Header().Get("X-Request-ID") != "")
+	// Output: 200 true
+}
+
+func ExamplePool_Invoke() {
+	root := newApp()
+	defer os.RemoveAll(root)
+
+	pool, err := maboo.NewPool(maboo.WithRoot(root), maboo.WithWorkers(1, 1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := pool.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := pool.Invoke(ctx, maboo.Request{
+		Entry:  "index.php",
+		Method: "POST",
+		Headers: map[string]string{
+			"Content-Type": "application/x-www-form-urlencoded",
+		},
+		Body:   []byte("task=warmup"),
+		Caller: "warmup",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(resp.Status, pool.Stats().Requests)
+	// Output: 200 1
+}
+
+func ExampleNewPool() {
+	_, err := maboo.NewPool(maboo.WithWorkers(0, 4))
+	fmt.Println(err)
+	// Output: maboo: pool.min_workers must be >= 1, got 0
+}
@@ -0,0 +1,181 @@
+// Package audit records administrative actions: reloads, config changes,
+// log reopening, upgrades, limit changes, cache purges, test notifications,
+// PHP evaluated through the admin API and shutdowns. Every
+// such action runs through Log.Do, whichever of signals, the admin API, the
+// CLI or the file watcher asked for it, so each one leaves a record of who
+// asked, with what, how it went and how long it took. Records go to the
+// audit log output and the most recent are kept for the admin API.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var actionsTotal = metrics.NewCounterVec("maboo_audit_actions_total",
+	"Administrative actions by action and result (ok, error).", "action", "result")
+
+// Kinds of actor.
+const (
+	ActorSignal  = "signal"  // a signal, e.g. from maboo reload or kill; the ID names it
+	ActorAdmin   = "admin"   // an admin API request; the ID identifies its token
+	ActorCLI     = "cli"     // the maboo CLI, through the admin API; the ID identifies its token
+	ActorWatcher = "watcher" // the file watcher
+	ActorSystem  = "system"  // maboo itself, e.g. shutting down after handing over to a new process
+)
+
+// Actions.
+const (
+	ActionReloadWorkers      = "reload_workers"
+	ActionReloadConfig       = "reload_config"
+	ActionReopenLogs         = "reopen_logs"
+	ActionUpgrade            = "upgrade"
+	ActionShutdown           = "shutdown"
+	ActionSetServerLimits    = "set_server_limits"
+	ActionSetWebSocketLimits = "set_websocket_limits"
+	ActionPurgeCache         = "purge_cache"
+	ActionTestNotifications  = "test_notifications"
+	ActionEval               = "eval"
+	ActionDumpWorker         = "dump_worker"
+	ActionKillWorker         = "kill_worker"
+)
+
+// Results of an action.
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+// Actor is who asked for an action.
+type Actor struct {
+	Kind string
+	ID   string // signal name or token ID; "" when there is nothing to tell apart
+}
+
+// Signal is the actor for an action asked for by the signal name, e.g.
+// SIGHUP.
+func Signal(name string) Actor {
+	return Actor{Kind: ActorSignal, ID: name}
+}
+
+// TokenID identifies a bearer token in records without revealing it: the
+// first 8 hex digits of its SHA-256. It is "" for no token.
+func TokenID(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Record is one administrative action.
+type Record struct {
+	Time       time.Time      `json:"time"`
+	Actor      string         `json:"actor"`
+	ActorID    string         `json:"actor_id,omitempty"`
+	Action     string         `json:"action"`
+	Params     map[string]any `json:"params,omitempty"`
+	Result     string         `json:"result"`
+	Error      string         `json:"error,omitempty"`
+	DurationMS float64        `json:"duration_ms"`
+}
+
+// Log runs administrative actions and records them. A nil *Log runs them
+// unrecorded.
+type Log struct {
+	logger *slog.Logger // the audit log output
+
+	mu     sync.Mutex
+	recent []Record // ring of the last keep records
+	next   int      // where the next record goes in recent
+	full   bool
+}
+
+// New returns a Log writing records to logger and keeping the last keep
+// of them for Recent.
+func New(logger *slog.Logger, keep int) *Log {
+	return &Log{logger: logger, recent: make([]Record, keep)}
+}
+
+// Do runs fn as action on behalf of actor and records it, with the error
+// fn returns. params describe what was asked for; fn may add what it
+// found out, such as the number of entries purged. Do returns fn's error.
+func (l *Log) Do(actor Actor, action string, params map[string]any, fn func() error) error {
+	if l == nil {
+		return fn()
+	}
+	start := time.Now()
+	err := fn()
+	rec := Record{
+		Time:       start,
+		Actor:      actor.Kind,
+		ActorID:    actor.ID,
+		Action:     action,
+		Params:     params,
+		Result:     ResultOK,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		rec.Result, rec.Error = ResultError, err.Error()
+	}
+	actionsTotal.WithLabelValues(action, rec.Result).Inc()
+	l.write(rec)
+
+	l.mu.Lock()
+	if len(l.recent) > 0 {
+		l.recent[l.next] = rec
+		l.next = (l.next + 1) % len(l.recent)
+		l.full = l.full || l.next == 0
+	}
+	l.mu.Unlock()
+	return err
+}
+
+func (l *Log) write(rec Record) {
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("actor", rec.Actor),
+		slog.String("action", rec.Action),
+		slog.String("result", rec.Result),
+		slog.Float64("duration_ms", rec.DurationMS),
+	}
+	if rec.ActorID != "" {
+		attrs = append(attrs, slog.String("actor_id", rec.ActorID))
+	}
+	if len(rec.Params) > 0 {
+		attrs = append(attrs, slog.Any("params", rec.Params))
+	}
+	if rec.Error != "" {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", rec.Error))
+	}
+	l.logger.LogAttrs(context.Background(), level, "audit", attrs...)
+}
+
+// Recent returns up to n of the records kept, newest first; all of them
+// for n <= 0.
+func (l *Log) Recent(n int) []Record {
+	if l == nil {
+		return []Record{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	kept := l.next
+	if l.full {
+		kept = len(l.recent)
+	}
+	if n <= 0 || n > kept {
+		n = kept
+	}
+	out := make([]Record, n)
+	for i := range out {
+		out[i] = l.recent[(l.next-1-i+len(l.recent))%len(l.recent)]
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package audit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/audit"
+)
+
+func TestDo(t *testing.T) {
+	var out bytes.Buffer
+	log := audit.New(slog.New(slog.NewJSONHandler(&out, nil)), 2)
+
+	err := log.Do(audit.Signal("SIGUSR1"), audit.ActionReloadWorkers, nil, func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]any{"path": "/blog/*"}
+	err = log.Do(audit.Actor{Kind: audit.ActorAdmin, ID: audit.TokenID("s3cret")}, audit.ActionPurgeCache, params, func() error {
+		params["purged"] = 3
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("entry script not found")
+	if err := log.Do(audit.Signal("SIGHUP"), audit.ActionReloadConfig, nil, func() error { return failed }); err != failed {
+		t.Errorf("Do = %v, want fn's error", err)
+	}
+
+	// Two are kept, newest first.
+	recent := log.Recent(0)
+	if len(recent) != 2 || recent[0].Action != audit.ActionReloadConfig || recent[1].Action != audit.ActionPurgeCache {
+		t.Fatalf("Recent = %+v", recent)
+	}
+	if r := recent[0]; r.Result != audit.ResultError || r.Error != failed.Error() || r.Actor != audit.ActorSignal || r.ActorID != "SIGHUP" {
+		t.Errorf("failed reload: %+v", r)
+	}
+	if r := recent[1]; r.Result != audit.ResultOK || r.Params["purged"] != 3 || r.ActorID != audit.TokenID("s3cret") {
+		t.Errorf("purge: %+v", r)
+	}
+	if got := log.Recent(1); len(got) != 1 || got[0].Action != audit.ActionReloadConfig {
+		t.Errorf("Recent(1) = %+v", got)
+	}
+
+	// Every action reaches the output, tokens never.
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("%d records written, want 3:\n%s", len(lines), out.String())
+	}
+	var purge map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &purge); err != nil {
+		t.Fatal(err)
+	}
+	if purge["msg"] != "audit" || purge["action"] != audit.ActionPurgeCache || purge["actor"] != audit.ActorAdmin {
+		t.Errorf("record = %v", purge)
+	}
+	if strings.Contains(out.String(), "s3cret") {
+		t.Error("token written to the audit log")
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var log *audit.Log
+	ran := false
+	log.Do(audit.Signal("SIGUSR2"), audit.ActionReopenLogs, nil, func() error { ran = true; return nil })
+	if !ran {
+		t.Error("nil Log did not run the action")
+	}
+	if got := log.Recent(10); len(got) != 0 {
+		t.Errorf("Recent = %v", got)
+	}
+}
@@ -0,0 +1,104 @@
+// Package buildinfo describes the running binary: its version, commit,
+// build date, Go toolchain and PHP engine.
+//
+// Release builds stamp the first three with the linker:
+//
+//	go build -ldflags "-X github.com/sadewadee/maboo/internal/buildinfo.Version=1.2.0 \
+//	    -X github.com/sadewadee/maboo/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	    -X github.com/sadewadee/maboo/internal/buildinfo.Date=$(date -u +%FT%TZ)"
+//
+// Unstamped builds fall back to the VCS information the go command records
+// (runtime/debug.ReadBuildInfo).
+package buildinfo
+
+import (
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// devVersion is the version of unstamped builds.
+const devVersion = "0.2.0-dev"
+
+// Set with -ldflags -X.
+var (
+	Version = devVersion
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is what maboo version --json, /health and maboo_build_info report.
+type Info struct {
+	Version     string   `json:"version"`
+	Commit      string   `json:"commit,omitempty"`
+	Modified    bool     `json:"modified,omitempty"` // built from a dirty tree
+	Date        string   `json:"build_date,omitempty"`
+	GoVersion   string   `json:"go_version"`
+	PHPEmbed    bool     `json:"php_embed"`
+	PHPVersions []string `json:"php_versions"`
+}
+
+// Get assembles the Info of the running binary.
+func Get() Info {
+	info := Info{
+		Version:     strings.TrimPrefix(Version, "v"), // git describe tags
+		Commit:      Commit,
+		Date:        Date,
+		GoVersion:   runtime.Version(),
+		PHPEmbed:    phpengine.EmbedCompiled,
+		PHPVersions: phpengine.BundledVersions(),
+	}
+	if info.PHPVersions == nil {
+		info.PHPVersions = []string{} // "php_versions": [] rather than null
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		fromBuildInfo(&info, bi)
+	}
+	return info
+}
+
+// fromBuildInfo fills what the linker flags left unset.
+func fromBuildInfo(info *Info, bi *debug.BuildInfo) {
+	stamped := info.Commit != ""
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if !stamped {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			if !stamped {
+				info.Modified, _ = strconv.ParseBool(s.Value)
+			}
+		}
+	}
+	// go install module@v1.2.3 records the module version. Builds from a
+	// checkout get a pseudo-version, which says less than the commit.
+	if v := bi.Main.Version; Version == devVersion && v != "" && v != "(devel)" && !pseudoVersion.MatchString(v) {
+		info.Version = strings.TrimPrefix(v, "v")
+	}
+}
+
+// pseudoVersion matches v0.0.0-20261018012746-5a270619b342 and the
+// +dirty variants of untagged builds.
+var pseudoVersion = regexp.MustCompile(`-(\d+\.)?\d{14}-[0-9a-f]{12}(\+dirty)?$|\+dirty$`)
+
+// ExportMetric publishes info as the maboo_build_info gauge, whose value is
+// always 1.
+func ExportMetric(info Info) {
+	gauge := metrics.NewGaugeVec("maboo_build_info",
+		"Build information of the running maboo binary; always 1.",
+		"version", "commit", "go_version", "php_embed", "php_versions")
+	gauge.Reset()
+	gauge.WithLabelValues(info.Version, info.Commit, info.GoVersion,
+		strconv.FormatBool(info.PHPEmbed), strings.Join(info.PHPVersions, ",")).Set(1)
+}
@@ -0,0 +1,71 @@
+package buildinfo
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+func TestFromBuildInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Version: "v0.0.0-20261018012746-5a270619b342+dirty"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "5a270619b342"},
+			{Key: "vcs.time", Value: "2026-10-18T01:27:46Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	info := Info{Version: devVersion}
+	fromBuildInfo(&info, bi)
+	if info.Version != devVersion {
+		t.Errorf("version = %q, want the pseudo-version ignored", info.Version)
+	}
+	if info.Commit != "5a270619b342" || !info.Modified || info.Date != "2026-10-18T01:27:46Z" {
+		t.Errorf("info = %+v, want the VCS stamps", info)
+	}
+
+	// Linker-stamped values win over the VCS stamps.
+	stamped := Info{Version: "1.2.0", Commit: "abc123", Date: "2026-01-01T00:00:00Z"}
+	fromBuildInfo(&stamped, bi)
+	if stamped.Commit != "abc123" || stamped.Modified || stamped.Date != "2026-01-01T00:00:00Z" {
+		t.Errorf("stamped info = %+v, want it unchanged", stamped)
+	}
+
+	// go install module@version
+	installed := Info{Version: devVersion}
+	fromBuildInfo(&installed, &debug.BuildInfo{Main: debug.Module{Version: "v1.3.0"}})
+	if installed.Version != "1.3.0" {
+		t.Errorf("installed version = %q, want 1.3.0", installed.Version)
+	}
+}
+
+func TestExportMetric(t *testing.T) {
+	ExportMetric(Info{Version: "1.2.0", Commit: "abc123", GoVersion: "go1.25.1", PHPVersions: []string{"8.2", "8.3"}})
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "maboo_build_info" {
+			continue
+		}
+		if len(f.Metric) != 1 || f.Metric[0].GetGauge().GetValue() != 1 {
+			t.Fatalf("maboo_build_info = %v, want one series with value 1", f.Metric)
+		}
+		labels := map[string]string{}
+		for _, l := range f.Metric[0].Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		want := map[string]string{"version": "1.2.0", "commit": "abc123", "go_version": "go1.25.1",
+			"php_embed": "false", "php_versions": "8.2,8.3"}
+		for k, v := range want {
+			if labels[k] != v {
+				t.Errorf("label %s = %q, want %q", k, labels[k], v)
+			}
+		}
+		return
+	}
+	t.Error("maboo_build_info not registered")
+}
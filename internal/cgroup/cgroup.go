@@ -0,0 +1,145 @@
+// Package cgroup reads the CPU and memory limits a container runtime puts
+// on the process, so GOMAXPROCS and the worker pool can be sized to fit
+// them rather than to the whole host.
+package cgroup
+
+import (
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Limits are the cgroup limits that apply to the process. A zero field
+// means no limit was found.
+type Limits struct {
+	Version int     `json:"cgroup_version,omitempty"` // 1 or 2
+	CPU     float64 `json:"cpu,omitempty"`            // CPUs' worth of quota
+	Memory  int64   `json:"memory_bytes,omitempty"`
+}
+
+// unlimitedMemory is the cutoff above which a cgroup v1 memory limit is
+// the kernel's "no limit" value, a page-rounded MaxInt64.
+const unlimitedMemory = 1 << 62
+
+// Detect reads the limits of the cgroup the process runs in. Outside a
+// cgroup, or on systems without one, it returns zero Limits.
+func Detect() Limits {
+	return detect("/")
+}
+
+// detect reads /proc and /sys/fs/cgroup relative to root.
+func detect(root string) Limits {
+	data, err := os.ReadFile(filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		return Limits{}
+	}
+	mount := filepath.Join(root, "sys/fs/cgroup")
+
+	var cpuPath, memPath string
+	v1 := false
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return detectV2(mount, parts[2])
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			switch c {
+			case "cpu":
+				cpuPath, v1 = parts[2], true
+			case "memory":
+				memPath, v1 = parts[2], true
+			}
+		}
+	}
+	if !v1 {
+		return Limits{}
+	}
+
+	l := Limits{Version: 1}
+	if cpuPath != "" {
+		walk(filepath.Join(mount, "cpu"), cpuPath, func(dir string) {
+			quota, ok1 := readInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+			period, ok2 := readInt(filepath.Join(dir, "cpu.cfs_period_us"))
+			if ok1 && ok2 && quota > 0 && period > 0 {
+				l.CPU = lower(l.CPU, float64(quota)/float64(period))
+			}
+		})
+	}
+	if memPath != "" {
+		walk(filepath.Join(mount, "memory"), memPath, func(dir string) {
+			if limit, ok := readInt(filepath.Join(dir, "memory.limit_in_bytes")); ok && limit > 0 && limit < unlimitedMemory {
+				l.Memory = int64(lower(float64(l.Memory), float64(limit)))
+			}
+		})
+	}
+	return l
+}
+
+// detectV2 reads the limits of the unified hierarchy's cgroup at cgPath.
+func detectV2(mount, cgPath string) Limits {
+	l := Limits{Version: 2}
+	walk(mount, cgPath, func(dir string) {
+		if data, err := os.ReadFile(filepath.Join(dir, "cpu.max")); err == nil {
+			fields := strings.Fields(string(data))
+			if len(fields) == 2 && fields[0] != "max" {
+				quota, err1 := strconv.ParseInt(fields[0], 10, 64)
+				period, err2 := strconv.ParseInt(fields[1], 10, 64)
+				if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+					l.CPU = lower(l.CPU, float64(quota)/float64(period))
+				}
+			}
+		}
+		if limit, ok := readInt(filepath.Join(dir, "memory.max")); ok && limit > 0 {
+			l.Memory = int64(lower(float64(l.Memory), float64(limit)))
+		}
+	})
+	return l
+}
+
+// walk calls fn for the directory of cgPath under mount and each of its
+// parents up to mount, since a parent's limit applies to its children.
+// Inside a container the process's own cgroup is usually mount itself.
+func walk(mount, cgPath string, fn func(dir string)) {
+	for p := path.Clean("/" + cgPath); ; p = path.Dir(p) {
+		fn(filepath.Join(mount, filepath.FromSlash(p)))
+		if p == "/" {
+			return
+		}
+	}
+}
+
+// lower returns the smaller of two limits, where 0 is no limit.
+func lower(a, b float64) float64 {
+	if a == 0 || b < a {
+		return b
+	}
+	return a
+}
+
+// readInt reads a file holding one integer; "max" and missing files are
+// not ok.
+func readInt(name string) (int64, bool) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return n, err == nil
+}
+
+// MaxProcs returns the GOMAXPROCS that fits the CPU quota: the quota
+// rounded down, at least 1 and at most the host's CPUs. Without a quota
+// it returns 0.
+func (l Limits) MaxProcs() int {
+	if l.CPU <= 0 {
+		return 0
+	}
+	return max(1, min(int(math.Floor(l.CPU)), runtime.NumCPU()))
+}
@@ -0,0 +1,109 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeRoot lays out files relative to a temporary root for detect.
+func fakeRoot(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  Limits
+	}{
+		{"no cgroup", map[string]string{}, Limits{}},
+		{
+			"v2 in a container namespace",
+			map[string]string{
+				"proc/self/cgroup":         "0::/\n",
+				"sys/fs/cgroup/cpu.max":    "200000 100000\n",
+				"sys/fs/cgroup/memory.max": "1073741824\n",
+			},
+			Limits{Version: 2, CPU: 2, Memory: 1 << 30},
+		},
+		{
+			"v2 unlimited",
+			map[string]string{
+				"proc/self/cgroup":         "0::/\n",
+				"sys/fs/cgroup/cpu.max":    "max 100000\n",
+				"sys/fs/cgroup/memory.max": "max\n",
+			},
+			Limits{Version: 2},
+		},
+		{
+			"v2 parent limit is lower",
+			map[string]string{
+				"proc/self/cgroup":                        "0::/kubepods/pod1/app\n",
+				"sys/fs/cgroup/kubepods/pod1/cpu.max":     "150000 100000\n",
+				"sys/fs/cgroup/kubepods/pod1/app/cpu.max": "400000 100000\n",
+				"sys/fs/cgroup/kubepods/pod1/memory.max":  "536870912\n",
+			},
+			Limits{Version: 2, CPU: 1.5, Memory: 512 << 20},
+		},
+		{
+			"v1",
+			map[string]string{
+				"proc/self/cgroup": "12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n",
+				// Without a cgroup namespace the container's own cgroup
+				// is mounted at the root of each hierarchy.
+				"sys/fs/cgroup/cpu/cpu.cfs_quota_us":         "50000\n",
+				"sys/fs/cgroup/cpu/cpu.cfs_period_us":        "100000\n",
+				"sys/fs/cgroup/memory/memory.limit_in_bytes": "268435456\n",
+			},
+			Limits{Version: 1, CPU: 0.5, Memory: 256 << 20},
+		},
+		{
+			"v1 unlimited",
+			map[string]string{
+				"proc/self/cgroup":                           "4:cpu,cpuacct:/\n12:memory:/\n",
+				"sys/fs/cgroup/cpu/cpu.cfs_quota_us":         "-1\n",
+				"sys/fs/cgroup/cpu/cpu.cfs_period_us":        "100000\n",
+				"sys/fs/cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n",
+			},
+			Limits{Version: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detect(fakeRoot(t, tt.files)); got != tt.want {
+				t.Errorf("detect = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxProcs(t *testing.T) {
+	cpus := runtime.NumCPU()
+	tests := []struct {
+		cpu  float64
+		want int
+	}{
+		{0, 0},
+		{0.5, 1},
+		{1.9, 1},
+		{float64(cpus) + 8, cpus},
+	}
+	for _, tt := range tests {
+		if got := (Limits{CPU: tt.cpu}).MaxProcs(); got != tt.want {
+			t.Errorf("MaxProcs with %g CPUs = %d, want %d", tt.cpu, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package clientip finds the address of the client behind a request. The
+// X-Forwarded-For header is only believed when it was added by a trusted
+// proxy; otherwise any client could claim any address.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Resolver resolves client addresses. A nil *Resolver trusts no proxy and
+// always returns the peer address.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New creates a Resolver that trusts the given proxies, each an IP address
+// ("10.0.0.1") or a CIDR range ("10.0.0.0/8").
+func New(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, p := range proxies {
+		prefix, err := ParsePrefix(p)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, prefix)
+	}
+	return r, nil
+}
+
+// ParsePrefix parses an IP address or CIDR range. A bare address becomes a
+// single-address prefix.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// IP returns the client address of req. When the peer is a trusted proxy,
+// X-Forwarded-For is walked from the right, skipping further trusted
+// proxies, and the first other address is the client. Unparsable entries
+// stop the walk, so a forged header cannot hide the real peer.
+func (r *Resolver) IP(req *http.Request) string {
+	return addrString(r.Addr(req), req.RemoteAddr)
+}
+
+// Addr is IP as a netip.Addr. It is the zero Addr when the peer is not an
+// IP address, as for a unix socket.
+func (r *Resolver) Addr(req *http.Request) netip.Addr {
+	peer := remoteAddr(req.RemoteAddr)
+	if !r.trusts(peer) {
+		return peer
+	}
+
+	client := peer
+	hops := forwardedFor(req.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !r.trusts(client) {
+			break
+		}
+	}
+	return client
+}
+
+// Proto returns the scheme the client used, "http" or "https". Behind a
+// trusted proxy that terminates TLS, it is taken from X-Forwarded-Proto.
+func (r *Resolver) Proto(req *http.Request) string {
+	if r.trusts(remoteAddr(req.RemoteAddr)) {
+		// With several proxies the first value is the client's.
+		switch proto := strings.ToLower(firstValue(req.Header.Get("X-Forwarded-Proto"))); proto {
+		case "http", "https":
+			return proto
+		}
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host and port the client asked for. Behind a trusted
+// proxy they are taken from X-Forwarded-Host and X-Forwarded-Port, so that
+// a proxy addressing maboo by an internal name does not leak it. Without
+// an explicit port, port is the scheme's default.
+func (r *Resolver) Host(req *http.Request) (host, port string) {
+	raw, fwdPort := req.Host, ""
+	if r.trusts(remoteAddr(req.RemoteAddr)) {
+		// With several proxies the first value is the client's.
+		if h := firstValue(req.Header.Get("X-Forwarded-Host")); validHost(h) {
+			raw = h
+		}
+		if p := firstValue(req.Header.Get("X-Forwarded-Port")); validPort(p) {
+			fwdPort = p
+		}
+	}
+	host, port, err := net.SplitHostPort(raw)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]"), ""
+	}
+	switch {
+	case fwdPort != "":
+		port = fwdPort
+	case port == "" && r.Proto(req) == "https":
+		port = "443"
+	case port == "":
+		port = "80"
+	}
+	return host, port
+}
+
+func (r *Resolver) trusts(addr netip.Addr) bool {
+	if r == nil || !addr.IsValid() {
+		return false
+	}
+	for _, p := range r.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr parses an http.Request.RemoteAddr ("ip:port" or a bare IP).
+func remoteAddr(s string) netip.Addr {
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		host = s
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// addrString formats addr, falling back to raw when it did not parse (as
+// for a unix socket peer).
+func addrString(addr netip.Addr, raw string) string {
+	if !addr.IsValid() {
+		return raw
+	}
+	return addr.String()
+}
+
+// firstValue returns the first of the comma-separated values in v.
+func firstValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+// validHost reports whether h can be a Host header: not empty, and
+// without the characters that would let it carry a path or credentials.
+func validHost(h string) bool {
+	return h != "" && !strings.ContainsAny(h, " \t/\\?#@")
+}
+
+// validPort reports whether p is a port number.
+func validPort(p string) bool {
+	n, err := strconv.Atoi(p)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// forwardedFor returns the X-Forwarded-For hops in order, across repeated
+// headers.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// Set is a precompiled set of addresses: its prefixes merged into sorted,
+// disjoint ranges, so Contains is a binary search whatever their number.
+// The zero Set is empty.
+type Set struct {
+	ranges []addrRange
+}
+
+type addrRange struct {
+	first, last netip.Addr
+}
+
+// NewSet compiles entries, each an IP address or a CIDR range.
+func NewSet(entries []string) (*Set, error) {
+	var ranges []addrRange
+	for _, e := range entries {
+		prefix, err := ParsePrefix(e)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, addrRange{prefix.Addr(), lastAddr(prefix)})
+	}
+	// IPv4 addresses sort before IPv6 ones, so the two never merge.
+	slices.SortFunc(ranges, func(a, b addrRange) int { return a.first.Compare(b.first) })
+	s := &Set{}
+	for _, r := range ranges {
+		if n := len(s.ranges); n > 0 && s.ranges[n-1].first.Is4() == r.first.Is4() {
+			prev := &s.ranges[n-1]
+			if next := prev.last.Next(); r.first.Compare(prev.last) <= 0 || (next.IsValid() && r.first == next) {
+				if r.last.Compare(prev.last) > 0 {
+					prev.last = r.last
+				}
+				continue
+			}
+		}
+		s.ranges = append(s.ranges, r)
+	}
+	return s, nil
+}
+
+// Contains reports whether addr is in the set. A nil Set is empty.
+func (s *Set) Contains(addr netip.Addr) bool {
+	if s == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	i, found := slices.BinarySearchFunc(s.ranges, addr, func(r addrRange, a netip.Addr) int { return r.first.Compare(a) })
+	if found {
+		return true
+	}
+	if i == 0 {
+		return false
+	}
+	r := s.ranges[i-1]
+	return r.first.Is4() == addr.Is4() && addr.Compare(r.last) <= 0
+}
+
+// Len returns the number of disjoint ranges the set was compiled into.
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.ranges)
+}
+
+// lastAddr returns the highest address in the masked prefix p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().As16()
+	bits := p.Bits()
+	if p.Addr().Is4() {
+		bits += 96
+	}
+	for i := bits; i < 128; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	last := netip.AddrFrom16(b)
+	if p.Addr().Is4() {
+		return last.Unmap()
+	}
+	return last
+}
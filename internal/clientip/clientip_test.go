@@ -0,0 +1,176 @@
+package clientip_test
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/clientip"
+)
+
+func TestIP(t *testing.T) {
+	r, err := clientip.New([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer ignores header", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.1.2.3:5000", []string{"198.51.100.1, 192.168.1.1, 10.9.9.9"}, "198.51.100.1"},
+		{"spoofed left entry", "10.1.2.3:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"repeated headers", "10.1.2.3:5000", []string{"198.51.100.1", "10.9.9.9"}, "198.51.100.1"},
+		{"only proxies", "10.1.2.3:5000", []string{"10.9.9.9"}, "10.9.9.9"},
+		{"no header", "10.1.2.3:5000", nil, "10.1.2.3"},
+		{"garbage stops the walk", "10.1.2.3:5000", []string{"198.51.100.1, junk"}, "10.1.2.3"},
+		{"ipv6", "[2001:db8::1]:443", nil, "2001:db8::1"},
+		{"ipv4-mapped", "[::ffff:10.1.2.3]:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := r.IP(req); got != tt.want {
+				t.Errorf("IP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProto(t *testing.T) {
+	r, err := clientip.New([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote, proto string
+		tls           bool
+		want          string
+	}{
+		{"203.0.113.7:5000", "", false, "http"},
+		{"203.0.113.7:5000", "", true, "https"},
+		{"203.0.113.7:5000", "https", false, "http"},
+		{"10.1.2.3:5000", "https", false, "https"},
+		{"10.1.2.3:5000", "HTTPS, http", false, "https"},
+		{"10.1.2.3:5000", "gopher", true, "https"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		if got := r.Proto(req); got != tt.want {
+			t.Errorf("%s with X-Forwarded-Proto %q (tls %v): Proto() = %q, want %q", tt.remote, tt.proto, tt.tls, got, tt.want)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	r, err := clientip.New([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote, host   string
+		headers        map[string]string
+		wantHost, port string
+	}{
+		{"203.0.113.7:5000", "example.com", nil, "example.com", "80"},
+		{"203.0.113.7:5000", "example.com:8080", nil, "example.com", "8080"},
+		{"203.0.113.7:5000", "maboo-svc:8080", map[string]string{"X-Forwarded-Host": "evil.test", "X-Forwarded-Port": "443"}, "maboo-svc", "8080"},
+		{"10.1.2.3:5000", "maboo-svc:8080", map[string]string{"X-Forwarded-Host": "example.com"}, "example.com", "80"},
+		{"10.1.2.3:5000", "maboo-svc:8080", map[string]string{"X-Forwarded-Host": "example.com, maboo-svc", "X-Forwarded-Proto": "https"}, "example.com", "443"},
+		{"10.1.2.3:5000", "maboo-svc:8080", map[string]string{"X-Forwarded-Host": "example.com:8443"}, "example.com", "8443"},
+		{"10.1.2.3:5000", "maboo-svc:8080", map[string]string{"X-Forwarded-Host": "example.com", "X-Forwarded-Port": "8443"}, "example.com", "8443"},
+		{"10.1.2.3:5000", "maboo-svc:8080", map[string]string{"X-Forwarded-Port": "443"}, "maboo-svc", "443"},
+		{"10.1.2.3:5000", "maboo-svc:8080", map[string]string{"X-Forwarded-Host": "a.test/path", "X-Forwarded-Port": "http"}, "maboo-svc", "8080"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		req.Host = tt.host
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		host, port := r.Host(req)
+		if host != tt.wantHost || port != tt.port {
+			t.Errorf("%s for %s with %v: Host() = %q, %q; want %q, %q", tt.remote, tt.host, tt.headers, host, port, tt.wantHost, tt.port)
+		}
+	}
+}
+
+func TestNilResolverTrustsNothing(t *testing.T) {
+	var r *clientip.Resolver
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := r.IP(req); got != "10.1.2.3" {
+		t.Errorf("IP() = %q, want the peer", got)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, p := range []string{"10.0.0.0/33", "proxy.local", ""} {
+		if _, err := clientip.New([]string{p}); err == nil {
+			t.Errorf("New(%q) accepted", p)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	s, err := clientip.NewSet([]string{
+		"10.0.0.0/8", "10.1.0.0/16", // nested
+		"192.168.0.0/24", "192.168.1.0/24", // adjacent
+		"203.0.113.7",
+		"2001:db8::/32",
+		"255.255.255.255",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 5 {
+		t.Errorf("Len() = %d, want 5 merged ranges", s.Len())
+	}
+	for addr, want := range map[string]bool{
+		"10.0.0.0":          true,
+		"10.255.255.255":    true,
+		"11.0.0.0":          false,
+		"9.255.255.255":     false,
+		"192.168.1.200":     true,
+		"192.168.2.0":       false,
+		"203.0.113.7":       true,
+		"203.0.113.8":       false,
+		"::ffff:10.1.2.3":   true,
+		"2001:db8:ffff::1":  true,
+		"2001:db9::":        false,
+		"::a00:1":           false, // 10.0.0.1's bits, but IPv6
+		"255.255.255.255":   true,
+		"ffff:ffff::ffff:1": false,
+	} {
+		if got := s.Contains(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	var empty *clientip.Set
+	if empty.Contains(netip.MustParseAddr("10.0.0.1")) || s.Contains(netip.Addr{}) {
+		t.Error("nil set or invalid address matched")
+	}
+	if _, err := clientip.NewSet([]string{"10.0.0.0/8", "office"}); err == nil {
+		t.Error("NewSet accepted a hostname")
+	}
+}
@@ -1,25 +1,91 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/sadewadee/maboo/internal/cgroup"
+	"github.com/sadewadee/maboo/internal/clientip"
+	"github.com/sadewadee/maboo/internal/cron"
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/glob"
+	"github.com/sadewadee/maboo/internal/notify"
+	"github.com/sadewadee/maboo/internal/origin"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/route"
+	"golang.org/x/net/http/httpguts"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds the complete maboo server configuration.
 type Config struct {
-	Server    ServerConfig    `yaml:"server"`
-	PHP       PHPConfig       `yaml:"php"`
-	Pool      PoolConfig      `yaml:"pool"`
-	App       AppConfig       `yaml:"app"`
-	WebSocket WebSocketConfig `yaml:"websocket"`
-	Static    StaticConfig    `yaml:"static"`
-	Logging   LogConfig       `yaml:"logging"`
-	Metrics   MetricsConfig   `yaml:"metrics"`
-	Watch     WatchConfig     `yaml:"watch"`
-	Workers   []WorkerConfig  `yaml:"workers"`
+	Strict      bool              `yaml:"strict"` // reject unknown keys (default true)
+	Server      ServerConfig      `yaml:"server"`
+	PHP         PHPConfig         `yaml:"php"`
+	Pool        PoolConfig        `yaml:"pool"`
+	App         AppConfig         `yaml:"app"`
+	WebSocket   WebSocketConfig   `yaml:"websocket"`
+	Static      StaticConfig      `yaml:"static"`
+	Temp        TempConfig        `yaml:"temp"`
+	Cache       CacheConfig       `yaml:"cache"`
+	Coalesce    CoalesceConfig    `yaml:"coalesce"`
+	Hints       HintsConfig       `yaml:"early_hints"`
+	Access      AccessConfig      `yaml:"access_control"`
+	Rewrites    RewritesConfig    `yaml:"rewrites"`
+	Headers     HeadersConfig     `yaml:"response_headers"`
+	ETag        ETagConfig        `yaml:"etag"`
+	Compression CompressionConfig `yaml:"compression"`
+	Logging     LogConfig         `yaml:"logging"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Health      HealthConfig      `yaml:"health"`
+	Schedule    ScheduleConfig    `yaml:"schedule"`
+	Jobs        []JobConfig       `yaml:"jobs"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Errors      ErrorsConfig      `yaml:"error_reporting"`
+	Notify      NotifyConfig      `yaml:"notifications"`
+	Watch       WatchConfig       `yaml:"watch"`
+	Workers     []WorkerConfig    `yaml:"workers"`
+	Admin       AdminConfig       `yaml:"admin"`
+	Debug       DebugConfig       `yaml:"debug"`
+
+	// envPaths records values substituted from the environment so Dump
+	// can redact them.
+	envPaths map[string]bool
+
+	// warnings collects non-fatal problems found by Load.
+	warnings []Warning
+
+	// sizing records the container limits Load was given and what the
+	// pool sizes were derived from.
+	sizing PoolSizing
+}
+
+// Warnings returns the deprecations and (outside strict mode) unknown keys
+// found while loading the file.
+func (c *Config) Warnings() []Warning {
+	return c.warnings
+}
+
+// Options adjust how LoadWithOptions parses a file.
+type Options struct {
+	// NonStrict downgrades unknown keys to warnings even when the file
+	// leaves strict enabled (the serve --no-strict flag).
+	NonStrict bool
+
+	// Limits are the container's CPU and memory limits. When set, a file
+	// that leaves pool.min_workers and pool.max_workers out gets them
+	// sized to fit the memory limit.
+	Limits *cgroup.Limits
 }
 
 // ServerMode defines the server operation mode
@@ -31,19 +97,47 @@ const (
 )
 
 type ServerConfig struct {
-	Address      string      `yaml:"address"`
-	Mode         ServerMode  `yaml:"mode"`
-	HTTP2        bool        `yaml:"http2"`
-	HTTP3        bool        `yaml:"http3"`
-	TLS          TLSConfig   `yaml:"tls"`
-	HTTPRedirect bool        `yaml:"http_redirect"`
+	Address         string     `yaml:"address"`
+	Mode            ServerMode `yaml:"mode"`
+	HTTP2           bool       `yaml:"http2"`
+	HTTP3           bool       `yaml:"http3"`
+	TLS             TLSConfig  `yaml:"tls"`
+	HTTPRedirect    bool       `yaml:"http_redirect"`
+	MaxBodySize     Size       `yaml:"max_body_size"`     // request body limit, e.g. 32M (0 = unlimited)
+	MaxResponseSize Size       `yaml:"max_response_size"` // PHP response body limit; larger ones fail with 502 (0 = unlimited)
+	Compression     bool       `yaml:"compression"`       // deprecated: compression.enabled
+	PIDFile         string     `yaml:"pid_file"`          // locked while running; used by maboo reload/stop ("" = none)
+	ReusePort       bool       `yaml:"reuse_port"`        // bind with SO_REUSEPORT, sharing the port with other sockets and processes (Linux)
+	Listeners       int        `yaml:"listeners"`         // sockets accepting on server.address with reuse_port (0 = one per CPU)
+
+	// Slow clients: the request line and headers must arrive within
+	// ReadHeaderTimeout, and then maboo waits for the client at most
+	// BodyRateInterval for each MinBodyRate bytes of the body, or
+	// answers 408 (MinBodyRate 0 = wait as long as it takes).
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout"`
+	MinBodyRate       Size     `yaml:"min_body_rate"`
+	BodyRateInterval  Duration `yaml:"body_rate_interval"`
+
+	// Connections over these are closed as soon as they are accepted
+	// (0 = unlimited). Peers are counted by socket address.
+	MaxConnections      int `yaml:"max_connections"`
+	MaxConnectionsPerIP int `yaml:"max_connections_per_ip"`
+
+	// TrustedProxies lists the IPs and CIDR ranges whose X-Forwarded-For
+	// header is believed when working out the client address.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// CanonicalHost is the host the site is served under, with an optional
+	// port. Requests for any other host are redirected to it with 308
+	// ("" = serve every host).
+	CanonicalHost string `yaml:"canonical_host"`
 }
 
 type TLSConfig struct {
-	Auto  bool       `yaml:"auto"`
-	Cert  string     `yaml:"cert"`
-	Key   string     `yaml:"key"`
-	ACME  ACMEConfig `yaml:"acme"`
+	Auto bool       `yaml:"auto"`
+	Cert string     `yaml:"cert"`
+	Key  string     `yaml:"key"`
+	ACME ACMEConfig `yaml:"acme"`
 }
 
 type ACMEConfig struct {
@@ -51,6 +145,10 @@ type ACMEConfig struct {
 	Domains  []string `yaml:"domains"`
 	CacheDir string   `yaml:"cache_dir"`
 	Staging  bool     `yaml:"staging"`
+
+	// External Account Binding, required by CAs such as ZeroSSL.
+	EABKeyID   string `yaml:"eab_key_id"`
+	EABHMACKey Secret `yaml:"eab_hmac_key"` // base64url-encoded, as issued by the CA
 }
 
 type PHPConfig struct {
@@ -59,30 +157,158 @@ type PHPConfig struct {
 	Binary  string            `yaml:"binary"`  // Optional: use system PHP instead of bundled
 	Worker  string            `yaml:"worker"`  // Legacy: path to worker script
 	INI     map[string]string `yaml:"ini"`
+	Sandbox SandboxConfig     `yaml:"sandbox"`
+}
+
+// SandboxConfig confines the PHP code serving requests, as
+// php_admin_value does under Apache: scripts cannot change these
+// settings. The embedded engine applies them to each request, so Routes
+// can give some requests settings of their own; worker processes get the
+// top-level settings when they start.
+type SandboxConfig struct {
+	OpenBasedir      []string               `yaml:"open_basedir"`      // directories PHP may open files in; must include app.root (empty = anywhere)
+	DisableFunctions []string               `yaml:"disable_functions"` // e.g. exec, shell_exec, proc_open
+	AllowURLFopen    string                 `yaml:"allow_url_fopen"`   // on or off ("" = as php.ini says)
+	Routes           map[string]SandboxRule `yaml:"routes"`            // route template -> settings replacing these for its requests
+}
+
+// SandboxRule is the sandbox for the requests matching one route
+// template. Settings left empty are taken from the top level.
+type SandboxRule struct {
+	OpenBasedir      []string `yaml:"open_basedir"`
+	DisableFunctions []string `yaml:"disable_functions"`
+	AllowURLFopen    string   `yaml:"allow_url_fopen"`
+}
+
+// Rule returns the top-level settings of s.
+func (s SandboxConfig) Rule() SandboxRule {
+	return SandboxRule{OpenBasedir: s.OpenBasedir, DisableFunctions: s.DisableFunctions, AllowURLFopen: s.AllowURLFopen}
+}
+
+// Over returns r with the settings it leaves empty taken from base.
+func (r SandboxRule) Over(base SandboxRule) SandboxRule {
+	if r.OpenBasedir == nil {
+		r.OpenBasedir = base.OpenBasedir
+	}
+	if r.DisableFunctions == nil {
+		r.DisableFunctions = base.DisableFunctions
+	}
+	if r.AllowURLFopen == "" {
+		r.AllowURLFopen = base.AllowURLFopen
+	}
+	return r
+}
+
+// INI returns the php.ini settings r makes; those it leaves empty are
+// not included.
+func (r SandboxRule) INI() map[string]string {
+	ini := make(map[string]string, 3)
+	if len(r.OpenBasedir) > 0 {
+		ini["open_basedir"] = strings.Join(r.OpenBasedir, string(os.PathListSeparator))
+	}
+	if len(r.DisableFunctions) > 0 {
+		ini["disable_functions"] = strings.Join(r.DisableFunctions, ",")
+	}
+	switch strings.ToLower(r.AllowURLFopen) {
+	case "on", "true", "1":
+		ini["allow_url_fopen"] = "1"
+	case "off", "false", "0":
+		ini["allow_url_fopen"] = "0"
+	}
+	return ini
 }
 
 type AppConfig struct {
 	Root  string            `yaml:"root"`  // Document root
 	Entry string            `yaml:"entry"` // auto, or explicit path like "public/index.php"
 	Env   map[string]string `yaml:"env"`   // Environment variables
+	Debug bool              `yaml:"debug"` // show error details in responses (development only)
 }
 
 type PoolConfig struct {
 	MinWorkers      int      `yaml:"min_workers"`
 	MaxWorkers      int      `yaml:"max_workers"`
 	MaxJobs         int      `yaml:"max_jobs"`
-	MaxMemory       string   `yaml:"max_memory"`
+	MaxMemory       Size     `yaml:"max_memory"`     // per-worker memory limit, e.g. 128M (0 = none)
+	MaxFrameSize    Size     `yaml:"max_frame_size"` // largest protocol frame accepted from a worker process
+	WorkerMemory    Size     `yaml:"worker_memory"`  // expected use per worker, for sizing from a container memory limit (0 = max_memory)
 	IdleTimeout     Duration `yaml:"idle_timeout"`
 	AllocateTimeout Duration `yaml:"allocate_timeout"`
 	RequestTimeout  Duration `yaml:"request_timeout"`
+	SpawnRate       float64  `yaml:"spawn_rate"` // workers started per second for requests that find none idle (0 = only on the watchdog tick)
+	OnDemand        bool     `yaml:"on_demand"`  // start with no workers and scale back to none after idle_timeout; min_workers is ignored
+
+	// RouteTimeouts overrides RequestTimeout for the requests matching a
+	// route template, e.g. "/admin/reports/*": "5m".
+	RouteTimeouts map[string]Duration `yaml:"route_timeouts"`
 }
 
 type WebSocketConfig struct {
-	Enabled        bool     `yaml:"enabled"`
-	Path           string   `yaml:"path"`
-	Worker         string   `yaml:"worker"`
-	MaxConnections int      `yaml:"max_connections"`
-	PingInterval   Duration `yaml:"ping_interval"`
+	Enabled              bool     `yaml:"enabled"`
+	Path                 string   `yaml:"path"`
+	Worker               string   `yaml:"worker"`
+	MinWorkers           int      `yaml:"min_workers"`     // worker group for websocket.worker (0 = pool.min_workers)
+	MaxWorkers           int      `yaml:"max_workers"`     // 0 = pool.max_workers
+	AllowedOrigins       []string `yaml:"allowed_origins"` // same-origin, *, [scheme://]host[:port] with optional *. prefix
+	ForwardHeaders       []string `yaml:"forward_headers"` // request headers passed to PHP with the connect event
+	AuthEndpoint         string   `yaml:"auth_endpoint"`   // script asked before each upgrade, relative to app.root; "" = none
+	AuthTimeout          Duration `yaml:"auth_timeout"`
+	MaxConnections       int      `yaml:"max_connections"`        // 0 = unlimited
+	MaxConnectionsPerIP  int      `yaml:"max_connections_per_ip"` // 0 = unlimited
+	MaxRoomSize          int      `yaml:"max_room_size"`          // members per room unless PHP sets a limit when joining (0 = unlimited)
+	MaxMessageSize       Size     `yaml:"max_message_size"`       // inbound message limit, e.g. 64K (0 = unlimited)
+	MessageRate          float64  `yaml:"message_rate"`           // inbound messages per second per connection (0 = unlimited)
+	MessageBurst         int      `yaml:"message_burst"`          // messages allowed at once above message_rate
+	RateExceeded         string   `yaml:"rate_exceeded"`          // over message_rate: drop the message or close
+	SendQueue            int      `yaml:"send_queue"`             // messages buffered per client
+	BroadcastParallelism int      `yaml:"broadcast_parallelism"`  // goroutines delivering one large broadcast (0 = one per CPU)
+	PublishToken         Secret   `yaml:"publish_token"`          // bearer token for the admin API's publish endpoint ("" = admin.token)
+	PublishRate          float64  `yaml:"publish_rate"`           // publish requests per second (0 = unlimited)
+	PublishBurst         int      `yaml:"publish_burst"`
+	WriteTimeout         Duration `yaml:"write_timeout"`    // how long one write to a client may take
+	SlowClient           string   `yaml:"slow_client"`      // full send queue: disconnect or drop
+	PingInterval         Duration `yaml:"ping_interval"`    // 0 disables keepalive pings
+	PongTimeout          Duration `yaml:"pong_timeout"`     // how long a ping may go unanswered
+	ShutdownMessage      string   `yaml:"shutdown_message"` // sent to every client before the close frames on shutdown ("" = none)
+	DrainTimeout         Duration `yaml:"drain_timeout"`    // how long shutdown waits for clients to answer the close frame
+	CloseSpread          Duration `yaml:"close_spread"`     // close frames are spread over this much of drain_timeout
+	TickInterval         Duration `yaml:"tick_interval"`    // how often the worker gets a tick event (0 = never)
+
+	Subprotocols []WebSocketSubprotocolConfig `yaml:"subprotocols"` // negotiated via Sec-WebSocket-Protocol, in order of preference (empty = none)
+
+	Compression  WebSocketCompressionConfig  `yaml:"compression"`
+	Resume       WebSocketResumeConfig       `yaml:"resume"`
+	PrivateRooms WebSocketPrivateRoomsConfig `yaml:"private_rooms"`
+}
+
+// WebSocketCompressionConfig controls the permessage-deflate extension.
+type WebSocketCompressionConfig struct {
+	Enabled   bool `yaml:"enabled"`   // negotiate permessage-deflate with clients that offer it
+	Level     int  `yaml:"level"`     // flate level, 1 (fastest) to 9 (smallest)
+	Threshold Size `yaml:"threshold"` // smaller messages are sent uncompressed
+}
+
+// WebSocketResumeConfig lets clients that lost their connection resume
+// their session and get the messages they missed.
+type WebSocketResumeConfig struct {
+	Enabled     bool     `yaml:"enabled"`
+	Buffer      int      `yaml:"buffer"`       // messages kept per session for replay
+	BufferSize  Size     `yaml:"buffer_size"`  // bytes kept per session; older messages go first
+	TTL         Duration `yaml:"ttl"`          // how long a dropped connection's session waits to be resumed
+	MaxSessions int      `yaml:"max_sessions"` // dropped sessions kept at once; the oldest end first (0 = unlimited)
+}
+
+// WebSocketSubprotocolConfig is one subprotocol clients may negotiate.
+type WebSocketSubprotocolConfig struct {
+	Name   string `yaml:"name"`   // as sent in Sec-WebSocket-Protocol, e.g. graphql-ws
+	Worker string `yaml:"worker"` // script handling its connections' events ("" = websocket.worker)
+}
+
+// WebSocketPrivateRoomsConfig names the rooms a connection may only join
+// once the worker has authorized it.
+type WebSocketPrivateRoomsConfig struct {
+	Prefixes []string `yaml:"prefixes"`  // rooms whose names start with one of these, e.g. "private-"
+	CacheTTL Duration `yaml:"cache_ttl"` // how long a decision holds per connection and room (0 = ask every time)
 }
 
 type StaticConfig struct {
@@ -90,28 +316,394 @@ type StaticConfig struct {
 	CacheControl string `yaml:"cache_control"`
 }
 
+// TempConfig is the scratch area temporary files are written to, such as
+// request bodies too large to keep in memory.
+type TempConfig struct {
+	Dir           string   `yaml:"dir"`            // "" = the system temp directory
+	MaxBytes      Size     `yaml:"max_bytes"`      // bytes all temporary files may hold (0 = unlimited)
+	SweepInterval Duration `yaml:"sweep_interval"` // how often files left by earlier processes are looked for (0 = never)
+	OrphanAge     Duration `yaml:"orphan_age"`     // how long such a file must be untouched to be removed
+}
+
+// ErrorsConfig sends recovered panics, PHP errors and worker crash loops to
+// Sentry or a service that speaks its API.
+type ErrorsConfig struct {
+	DSN         Secret   `yaml:"dsn"` // "" = off
+	Environment string   `yaml:"environment"`
+	SampleRate  float64  `yaml:"sample_rate"` // share of events sent, 0-1
+	QueueSize   int      `yaml:"queue_size"`  // events waiting to be sent; more are dropped
+	Timeout     Duration `yaml:"timeout"`     // per event sent
+}
+
+// NotifyConfig posts operational events, such as worker crash loops,
+// ACME failures and health probes changing state, to webhooks.
+type NotifyConfig struct {
+	InstanceID string          `yaml:"instance_id"` // names this instance in payloads; "" = the hostname
+	QueueSize  int             `yaml:"queue_size"`  // events waiting to be sent; more are dropped
+	Retries    int             `yaml:"retries"`     // further attempts after a failed one
+	Backoff    Duration        `yaml:"backoff"`     // before the first retry, doubled for each one after
+	Timeout    Duration        `yaml:"timeout"`     // per attempt, for webhooks without their own
+	Webhooks   []WebhookConfig `yaml:"webhooks"`
+}
+
+// WebhookConfig is one endpoint notifications are posted to.
+type WebhookConfig struct {
+	Name     string   `yaml:"name"`     // shown in logs and test results; "" = webhooks[i]
+	URL      Secret   `yaml:"url"`      // often holds a token, as Slack's do
+	Events   []string `yaml:"events"`   // event types sent; [] = all
+	Template string   `yaml:"template"` // text/template producing the JSON body; "" = maboo's payload
+	Timeout  Duration `yaml:"timeout"`  // per attempt; 0 = notifications.timeout
+}
+
+// CacheConfig is the micro-cache for PHP responses: GET requests to Routes
+// are answered from memory for TTL once PHP has served them.
+type CacheConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	Routes        []string `yaml:"routes"` // route templates whose responses may be cached
+	TTL           Duration `yaml:"ttl"`
+	MaxSize       Size     `yaml:"max_size"`       // memory for cached responses; the least recently used go first
+	VaryHeaders   []string `yaml:"vary_headers"`   // request headers whose values are part of the key, e.g. Accept-Encoding
+	VaryCookies   []string `yaml:"vary_cookies"`   // cookie name prefixes whose presence is part of the key
+	BypassCookies []string `yaml:"bypass_cookies"` // cookie name prefixes that send a request past the cache
+}
+
+// CoalesceConfig makes identical concurrent anonymous requests to some
+// routes share one PHP execution: the first runs PHP and the others get a
+// copy of its response.
+type CoalesceConfig struct {
+	Enabled     bool     `yaml:"enabled"`
+	Routes      []string `yaml:"routes"`       // route templates whose requests may be coalesced
+	VaryHeaders []string `yaml:"vary_headers"` // request headers whose values are part of the key, e.g. Accept-Encoding
+	MaxWait     Duration `yaml:"max_wait"`     // how long a request waits for another's response before running PHP itself
+}
+
+// HintsConfig sends 103 Early Hints, so browsers can fetch what a page
+// needs while PHP still builds it.
+type HintsConfig struct {
+	Enabled bool                `yaml:"enabled"`
+	Routes  map[string][]string `yaml:"routes"` // route template -> Link header values sent before PHP runs
+	HTTP1   bool                `yaml:"http1"`  // also send to HTTP/1.1 clients; never to HTTP/1.0
+}
+
+// AccessConfig allows or denies requests by client address before they
+// reach static files or PHP. Rules are tried in order; see AccessRule.
+type AccessConfig struct {
+	Default    string       `yaml:"default"`     // allow or deny, when no rule decides
+	DenyStatus int          `yaml:"deny_status"` // 403, or 404 to hide what is there
+	Rules      []AccessRule `yaml:"rules"`
+}
+
+// AccessRule applies to requests whose path and host match. A client in
+// Deny is denied; one in Allow is allowed. Anyone else is denied when
+// Allow is set, and otherwise left to the next rule.
+type AccessRule struct {
+	Name  string   `yaml:"name"`  // metric label ("" = rules[i])
+	Paths []string `yaml:"paths"` // path prefixes ("/wp-admin") or globs ("/**/*.sql"); none = every path
+	Host  string   `yaml:"host"`  // exact host or *.example.com ("" = any)
+	Allow []string `yaml:"allow"` // IPs and CIDR ranges
+	Deny  []string `yaml:"deny"`
+}
+
+// HeadersConfig filters the headers of PHP responses before they reach
+// the client. Hop-by-hop headers are always dropped.
+type HeadersConfig struct {
+	InternalPrefixes []string `yaml:"internal_prefixes"` // maboo's own headers, dropped once read (default X-Maboo-)
+	HideServer       bool     `yaml:"hide_server"`       // drop X-Powered-By and Server
+	Deny             []string `yaml:"deny"`              // further header names to drop
+	Allow            []string `yaml:"allow"`             // names kept despite internal_prefixes, hide_server and deny
+	PreserveCase     bool     `yaml:"preserve_case"`     // send header names in the case PHP wrote them, not canonical
+}
+
+// ETagConfig tags successful PHP responses with a strong ETag computed
+// over their body, and answers GET and HEAD requests whose If-None-Match
+// names it with 304 Not Modified.
+type ETagConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Routes  []string `yaml:"routes"` // route templates to tag; empty = every PHP response
+}
+
+// CompressionConfig decides which responses are compressed, and how.
+// Routes can turn compression off, for bodies that are already encrypted
+// or that carry secrets next to text an attacker controls (BREACH), or
+// compress harder.
+type CompressionConfig struct {
+	Enabled      bool                        `yaml:"enabled"`
+	Algorithms   []string                    `yaml:"algorithms"`    // content codings offered, preferred first: gzip, deflate
+	MinSize      Size                        `yaml:"min_size"`      // smaller bodies are sent as they are
+	Level        int                         `yaml:"level"`         // 1 (fastest) to 9 (smallest)
+	ContentTypes []string                    `yaml:"content_types"` // media types to compress; "text/" covers every subtype, "+json" every type with that suffix
+	Routes       map[string]CompressionRoute `yaml:"routes"`        // route template -> override for its requests
+}
+
+// CompressionRoute overrides the compression section for the requests
+// matching one route template.
+type CompressionRoute struct {
+	Disable bool `yaml:"disable"` // send the responses uncompressed
+	Level   int  `yaml:"level"`   // 0 = compression.level
+}
+
+// CompressionAlgorithms are the values compression.algorithms accepts.
+var CompressionAlgorithms = []string{"gzip", "deflate"}
+
+// RewritesConfig rewrites request URLs before they are routed, for apps
+// written against mod_rewrite.
+type RewritesConfig struct {
+	MaxIterations int           `yaml:"max_iterations"` // passes over the rules before a request fails as a loop
+	Rules         []RewriteRule `yaml:"rules"`
+}
+
+// RewriteRule is one RewriteRule, with its RewriteConds folded in.
+type RewriteRule struct {
+	Match        string `yaml:"match"`         // regexp on the path
+	To           string `yaml:"to"`            // substitution with $1, ${name} and %1 (from Query); "-" keeps the URL
+	Host         string `yaml:"host"`          // regexp the host must match ("" = any)
+	Query        string `yaml:"query"`         // regexp the query string must match ("" = any)
+	UnlessExists bool   `yaml:"unless_exists"` // skip when the path is a file or directory (!-f, !-d)
+	Flag         string `yaml:"flag"`          // "" (go on), last, redirect or php
+	Status       int    `yaml:"status"`        // redirect status (0 = 302)
+	AppendQuery  bool   `yaml:"append_query"`  // keep the original query after a new one (QSA)
+}
+
 type LogConfig struct {
-	Level  string `yaml:"level"`
-	Format string `yaml:"format"`
-	Output string `yaml:"output"`
+	Level        string           `yaml:"level"`
+	Format       string           `yaml:"format"`
+	Output       string           `yaml:"output"`        // stdout, stderr, or file path
+	AccessOutput string           `yaml:"access_output"` // request log destination ("" = output)
+	Request      RequestLogConfig `yaml:"request"`
+	Audit        AuditLogConfig   `yaml:"audit"`
+
+	// Built-in rotation for file outputs. Each file rotates on its own.
+	MaxSize    Size     `yaml:"max_size"`    // rotate at this size (0 = rotate externally, reopen on SIGUSR2)
+	MaxBackups int      `yaml:"max_backups"` // rotated files to keep (0 = all)
+	MaxAge     Duration `yaml:"max_age"`     // delete rotated files older than this (0 = never)
+	Compress   bool     `yaml:"compress"`    // gzip rotated files
+}
+
+// RequestLogConfig controls which requests produce an access log record.
+// Failed, slow, and explicitly listed requests are always logged; the rest
+// are sampled at SampleRate.
+type RequestLogConfig struct {
+	SampleRate    float64  `yaml:"sample_rate"`    // 0.0-1.0, fraction of fast successful requests logged
+	SlowThreshold Duration `yaml:"slow_threshold"` // requests slower than this are always logged (0 = disabled)
+	AlwaysPaths   []string `yaml:"always_paths"`   // path prefixes that are always logged
+}
+
+// AuditLogConfig controls the record of administrative actions: reloads,
+// upgrades, limit changes, cache purges and shutdowns.
+type AuditLogConfig struct {
+	Output string `yaml:"output"` // stdout, stderr, or file path ("" = logging.output)
+	Keep   int    `yaml:"keep"`   // recent records the admin API returns
 }
 
 type MetricsConfig struct {
-	Enabled bool   `yaml:"enabled"`
-	Path    string `yaml:"path"`
+	Enabled           bool     `yaml:"enabled"`
+	Path              string   `yaml:"path"`               // served on the main listener ("" = only on address)
+	Address           string   `yaml:"address"`            // dedicated listener, host:port or unix:/path ("" = none)
+	RouteLabels       []string `yaml:"route_labels"`       // path templates like /api/users/:id or /blog/*
+	RuntimeCollectors bool     `yaml:"runtime_collectors"` // standard go_* and process_* collectors
+	Token             Secret   `yaml:"token"`              // require "Authorization: Bearer <token>" to scrape
+	Allow             []string `yaml:"allow"`              // IPs and CIDR ranges allowed to scrape over TCP (empty = any)
+}
+
+// MetricsUnixPrefix marks a metrics.address that is a unix socket path.
+const MetricsUnixPrefix = "unix:"
+
+// MaxRouteLabels caps metrics.route_labels so the route label can never
+// blow up series cardinality.
+const MaxRouteLabels = 100
+
+// TracingConfig controls W3C trace context propagation. maboo forwards
+// traceparent/tracestate to PHP and tags its own log records with the
+// trace; it does not record spans.
+type TracingConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Generate       bool   `yaml:"generate"`        // create a traceparent when the request has none
+	ResponseHeader string `yaml:"response_header"` // echo the trace ID in this response header ("" = off)
+}
+
+// HealthConfig configures dependency probes that feed /readyz.
+type HealthConfig struct {
+	Policy   string              `yaml:"policy"` // any (one failing probe fails readiness) or quorum (a majority must pass)
+	Checks   []HealthCheckConfig `yaml:"checks"`
+	SelfTest SelfTestConfig      `yaml:"self_test"`
+}
+
+// SelfTestConfig describes the request run through the pool at startup,
+// before the server reports ready. A 5xx answer or an error fails it.
+type SelfTestConfig struct {
+	Enabled bool     `yaml:"enabled"` // default true
+	Path    string   `yaml:"path"`    // REQUEST_URI of the request, run by app.entry (default /)
+	Timeout Duration `yaml:"timeout"` // default 30s
+	Strict  bool     `yaml:"strict"`  // exit on failure instead of serving while not ready
+}
+
+// HealthCheckConfig describes one dependency probe. Type selects which of
+// Address (tcp), URL (http) or Script (php) is used.
+type HealthCheckConfig struct {
+	Name             string   `yaml:"name"`
+	Type             string   `yaml:"type"`              // tcp, http, php
+	Address          string   `yaml:"address"`           // tcp: host:port
+	URL              string   `yaml:"url"`               // http: URL to GET
+	ExpectStatus     int      `yaml:"expect_status"`     // http: expected status (default 200)
+	Script           string   `yaml:"script"`            // php: script run through the worker pool
+	Interval         Duration `yaml:"interval"`          // default 10s
+	Timeout          Duration `yaml:"timeout"`           // default 2s
+	FailureThreshold int      `yaml:"failure_threshold"` // consecutive failures before unhealthy (default 3)
 }
 
+// ScheduleConfig configures the built-in task scheduler, which runs PHP
+// scripts on cron schedules without a system cron or PHP CLI.
+type ScheduleConfig struct {
+	Timezone  string              `yaml:"timezone"`   // IANA zone for the cron expressions ("" = local time)
+	StateFile string              `yaml:"state_file"` // remembers when each job last ran; required for catch_up
+	Jobs      []ScheduleJobConfig `yaml:"jobs"`
+}
+
+// ScheduleJobConfig describes one scheduled script.
+type ScheduleJobConfig struct {
+	Name    string   `yaml:"name"`
+	Cron    string   `yaml:"cron"`     // minute hour day-of-month month day-of-week, or @hourly, @daily...
+	Script  string   `yaml:"script"`   // relative to app.root
+	Args    []string `yaml:"args"`     // cli: $argv after the script
+	Mode    string   `yaml:"mode"`     // cli (default): run as a CLI script; request: dispatch through the worker pool
+	Timeout Duration `yaml:"timeout"`  // cancel runs that take longer (0 = none)
+	Overlap string   `yaml:"overlap"`  // when the previous run is still going: skip (default), queue or kill
+	Jitter  Duration `yaml:"jitter"`   // random delay up to this before each run
+	CatchUp bool     `yaml:"catch_up"` // run once at startup if a run was missed while maboo was down
+}
+
+// JobConfig describes a long-running PHP command, such as Laravel's
+// queue:work, that maboo keeps running next to the HTTP workers.
+type JobConfig struct {
+	Name            string   `yaml:"name"`
+	Script          string   `yaml:"script"`            // relative to app.root
+	Args            []string `yaml:"args"`              // $argv after the script
+	Count           int      `yaml:"count"`             // processes to keep running (0 = 1)
+	MaxMemory       Size     `yaml:"max_memory"`        // restart a process whose RSS passes this (0 = none; php.binary only)
+	RestartDelay    Duration `yaml:"restart_delay"`     // wait before restarting a process that exited (0 = 1s)
+	MaxRestartDelay Duration `yaml:"max_restart_delay"` // the delay doubles on each quick exit up to this (0 = 1m)
+	StopSignal      string   `yaml:"stop_signal"`       // asks a process to finish: TERM (default), INT, QUIT, HUP, USR1 or USR2
+	StopGrace       Duration `yaml:"stop_grace"`        // how long it gets to finish before SIGKILL (0 = 10s)
+}
+
+// AdminConfig configures the admin API, a separate listener for operator
+// tooling such as maboo status. Keep it on a loopback or private address.
+type AdminConfig struct {
+	Enabled bool            `yaml:"enabled"`
+	Address string          `yaml:"address"`
+	Token   Secret          `yaml:"token"` // require "Authorization: Bearer <token>" ("" = no auth)
+	Eval    AdminEvalConfig `yaml:"eval"`
+}
+
+// AdminEvalConfig enables POST /eval on the admin API, which runs PHP
+// snippets on the embedded engine. Whoever holds admin.token can run any
+// code as maboo, so it is off unless enabled.
+type AdminEvalConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Timeout   Duration `yaml:"timeout"`    // per snippet
+	MaxOutput Size     `yaml:"max_output"` // output past it fails the snippet
+}
+
+// DebugConfig reports what PHP did for a request: opcache hits and misses,
+// files included, peak memory and the realpath cache size, as
+// X-Maboo-Debug-* response headers and in the access log. Requests
+// without it pay nothing for the collection.
+type DebugConfig struct {
+	Stats  bool   `yaml:"stats"`  // for every request (development only)
+	Secret Secret `yaml:"secret"` // for requests sending "X-Maboo-Debug: <secret>" ("" = only with stats)
+}
+
+// MinDebugSecret is the shortest debug.secret accepted: anyone who guesses
+// it sees how the application runs.
+const MinDebugSecret = 16
+
 type WatchConfig struct {
 	Enabled  bool     `yaml:"enabled"`
 	Dirs     []string `yaml:"dirs"`
-	Interval Duration `yaml:"interval"`
+	Backend  string   `yaml:"backend"`  // auto (fsnotify, falling back to poll), fsnotify or poll
+	Interval Duration `yaml:"interval"` // scan period of the poll backend
+
+	// One reload per batch of changes: it fires once no change arrived
+	// for Debounce, or MaxDelay after the batch began, but not sooner
+	// than Cooldown after the previous reload.
+	Debounce Duration `yaml:"debounce"`
+	MaxDelay Duration `yaml:"max_delay"` // 0 = wait for a quiet period however long the churn lasts
+	Cooldown Duration `yaml:"cooldown"`
+
+	// Which files count, checked for every change: a path matching an
+	// Exclude glob is ignored (excluded directories are not descended
+	// into), then the first matching Include rule decides the action,
+	// then a file with one of Extensions reloads workers.
+	Extensions []string    `yaml:"extensions"`
+	Include    []WatchRule `yaml:"include"`
+	Exclude    []string    `yaml:"exclude"` // replaces the default list when set
+
+	// How changed code reaches the workers: reload restarts them,
+	// invalidate drops the changed files from each worker's opcache.
+	// Invalidate still reloads for batches of more than InvalidateMax
+	// files (0 = no limit) or touching a FullReload file. FullReload
+	// globs are watched even without a matching extension or include.
+	Strategy      string   `yaml:"strategy"`
+	InvalidateMax int      `yaml:"invalidate_max"`
+	FullReload    []string `yaml:"full_reload"`
 }
 
+// Values of WatchConfig.Strategy.
+const (
+	WatchStrategyReload     = "reload"
+	WatchStrategyInvalidate = "invalidate"
+)
+
+// Actions a WatchRule can take when a matching file changes.
+const (
+	WatchActionReload = "reload" // reload the workers
+	WatchActionConfig = "config" // re-read the config file, as on SIGHUP
+	WatchActionLog    = "log"    // only log the change
+)
+
+// WatchRule is an include pattern of the watcher. Pattern is a glob
+// relative to the watched directory where "**" spans directories, so
+// ".env" matches only at the top and "config/**/*.yaml" at any depth
+// below config/. Matching is case-sensitive.
+type WatchRule struct {
+	Pattern string `yaml:"pattern"`
+	Action  string `yaml:"action"` // reload (default), config or log
+}
+
+// WorkerConfig describes a group of PHP workers serving the requests that
+// match Pattern (a route template; empty matches everything not claimed by
+// another group). The pool settings default to the global pool section;
+// Load fills them in, so a loaded config never has zero values here.
 type WorkerConfig struct {
 	Script  string   `yaml:"script"`
 	Pattern string   `yaml:"pattern"`
 	Count   int      `yaml:"count"`
-	Watch   []string `yaml:"watch"`
+	Watch   []string `yaml:"watch"` // globs below app.root whose changes reload this group alone
+
+	MaxJobs         int      `yaml:"max_jobs"`
+	MaxMemory       Size     `yaml:"max_memory"`
+	IdleTimeout     Duration `yaml:"idle_timeout"`
+	AllocateTimeout Duration `yaml:"allocate_timeout"`
+	RequestTimeout  Duration `yaml:"request_timeout"`
+}
+
+// inherit copies every pool setting the group leaves unset from pool.
+func (w *WorkerConfig) inherit(pool PoolConfig) {
+	if w.MaxJobs == 0 {
+		w.MaxJobs = pool.MaxJobs
+	}
+	if w.MaxMemory == 0 {
+		w.MaxMemory = pool.MaxMemory
+	}
+	if w.IdleTimeout == 0 {
+		w.IdleTimeout = pool.IdleTimeout
+	}
+	if w.AllocateTimeout == 0 {
+		w.AllocateTimeout = pool.AllocateTimeout
+	}
+	if w.RequestTimeout == 0 {
+		w.RequestTimeout = pool.RequestTimeout
+	}
 }
 
 // Duration is a time.Duration that supports YAML string unmarshaling.
@@ -134,12 +726,48 @@ func (d Duration) MarshalYAML() (interface{}, error) {
 	return time.Duration(d).String(), nil
 }
 
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by TOML).
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", text, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
 func (d Duration) Duration() time.Duration {
 	return time.Duration(d)
 }
 
-// Load reads config from a YAML file, applying defaults for missing values.
+// Load reads config from a YAML, JSON or TOML file (chosen by extension),
+// applying defaults for missing values.
+// ${VAR} and ${VAR:-default} references in values are expanded from the
+// environment before decoding.
 func Load(path string) (*Config, error) {
+	return LoadWithOptions(path, Options{})
+}
+
+// LoadWithOptions is Load with parsing options. Unknown keys are an error
+// unless strict is false in the file or opts.NonStrict is set, in which case
+// they are reported through Config.Warnings alongside deprecated keys.
+func LoadWithOptions(path string, opts Options) (*Config, error) {
 	cfg := Default()
 
 	data, err := os.ReadFile(path)
@@ -147,9 +775,40 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("reading config file: %w", err)
 	}
 
-	if err := yaml.Unmarshal(data, cfg); err != nil {
+	root, err := parseDocument(path, data)
+	if err != nil {
 		return nil, fmt.Errorf("parsing config file: %w", err)
 	}
+	if root.Kind != 0 {
+		envPaths, err := expandEnv(root, os.LookupEnv)
+		if err != nil {
+			return nil, fmt.Errorf("expanding environment variables: %w", err)
+		}
+		if err := resolveSecretFiles(root); err != nil {
+			return nil, fmt.Errorf("reading secret files: %w", err)
+		}
+		if err := root.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file: %w", err)
+		}
+		cfg.envPaths = envPaths
+
+		unknown := unknownKeys(root)
+		if len(unknown) > 0 && cfg.Strict && !opts.NonStrict {
+			errs := make([]error, len(unknown))
+			for i, w := range unknown {
+				errs[i] = errors.New(w.String())
+			}
+			return nil, fmt.Errorf("unknown config keys (set strict: false to ignore):\n%w", errors.Join(errs...))
+		}
+		cfg.warnings = append(cfg.warnings, unknown...)
+		cfg.warnings = append(cfg.warnings, checkDeprecated(root)...)
+		applyDeprecations(cfg)
+	}
+	if opts.Limits != nil {
+		explicit := lookupNode(root, "pool.min_workers") != nil || lookupNode(root, "pool.max_workers") != nil
+		cfg.sizePool(*opts.Limits, explicit)
+	}
+	cfg.fillWorkerDefaults()
 
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
@@ -158,44 +817,966 @@ func Load(path string) (*Config, error) {
 	return cfg, nil
 }
 
-// Validate checks the config for invalid values.
+// Validate checks the config for invalid values and reports every problem
+// found, joined into one error.
 func (c *Config) Validate() error {
+	var errs []error
 	if c.Pool.MinWorkers < 1 {
-		return fmt.Errorf("pool.min_workers must be >= 1, got %d", c.Pool.MinWorkers)
+		errs = append(errs, fmt.Errorf("pool.min_workers must be >= 1, got %d", c.Pool.MinWorkers))
 	}
 	if c.Pool.MaxWorkers < c.Pool.MinWorkers {
-		return fmt.Errorf("pool.max_workers (%d) must be >= pool.min_workers (%d)", c.Pool.MaxWorkers, c.Pool.MinWorkers)
+		errs = append(errs, fmt.Errorf("pool.max_workers (%d) must be >= pool.min_workers (%d)", c.Pool.MaxWorkers, c.Pool.MinWorkers))
 	}
 	if c.Pool.MaxJobs < 0 {
-		return fmt.Errorf("pool.max_jobs must be >= 0, got %d", c.Pool.MaxJobs)
+		errs = append(errs, fmt.Errorf("pool.max_jobs must be >= 0, got %d", c.Pool.MaxJobs))
+	}
+	if c.Pool.MaxMemory < 0 {
+		errs = append(errs, fmt.Errorf("pool.max_memory must not be negative, got %d", c.Pool.MaxMemory))
+	}
+	if c.Pool.MaxFrameSize < 0 {
+		errs = append(errs, fmt.Errorf("pool.max_frame_size must not be negative, got %d", c.Pool.MaxFrameSize))
+	}
+	if c.Pool.WorkerMemory < 0 {
+		errs = append(errs, fmt.Errorf("pool.worker_memory must not be negative, got %d", c.Pool.WorkerMemory))
+	}
+	if c.Pool.SpawnRate < 0 {
+		errs = append(errs, fmt.Errorf("pool.spawn_rate must not be negative, got %g", c.Pool.SpawnRate))
+	}
+	if c.Pool.RequestTimeout < 0 {
+		errs = append(errs, fmt.Errorf("pool.request_timeout must not be negative, got %s", c.Pool.RequestTimeout.Duration()))
+	}
+	if c.Pool.OnDemand && c.Pool.IdleTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("pool.idle_timeout must be positive with pool.on_demand, got %s", c.Pool.IdleTimeout.Duration()))
+	}
+	for _, tpl := range slices.Sorted(maps.Keys(c.Pool.RouteTimeouts)) {
+		if err := route.Validate(tpl); err != nil {
+			errs = append(errs, fmt.Errorf("pool.route_timeouts: %w", err))
+		} else if d := c.Pool.RouteTimeouts[tpl]; d <= 0 {
+			errs = append(errs, fmt.Errorf("pool.route_timeouts[%q] must be positive, got %s", tpl, d.Duration()))
+		}
+	}
+	if c.Server.MaxBodySize < 0 {
+		errs = append(errs, fmt.Errorf("server.max_body_size must not be negative, got %d", c.Server.MaxBodySize))
+	}
+	if c.Server.MaxResponseSize < 0 {
+		errs = append(errs, fmt.Errorf("server.max_response_size must not be negative, got %d", c.Server.MaxResponseSize))
+	}
+	if c.Server.ReadHeaderTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("server.read_header_timeout must be positive, got %s", c.Server.ReadHeaderTimeout.Duration()))
+	}
+	if c.Server.MinBodyRate < 0 {
+		errs = append(errs, fmt.Errorf("server.min_body_rate must not be negative, got %d", c.Server.MinBodyRate))
+	} else if c.Server.MinBodyRate > 0 && c.Server.BodyRateInterval <= 0 {
+		errs = append(errs, fmt.Errorf("server.body_rate_interval must be positive with server.min_body_rate, got %s", c.Server.BodyRateInterval.Duration()))
 	}
 
 	// Validate PHP mode
 	validModes := map[string]bool{"worker": true, "request": true}
 	if !validModes[c.PHP.Mode] {
-		return fmt.Errorf("php.mode must be 'worker' or 'request', got %q", c.PHP.Mode)
+		errs = append(errs, fmt.Errorf("php.mode must be 'worker' or 'request', got %q", c.PHP.Mode))
 	}
 
 	// Validate PHP version
-	validVersions := map[string]bool{
-		"auto": true, "7.4": true, "8.0": true,
-		"8.1": true, "8.2": true, "8.3": true, "8.4": true,
-	}
-	if !validVersions[c.PHP.Version] {
-		return fmt.Errorf("php.version must be auto or specific version (7.4-8.4), got %q", c.PHP.Version)
+	if c.PHP.Version != "auto" && !phpengine.IsSupported(c.PHP.Version) {
+		errs = append(errs, fmt.Errorf("php.version must be auto or one of %s, got %q",
+			strings.Join(phpengine.SupportedVersions, ", "), c.PHP.Version))
 	}
 
+	errs = append(errs, c.PHP.Sandbox.validate(c)...)
+
 	// Legacy: php.worker is only required for external PHP worker mode
 	// Embedded PHP mode (default) doesn't need worker script
 	if c.PHP.Binary != "" && c.PHP.Worker == "" && len(c.Workers) == 0 {
-		return fmt.Errorf("php.worker or workers[] is required when using external PHP binary")
+		errs = append(errs, fmt.Errorf("php.worker or workers[] is required when using external PHP binary"))
 	}
 
 	if c.Server.Address == "" {
-		return fmt.Errorf("server.address is required")
+		errs = append(errs, fmt.Errorf("server.address is required"))
 	}
-	if c.WebSocket.Enabled && c.WebSocket.Worker == "" {
-		return fmt.Errorf("websocket.worker is required when websocket is enabled")
+	if c.Server.Listeners < 0 {
+		errs = append(errs, fmt.Errorf("server.listeners must not be negative, got %d", c.Server.Listeners))
 	}
-	return nil
+	if c.Server.MaxConnections < 0 || c.Server.MaxConnectionsPerIP < 0 {
+		errs = append(errs, fmt.Errorf("server.max_connections and max_connections_per_ip must not be negative"))
+	}
+	for i, p := range c.Server.TrustedProxies {
+		if _, err := clientip.ParsePrefix(p); err != nil {
+			errs = append(errs, fmt.Errorf("server.trusted_proxies[%d]: %w", i, err))
+		}
+	}
+	if h := c.Server.CanonicalHost; h != "" && !validCanonicalHost(h) {
+		errs = append(errs, fmt.Errorf("server.canonical_host must be a host name with an optional port, such as example.com, got %q", h))
+	}
+	if c.Logging.MaxSize < 0 || c.Logging.MaxBackups < 0 || c.Logging.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("logging.max_size, max_backups and max_age must not be negative"))
+	}
+	if c.Logging.Audit.Keep < 0 {
+		errs = append(errs, fmt.Errorf("logging.audit.keep must not be negative, got %d", c.Logging.Audit.Keep))
+	}
+	if c.Logging.Request.SampleRate < 0 || c.Logging.Request.SampleRate > 1 {
+		errs = append(errs, fmt.Errorf("logging.request.sample_rate must be between 0 and 1, got %g", c.Logging.Request.SampleRate))
+	}
+	errs = append(errs, c.Metrics.validate()...)
+	if addr := c.Metrics.Address; addr != "" && (addr == c.Server.Address || (c.Admin.Enabled && addr == c.Admin.Address)) {
+		errs = append(errs, fmt.Errorf("metrics.address %q is already used by another listener", addr))
+	}
+	errs = append(errs, c.Temp.validate()...)
+	errs = append(errs, c.Cache.validate()...)
+	errs = append(errs, c.Coalesce.validate()...)
+	errs = append(errs, c.Hints.validate()...)
+	errs = append(errs, c.Access.validate()...)
+	errs = append(errs, c.Rewrites.validate()...)
+	errs = append(errs, c.Headers.validate()...)
+	errs = append(errs, c.ETag.validate()...)
+	errs = append(errs, c.Compression.validate()...)
+	errs = append(errs, c.Errors.validate()...)
+	errs = append(errs, c.Notify.validate()...)
+	errs = append(errs, c.Health.validate()...)
+	errs = append(errs, c.Schedule.validate()...)
+	errs = append(errs, c.validateJobs()...)
+	errs = append(errs, c.validateWorkers()...)
+	errs = append(errs, c.Watch.validate()...)
+	if c.Admin.Enabled && c.Admin.Address == "" {
+		errs = append(errs, fmt.Errorf("admin.address is required when admin is enabled"))
+	}
+	errs = append(errs, c.Admin.Eval.validate(c.Admin.Token)...)
+	if acme := c.Server.TLS.ACME; (acme.EABKeyID == "") != (acme.EABHMACKey == "") {
+		errs = append(errs, fmt.Errorf("server.tls.acme.eab_key_id and eab_hmac_key must be set together"))
+	}
+	if n := len(c.Debug.Secret); n > 0 && n < MinDebugSecret {
+		errs = append(errs, fmt.Errorf("debug.secret must be at least %d characters, got %d", MinDebugSecret, n))
+	}
+	errs = append(errs, c.WebSocket.validate()...)
+	return errors.Join(errs...)
+}
+
+func (w *WebSocketConfig) validate() []error {
+	var errs []error
+	if w.Enabled && w.Worker == "" {
+		errs = append(errs, fmt.Errorf("websocket.worker is required when websocket is enabled"))
+	}
+	if w.MinWorkers < 0 || w.MaxWorkers < 0 {
+		errs = append(errs, fmt.Errorf("websocket.min_workers and max_workers must not be negative"))
+	} else if w.MaxWorkers > 0 && w.MaxWorkers < w.MinWorkers {
+		errs = append(errs, fmt.Errorf("websocket.max_workers (%d) must be >= websocket.min_workers (%d)", w.MaxWorkers, w.MinWorkers))
+	}
+	if _, err := origin.Compile(w.AllowedOrigins); err != nil {
+		errs = append(errs, fmt.Errorf("websocket.allowed_origins: %w", err))
+	}
+	for i, h := range w.ForwardHeaders {
+		if h == "" || strings.ContainsAny(h, " \t:") {
+			errs = append(errs, fmt.Errorf("websocket.forward_headers[%d]: %q is not a header name", i, h))
+		}
+	}
+	if w.AuthEndpoint != "" && w.AuthTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("websocket.auth_timeout must be positive when auth_endpoint is set, got %s", w.AuthTimeout.Duration()))
+	}
+	if w.MaxConnections < 0 || w.MaxConnectionsPerIP < 0 {
+		errs = append(errs, fmt.Errorf("websocket.max_connections and max_connections_per_ip must not be negative"))
+	}
+	if w.MaxMessageSize < 0 {
+		errs = append(errs, fmt.Errorf("websocket.max_message_size must not be negative, got %d", w.MaxMessageSize))
+	}
+	if w.MessageRate < 0 {
+		errs = append(errs, fmt.Errorf("websocket.message_rate must not be negative, got %g", w.MessageRate))
+	}
+	if w.MessageRate > 0 && w.MessageBurst < 1 {
+		errs = append(errs, fmt.Errorf("websocket.message_burst must be at least 1 when message_rate is set, got %d", w.MessageBurst))
+	}
+	switch w.RateExceeded {
+	case "drop", "close":
+	default:
+		errs = append(errs, fmt.Errorf("websocket.rate_exceeded must be drop or close, got %q", w.RateExceeded))
+	}
+	if c := w.Compression; c.Enabled && (c.Level < 1 || c.Level > 9) {
+		errs = append(errs, fmt.Errorf("websocket.compression.level must be between 1 and 9, got %d", c.Level))
+	}
+	if w.Compression.Threshold < 0 {
+		errs = append(errs, fmt.Errorf("websocket.compression.threshold must not be negative, got %d", w.Compression.Threshold))
+	}
+	if w.PublishRate < 0 {
+		errs = append(errs, fmt.Errorf("websocket.publish_rate must not be negative, got %g", w.PublishRate))
+	}
+	if w.PublishRate > 0 && w.PublishBurst < 1 {
+		errs = append(errs, fmt.Errorf("websocket.publish_burst must be at least 1 when publish_rate is set, got %d", w.PublishBurst))
+	}
+	if w.SendQueue <= 0 {
+		errs = append(errs, fmt.Errorf("websocket.send_queue must be positive, got %d", w.SendQueue))
+	}
+	if w.MaxRoomSize < 0 {
+		errs = append(errs, fmt.Errorf("websocket.max_room_size must not be negative, got %d", w.MaxRoomSize))
+	}
+	if w.TickInterval < 0 {
+		errs = append(errs, fmt.Errorf("websocket.tick_interval must not be negative, got %s", w.TickInterval.Duration()))
+	}
+	if w.BroadcastParallelism < 0 {
+		errs = append(errs, fmt.Errorf("websocket.broadcast_parallelism must not be negative, got %d", w.BroadcastParallelism))
+	}
+	if w.WriteTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("websocket.write_timeout must be positive, got %s", w.WriteTimeout.Duration()))
+	}
+	switch w.SlowClient {
+	case "disconnect", "drop":
+	default:
+		errs = append(errs, fmt.Errorf("websocket.slow_client must be disconnect or drop, got %q", w.SlowClient))
+	}
+	if w.PingInterval < 0 {
+		errs = append(errs, fmt.Errorf("websocket.ping_interval must not be negative, got %s", w.PingInterval.Duration()))
+	}
+	if w.DrainTimeout < 0 || w.CloseSpread < 0 {
+		errs = append(errs, fmt.Errorf("websocket.drain_timeout and close_spread must not be negative"))
+	} else if w.CloseSpread > w.DrainTimeout {
+		errs = append(errs, fmt.Errorf("websocket.close_spread (%s) must not exceed websocket.drain_timeout (%s)", w.CloseSpread.Duration(), w.DrainTimeout.Duration()))
+	}
+	if r := w.Resume; r.Enabled {
+		if r.Buffer < 1 || r.BufferSize < 1 {
+			errs = append(errs, fmt.Errorf("websocket.resume.buffer and buffer_size must be positive when resume is enabled"))
+		}
+		if r.TTL <= 0 {
+			errs = append(errs, fmt.Errorf("websocket.resume.ttl must be positive when resume is enabled, got %s", r.TTL.Duration()))
+		}
+		if r.MaxSessions < 0 {
+			errs = append(errs, fmt.Errorf("websocket.resume.max_sessions must not be negative, got %d", r.MaxSessions))
+		}
+	}
+	for _, prefix := range w.PrivateRooms.Prefixes {
+		if prefix == "" {
+			errs = append(errs, fmt.Errorf("websocket.private_rooms.prefixes must not contain an empty prefix"))
+			break
+		}
+	}
+	if w.PrivateRooms.CacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("websocket.private_rooms.cache_ttl must not be negative, got %s", w.PrivateRooms.CacheTTL.Duration()))
+	}
+	if w.PingInterval > 0 && w.PongTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("websocket.pong_timeout must be positive when ping_interval is set, got %s", w.PongTimeout.Duration()))
+	}
+	seen := make(map[string]bool, len(w.Subprotocols))
+	for i, sp := range w.Subprotocols {
+		switch {
+		case !httpguts.ValidHeaderFieldName(sp.Name):
+			errs = append(errs, fmt.Errorf("websocket.subprotocols[%d].name: %q is not a subprotocol name", i, sp.Name))
+		case seen[sp.Name]:
+			errs = append(errs, fmt.Errorf("websocket.subprotocols[%d].name: %q is listed twice", i, sp.Name))
+		}
+		seen[sp.Name] = true
+	}
+	return errs
+}
+
+// fillWorkerDefaults makes every worker group inherit the pool settings it
+// does not override.
+func (c *Config) fillWorkerDefaults() {
+	for i := range c.Workers {
+		c.Workers[i].inherit(c.Pool)
+	}
+	if c.WebSocket.MinWorkers == 0 {
+		c.WebSocket.MinWorkers = c.Pool.MinWorkers
+	}
+	if c.WebSocket.MaxWorkers == 0 {
+		c.WebSocket.MaxWorkers = c.Pool.MaxWorkers
+	}
+}
+
+// WebSocketPool returns the pool settings of the websocket worker group:
+// the pool section with the group's own worker counts. pool.on_demand
+// applies to HTTP requests only.
+func (c *Config) WebSocketPool() PoolConfig {
+	pool := c.Pool
+	pool.OnDemand = false
+	if c.WebSocket.MinWorkers > 0 {
+		pool.MinWorkers = c.WebSocket.MinWorkers
+	}
+	if c.WebSocket.MaxWorkers > 0 {
+		pool.MaxWorkers = c.WebSocket.MaxWorkers
+	}
+	return pool
+}
+
+// HTTPWorkers returns the worker group HTTP requests are served by when
+// PHP runs as php.binary processes: the group without a pattern, with its
+// pool settings applied to the pool section. ok is false when requests
+// run on the embedded engine.
+func (c *Config) HTTPWorkers() (group WorkerConfig, pool PoolConfig, ok bool) {
+	if c.PHP.Binary == "" {
+		return WorkerConfig{}, PoolConfig{}, false
+	}
+	for _, w := range c.Workers {
+		if w.Pattern != "" {
+			continue
+		}
+		pool = c.Pool
+		pool.MinWorkers = w.Count
+		pool.MaxWorkers = max(w.Count, c.Pool.MaxWorkers)
+		pool.MaxJobs = w.MaxJobs
+		pool.MaxMemory = w.MaxMemory
+		pool.IdleTimeout = w.IdleTimeout
+		pool.AllocateTimeout = w.AllocateTimeout
+		pool.RequestTimeout = w.RequestTimeout
+		return w, pool, true
+	}
+	return WorkerConfig{}, PoolConfig{}, false
+}
+
+// GroupWatch returns the watcher config of the worker group w: its watch
+// globs, below app.root, reload it; nothing else does. Timing, backend
+// and exclusions are those of the watch section.
+func (c *Config) GroupWatch(w WorkerConfig) WatchConfig {
+	watch := c.Watch
+	watch.Enabled = len(w.Watch) > 0
+	watch.Dirs = []string{c.App.Root}
+	watch.Extensions = nil
+	watch.Include = make([]WatchRule, len(w.Watch))
+	for i, pattern := range w.Watch {
+		watch.Include[i] = WatchRule{Pattern: pattern, Action: WatchActionReload}
+	}
+	watch.Strategy = WatchStrategyReload
+	watch.FullReload = nil
+	return watch
+}
+
+func (c *Config) validateWorkers() []error {
+	var errs []error
+	patterns := make(map[string]int, len(c.Workers))
+	for i, w := range c.Workers {
+		prefix := fmt.Sprintf("workers[%d]", i)
+		if w.Script == "" {
+			errs = append(errs, fmt.Errorf("%s.script is required", prefix))
+		} else if c.PHP.Binary != "" {
+			if _, err := os.Stat(w.Script); err != nil {
+				errs = append(errs, fmt.Errorf("%s.script: %w", prefix, err))
+			}
+		}
+		if w.Count < 1 {
+			errs = append(errs, fmt.Errorf("%s.count must be >= 1, got %d", prefix, w.Count))
+		}
+		if w.Pattern != "" {
+			if err := route.Validate(w.Pattern); err != nil {
+				errs = append(errs, fmt.Errorf("%s.pattern: %w", prefix, err))
+			}
+		}
+		if j, ok := patterns[w.Pattern]; ok {
+			errs = append(errs, fmt.Errorf("%s.pattern %q duplicates workers[%d]", prefix, w.Pattern, j))
+		} else {
+			patterns[w.Pattern] = i
+		}
+		for k, pattern := range w.Watch {
+			if err := glob.Validate(pattern); err != nil {
+				errs = append(errs, fmt.Errorf("%s.watch[%d]: %w", prefix, k, err))
+			}
+		}
+		if w.MaxJobs < 0 || w.MaxMemory < 0 || w.IdleTimeout < 0 || w.AllocateTimeout < 0 || w.RequestTimeout < 0 {
+			errs = append(errs, fmt.Errorf("%s: max_jobs, max_memory and timeouts must not be negative", prefix))
+		}
+	}
+	return errs
+}
+
+// hostName matches a DNS name or an IPv4 address, and hostPort one with a
+// port.
+var (
+	hostName = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*\.?$`)
+	hostPort = regexp.MustCompile(`^(.+):([0-9]{1,5})$`)
+)
+
+// validCanonicalHost reports whether h is a host name, optionally with a
+// port, as server.canonical_host needs.
+func validCanonicalHost(h string) bool {
+	if m := hostPort.FindStringSubmatch(h); m != nil {
+		h = m[1]
+	}
+	return hostName.MatchString(h)
+}
+
+// StopSignals are the values jobs[].stop_signal accepts.
+var StopSignals = []string{"TERM", "INT", "QUIT", "HUP", "USR1", "USR2"}
+
+func (c *Config) validateJobs() []error {
+	var errs []error
+	seen := make(map[string]bool, len(c.Jobs))
+	for i, j := range c.Jobs {
+		if j.Name == "" {
+			errs = append(errs, fmt.Errorf("jobs[%d].name is required", i))
+			continue
+		}
+		if seen[j.Name] {
+			errs = append(errs, fmt.Errorf("jobs[%d]: duplicate name %q", i, j.Name))
+			continue
+		}
+		seen[j.Name] = true
+
+		if j.Script == "" {
+			errs = append(errs, fmt.Errorf("jobs[%d] (%s): script is required", i, j.Name))
+		}
+		if j.Count < 0 {
+			errs = append(errs, fmt.Errorf("jobs[%d] (%s): count must not be negative, got %d", i, j.Name, j.Count))
+		}
+		if j.StopSignal != "" && !slices.Contains(StopSignals, strings.TrimPrefix(strings.ToUpper(j.StopSignal), "SIG")) {
+			errs = append(errs, fmt.Errorf("jobs[%d] (%s): stop_signal must be one of %s, got %q", i, j.Name, strings.Join(StopSignals, ", "), j.StopSignal))
+		}
+		if j.MaxMemory > 0 && c.PHP.Binary == "" {
+			errs = append(errs, fmt.Errorf("jobs[%d] (%s): max_memory needs php.binary, the embedded engine shares maboo's memory", i, j.Name))
+		}
+		if j.MaxMemory < 0 || j.RestartDelay < 0 || j.MaxRestartDelay < 0 || j.StopGrace < 0 {
+			errs = append(errs, fmt.Errorf("jobs[%d] (%s): max_memory, restart_delay, max_restart_delay and stop_grace must not be negative", i, j.Name))
+		}
+	}
+	return errs
+}
+
+func (e *ErrorsConfig) validate() []error {
+	var errs []error
+	if e.SampleRate < 0 || e.SampleRate > 1 {
+		errs = append(errs, fmt.Errorf("error_reporting.sample_rate must be between 0 and 1, got %g", e.SampleRate))
+	}
+	if e.DSN == "" {
+		return errs
+	}
+	if _, err := errreport.ParseDSN(e.DSN.Value()); err != nil {
+		// The DSN is a secret: the error must not quote it.
+		errs = append(errs, fmt.Errorf("error_reporting.dsn is not a valid Sentry DSN"))
+	}
+	if e.QueueSize < 1 {
+		errs = append(errs, fmt.Errorf("error_reporting.queue_size must be >= 1, got %d", e.QueueSize))
+	}
+	if e.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("error_reporting.timeout must be positive, got %s", e.Timeout.Duration()))
+	}
+	return errs
+}
+
+func (e *AdminEvalConfig) validate(token Secret) []error {
+	var errs []error
+	if !e.Enabled {
+		return errs
+	}
+	if token == "" {
+		errs = append(errs, fmt.Errorf("admin.eval.enabled requires admin.token"))
+	}
+	if e.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("admin.eval.timeout must be positive, got %s", e.Timeout.Duration()))
+	}
+	if e.MaxOutput <= 0 {
+		errs = append(errs, fmt.Errorf("admin.eval.max_output must be positive, got %d", e.MaxOutput))
+	}
+	return errs
+}
+
+func (n *NotifyConfig) validate() []error {
+	var errs []error
+	if len(n.Webhooks) == 0 {
+		return errs
+	}
+	if n.QueueSize < 1 {
+		errs = append(errs, fmt.Errorf("notifications.queue_size must be >= 1, got %d", n.QueueSize))
+	}
+	if n.Retries < 0 {
+		errs = append(errs, fmt.Errorf("notifications.retries must not be negative, got %d", n.Retries))
+	}
+	if n.Backoff < 0 {
+		errs = append(errs, fmt.Errorf("notifications.backoff must not be negative, got %s", n.Backoff.Duration()))
+	}
+	if n.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("notifications.timeout must be positive, got %s", n.Timeout.Duration()))
+	}
+	for i, w := range n.Webhooks {
+		// The URL is a secret: errors must not quote it.
+		if u, err := url.Parse(w.URL.Value()); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("notifications.webhooks[%d].url must be an http or https URL", i))
+		}
+		for _, e := range w.Events {
+			if !slices.Contains(notify.Events, e) {
+				errs = append(errs, fmt.Errorf("notifications.webhooks[%d].events: unknown event %q (want one of %s)", i, e, strings.Join(notify.Events, ", ")))
+			}
+		}
+		if w.Template != "" {
+			if _, err := notify.ParseTemplate(w.Template); err != nil {
+				errs = append(errs, fmt.Errorf("notifications.webhooks[%d].template: %w", i, err))
+			}
+		}
+		if w.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("notifications.webhooks[%d].timeout must not be negative, got %s", i, w.Timeout.Duration()))
+		}
+	}
+	return errs
+}
+
+func (h *HintsConfig) validate() []error {
+	var errs []error
+	for _, tpl := range slices.Sorted(maps.Keys(h.Routes)) {
+		if err := route.Validate(tpl); err != nil {
+			errs = append(errs, fmt.Errorf("early_hints.routes: %w", err))
+			continue
+		}
+		for i, link := range h.Routes[tpl] {
+			if !strings.HasPrefix(link, "<") || !phpengine.ValidHeaderValue(link) {
+				errs = append(errs, fmt.Errorf("early_hints.routes[%s][%d] must be a Link header value like \"</app.css>; rel=preload; as=style\", got %q", tpl, i, link))
+			}
+		}
+	}
+	return errs
+}
+
+func (a *AccessConfig) validate() []error {
+	var errs []error
+	if a.Default != "allow" && a.Default != "deny" {
+		errs = append(errs, fmt.Errorf("access_control.default must be allow or deny, got %q", a.Default))
+	}
+	if a.DenyStatus != 403 && a.DenyStatus != 404 {
+		errs = append(errs, fmt.Errorf("access_control.deny_status must be 403 or 404, got %d", a.DenyStatus))
+	}
+	names := map[string]bool{}
+	for i, r := range a.Rules {
+		at := fmt.Sprintf("access_control.rules[%d]", i)
+		if r.Name != "" {
+			if names[r.Name] {
+				errs = append(errs, fmt.Errorf("%s.name %q is already used", at, r.Name))
+			}
+			names[r.Name] = true
+		}
+		if len(r.Allow) == 0 && len(r.Deny) == 0 {
+			errs = append(errs, fmt.Errorf("%s needs allow or deny addresses", at))
+		}
+		for j, p := range r.Paths {
+			if !strings.HasPrefix(p, "/") {
+				errs = append(errs, fmt.Errorf("%s.paths[%d] must start with /, got %q", at, j, p))
+			} else if IsAccessGlob(p) {
+				if err := glob.Validate(p[1:]); err != nil {
+					errs = append(errs, fmt.Errorf("%s.paths[%d]: %w", at, j, err))
+				}
+			}
+		}
+		if h := strings.TrimPrefix(r.Host, "*."); strings.ContainsAny(h, "*/: ") {
+			errs = append(errs, fmt.Errorf("%s.host must be a host name or *.domain, got %q", at, r.Host))
+		}
+		for j, addr := range r.Allow {
+			if _, err := clientip.ParsePrefix(addr); err != nil {
+				errs = append(errs, fmt.Errorf("%s.allow[%d]: %w", at, j, err))
+			}
+		}
+		for j, addr := range r.Deny {
+			if _, err := clientip.ParsePrefix(addr); err != nil {
+				errs = append(errs, fmt.Errorf("%s.deny[%d]: %w", at, j, err))
+			}
+		}
+	}
+	return errs
+}
+
+func (h *HeadersConfig) validate() []error {
+	var errs []error
+	for i, p := range h.InternalPrefixes {
+		if !phpengine.ValidHeaderName(p) {
+			errs = append(errs, fmt.Errorf("response_headers.internal_prefixes[%d]: invalid header name prefix %q", i, p))
+		}
+	}
+	for _, list := range []struct {
+		key   string
+		names []string
+	}{{"deny", h.Deny}, {"allow", h.Allow}} {
+		for i, name := range list.names {
+			if !phpengine.ValidHeaderName(name) {
+				errs = append(errs, fmt.Errorf("response_headers.%s[%d]: invalid header name %q", list.key, i, name))
+			}
+		}
+	}
+	return errs
+}
+
+func (r *RewritesConfig) validate() []error {
+	var errs []error
+	if r.MaxIterations < 1 {
+		errs = append(errs, fmt.Errorf("rewrites.max_iterations must be at least 1, got %d", r.MaxIterations))
+	}
+	for i, rule := range r.Rules {
+		at := fmt.Sprintf("rewrites.rules[%d]", i)
+		for _, re := range []struct{ field, expr string }{{"match", rule.Match}, {"host", rule.Host}, {"query", rule.Query}} {
+			if _, err := regexp.Compile(re.expr); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %w", at, re.field, err))
+			}
+		}
+		if rule.Match == "" {
+			errs = append(errs, fmt.Errorf("%s.match is required", at))
+		}
+		switch rule.Flag {
+		case "", "last", "php":
+			if rule.To != "-" && !strings.HasPrefix(rule.To, "/") {
+				errs = append(errs, fmt.Errorf("%s.to must be a path starting with / or -, got %q", at, rule.To))
+			}
+		case "redirect":
+			if rule.To == "" || rule.To == "-" {
+				errs = append(errs, fmt.Errorf("%s.to must be a path or URL to redirect to", at))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s.flag must be last, redirect or php, got %q", at, rule.Flag))
+		}
+		switch {
+		case rule.Status == 0:
+		case rule.Flag != "redirect":
+			errs = append(errs, fmt.Errorf("%s.status only applies to flag: redirect", at))
+		case !slices.Contains([]int{301, 302, 303, 307, 308}, rule.Status):
+			errs = append(errs, fmt.Errorf("%s.status must be 301, 302, 303, 307 or 308, got %d", at, rule.Status))
+		}
+	}
+	return errs
+}
+
+// IsAccessGlob reports whether an access_control path is a glob rather
+// than a prefix.
+func IsAccessGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[{")
+}
+
+// phpFunctionName matches the names disable_functions takes.
+var phpFunctionName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s *SandboxConfig) validate(c *Config) []error {
+	errs := s.Rule().validate("php.sandbox", c.App.Root)
+	for _, tpl := range slices.Sorted(maps.Keys(s.Routes)) {
+		if err := route.Validate(tpl); err != nil {
+			errs = append(errs, fmt.Errorf("php.sandbox.routes: %w", err))
+			continue
+		}
+		errs = append(errs, s.Routes[tpl].validate(fmt.Sprintf("php.sandbox.routes[%q]", tpl), c.App.Root)...)
+	}
+	if _, _, ok := c.HTTPWorkers(); ok && len(s.Routes) > 0 {
+		errs = append(errs, fmt.Errorf("php.sandbox.routes needs the embedded engine: worker processes are sandboxed when they start, for every request"))
+	}
+	return errs
+}
+
+// validate checks r, reporting errors under path. open_basedir must
+// contain root, or PHP could not open the scripts it serves.
+func (r SandboxRule) validate(path, root string) []error {
+	var errs []error
+	for i, dir := range r.OpenBasedir {
+		if !filepath.IsAbs(dir) {
+			errs = append(errs, fmt.Errorf("%s.open_basedir[%d] must be an absolute path, got %q", path, i, dir))
+		}
+	}
+	if len(errs) == 0 && len(r.OpenBasedir) > 0 {
+		if abs, err := filepath.Abs(root); err == nil && !slices.ContainsFunc(r.OpenBasedir, func(dir string) bool { return within(abs, dir) }) {
+			errs = append(errs, fmt.Errorf("%s.open_basedir must contain app.root (%s), or PHP cannot open its scripts", path, abs))
+		}
+	}
+	for i, name := range r.DisableFunctions {
+		if !phpFunctionName.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%s.disable_functions[%d] must be a function name, got %q", path, i, name))
+		}
+	}
+	switch strings.ToLower(r.AllowURLFopen) {
+	case "", "on", "off", "true", "false", "1", "0":
+	default:
+		errs = append(errs, fmt.Errorf("%s.allow_url_fopen must be on or off, got %q", path, r.AllowURLFopen))
+	}
+	return errs
+}
+
+// within reports whether path is dir or below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (t *TempConfig) validate() []error {
+	var errs []error
+	if t.MaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("temp.max_bytes must not be negative, got %d", t.MaxBytes))
+	}
+	if t.SweepInterval < 0 {
+		errs = append(errs, fmt.Errorf("temp.sweep_interval must not be negative, got %s", t.SweepInterval.Duration()))
+	}
+	if t.SweepInterval > 0 && t.OrphanAge <= 0 {
+		errs = append(errs, fmt.Errorf("temp.orphan_age must be positive when temp.sweep_interval is set, got %s", t.OrphanAge.Duration()))
+	}
+	return errs
+}
+
+func (c *CacheConfig) validate() []error {
+	var errs []error
+	if c.Enabled && c.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("cache.ttl must be positive, got %s", c.TTL.Duration()))
+	}
+	if c.Enabled && c.MaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("cache.max_size must be positive, got %d", c.MaxSize))
+	}
+	for i, tpl := range c.Routes {
+		if err := route.Validate(tpl); err != nil {
+			errs = append(errs, fmt.Errorf("cache.routes[%d]: %w", i, err))
+		}
+	}
+	for i, h := range c.VaryHeaders {
+		if h == "" {
+			errs = append(errs, fmt.Errorf("cache.vary_headers[%d] must not be empty", i))
+		}
+	}
+	for i, prefix := range c.VaryCookies {
+		if prefix == "" {
+			errs = append(errs, fmt.Errorf("cache.vary_cookies[%d] must not be empty", i))
+		}
+	}
+	for i, prefix := range c.BypassCookies {
+		if prefix == "" {
+			errs = append(errs, fmt.Errorf("cache.bypass_cookies[%d] must not be empty", i))
+		}
+	}
+	return errs
+}
+
+func (c *CoalesceConfig) validate() []error {
+	var errs []error
+	if c.Enabled && c.MaxWait <= 0 {
+		errs = append(errs, fmt.Errorf("coalesce.max_wait must be positive, got %s", c.MaxWait.Duration()))
+	}
+	for i, tpl := range c.Routes {
+		if err := route.Validate(tpl); err != nil {
+			errs = append(errs, fmt.Errorf("coalesce.routes[%d]: %w", i, err))
+		}
+	}
+	for i, h := range c.VaryHeaders {
+		if h == "" {
+			errs = append(errs, fmt.Errorf("coalesce.vary_headers[%d] must not be empty", i))
+		}
+	}
+	return errs
+}
+
+func (m *MetricsConfig) validate() []error {
+	var errs []error
+	if m.Enabled && m.Path == "" && m.Address == "" {
+		errs = append(errs, fmt.Errorf("metrics.path or metrics.address is required when metrics is enabled"))
+	}
+	if m.Path != "" && !strings.HasPrefix(m.Path, "/") {
+		errs = append(errs, fmt.Errorf("metrics.path must start with /, got %q", m.Path))
+	}
+	if sock, ok := strings.CutPrefix(m.Address, MetricsUnixPrefix); ok {
+		if sock == "" {
+			errs = append(errs, fmt.Errorf("metrics.address needs a socket path after %s", MetricsUnixPrefix))
+		}
+	} else if m.Address != "" {
+		if _, _, err := net.SplitHostPort(m.Address); err != nil {
+			errs = append(errs, fmt.Errorf("metrics.address must be host:port or unix:/path, got %q", m.Address))
+		}
+	}
+	for i, addr := range m.Allow {
+		if _, err := clientip.ParsePrefix(addr); err != nil {
+			errs = append(errs, fmt.Errorf("metrics.allow[%d]: %w", i, err))
+		}
+	}
+	if len(m.RouteLabels) > MaxRouteLabels {
+		errs = append(errs, fmt.Errorf("metrics.route_labels allows at most %d templates, got %d", MaxRouteLabels, len(m.RouteLabels)))
+	}
+	for i, tpl := range m.RouteLabels {
+		if err := route.Validate(tpl); err != nil {
+			errs = append(errs, fmt.Errorf("metrics.route_labels[%d]: %w", i, err))
+		}
+	}
+	return errs
+}
+
+func (e *ETagConfig) validate() []error {
+	var errs []error
+	for i, tpl := range e.Routes {
+		if err := route.Validate(tpl); err != nil {
+			errs = append(errs, fmt.Errorf("etag.routes[%d]: %w", i, err))
+		}
+	}
+	return errs
+}
+
+func (c *CompressionConfig) validate() []error {
+	var errs []error
+	if c.Enabled && len(c.Algorithms) == 0 {
+		errs = append(errs, fmt.Errorf("compression.algorithms must not be empty when compression is enabled"))
+	}
+	for i, a := range c.Algorithms {
+		if !slices.Contains(CompressionAlgorithms, a) {
+			errs = append(errs, fmt.Errorf("compression.algorithms[%d] must be one of %s, got %q", i, strings.Join(CompressionAlgorithms, ", "), a))
+		} else if slices.Index(c.Algorithms, a) < i {
+			errs = append(errs, fmt.Errorf("compression.algorithms[%d] repeats %q", i, a))
+		}
+	}
+	if c.MinSize < 0 {
+		errs = append(errs, fmt.Errorf("compression.min_size must not be negative, got %d", c.MinSize))
+	}
+	if c.Level < 1 || c.Level > 9 {
+		errs = append(errs, fmt.Errorf("compression.level must be between 1 and 9, got %d", c.Level))
+	}
+	if c.Enabled && len(c.ContentTypes) == 0 {
+		errs = append(errs, fmt.Errorf("compression.content_types must not be empty when compression is enabled"))
+	}
+	for i, t := range c.ContentTypes {
+		if t == "" || t == "+" || t == "/" {
+			errs = append(errs, fmt.Errorf("compression.content_types[%d]: invalid media type %q", i, t))
+		}
+	}
+	for _, tpl := range slices.Sorted(maps.Keys(c.Routes)) {
+		if err := route.Validate(tpl); err != nil {
+			errs = append(errs, fmt.Errorf("compression.routes: %w", err))
+			continue
+		}
+		switch r := c.Routes[tpl]; {
+		case r.Disable && r.Level != 0:
+			errs = append(errs, fmt.Errorf("compression.routes[%q] sets both disable and level", tpl))
+		case r.Level < 0 || r.Level > 9:
+			errs = append(errs, fmt.Errorf("compression.routes[%q].level must be between 1 and 9 (0 = compression.level), got %d", tpl, r.Level))
+		}
+	}
+	return errs
+}
+
+func (w *WatchConfig) validate() []error {
+	var errs []error
+	switch w.Backend {
+	case "", "auto", "fsnotify", "poll":
+	default:
+		errs = append(errs, fmt.Errorf("watch.backend must be auto, fsnotify or poll, got %q", w.Backend))
+	}
+	// auto falls back to polling, so only fsnotify can do without an interval.
+	if w.Enabled && w.Backend != "fsnotify" && w.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("watch.interval must be positive unless watch.backend is fsnotify, got %s", w.Interval.Duration()))
+	}
+	if w.Debounce < 0 || w.MaxDelay < 0 || w.Cooldown < 0 {
+		errs = append(errs, fmt.Errorf("watch.debounce, max_delay and cooldown must not be negative"))
+	} else if w.MaxDelay > 0 && w.MaxDelay < w.Debounce {
+		errs = append(errs, fmt.Errorf("watch.max_delay (%s) must be >= watch.debounce (%s)", w.MaxDelay.Duration(), w.Debounce.Duration()))
+	}
+	for i, ext := range w.Extensions {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext, "/*?[") {
+			errs = append(errs, fmt.Errorf("watch.extensions[%d] must look like \".php\", got %q", i, ext))
+		}
+	}
+	for i, r := range w.Include {
+		if err := glob.Validate(r.Pattern); err != nil {
+			errs = append(errs, fmt.Errorf("watch.include[%d].pattern: %w", i, err))
+		}
+		switch r.Action {
+		case "", WatchActionReload, WatchActionConfig, WatchActionLog:
+		default:
+			errs = append(errs, fmt.Errorf("watch.include[%d].action must be reload, config or log, got %q", i, r.Action))
+		}
+	}
+	for i, p := range w.Exclude {
+		if err := glob.Validate(p); err != nil {
+			errs = append(errs, fmt.Errorf("watch.exclude[%d]: %w", i, err))
+		}
+	}
+	switch w.Strategy {
+	case "", WatchStrategyReload, WatchStrategyInvalidate:
+	default:
+		errs = append(errs, fmt.Errorf("watch.strategy must be reload or invalidate, got %q", w.Strategy))
+	}
+	if w.InvalidateMax < 0 {
+		errs = append(errs, fmt.Errorf("watch.invalidate_max must not be negative, got %d", w.InvalidateMax))
+	}
+	for i, p := range w.FullReload {
+		if err := glob.Validate(p); err != nil {
+			errs = append(errs, fmt.Errorf("watch.full_reload[%d]: %w", i, err))
+		}
+	}
+	return errs
+}
+
+func (h *HealthConfig) validate() []error {
+	var errs []error
+	switch h.Policy {
+	case "", "any", "quorum":
+	default:
+		errs = append(errs, fmt.Errorf("health.policy must be 'any' or 'quorum', got %q", h.Policy))
+	}
+
+	seen := make(map[string]bool, len(h.Checks))
+	for i, c := range h.Checks {
+		if c.Name == "" {
+			errs = append(errs, fmt.Errorf("health.checks[%d].name is required", i))
+			continue
+		}
+		if seen[c.Name] {
+			errs = append(errs, fmt.Errorf("health.checks[%d]: duplicate name %q", i, c.Name))
+			continue
+		}
+		seen[c.Name] = true
+
+		switch c.Type {
+		case "tcp":
+			if c.Address == "" {
+				errs = append(errs, fmt.Errorf("health.checks[%d] (%s): address is required for tcp probes", i, c.Name))
+			}
+		case "http":
+			if c.URL == "" {
+				errs = append(errs, fmt.Errorf("health.checks[%d] (%s): url is required for http probes", i, c.Name))
+			}
+		case "php":
+			if c.Script == "" {
+				errs = append(errs, fmt.Errorf("health.checks[%d] (%s): script is required for php probes", i, c.Name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("health.checks[%d] (%s): type must be tcp, http or php, got %q", i, c.Name, c.Type))
+		}
+
+		if c.Interval < 0 || c.Timeout < 0 || c.FailureThreshold < 0 {
+			errs = append(errs, fmt.Errorf("health.checks[%d] (%s): interval, timeout and failure_threshold must not be negative", i, c.Name))
+		}
+	}
+
+	if p := h.SelfTest.Path; p != "" && !strings.HasPrefix(p, "/") {
+		errs = append(errs, fmt.Errorf("health.self_test.path must start with /, got %q", p))
+	}
+	if h.SelfTest.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("health.self_test.timeout must not be negative, got %s", h.SelfTest.Timeout.Duration()))
+	}
+	return errs
+}
+
+func (s *ScheduleConfig) validate() []error {
+	var errs []error
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("schedule.timezone: %w", err))
+		}
+	}
+
+	seen := make(map[string]bool, len(s.Jobs))
+	for i, j := range s.Jobs {
+		if j.Name == "" {
+			errs = append(errs, fmt.Errorf("schedule.jobs[%d].name is required", i))
+			continue
+		}
+		if seen[j.Name] {
+			errs = append(errs, fmt.Errorf("schedule.jobs[%d]: duplicate name %q", i, j.Name))
+			continue
+		}
+		seen[j.Name] = true
+
+		if _, err := cron.Parse(j.Cron); err != nil {
+			errs = append(errs, fmt.Errorf("schedule.jobs[%d] (%s): cron: %w", i, j.Name, err))
+		}
+		if j.Script == "" {
+			errs = append(errs, fmt.Errorf("schedule.jobs[%d] (%s): script is required", i, j.Name))
+		}
+		switch j.Mode {
+		case "", "cli":
+		case "request":
+			if len(j.Args) > 0 {
+				errs = append(errs, fmt.Errorf("schedule.jobs[%d] (%s): args only apply to cli jobs", i, j.Name))
+			}
+			if j.Overlap == "kill" {
+				errs = append(errs, fmt.Errorf("schedule.jobs[%d] (%s): overlap kill needs mode cli, the worker pool cannot cancel a request", i, j.Name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("schedule.jobs[%d] (%s): mode must be cli or request, got %q", i, j.Name, j.Mode))
+		}
+		switch j.Overlap {
+		case "", "skip", "queue", "kill":
+		default:
+			errs = append(errs, fmt.Errorf("schedule.jobs[%d] (%s): overlap must be skip, queue or kill, got %q", i, j.Name, j.Overlap))
+		}
+		if j.Timeout < 0 || j.Jitter < 0 {
+			errs = append(errs, fmt.Errorf("schedule.jobs[%d] (%s): timeout and jitter must not be negative", i, j.Name))
+		}
+		if j.CatchUp && s.StateFile == "" {
+			errs = append(errs, fmt.Errorf("schedule.jobs[%d] (%s): catch_up requires schedule.state_file", i, j.Name))
+		}
+	}
+	return errs
 }
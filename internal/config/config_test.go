@@ -1,8 +1,14 @@
 package config_test
 
 import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/sadewadee/maboo/internal/cgroup"
 	"github.com/sadewadee/maboo/internal/config"
 )
 
@@ -112,3 +118,1133 @@ func TestValidatePHPVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateWatchBackend(t *testing.T) {
+	tests := []struct {
+		backend   string
+		interval  time.Duration
+		expectErr bool
+	}{
+		{"auto", 2 * time.Second, false},
+		{"fsnotify", 0, false},
+		{"poll", time.Second, false},
+		{"poll", 0, true},
+		{"auto", 0, true},
+		{"inotify", time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Watch.Enabled = true
+			cfg.Watch.Backend = tt.backend
+			cfg.Watch.Interval = config.Duration(tt.interval)
+
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateWatchDebounce(t *testing.T) {
+	cfg := config.Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	cfg.Watch.MaxDelay = config.Duration(100 * time.Millisecond)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "watch.max_delay") {
+		t.Errorf("max_delay below debounce: err = %v", err)
+	}
+	cfg.Watch.MaxDelay = 0
+	cfg.Watch.Cooldown = config.Duration(-time.Second)
+	if err := cfg.Validate(); err == nil {
+		t.Error("negative cooldown accepted")
+	}
+}
+
+func TestGroupWatch(t *testing.T) {
+	cfg := config.Default()
+	cfg.App.Root = "/srv/app"
+	cfg.Watch.Strategy = config.WatchStrategyInvalidate
+	w := config.WorkerConfig{Script: "billing.php", Count: 1, Watch: []string{"billing/**/*.php", "billing.php"}}
+
+	got := cfg.GroupWatch(w)
+	if !got.Enabled || len(got.Dirs) != 1 || got.Dirs[0] != "/srv/app" {
+		t.Errorf("enabled %v, dirs %v; want enabled on app.root", got.Enabled, got.Dirs)
+	}
+	if len(got.Include) != 2 || got.Include[0] != (config.WatchRule{Pattern: "billing/**/*.php", Action: config.WatchActionReload}) {
+		t.Errorf("include = %+v, want the group's globs, reloading", got.Include)
+	}
+	if len(got.Extensions) != 0 || len(got.FullReload) != 0 || got.Strategy != config.WatchStrategyReload {
+		t.Errorf("extensions %v, full_reload %v, strategy %q; want only the globs, reloading", got.Extensions, got.FullReload, got.Strategy)
+	}
+	if got.Debounce != cfg.Watch.Debounce || len(got.Exclude) != len(cfg.Watch.Exclude) {
+		t.Errorf("debounce %s, exclude %v; want the watch section's", got.Debounce.Duration(), got.Exclude)
+	}
+	if cfg.GroupWatch(config.WorkerConfig{Script: "index.php"}).Enabled {
+		t.Error("group without watch globs is watched")
+	}
+}
+
+func TestValidateWatchPatterns(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(w *config.WatchConfig)
+		errStr string
+	}{
+		{"valid", func(w *config.WatchConfig) {
+			w.Include = []config.WatchRule{{Pattern: ".env", Action: "config"}, {Pattern: "config/**/*.{yaml,yml}"}}
+			w.Exclude = []string{"storage/**"}
+		}, ""},
+		{"bad include", func(w *config.WatchConfig) {
+			w.Include = []config.WatchRule{{Pattern: "config/[a-.yaml"}}
+		}, "watch.include[0].pattern"},
+		{"absolute include", func(w *config.WatchConfig) {
+			w.Include = []config.WatchRule{{Pattern: "/etc/app.yaml"}}
+		}, "watch.include[0].pattern"},
+		{"bad action", func(w *config.WatchConfig) {
+			w.Include = []config.WatchRule{{Pattern: ".env", Action: "restart"}}
+		}, "watch.include[0].action"},
+		{"bad exclude", func(w *config.WatchConfig) {
+			w.Exclude = []string{"**/vendor/**", "{storage"}
+		}, "watch.exclude[1]"},
+		{"bad extension", func(w *config.WatchConfig) {
+			w.Extensions = []string{"php"}
+		}, "watch.extensions[0]"},
+		{"invalidate strategy", func(w *config.WatchConfig) {
+			w.Strategy = "invalidate"
+			w.FullReload = []string{"composer.lock", "config/*.php"}
+		}, ""},
+		{"bad strategy", func(w *config.WatchConfig) {
+			w.Strategy = "restart"
+		}, "watch.strategy"},
+		{"negative invalidate_max", func(w *config.WatchConfig) {
+			w.InvalidateMax = -1
+		}, "watch.invalidate_max"},
+		{"bad full_reload", func(w *config.WatchConfig) {
+			w.FullReload = []string{".env", "config/[a-.php"}
+		}, "watch.full_reload[1]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.modify(&cfg.Watch)
+			err := cfg.Validate()
+			if tt.errStr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errStr) {
+				t.Errorf("err = %v, want it to mention %s", err, tt.errStr)
+			}
+		})
+	}
+}
+
+func TestValidateWebSocketKeepalive(t *testing.T) {
+	cfg := config.Default()
+	cfg.WebSocket.PingInterval = 0
+	cfg.WebSocket.PongTimeout = 0
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("keepalive disabled: %v", err)
+	}
+	cfg.WebSocket.PingInterval = config.Duration(-time.Second)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.ping_interval") {
+		t.Errorf("negative ping_interval: err = %v", err)
+	}
+	cfg.WebSocket.PingInterval = config.Duration(30 * time.Second)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.pong_timeout") {
+		t.Errorf("ping_interval without pong_timeout: err = %v", err)
+	}
+}
+
+func TestValidateWebSocketSubprotocols(t *testing.T) {
+	tests := []struct {
+		names []string
+		err   string
+	}{
+		{[]string{"graphql-ws", "mqtt", "v2.chat.example.com"}, ""},
+		{[]string{""}, "websocket.subprotocols[0].name"},
+		{[]string{"graphql-ws", "chat, v2"}, "websocket.subprotocols[1].name"},
+		{[]string{"mqtt", "mqtt"}, `"mqtt" is listed twice`},
+	}
+	for _, tt := range tests {
+		cfg := config.Default()
+		for _, name := range tt.names {
+			cfg.WebSocket.Subprotocols = append(cfg.WebSocket.Subprotocols, config.WebSocketSubprotocolConfig{Name: name})
+		}
+		err := cfg.Validate()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%q: %v", tt.names, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: err = %v, want %q", tt.names, err, tt.err)
+		}
+	}
+}
+
+func TestValidateNotifications(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook config.WebhookConfig
+		err     string
+	}{
+		{"valid", config.WebhookConfig{URL: "https://hooks.slack.com/services/T0/B0/x", Events: []string{"worker_crash_loop", "acme_failure"},
+			Template: `{"text": {{json .Message}}}`}, ""},
+		{"not a URL", config.WebhookConfig{URL: "hooks.slack.com/services/T0KEN"}, "notifications.webhooks[0].url"},
+		{"unknown event", config.WebhookConfig{URL: "https://example.com/hook", Events: []string{"circuit_open"}}, `unknown event "circuit_open"`},
+		{"bad template", config.WebhookConfig{URL: "https://example.com/hook", Template: `{"text": {{.Message}`}, "notifications.webhooks[0].template"},
+	}
+	for _, tt := range tests {
+		cfg := config.Default()
+		cfg.Notify.Webhooks = []config.WebhookConfig{tt.webhook}
+		err := cfg.Validate()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+		if err != nil && strings.Contains(err.Error(), "T0KEN") {
+			t.Errorf("%s: error quotes the URL: %v", tt.name, err)
+		}
+	}
+}
+
+func TestValidateAdminEval(t *testing.T) {
+	cfg := config.Default()
+	cfg.Admin.Enabled = true
+	cfg.Admin.Eval.Enabled = true
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "admin.eval.enabled requires admin.token") {
+		t.Errorf("eval without a token: err = %v", err)
+	}
+	cfg.Admin.Token = "s3cret"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("eval with a token: %v", err)
+	}
+	cfg.Admin.Eval.MaxOutput = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "admin.eval.max_output") {
+		t.Errorf("max_output 0: err = %v", err)
+	}
+}
+
+func TestValidateRouteTimeouts(t *testing.T) {
+	cfg := config.Default()
+	cfg.Pool.RouteTimeouts = map[string]config.Duration{"/admin/reports/*": config.Duration(5 * time.Minute)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid route timeout: %v", err)
+	}
+	cfg.Pool.RouteTimeouts["/admin/*/export"] = config.Duration(time.Minute)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "pool.route_timeouts") {
+		t.Errorf("wildcard before the last segment: err = %v", err)
+	}
+	delete(cfg.Pool.RouteTimeouts, "/admin/*/export")
+	cfg.Pool.RouteTimeouts["/upload"] = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `pool.route_timeouts["/upload"]`) {
+		t.Errorf("zero route timeout: err = %v", err)
+	}
+}
+
+func TestValidateSpawnRate(t *testing.T) {
+	cfg := config.Default()
+	cfg.Pool.SpawnRate = 0
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("demand spawning disabled: %v", err)
+	}
+	cfg.Pool.SpawnRate = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "pool.spawn_rate") {
+		t.Errorf("negative spawn_rate: err = %v", err)
+	}
+}
+
+func TestValidateOnDemand(t *testing.T) {
+	cfg := config.Default()
+	cfg.Pool.OnDemand = true
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("on-demand pool: %v", err)
+	}
+	if cfg.WebSocketPool().OnDemand {
+		t.Error("websocket group inherits pool.on_demand")
+	}
+	cfg.Pool.IdleTimeout = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "pool.idle_timeout") {
+		t.Errorf("on-demand pool without idle_timeout: err = %v", err)
+	}
+}
+
+func TestValidateDebug(t *testing.T) {
+	cfg := config.Default()
+	cfg.Debug.Secret = "0123456789abcdef"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid secret: %v", err)
+	}
+	cfg.Debug.Secret = "letmein"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "debug.secret") {
+		t.Errorf("short secret: err = %v", err)
+	}
+}
+
+func TestValidateSlowClients(t *testing.T) {
+	cfg := config.Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	cfg.Server.ReadHeaderTimeout = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.read_header_timeout") {
+		t.Errorf("no header timeout: err = %v", err)
+	}
+	cfg.Server.ReadHeaderTimeout = config.Duration(time.Second)
+	cfg.Server.BodyRateInterval = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.body_rate_interval") {
+		t.Errorf("rate without an interval: err = %v", err)
+	}
+	cfg.Server.MinBodyRate = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("no minimum rate: %v", err)
+	}
+	cfg.Server.MinBodyRate = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.min_body_rate") {
+		t.Errorf("negative rate: err = %v", err)
+	}
+}
+
+func TestValidateTemp(t *testing.T) {
+	cfg := config.Default()
+	cfg.Temp.MaxBytes = 512 * config.MiB
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid temp: %v", err)
+	}
+	cfg.Temp.MaxBytes = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "temp.max_bytes") {
+		t.Errorf("negative max_bytes: err = %v", err)
+	}
+	cfg.Temp.MaxBytes = 0
+	cfg.Temp.OrphanAge = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "temp.orphan_age") {
+		t.Errorf("sweeping without an orphan age: err = %v", err)
+	}
+	cfg.Temp.SweepInterval = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("no sweep: %v", err)
+	}
+}
+
+func TestValidateSandbox(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.Default()
+	cfg.App.Root = root
+	cfg.PHP.Sandbox = config.SandboxConfig{
+		OpenBasedir:      []string{filepath.Dir(root), "/tmp"},
+		DisableFunctions: []string{"exec", "shell_exec"},
+		AllowURLFopen:    "off",
+		Routes:           map[string]config.SandboxRule{"/admin/*": {AllowURLFopen: "on"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid sandbox: %v", err)
+	}
+	want := map[string]string{"open_basedir": filepath.Dir(root) + string(os.PathListSeparator) + "/tmp", "disable_functions": "exec,shell_exec", "allow_url_fopen": "1"}
+	if got := cfg.PHP.Sandbox.Routes["/admin/*"].Over(cfg.PHP.Sandbox.Rule()).INI(); !maps.Equal(got, want) {
+		t.Errorf("route INI = %v, want %v", got, want)
+	}
+
+	cfg.PHP.Sandbox.OpenBasedir = []string{"/srv/other"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "php.sandbox.open_basedir must contain app.root") {
+		t.Errorf("basedir without the document root: err = %v", err)
+	}
+	cfg.PHP.Sandbox.OpenBasedir = []string{root + "-old"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "must contain app.root") {
+		t.Errorf("basedir sharing a prefix with the document root: err = %v", err)
+	}
+	cfg.PHP.Sandbox.OpenBasedir = []string{"app"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "php.sandbox.open_basedir[0] must be an absolute path") {
+		t.Errorf("relative basedir: err = %v", err)
+	}
+	cfg.PHP.Sandbox.OpenBasedir = nil
+	cfg.PHP.Sandbox.Routes["/uploads/*"] = config.SandboxRule{OpenBasedir: []string{"/srv/uploads"}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `php.sandbox.routes["/uploads/*"].open_basedir`) {
+		t.Errorf("route basedir without the document root: err = %v", err)
+	}
+	delete(cfg.PHP.Sandbox.Routes, "/uploads/*")
+	cfg.PHP.Sandbox.DisableFunctions = []string{"exec()"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "php.sandbox.disable_functions[0]") {
+		t.Errorf("bad function name: err = %v", err)
+	}
+	cfg.PHP.Sandbox.DisableFunctions = nil
+	cfg.PHP.Sandbox.AllowURLFopen = "maybe"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "php.sandbox.allow_url_fopen") {
+		t.Errorf("bad allow_url_fopen: err = %v", err)
+	}
+	cfg.PHP.Sandbox.AllowURLFopen = ""
+	cfg.PHP.Sandbox.Routes["admin"] = config.SandboxRule{}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "php.sandbox.routes") {
+		t.Errorf("route without a leading slash: err = %v", err)
+	}
+	delete(cfg.PHP.Sandbox.Routes, "admin")
+
+	script := filepath.Join(root, "worker.php")
+	if err := os.WriteFile(script, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.PHP.Binary = "/usr/bin/php"
+	cfg.Workers = []config.WorkerConfig{{Script: script, Count: 1}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "php.sandbox.routes needs the embedded engine") {
+		t.Errorf("routes with worker processes: err = %v", err)
+	}
+}
+
+func TestValidateCache(t *testing.T) {
+	cfg := config.Default()
+	cfg.Cache.Enabled = true
+	cfg.Cache.Routes = []string{"/", "/blog/*"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid cache: %v", err)
+	}
+	cfg.Cache.Routes = append(cfg.Cache.Routes, "blog")
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "cache.routes[2]") {
+		t.Errorf("route without a leading slash: err = %v", err)
+	}
+	cfg.Cache.Routes = cfg.Cache.Routes[:2]
+	cfg.Cache.TTL = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "cache.ttl") {
+		t.Errorf("zero ttl: err = %v", err)
+	}
+	cfg.Cache.TTL = config.Duration(time.Minute)
+	cfg.Cache.BypassCookies = []string{""}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "cache.bypass_cookies[0]") {
+		t.Errorf("empty bypass cookie: err = %v", err)
+	}
+	cfg.Cache.BypassCookies = nil
+	cfg.Cache.Enabled = false
+	cfg.Cache.MaxSize = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("disabled cache without a size: %v", err)
+	}
+}
+
+func TestValidateCoalesce(t *testing.T) {
+	cfg := config.Default()
+	cfg.Coalesce.Enabled = true
+	cfg.Coalesce.Routes = []string{"/", "/blog/*"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid coalesce: %v", err)
+	}
+	cfg.Coalesce.Routes = append(cfg.Coalesce.Routes, "blog")
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "coalesce.routes[2]") {
+		t.Errorf("route without a leading slash: err = %v", err)
+	}
+	cfg.Coalesce.Routes = cfg.Coalesce.Routes[:2]
+	cfg.Coalesce.MaxWait = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "coalesce.max_wait") {
+		t.Errorf("zero max_wait: err = %v", err)
+	}
+	cfg.Coalesce.MaxWait = config.Duration(time.Second)
+	cfg.Coalesce.VaryHeaders = []string{""}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "coalesce.vary_headers[0]") {
+		t.Errorf("empty vary header: err = %v", err)
+	}
+}
+
+func TestValidateEarlyHints(t *testing.T) {
+	cfg := config.Default()
+	cfg.Hints.Routes = map[string][]string{
+		"/":       {"</app.css>; rel=preload; as=style"},
+		"/blog/*": {"/app.js"},
+		"/a/*/b":  {"</x.js>; rel=preload; as=script"},
+		"/splits": {"</a.js>; rel=preload\r\nSet-Cookie: x=1"},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid early hints accepted")
+	}
+	for _, want := range []string{"early_hints.routes[/blog/*][0]", "early_hints.routes: ", "early_hints.routes[/splits][0]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "early_hints.routes[/][0]") {
+		t.Errorf("valid link rejected: %v", err)
+	}
+}
+
+func TestValidateAccessControl(t *testing.T) {
+	cfg := config.Default()
+	cfg.Access.Rules = []config.AccessRule{
+		{Name: "office", Paths: []string{"/wp-admin", "/**/*.sql"}, Host: "*.example.com", Allow: []string{"203.0.113.0/24", "2001:db8::1"}},
+		{Deny: []string{"198.51.100.0/24"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid rules rejected: %v", err)
+	}
+
+	cfg.Access.Default = "block"
+	cfg.Access.DenyStatus = 401
+	cfg.Access.Rules = []config.AccessRule{
+		{Name: "a", Paths: []string{"wp-admin", "/{a,b"}, Host: "shop.*", Allow: []string{"office"}},
+		{Name: "a", Deny: []string{"10.0.0.0/33"}},
+		{Paths: []string{"/"}},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid access control accepted")
+	}
+	for _, want := range []string{
+		"access_control.default", "access_control.deny_status",
+		"rules[0].paths[0]", "rules[0].paths[1]", "rules[0].host", "rules[0].allow[0]",
+		`rules[1].name "a"`, "rules[1].deny[0]", "rules[2] needs allow or deny",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestValidateRewrites(t *testing.T) {
+	cfg := config.Default()
+	cfg.Rewrites.Rules = []config.RewriteRule{
+		{Match: `^/product/(\d+)$`, To: "/product.php?id=$1", Flag: "last"},
+		{Match: `^/old/(.*)$`, To: "https://example.com/$1", Flag: "redirect", Status: 301},
+		{Match: `^/index\.php$`, To: "-"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid rules rejected: %v", err)
+	}
+
+	cfg.Rewrites.MaxIterations = 0
+	cfg.Rewrites.Rules = []config.RewriteRule{
+		{Match: `^/(\d+$`, To: "/x"},
+		{Match: `^/a$`, To: "b.php", Query: `(`},
+		{Match: `^/a$`, To: "-", Flag: "redirect"},
+		{Match: `^/a$`, To: "/b", Flag: "rewrite"},
+		{Match: `^/a$`, To: "/b", Status: 301},
+		{Match: `^/a$`, To: "/b", Flag: "redirect", Status: 200},
+		{To: "/b"},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid rewrites accepted")
+	}
+	for _, want := range []string{
+		"rewrites.max_iterations", "rules[0].match", "rules[1].query", "rules[1].to must be a path",
+		"rules[2].to must be a path or URL", "rules[3].flag", "rules[4].status only applies",
+		"rules[5].status must be", "rules[6].match is required",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestValidateErrorReporting(t *testing.T) {
+	cfg := config.Default()
+	cfg.Errors.DSN = "https://abc123@o1.ingest.sentry.io/42"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid DSN: %v", err)
+	}
+	cfg.Errors.DSN = "https://o1.ingest.sentry.io/42?key=abc123"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "error_reporting.dsn") {
+		t.Errorf("DSN without a key: err = %v", err)
+	} else if strings.Contains(err.Error(), "abc123") {
+		t.Errorf("error %q quotes the DSN", err)
+	}
+	cfg.Errors.DSN = "https://abc123@o1.ingest.sentry.io/42"
+	cfg.Errors.SampleRate = 1.5
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "error_reporting.sample_rate") {
+		t.Errorf("sample rate above 1: err = %v", err)
+	}
+	cfg.Errors.SampleRate = 0.5
+	cfg.Errors.QueueSize = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "error_reporting.queue_size") {
+		t.Errorf("no queue: err = %v", err)
+	}
+}
+
+func TestValidateConnectionLimits(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"}
+	cfg.WebSocket.MaxConnectionsPerIP = 20
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid limits: %v", err)
+	}
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8", "lb.internal"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.trusted_proxies[1]") {
+		t.Errorf("hostname proxy: err = %v", err)
+	}
+	cfg.Server.TrustedProxies = nil
+	cfg.Server.Listeners = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.listeners") {
+		t.Errorf("negative listeners: err = %v", err)
+	}
+	cfg.Server.Listeners = 0
+	cfg.Server.MaxConnectionsPerIP = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.max_connections") {
+		t.Errorf("negative per-IP cap: err = %v", err)
+	}
+	cfg.Server.MaxConnectionsPerIP = 0
+	cfg.WebSocket.AllowedOrigins = []string{"same-origin", "https://*.example.com", "ws://example.com"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.allowed_origins") {
+		t.Errorf("ws:// origin: err = %v", err)
+	}
+	cfg.WebSocket.AllowedOrigins = nil
+	cfg.WebSocket.ForwardHeaders = []string{"Cookie", "X-Tenant: acme"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.forward_headers[1]") {
+		t.Errorf("header with value: err = %v", err)
+	}
+	cfg.WebSocket.ForwardHeaders = nil
+	cfg.WebSocket.AuthEndpoint = "ws-auth.php"
+	cfg.WebSocket.AuthTimeout = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.auth_timeout") {
+		t.Errorf("auth endpoint without timeout: err = %v", err)
+	}
+	cfg.WebSocket.AuthEndpoint = ""
+	cfg.WebSocket.SlowClient = "block"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.slow_client") {
+		t.Errorf("unknown slow_client policy: err = %v", err)
+	}
+	cfg.WebSocket.SlowClient = "drop"
+	cfg.WebSocket.SendQueue = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.send_queue") {
+		t.Errorf("empty send queue: err = %v", err)
+	}
+	cfg.WebSocket.SendQueue = 256
+	cfg.WebSocket.BroadcastParallelism = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.broadcast_parallelism") {
+		t.Errorf("negative broadcast parallelism: err = %v", err)
+	}
+	cfg.WebSocket.BroadcastParallelism = 0
+	cfg.WebSocket.TickInterval = config.Duration(-time.Second)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.tick_interval") {
+		t.Errorf("negative tick interval: err = %v", err)
+	}
+	cfg.WebSocket.TickInterval = 0
+	cfg.WebSocket.MaxRoomSize = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.max_room_size") {
+		t.Errorf("negative room size: err = %v", err)
+	}
+	cfg.WebSocket.MaxRoomSize = 0
+	cfg.WebSocket.PrivateRooms.Prefixes = []string{"private-", ""}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.private_rooms.prefixes") {
+		t.Errorf("empty private room prefix: err = %v", err)
+	}
+	cfg.WebSocket.PrivateRooms.Prefixes = []string{"private-"}
+	cfg.WebSocket.PrivateRooms.CacheTTL = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.private_rooms.cache_ttl") {
+		t.Errorf("negative authorization cache TTL: err = %v", err)
+	}
+	cfg.WebSocket.PrivateRooms.CacheTTL = 0
+	cfg.WebSocket.MessageRate = 10
+	cfg.WebSocket.MessageBurst = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.message_burst") {
+		t.Errorf("rate without burst: err = %v", err)
+	}
+	cfg.WebSocket.MessageBurst = 20
+	cfg.WebSocket.RateExceeded = "throttle"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.rate_exceeded") {
+		t.Errorf("unknown rate_exceeded: err = %v", err)
+	}
+	cfg.WebSocket.RateExceeded = "close"
+	cfg.WebSocket.Compression.Enabled = true
+	cfg.WebSocket.Compression.Level = 10
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.compression.level") {
+		t.Errorf("compression level 10: err = %v", err)
+	}
+	cfg.WebSocket.Compression.Level = 6
+	cfg.WebSocket.MinWorkers, cfg.WebSocket.MaxWorkers = 4, 2
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.max_workers") {
+		t.Errorf("max_workers below min_workers: err = %v", err)
+	}
+	cfg.WebSocket.MinWorkers, cfg.WebSocket.MaxWorkers = 0, 0
+	cfg.WebSocket.CloseSpread = config.Duration(time.Minute)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.close_spread") {
+		t.Errorf("close_spread over drain_timeout: err = %v", err)
+	}
+	cfg.WebSocket.CloseSpread = 0
+	cfg.WebSocket.Resume.Enabled = true
+	cfg.WebSocket.Resume.TTL = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.resume.ttl") {
+		t.Errorf("resume without ttl: err = %v", err)
+	}
+	cfg.WebSocket.Resume.TTL = config.Duration(time.Minute)
+	cfg.WebSocket.MaxConnectionsPerIP = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "websocket.max_connections") {
+		t.Errorf("negative per-IP limit: err = %v", err)
+	}
+}
+
+func TestValidateHealthChecks(t *testing.T) {
+	tests := []struct {
+		name      string
+		health    config.HealthConfig
+		expectErr bool
+	}{
+		{"empty", config.HealthConfig{}, false},
+		{"tcp", config.HealthConfig{Checks: []config.HealthCheckConfig{{Name: "db", Type: "tcp", Address: "db:3306"}}}, false},
+		{"quorum", config.HealthConfig{Policy: "quorum"}, false},
+		{"bad policy", config.HealthConfig{Policy: "most"}, true},
+		{"missing name", config.HealthConfig{Checks: []config.HealthCheckConfig{{Type: "tcp", Address: "db:3306"}}}, true},
+		{"missing address", config.HealthConfig{Checks: []config.HealthCheckConfig{{Name: "db", Type: "tcp"}}}, true},
+		{"missing url", config.HealthConfig{Checks: []config.HealthCheckConfig{{Name: "api", Type: "http"}}}, true},
+		{"missing script", config.HealthConfig{Checks: []config.HealthCheckConfig{{Name: "app", Type: "php"}}}, true},
+		{"unknown type", config.HealthConfig{Checks: []config.HealthCheckConfig{{Name: "x", Type: "udp"}}}, true},
+		{"duplicate", config.HealthConfig{Checks: []config.HealthCheckConfig{
+			{Name: "db", Type: "tcp", Address: "a:1"},
+			{Name: "db", Type: "tcp", Address: "b:1"},
+		}}, true},
+		{"self test", config.HealthConfig{SelfTest: config.SelfTestConfig{Enabled: true, Path: "/up?check=1", Timeout: config.Duration(time.Second)}}, false},
+		{"self test relative path", config.HealthConfig{SelfTest: config.SelfTestConfig{Enabled: true, Path: "up"}}, true},
+		{"self test negative timeout", config.HealthConfig{SelfTest: config.SelfTestConfig{Timeout: -1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Health = tt.health
+
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	artisan := config.ScheduleJobConfig{Name: "laravel", Cron: "* * * * *", Script: "artisan", Args: []string{"schedule:run"}}
+	with := func(edit func(*config.ScheduleJobConfig)) config.ScheduleConfig {
+		j := artisan
+		edit(&j)
+		return config.ScheduleConfig{Jobs: []config.ScheduleJobConfig{j}}
+	}
+
+	tests := []struct {
+		name      string
+		schedule  config.ScheduleConfig
+		expectErr bool
+	}{
+		{"empty", config.ScheduleConfig{}, false},
+		{"cli", config.ScheduleConfig{Jobs: []config.ScheduleJobConfig{artisan}}, false},
+		{"request", with(func(j *config.ScheduleJobConfig) { j.Mode, j.Args = "request", nil }), false},
+		{"macro", with(func(j *config.ScheduleJobConfig) { j.Cron = "@daily" }), false},
+		{"timezone", config.ScheduleConfig{Timezone: "UTC", Jobs: []config.ScheduleJobConfig{artisan}}, false},
+		{"catch up", config.ScheduleConfig{StateFile: "schedule.json", Jobs: []config.ScheduleJobConfig{
+			{Name: "report", Cron: "@daily", Script: "report.php", CatchUp: true},
+		}}, false},
+		{"bad timezone", config.ScheduleConfig{Timezone: "Mars/Olympus"}, true},
+		{"missing name", with(func(j *config.ScheduleJobConfig) { j.Name = "" }), true},
+		{"bad cron", with(func(j *config.ScheduleJobConfig) { j.Cron = "* * *" }), true},
+		{"missing script", with(func(j *config.ScheduleJobConfig) { j.Script = "" }), true},
+		{"bad mode", with(func(j *config.ScheduleJobConfig) { j.Mode = "fork" }), true},
+		{"request args", with(func(j *config.ScheduleJobConfig) { j.Mode = "request" }), true},
+		{"request kill", with(func(j *config.ScheduleJobConfig) { j.Mode, j.Args, j.Overlap = "request", nil, "kill" }), true},
+		{"bad overlap", with(func(j *config.ScheduleJobConfig) { j.Overlap = "wait" }), true},
+		{"negative jitter", with(func(j *config.ScheduleJobConfig) { j.Jitter = -1 }), true},
+		{"catch up without state", with(func(j *config.ScheduleJobConfig) { j.CatchUp = true }), true},
+		{"duplicate", config.ScheduleConfig{Jobs: []config.ScheduleJobConfig{artisan, artisan}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Schedule = tt.schedule
+
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateJobs(t *testing.T) {
+	queue := config.JobConfig{Name: "queue", Script: "artisan", Args: []string{"queue:work"}, Count: 2}
+	with := func(edit func(*config.JobConfig)) []config.JobConfig {
+		j := queue
+		edit(&j)
+		return []config.JobConfig{j}
+	}
+
+	tests := []struct {
+		name      string
+		binary    string
+		jobs      []config.JobConfig
+		expectErr bool
+	}{
+		{"none", "", nil, false},
+		{"queue", "", []config.JobConfig{queue}, false},
+		{"stop signal", "", with(func(j *config.JobConfig) { j.StopSignal, j.StopGrace = "SIGQUIT", config.Duration(time.Minute) }), false},
+		{"max memory", "php", with(func(j *config.JobConfig) { j.MaxMemory = 256 * config.MiB }), false},
+		{"missing name", "", with(func(j *config.JobConfig) { j.Name = "" }), true},
+		{"missing script", "", with(func(j *config.JobConfig) { j.Script = "" }), true},
+		{"negative count", "", with(func(j *config.JobConfig) { j.Count = -1 }), true},
+		{"bad stop signal", "", with(func(j *config.JobConfig) { j.StopSignal = "KILL" }), true},
+		{"negative delay", "", with(func(j *config.JobConfig) { j.RestartDelay = -1 }), true},
+		{"max memory embedded", "", with(func(j *config.JobConfig) { j.MaxMemory = 256 * config.MiB }), true},
+		{"duplicate", "", []config.JobConfig{queue, queue}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Jobs = tt.jobs
+			if tt.binary != "" {
+				cfg.PHP.Binary, cfg.PHP.Worker = tt.binary, "worker.php"
+			}
+
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateWorkerGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		binary  string
+		workers []config.WorkerConfig
+		wantErr []string
+	}{
+		{"empty", "", nil, nil},
+		{"valid", "", []config.WorkerConfig{
+			{Script: "api.php", Pattern: "/api/*", Count: 2, Watch: []string{"src/**/*.php"}},
+			{Script: "web.php", Count: 4},
+		}, nil},
+		{"missing script", "", []config.WorkerConfig{{Count: 1}}, []string{"workers[0].script is required"}},
+		{"zero count", "", []config.WorkerConfig{{Script: "a.php"}}, []string{"workers[0].count must be >= 1"}},
+		{"bad pattern", "", []config.WorkerConfig{{Script: "a.php", Count: 1, Pattern: "api/*"}}, []string{"workers[0].pattern"}},
+		{"bad watch glob", "", []config.WorkerConfig{{Script: "a.php", Count: 1, Watch: []string{"src/[a"}}}, []string{"workers[0].watch[0]"}},
+		{"duplicate pattern", "", []config.WorkerConfig{
+			{Script: "a.php", Count: 1, Pattern: "/api/*"},
+			{Script: "b.php", Count: 1, Pattern: "/api/*"},
+		}, []string{`workers[1].pattern "/api/*" duplicates workers[0]`}},
+		{"missing script file", "/usr/bin/php", []config.WorkerConfig{
+			{Script: filepath.Join(t.TempDir(), "missing.php"), Count: 1},
+		}, []string{"workers[0].script"}},
+		{"every error reported", "", []config.WorkerConfig{
+			{Count: 1},
+			{Script: "b.php"},
+		}, []string{"workers[0].script", "workers[1].count"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.PHP.Binary = tt.binary
+			cfg.Workers = tt.workers
+
+			err := cfg.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkerGroupsInheritPool(t *testing.T) {
+	path := writeConfig(t, `pool:
+  max_jobs: 500
+  max_memory: 256M
+  request_timeout: 10s
+workers:
+  - script: api.php
+    pattern: /api/*
+    count: 2
+    max_jobs: 50
+    request_timeout: 2s
+  - script: web.php
+    count: 4
+`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, web := cfg.Workers[0], cfg.Workers[1]
+	if api.MaxJobs != 50 || api.RequestTimeout.Duration() != 2*time.Second {
+		t.Errorf("api overrides lost: %+v", api)
+	}
+	if api.MaxMemory != 256*config.MiB {
+		t.Errorf("api.MaxMemory = %v, want inherited 256M", api.MaxMemory)
+	}
+	if web.MaxJobs != 500 || web.MaxMemory != 256*config.MiB || web.RequestTimeout.Duration() != 10*time.Second {
+		t.Errorf("web did not inherit pool settings: %+v", web)
+	}
+	if web.IdleTimeout != cfg.Pool.IdleTimeout || web.AllocateTimeout != cfg.Pool.AllocateTimeout {
+		t.Errorf("web did not inherit default timeouts: %+v", web)
+	}
+}
+
+func TestWebSocketWorkerGroup(t *testing.T) {
+	path := writeConfig(t, `pool:
+  min_workers: 4
+  max_workers: 16
+  max_jobs: 500
+websocket:
+  enabled: true
+  worker: ws.php
+  min_workers: 1
+  max_workers: 2
+`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := cfg.WebSocketPool()
+	if ws.MinWorkers != 1 || ws.MaxWorkers != 2 || ws.MaxJobs != 500 {
+		t.Errorf("websocket pool = %+v, want 1-2 workers with the pool's max_jobs", ws)
+	}
+	if cfg.Pool.MinWorkers != 4 || cfg.Pool.MaxWorkers != 16 {
+		t.Errorf("pool changed to %d-%d workers", cfg.Pool.MinWorkers, cfg.Pool.MaxWorkers)
+	}
+
+	path = writeConfig(t, `pool:
+  min_workers: 4
+  max_workers: 16
+websocket:
+  enabled: true
+  worker: ws.php
+`)
+	if cfg, err = config.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.WebSocket.MinWorkers != 4 || cfg.WebSocket.MaxWorkers != 16 {
+		t.Errorf("websocket workers = %d-%d, want the pool's 4-16", cfg.WebSocket.MinWorkers, cfg.WebSocket.MaxWorkers)
+	}
+}
+
+func TestPoolSizedFromLimits(t *testing.T) {
+	limits := &cgroup.Limits{Version: 2, CPU: 2, Memory: 1 << 30}
+	load := func(body string) *config.Config {
+		t.Helper()
+		cfg, err := config.LoadWithOptions(writeConfig(t, body), config.Options{Limits: limits})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	// 1G at the default 128M max_memory per worker holds 8.
+	cfg := load("app:\n  root: .\n")
+	if cfg.Pool.MinWorkers != 4 || cfg.Pool.MaxWorkers != 8 {
+		t.Errorf("default pool = %d-%d workers, want 4-8", cfg.Pool.MinWorkers, cfg.Pool.MaxWorkers)
+	}
+	if s := cfg.PoolSizing(); !s.Derived || s.MaxWorkers != 8 || s.WorkerMemory != 128<<20 || s.Limits != *limits {
+		t.Errorf("sizing = %+v", s)
+	}
+
+	cfg = load("pool:\n  worker_memory: 512M\n")
+	if cfg.Pool.MinWorkers != 2 || cfg.Pool.MaxWorkers != 2 {
+		t.Errorf("512M per worker = %d-%d workers, want 2-2", cfg.Pool.MinWorkers, cfg.Pool.MaxWorkers)
+	}
+
+	// A limit holding more workers than the defaults keeps the defaults.
+	limits.Memory = 64 << 30
+	if cfg = load("{}\n"); cfg.Pool.MaxWorkers != 32 {
+		t.Errorf("64G limit = %d max workers, want the default 32", cfg.Pool.MaxWorkers)
+	}
+
+	// Explicit counts win.
+	limits.Memory = 1 << 30
+	cfg = load("pool:\n  max_workers: 20\n")
+	if cfg.Pool.MinWorkers != 4 || cfg.Pool.MaxWorkers != 20 {
+		t.Errorf("explicit pool = %d-%d workers, want 4-20", cfg.Pool.MinWorkers, cfg.Pool.MaxWorkers)
+	}
+	if s := cfg.PoolSizing(); s.Derived || s.Limits != *limits {
+		t.Errorf("explicit sizing = %+v", s)
+	}
+}
+
+func TestValidateCanonicalHost(t *testing.T) {
+	tests := []struct {
+		host      string
+		expectErr bool
+	}{
+		{"", false},
+		{"example.com", false},
+		{"www.example.com.", false},
+		{"example.com:8443", false},
+		{"10.0.0.1", false},
+		{"https://example.com", true},
+		{"example.com/shop", true},
+		{"example.com:port", true},
+		{"-bad.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Server.CanonicalHost = tt.host
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateResponseHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   config.HeadersConfig
+		expectErr bool
+	}{
+		{"empty", config.HeadersConfig{}, false},
+		{"lists", config.HeadersConfig{InternalPrefixes: []string{"X-Internal-"}, HideServer: true, Deny: []string{"X-Debug-Token"}, Allow: []string{"X-Maboo-Cache"}}, false},
+		{"bad prefix", config.HeadersConfig{InternalPrefixes: []string{""}}, true},
+		{"bad deny", config.HeadersConfig{Deny: []string{"X Debug"}}, true},
+		{"bad allow", config.HeadersConfig{Allow: []string{"X-A:b"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Headers = tt.headers
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateETag(t *testing.T) {
+	tests := []struct {
+		name      string
+		etag      config.ETagConfig
+		expectErr bool
+	}{
+		{"every route", config.ETagConfig{Enabled: true}, false},
+		{"routes", config.ETagConfig{Enabled: true, Routes: []string{"/", "/blog/:slug"}}, false},
+		{"bad route", config.ETagConfig{Enabled: true, Routes: []string{"blog"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.ETag = tt.etag
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	tests := []struct {
+		name      string
+		edit      func(c *config.CompressionConfig)
+		expectErr bool
+	}{
+		{"defaults", func(c *config.CompressionConfig) {}, false},
+		{"deflate first", func(c *config.CompressionConfig) { c.Algorithms = []string{"deflate", "gzip"} }, false},
+		{"routes", func(c *config.CompressionConfig) {
+			c.Routes = map[string]config.CompressionRoute{"/download/*": {Disable: true}, "/api/:id": {Level: 9}}
+		}, false},
+		{"disabled, nothing listed", func(c *config.CompressionConfig) { c.Enabled = false; c.Algorithms = nil; c.ContentTypes = nil }, false},
+		{"no algorithms", func(c *config.CompressionConfig) { c.Algorithms = nil }, true},
+		{"unknown algorithm", func(c *config.CompressionConfig) { c.Algorithms = []string{"br"} }, true},
+		{"repeated algorithm", func(c *config.CompressionConfig) { c.Algorithms = []string{"gzip", "gzip"} }, true},
+		{"negative min_size", func(c *config.CompressionConfig) { c.MinSize = -1 }, true},
+		{"level 0", func(c *config.CompressionConfig) { c.Level = 0 }, true},
+		{"level 10", func(c *config.CompressionConfig) { c.Level = 10 }, true},
+		{"no content types", func(c *config.CompressionConfig) { c.ContentTypes = nil }, true},
+		{"empty content type", func(c *config.CompressionConfig) { c.ContentTypes = []string{"text/", ""} }, true},
+		{"bad route", func(c *config.CompressionConfig) {
+			c.Routes = map[string]config.CompressionRoute{"download": {Disable: true}}
+		}, true},
+		{"disable and level", func(c *config.CompressionConfig) {
+			c.Routes = map[string]config.CompressionRoute{"/download/*": {Disable: true, Level: 9}}
+		}, true},
+		{"route level 10", func(c *config.CompressionConfig) {
+			c.Routes = map[string]config.CompressionRoute{"/api/*": {Level: 10}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.edit(&cfg.Compression)
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateMetricsAddress(t *testing.T) {
+	tests := []struct {
+		name      string
+		edit      func(m *config.MetricsConfig)
+		expectErr bool
+	}{
+		{"in-band only", func(m *config.MetricsConfig) {}, false},
+		{"tcp listener", func(m *config.MetricsConfig) { m.Address = "127.0.0.1:9100" }, false},
+		{"unix socket, no path", func(m *config.MetricsConfig) { m.Address = "unix:/run/maboo/metrics.sock"; m.Path = "" }, false},
+		{"allow", func(m *config.MetricsConfig) { m.Allow = []string{"10.0.0.0/8", "::1"} }, false},
+		{"neither", func(m *config.MetricsConfig) { m.Path = "" }, true},
+		{"disabled, neither", func(m *config.MetricsConfig) { m.Enabled = false; m.Path = "" }, false},
+		{"relative path", func(m *config.MetricsConfig) { m.Path = "metrics" }, true},
+		{"no port", func(m *config.MetricsConfig) { m.Address = "localhost" }, true},
+		{"empty socket", func(m *config.MetricsConfig) { m.Address = "unix:" }, true},
+		{"bad allow", func(m *config.MetricsConfig) { m.Allow = []string{"10.0.0.0/33"} }, true},
+		{"main listener", func(m *config.MetricsConfig) { m.Address = config.Default().Server.Address }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.edit(&cfg.Metrics)
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
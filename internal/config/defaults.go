@@ -2,21 +2,32 @@ package config
 
 import "time"
 
+// DefaultPIDFile is where the server records its PID unless server.pid_file
+// says otherwise.
+const DefaultPIDFile = "/var/run/maboo.pid"
+
 // Default returns a Config with sensible defaults.
 func Default() *Config {
 	return &Config{
+		Strict: true,
 		Server: ServerConfig{
 			Address:      "0.0.0.0:8080",
 			Mode:         ModeNative,
 			HTTP2:        true,
+			Compression:  true,
 			HTTP3:        false,
 			TLS:          TLSConfig{Auto: false},
 			HTTPRedirect: false,
+			PIDFile:      DefaultPIDFile,
+
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			MinBodyRate:       KiB,
+			BodyRateInterval:  Duration(10 * time.Second),
 		},
 		PHP: PHPConfig{
 			Version: "auto",
 			Mode:    "worker",
-			Binary:  "",  // Empty = embedded PHP mode
+			Binary:  "", // Empty = embedded PHP mode
 			Worker:  "",
 			INI: map[string]string{
 				"memory_limit":       "256M",
@@ -32,35 +43,145 @@ func Default() *Config {
 			MinWorkers:      4,
 			MaxWorkers:      32,
 			MaxJobs:         10000,
-			MaxMemory:       "128M",
+			MaxMemory:       128 * MiB,
+			MaxFrameSize:    16 * MiB,
 			IdleTimeout:     Duration(60 * time.Second),
 			AllocateTimeout: Duration(30 * time.Second),
 			RequestTimeout:  Duration(30 * time.Second),
+			SpawnRate:       4,
 		},
 		WebSocket: WebSocketConfig{
 			Enabled:        false,
 			Path:           "/ws",
 			Worker:         "",
+			AllowedOrigins: []string{"same-origin"},
+			ForwardHeaders: []string{"Cookie", "Authorization", "User-Agent"},
+			AuthTimeout:    Duration(5 * time.Second),
 			MaxConnections: 10000,
+			MaxMessageSize: MiB,
+			MessageBurst:   20,
+			RateExceeded:   "drop",
+			SendQueue:      256,
+			PublishBurst:   100,
+			WriteTimeout:   Duration(10 * time.Second),
+			SlowClient:     "disconnect",
 			PingInterval:   Duration(30 * time.Second),
+			PongTimeout:    Duration(10 * time.Second),
+			DrainTimeout:   Duration(10 * time.Second),
+			CloseSpread:    Duration(5 * time.Second),
+			Compression: WebSocketCompressionConfig{
+				Level:     1,
+				Threshold: KiB,
+			},
+			Resume: WebSocketResumeConfig{
+				Buffer:      100,
+				BufferSize:  256 * KiB,
+				TTL:         Duration(2 * time.Minute),
+				MaxSessions: 10000,
+			},
+			PrivateRooms: WebSocketPrivateRoomsConfig{
+				CacheTTL: Duration(time.Minute),
+			},
 		},
 		Static: StaticConfig{
 			Root:         "public",
 			CacheControl: "public, max-age=3600",
 		},
+		Temp: TempConfig{
+			SweepInterval: Duration(10 * time.Minute),
+			OrphanAge:     Duration(time.Hour),
+		},
+		Cache: CacheConfig{
+			TTL:     Duration(30 * time.Second),
+			MaxSize: 64 * MiB,
+		},
+		Coalesce: CoalesceConfig{
+			MaxWait: Duration(5 * time.Second),
+		},
+		Access: AccessConfig{
+			Default:    "allow",
+			DenyStatus: 403,
+		},
+		Rewrites: RewritesConfig{
+			MaxIterations: 10,
+		},
+		Headers: HeadersConfig{
+			InternalPrefixes: []string{"X-Maboo-"},
+		},
+		Compression: CompressionConfig{
+			Enabled:    true,
+			Algorithms: []string{"gzip"},
+			MinSize:    KiB,
+			Level:      1,
+			ContentTypes: []string{
+				"text/", "application/json", "application/javascript", "application/xml",
+				"application/xhtml+xml", "image/svg+xml", "+json", "+xml",
+			},
+		},
 		Logging: LogConfig{
-			Level:  "info",
-			Format: "json",
-			Output: "stdout",
+			Level:    "info",
+			Format:   "json",
+			Output:   "stdout",
+			Compress: true,
+			Request: RequestLogConfig{
+				SampleRate:    1.0,
+				SlowThreshold: Duration(time.Second),
+				AlwaysPaths:   []string{},
+			},
+			Audit: AuditLogConfig{
+				Keep: 100,
+			},
 		},
 		Metrics: MetricsConfig{
-			Enabled: true,
-			Path:    "/metrics",
+			Enabled:           true,
+			Path:              "/metrics",
+			RuntimeCollectors: true,
+		},
+		Health: HealthConfig{
+			Policy: "any",
+			SelfTest: SelfTestConfig{
+				Enabled: true,
+				Path:    "/",
+				Timeout: Duration(30 * time.Second),
+			},
+		},
+		Tracing: TracingConfig{
+			Enabled:        true,
+			Generate:       true,
+			ResponseHeader: "X-Trace-Id",
+		},
+		Errors: ErrorsConfig{
+			SampleRate: 1.0,
+			QueueSize:  100,
+			Timeout:    Duration(5 * time.Second),
+		},
+		Notify: NotifyConfig{
+			QueueSize: 100,
+			Retries:   3,
+			Backoff:   Duration(time.Second),
+			Timeout:   Duration(5 * time.Second),
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Address: "127.0.0.1:9180",
+			Eval: AdminEvalConfig{
+				Timeout:   Duration(5 * time.Second),
+				MaxOutput: 64 * KiB,
+			},
 		},
 		Watch: WatchConfig{
-			Enabled:  false,
-			Dirs:     []string{},
-			Interval: Duration(2 * time.Second),
+			Enabled:       false,
+			Dirs:          []string{},
+			Backend:       "auto",
+			Interval:      Duration(2 * time.Second),
+			Debounce:      Duration(500 * time.Millisecond),
+			MaxDelay:      Duration(5 * time.Second),
+			Cooldown:      Duration(time.Second),
+			Extensions:    []string{".php", ".inc", ".phtml"},
+			Exclude:       []string{"**/vendor/**", "**/node_modules/**", "**/.git/**"},
+			Strategy:      "reload",
+			InvalidateMax: 50,
+			FullReload:    []string{"composer.lock", ".env"},
 		},
 	}
 }
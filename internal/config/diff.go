@@ -0,0 +1,201 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ChangeKind classifies how a config change can be applied to a running
+// server.
+type ChangeKind int
+
+const (
+	// ChangeHot is applied in place without interrupting requests.
+	ChangeHot ChangeKind = iota
+	// ChangeWorkerReload is applied by gracefully replacing the PHP workers.
+	ChangeWorkerReload
+	// ChangeRestart only takes effect after a full restart.
+	ChangeRestart
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeHot:
+		return "hot"
+	case ChangeWorkerReload:
+		return "worker_reload"
+	default:
+		return "restart"
+	}
+}
+
+// Change is a single differing config value, identified by its YAML path.
+type Change struct {
+	Path string
+	Kind ChangeKind
+}
+
+// hotPaths can be swapped into a running server.
+var hotPaths = map[string]bool{
+	"logging.level":              true,
+	"logging.format":             true,
+	"pool.min_workers":           true,
+	"pool.max_workers":           true,
+	"pool.max_jobs":              true,
+	"pool.idle_timeout":          true,
+	"pool.allocate_timeout":      true,
+	"pool.request_timeout":       true,
+	"pool.route_timeouts":        true,
+	"pool.worker_memory":         true,
+	"pool.spawn_rate":            true,
+	"static.cache_control":       true,
+	"cache.enabled":              true,
+	"cache.routes":               true,
+	"cache.ttl":                  true,
+	"cache.max_size":             true,
+	"cache.vary_headers":         true,
+	"cache.vary_cookies":         true,
+	"cache.bypass_cookies":       true,
+	"coalesce.enabled":           true,
+	"coalesce.routes":            true,
+	"coalesce.vary_headers":      true,
+	"coalesce.max_wait":          true,
+	"early_hints.enabled":        true,
+	"early_hints.routes":         true,
+	"early_hints.http1":          true,
+	"access_control.default":     true,
+	"access_control.deny_status": true,
+	"access_control.rules":       true,
+	"rewrites.max_iterations":    true,
+	"rewrites.rules":             true,
+	"app.debug":                  true,
+	"server.max_body_size":       true,
+	"server.max_response_size":   true,
+	"server.min_body_rate":       true,
+	"server.body_rate_interval":  true,
+	"server.canonical_host":      true,
+	"debug.stats":                true,
+	"debug.secret":               true,
+
+	"response_headers.internal_prefixes": true,
+	"response_headers.hide_server":       true,
+	"response_headers.deny":              true,
+	"response_headers.allow":             true,
+	"response_headers.preserve_case":     true,
+
+	"etag.enabled": true,
+	"etag.routes":  true,
+
+	// A change to the watch section restarts the file watcher.
+	"watch.enabled":        true,
+	"watch.dirs":           true,
+	"watch.backend":        true,
+	"watch.interval":       true,
+	"watch.debounce":       true,
+	"watch.max_delay":      true,
+	"watch.cooldown":       true,
+	"watch.extensions":     true,
+	"watch.include":        true,
+	"watch.exclude":        true,
+	"watch.strategy":       true,
+	"watch.invalidate_max": true,
+	"watch.full_reload":    true,
+
+	"compression.enabled":       true,
+	"compression.algorithms":    true,
+	"compression.min_size":      true,
+	"compression.level":         true,
+	"compression.content_types": true,
+	"compression.routes":        true,
+}
+
+// workerReloadPrefixes take effect when workers are respawned.
+var workerReloadPrefixes = []string{"php.", "app.", "pool.max_memory"}
+
+// Diff compares two configs field by field and classifies every change.
+// Slices and maps are compared as a whole and reported at their own path.
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	diffValue(reflect.ValueOf(*old), reflect.ValueOf(*new), "", &changes)
+	return changes
+}
+
+func diffValue(a, b reflect.Value, path string, out *[]Change) {
+	if a.Kind() == reflect.Struct && a.Type() != reflect.TypeOf(Duration(0)) {
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			diffValue(a.Field(i), b.Field(i), joinPath(path, name), out)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+		*out = append(*out, Change{Path: path, Kind: classify(path)})
+	}
+}
+
+func classify(path string) ChangeKind {
+	if hotPaths[path] {
+		return ChangeHot
+	}
+	for _, p := range workerReloadPrefixes {
+		if strings.HasPrefix(path, p) {
+			return ChangeWorkerReload
+		}
+	}
+	return ChangeRestart
+}
+
+// Reloadable returns a copy of running with every hot and worker-reload
+// setting taken from next. Restart-only settings keep their running values,
+// so the result describes what the process actually runs after a reload.
+func Reloadable(running, next *Config) *Config {
+	merged := *running
+	merged.Logging.Level = next.Logging.Level
+	merged.Logging.Format = next.Logging.Format
+	merged.Pool.MinWorkers = next.Pool.MinWorkers
+	merged.Pool.MaxWorkers = next.Pool.MaxWorkers
+	merged.Pool.MaxJobs = next.Pool.MaxJobs
+	merged.Pool.MaxMemory = next.Pool.MaxMemory
+	merged.Pool.WorkerMemory = next.Pool.WorkerMemory
+	merged.Pool.IdleTimeout = next.Pool.IdleTimeout
+	merged.Pool.AllocateTimeout = next.Pool.AllocateTimeout
+	merged.Pool.RequestTimeout = next.Pool.RequestTimeout
+	merged.Pool.SpawnRate = next.Pool.SpawnRate
+	merged.Pool.RouteTimeouts = next.Pool.RouteTimeouts
+	merged.Static.CacheControl = next.Static.CacheControl
+	merged.Cache = next.Cache
+	merged.Coalesce = next.Coalesce
+	merged.Hints = next.Hints
+	merged.Access = next.Access
+	merged.Rewrites = next.Rewrites
+	merged.Headers = next.Headers
+	merged.ETag = next.ETag
+	merged.Compression = next.Compression
+	merged.Watch = next.Watch
+	merged.Server.MaxBodySize = next.Server.MaxBodySize
+	merged.Server.MaxResponseSize = next.Server.MaxResponseSize
+	merged.Server.MinBodyRate = next.Server.MinBodyRate
+	merged.Server.BodyRateInterval = next.Server.BodyRateInterval
+	merged.Server.CanonicalHost = next.Server.CanonicalHost
+	merged.PHP = next.PHP
+	merged.App = next.App
+	merged.Debug = next.Debug
+	merged.sizing = next.sizing
+
+	merged.envPaths = make(map[string]bool, len(running.envPaths)+len(next.envPaths))
+	for p := range running.envPaths {
+		merged.envPaths[p] = true
+	}
+	for p := range next.envPaths {
+		merged.envPaths[p] = true
+	}
+	return &merged
+}
@@ -0,0 +1,80 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestDiffClassifiesChanges(t *testing.T) {
+	old := config.Default()
+	next := config.Default()
+	next.Logging.Level = "debug"
+	next.Pool.MaxWorkers = 16
+	next.Pool.RequestTimeout = config.Duration(5 * time.Second)
+	next.PHP.INI["memory_limit"] = "512M"
+	next.Server.Address = "0.0.0.0:9090"
+	next.Watch.Dirs = []string{"src"}
+	next.Cache.Routes = []string{"/blog/*"}
+	next.Access.Rules = []config.AccessRule{{Paths: []string{"/wp-admin"}, Allow: []string{"203.0.113.0/24"}}}
+
+	want := map[string]config.ChangeKind{
+		"logging.level":        config.ChangeHot,
+		"pool.max_workers":     config.ChangeHot,
+		"pool.request_timeout": config.ChangeHot,
+		"php.ini":              config.ChangeWorkerReload,
+		"server.address":       config.ChangeRestart,
+		"watch.dirs":           config.ChangeHot,
+		"cache.routes":         config.ChangeHot,
+		"access_control.rules": config.ChangeHot,
+	}
+
+	changes := config.Diff(old, next)
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes %v, want %d", len(changes), changes, len(want))
+	}
+	for _, c := range changes {
+		kind, ok := want[c.Path]
+		if !ok {
+			t.Errorf("unexpected change %q", c.Path)
+			continue
+		}
+		if c.Kind != kind {
+			t.Errorf("%s: kind %v, want %v", c.Path, c.Kind, kind)
+		}
+	}
+
+	if len(config.Diff(old, config.Default())) != 0 {
+		t.Error("identical configs must not differ")
+	}
+}
+
+func TestReloadableKeepsRestartSettings(t *testing.T) {
+	old := config.Default()
+	next := config.Default()
+	next.Logging.Format = "text"
+	next.Pool.MaxJobs = 50
+	next.Static.CacheControl = "no-store"
+	next.Access.Default = "deny"
+	next.PHP.Version = "8.3"
+	next.Server.Address = "0.0.0.0:9090"
+	next.Server.CanonicalHost = "www.example.com"
+	next.Headers.HideServer = true
+	next.ETag.Enabled = !old.ETag.Enabled
+	next.Coalesce.Enabled = true
+	next.Server.MinBodyRate = 1024
+	next.Pool.OnDemand = true
+	next.Pool.MaxFrameSize = 2 * old.Pool.MaxFrameSize
+	next.Watch.Strategy = config.WatchStrategyInvalidate
+
+	merged := config.Reloadable(old, next)
+	for _, c := range config.Diff(merged, next) {
+		if c.Kind != config.ChangeRestart {
+			t.Errorf("%s (%v) was not carried over", c.Path, c.Kind)
+		}
+	}
+	if merged.Server.Address != old.Server.Address || merged.Pool.OnDemand || merged.Pool.MaxFrameSize != old.Pool.MaxFrameSize {
+		t.Error("restart-only settings must keep their running value")
+	}
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// literalTag marks a scalar that must not be expanded:
+//
+//	password: !literal "pa$${word}"
+const literalTag = "!literal"
+
+// redactedValue replaces env-sourced values in Dump output.
+const redactedValue = "[redacted from env]"
+
+// expandEnv expands ${VAR} and ${VAR:-default} references in every scalar
+// value under n, in place. "$$" is a literal "$". Values tagged !literal
+// are left untouched. It returns the dotted paths of values that took a
+// variable from the environment, and an error naming every unset variable
+// that had no default.
+func expandEnv(n *yaml.Node, lookup func(string) (string, bool)) (map[string]bool, error) {
+	e := &envExpander{lookup: lookup, sourced: make(map[string]bool), missing: make(map[string][]string)}
+	e.walk(n, "")
+	if len(e.errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(e.errs, "; "))
+	}
+	if len(e.missing) > 0 {
+		names := make([]string, 0, len(e.missing))
+		for name := range e.missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = fmt.Sprintf("%s (%s)", name, strings.Join(e.missing[name], ", "))
+		}
+		return nil, fmt.Errorf("unset environment variables: %s", strings.Join(parts, ", "))
+	}
+	return e.sourced, nil
+}
+
+type envExpander struct {
+	lookup  func(string) (string, bool)
+	sourced map[string]bool
+	missing map[string][]string // variable -> paths referencing it
+	errs    []string
+}
+
+func (e *envExpander) walk(n *yaml.Node, path string) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			e.walk(c, path)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			e.walk(n.Content[i+1], joinPath(path, n.Content[i].Value))
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			e.walk(c, path+"["+strconv.Itoa(i)+"]")
+		}
+	case yaml.ScalarNode:
+		if n.Tag == literalTag {
+			n.Tag = "!!str"
+			return
+		}
+		if !strings.Contains(n.Value, "$") {
+			return
+		}
+		val, substituted, fromEnv, err := e.expand(n.Value, path)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Sprintf("%s: %v", path, err))
+			return
+		}
+		n.Value = val
+		if fromEnv {
+			e.sourced[path] = true
+		}
+		// Let the substituted text be re-typed (e.g. "8080" -> int)
+		// unless the author quoted the original value.
+		if substituted && n.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) == 0 {
+			n.Tag = ""
+		}
+	}
+}
+
+// expand substitutes references in s. substituted reports whether any
+// ${...} reference was present, fromEnv whether any was satisfied by an
+// environment variable rather than its default.
+func (e *envExpander) expand(s, path string) (out string, substituted, fromEnv bool, err error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", false, false, fmt.Errorf("unterminated ${ in %q", s)
+			}
+			expr := s[i+2 : i+2+end]
+			name, def, hasDef := strings.Cut(expr, ":-")
+			if !validEnvName(name) {
+				return "", false, false, fmt.Errorf("invalid variable name %q", name)
+			}
+			substituted = true
+			if v, ok := e.lookup(name); ok && (v != "" || !hasDef) {
+				b.WriteString(v)
+				fromEnv = true
+			} else if hasDef {
+				b.WriteString(def)
+			} else {
+				e.missing[name] = append(e.missing[name], path)
+			}
+			i += 2 + end
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), substituted, fromEnv, nil
+}
+
+func validEnvName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// Dump renders the effective configuration as YAML. Values that were
+// substituted from environment variables are redacted, so the output is
+// safe to show in `maboo config` or over the admin API.
+func (c *Config) Dump() ([]byte, error) {
+	var root yaml.Node
+	if err := root.Encode(c); err != nil {
+		return nil, fmt.Errorf("encoding config: %w", err)
+	}
+	if len(c.envPaths) > 0 {
+		redactPaths(&root, "", c.envPaths)
+	}
+	return yaml.Marshal(&root)
+}
+
+func redactPaths(n *yaml.Node, path string, paths map[string]bool) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			redactPaths(c, path, paths)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			redactPaths(n.Content[i+1], joinPath(path, n.Content[i].Value), paths)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			redactPaths(c, path+"["+strconv.Itoa(i)+"]", paths)
+		}
+	case yaml.ScalarNode:
+		if paths[path] {
+			n.Tag = "!!str"
+			n.Style = yaml.DoubleQuotedStyle
+			n.Value = redactedValue
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "maboo.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadExpandsEnv(t *testing.T) {
+	t.Setenv("MABOO_TEST_HOST", "127.0.0.1")
+	t.Setenv("MABOO_TEST_WORKERS", "8")
+	t.Setenv("MABOO_TEST_EMAIL", "ops@example.com")
+	t.Setenv("MABOO_TEST_EMPTY", "")
+
+	tests := []struct {
+		name  string
+		yaml  string
+		check func(t *testing.T, cfg *config.Config)
+	}{
+		{
+			name: "scalar with default and typed value",
+			yaml: "server:\n  address: \"${MABOO_TEST_HOST}:${MABOO_TEST_PORT:-9090}\"\npool:\n  min_workers: 2\n  max_workers: ${MABOO_TEST_WORKERS}\n",
+			check: func(t *testing.T, cfg *config.Config) {
+				if cfg.Server.Address != "127.0.0.1:9090" {
+					t.Errorf("address = %q", cfg.Server.Address)
+				}
+				if cfg.Pool.MaxWorkers != 8 {
+					t.Errorf("max_workers = %d", cfg.Pool.MaxWorkers)
+				}
+			},
+		},
+		{
+			name: "nested map",
+			yaml: "server:\n  tls:\n    acme:\n      email: ${MABOO_TEST_EMAIL}\napp:\n  env:\n    DB_HOST: ${MABOO_TEST_HOST}\n    MODE: ${MABOO_TEST_EMPTY:-prod}\n",
+			check: func(t *testing.T, cfg *config.Config) {
+				if cfg.Server.TLS.ACME.Email != "ops@example.com" {
+					t.Errorf("acme.email = %q", cfg.Server.TLS.ACME.Email)
+				}
+				want := map[string]string{"DB_HOST": "127.0.0.1", "MODE": "prod"}
+				if !reflect.DeepEqual(cfg.App.Env, want) {
+					t.Errorf("app.env = %v, want %v", cfg.App.Env, want)
+				}
+			},
+		},
+		{
+			name: "lists",
+			yaml: "server:\n  tls:\n    acme:\n      domains: [\"${MABOO_TEST_HOST}\", \"static.example.com\"]\nwatch:\n  dirs:\n    - ${MABOO_TEST_DIR:-src}\n    - vendor\n",
+			check: func(t *testing.T, cfg *config.Config) {
+				if !reflect.DeepEqual(cfg.Server.TLS.ACME.Domains, []string{"127.0.0.1", "static.example.com"}) {
+					t.Errorf("domains = %v", cfg.Server.TLS.ACME.Domains)
+				}
+				if !reflect.DeepEqual(cfg.Watch.Dirs, []string{"src", "vendor"}) {
+					t.Errorf("dirs = %v", cfg.Watch.Dirs)
+				}
+			},
+		},
+		{
+			name: "escapes and literal tag",
+			yaml: "php:\n  ini:\n    a: \"cost $$5\"\n    b: !literal \"${NOT_EXPANDED} $$\"\n    c: \"price: $9\"\n",
+			check: func(t *testing.T, cfg *config.Config) {
+				want := map[string]string{"a": "cost $5", "b": "${NOT_EXPANDED} $$", "c": "price: $9"}
+				for k, v := range want {
+					if cfg.PHP.INI[k] != v {
+						t.Errorf("php.ini.%s = %q, want %q", k, cfg.PHP.INI[k], v)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.Load(writeConfig(t, tt.yaml))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadReportsAllMissingEnv(t *testing.T) {
+	path := writeConfig(t, "server:\n  address: ${MABOO_MISSING_A}\napp:\n  env:\n    X: ${MABOO_MISSING_B}\n    Y: ${MABOO_MISSING_A}\n")
+
+	_, err := config.Load(path)
+	if err == nil {
+		t.Fatal("expected an error for unset variables")
+	}
+	for _, want := range []string{"MABOO_MISSING_A (server.address, app.env.Y)", "MABOO_MISSING_B (app.env.X)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestDumpRedactsEnvValues(t *testing.T) {
+	t.Setenv("MABOO_TEST_EMAIL", "secret@example.com")
+	cfg, err := config.Load(writeConfig(t, "server:\n  tls:\n    acme:\n      email: ${MABOO_TEST_EMAIL}\n      domains: [example.com]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cfg.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "secret@example.com") {
+		t.Errorf("dump leaks env value:\n%s", out)
+	}
+	if !strings.Contains(string(out), "example.com") || !strings.Contains(string(out), "redacted") {
+		t.Errorf("unexpected dump:\n%s", out)
+	}
+}
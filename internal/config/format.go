@@ -0,0 +1,70 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// parseDocument parses a config file into a YAML node tree, choosing the
+// format by extension (.yaml/.yml, .json, .toml; anything else is YAML).
+// JSON and TOML are converted to the same tree so env expansion and
+// decoding behave identically for every format.
+func parseDocument(path string, data []byte) (*yaml.Node, error) {
+	var root yaml.Node
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var v map[string]interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("parsing JSON: %w", err)
+		}
+		if err := root.Encode(jsonNumbers(v)); err != nil {
+			return nil, fmt.Errorf("converting JSON: %w", err)
+		}
+
+	case ".toml":
+		var v map[string]interface{}
+		if err := toml.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("parsing TOML: %w", err)
+		}
+		if err := root.Encode(v); err != nil {
+			return nil, fmt.Errorf("converting TOML: %w", err)
+		}
+
+	default:
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, err
+		}
+	}
+	return &root, nil
+}
+
+// jsonNumbers replaces json.Number values with int64 or float64 so they
+// encode as YAML numbers rather than strings.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonNumbers(e)
+		}
+	}
+	return v
+}
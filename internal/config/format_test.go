@@ -0,0 +1,49 @@
+package config_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestLoadFormatsAgree(t *testing.T) {
+	want, err := config.Load("testdata/full.yaml")
+	if err != nil {
+		t.Fatalf("yaml: %v", err)
+	}
+	if want.Pool.RequestTimeout.Duration() != 45*time.Second || want.Health.Checks[0].Interval.Duration() != 5*time.Second {
+		t.Fatalf("fixture not applied: %+v", want.Pool)
+	}
+
+	for _, path := range []string{"testdata/full.json", "testdata/full.toml"} {
+		t.Run(path, func(t *testing.T) {
+			got, err := config.Load(path)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("config from %s differs from YAML:\n got  %+v\n want %+v", path, got, want)
+			}
+		})
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	var d config.Duration
+	if err := json.Unmarshal([]byte(`"1m30s"`), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Duration() != 90*time.Second {
+		t.Errorf("got %v", d.Duration())
+	}
+	out, _ := json.Marshal(d)
+	if string(out) != `"1m30s"` {
+		t.Errorf("marshal = %s", out)
+	}
+	if err := json.Unmarshal([]byte(`30`), &d); err == nil {
+		t.Error("expected an error for a bare number")
+	}
+}
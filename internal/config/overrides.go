@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ApplyOverrides sets config values by YAML path, as if they had been
+// written in the file: {"logging.level": "debug", "watch.dirs": "[src]"}.
+// Values are parsed as YAML, so lists, booleans and sizes work as they do
+// in maboo.yaml. Overrides are applied after Load, so run Validate again
+// afterwards.
+func (c *Config) ApplyOverrides(overrides map[string]string) error {
+	paths := make([]string, 0, len(overrides))
+	for p := range overrides {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := checkOverridePath(reflect.TypeOf(*c), path); err != nil {
+			return fmt.Errorf("override %s: %w", path, err)
+		}
+		var value yaml.Node
+		if err := yaml.Unmarshal([]byte(overrides[path]), &value); err != nil {
+			return fmt.Errorf("override %s: %w", path, err)
+		}
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+		if len(value.Content) > 0 {
+			node = value.Content[0]
+		}
+		// Wrap the value in one mapping per path segment and decode it
+		// onto the config, which leaves every other field untouched.
+		keys := strings.Split(path, ".")
+		for i := len(keys) - 1; i >= 0; i-- {
+			node = &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: keys[i]}, node,
+			}}
+		}
+		if err := node.Decode(c); err != nil {
+			return fmt.Errorf("override %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// checkOverridePath reports paths that name no config key, which Decode
+// would otherwise ignore silently.
+func checkOverridePath(t reflect.Type, path string) error {
+	keys := strings.Split(path, ".")
+	for i, key := range keys {
+		if t.Kind() == reflect.Map {
+			// php.ini, app.env: any key is valid below a map.
+			return nil
+		}
+		if t.Kind() != reflect.Struct || t == durationType {
+			return fmt.Errorf("%s is not a section", strings.Join(keys[:i], "."))
+		}
+		field, ok := fieldByYAMLName(t, key)
+		if !ok {
+			return fmt.Errorf("unknown key %q", strings.Join(keys[:i+1], "."))
+		}
+		t = field.Type
+	}
+	return nil
+}
+
+func fieldByYAMLName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if f.IsExported() && tag == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+var durationType = reflect.TypeOf(Duration(0))
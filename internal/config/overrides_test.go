@@ -0,0 +1,64 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	cfg := config.Default()
+	cfg.PHP.INI = map[string]string{"memory_limit": "256M"}
+
+	err := cfg.ApplyOverrides(map[string]string{
+		"logging.level":          "debug",
+		"server.compression":     "false",
+		"server.max_body_size":   "8M",
+		"watch.dirs":             `[src, "my app"]`,
+		"watch.interval":         "500ms",
+		"php.ini.display_errors": "1",
+		"server.tls.cert":        `""`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Logging.Level != "debug" || cfg.Server.Compression {
+		t.Errorf("logging.level = %q, server.compression = %v", cfg.Logging.Level, cfg.Server.Compression)
+	}
+	if cfg.Server.MaxBodySize.Bytes() != 8<<20 {
+		t.Errorf("server.max_body_size = %d", cfg.Server.MaxBodySize)
+	}
+	if got := cfg.Watch.Dirs; len(got) != 2 || got[1] != "my app" {
+		t.Errorf("watch.dirs = %q", got)
+	}
+	if cfg.Watch.Interval.Duration() != 500*time.Millisecond {
+		t.Errorf("watch.interval = %s", cfg.Watch.Interval.Duration())
+	}
+	if cfg.PHP.INI["display_errors"] != "1" || cfg.PHP.INI["memory_limit"] != "256M" {
+		t.Errorf("php.ini = %v, want the override merged into the existing map", cfg.PHP.INI)
+	}
+	// Untouched values keep their defaults.
+	if cfg.Logging.Format != "json" || !cfg.Server.HTTP2 {
+		t.Errorf("unrelated values changed: format %q, http2 %v", cfg.Logging.Format, cfg.Server.HTTP2)
+	}
+}
+
+func TestApplyOverridesRejectsBadInput(t *testing.T) {
+	tests := map[string]struct{ path, value, want string }{
+		"unknown key":    {"logging.colour", "true", `unknown key "logging.colour"`},
+		"not a section":  {"logging.level.x", "1", "logging.level is not a section"},
+		"wrong type":     {"pool.max_workers", "many", "cannot unmarshal"},
+		"invalid syntax": {"logging.level", "[", "did not find expected"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := config.Default().ApplyOverrides(map[string]string{tt.path: tt.value})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Secret is a sensitive string such as a token or password. It prints as
+// "[redacted]" in logs, errors and config dumps; use Value for the real
+// contents.
+//
+// Every Secret field also accepts a "<key>_file" sibling naming a file to
+// read the value from (a Kubernetes secret mount, for example):
+//
+//	metrics:
+//	  token_file: /run/secrets/metrics-token
+type Secret string
+
+// secretFileSuffix is appended to a Secret's key to read it from a file.
+const secretFileSuffix = "_file"
+
+const redactedSecret = "[redacted]"
+
+// Value returns the secret in plain text.
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// String redacts non-empty secrets.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redactedSecret
+}
+
+// GoString redacts %#v output as well.
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// resolveSecretFiles replaces every "<key>_file" entry that belongs to a
+// Secret field with "<key>" holding the file's contents, minus a trailing
+// newline. Setting both forms is an error.
+func resolveSecretFiles(root *yaml.Node) error {
+	var errs []string
+	walkSecrets(root, reflect.TypeOf(Config{}), "", &errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func walkSecrets(n *yaml.Node, t reflect.Type, path string, errs *[]string) {
+	if n.Kind == yaml.DocumentNode {
+		for _, c := range n.Content {
+			walkSecrets(c, t, path, errs)
+		}
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode || reflect.PointerTo(t).Implements(yamlUnmarshalerType) {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			ft, ok := fields[key.Value]
+			if ok {
+				walkSecrets(val, ft, joinPath(path, key.Value), errs)
+				continue
+			}
+			name, isFile := strings.CutSuffix(key.Value, secretFileSuffix)
+			if !isFile || fields[name] != secretType {
+				continue
+			}
+			keyPath := joinPath(path, key.Value)
+			if lookupKey(n, name) != nil {
+				*errs = append(*errs, fmt.Sprintf("%s: set either %s or %s, not both", joinPath(path, name), name, key.Value))
+				continue
+			}
+			data, err := os.ReadFile(val.Value)
+			if err != nil {
+				*errs = append(*errs, fmt.Sprintf("%s: %v", keyPath, err))
+				continue
+			}
+			key.Value = name
+			val.Kind, val.Tag, val.Style = yaml.ScalarNode, "!!str", 0
+			val.Value = strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, c := range n.Content {
+			walkSecrets(c, t.Elem(), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			walkSecrets(n.Content[i+1], t.Elem(), joinPath(path, n.Content[i].Value), errs)
+		}
+	}
+}
+
+// lookupKey returns the value for key in mapping n, or nil.
+func lookupKey(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
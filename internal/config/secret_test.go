@@ -0,0 +1,93 @@
+package config_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func writeSecret(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretFromFile(t *testing.T) {
+	token := writeSecret(t, "s3cret-token\n")
+	hmac := writeSecret(t, "aG1hYy1rZXk")
+
+	cfg, err := config.Load(writeConfig(t, fmt.Sprintf(`metrics:
+  token_file: %s
+server:
+  tls:
+    acme:
+      eab_key_id: kid-1
+      eab_hmac_key_file: %s
+`, token, hmac)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Metrics.Token.Value(); got != "s3cret-token" {
+		t.Errorf("metrics token = %q, want trailing newline trimmed", got)
+	}
+	if got := cfg.Server.TLS.ACME.EABHMACKey.Value(); got != "aG1hYy1rZXk" {
+		t.Errorf("eab_hmac_key = %q", got)
+	}
+}
+
+func TestSecretFileErrors(t *testing.T) {
+	token := writeSecret(t, "from-file")
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"both set", "metrics:\n  token: inline\n  token_file: " + token + "\n",
+			"metrics.token: set either token or token_file, not both"},
+		{"unreadable", "metrics:\n  token_file: " + filepath.Join(t.TempDir(), "missing") + "\n",
+			"metrics.token_file: open"},
+		{"not a secret", "server:\n  address_file: " + token + "\n",
+			`server.address_file (line 2): unknown key`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := config.Load(writeConfig(t, tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+			if err != nil && strings.Contains(err.Error(), "from-file") {
+				t.Errorf("error leaks the secret: %v", err)
+			}
+		})
+	}
+}
+
+func TestSecretRedacted(t *testing.T) {
+	cfg, err := config.Load(writeConfig(t, "metrics:\n  token_file: "+writeSecret(t, "hunter2\n")+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cfg.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "hunter2") || !strings.Contains(string(out), "token: '[redacted]'") {
+		t.Errorf("dump does not redact the token:\n%s", out)
+	}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		if s := fmt.Sprintf(format, cfg.Metrics); strings.Contains(s, "hunter2") {
+			t.Errorf("%s leaks the token: %s", format, s)
+		}
+	}
+	if config.Secret("").String() != "" {
+		t.Error("empty secret should print as empty")
+	}
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Size is a byte count that unmarshals from plain integers or strings with
+// a unit suffix. K, M and G are binary multiples, as in php.ini
+// ("128M" = 128 MiB); Ki, Mi and Gi are accepted as explicit aliases.
+type Size int64
+
+// Size units.
+const (
+	KiB Size = 1 << 10
+	MiB Size = 1 << 20
+	GiB Size = 1 << 30
+)
+
+var sizeSuffixes = map[string]Size{
+	"":  1,
+	"b": 1,
+	"k": KiB, "ki": KiB,
+	"m": MiB, "mi": MiB,
+	"g": GiB, "gi": GiB,
+}
+
+// ParseSize parses a size such as "512", "64K", "128M", "1Gi". Suffixes
+// are case-insensitive. Negative values and unknown suffixes (including
+// "MB", which is ambiguous between decimal and binary) are rejected.
+func ParseSize(s string) (Size, error) {
+	s = strings.TrimSpace(s)
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9') {
+		i++
+	}
+	if i == 0 {
+		if strings.HasPrefix(s, "-") {
+			return 0, fmt.Errorf("invalid size %q: must not be negative", s)
+		}
+		return 0, fmt.Errorf("invalid size %q: expected a number with optional K, M or G suffix", s)
+	}
+
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+	unit, ok := sizeSuffixes[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q (use K, M, G or Ki, Mi, Gi)", s, s[i:])
+	}
+	if n > int64((1<<63-1)/unit) {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return Size(n) * unit, nil
+}
+
+// Bytes returns the size as an int64 byte count.
+func (s Size) Bytes() int64 {
+	return int64(s)
+}
+
+// String renders the size with the largest exact unit, e.g. "128M".
+func (s Size) String() string {
+	switch {
+	case s != 0 && s%GiB == 0:
+		return strconv.FormatInt(int64(s/GiB), 10) + "G"
+	case s != 0 && s%MiB == 0:
+		return strconv.FormatInt(int64(s/MiB), 10) + "M"
+	case s != 0 && s%KiB == 0:
+		return strconv.FormatInt(int64(s/KiB), 10) + "K"
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
+
+func (s *Size) UnmarshalYAML(value *yaml.Node) error {
+	var str string
+	if err := value.Decode(&str); err != nil {
+		return err
+	}
+	return s.UnmarshalText([]byte(str))
+}
+
+func (s Size) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		return s.UnmarshalText([]byte(v))
+	case float64:
+		return s.UnmarshalText([]byte(strconv.FormatFloat(v, 'f', -1, 64)))
+	}
+	return fmt.Errorf("invalid size %s", data)
+}
+
+func (s Size) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by TOML).
+func (s *Size) UnmarshalText(text []byte) error {
+	parsed, err := ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+func (s Size) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
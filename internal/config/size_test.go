@@ -0,0 +1,90 @@
+package config_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    config.Size
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512b", 512, false},
+		{"64K", 64 << 10, false},
+		{"64k", 64 << 10, false},
+		{"64Ki", 64 << 10, false},
+		{"128M", 128 << 20, false},
+		{"128m", 128 << 20, false},
+		{"128Mi", 128 << 20, false},
+		{"128MI", 128 << 20, false},
+		{"2G", 2 << 30, false},
+		{"2Gi", 2 << 30, false},
+		{" 1G ", 1 << 30, false},
+		{"128MB", 0, true},
+		{"1.5G", 0, true},
+		{"-1", 0, true},
+		{"-128M", 0, true},
+		{"", 0, true},
+		{"M", 0, true},
+		{"12T", 0, true},
+		{"99999999999999G", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := config.ParseSize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSizeString(t *testing.T) {
+	for in, want := range map[config.Size]string{
+		0:                "0",
+		1000:             "1000",
+		2 * config.KiB:   "2K",
+		128 * config.MiB: "128M",
+		config.GiB:       "1G",
+	} {
+		if got := in.String(); got != want {
+			t.Errorf("Size(%d).String() = %q, want %q", int64(in), got, want)
+		}
+	}
+}
+
+func TestSizeJSON(t *testing.T) {
+	var s struct{ A, B config.Size }
+	if err := json.Unmarshal([]byte(`{"A": "64M", "B": 1024}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.A != 64*config.MiB || s.B != 1024 {
+		t.Errorf("got %+v", s)
+	}
+	if err := json.Unmarshal([]byte(`{"A": "64MB"}`), &s); err == nil {
+		t.Error("expected an error for 64MB")
+	}
+}
+
+func TestLoadRejectsBadSize(t *testing.T) {
+	if _, err := config.Load(writeConfig(t, "pool:\n  max_memory: 128MB\n")); err == nil {
+		t.Error("expected max_memory: 128MB to be rejected")
+	}
+	cfg, err := config.Load(writeConfig(t, "pool:\n  max_memory: 256Mi\nserver:\n  max_body_size: 1048576\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Pool.MaxMemory != 256*config.MiB || cfg.Server.MaxBodySize != config.MiB {
+		t.Errorf("got max_memory=%v max_body_size=%v", cfg.Pool.MaxMemory, cfg.Server.MaxBodySize)
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import "github.com/sadewadee/maboo/internal/cgroup"
+
+// PoolSizing is how the pool's worker counts were chosen: the container
+// limits Load was given and, when the file left the counts out, the
+// counts derived from the memory limit.
+type PoolSizing struct {
+	Limits       cgroup.Limits `json:"-"`       // reported on their own
+	Derived      bool          `json:"derived"` // min/max_workers computed from Limits.Memory
+	MinWorkers   int           `json:"min_workers"`
+	MaxWorkers   int           `json:"max_workers"`
+	WorkerMemory int64         `json:"worker_memory_bytes,omitempty"` // estimate the counts were derived with
+}
+
+// PoolSizing returns how the pool was sized; zero unless the config was
+// loaded with Options.Limits.
+func (c *Config) PoolSizing() PoolSizing {
+	return c.sizing
+}
+
+// WorkerMemoryEstimate is the memory one worker is expected to use:
+// pool.worker_memory, or pool.max_memory when that is 0.
+func (p PoolConfig) WorkerMemoryEstimate() int64 {
+	if p.WorkerMemory > 0 {
+		return p.WorkerMemory.Bytes()
+	}
+	return p.MaxMemory.Bytes()
+}
+
+// sizePool fits the default worker counts to a memory limit: as many
+// workers as the limit holds at the per-worker estimate, but never more
+// than the defaults. Counts set in the file are kept.
+func (c *Config) sizePool(l cgroup.Limits, explicit bool) {
+	c.sizing = PoolSizing{Limits: l}
+	per := c.Pool.WorkerMemoryEstimate()
+	if !explicit && l.Memory > 0 && per > 0 {
+		fit := int(max(1, min(l.Memory/per, int64(c.Pool.MaxWorkers))))
+		c.Pool.MaxWorkers = fit
+		c.Pool.MinWorkers = min(c.Pool.MinWorkers, fit)
+		c.sizing.Derived = true
+		c.sizing.WorkerMemory = per
+	}
+	c.sizing.MinWorkers = c.Pool.MinWorkers
+	c.sizing.MaxWorkers = c.Pool.MaxWorkers
+}
@@ -0,0 +1,214 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Warning is a non-fatal problem found while loading a config file.
+type Warning struct {
+	Path        string // dotted key path, e.g. "php.worker"
+	Line        int    // line in the source file; 0 for JSON/TOML
+	Message     string
+	Replacement string // for deprecated keys, the key to use instead
+}
+
+func (w Warning) String() string {
+	if w.Line > 0 {
+		return fmt.Sprintf("%s (line %d): %s", w.Path, w.Line, w.Message)
+	}
+	return fmt.Sprintf("%s: %s", w.Path, w.Message)
+}
+
+// deprecation describes a key that still works but has a replacement.
+// migrate copies the old value into the new location; it runs after
+// decoding and must leave configs that already use the replacement alone.
+type deprecation struct {
+	path        string
+	replacement string
+	migrate     func(c *Config)
+}
+
+// deprecations is the registry of renamed keys.
+var deprecations = []deprecation{
+	{
+		path:        "php.worker",
+		replacement: "workers[].script",
+		migrate: func(c *Config) {
+			if c.PHP.Worker != "" && len(c.Workers) == 0 {
+				c.Workers = []WorkerConfig{{Script: c.PHP.Worker, Count: c.Pool.MinWorkers}}
+			}
+		},
+	},
+	{
+		path:        "server.compression",
+		replacement: "compression.enabled",
+		migrate: func(c *Config) {
+			if !c.Server.Compression {
+				c.Compression.Enabled = false
+			}
+		},
+	},
+}
+
+// checkDeprecated returns a warning for every deprecated key present in
+// the document.
+func checkDeprecated(root *yaml.Node) []Warning {
+	var warnings []Warning
+	for _, d := range deprecations {
+		if n := lookupNode(root, d.path); n != nil {
+			warnings = append(warnings, Warning{
+				Path:        d.path,
+				Line:        n.Line,
+				Message:     fmt.Sprintf("deprecated, use %s instead", d.replacement),
+				Replacement: d.replacement,
+			})
+		}
+	}
+	return warnings
+}
+
+func applyDeprecations(c *Config) {
+	for _, d := range deprecations {
+		d.migrate(c)
+	}
+}
+
+// lookupNode finds the value node at a dotted mapping path.
+func lookupNode(n *yaml.Node, path string) *yaml.Node {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	for _, key := range strings.Split(path, ".") {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				next = n.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
+
+// unknownKeys walks the document against the Config type and reports
+// every mapping key that does not correspond to a field.
+func unknownKeys(root *yaml.Node) []Warning {
+	var out []Warning
+	walkKnown(root, reflect.TypeOf(Config{}), "", &out)
+	return out
+}
+
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+func walkKnown(n *yaml.Node, t reflect.Type, path string, out *[]Warning) {
+	if n.Kind == yaml.DocumentNode {
+		for _, c := range n.Content {
+			walkKnown(c, t, path, out)
+		}
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(yamlUnmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			keyPath := joinPath(path, key.Value)
+			ft, ok := fields[key.Value]
+			if !ok {
+				msg := "unknown key"
+				if s := suggest(key.Value, fields); s != "" {
+					msg += fmt.Sprintf(", did you mean %q?", s)
+				}
+				*out = append(*out, Warning{Path: keyPath, Line: key.Line, Message: msg})
+				continue
+			}
+			walkKnown(n.Content[i+1], ft, keyPath, out)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, c := range n.Content {
+			walkKnown(c, t.Elem(), path+"["+strconv.Itoa(i)+"]", out)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			walkKnown(n.Content[i+1], t.Elem(), joinPath(path, n.Content[i].Value), out)
+		}
+	}
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// suggest returns the known key closest to key, if it is a likely typo.
+func suggest(key string, fields map[string]reflect.Type) string {
+	best, bestDist := "", 3
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDist || (d == bestDist && best != "" && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,95 @@
+package config_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestStrictRejectsUnknownKeys(t *testing.T) {
+	path := writeConfig(t, "pool:\n  max_worker: 64\nphp:\n  verison: \"8.3\"\n  ini:\n    any_key: \"1\"\n")
+
+	_, err := config.Load(path)
+	if err == nil {
+		t.Fatal("expected unknown keys to be rejected")
+	}
+	for _, want := range []string{
+		`pool.max_worker (line 2): unknown key, did you mean "max_workers"?`,
+		`php.verison (line 4)`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "any_key") {
+		t.Error("keys inside free-form maps must be accepted")
+	}
+}
+
+func TestNonStrictWarnsAboutUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		opts config.Options
+	}{
+		{"file setting", "strict: false\npool:\n  max_worker: 64\n", config.Options{}},
+		{"option", "pool:\n  max_worker: 64\n", config.Options{NonStrict: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.LoadWithOptions(writeConfig(t, tt.yaml), tt.opts)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if w := cfg.Warnings(); len(w) != 1 || w[0].Path != "pool.max_worker" {
+				t.Errorf("warnings = %v", w)
+			}
+		})
+	}
+}
+
+func TestDeprecatedKeysMigrate(t *testing.T) {
+	cfg, err := config.Load(writeConfig(t, "php:\n  worker: worker.php\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := cfg.Warnings()
+	if len(w) != 1 || w[0].Path != "php.worker" || w[0].Replacement != "workers[].script" || w[0].Line != 2 {
+		t.Fatalf("warnings = %+v", w)
+	}
+	if len(cfg.Workers) != 1 || cfg.Workers[0].Script != "worker.php" {
+		t.Errorf("workers = %+v, want php.worker migrated", cfg.Workers)
+	}
+}
+
+func TestDeprecatedServerCompression(t *testing.T) {
+	cfg, err := config.Load(writeConfig(t, "server:\n  compression: false\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := cfg.Warnings(); len(w) != 1 || w[0].Path != "server.compression" || w[0].Replacement != "compression.enabled" {
+		t.Fatalf("warnings = %+v", w)
+	}
+	if cfg.Compression.Enabled {
+		t.Error("server.compression: false left compression enabled")
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	cfg := config.Default()
+	cfg.Pool.MinWorkers = 0
+	cfg.Pool.MaxJobs = -1
+	cfg.PHP.Mode = "cgi"
+	cfg.Server.Address = ""
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 4 {
+		t.Errorf("expected 4 joined errors, got: %v", err)
+	}
+}
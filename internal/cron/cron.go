@@ -0,0 +1,220 @@
+// Package cron parses standard five-field cron expressions and computes
+// their next activation time.
+package cron
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Vixie cron semantics: when both day fields are restricted a day
+	// matches if either does; otherwise the "*" field matches every day.
+	domStar, dowStar bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as a second Sunday and folded onto 0.
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses "minute hour day-of-month month day-of-week" or one of
+// the @yearly, @monthly, @weekly, @daily and @hourly shorthands. Fields
+// accept "*", values, ranges ("1-5"), steps ("*/15", "0-30/10"), lists
+// ("1,15") and, for months and weekdays, three-letter names.
+func Parse(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "@") {
+		m, ok := macros[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("unknown shorthand %q", spec)
+		}
+		spec = m
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d in %q", len(parts), expr)
+	}
+
+	var (
+		s   Schedule
+		err error
+	)
+	if s.minute, err = minuteField.parse(parts[0]); err != nil {
+		return nil, err
+	}
+	if s.hour, err = hourField.parse(parts[1]); err != nil {
+		return nil, err
+	}
+	if s.dom, err = domField.parse(parts[2]); err != nil {
+		return nil, err
+	}
+	if s.month, err = monthField.parse(parts[3]); err != nil {
+		return nil, err
+	}
+	if s.dow, err = dowField.parse(parts[4]); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domStar = parts[2] == "*"
+	s.dowStar = parts[4] == "*"
+	return &s, nil
+}
+
+// parse turns one comma-separated field into a bit set.
+func (f field) parse(spec string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(spec, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%s field %q: invalid step %q", f.name, spec, stepStr)
+			}
+			step = n
+		}
+
+		var lo, hi int
+		switch lowStr, highStr, isRange := strings.Cut(rng, "-"); {
+		case rng == "*":
+			lo, hi = f.min, f.max
+		case isRange:
+			var err error
+			if lo, err = f.value(lowStr); err != nil {
+				return 0, fmt.Errorf("%s field %q: %w", f.name, spec, err)
+			}
+			if hi, err = f.value(highStr); err != nil {
+				return 0, fmt.Errorf("%s field %q: %w", f.name, spec, err)
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("%s field %q: range %d-%d is backwards", f.name, spec, lo, hi)
+			}
+		default:
+			v, err := f.value(rng)
+			if err != nil {
+				return 0, fmt.Errorf("%s field %q: %w", f.name, spec, err)
+			}
+			// "5/15" means from 5 to the end in steps of 15.
+			lo, hi = v, v
+			if hasStep {
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// maxSearch bounds Next for expressions that can never match, such as
+// "0 0 30 2 *".
+const maxSearch = 5
+
+// Next returns the first activation strictly after t, in t's location.
+// It returns the zero time if there is none within the next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + maxSearch
+
+	for t.Year() <= limit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) { // repeated hour at the end of DST
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Duration(s.minutesUntilMatch(t.Minute())) * time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// minutesUntilMatch is how far the next matching minute of this hour is
+// from m, or the distance to the top of the hour when none is left.
+func (s *Schedule) minutesUntilMatch(m int) int {
+	rest := s.minute >> uint(m+1) << uint(m+1)
+	if rest == 0 {
+		return 60 - m
+	}
+	return bits.TrailingZeros64(rest) - m
+}
@@ -0,0 +1,99 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/cron"
+)
+
+func TestNext(t *testing.T) {
+	// Wednesday, 15 January 2025.
+	from := time.Date(2025, 1, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 15, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 1, 15, 10, 15, 0, 0, time.UTC)},
+		{"5 * * * *", time.Date(2025, 1, 15, 11, 5, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2025, 1, 15, 13, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2025, 1, 16, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * mon-fri", time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 JUN *", time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{"10,40 10 * * *", time.Date(2025, 1, 15, 10, 10, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 20th or any Friday.
+		{"0 0 20 * fri", time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := cron.Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Parse(%q).Next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestNextIsStrictlyAfter(t *testing.T) {
+	s, _ := cron.Parse("0 * * * *")
+	at := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	if got, want := s.Next(at), at.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("Next(%v) = %v, want %v", at, got, want)
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	s, _ := cron.Parse("30 2 * * *")
+	// 2:30 does not exist on 9 March 2025; the next run is a day later.
+	from := time.Date(2025, 3, 8, 12, 0, 0, 0, loc)
+	got := s.Next(from)
+	if want := time.Date(2025, 3, 10, 2, 30, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("Next across DST = %v, want %v", got, want)
+	}
+}
+
+func TestNextNeverMatches(t *testing.T) {
+	s, err := cron.Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Now()); !got.IsZero() {
+		t.Errorf("Next = %v, want zero time", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@every 5m",
+	} {
+		if _, err := cron.Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
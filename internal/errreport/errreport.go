@@ -0,0 +1,60 @@
+// Package errreport sends errors that need a human's attention, such as
+// recovered panics and workers crashing in a loop, to an external service.
+// Reporting is asynchronous and never blocks the caller: events wait in a
+// bounded queue and are dropped when it is full.
+package errreport
+
+import (
+	"context"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var reportsTotal = metrics.NewCounterVec("maboo_error_reports_total",
+	"Error events by outcome (sent, failed, dropped, sampled_out).", "result")
+
+// Outcomes of an event, used as metric label values.
+const (
+	resultSent       = "sent"
+	resultFailed     = "failed"      // the service could not be reached or refused it
+	resultDropped    = "dropped"     // the queue was full, or the reporter closed
+	resultSampledOut = "sampled_out" // left out by the sample rate
+)
+
+// Event kinds.
+const (
+	KindPanic           = "panic"             // a panic recovered while handling a request
+	KindPHPError        = "php_error"         // PHP failed to answer a request
+	KindWorkerCrashLoop = "worker_crash_loop" // workers of a pool keep crashing
+)
+
+// Levels of an event.
+const (
+	LevelError = "error"
+	LevelFatal = "fatal"
+)
+
+// Event is one error to report.
+type Event struct {
+	Kind    string
+	Level   string
+	Message string
+	Tags    map[string]string // searchable: request_id, route, worker_id, ...
+	Extra   map[string]string // context such as the path or a stack trace
+}
+
+// Reporter sends events to an error tracking service.
+type Reporter interface {
+	// Report queues e to be sent. It never blocks.
+	Report(e Event)
+
+	// Close sends the events still queued, giving up when ctx is done,
+	// and stops the reporter. Later events are dropped.
+	Close(ctx context.Context) error
+}
+
+// Nop is the Reporter used when error reporting is not configured.
+type Nop struct{}
+
+func (Nop) Report(Event)                {}
+func (Nop) Close(context.Context) error { return nil }
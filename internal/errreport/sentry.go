@@ -0,0 +1,210 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DSN is a parsed Sentry DSN, https://<key>@<host>[/<path>]/<project>.
+type DSN struct {
+	Endpoint  string // the project's store API
+	PublicKey string
+}
+
+// ParseDSN parses a Sentry DSN.
+func ParseDSN(dsn string) (DSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		// The error would quote the DSN, key included.
+		return DSN{}, fmt.Errorf("DSN is not a URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return DSN{}, fmt.Errorf("DSN scheme must be http or https, got %q", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return DSN{}, fmt.Errorf("DSN has no public key")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || path[i+1:] == "" {
+		return DSN{}, fmt.Errorf("DSN has no project ID")
+	}
+	prefix, project := path[:i], path[i+1:]
+	return DSN{
+		Endpoint:  fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		PublicKey: u.User.Username(),
+	}, nil
+}
+
+// Options configures a Sentry reporter.
+type Options struct {
+	Release     string  // maboo version, tagged on every event
+	Environment string  // e.g. production
+	SampleRate  float64 // share of events sent, 0-1
+	QueueSize   int     // events waiting to be sent; more are dropped
+	Timeout     time.Duration
+}
+
+// Sentry reports events to Sentry, or a service that speaks its store API,
+// from a single background goroutine.
+type Sentry struct {
+	dsn    DSN
+	opts   Options
+	host   string
+	client *http.Client
+	logger *slog.Logger
+
+	mu     sync.RWMutex // guards closed, and queue against sends after close
+	closed bool
+	queue  chan Event
+	done   chan struct{}
+}
+
+// NewSentry returns a reporter sending to the project named by dsn.
+func NewSentry(dsn string, opts Options, logger *slog.Logger) (*Sentry, error) {
+	d, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	s := &Sentry{
+		dsn:    d,
+		opts:   opts,
+		host:   host,
+		client: &http.Client{Timeout: opts.Timeout},
+		logger: logger,
+		queue:  make(chan Event, max(opts.QueueSize, 1)),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Report queues e, unless the sample rate leaves it out or the queue is
+// full.
+func (s *Sentry) Report(e Event) {
+	if s.opts.SampleRate < 1 && rand.Float64() >= s.opts.SampleRate {
+		reportsTotal.WithLabelValues(resultSampledOut).Inc()
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		reportsTotal.WithLabelValues(resultDropped).Inc()
+		return
+	}
+	select {
+	case s.queue <- e:
+	default:
+		reportsTotal.WithLabelValues(resultDropped).Inc()
+	}
+}
+
+// Close sends the queued events and stops the reporter.
+func (s *Sentry) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sentry) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		if err := s.send(e); err != nil {
+			reportsTotal.WithLabelValues(resultFailed).Inc()
+			s.logger.Warn("sending error report", "kind", e.Kind, "error", err)
+			continue
+		}
+		reportsTotal.WithLabelValues(resultSent).Inc()
+	}
+}
+
+// sentryEvent is the body of a store API request.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	Platform    string            `json:"platform"`
+	Message     string            `json:"message"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Tags        map[string]string `json:"tags"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+func (s *Sentry) send(e Event) error {
+	tags := make(map[string]string, len(e.Tags)+1)
+	for k, v := range e.Tags {
+		tags[k] = v
+	}
+	tags["kind"] = e.Kind
+	level := e.Level
+	if level == "" {
+		level = LevelError
+	}
+	body, err := json.Marshal(sentryEvent{
+		EventID:     eventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Logger:      "maboo",
+		Platform:    "go",
+		Message:     e.Message,
+		Release:     s.opts.Release,
+		Environment: s.opts.Environment,
+		ServerName:  s.host,
+		Tags:        tags,
+		Extra:       e.Extra,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.dsn.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=maboo/%s, sentry_key=%s",
+		s.opts.Release, s.dsn.PublicKey))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("store API answered %s", resp.Status)
+	}
+	return nil
+}
+
+// eventID returns a random 32-digit hex event ID.
+func eventID() string {
+	var b [16]byte
+	crand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
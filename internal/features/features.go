@@ -0,0 +1,84 @@
+// Package features is the registry of the optional maboo features PHP can
+// detect. Scripts see the ones the server offers, with its version, in
+// MABOO_FEATURES and MABOO_VERSION. A process worker declares the ones it
+// implements in its first WORKER_READY, and its pool uses only the
+// features both sides have.
+package features
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/buildinfo"
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+// The features, by the names scripts and workers use for them.
+const (
+	Stats      = "stats"      // per-request statistics: FlagDebug REQUESTs answered with WORKER_STATS
+	Invalidate = "invalidate" // INVALIDATE frames dropping changed files from opcache
+	Streaming  = "streaming"  // WebSocket events as STREAM_DATA frames
+	Timeout    = "timeout"    // max_execution_time in the REQUEST header
+	Sendfile   = "sendfile"   // X-Maboo-Sendfile responses and byte ranges
+	Dump       = "dump"       // SIGUSR2 answered with a LOG frame holding the request's backtrace
+)
+
+// All lists every feature in advertisement order.
+var All = Set{Stats, Invalidate, Streaming, Timeout, Sendfile, Dump}
+
+// The $_SERVER and environment variables the advertisement is made in.
+const (
+	VersionVar  = "MABOO_VERSION"
+	FeaturesVar = "MABOO_FEATURES"
+)
+
+// Set is a list of features in advertisement order.
+type Set []string
+
+// Offered returns the features cfg leaves enabled.
+func Offered(cfg *config.Config) Set {
+	var s Set
+	for _, f := range All {
+		switch f {
+		case Stats:
+			if !cfg.Debug.Stats && cfg.Debug.Secret == "" {
+				continue
+			}
+		case Invalidate:
+			if !cfg.Watch.Enabled || cfg.Watch.Strategy != config.WatchStrategyInvalidate {
+				continue
+			}
+		case Streaming:
+			if !cfg.WebSocket.Enabled {
+				continue
+			}
+		case Dump:
+			if !cfg.Admin.Enabled {
+				continue
+			}
+		}
+		s = append(s, f)
+	}
+	return s
+}
+
+// Has reports whether s includes f.
+func (s Set) Has(f string) bool {
+	return slices.Contains(s, f)
+}
+
+// String returns s comma-separated, as in MABOO_FEATURES.
+func (s Set) String() string {
+	return strings.Join(s, ",")
+}
+
+// Env returns the advertisement of offered as environment variables.
+func Env(offered Set) []string {
+	return []string{VersionVar + "=" + buildinfo.Version, FeaturesVar + "=" + offered.String()}
+}
+
+// Advertise adds the advertisement of offered to a request's $_SERVER.
+func Advertise(server map[string]string, offered Set) {
+	server[VersionVar] = buildinfo.Version
+	server[FeaturesVar] = offered.String()
+}
@@ -0,0 +1,47 @@
+package features_test
+
+import (
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/features"
+)
+
+func TestOffered(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		set  func(*config.Config)
+		want string
+	}{
+		{"defaults", func(*config.Config) {}, "timeout,sendfile"},
+		{"debug stats", func(c *config.Config) { c.Debug.Stats = true }, "stats,timeout,sendfile"},
+		{"debug secret", func(c *config.Config) { c.Debug.Secret = "0123456789abcdef" }, "stats,timeout,sendfile"},
+		{"watch reload", func(c *config.Config) { c.Watch.Enabled = true }, "timeout,sendfile"},
+		{"watch invalidate", func(c *config.Config) {
+			c.Watch.Enabled = true
+			c.Watch.Strategy = config.WatchStrategyInvalidate
+		}, "invalidate,timeout,sendfile"},
+		{"websocket", func(c *config.Config) { c.WebSocket.Enabled = true }, "streaming,timeout,sendfile"},
+		{"admin", func(c *config.Config) { c.Admin.Enabled = true }, "timeout,sendfile,dump"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Default()
+			tc.set(cfg)
+			if got := features.Offered(cfg).String(); got != tc.want {
+				t.Errorf("Offered() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAdvertise(t *testing.T) {
+	server := map[string]string{}
+	features.Advertise(server, features.Set{features.Stats, features.Timeout})
+	if server[features.FeaturesVar] != "stats,timeout" || server[features.VersionVar] == "" {
+		t.Errorf("$_SERVER = %v", server)
+	}
+	env := features.Env(nil)
+	if len(env) != 2 || env[1] != "MABOO_FEATURES=" {
+		t.Errorf("Env(nil) = %q", env)
+	}
+}
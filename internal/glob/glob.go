@@ -0,0 +1,160 @@
+// Package glob matches slash-separated paths against patterns with
+// doublestar semantics: "**" as a whole segment spans any number of
+// directories, including none.
+package glob
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// maxAlternatives bounds brace expansion, so "{a,b}{c,d}..." cannot blow up.
+const maxAlternatives = 256
+
+// Glob is a compiled pattern.
+type Glob struct {
+	pattern string
+	alts    [][]string // brace-expanded alternatives, split into segments
+}
+
+// Compile parses pattern. Besides "**", segments support the path.Match
+// syntax ("*", "?", "[a-z]", "[!x]", "\" escapes) and "{a,b}" expands to
+// alternatives, which may contain slashes. Patterns are relative: they
+// are matched against paths below a root and may not start with "/".
+// Matching is case-sensitive.
+func Compile(pattern string) (*Glob, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	if strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern %q must be relative", pattern)
+	}
+	expanded, err := expandBraces(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+
+	g := &Glob{pattern: pattern}
+	for _, alt := range expanded {
+		segs := strings.Split(alt, "/")
+		for _, seg := range segs {
+			if seg == "**" {
+				continue
+			}
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("pattern %q: bad segment %q", pattern, seg)
+			}
+		}
+		g.alts = append(g.alts, segs)
+	}
+	return g, nil
+}
+
+// Validate reports whether pattern compiles.
+func Validate(pattern string) error {
+	_, err := Compile(pattern)
+	return err
+}
+
+// Match reports whether the slash-separated relative path name matches.
+// A trailing "/**" also matches the directory itself, so "vendor/**"
+// matches "vendor".
+func (g *Glob) Match(name string) bool {
+	segs := strings.Split(strings.Trim(name, "/"), "/")
+	for _, alt := range g.alts {
+		if matchSegments(alt, segs) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Glob) String() string {
+	return g.pattern
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for len(pat) > 0 && pat[0] == "**" {
+				pat = pat[1:]
+			}
+			if len(pat) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// expandBraces turns "a{b,c}d" into ["abd", "acd"], recursively.
+func expandBraces(p string) ([]string, error) {
+	open := -1
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case '{':
+			open = i
+		case '}':
+			return nil, fmt.Errorf("unmatched '}'")
+		}
+		if open >= 0 {
+			break
+		}
+	}
+	if open < 0 {
+		return []string{p}, nil
+	}
+
+	depth, start := 0, open+1
+	var options []string
+	for i := open; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				options = append(options, p[start:i])
+				start = i + 1
+			}
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			options = append(options, p[start:i])
+			prefix, suffix := p[:open], p[i+1:]
+
+			var out []string
+			for _, o := range options {
+				more, err := expandBraces(prefix + o + suffix)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, more...)
+				if len(out) > maxAlternatives {
+					return nil, fmt.Errorf("more than %d alternatives", maxAlternatives)
+				}
+			}
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("unmatched '{'")
+}
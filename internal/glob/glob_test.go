@@ -0,0 +1,79 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/glob"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		// Plain segments match one level only.
+		{".env", ".env", true},
+		{".env", "app/.env", false},
+		{"*.php", "index.php", true},
+		{"*.php", "app/index.php", false},
+		{"config/*.yaml", "config/app.yaml", true},
+		{"config/*.yaml", "config/packages/app.yaml", false},
+
+		// ** spans any number of directories, including none.
+		{"**/*.php", "index.php", true},
+		{"**/*.php", "app/Http/Controllers/Home.php", true},
+		{"config/**/*.yaml", "config/app.yaml", true},
+		{"config/**/*.yaml", "config/packages/prod/cache.yaml", true},
+		{"config/**/*.yaml", "src/config/app.yaml", false},
+		{"**/vendor/**", "vendor", true},
+		{"**/vendor/**", "vendor/acme/lib.php", true},
+		{"**/vendor/**", "packages/billing/vendor/autoload.php", true},
+		{"**/vendor/**", "app/vendors/x.php", false},
+		{"storage/**", "storage", true},
+		{"storage/**", "storage/logs/laravel.log", true},
+		{"storage/**", "app/storage/x.php", false},
+		{"a/**/**/b", "a/b", true},
+		{"**", "anything/at/all", true},
+
+		// Character classes, ? and braces.
+		{"app/?ser.php", "app/User.php", true},
+		{"[A-Z]*.php", "Kernel.php", true},
+		{"[A-Z]*.php", "kernel.php", false},
+		{"**/*.{yaml,yml}", "config/app.yml", true},
+		{"{config,routes}/**", "routes/web.php", true},
+		{"{config,routes}/**", "app/web.php", false},
+		{"{.env,.env.*}", ".env.local", true},
+		{"src/{a,b/{c,d}}/x", "src/b/d/x", true},
+
+		// Matching is case-sensitive.
+		{"*.PHP", "index.php", false},
+		{"*.php", "INDEX.PHP", false},
+		{"Config/**", "config/app.yaml", false},
+		{"**/Vendor/**", "vendor/autoload.php", false},
+	}
+	for _, tt := range tests {
+		g, err := glob.Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.pattern, err)
+		}
+		if got := g.Match(tt.name); got != tt.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"/etc/*.conf",
+		"config/[a-.yaml",
+		"{a,b",
+		"a,b}",
+		"{a,b}{c,d}{e,f}{g,h}{i,j}{k,l}{m,n}{o,p}{q,r}",
+	} {
+		if err := glob.Validate(pattern); err == nil {
+			t.Errorf("Validate(%q) succeeded", pattern)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+// Package handoff passes listening sockets from a running maboo to a newly
+// exec'd binary, so the binary can be replaced without refusing a single
+// connection.
+//
+// The old process starts the new binary with every listener as an extra
+// file and a JSON state blob in MABOO_UPGRADE. The new process picks the
+// sockets up by name instead of binding, signals readiness over a pipe,
+// and the old process then drains its in-flight requests and exits.
+// Sockets passed in by systemd socket activation are picked up the same
+// way.
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// envState carries the state blob to the new process.
+const envState = "MABOO_UPGRADE"
+
+var (
+	// ErrInProgress is returned while another upgrade is starting or has
+	// already handed over.
+	ErrInProgress = errors.New("upgrade already in progress")
+	// ErrParentDraining is returned by a new process whose parent has not
+	// exited yet.
+	ErrParentDraining = errors.New("previous process is still draining")
+)
+
+// state is the blob passed in MABOO_UPGRADE.
+type state struct {
+	ParentPID int            `json:"parent_pid"`
+	ReadyFD   int            `json:"ready_fd"`
+	Listeners map[string]int `json:"listeners"` // name -> fd
+}
+
+// filer is a listener or packet conn that can be duplicated for the child.
+type filer interface {
+	File() (*os.File, error)
+	Close() error
+}
+
+// Listeners hands out sockets by name, preferring inherited ones, and
+// remembers them for the next upgrade.
+type Listeners struct {
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	active    map[string]filer
+
+	parentPID int
+	ready     *os.File // write end of the parent's readiness pipe
+
+	upgrading atomic.Bool
+}
+
+// New reads inherited sockets from MABOO_UPGRADE or systemd's LISTEN_FDS
+// and clears those variables so PHP workers and later upgrades do not see
+// them.
+func New() (*Listeners, error) {
+	l := &Listeners{inherited: map[string]*os.File{}, active: map[string]filer{}}
+
+	if raw, ok := os.LookupEnv(envState); ok {
+		os.Unsetenv(envState)
+		var st state
+		if err := json.Unmarshal([]byte(raw), &st); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", envState, err)
+		}
+		l.parentPID = st.ParentPID
+		l.ready = inheritFD(st.ReadyFD, "ready")
+		for name, fd := range st.Listeners {
+			l.inherited[name] = inheritFD(fd, name)
+		}
+		return l, nil
+	}
+
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < n; i++ {
+			name := ""
+			if i < len(names) {
+				name = names[i]
+			}
+			if name == "" || name == "unknown" {
+				// Unnamed sockets: the first serves HTTP.
+				name = "http"
+				if i > 0 {
+					name = "fd" + strconv.Itoa(3+i)
+				}
+			}
+			l.inherited[name] = inheritFD(3+i, name)
+		}
+	}
+	for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(k)
+	}
+	return l, nil
+}
+
+// inheritFD wraps an inherited descriptor, marking it close-on-exec so it
+// does not leak into PHP processes.
+func inheritFD(fd int, name string) *os.File {
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), name)
+}
+
+// Upgraded reports whether this process was started by an upgrade.
+func (l *Listeners) Upgraded() bool {
+	return l.parentPID != 0
+}
+
+// ParentPID returns the pid of the process that started this one by an
+// upgrade, or 0.
+func (l *Listeners) ParentPID() int {
+	return l.parentPID
+}
+
+// Listen returns the inherited TCP listener called name, or binds addr.
+// An inherited socket bound to a different address (the config changed
+// across the upgrade) is closed and addr is bound instead.
+func (l *Listeners) Listen(name, addr string) (net.Listener, error) {
+	return l.ListenConfig(&net.ListenConfig{}, name, addr)
+}
+
+// ListenConfig is Listen binding addr with lc, e.g. to set socket options.
+// An inherited socket keeps the options it was bound with.
+func (l *Listeners) ListenConfig(lc *net.ListenConfig, name, addr string) (net.Listener, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.inherited[name]; ok {
+		delete(l.inherited, name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited %s listener: %w", name, err)
+		}
+		if sameAddr(ln.Addr(), addr) {
+			l.active[name] = ln.(filer)
+			return ln, nil
+		}
+		ln.Close()
+	}
+
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l.active[name] = ln.(filer)
+	return ln, nil
+}
+
+// ListenPacket is Listen for UDP sockets (HTTP/3).
+func (l *Listeners) ListenPacket(name, addr string) (net.PacketConn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.inherited[name]; ok {
+		delete(l.inherited, name)
+		conn, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited %s socket: %w", name, err)
+		}
+		if sameAddr(conn.LocalAddr(), addr) {
+			l.active[name] = conn.(filer)
+			return conn, nil
+		}
+		conn.Close()
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l.active[name] = conn.(filer)
+	return conn, nil
+}
+
+// sameAddr reports whether a bound address satisfies a listen address.
+func sameAddr(bound net.Addr, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	bhost, bport, err := net.SplitHostPort(bound.String())
+	if err != nil || bport != port {
+		return false
+	}
+	if host == "" {
+		host = "::"
+	}
+	want, got := net.ParseIP(host), net.ParseIP(bhost)
+	if want == nil || got == nil {
+		return host == bhost
+	}
+	return want.Equal(got) || (want.IsUnspecified() && got.IsUnspecified())
+}
+
+// Ready tells the parent (and systemd) that this process is serving. Any
+// inherited socket not claimed by Listen is closed.
+func (l *Listeners) Ready() error {
+	l.mu.Lock()
+	for name, f := range l.inherited {
+		f.Close()
+		delete(l.inherited, name)
+	}
+	l.mu.Unlock()
+
+	notifySystemd(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()))
+	if l.ready == nil {
+		return nil
+	}
+	_, err := l.ready.Write([]byte{1})
+	l.ready.Close()
+	l.ready = nil
+	return err
+}
+
+// Upgrade starts the binary at the current executable path with the same
+// arguments and every active listener, and waits up to timeout for it to
+// call Ready. It returns the new pid; the caller should then stop
+// accepting, drain and exit. On error the new process has been killed or
+// has exited, and the caller keeps serving.
+//
+// Only one upgrade runs at a time, and a successful one cannot be
+// repeated from the same process.
+func (l *Listeners) Upgrade(timeout time.Duration) (int, error) {
+	// The old process is our parent until it exits; checking it by pid
+	// would also match it as an unreaped zombie.
+	if l.parentPID != 0 && os.Getppid() == l.parentPID {
+		return 0, ErrParentDraining
+	}
+	if !l.upgrading.CompareAndSwap(false, true) {
+		return 0, ErrInProgress
+	}
+	pid, err := l.startChild(timeout)
+	if err != nil {
+		l.upgrading.Store(false)
+	}
+	return pid, err
+}
+
+func (l *Listeners) startChild(timeout time.Duration) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("locating executable: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// Files[i] becomes fd i in the child: stdio, the readiness pipe, then
+	// the listeners.
+	files := []uintptr{0, 1, 2, w.Fd()}
+	st := state{ParentPID: os.Getpid(), ReadyFD: 3, Listeners: map[string]int{}}
+	var dups []int
+	defer func() {
+		for _, fd := range dups {
+			syscall.Close(fd)
+		}
+	}()
+
+	l.mu.Lock()
+	names := make([]string, 0, len(l.active))
+	for name := range l.active {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fd, err := dupSocket(l.active[name])
+		if err != nil {
+			l.mu.Unlock()
+			return 0, fmt.Errorf("duplicating %s listener: %w", name, err)
+		}
+		dups = append(dups, fd)
+		st.Listeners[name] = len(files)
+		files = append(files, uintptr(fd))
+	}
+	l.mu.Unlock()
+
+	blob, err := json.Marshal(st)
+	if err != nil {
+		return 0, err
+	}
+	// syscall.ForkExec rather than os/exec: exec.Cmd calls File.Fd on
+	// extra files, which switches the shared sockets to blocking mode and
+	// leaves our own accept loop stuck in a blocking accept.
+	pid, err := syscall.ForkExec(exe, os.Args, &syscall.ProcAttr{
+		Env:   append(childEnv(), envState+"="+string(blob)),
+		Files: files,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("starting %s: %w", exe, err)
+	}
+	proc, _ := os.FindProcess(pid)
+	// Close our copy of the write end so a child that dies reads as EOF.
+	w.Close()
+
+	readyCh := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, 1)
+		n, _ := r.Read(buf)
+		readyCh <- n == 1
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		ps, err := proc.Wait()
+		if err == nil {
+			err = errors.New(ps.String())
+		}
+		exited <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ready := <-readyCh:
+		if ready {
+			return pid, nil
+		}
+		proc.Kill()
+		return 0, fmt.Errorf("new process exited before it was ready: %v", <-exited)
+	case err := <-exited:
+		return 0, fmt.Errorf("new process exited before it was ready: %v", err)
+	case <-timer.C:
+		proc.Kill()
+		<-exited
+		return 0, fmt.Errorf("new process not ready within %s", timeout)
+	}
+}
+
+// dupSocket duplicates a socket's descriptor without changing its flags.
+// The copy is close-on-exec, so only ForkExec's Files hands it on.
+func dupSocket(sock filer) (int, error) {
+	sc, ok := sock.(syscall.Conn)
+	if !ok {
+		return 0, errors.New("not a socket")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd int
+	var dupErr error
+	if err := raw.Control(func(s uintptr) {
+		r, _, errno := syscall.Syscall(syscall.SYS_FCNTL, s, syscall.F_DUPFD_CLOEXEC, 0)
+		if errno != 0 {
+			dupErr = errno
+		}
+		fd = int(r)
+	}); err != nil {
+		return 0, err
+	}
+	return fd, dupErr
+}
+
+// childEnv is the environment minus any socket-passing variables.
+func childEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		switch key {
+		case envState, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES":
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// notifySystemd sends state to the service manager when running under
+// Type=notify. Reporting MAINPID needs NotifyAccess=all in the unit.
+func notifySystemd(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
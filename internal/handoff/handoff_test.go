@@ -0,0 +1,209 @@
+package handoff_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/handoff"
+)
+
+// The upgrade test execs the test binary as the new process; started that
+// way it serves as the child instead of running the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv("MABOO_UPGRADE") != "" {
+		os.Exit(runChild())
+	}
+	os.Exit(m.Run())
+}
+
+// runChild takes over the "http" listener, answers "child" until SIGTERM.
+// With HANDOFF_TEST_FAIL set it exits before becoming ready.
+func runChild() int {
+	if os.Getenv("HANDOFF_TEST_FAIL") != "" {
+		return 1
+	}
+	sockets, err := handoff.New()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ln, err := sockets.Listen("http", os.Getenv("HANDOFF_TEST_ADDR"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "child")
+	})}
+	go srv.Serve(ln)
+	if err := sockets.Ready(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM)
+	<-quit
+	srv.Shutdown(context.Background())
+	return 0
+}
+
+func TestUpgradeUnderLoad(t *testing.T) {
+	sockets, err := handoff.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sockets.Upgraded() {
+		t.Fatal("Upgraded() = true without an inherited state")
+	}
+	ln, err := sockets.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	t.Setenv("HANDOFF_TEST_ADDR", addr)
+
+	parent := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "parent")
+	})}
+	go parent.Serve(ln)
+
+	// Fresh connections throughout, so every request goes through accept.
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		Timeout:   5 * time.Second,
+	}
+	var (
+		stop     atomic.Bool
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []error
+		served   = map[string]int{}
+	)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				body, err := get(client, "http://"+addr+"/")
+				mu.Lock()
+				if err != nil {
+					failures = append(failures, err)
+				} else {
+					served[body]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	pid, err := sockets.Upgrade(10 * time.Second)
+	if err != nil {
+		stop.Store(true)
+		wg.Wait()
+		t.Fatal(err)
+	}
+	child, _ := os.FindProcess(pid)
+	defer func() {
+		child.Signal(syscall.SIGTERM)
+		child.Wait()
+	}()
+
+	if _, err := sockets.Upgrade(time.Second); !errors.Is(err, handoff.ErrInProgress) {
+		t.Errorf("second Upgrade = %v, want ErrInProgress", err)
+	}
+
+	// Drain as maboo does once the new process is ready: stop accepting,
+	// let accepted connections send their request, then shut down.
+	// Shutdown alone would hang up on connections it has not read from.
+	ln.Close()
+	time.Sleep(100 * time.Millisecond)
+	if err := parent.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	stop.Store(true)
+	wg.Wait()
+
+	if len(failures) > 0 {
+		t.Errorf("%d requests failed during the upgrade, first: %v", len(failures), failures[0])
+	}
+	if served["parent"] == 0 || served["child"] == 0 {
+		t.Errorf("served = %v, want requests answered by both processes", served)
+	}
+}
+
+func TestFailedUpgradeKeepsServing(t *testing.T) {
+	sockets, err := handoff.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := sockets.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	t.Setenv("HANDOFF_TEST_ADDR", ln.Addr().String())
+	t.Setenv("HANDOFF_TEST_FAIL", "1")
+
+	if _, err := sockets.Upgrade(10 * time.Second); err == nil {
+		t.Fatal("Upgrade succeeded with a child that exits")
+	}
+	// The listener still accepts, and the failed attempt does not block
+	// the next one.
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("listener closed after a failed upgrade: %v", err)
+	}
+	conn.Close()
+	if _, err := sockets.Upgrade(10 * time.Second); errors.Is(err, handoff.ErrInProgress) {
+		t.Error("retry refused with ErrInProgress after a failed upgrade")
+	}
+}
+
+func get(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestListenRebindsOnAddressChange(t *testing.T) {
+	sockets, err := handoff.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := sockets.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Without inherited sockets a second name binds its own address.
+	other, err := sockets.Listen("admin", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if other.Addr().String() == ln.Addr().String() {
+		t.Errorf("admin listener reused %s", ln.Addr())
+	}
+	if _, err := net.Dial("tcp", other.Addr().String()); err != nil {
+		t.Errorf("dialing admin listener: %v", err)
+	}
+}
@@ -0,0 +1,433 @@
+// Package jobs keeps long-running PHP commands, such as Laravel's
+// "php artisan queue:work", running inside maboo serve. It restarts a
+// process that exits or outgrows its memory limit, and stops processes
+// gracefully on reload and shutdown, replacing a supervisord next to
+// maboo.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// Process states, as reported in Status.
+const (
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateBackoff  = "backoff" // waiting to restart after an exit
+	StateStopping = "stopping"
+	StateStopped  = "stopped"
+	StateFailed   = "failed" // cannot run as configured; not restarted
+)
+
+// Restart reasons, the reason label of maboo_job_restarts_total.
+const (
+	RestartExit   = "exit"   // the process exited or failed to start
+	RestartMemory = "memory" // its resident memory passed max_memory
+	RestartReload = "reload" // Restart was called, on SIGUSR1 or a worker reload
+)
+
+const (
+	defaultRestartDelay    = time.Second
+	defaultMaxRestartDelay = time.Minute
+	defaultStopGrace       = 10 * time.Second
+
+	// processWaitDelay bounds how long a killed php process may keep its
+	// output pipes open through children it started.
+	processWaitDelay = 5 * time.Second
+
+	// maxLine caps a line of job output; the rest of a longer one is
+	// dropped.
+	maxLine = 64 << 10
+)
+
+var (
+	// stableRun is how long a process must run for its exit to count as a
+	// fresh failure: the restart delay starts over from restart_delay
+	// instead of doubling.
+	stableRun = 10 * time.Second
+
+	// memoryCheckInterval is how often a process is checked against
+	// max_memory.
+	memoryCheckInterval = 5 * time.Second
+)
+
+var (
+	processesGauge = metrics.NewGaugeVec("maboo_job_processes",
+		"Processes of a job currently running.", "job")
+	restartsCounter = metrics.NewCounterVec("maboo_job_restarts_total",
+		"Processes of a job restarted, by reason: exit, memory or reload.", "job", "reason")
+	lastExitGauge = metrics.NewGaugeVec("maboo_job_last_exit_code",
+		"Exit code of the most recent process of a job to end.", "job")
+)
+
+var stopSignals = map[string]syscall.Signal{
+	"TERM": syscall.SIGTERM,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"HUP":  syscall.SIGHUP,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// Status is the state of one job, as the admin API and /health report it.
+type Status struct {
+	Name      string          `json:"name"`
+	Count     int             `json:"count"`
+	Running   int             `json:"running"`
+	Restarts  int             `json:"restarts"`
+	Processes []ProcessStatus `json:"processes"`
+}
+
+// ProcessStatus is the state of one of a job's processes.
+type ProcessStatus struct {
+	State        string     `json:"state"`
+	PID          int        `json:"pid,omitempty"` // 0 in the embedded engine
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	Restarts     int        `json:"restarts"`
+	LastExitCode *int       `json:"last_exit_code,omitempty"`
+	LastExitAt   *time.Time `json:"last_exit_at,omitempty"`
+}
+
+// Manager supervises the configured jobs. Each process a job asks for has
+// its own goroutine that starts it, watches it and restarts it.
+type Manager struct {
+	jobs   []*job
+	run    Runner
+	logger *slog.Logger
+
+	mu      sync.Mutex      // orders Start against Stop
+	ctx     context.Context // cancelled by Stop; stops the processes
+	cancel  context.CancelFunc
+	kill    context.Context // cancelled by Stop when its deadline passes
+	killAll context.CancelFunc
+	started bool
+	wg      sync.WaitGroup
+}
+
+type job struct {
+	cfg    config.JobConfig // with the defaults filled in
+	signal syscall.Signal
+	procs  []*instance
+}
+
+// instance is one of a job's processes, across restarts.
+type instance struct {
+	job     *job
+	restart chan struct{} // asks for a fresh process
+
+	mu         sync.Mutex
+	state      string
+	pid        int
+	startedAt  time.Time
+	restarts   int
+	lastExit   *int
+	lastExitAt time.Time
+}
+
+// New prepares a manager for jobs. Processes are started through run.
+func New(jobs []config.JobConfig, run Runner, logger *slog.Logger) (*Manager, error) {
+	m := &Manager{run: run, logger: logger}
+	for _, c := range jobs {
+		sig, ok := stopSignals[strings.TrimPrefix(strings.ToUpper(c.StopSignal), "SIG")]
+		if c.StopSignal == "" {
+			sig, ok = syscall.SIGTERM, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("job %s: unknown stop_signal %q", c.Name, c.StopSignal)
+		}
+		if c.Count == 0 {
+			c.Count = 1
+		}
+		if c.RestartDelay == 0 {
+			c.RestartDelay = config.Duration(defaultRestartDelay)
+		}
+		if c.MaxRestartDelay == 0 {
+			c.MaxRestartDelay = config.Duration(defaultMaxRestartDelay)
+		}
+		if c.StopGrace == 0 {
+			c.StopGrace = config.Duration(defaultStopGrace)
+		}
+
+		j := &job{cfg: c, signal: sig}
+		for range c.Count {
+			j.procs = append(j.procs, &instance{job: j, state: StateStopped, restart: make(chan struct{}, 1)})
+		}
+		processesGauge.WithLabelValues(c.Name)
+		lastExitGauge.WithLabelValues(c.Name)
+		for _, reason := range []string{RestartExit, RestartMemory, RestartReload} {
+			restartsCounter.WithLabelValues(c.Name, reason)
+		}
+		m.jobs = append(m.jobs, j)
+	}
+
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.kill, m.killAll = context.WithCancel(context.Background())
+	return m, nil
+}
+
+// Start starts every job's processes. It does nothing once Stop has been
+// called, or on a second call.
+func (m *Manager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil || m.started {
+		return
+	}
+	m.started = true
+
+	processes := 0
+	for _, j := range m.jobs {
+		for n, in := range j.procs {
+			m.wg.Add(1)
+			go m.supervise(in, n)
+		}
+		processes += len(j.procs)
+	}
+	if len(m.jobs) > 0 {
+		m.logger.Info("jobs started", "jobs", len(m.jobs), "processes", processes)
+	}
+}
+
+// Restart replaces every process with a fresh one, each stopped with its
+// job's stop_signal and stop_grace first. It returns without waiting. A
+// process waiting out its restart delay starts at once.
+func (m *Manager) Restart() {
+	m.logger.Info("restarting jobs", "jobs", len(m.jobs))
+	for _, j := range m.jobs {
+		for _, in := range j.procs {
+			select {
+			case in.restart <- struct{}{}:
+			default: // a restart is already pending
+			}
+		}
+	}
+}
+
+// Stop stops every process with its job's stop_signal and waits for them
+// to exit, each for at most its stop_grace. Processes still running when
+// ctx ends are killed.
+func (m *Manager) Stop(ctx context.Context) {
+	m.mu.Lock()
+	m.cancel()
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		m.logger.Warn("killing jobs still running at shutdown")
+		m.killAll()
+		<-done
+	}
+}
+
+// Statuses reports every job, in config order.
+func (m *Manager) Statuses() []Status {
+	out := make([]Status, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		st := Status{Name: j.cfg.Name, Count: j.cfg.Count, Processes: make([]ProcessStatus, 0, len(j.procs))}
+		for _, in := range j.procs {
+			ps := in.status()
+			if ps.State == StateRunning {
+				st.Running++
+			}
+			st.Restarts += ps.Restarts
+			st.Processes = append(st.Processes, ps)
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// exit is how a process ended.
+type exit struct {
+	code int
+	err  error
+}
+
+// supervise keeps process n of a job running until Stop.
+func (m *Manager) supervise(in *instance, n int) {
+	defer m.wg.Done()
+	cfg := in.job.cfg
+	delay := cfg.RestartDelay.Duration()
+	for {
+		reason := RestartExit
+		in.setState(StateStarting)
+		p, err := m.run.Start(cfg)
+		if errors.Is(err, phpengine.ErrCLIUnavailable) {
+			// No restart would fare better; only a config change helps.
+			m.logger.Error("job cannot run", "job", cfg.Name, "process", n, "error", err)
+			in.setState(StateFailed)
+			return
+		}
+		if err != nil {
+			m.logger.Error("job failed to start", "job", cfg.Name, "process", n, "error", err)
+		} else {
+			start := time.Now()
+			in.started(p.PID(), start)
+			processesGauge.WithLabelValues(cfg.Name).Inc()
+			m.logger.Info("job started", "job", cfg.Name, "process", n, "pid", p.PID())
+
+			var e exit
+			reason, e = m.watch(in, p)
+			processesGauge.WithLabelValues(cfg.Name).Dec()
+			in.exited(e.code)
+			lastExitGauge.WithLabelValues(cfg.Name).Set(float64(e.code))
+
+			attrs := []any{"job", cfg.Name, "process", n, "exit_code", e.code, "ran", time.Since(start).Round(time.Millisecond)}
+			if e.err != nil {
+				attrs = append(attrs, "error", e.err)
+			}
+			if errors.Is(e.err, phpengine.ErrCLIUnavailable) {
+				m.logger.Error("job cannot run", attrs...)
+				in.setState(StateFailed)
+				return
+			}
+			switch reason {
+			case "":
+				m.logger.Info("job stopped", attrs...)
+				in.setState(StateStopped)
+				return
+			case RestartExit:
+				m.logger.Warn("job exited", attrs...)
+				if time.Since(start) >= stableRun {
+					delay = cfg.RestartDelay.Duration()
+				}
+			default:
+				m.logger.Info("job stopped for restart", append(attrs, "reason", reason)...)
+				delay = cfg.RestartDelay.Duration()
+			}
+		}
+
+		if reason == RestartExit {
+			in.setState(StateBackoff)
+			t := time.NewTimer(delay)
+			select {
+			case <-t.C:
+			case <-in.restart:
+				t.Stop()
+			case <-m.ctx.Done():
+				t.Stop()
+				in.setState(StateStopped)
+				return
+			}
+			delay = min(2*delay, cfg.MaxRestartDelay.Duration())
+		}
+		in.restarted()
+		restartsCounter.WithLabelValues(cfg.Name, reason).Inc()
+	}
+}
+
+// watch waits for p to exit, or stops it for a restart or Stop. It returns
+// the restart reason, or "" after Stop.
+func (m *Manager) watch(in *instance, p Process) (string, exit) {
+	cfg := in.job.cfg
+	exited := make(chan exit, 1)
+	go func() {
+		code, err := p.Wait()
+		exited <- exit{code, err}
+	}()
+
+	var memory <-chan time.Time
+	if cfg.MaxMemory > 0 {
+		t := time.NewTicker(memoryCheckInterval)
+		defer t.Stop()
+		memory = t.C
+	}
+	for {
+		select {
+		case e := <-exited:
+			return RestartExit, e
+		case <-memory:
+			if rss, ok := p.Memory(); ok && rss > cfg.MaxMemory.Bytes() {
+				m.logger.Warn("job passed max_memory, restarting it", "job", cfg.Name, "pid", p.PID(),
+					"memory", config.Size(rss), "max_memory", cfg.MaxMemory)
+				return RestartMemory, m.stop(in, p, exited)
+			}
+		case <-in.restart:
+			return RestartReload, m.stop(in, p, exited)
+		case <-m.ctx.Done():
+			return "", m.stop(in, p, exited)
+		}
+	}
+}
+
+// stop sends p its job's stop signal and waits stop_grace for it to exit
+// before killing it.
+func (m *Manager) stop(in *instance, p Process, exited <-chan exit) exit {
+	cfg := in.job.cfg
+	in.setState(StateStopping)
+	if err := p.Signal(in.job.signal); err != nil {
+		p.Kill()
+	}
+	t := time.NewTimer(cfg.StopGrace.Duration())
+	defer t.Stop()
+	select {
+	case e := <-exited:
+		return e
+	case <-t.C:
+		m.logger.Warn("job did not stop within stop_grace, killing it", "job", cfg.Name, "pid", p.PID(),
+			"stop_grace", cfg.StopGrace.Duration())
+	case <-m.kill.Done():
+	}
+	p.Kill()
+	return <-exited
+}
+
+func (in *instance) setState(state string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.state = state
+	if state != StateRunning && state != StateStopping {
+		in.pid = 0
+	}
+}
+
+func (in *instance) started(pid int, at time.Time) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.state, in.pid, in.startedAt = StateRunning, pid, at
+}
+
+func (in *instance) exited(code int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.lastExit, in.lastExitAt = &code, time.Now()
+	in.pid = 0
+}
+
+func (in *instance) restarted() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.restarts++
+}
+
+func (in *instance) status() ProcessStatus {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	ps := ProcessStatus{State: in.state, PID: in.pid, Restarts: in.restarts, LastExitCode: in.lastExit}
+	if in.state == StateRunning || in.state == StateStopping {
+		at := in.startedAt
+		ps.StartedAt = &at
+	}
+	if in.lastExit != nil {
+		at := in.lastExitAt
+		ps.LastExitAt = &at
+	}
+	return ps
+}
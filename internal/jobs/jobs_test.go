@@ -0,0 +1,356 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// fakeProcess runs until it gets a signal, is killed or exits by itself.
+type fakeProcess struct {
+	pid     int
+	ignore  bool // ignore signals, so only Kill ends it
+	memory  int64
+	done    chan exit
+	once    sync.Once
+	signals atomic.Int32
+}
+
+func (p *fakeProcess) PID() int { return p.pid }
+
+func (p *fakeProcess) Signal(syscall.Signal) error {
+	p.signals.Add(1)
+	if !p.ignore {
+		p.end(exit{code: 0})
+	}
+	return nil
+}
+
+func (p *fakeProcess) Kill()                 { p.end(exit{code: -1}) }
+func (p *fakeProcess) Memory() (int64, bool) { return p.memory, p.memory > 0 }
+
+func (p *fakeProcess) Wait() (int, error) {
+	e := <-p.done
+	return e.code, e.err
+}
+
+func (p *fakeProcess) end(e exit) {
+	p.once.Do(func() { p.done <- e })
+}
+
+// fakeRunner hands out fakeProcesses made by spawn and records them.
+type fakeRunner struct {
+	spawn   func(n int) *fakeProcess
+	started chan *fakeProcess
+
+	mu    sync.Mutex
+	procs []*fakeProcess
+}
+
+func newFakeRunner(spawn func(n int) *fakeProcess) *fakeRunner {
+	return &fakeRunner{spawn: spawn, started: make(chan *fakeProcess, 100)}
+}
+
+func (r *fakeRunner) Start(config.JobConfig) (Process, error) {
+	r.mu.Lock()
+	p := r.spawn(len(r.procs) + 1)
+	p.done = make(chan exit, 1)
+	if p.pid == 0 {
+		p.pid = 1000 + len(r.procs)
+	}
+	r.procs = append(r.procs, p)
+	r.mu.Unlock()
+	r.started <- p
+	return p, nil
+}
+
+func (r *fakeRunner) next(t *testing.T) *fakeProcess {
+	t.Helper()
+	select {
+	case p := <-r.started:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("no process started")
+		return nil
+	}
+}
+
+func newTestManager(t *testing.T, jobs []config.JobConfig, run Runner) (*Manager, *logBuffer) {
+	t.Helper()
+	logs := &logBuffer{}
+	m, err := New(jobs, run, slog.New(slog.NewTextHandler(logs, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, logs
+}
+
+// logBuffer collects log output written from several goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor polls cond until it holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManagerRestartsExitedProcess(t *testing.T) {
+	run := newFakeRunner(func(int) *fakeProcess { return &fakeProcess{} })
+	m, logs := newTestManager(t, []config.JobConfig{
+		{Name: "queue", Script: "artisan", Count: 2, RestartDelay: config.Duration(time.Millisecond)},
+	}, run)
+	m.Start()
+	defer m.Stop(context.Background())
+
+	run.next(t)
+	p := run.next(t)
+	p.end(exit{code: 3})
+	run.next(t)
+
+	waitFor(t, "the restart to be reported", func() bool {
+		st := m.Statuses()[0]
+		return st.Running == 2 && st.Restarts == 1
+	})
+	st := m.Statuses()[0]
+	var exited *ProcessStatus
+	for i := range st.Processes {
+		if st.Processes[i].LastExitCode != nil {
+			exited = &st.Processes[i]
+		}
+	}
+	if exited == nil || *exited.LastExitCode != 3 || exited.Restarts != 1 || exited.PID == 0 {
+		t.Errorf("processes = %+v, want one restarted after exit code 3", st.Processes)
+	}
+	if !strings.Contains(logs.String(), "job exited") {
+		t.Errorf("exit not logged: %s", logs)
+	}
+}
+
+func TestManagerBacksOff(t *testing.T) {
+	run := newFakeRunner(func(int) *fakeProcess { return &fakeProcess{} })
+	m, _ := newTestManager(t, []config.JobConfig{
+		{Name: "broken", Script: "broken.php", RestartDelay: config.Duration(20 * time.Millisecond), MaxRestartDelay: config.Duration(50 * time.Millisecond)},
+	}, run)
+	m.Start()
+	defer m.Stop(context.Background())
+
+	// Exits right after starting wait 20ms, 40ms, then 50ms each.
+	var gaps []time.Duration
+	last := time.Now()
+	for i := range 4 {
+		p := run.next(t)
+		if i > 0 {
+			gaps = append(gaps, time.Since(last))
+		}
+		last = time.Now()
+		p.end(exit{code: 255})
+	}
+	for i, min := range []time.Duration{20, 40, 50} {
+		if gaps[i] < min*time.Millisecond {
+			t.Errorf("restart %d after %s, want at least %dms", i+1, gaps[i], min)
+		}
+	}
+	if st := m.Statuses()[0].Processes[0]; st.State != StateBackoff && st.State != StateRunning {
+		t.Errorf("state %q", st.State)
+	}
+}
+
+func TestManagerRestart(t *testing.T) {
+	run := newFakeRunner(func(n int) *fakeProcess { return &fakeProcess{ignore: n == 1} })
+	m, logs := newTestManager(t, []config.JobConfig{
+		{Name: "queue", Script: "artisan", StopSignal: "QUIT", StopGrace: config.Duration(30 * time.Millisecond)},
+	}, run)
+	m.Start()
+	defer m.Stop(context.Background())
+
+	stubborn := run.next(t)
+	m.Restart()
+	fresh := run.next(t)
+	if stubborn.signals.Load() != 1 {
+		t.Errorf("old process got %d signals, want 1", stubborn.signals.Load())
+	}
+	if !strings.Contains(logs.String(), "killing it") {
+		t.Errorf("kill after stop_grace not logged: %s", logs)
+	}
+
+	m.Restart()
+	run.next(t)
+	if fresh.signals.Load() != 1 {
+		t.Errorf("fresh process got %d signals, want 1", fresh.signals.Load())
+	}
+	waitFor(t, "restarts", func() bool { return m.Statuses()[0].Restarts == 2 })
+}
+
+func TestManagerRestartsOnMemory(t *testing.T) {
+	memoryCheckInterval = 5 * time.Millisecond
+	t.Cleanup(func() { memoryCheckInterval = 5 * time.Second })
+
+	run := newFakeRunner(func(n int) *fakeProcess {
+		if n == 1 {
+			return &fakeProcess{memory: 300 << 20}
+		}
+		return &fakeProcess{memory: 10 << 20}
+	})
+	m, logs := newTestManager(t, []config.JobConfig{
+		{Name: "queue", Script: "artisan", MaxMemory: 256 * config.MiB},
+	}, run)
+	m.Start()
+	defer m.Stop(context.Background())
+
+	first := run.next(t)
+	run.next(t)
+	if first.signals.Load() != 1 {
+		t.Errorf("process over max_memory got %d signals, want 1", first.signals.Load())
+	}
+	if !strings.Contains(logs.String(), "passed max_memory") {
+		t.Errorf("memory restart not logged: %s", logs)
+	}
+}
+
+func TestManagerStop(t *testing.T) {
+	run := newFakeRunner(func(int) *fakeProcess { return &fakeProcess{ignore: true} })
+	m, logs := newTestManager(t, []config.JobConfig{
+		{Name: "queue", Script: "artisan", Count: 2, StopGrace: config.Duration(time.Hour)},
+	}, run)
+	m.Start()
+	run.next(t)
+	run.next(t)
+
+	// Neither process stops on its signal; the deadline kills both.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	m.Stop(ctx)
+	for _, p := range m.Statuses()[0].Processes {
+		if p.State != StateStopped || p.LastExitCode == nil || *p.LastExitCode != -1 {
+			t.Errorf("process after Stop = %+v, want stopped by a kill", p)
+		}
+	}
+	if !strings.Contains(logs.String(), "killing jobs still running at shutdown") {
+		t.Errorf("kill at shutdown not logged: %s", logs)
+	}
+
+	m.Start() // after Stop: no new processes
+	select {
+	case <-run.started:
+		t.Error("Start after Stop started a process")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// A job the embedded engine cannot run fails once instead of restarting.
+func TestManagerEngineUnavailable(t *testing.T) {
+	if phpengine.EmbedCompiled {
+		t.Skip("the engine may run the script")
+	}
+	cfg := config.Default()
+	cfg.App.Root = t.TempDir()
+	logs := &logBuffer{}
+	m, err := New([]config.JobConfig{
+		{Name: "queue", Script: "artisan", Count: 1, RestartDelay: config.Duration(time.Millisecond)},
+	}, NewRunner(cfg, slog.New(slog.NewTextHandler(io.Discard, nil))), slog.New(slog.NewTextHandler(logs, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	defer m.Stop(context.Background())
+
+	waitFor(t, "the job to fail", func() bool { return m.Statuses()[0].Processes[0].State == StateFailed })
+	time.Sleep(20 * time.Millisecond) // long enough for restarts to show
+	if st := m.Statuses()[0]; st.Restarts != 0 || st.Processes[0].State != StateFailed {
+		t.Errorf("status = %+v, want failed with no restarts", st)
+	}
+	if !strings.Contains(logs.String(), "job cannot run") || !strings.Contains(logs.String(), "php.binary") {
+		t.Errorf("failure not logged: %s", logs)
+	}
+}
+
+func TestNewRejectsUnknownSignal(t *testing.T) {
+	_, err := New([]config.JobConfig{{Name: "queue", Script: "artisan", StopSignal: "KILL"}}, nil, slog.Default())
+	if err == nil {
+		t.Error("expected an error for stop_signal KILL")
+	}
+}
+
+// TestRunnerProcess runs a job through a shell script standing in for
+// php.binary.
+func TestRunnerProcess(t *testing.T) {
+	dir := t.TempDir()
+	php := filepath.Join(dir, "php")
+	script := "#!/bin/sh\n" +
+		"echo \"started $*\"\n" +
+		"trap 'echo \"stopping on QUIT\"; exit 7' QUIT\n" +
+		"while :; do sleep 0.01; done\n"
+	if err := os.WriteFile(php, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.App.Root = dir
+	cfg.PHP.Binary = php
+	cfg.PHP.INI = map[string]string{"memory_limit": "64M"}
+
+	logs := &logBuffer{}
+	run := NewRunner(cfg, slog.New(slog.NewTextHandler(logs, nil)))
+	p, err := run.Start(config.JobConfig{Name: "queue", Script: "artisan", Args: []string{"queue:work", "--sleep=3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PID() == 0 {
+		t.Error("no pid")
+	}
+	if rss, ok := p.Memory(); !ok || rss <= 0 {
+		t.Logf("resident memory not known here: %d, %v", rss, ok)
+	}
+	waitFor(t, "the job's output", func() bool {
+		return strings.Contains(logs.String(), "started -d memory_limit=64M "+filepath.Join(dir, "artisan")+" queue:work --sleep=3")
+	})
+
+	if err := p.Signal(syscall.SIGQUIT); err != nil {
+		t.Fatal(err)
+	}
+	code, err := p.Wait()
+	if code != 7 || err != nil {
+		t.Errorf("Wait = %d, %v; want exit code 7", code, err)
+	}
+	if !strings.Contains(logs.String(), "stopping on QUIT") || !strings.Contains(logs.String(), "job=queue") {
+		t.Errorf("output not logged for the job: %s", logs)
+	}
+
+	cfg.PHP.Binary = filepath.Join(dir, "missing")
+	if _, err := run.Start(config.JobConfig{Name: "queue", Script: "artisan"}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing binary: %v", err)
+	}
+}
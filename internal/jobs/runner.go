@@ -0,0 +1,192 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// Process is one running instance of a job.
+type Process interface {
+	// PID is the operating system's id for the process; 0 when it runs
+	// inside maboo.
+	PID() int
+	// Signal asks the process to finish.
+	Signal(sig syscall.Signal) error
+	// Kill ends the process at once.
+	Kill()
+	// Wait waits for the process to end and returns its exit code. A
+	// non-nil error means it did not run to completion.
+	Wait() (int, error)
+	// Memory returns the process's resident memory in bytes, when known.
+	Memory() (int64, bool)
+}
+
+// Runner starts processes for jobs.
+type Runner interface {
+	Start(job config.JobConfig) (Process, error)
+}
+
+// NewRunner returns the Runner maboo serve uses: jobs run through
+// php.binary when one is configured and the embedded engine otherwise.
+// Their output goes to logger, one line per record.
+func NewRunner(cfg *config.Config, logger *slog.Logger) Runner {
+	return &runner{cfg: cfg, logger: logger}
+}
+
+type runner struct {
+	cfg    *config.Config
+	logger *slog.Logger
+}
+
+func (r *runner) Start(job config.JobConfig) (Process, error) {
+	script := job.Script
+	if !filepath.IsAbs(script) {
+		script = filepath.Join(r.cfg.App.Root, script)
+	}
+	if r.cfg.PHP.Binary != "" {
+		return r.startProcess(job, script)
+	}
+	return r.startEngine(job, script)
+}
+
+// startProcess runs the script as a child php process.
+func (r *runner) startProcess(job config.JobConfig, script string) (Process, error) {
+	args := make([]string, 0, 2*len(r.cfg.PHP.INI)+1+len(job.Args))
+	for _, k := range slices.Sorted(maps.Keys(r.cfg.PHP.INI)) {
+		args = append(args, "-d", k+"="+r.cfg.PHP.INI[k])
+	}
+	args = append(args, script)
+	args = append(args, job.Args...)
+
+	cmd := exec.Command(r.cfg.PHP.Binary, args...)
+	cmd.Dir = r.cfg.App.Root
+	cmd.Env = os.Environ()
+	for k, v := range r.cfg.App.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	out := r.output(job.Name)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = processWaitDelay
+	if err := cmd.Start(); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return &process{cmd: cmd, out: out}, nil
+}
+
+// startEngine runs the script in-process with the embedded engine. It has
+// no process to signal: stopping it cancels the run. Without the libphp
+// bindings it fails with phpengine.ErrCLIUnavailable, rather than start a
+// run that ends at once.
+func (r *runner) startEngine(job config.JobConfig, script string) (Process, error) {
+	if !phpengine.EmbedCompiled {
+		return nil, fmt.Errorf("%w; set php.binary", phpengine.ErrCLIUnavailable)
+	}
+	engine, err := phpengine.NewEngine(phpengine.SelectVersion(r.cfg.App.Root, r.cfg.PHP.Version))
+	if err != nil {
+		return nil, fmt.Errorf("creating PHP engine: %w", err)
+	}
+	if err := engine.Startup(); err != nil {
+		return nil, fmt.Errorf("starting PHP engine: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &engineRun{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		defer engine.Shutdown()
+		res, err := engine.ExecuteCLI(ctx, script, job.Args, r.cfg.App.Env)
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.code = res.ExitCode
+		out := r.output(job.Name)
+		out.Write(res.Output)
+		out.Close()
+	}()
+	return p, nil
+}
+
+// output returns a writer logging each line written to it as output of
+// the job name.
+func (r *runner) output(name string) io.WriteCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		sc := bufio.NewScanner(pr)
+		sc.Buffer(make([]byte, 0, 4096), maxLine)
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" {
+				r.logger.Info("job output", "job", name, "line", line)
+			}
+		}
+		// Drain a line too long to scan, so the job does not block.
+		io.Copy(io.Discard, pr)
+	}()
+	return pw
+}
+
+// process is a job running as a child php process.
+type process struct {
+	cmd *exec.Cmd
+	out io.Closer
+}
+
+func (p *process) PID() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *process) Signal(sig syscall.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+func (p *process) Kill() {
+	p.cmd.Process.Kill()
+}
+
+func (p *process) Wait() (int, error) {
+	err := p.cmd.Wait()
+	p.out.Close()
+	var exit *exec.ExitError
+	if err == nil || errors.As(err, &exit) {
+		return p.cmd.ProcessState.ExitCode(), nil
+	}
+	return p.cmd.ProcessState.ExitCode(), err
+}
+
+func (p *process) Memory() (int64, bool) {
+	return residentMemory(p.cmd.Process.Pid)
+}
+
+// engineRun is a job running in the embedded engine.
+type engineRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	code   int
+	err    error
+}
+
+func (p *engineRun) PID() int                    { return 0 }
+func (p *engineRun) Signal(syscall.Signal) error { p.cancel(); return nil }
+func (p *engineRun) Kill()                       { p.cancel() }
+func (p *engineRun) Memory() (int64, bool)       { return 0, false }
+
+func (p *engineRun) Wait() (int, error) {
+	<-p.done
+	return p.code, p.err
+}
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// ANSI colors for console levels.
+const (
+	colorReset  = "\x1b[0m"
+	colorGray   = "\x1b[90m"
+	colorCyan   = "\x1b[36m"
+	colorYellow = "\x1b[33m"
+	colorRed    = "\x1b[31m"
+)
+
+// consoleHandler writes one colored, human-readable line per record for
+// development: "15:04:05.000 INF message key=value".
+type consoleHandler struct {
+	out   io.Writer
+	opts  *slog.HandlerOptions
+	attrs string // pre-rendered WithAttrs output
+	group string // dotted prefix from WithGroup
+}
+
+func newConsoleHandler(out io.Writer, opts *slog.HandlerOptions) *consoleHandler {
+	return &consoleHandler{out: out, opts: opts}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(colorGray)
+	b.WriteString(r.Time.Format("15:04:05.000"))
+	b.WriteString(colorReset)
+	b.WriteByte(' ')
+	b.WriteString(levelLabel(r.Level))
+	b.WriteByte(' ')
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.group, a)
+		return true
+	})
+	b.WriteByte('\n')
+	// One write per record keeps lines whole on a shared Output.
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		appendAttr(&b, h.group, a)
+	}
+	next := *h
+	next.attrs = b.String()
+	return &next
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.group = h.group + name + "."
+	return &next
+}
+
+func levelLabel(l slog.Level) string {
+	switch {
+	case l >= slog.LevelError:
+		return colorRed + "ERR" + colorReset
+	case l >= slog.LevelWarn:
+		return colorYellow + "WRN" + colorReset
+	case l >= slog.LevelInfo:
+		return colorCyan + "INF" + colorReset
+	default:
+		return colorGray + "DBG" + colorReset
+	}
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, prefix, ga)
+		}
+		return
+	}
+	b.WriteByte(' ')
+	b.WriteString(colorGray)
+	b.WriteString(prefix + a.Key)
+	b.WriteString("=")
+	b.WriteString(colorReset)
+	s := a.Value.String()
+	if a.Value.Kind() == slog.KindString && (s == "" || strings.ContainsAny(s, " \"=\n")) {
+		s = strconv.Quote(s)
+	}
+	b.WriteString(s)
+}
@@ -0,0 +1,129 @@
+// Package logging builds maboo's process logger. The level and format can
+// be changed at runtime (config reload) without replacing the *slog.Logger
+// values already handed to the server and worker pool.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Logger owns the root slog handler.
+type Logger struct {
+	out    io.Writer
+	format string
+	level  slog.LevelVar
+	root   atomic.Pointer[slog.Handler]
+}
+
+// New creates a logger writing to out with the given level (debug, info,
+// warn, error) and format (json, text, or console for colored development
+// output).
+func New(out io.Writer, level, format string) *Logger {
+	l := &Logger{out: out}
+	l.Configure(level, format)
+	return l
+}
+
+// Configure changes the level and format. Records already in flight finish
+// with the previous handler.
+func (l *Logger) Configure(level, format string) {
+	l.level.Set(ParseLevel(level))
+	l.format = format
+	l.rebuild()
+}
+
+// SetOutput redirects records to out, keeping the level and format. It is
+// meant for startup, once the config naming the output has been read.
+func (l *Logger) SetOutput(out io.Writer) {
+	l.out = out
+	l.rebuild()
+}
+
+func (l *Logger) rebuild() {
+	opts := &slog.HandlerOptions{Level: &l.level}
+	var h slog.Handler
+	switch l.format {
+	case "text":
+		h = slog.NewTextHandler(l.out, opts)
+	case "console":
+		h = newConsoleHandler(l.out, opts)
+	default:
+		h = slog.NewJSONHandler(l.out, opts)
+	}
+	l.root.Store(&h)
+}
+
+// Slog returns a *slog.Logger that follows later Configure calls.
+func (l *Logger) Slog() *slog.Logger {
+	return slog.New(&handler{l: l})
+}
+
+// ParseLevel maps a config level name to a slog level, defaulting to info.
+func ParseLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// handler forwards to the Logger's current root handler. Attributes and
+// groups added with WithAttrs/WithGroup are replayed onto a new root the
+// first time it is used, then cached.
+type handler struct {
+	l   *Logger
+	ops []func(slog.Handler) slog.Handler
+
+	cache atomic.Pointer[derived]
+}
+
+type derived struct {
+	root *slog.Handler
+	h    slog.Handler
+}
+
+func (h *handler) current() slog.Handler {
+	root := h.l.root.Load()
+	if len(h.ops) == 0 {
+		return *root
+	}
+	if d := h.cache.Load(); d != nil && d.root == root {
+		return d.h
+	}
+	out := *root
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	h.cache.Store(&derived{root: root, h: out})
+	return out
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.l.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.current().Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{l: h.l, ops: append(ops, op)}
+}
@@ -0,0 +1,48 @@
+package logging_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/logging"
+)
+
+func TestConfigureAtRuntime(t *testing.T) {
+	var buf bytes.Buffer
+	l := logging.New(&buf, "info", "json")
+	logger := l.Slog().With("component", "pool")
+
+	logger.Debug("hidden")
+	logger.Info("first")
+	if strings.Contains(buf.String(), "hidden") {
+		t.Error("debug record logged at info level")
+	}
+	if !strings.Contains(buf.String(), `"component":"pool"`) {
+		t.Errorf("expected JSON with attrs, got %q", buf.String())
+	}
+
+	buf.Reset()
+	l.Configure("debug", "text")
+	logger.Debug("now visible")
+	out := buf.String()
+	if !strings.Contains(out, "msg=\"now visible\"") || !strings.Contains(out, "component=pool") {
+		t.Errorf("expected text record with attrs after reconfigure, got %q", out)
+	}
+}
+
+func TestConsoleFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := logging.New(&buf, "debug", "console")
+	l.Slog().With("component", "pool").WithGroup("req").Debug("worker ready", "id", 3, "path", "/a b")
+
+	out := buf.String()
+	for _, want := range []string{"DBG", "worker ready", "component=", "pool", "req.id=", `"/a b"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q missing %q", out, want)
+		}
+	}
+	if strings.Count(out, "\n") != 1 {
+		t.Errorf("want one line per record, got %q", out)
+	}
+}
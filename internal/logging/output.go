@@ -0,0 +1,255 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp inserted into rotated file names.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotateOptions controls built-in rotation of a log file. The zero value
+// disables it, leaving rotation to an external tool and Reopen.
+type RotateOptions struct {
+	MaxSize    int64         // rotate before a write would exceed this many bytes (0 = never)
+	MaxBackups int           // rotated files to keep (0 = keep all)
+	MaxAge     time.Duration // delete rotated files older than this (0 = keep all)
+	Compress   bool          // gzip rotated files
+}
+
+// Output is a log destination shared by every handler writing to it. Each
+// Write is a complete record and holds the lock for its duration, so
+// records never interleave and none are lost while the file is reopened or
+// rotated underneath them.
+type Output struct {
+	mu   sync.Mutex
+	path string    // empty for stdout/stderr
+	w    io.Writer // the current destination
+	file *os.File  // w when writing to a file
+	size int64
+	opts RotateOptions
+
+	mill    sync.Mutex     // serializes compression and pruning of backups
+	milling sync.WaitGroup // background mill runs, waited for by Close
+}
+
+// Open returns an Output for a logging.output value: "stdout", "stderr",
+// or a file path, which is created if needed and appended to.
+func Open(target string, opts RotateOptions) (*Output, error) {
+	switch target {
+	case "", "stdout":
+		return &Output{w: os.Stdout}, nil
+	case "stderr":
+		return &Output{w: os.Stderr}, nil
+	}
+	o := &Output{path: target, opts: opts}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Path returns the file written to, or "" for stdout and stderr.
+func (o *Output) Path() string {
+	return o.path
+}
+
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file != nil && o.opts.MaxSize > 0 && o.size > 0 && o.size+int64(len(p)) > o.opts.MaxSize {
+		if err := o.rotate(); err != nil {
+			// Keep logging to the current file rather than dropping records.
+			fmt.Fprintf(os.Stderr, "maboo: rotating %s: %v\n", o.path, err)
+		}
+	}
+	n, err := o.w.Write(p)
+	o.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens the file at its configured path, picking up a
+// new file after an external tool (logrotate) has renamed the old one. It
+// is a no-op for stdout and stderr.
+func (o *Output) Reopen() error {
+	if o.path == "" {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	old := o.file
+	if err := o.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// Close closes the file once pending backup compression has finished.
+// Stdout and stderr are left open.
+func (o *Output) Close() error {
+	if o.path == "" {
+		return nil
+	}
+	o.mu.Lock()
+	err := o.file.Close()
+	o.mu.Unlock()
+	o.milling.Wait()
+	return err
+}
+
+// open replaces the current file with a fresh handle on o.path. The caller
+// holds o.mu (or owns o exclusively).
+func (o *Output) open() error {
+	if err := os.MkdirAll(filepath.Dir(o.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	o.file, o.w, o.size = f, f, fi.Size()
+	return nil
+}
+
+// rotate renames the current file to a timestamped backup and starts a new
+// one, then compresses and prunes backups in the background. The caller
+// holds o.mu.
+func (o *Output) rotate() error {
+	backup := o.backupName(time.Now())
+	if err := os.Rename(o.path, backup); err != nil {
+		return err
+	}
+	old := o.file
+	if err := o.open(); err != nil {
+		// Put the file back so the old handle keeps writing to o.path.
+		os.Rename(backup, o.path)
+		return err
+	}
+	old.Close()
+
+	o.milling.Add(1)
+	go o.millBackups(backup)
+	return nil
+}
+
+// backupName returns an unused backup path for a rotation at t. Rotations
+// within the same millisecond move on to the next free timestamp.
+func (o *Output) backupName(t time.Time) string {
+	ext := filepath.Ext(o.path)
+	base := strings.TrimSuffix(o.path, ext)
+	for {
+		name := fmt.Sprintf("%s-%s%s", base, t.Format(backupTimeFormat), ext)
+		_, err := os.Lstat(name)
+		_, errGz := os.Lstat(name + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(errGz) {
+			return name
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// millBackups compresses a freshly rotated file and removes backups beyond
+// MaxBackups or older than MaxAge.
+func (o *Output) millBackups(rotated string) {
+	defer o.milling.Done()
+	o.mill.Lock()
+	defer o.mill.Unlock()
+
+	if o.opts.Compress {
+		if err := compressFile(rotated); err != nil {
+			fmt.Fprintf(os.Stderr, "maboo: compressing %s: %v\n", rotated, err)
+		}
+	}
+
+	backups, err := o.backups()
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-o.opts.MaxAge)
+	for i, b := range backups {
+		expired := o.opts.MaxAge > 0 && b.modTime.Before(cutoff)
+		surplus := o.opts.MaxBackups > 0 && i >= o.opts.MaxBackups
+		if expired || surplus {
+			os.Remove(b.path)
+		}
+	}
+}
+
+type backupFile struct {
+	path    string
+	modTime time.Time
+}
+
+// backups lists rotated files for this output, newest first.
+func (o *Output) backups() ([]backupFile, error) {
+	dir := filepath.Dir(o.path)
+	ext := filepath.Ext(o.path)
+	prefix := strings.TrimSuffix(filepath.Base(o.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []backupFile
+	for _, e := range entries {
+		name := e.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, backupFile{path: filepath.Join(dir, name), modTime: info.ModTime()})
+	}
+	// The timestamp in the name sorts chronologically.
+	sort.Slice(out, func(i, j int) bool { return out[i].path > out[j].path })
+	return out, nil
+}
+
+// compressFile replaces path with path.gz.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,141 @@
+package logging_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/logging"
+)
+
+// countLines counts newline-terminated records in dir, reading through
+// gzip for compressed backups, and fails on any partial record.
+func countLines(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, e := range entries {
+		f, err := os.Open(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(e.Name(), ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatalf("%s: %v", e.Name(), err)
+			}
+			r = gz
+		}
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			if !strings.HasPrefix(sc.Text(), "record ") || !strings.HasSuffix(sc.Text(), " end") {
+				t.Errorf("%s: interleaved or partial record %q", e.Name(), sc.Text())
+			}
+			total++
+		}
+		f.Close()
+	}
+	return total
+}
+
+func TestOutputRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	out, err := logging.Open(filepath.Join(dir, "app.log"), logging.RotateOptions{MaxSize: 1024, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, records = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				fmt.Fprintf(out, "record %d/%d padding-padding-padding end\n", w, i)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := countLines(t, dir); got != writers*records {
+		t.Errorf("found %d records, want %d", got, writers*records)
+	}
+	gz, _ := filepath.Glob(filepath.Join(dir, "app-*.log.gz"))
+	if len(gz) == 0 {
+		t.Error("no compressed backups")
+	}
+	if plain, _ := filepath.Glob(filepath.Join(dir, "app-*.log")); len(plain) != 0 {
+		t.Errorf("uncompressed backups left behind: %v", plain)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "app.log")); err != nil || fi.Size() > 1024 {
+		t.Errorf("active file: %v, size over the limit", err)
+	}
+}
+
+func TestOutputPrunesBackups(t *testing.T) {
+	dir := t.TempDir()
+	out, err := logging.Open(filepath.Join(dir, "access.log"), logging.RotateOptions{MaxSize: 64, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(out, "record %02d --------------------------------------- end\n", i)
+	}
+	out.Close()
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "access-*.log"))
+	if len(backups) != 2 {
+		t.Errorf("backups = %v, want the 2 newest", backups)
+	}
+}
+
+func TestOutputReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	out, err := logging.Open(path, logging.RotateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	fmt.Fprintln(out, "record before end")
+	// What logrotate does: rename, then signal.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(out, "record still-old end")
+	if err := out.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(out, "record after end")
+
+	if got := readLines(t, path+".1"); len(got) != 2 {
+		t.Errorf("rotated file = %q, want the two records before reopen", got)
+	}
+	if got := readLines(t, path); len(got) != 1 || got[0] != "record after end" {
+		t.Errorf("new file = %q", got)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+// Callers of PHP from Go code, used as the caller label of the internal
+// request metrics. Programs embedding maboo's packages may use their own.
+const (
+	CallerSchedule       = "schedule"
+	CallerProbe          = "probe"
+	CallerSelfTest       = "self_test"
+	CallerWebSocketAuth  = "websocket_auth"
+	CallerWebSocketEvent = "websocket_event"
+	CallerOther          = "internal" // a request naming no caller
+)
+
+var (
+	internalRequests = NewCounterVec("maboo_internal_requests_total",
+		"PHP requests made from Go code rather than HTTP, by caller and status (error when none came back).", "caller", "status")
+	internalDuration = NewHistogramVec("maboo_internal_request_duration_seconds",
+		"Time taken by PHP requests made from Go code, by caller.", nil, "caller")
+)
+
+// RecordInternalRequest counts one internal request by caller that took d
+// and answered with status, or failed with err.
+func RecordInternalRequest(caller string, status int, err error, d time.Duration) {
+	if caller == "" {
+		caller = CallerOther
+	}
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(status)
+	}
+	internalRequests.WithLabelValues(caller, code).Inc()
+	internalDuration.WithLabelValues(caller).Observe(d.Seconds())
+}
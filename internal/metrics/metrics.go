@@ -0,0 +1,119 @@
+// Package metrics owns maboo's shared Prometheus registry.
+//
+// Subsystems register their own collectors here instead of editing the
+// server's exposition code:
+//
+//	var connections = metrics.NewGauge("maboo_websocket_connections", "Open WebSocket connections.")
+//
+// Constructors return the already-registered collector when called twice
+// with the same name, so packages can be initialized more than once (tests,
+// config reloads) without panicking.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry is the process-wide registry served at metrics.path.
+var Registry = prometheus.NewRegistry()
+
+// DefaultDurationBuckets are the latency buckets (seconds) used by maboo's
+// request histograms.
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0}
+
+// DefaultSizeBuckets are the body size buckets (bytes) used by maboo's
+// request and response size histograms: 256B to 256MiB in powers of 4.
+var DefaultSizeBuckets = prometheus.ExponentialBuckets(256, 4, 11)
+
+var runtimeOnce sync.Once
+
+// EnableRuntimeCollectors registers the standard go_* and process_*
+// collectors. They are optional (metrics.runtime_collectors) because they
+// add a few dozen series per instance.
+func EnableRuntimeCollectors() {
+	runtimeOnce.Do(func() {
+		Registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	})
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Register adds c to the registry, returning the existing collector if an
+// identical one is already registered.
+func Register[T prometheus.Collector](c T) T {
+	if err := Registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
+
+// Replace registers c, unregistering any previously registered collector
+// with the same descriptors first. Use it for collectors that snapshot
+// per-instance state (the server's request collector) where the newest
+// instance must win.
+func Replace(c prometheus.Collector) {
+	if err := Registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			panic(err)
+		}
+		Registry.Unregister(are.ExistingCollector)
+		Registry.MustRegister(c)
+	}
+}
+
+// NewCounter creates and registers a counter.
+func NewCounter(name, help string) prometheus.Counter {
+	return Register(prometheus.NewCounter(prometheus.CounterOpts{Name: name, Help: help}))
+}
+
+// NewCounterVec creates and registers a labeled counter.
+func NewCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	return Register(prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels))
+}
+
+// NewGauge creates and registers a gauge.
+func NewGauge(name, help string) prometheus.Gauge {
+	return Register(prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help}))
+}
+
+// NewGaugeVec creates and registers a labeled gauge.
+func NewGaugeVec(name, help string, labels ...string) *prometheus.GaugeVec {
+	return Register(prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels))
+}
+
+// NewHistogram creates and registers a histogram. Nil buckets use
+// DefaultDurationBuckets.
+func NewHistogram(name, help string, buckets []float64) prometheus.Histogram {
+	if buckets == nil {
+		buckets = DefaultDurationBuckets
+	}
+	return Register(prometheus.NewHistogram(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}))
+}
+
+// NewHistogramVec creates and registers a labeled histogram. Nil buckets
+// use DefaultDurationBuckets.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	if buckets == nil {
+		buckets = DefaultDurationBuckets
+	}
+	return Register(prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels))
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RuntimeSampleTTL is how long a runtime sample is reused. Scrapes and
+// health checks arriving within the TTL share one read.
+const RuntimeSampleTTL = time.Second
+
+// RuntimeSample is a snapshot of cheap Go runtime statistics. Unlike
+// runtime.ReadMemStats, reading it never stops the world.
+type RuntimeSample struct {
+	HeapAlloc   uint64 // bytes in heap objects
+	TotalMemory uint64 // bytes mapped by the runtime (≈ MemStats.Sys)
+	GCCycles    uint64 // completed GC cycles
+	Goroutines  uint64
+	Taken       time.Time
+}
+
+var runtimeMetricNames = [...]string{
+	"/memory/classes/heap/objects:bytes",
+	"/memory/classes/total:bytes",
+	"/gc/cycles/total:gc-cycles",
+	"/sched/goroutines:goroutines",
+}
+
+var (
+	runtimeSample  atomic.Pointer[RuntimeSample]
+	runtimeMu      sync.Mutex
+	runtimeSamples = func() []metrics.Sample {
+		s := make([]metrics.Sample, len(runtimeMetricNames))
+		for i, name := range runtimeMetricNames {
+			s[i].Name = name
+		}
+		return s
+	}()
+)
+
+// ReadRuntime returns the cached runtime sample, refreshing it when it is
+// older than RuntimeSampleTTL.
+func ReadRuntime() RuntimeSample {
+	if s := runtimeSample.Load(); s != nil && time.Since(s.Taken) < RuntimeSampleTTL {
+		return *s
+	}
+
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+
+	// Another caller may have refreshed while we waited.
+	if s := runtimeSample.Load(); s != nil && time.Since(s.Taken) < RuntimeSampleTTL {
+		return *s
+	}
+
+	metrics.Read(runtimeSamples)
+	s := &RuntimeSample{
+		HeapAlloc:   sampleUint64(runtimeSamples[0]),
+		TotalMemory: sampleUint64(runtimeSamples[1]),
+		GCCycles:    sampleUint64(runtimeSamples[2]),
+		Goroutines:  sampleUint64(runtimeSamples[3]),
+		Taken:       time.Now(),
+	}
+	runtimeSample.Store(s)
+	return *s
+}
+
+func sampleUint64(s metrics.Sample) uint64 {
+	if s.Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s.Value.Uint64()
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestReadRuntime(t *testing.T) {
+	s := ReadRuntime()
+	if s.HeapAlloc == 0 || s.TotalMemory == 0 || s.Goroutines == 0 {
+		t.Fatalf("expected non-zero sample, got %+v", s)
+	}
+	if again := ReadRuntime(); again.Taken != s.Taken {
+		t.Error("expected the cached sample to be reused within the TTL")
+	}
+}
+
+// BenchmarkReadMemStats is the stop-the-world baseline ReadRuntime replaces.
+func BenchmarkReadMemStats(b *testing.B) {
+	var mem runtime.MemStats
+	for b.Loop() {
+		runtime.ReadMemStats(&mem)
+	}
+}
+
+func BenchmarkReadRuntime(b *testing.B) {
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			runtimeSample.Store(nil)
+			ReadRuntime()
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			ReadRuntime()
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Reasons a worker is taken out of rotation and replaced, used as the
+// cause label of maboo_worker_recycled_total.
+const (
+	RecycleMaxJobs  = "max_jobs"
+	RecycleMemory   = "memory"
+	RecycleTimeout  = "timeout"
+	RecycleCrash    = "crash"
+	RecycleResponse = "response_size" // the script's output passed server.max_response_size
+	RecycleEval     = "eval"          // the worker evaluated a snippet for the admin API
+	RecycleKilled   = "killed"        // the admin API killed the worker
+)
+
+// What made the pool add a worker, used as the trigger label of
+// maboo_worker_scale_ups_total.
+const (
+	ScaleTick   = "tick"   // the watchdog found the pool busy
+	ScaleDemand = "demand" // a request found no idle worker
+	ScaleCold   = "cold"   // a request found an on-demand pool scaled to zero
+)
+
+// RecycleCauses lists every recycle cause in exposition order.
+var RecycleCauses = []string{RecycleMaxJobs, RecycleMemory, RecycleTimeout, RecycleCrash, RecycleResponse, RecycleEval, RecycleKilled}
+
+var (
+	workerSpawns = NewCounter("maboo_worker_spawn_total",
+		"PHP workers spawned successfully.")
+	workerSpawnFailures = NewCounter("maboo_worker_spawn_failures_total",
+		"PHP worker spawns that failed.")
+	workerRecycled = NewCounterVec("maboo_worker_recycled_total",
+		"PHP workers recycled, by cause.", "cause")
+	workerScaleUps = NewCounterVec("maboo_worker_scale_ups_total",
+		"PHP workers added to a pool under load, by trigger.", "trigger")
+	workerExecErrors = NewCounter("maboo_worker_exec_errors_total",
+		"Requests that failed inside a PHP worker.")
+	workerSpawnDuration = NewHistogram("maboo_worker_spawn_duration_seconds",
+		"Time taken to spawn and start a PHP worker.", nil)
+	workerColdStarts = NewHistogram("maboo_worker_cold_start_wait_seconds",
+		"Time requests that found an on-demand pool scaled to zero waited for its first worker.", nil)
+)
+
+func init() {
+	// Pre-create every cause so dashboards see zeros instead of gaps.
+	for _, cause := range RecycleCauses {
+		workerRecycled.WithLabelValues(cause)
+	}
+	workerScaleUps.WithLabelValues(ScaleTick)
+	workerScaleUps.WithLabelValues(ScaleDemand)
+	workerScaleUps.WithLabelValues(ScaleCold)
+}
+
+// ObserveWorkerSpawn records a spawn attempt that started at start.
+func ObserveWorkerSpawn(start time.Time, err error) {
+	if err != nil {
+		workerSpawnFailures.Inc()
+		return
+	}
+	workerSpawns.Inc()
+	workerSpawnDuration.Observe(time.Since(start).Seconds())
+}
+
+// RecordWorkerRecycle counts a worker recycled for cause.
+func RecordWorkerRecycle(cause string) {
+	workerRecycled.WithLabelValues(cause).Inc()
+	recentRecycles.add(cause, time.Now())
+}
+
+// RecordScaleUp counts a worker added to a pool for trigger.
+func RecordScaleUp(trigger string) {
+	workerScaleUps.WithLabelValues(trigger).Inc()
+}
+
+// ObserveColdStart records a request that found its pool scaled to zero
+// and started waiting for a worker at start.
+func ObserveColdStart(start time.Time) {
+	workerColdStarts.Observe(time.Since(start).Seconds())
+}
+
+// RecordWorkerExecError counts a request that failed inside a worker.
+func RecordWorkerExecError() {
+	workerExecErrors.Inc()
+}
+
+// RecentWorkerRecycles returns per-cause recycle counts over the last hour.
+func RecentWorkerRecycles() map[string]int {
+	return recentRecycles.total(time.Now())
+}
+
+var recentRecycles = newRecycleWindow()
+
+// recycleWindow keeps per-minute recycle counts for the trailing hour.
+type recycleWindow struct {
+	mu      sync.Mutex
+	minutes [60]int64 // unix minute each slot belongs to
+	slots   [60]map[string]int
+}
+
+func newRecycleWindow() *recycleWindow {
+	return &recycleWindow{}
+}
+
+func (rw *recycleWindow) add(cause string, now time.Time) {
+	minute := now.Unix() / 60
+	slot := minute % 60
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.minutes[slot] != minute || rw.slots[slot] == nil {
+		rw.minutes[slot] = minute
+		rw.slots[slot] = make(map[string]int, len(RecycleCauses))
+	}
+	rw.slots[slot][cause]++
+}
+
+func (rw *recycleWindow) total(now time.Time) map[string]int {
+	minute := now.Unix() / 60
+	out := make(map[string]int, len(RecycleCauses))
+	for _, cause := range RecycleCauses {
+		out[cause] = 0
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	for slot, m := range rw.slots {
+		if m == nil || minute-rw.minutes[slot] >= 60 {
+			continue
+		}
+		for cause, n := range m {
+			out[cause] += n
+		}
+	}
+	return out
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecycleWindow(t *testing.T) {
+	rw := newRecycleWindow()
+	now := time.Unix(1_700_000_000, 0)
+
+	rw.add(RecycleMaxJobs, now.Add(-61*time.Minute)) // expired
+	rw.add(RecycleMaxJobs, now.Add(-30*time.Minute))
+	rw.add(RecycleMemory, now)
+	rw.add(RecycleMemory, now)
+
+	got := rw.total(now)
+	if got[RecycleMaxJobs] != 1 {
+		t.Errorf("max_jobs = %d, want 1", got[RecycleMaxJobs])
+	}
+	if got[RecycleMemory] != 2 {
+		t.Errorf("memory = %d, want 2", got[RecycleMemory])
+	}
+	if n, ok := got[RecycleCrash]; !ok || n != 0 {
+		t.Errorf("crash = %d (present %v), want 0", n, ok)
+	}
+
+	// A slot reused an hour later starts from zero.
+	rw.add(RecycleMemory, now.Add(time.Hour))
+	if got := rw.total(now.Add(time.Hour)); got[RecycleMemory] != 1 {
+		t.Errorf("memory after wrap = %d, want 1", got[RecycleMemory])
+	}
+}
@@ -0,0 +1,339 @@
+// Package notify posts operational events, such as workers crashing in a
+// loop or a certificate that cannot be renewed, to webhooks: Slack,
+// PagerDuty or anything else that takes a JSON POST. Like errreport it
+// never blocks the code raising the event: events wait in a bounded queue,
+// are dropped when it is full, and one background goroutine delivers them,
+// retrying failures with backoff.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var notificationsTotal = metrics.NewCounterVec("maboo_notifications_total",
+	"Webhook notifications by event and outcome (sent, failed, dropped).", "event", "result")
+
+// Outcomes of a notification, used as metric label values.
+const (
+	resultSent    = "sent"
+	resultFailed  = "failed"  // every attempt failed, or the payload could not be built
+	resultDropped = "dropped" // the queue was full, or the notifier closed
+)
+
+// Event types.
+const (
+	EventWorkerCrashLoop = "worker_crash_loop"      // workers of a pool keep crashing
+	EventACMEFailure     = "acme_failure"           // a certificate could not be obtained or renewed
+	EventProbeFailing    = "health_probe_failing"   // a health.checks probe went unhealthy
+	EventProbeRecovered  = "health_probe_recovered" // and became healthy again
+	EventTest            = "test"                   // sent by the admin API to try the webhooks
+)
+
+// Events lists the event types a webhook can subscribe to.
+var Events = []string{EventWorkerCrashLoop, EventACMEFailure, EventProbeFailing, EventProbeRecovered, EventTest}
+
+// Event is one thing that happened.
+type Event struct {
+	Type    string
+	Message string
+	Details map[string]string // e.g. the probe, domain or last error
+}
+
+// Payload is what a webhook's template is executed with, and what is
+// posted as JSON when it has none.
+type Payload struct {
+	InstanceID string            `json:"instance_id"`
+	Event      string            `json:"event"`
+	Message    string            `json:"message"`
+	Time       time.Time         `json:"time"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+// ParseTemplate parses a webhook payload template. Templates are
+// text/template, executed with a Payload, and must produce JSON; the json
+// function quotes a value for it, as in {"text": {{json .Message}}}.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("payload").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Option("missingkey=zero").Parse(text)
+}
+
+// Webhook is one endpoint events are posted to.
+type Webhook struct {
+	Name     string
+	URL      string
+	Events   []string           // event types sent; all of them when empty
+	Template *template.Template // builds the body; nil posts the Payload as JSON
+	Timeout  time.Duration      // per attempt
+}
+
+// wants reports whether events of type event go to w.
+func (w *Webhook) wants(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// Options configures a Notifier.
+type Options struct {
+	InstanceID string        // names this maboo instance in payloads
+	QueueSize  int           // events waiting to be sent; more are dropped
+	Retries    int           // further attempts after a failed one
+	Backoff    time.Duration // wait before the first retry, doubled for each one after
+}
+
+// Notifier delivers events to webhooks from a single background
+// goroutine. A nil *Notifier drops every event.
+type Notifier struct {
+	hooks  []Webhook
+	opts   Options
+	client *http.Client
+	logger *slog.Logger
+
+	mu     sync.RWMutex // guards closed, and queue against sends after close
+	closed bool
+	queue  chan Event
+	done   chan struct{}
+
+	// ctx is cancelled when Close gives up, ending the attempt or backoff
+	// under way.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New returns a Notifier posting to hooks and starts its goroutine.
+func New(hooks []Webhook, opts Options, logger *slog.Logger) *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		hooks:  hooks,
+		opts:   opts,
+		client: &http.Client{},
+		logger: logger,
+		queue:  make(chan Event, max(opts.QueueSize, 1)),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues e for the webhooks that want it, unless the queue is full.
+// It never blocks.
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		notificationsTotal.WithLabelValues(e.Type, resultDropped).Inc()
+		return
+	}
+	select {
+	case n.queue <- e:
+	default:
+		notificationsTotal.WithLabelValues(e.Type, resultDropped).Inc()
+		n.logger.Warn("notification dropped, queue full", "event", e.Type)
+	}
+}
+
+// Delivery is the outcome of sending an event to one webhook.
+type Delivery struct {
+	Webhook string `json:"webhook"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Test sends a test event to every webhook, whatever events it takes,
+// and waits for the outcome. There are no retries, so a webhook that
+// fails shows as failed.
+func (n *Notifier) Test(ctx context.Context, message string) []Delivery {
+	if n == nil {
+		return []Delivery{}
+	}
+	e := Event{Type: EventTest, Message: message}
+	out := make([]Delivery, len(n.hooks))
+	for i := range n.hooks {
+		h := &n.hooks[i]
+		out[i].Webhook = h.Name
+		if err := n.send(ctx, h, e, time.Now()); err != nil {
+			notificationsTotal.WithLabelValues(e.Type, resultFailed).Inc()
+			out[i].Error = err.Error()
+			continue
+		}
+		notificationsTotal.WithLabelValues(e.Type, resultSent).Inc()
+	}
+	return out
+}
+
+// Close sends the queued events and stops the notifier. When ctx is done
+// first, the delivery under way is abandoned and ctx's error returned.
+func (n *Notifier) Close(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		return ctx.Err()
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for e := range n.queue {
+		now := time.Now()
+		for i := range n.hooks {
+			h := &n.hooks[i]
+			if !h.wants(e.Type) {
+				continue
+			}
+			attempts, err := n.deliver(h, e, now)
+			if err != nil {
+				notificationsTotal.WithLabelValues(e.Type, resultFailed).Inc()
+				n.logger.Warn("sending notification", "webhook", h.Name, "event", e.Type, "attempts", attempts, "error", err)
+				continue
+			}
+			notificationsTotal.WithLabelValues(e.Type, resultSent).Inc()
+		}
+	}
+}
+
+// deliver sends e to h, retrying with backoff, and returns the number of
+// attempts made with the last error.
+func (n *Notifier) deliver(h *Webhook, e Event, at time.Time) (int, error) {
+	backoff := n.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := n.send(n.ctx, h, e, at)
+		if err == nil || attempt > n.opts.Retries || errors.As(err, new(permanentError)) {
+			return attempt, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-n.ctx.Done():
+			return attempt, err
+		}
+		backoff *= 2
+	}
+}
+
+// permanentError is a failure retrying will not fix, such as a template
+// that does not produce JSON or a webhook refusing the payload.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+// send makes one attempt at posting e to h.
+func (n *Notifier) send(ctx context.Context, h *Webhook, e Event, at time.Time) error {
+	body, err := n.payload(h, e, at)
+	if err != nil {
+		return permanentError{err}
+	}
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "maboo")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The error would quote the URL, which often holds a token.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	default:
+		return permanentError{fmt.Errorf("webhook answered %s", resp.Status)}
+	}
+}
+
+// payload builds the body posting e to h.
+func (n *Notifier) payload(h *Webhook, e Event, at time.Time) ([]byte, error) {
+	p := Payload{
+		InstanceID: n.opts.InstanceID,
+		Event:      e.Type,
+		Message:    e.Message,
+		Time:       at.UTC(),
+		Details:    e.Details,
+	}
+	if h.Template == nil {
+		return json.Marshal(p)
+	}
+	var buf bytes.Buffer
+	if err := h.Template.Execute(&buf, p); err != nil {
+		return nil, fmt.Errorf("executing template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template did not produce JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// Reporter returns an errreport.Reporter that passes every event on to
+// next and also notifies n of worker crash loops, so whatever reports
+// those, the worker pools among others, raises the notification too.
+// Closing it closes next only.
+func Reporter(n *Notifier, next errreport.Reporter) errreport.Reporter {
+	if n == nil {
+		return next
+	}
+	return crashLoops{Reporter: next, n: n}
+}
+
+type crashLoops struct {
+	errreport.Reporter
+	n *Notifier
+}
+
+func (r crashLoops) Report(e errreport.Event) {
+	r.Reporter.Report(e)
+	if e.Kind != errreport.KindWorkerCrashLoop {
+		return
+	}
+	details := make(map[string]string, len(e.Tags)+len(e.Extra))
+	for k, v := range e.Tags {
+		details[k] = v
+	}
+	for k, v := range e.Extra {
+		details[k] = v
+	}
+	r.n.Notify(Event{Type: EventWorkerCrashLoop, Message: e.Message, Details: details})
+}
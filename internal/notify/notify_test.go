@@ -0,0 +1,194 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/notify"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestNotifierDelivers(t *testing.T) {
+	slack := make(chan map[string]any, 10)
+	all := make(chan map[string]any, 10)
+	hook := func(got chan map[string]any) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("body is not JSON: %v", err)
+			}
+			got <- body
+		}))
+	}
+	slackSrv, allSrv := hook(slack), hook(all)
+	defer slackSrv.Close()
+	defer allSrv.Close()
+
+	tmpl, err := notify.ParseTemplate(`{"text": {{json (printf "[%s] %s: %s" .InstanceID .Event .Message)}}, "probe": {{json .Details.probe}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := notify.New([]notify.Webhook{
+		{Name: "slack", URL: slackSrv.URL, Events: []string{notify.EventProbeFailing}, Template: tmpl},
+		{Name: "all", URL: allSrv.URL},
+	}, notify.Options{InstanceID: "web-1", QueueSize: 10}, discard)
+
+	n.Notify(notify.Event{Type: notify.EventProbeFailing, Message: `probe "db" failing`, Details: map[string]string{"probe": "db"}})
+	n.Notify(notify.Event{Type: notify.EventACMEFailure, Message: "no certificate", Details: map[string]string{"domain": "example.com"}})
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(slack) != 1 || len(all) != 2 {
+		t.Fatalf("slack got %d, all got %d; want 1 and 2", len(slack), len(all))
+	}
+	if got := <-slack; got["text"] != `[web-1] health_probe_failing: probe "db" failing` || got["probe"] != "db" {
+		t.Errorf("templated payload = %v", got)
+	}
+	<-all
+	got := <-all
+	details, _ := got["details"].(map[string]any)
+	if got["instance_id"] != "web-1" || got["event"] != notify.EventACMEFailure || details["domain"] != "example.com" || got["time"] == nil {
+		t.Errorf("payload = %v", got)
+	}
+}
+
+func TestNotifierRetries(t *testing.T) {
+	var calls atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+	var refused atomic.Int32
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refused.Add(1)
+		http.Error(w, "no such channel", http.StatusNotFound)
+	}))
+	defer refusing.Close()
+
+	n := notify.New([]notify.Webhook{
+		{Name: "flaky", URL: flaky.URL},
+		{Name: "refusing", URL: refusing.URL},
+	}, notify.Options{QueueSize: 1, Retries: 3, Backoff: time.Millisecond}, discard)
+	n.Notify(notify.Event{Type: notify.EventWorkerCrashLoop, Message: "crashing"})
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("flaky webhook called %d times, want 3", got)
+	}
+	if got := refused.Load(); got != 1 {
+		t.Errorf("webhook answering 404 called %d times, want 1: retrying will not help", got)
+	}
+}
+
+func TestNotifierNeverBlocks(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	n := notify.New([]notify.Webhook{{Name: "stuck", URL: srv.URL}},
+		notify.Options{QueueSize: 2, Retries: 5, Backoff: time.Second}, discard)
+	start := time.Now()
+	for range 100 {
+		n.Notify(notify.Event{Type: notify.EventWorkerCrashLoop})
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("100 notifications to a stuck webhook took %s", d)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := n.Close(ctx); err == nil {
+		t.Error("Close returned before the stuck notifications were sent")
+	}
+	n.Notify(notify.Event{Type: notify.EventWorkerCrashLoop}) // dropped, must not panic
+
+	var none *notify.Notifier
+	none.Notify(notify.Event{Type: notify.EventTest})
+	if err := none.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotifierTest(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	// Test ignores event filters, and never quotes the URL.
+	n := notify.New([]notify.Webhook{
+		{Name: "ok", URL: ok.URL + "/T0KEN", Events: []string{notify.EventACMEFailure}},
+		{Name: "down", URL: down.URL + "/T0KEN"},
+	}, notify.Options{QueueSize: 1}, discard)
+	defer n.Close(context.Background())
+
+	got := n.Test(context.Background(), "hello")
+	if len(got) != 2 || got[0] != (notify.Delivery{Webhook: "ok"}) || got[1].Webhook != "down" || got[1].Error == "" {
+		t.Fatalf("Test = %+v", got)
+	}
+	if strings.Contains(got[1].Error, "T0KEN") {
+		t.Errorf("error quotes the URL: %s", got[1].Error)
+	}
+}
+
+func TestReporter(t *testing.T) {
+	got := make(chan map[string]any, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		got <- body
+	}))
+	defer srv.Close()
+	n := notify.New([]notify.Webhook{{Name: "all", URL: srv.URL}}, notify.Options{QueueSize: 10}, discard)
+
+	next := &recorder{}
+	r := notify.Reporter(n, next)
+	r.Report(errreport.Event{Kind: errreport.KindPanic, Message: "boom"})
+	r.Report(errreport.Event{
+		Kind:    errreport.KindWorkerCrashLoop,
+		Message: "5 PHP worker crashes within 1m0s",
+		Tags:    map[string]string{"worker_id": "3"},
+		Extra:   map[string]string{"last_error": "exit status 255"},
+	})
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.events) != 2 {
+		t.Errorf("next reporter got %d events, want both", len(next.events))
+	}
+	if len(got) != 1 {
+		t.Fatalf("%d notifications, want only the crash loop's", len(got))
+	}
+	body := <-got
+	details, _ := body["details"].(map[string]any)
+	if body["event"] != notify.EventWorkerCrashLoop || details["worker_id"] != "3" || details["last_error"] != "exit status 255" {
+		t.Errorf("payload = %v", body)
+	}
+
+	if notify.Reporter(nil, next) != errreport.Reporter(next) {
+		t.Error("Reporter without a notifier should be next")
+	}
+}
+
+type recorder struct {
+	events []errreport.Event
+}
+
+func (r *recorder) Report(e errreport.Event)    { r.events = append(r.events, e) }
+func (r *recorder) Close(context.Context) error { return nil }
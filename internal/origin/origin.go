@@ -0,0 +1,179 @@
+// Package origin checks the Origin header of cross-site requests, such as
+// WebSocket upgrades, against a list of allowed origins.
+package origin
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Special entries of an allowed origins list.
+const (
+	SameOrigin = "same-origin" // the origin of the request itself
+	Any        = "*"           // every origin; disables the check
+)
+
+// Checker decides which origins are allowed.
+type Checker struct {
+	any        bool
+	sameOrigin bool
+	rules      []rule
+}
+
+// rule is one allowed origin. An empty scheme matches http and https, an
+// empty port the scheme's default port.
+type rule struct {
+	scheme   string
+	host     string // without the "*." of a wildcard
+	wildcard bool   // host matches subdomains of host, not host itself
+	port     string
+}
+
+// Compile parses allowed origins. Besides SameOrigin and Any, entries are
+// "[scheme://]host[:port]", where host may start with "*." to allow every
+// subdomain. An empty list allows the same origin only.
+func Compile(allowed []string) (*Checker, error) {
+	c := &Checker{}
+	if len(allowed) == 0 {
+		c.sameOrigin = true
+	}
+	for _, entry := range allowed {
+		switch entry {
+		case Any:
+			c.any = true
+			continue
+		case SameOrigin:
+			c.sameOrigin = true
+			continue
+		}
+		r, err := parseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		c.rules = append(c.rules, r)
+	}
+	return c, nil
+}
+
+func parseRule(entry string) (rule, error) {
+	var r rule
+	hostport := entry
+	if scheme, rest, ok := strings.Cut(entry, "://"); ok {
+		r.scheme = strings.ToLower(scheme)
+		if r.scheme != "http" && r.scheme != "https" {
+			return rule{}, fmt.Errorf("origin %q: scheme must be http or https", entry)
+		}
+		hostport = rest
+	}
+	if hostport == "" || strings.ContainsAny(hostport, "/?#@") {
+		return rule{}, fmt.Errorf("origin %q must be [scheme://]host[:port]", entry)
+	}
+
+	host := hostport
+	if h, port, err := net.SplitHostPort(hostport); err == nil {
+		if port == "" {
+			return rule{}, fmt.Errorf("origin %q: empty port", entry)
+		}
+		host, r.port = h, port
+	}
+	host = strings.ToLower(host)
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		host, r.wildcard = rest, true
+	}
+	if host == "" || strings.Contains(host, "*") {
+		return rule{}, fmt.Errorf("origin %q: only a leading \"*.\" wildcard is allowed", entry)
+	}
+	r.host = host
+	return r, nil
+}
+
+// AllowsAny reports whether the checker lets every origin through.
+func (c *Checker) AllowsAny() bool {
+	return c.any
+}
+
+// Allow reports whether req may proceed. Requests without an Origin come
+// from non-browser clients, which cannot be hijacked cross-site, and are
+// allowed. scheme is the scheme the client used to reach the server
+// ("http" or "https"), needed to compare a same-origin request.
+func (c *Checker) Allow(req *http.Request, scheme string) bool {
+	header := req.Header.Get("Origin")
+	if header == "" || c.any {
+		return true
+	}
+	o, ok := parse(header)
+	if !ok {
+		return false
+	}
+	if c.sameOrigin && o == self(req, scheme) {
+		return true
+	}
+	for _, r := range c.rules {
+		if r.matches(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// origin is a normalized scheme, lower-case host and explicit port.
+type origin struct {
+	scheme, host, port string
+}
+
+// parse parses an Origin header. The opaque "null" origin and anything
+// with a path do not parse.
+func parse(header string) (origin, bool) {
+	u, err := url.Parse(header)
+	if err != nil || u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return origin{}, false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return origin{}, false
+	}
+	return normalize(scheme, u.Hostname(), u.Port()), true
+}
+
+// self is the origin of req itself, taken from its Host header.
+func self(req *http.Request, scheme string) origin {
+	host, port, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host, port = req.Host, ""
+	}
+	return normalize(scheme, strings.Trim(host, "[]"), port)
+}
+
+func normalize(scheme, host, port string) origin {
+	if port == "" {
+		port = defaultPort(scheme)
+	}
+	return origin{scheme: scheme, host: strings.ToLower(host), port: port}
+}
+
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+func (r rule) matches(o origin) bool {
+	if r.scheme != "" && r.scheme != o.scheme {
+		return false
+	}
+	port := r.port
+	if port == "" {
+		port = defaultPort(o.scheme)
+	}
+	if port != o.port {
+		return false
+	}
+	if r.wildcard {
+		return strings.HasSuffix(o.host, "."+r.host)
+	}
+	return o.host == r.host
+}
@@ -0,0 +1,82 @@
+package origin_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/origin"
+)
+
+func TestAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		host    string // request Host header
+		scheme  string // scheme the client used
+		origin  string // "" = no Origin header
+		want    bool
+	}{
+		{"no origin", nil, "example.com", "https", "", true},
+		{"no origin, strict list", []string{"https://app.example.com"}, "example.com", "https", "", true},
+
+		{"same origin", nil, "example.com", "https", "https://example.com", true},
+		{"same origin, default port spelled out", nil, "example.com", "https", "https://example.com:443", true},
+		{"same origin, host with port", nil, "example.com:8443", "https", "https://example.com:8443", true},
+		{"same origin, case", nil, "Example.COM", "https", "https://example.com", true},
+		{"same origin, ipv6", nil, "[::1]:8080", "http", "http://[::1]:8080", true},
+		{"cross origin", nil, "example.com", "https", "https://evil.test", false},
+		{"scheme mismatch", nil, "example.com", "https", "http://example.com", false},
+		{"port mismatch", nil, "example.com:8443", "https", "https://example.com", false},
+		{"null origin", nil, "example.com", "https", "null", false},
+		{"origin with path", nil, "example.com", "https", "https://example.com/app", false},
+
+		{"exact", []string{"https://app.example.com"}, "api.example.com", "https", "https://app.example.com", true},
+		{"exact, wrong scheme", []string{"https://app.example.com"}, "api.example.com", "https", "http://app.example.com", false},
+		{"exact, other port", []string{"https://app.example.com"}, "api.example.com", "https", "https://app.example.com:8443", false},
+		{"exact with port", []string{"http://localhost:3000"}, "localhost:8080", "http", "http://localhost:3000", true},
+		{"exact with port, default port", []string{"http://localhost:3000"}, "localhost:8080", "http", "http://localhost", false},
+		{"no scheme, http", []string{"app.example.com"}, "api.example.com", "https", "http://app.example.com", true},
+		{"no scheme, https", []string{"app.example.com"}, "api.example.com", "https", "https://app.example.com", true},
+		{"list replaces same origin", []string{"https://app.example.com"}, "api.example.com", "https", "https://api.example.com", false},
+		{"list with same-origin", []string{"same-origin", "https://app.example.com"}, "api.example.com", "https", "https://api.example.com", true},
+
+		{"wildcard subdomain", []string{"https://*.example.com"}, "api.example.com", "https", "https://app.example.com", true},
+		{"wildcard nested", []string{"https://*.example.com"}, "api.example.com", "https", "https://a.b.example.com", true},
+		{"wildcard excludes apex", []string{"https://*.example.com"}, "api.example.com", "https", "https://example.com", false},
+		{"wildcard suffix trick", []string{"https://*.example.com"}, "api.example.com", "https", "https://evilexample.com", false},
+		{"wildcard other domain", []string{"*.example.com"}, "api.example.com", "https", "https://example.com.evil.test", false},
+
+		{"any", []string{"*"}, "example.com", "https", "https://evil.test", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := origin.Compile(tt.allowed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("GET", "/ws", nil)
+			req.Host = tt.host
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := c.Allow(req, tt.scheme); got != tt.want {
+				t.Errorf("Allow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, entry := range []string{
+		"ws://example.com",
+		"https://example.com/path",
+		"https://",
+		"example.*.com",
+		"https://example.com:",
+		"https://user@example.com",
+	} {
+		if _, err := origin.Compile([]string{entry}); err == nil {
+			t.Errorf("Compile(%q) accepted", entry)
+		}
+	}
+}
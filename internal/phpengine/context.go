@@ -1,9 +1,14 @@
 package phpengine
 
 import (
+	"bytes"
+	"crypto/tls"
+	"io"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Context holds the PHP superglobals and execution context.
@@ -16,9 +21,35 @@ type Context struct {
 	Files   map[string]File
 	Env     map[string]string
 
+	// Body is the raw request body, as php://input reads it and as it is
+	// sent to a process worker; nil when the request has none.
+	Body io.Reader
+
 	// Execution info
 	ScriptFilename string
 	DocumentRoot   string
+
+	// INI overrides php.ini for this request only, e.g. max_execution_time
+	// as set by SetTimeout.
+	INI map[string]string
+
+	// MaxResponseSize limits the script's output, in bytes (0 =
+	// unlimited). Past it, execution fails with ErrResponseTooLarge.
+	MaxResponseSize int64
+
+	// EarlyHints, when set, sends a 103 Early Hints response with these
+	// Link header values while the script keeps running. The engine calls
+	// it when PHP sends a 103 before its final response.
+	EarlyHints func(links []string)
+
+	// CollectStats asks the engine for the request's Stats in its
+	// Response. Off, nothing is measured.
+	CollectStats bool
+
+	// Filled in by the worker pool after execution.
+	WorkerID int
+	PoolWait time.Duration // time spent waiting for an available worker
+	ExecTime time.Duration // time spent executing PHP
 }
 
 // File represents an uploaded file.
@@ -38,6 +69,7 @@ func NewContext(req *http.Request, docRoot, entryPoint string) *Context {
 		Cookies:        make(map[string]string),
 		Files:          make(map[string]File),
 		Env:            make(map[string]string),
+		INI:            make(map[string]string),
 		DocumentRoot:   docRoot,
 		ScriptFilename: filepath.Join(docRoot, entryPoint),
 	}
@@ -46,7 +78,7 @@ func NewContext(req *http.Request, docRoot, entryPoint string) *Context {
 	ctx.Server["REQUEST_METHOD"] = req.Method
 	ctx.Server["REQUEST_URI"] = req.URL.Path
 	ctx.Server["QUERY_STRING"] = req.URL.RawQuery
-	ctx.Server["SERVER_PROTOCOL"] = "HTTP/1.1"
+	ctx.Server["SERVER_PROTOCOL"] = serverProtocol(req)
 	ctx.Server["SERVER_NAME"] = req.Host
 	ctx.Server["DOCUMENT_ROOT"] = docRoot
 	ctx.Server["SCRIPT_NAME"] = "/" + entryPoint
@@ -56,9 +88,19 @@ func NewContext(req *http.Request, docRoot, entryPoint string) *Context {
 	ctx.Server["CONTENT_TYPE"] = req.Header.Get("Content-Type")
 	ctx.Server["CONTENT_LENGTH"] = req.Header.Get("Content-Length")
 
-	// HTTPS
+	// HTTPS and the TLS connection, and HTTP2 or HTTP3 as Apache's
+	// mod_http2 sets HTTP2
 	if req.TLS != nil {
 		ctx.Server["HTTPS"] = "on"
+		for k, v := range TLSServerVars(req.TLS) {
+			ctx.Server[k] = v
+		}
+	}
+	switch req.ProtoMajor {
+	case 2:
+		ctx.Server["HTTP2"] = "on"
+	case 3:
+		ctx.Server["HTTP3"] = "on"
 	}
 
 	// Headers as HTTP_*
@@ -74,13 +116,22 @@ func NewContext(req *http.Request, docRoot, entryPoint string) *Context {
 		ctx.Get[key] = values[0]
 	}
 
-	// $_POST (if applicable)
+	// $_POST (if applicable). A form body is read here, so keep a copy
+	// of it for Body.
 	if req.Method == "POST" {
+		if req.Body != nil && strings.HasPrefix(ctx.Server["CONTENT_TYPE"], "application/x-www-form-urlencoded") {
+			raw, _ := io.ReadAll(io.LimitReader(req.Body, maxFormSize+1))
+			req.Body = io.NopCloser(bytes.NewReader(raw))
+			ctx.Body = bytes.NewReader(raw)
+		}
 		req.ParseForm()
 		for key, values := range req.PostForm {
 			ctx.Post[key] = values[0]
 		}
 	}
+	if ctx.Body == nil && req.Body != nil && req.Body != http.NoBody {
+		ctx.Body = req.Body
+	}
 
 	// $_COOKIE
 	for _, cookie := range req.Cookies() {
@@ -89,3 +140,62 @@ func NewContext(req *http.Request, docRoot, entryPoint string) *Context {
 
 	return ctx
 }
+
+// serverProtocol is SERVER_PROTOCOL for req: HTTP/1.0, HTTP/1.1,
+// HTTP/2.0 or HTTP/3.0 as the client spoke it.
+func serverProtocol(req *http.Request) string {
+	if req.Proto == "" {
+		return "HTTP/1.1"
+	}
+	return req.Proto
+}
+
+// TLSServerVars returns the $_SERVER entries describing the TLS
+// connection cs, named as Apache's mod_ssl names them: SSL_PROTOCOL
+// (TLSv1.2, TLSv1.3), SSL_CIPHER (the IANA name, e.g.
+// TLS_AES_128_GCM_SHA256) and SSL_SESSION_RESUMED (Initial or Resumed).
+// SSL_ALPN_PROTOCOL is the protocol negotiated with ALPN, if any.
+func TLSServerVars(cs *tls.ConnectionState) map[string]string {
+	vars := map[string]string{
+		"SSL_PROTOCOL":        sslProtocols[cs.Version],
+		"SSL_CIPHER":          tls.CipherSuiteName(cs.CipherSuite),
+		"SSL_SESSION_RESUMED": "Initial",
+	}
+	if cs.DidResume {
+		vars["SSL_SESSION_RESUMED"] = "Resumed"
+	}
+	if cs.NegotiatedProtocol != "" {
+		vars["SSL_ALPN_PROTOCOL"] = cs.NegotiatedProtocol
+	}
+	return vars
+}
+
+// sslProtocols names TLS versions as SSL_PROTOCOL.
+var sslProtocols = map[uint16]string{
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+// maxFormSize is the largest form body read into $_POST, as net/http's
+// ParseForm allows.
+const maxFormSize = 10 << 20
+
+// SetTimeout limits the request to d by lowering PHP's max_execution_time
+// to MaxExecutionTime(d). A zero d leaves php.ini's limit in place.
+func (c *Context) SetTimeout(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.INI["max_execution_time"] = strconv.Itoa(MaxExecutionTime(d))
+}
+
+// MaxExecutionTime returns the max_execution_time, in whole seconds, that
+// makes PHP give up on a request a second before a timeout of d: PHP then
+// fails the request with its own fatal error instead of having the worker
+// cut off mid-response. Timeouts under two seconds get one second, the
+// smallest limit PHP accepts.
+func MaxExecutionTime(d time.Duration) int {
+	return max(1, int((d-time.Second)/time.Second))
+}
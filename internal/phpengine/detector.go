@@ -1,10 +1,27 @@
 package phpengine
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// entryCandidates are the entry points auto-detection tries, in priority
+// order.
+var entryCandidates = []string{
+	"public/index.php", // Laravel, Symfony, most frameworks
+	"index.php",        // WordPress, plain PHP
+	"app.php",          // Symfony (old structure)
+	"frontend.php",     // Custom
+	"main.php",         // Custom
+}
+
+// ErrEntryMissing is returned by CheckEntryPoint when the entry script
+// does not exist.
+var ErrEntryMissing = errors.New("entry script not found")
+
 // DetectEntryPoint finds the PHP entry point for the project.
 // Priority: explicit > auto-detect > default
 func DetectEntryPoint(docRoot, explicit string) string {
@@ -14,25 +31,46 @@ func DetectEntryPoint(docRoot, explicit string) string {
 	}
 
 	// 2. Auto-detect candidates (in priority order)
-	candidates := []string{
-		"public/index.php", // Laravel, Symfony, most frameworks
-		"index.php",        // WordPress, plain PHP
-		"app.php",          // Symfony (old structure)
-		"frontend.php",     // Custom
-		"main.php",         // Custom
-	}
-
-	for _, candidate := range candidates {
-		fullPath := filepath.Join(docRoot, candidate)
-		if _, err := os.Stat(fullPath); err == nil {
-			return candidate
-		}
+	if found := EntryCandidates(docRoot); len(found) > 0 {
+		return found[0]
 	}
 
 	// 3. Default fallback
 	return "index.php"
 }
 
+// EntryCandidates returns the auto-detection candidates present in
+// docRoot, in priority order.
+func EntryCandidates(docRoot string) []string {
+	var found []string
+	for _, candidate := range entryCandidates {
+		if isFile(filepath.Join(docRoot, candidate)) {
+			found = append(found, candidate)
+		}
+	}
+	return found
+}
+
+// CheckEntryPoint is DetectEntryPoint for an entry point that must exist.
+// When it does not, the ErrEntryMissing it returns with it lists the
+// candidates found in docRoot instead.
+func CheckEntryPoint(docRoot, explicit string) (string, error) {
+	entry := DetectEntryPoint(docRoot, explicit)
+	if isFile(filepath.Join(docRoot, entry)) {
+		return entry, nil
+	}
+	hint := "no candidates found, tried " + strings.Join(entryCandidates, ", ")
+	if found := EntryCandidates(docRoot); len(found) > 0 {
+		hint = "candidates found: " + strings.Join(found, ", ")
+	}
+	return entry, fmt.Errorf("%w: %s in %s (%s)", ErrEntryMissing, entry, docRoot, hint)
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
+
 // DetectFramework attempts to identify the PHP framework.
 func DetectFramework(docRoot string) string {
 	// Check for Laravel
@@ -1,8 +1,10 @@
 package phpengine_test
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/sadewadee/maboo/internal/phpengine"
@@ -52,6 +54,29 @@ func TestDetectEntryPointDefault(t *testing.T) {
 	}
 }
 
+func TestCheckEntryPoint(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	_, err := phpengine.CheckEntryPoint(tmpDir, "auto")
+	if !errors.Is(err, phpengine.ErrEntryMissing) || !strings.Contains(err.Error(), "no candidates found") {
+		t.Errorf("empty root: %v", err)
+	}
+
+	os.MkdirAll(filepath.Join(tmpDir, "index.php"), 0755) // a directory is not a script
+	os.WriteFile(filepath.Join(tmpDir, "app.php"), []byte("<?php"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "main.php"), []byte("<?php"), 0644)
+
+	_, err = phpengine.CheckEntryPoint(tmpDir, "public/index.php")
+	if !errors.Is(err, phpengine.ErrEntryMissing) || !strings.Contains(err.Error(), "candidates found: app.php, main.php") {
+		t.Errorf("missing explicit entry: %v", err)
+	}
+
+	entry, err := phpengine.CheckEntryPoint(tmpDir, "auto")
+	if err != nil || entry != "app.php" {
+		t.Errorf("auto = %q, %v; want app.php", entry, err)
+	}
+}
+
 func TestDetectFramework(t *testing.T) {
 	tests := []struct {
 		name      string
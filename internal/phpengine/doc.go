@@ -13,4 +13,16 @@
 //
 //	ctx := phpengine.NewContext(req, "/var/www", "public/index.php")
 //	resp, err := engine.Execute(ctx, "public/index.php")
+//
+// Go code calling PHP without an HTTP request describes the call as an
+// InternalRequest and runs it with Invoke, or with the Invoke method of
+// worker.Pool and server.Server, which pick a worker for it:
+//
+//	resp, err := pool.Invoke(ctx, phpengine.InternalRequest{
+//	    Entry:  "bin/warmup.php",
+//	    Caller: "warmup",
+//	})
+//
+// Programs outside this module cannot import it: the maboo package at
+// the module root exposes Engine, Context and InternalRequest to them.
 package phpengine
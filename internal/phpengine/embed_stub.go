@@ -0,0 +1,7 @@
+//go:build !php_embed
+
+package phpengine
+
+// EmbedCompiled reports whether the libphp bindings are compiled in (the
+// php_embed build tag). Without them the engine serves a placeholder page.
+const EmbedCompiled = false
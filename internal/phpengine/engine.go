@@ -1,8 +1,11 @@
 package phpengine
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
 )
@@ -18,14 +21,9 @@ type Engine struct {
 }
 
 // NewEngine creates a new embedded PHP engine for the specified version.
-// Valid versions: 7.4, 8.0, 8.1, 8.2, 8.3, 8.4
+// The version must be one of BundledVersions().
 func NewEngine(version string) (*Engine, error) {
-	validVersions := map[string]bool{
-		"7.4": true, "8.0": true, "8.1": true,
-		"8.2": true, "8.3": true, "8.4": true,
-	}
-
-	if !validVersions[version] {
+	if !IsBundled(version) {
 		return nil, fmt.Errorf("unsupported PHP version: %s", version)
 	}
 
@@ -78,17 +76,146 @@ func (e *Engine) Execute(ctx *Context, script string) (*Response, error) {
 		return nil, fmt.Errorf("engine not started")
 	}
 
-	// TODO: Call CGO php_execute()
+	// TODO: Call CGO php_context_set_ini() for each of ctx.INI, then
+	// php_execute() with ub_write writing to out, and ParseHeaderFields
+	// on resp->headers into Fields and Headers. open_basedir, disable_functions and allow_url_fopen
+	// are set at ZEND_INI_STAGE_ACTIVATE, as php_admin_value does, so
+	// the script cannot change them back; disable_functions also needs
+	// zend_disable_functions() for the request. A failed write must
+	// zend_bailout() so the script stops. A 103 sent by the script
+	// (headers_send(103)) goes to ctx.EarlyHints with its Link headers
+	// as soon as PHP flushes it.
 	// For now, return placeholder response
-	body := strings.ReplaceAll(placeholderHTML, "{{PHP_VERSION}}", e.version)
+	out := NewOutput(ctx.MaxResponseSize, nil)
+	if _, err := io.WriteString(out, strings.ReplaceAll(placeholderHTML, "{{PHP_VERSION}}", e.version)); err != nil {
+		return nil, fmt.Errorf("%s: %w", script, err)
+	}
 
-	return &Response{
+	resp := &Response{
 		Status: 200,
 		Headers: map[string]string{
 			"Content-Type": "text/html; charset=utf-8",
 		},
-		Body: []byte(body),
-	}, nil
+		Body: out.Bytes(),
+	}
+	// TODO: With ctx.CollectStats, call CGO php_request_stats(): opcache
+	// hits and misses counted around php_execute(), zend_hash_num_elements
+	// of EG(included_files), zend_memory_peak_usage() after
+	// zend_memory_reset_peak_usage(), and realpath_cache_size(). Until
+	// then Stats stays nil rather than reporting numbers nobody counted.
+	return resp, nil
+}
+
+// ErrInvalidateUnavailable is returned by Invalidate when the engine
+// cannot reach its opcache, as is the case without the libphp bindings.
+var ErrInvalidateUnavailable = errors.New("opcache invalidation needs the embedded engine built with libphp")
+
+// Invalidate drops files from the interpreter's opcache, so the next
+// include compiles them again. It is a no-op before Startup. Called with
+// no files, it only reports whether the engine can invalidate.
+func (e *Engine) Invalidate(files []string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.started {
+		return nil
+	}
+
+	// TODO: Call CGO opcache_invalidate(file, true) for each file
+	return ErrInvalidateUnavailable
+}
+
+// ErrCLIUnavailable is returned by ExecuteCLI when the engine cannot run
+// scripts under the CLI SAPI, as is the case without the libphp bindings.
+var ErrCLIUnavailable = errors.New("running PHP scripts in-process needs the embedded engine built with libphp")
+
+// ExecuteCLI runs script the way the php-cli SAPI would: args become
+// $argv[1:], env is added to the environment and output is collected
+// instead of sent to a client. ctx cancels a running script.
+func (e *Engine) ExecuteCLI(ctx context.Context, script string, args []string, env map[string]string) (*CLIResult, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.started {
+		return nil, fmt.Errorf("engine not started")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// TODO: Call CGO php_execute_script() under the CLI SAPI. Until then
+	// nothing runs, which must not pass for a clean exit.
+	return nil, ErrCLIUnavailable
+}
+
+// ErrEvalUnavailable is returned by Eval when the engine cannot evaluate
+// code, as is the case without the libphp bindings.
+var ErrEvalUnavailable = errors.New("PHP code evaluation needs the embedded engine built with libphp")
+
+// Eval runs code, PHP without the opening <?php tag, in a request of its
+// own with no superglobals from a client, and returns what it printed.
+// Output past maxOutput bytes (0 = unlimited) fails with
+// ErrResponseTooLarge, and ctx's deadline is the request's
+// max_execution_time. A snippet can still change the interpreter, by
+// declaring functions for instance, so callers should not serve requests
+// from the engine afterwards.
+func (e *Engine) Eval(ctx context.Context, code string, maxOutput int64) ([]byte, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.started {
+		return nil, fmt.Errorf("engine not started")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// TODO: Call CGO php_eval(): zend_eval_stringl() between
+	// php_request_startup() and php_request_shutdown(), with
+	// max_execution_time set from ctx's deadline and ub_write writing to
+	// NewOutput(maxOutput, nil). A failed write must zend_bailout().
+	return nil, ErrEvalUnavailable
+}
+
+// ErrBacktraceUnavailable is returned by Backtrace when the engine cannot
+// read the PHP stack, as is the case without the libphp bindings.
+var ErrBacktraceUnavailable = errors.New("PHP backtraces need the embedded engine built with libphp")
+
+// Backtrace returns the PHP call stack of the script the engine is
+// running, one frame per line as debug_print_backtrace prints it, or ""
+// when it runs none. It is called from another goroutine than the one
+// executing, so it is best-effort: the stack may unwind as it is read.
+func (e *Engine) Backtrace() (string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.started {
+		return "", fmt.Errorf("engine not started")
+	}
+
+	// TODO: Call CGO php_backtrace(): walk EG(current_execute_data) of
+	// the engine's thread, as zend_fetch_debug_backtrace() does, copying
+	// each frame's function, file and line while the executor is paused
+	// at its next EG(vm_interrupt).
+	return "", ErrBacktraceUnavailable
+}
+
+// Interrupt stops the script the engine is running at its next opcode,
+// failing its request, as when it runs out of max_execution_time. It is a
+// no-op when the engine runs nothing.
+func (e *Engine) Interrupt() {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	// TODO: Call CGO php_interrupt(): set EG(timed_out) and
+	// EG(vm_interrupt) on the engine's thread, so zend_timeout() bails
+	// out of the script.
+}
+
+// CLIResult is the outcome of ExecuteCLI.
+type CLIResult struct {
+	ExitCode int
+	Output   []byte // stdout and stderr, interleaved
 }
 
 // Response represents the result of PHP execution.
@@ -96,4 +223,11 @@ type Response struct {
 	Status  int
 	Headers map[string]string
 	Body    []byte
+
+	// Fields are the headers in the order and case the script sent them,
+	// when known; the router writes them instead of Headers with
+	// response_headers.preserve_case.
+	Fields []HeaderField
+
+	Stats *Stats // nil unless the Context asked for CollectStats and the engine could collect them
 }
@@ -1,7 +1,14 @@
 package phpengine_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/sadewadee/maboo/internal/phpengine"
 )
@@ -52,3 +59,118 @@ func TestEngineLifecycle(t *testing.T) {
 		t.Errorf("double shutdown failed: %v", err)
 	}
 }
+
+func TestExecuteCLI(t *testing.T) {
+	engine, err := phpengine.NewEngine("8.3")
+	if err != nil {
+		t.Skipf("CGO bindings not ready: %v", err)
+	}
+	if _, err := engine.ExecuteCLI(context.Background(), "artisan", nil, nil); err == nil {
+		t.Error("expected error before startup")
+	}
+
+	engine.Startup()
+	defer engine.Shutdown()
+	if _, err := engine.ExecuteCLI(context.Background(), "artisan", []string{"schedule:run"}, nil); !errors.Is(err, phpengine.ErrCLIUnavailable) {
+		t.Errorf("ExecuteCLI = %v, want ErrCLIUnavailable", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := engine.ExecuteCLI(ctx, "artisan", nil, nil); err != context.Canceled {
+		t.Errorf("ExecuteCLI with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestDiscoverVersions(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	for dir, names := range map[string][]string{
+		a: {"libphp-8.3.so", "libphp8.1.so", "libphp.so", "libphp-9.9.so"},
+		b: {"libphp8.3.dylib", "libphp-7.4.so", "README"},
+	} {
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	got := phpengine.DiscoverVersions([]string{a, filepath.Join(a, "missing"), b})
+	want := []string{"7.4", "8.1", "8.3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("DiscoverVersions = %v, want %v", got, want)
+	}
+}
+
+func TestBundledVersionsWithoutEmbed(t *testing.T) {
+	if phpengine.EmbedCompiled {
+		t.Skip("built with php_embed")
+	}
+	// The placeholder engine serves every supported version.
+	if got := phpengine.BundledVersions(); !slices.Equal(got, phpengine.SupportedVersions) {
+		t.Errorf("BundledVersions = %v, want %v", got, phpengine.SupportedVersions)
+	}
+}
+
+func TestMaxExecutionTime(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    int
+	}{
+		{30 * time.Second, 29},
+		{2500 * time.Millisecond, 1},
+		{500 * time.Millisecond, 1},
+		{5 * time.Minute, 299},
+	}
+	for _, tt := range tests {
+		if got := phpengine.MaxExecutionTime(tt.timeout); got != tt.want {
+			t.Errorf("MaxExecutionTime(%s) = %d, want %d", tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	out := phpengine.NewOutput(10, nil)
+	for _, chunk := range []string{"hello", "world"} {
+		if _, err := out.Write([]byte(chunk)); err != nil {
+			t.Fatalf("write within the limit: %v", err)
+		}
+	}
+	if n, err := out.Write([]byte("!")); n != 0 || !errors.Is(err, phpengine.ErrResponseTooLarge) {
+		t.Errorf("write past the limit = %d, %v", n, err)
+	}
+	if string(out.Bytes()) != "helloworld" || out.Len() != 10 {
+		t.Errorf("buffered %q (%d bytes)", out.Bytes(), out.Len())
+	}
+
+	// Streaming stops at the same point, with nothing buffered.
+	var sent bytes.Buffer
+	out = phpengine.NewOutput(8, &sent)
+	out.Write([]byte("12345"))
+	if _, err := out.Write([]byte("6789")); !errors.Is(err, phpengine.ErrResponseTooLarge) {
+		t.Errorf("streamed write past the limit: %v", err)
+	}
+	if sent.String() != "12345" || len(out.Bytes()) != 0 {
+		t.Errorf("sent %q, buffered %q", sent.String(), out.Bytes())
+	}
+
+	if _, err := phpengine.NewOutput(0, nil).Write(make([]byte, 1<<20)); err != nil {
+		t.Errorf("unlimited output: %v", err)
+	}
+}
+
+func TestExecuteResponseLimit(t *testing.T) {
+	engine, err := phpengine.NewEngine("8.3")
+	if err != nil {
+		t.Skipf("CGO bindings not ready: %v", err)
+	}
+	engine.Startup()
+	defer engine.Shutdown()
+
+	if _, err := engine.Execute(&phpengine.Context{MaxResponseSize: 64}, "index.php"); !errors.Is(err, phpengine.ErrResponseTooLarge) {
+		t.Errorf("Execute() error = %v, want ErrResponseTooLarge", err)
+	}
+	if _, err := engine.Execute(&phpengine.Context{}, "index.php"); err != nil {
+		t.Errorf("unlimited: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package phpengine
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// MaxHeaderBytes caps the header block a script may send, the way a
+// server caps the request headers it accepts.
+const MaxHeaderBytes = 64 << 10
+
+// HeaderField is one header of a response as the script sent it, the
+// name in its original case.
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// ParseHeaders parses the header block PHP's SAPI hands back with a
+// response into canonical names; see ParseHeaderFields. Repeated names
+// keep every value, in order, as Set-Cookie needs.
+func ParseHeaders(block []byte) (http.Header, error) {
+	fields, err := ParseHeaderFields(block)
+	if err != nil {
+		return nil, err
+	}
+	h := make(http.Header, len(fields))
+	for _, f := range fields {
+		key := http.CanonicalHeaderKey(f.Name)
+		h[key] = append(h[key], f.Value)
+	}
+	return h, nil
+}
+
+// ParseHeaderFields parses the header block PHP's SAPI hands back with a
+// response: "Name: value" lines ending in CRLF or LF, the last one
+// possibly unterminated. The fields keep the order and the case of the
+// block. Obsolete line folding (a line starting with a space or tab
+// continues the previous value) is joined with a single space.
+//
+// Anything that could not be written back verbatim is rejected rather
+// than repaired: a line without a colon, a name that is not an HTTP
+// token (including whitespace before the colon), a control character in
+// a value, or a block over MaxHeaderBytes. Bytes 0x80-0xFF are allowed in
+// values.
+func ParseHeaderFields(block []byte) ([]HeaderField, error) {
+	if len(block) > MaxHeaderBytes {
+		return nil, fmt.Errorf("header block of %d bytes exceeds %d", len(block), MaxHeaderBytes)
+	}
+	var fields []HeaderField
+	for n := 1; len(block) > 0; n++ {
+		var line []byte
+		line, block, _ = bytes.Cut(block, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("header line %d: continuation without a header", n)
+			}
+			last := &fields[len(fields)-1]
+			more := bytes.Trim(line, " \t")
+			if !ValidHeaderValue(string(more)) {
+				return nil, fmt.Errorf("header line %d: %s value contains a control character", n, http.CanonicalHeaderKey(last.Name))
+			}
+			if len(more) > 0 {
+				if last.Value != "" {
+					last.Value += " " + string(more)
+				} else {
+					last.Value = string(more)
+				}
+			}
+			continue
+		}
+
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			return nil, fmt.Errorf("header line %d: no colon", n)
+		}
+		if !ValidHeaderName(string(name)) {
+			return nil, fmt.Errorf("header line %d: invalid name %q", n, truncate(name, 64))
+		}
+		value = bytes.Trim(value, " \t")
+		if !ValidHeaderValue(string(value)) {
+			return nil, fmt.Errorf("header line %d: %s value contains a control character", n, http.CanonicalHeaderKey(string(name)))
+		}
+		fields = append(fields, HeaderField{Name: string(name), Value: string(value)})
+	}
+	return fields, nil
+}
+
+// ValidHeaderName reports whether name is an HTTP token (RFC 9110 5.1).
+func ValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidHeaderValue reports whether v can be written as a header value:
+// no control characters other than tab, so no CR or LF to end the header
+// early and start another, or the body.
+func ValidHeaderValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	switch c {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
+
+func truncate(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
+}
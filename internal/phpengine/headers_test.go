@@ -0,0 +1,135 @@
+package phpengine_test
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  http.Header
+	}{
+		{"crlf", "Content-Type: text/html\r\nX-Powered-By: PHP/8.3\r\n",
+			http.Header{"Content-Type": {"text/html"}, "X-Powered-By": {"PHP/8.3"}}},
+		{"lf only", "content-type: text/plain\nx-a: 1\n",
+			http.Header{"Content-Type": {"text/plain"}, "X-A": {"1"}}},
+		{"missing final crlf", "Location: /login\r\nX-A: 1",
+			http.Header{"Location": {"/login"}, "X-A": {"1"}}},
+		{"no space after colon", "X-A:1\r\n", http.Header{"X-A": {"1"}}},
+		{"colons in value", "Location: https://example.com:8443/a:b\r\n",
+			http.Header{"Location": {"https://example.com:8443/a:b"}}},
+		{"empty value", "X-Empty:\r\n", http.Header{"X-Empty": {""}}},
+		{"duplicates kept in order", "Set-Cookie: a=1\r\nSet-Cookie: b=2\r\nset-cookie: c=3\r\n",
+			http.Header{"Set-Cookie": {"a=1", "b=2", "c=3"}}},
+		{"obsolete folding", "X-Long: first\r\n  second\r\n\tthird\r\nX-B: 2\r\n",
+			http.Header{"X-Long": {"first second third"}, "X-B": {"2"}}},
+		{"folding onto an empty value", "X-A:\r\n b\r\n", http.Header{"X-A": {"b"}}},
+		{"8-bit value", "Content-Disposition: attachment; filename=\"r\xe9sum\xe9.pdf\"\r\n",
+			http.Header{"Content-Disposition": {"attachment; filename=\"r\xe9sum\xe9.pdf\""}}},
+		{"tab in value", "X-A: a\tb\r\n", http.Header{"X-A": {"a\tb"}}},
+		{"blank lines", "\r\nX-A: 1\r\n\r\n", http.Header{"X-A": {"1"}}},
+		{"empty", "", http.Header{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := phpengine.ParseHeaders([]byte(tt.block))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHeaderFields(t *testing.T) {
+	got, err := phpengine.ParseHeaderFields([]byte("x-Request-ID: 7\r\nSet-Cookie: a=1\r\nETag: \"v1\"\r\n folded\r\nset-cookie: b=2\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []phpengine.HeaderField{
+		{Name: "x-Request-ID", Value: "7"},
+		{Name: "Set-Cookie", Value: "a=1"},
+		{Name: "ETag", Value: "\"v1\" folded"},
+		{Name: "set-cookie", Value: "b=2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseHeadersRejects(t *testing.T) {
+	tests := []struct {
+		name, block, err string
+	}{
+		{"no colon", "X-A: 1\r\n<html>\r\n", "line 2: no colon"},
+		{"fold first", " continued\r\n", "continuation without a header"},
+		{"space before colon", "X-A : 1\r\n", "invalid name"},
+		{"empty name", ": 1\r\n", "invalid name"},
+		{"separator in name", "X(A): 1\r\n", "invalid name"},
+		{"8-bit name", "X-\xe9: 1\r\n", "invalid name"},
+		{"nul in value", "X-A: a\x00b\r\n", "X-A value contains a control character"},
+		{"bare cr in value", "X-A: a\rSet-Cookie: pwn=1\r\n", "control character"},
+		{"del in value", "X-A: a\x7f\r\n", "control character"},
+		{"control in fold", "X-A: a\r\n b\x01\r\n", "control character"},
+		{"too long", "X-A: " + strings.Repeat("a", phpengine.MaxHeaderBytes) + "\r\n", "exceeds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := phpengine.ParseHeaders([]byte(tt.block))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, %v; want error containing %q", h, err, tt.err)
+			}
+		})
+	}
+}
+
+func FuzzParseHeaders(f *testing.F) {
+	for _, seed := range []string{
+		"Content-Type: text/html\r\n",
+		"X-A:1\nX-A: 2",
+		"X-Long: a\r\n b\r\n",
+		"Set-Cookie: a=1\r\n\r\n<html>",
+		"X-A: a\rb\r\n",
+		"X-\xff: \xff\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, block []byte) {
+		h, err := phpengine.ParseHeaders(block)
+		if err != nil {
+			return
+		}
+		// Whatever is accepted can be written without splitting the
+		// response, and reads back the same.
+		var buf bytes.Buffer
+		for name, values := range h {
+			if !phpengine.ValidHeaderName(name) {
+				t.Fatalf("accepted name %q", name)
+			}
+			for _, v := range values {
+				if !phpengine.ValidHeaderValue(v) || strings.ContainsAny(v, "\r\n") {
+					t.Fatalf("accepted %s value %q", name, v)
+				}
+				buf.WriteString(name + ": " + v + "\r\n")
+			}
+		}
+		again, err := phpengine.ParseHeaders(buf.Bytes())
+		if err != nil {
+			t.Fatalf("reparsing %q: %v", buf.String(), err)
+		}
+		for name, values := range h {
+			if !reflect.DeepEqual(again[name], values) {
+				t.Fatalf("%s: %q, reparsed as %q", name, values, again[name])
+			}
+		}
+	})
+}
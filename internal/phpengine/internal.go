@@ -0,0 +1,150 @@
+package phpengine
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// InternalRequest is a call into PHP from Go code: the scheduler, health
+// probes, WebSocket hooks, or a program embedding maboo's packages. It
+// runs the same way an HTTP request does, without an http.Request or the
+// HTTP middleware.
+type InternalRequest struct {
+	Entry      string            // script to run, relative to the document root or absolute
+	DocRoot    string            // document root; the pool's app.root when empty
+	Method     string            // REQUEST_METHOD; GET when empty
+	Path       string            // REQUEST_URI, with an optional query string; "/"+Entry when empty
+	Headers    map[string]string // sent as HTTP_* in $_SERVER
+	Body       []byte            // a form-encoded body fills $_POST
+	ServerVars map[string]string // extra $_SERVER entries, set last
+	RemoteAddr string            // REMOTE_ADDR; 127.0.0.1 when empty
+
+	Group  string // worker group to run in; "" is the default group
+	Caller string // who is calling, the caller label of the internal request metrics
+}
+
+// Executor runs a prepared Context: worker.Pool, or an Engine of its own.
+type Executor interface {
+	Exec(ctx *Context, script string) (*Response, error)
+}
+
+// NewInternalContext builds the Context that runs r. A relative Entry is
+// taken from r.DocRoot, or docRoot without one; an absolute one runs with
+// its own directory as the document root.
+func NewInternalContext(r InternalRequest, docRoot string) *Context {
+	if r.DocRoot != "" {
+		docRoot = r.DocRoot
+	}
+	entry := r.Entry
+	if filepath.IsAbs(entry) {
+		docRoot, entry = filepath.Dir(entry), filepath.Base(entry)
+	}
+	entry = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(entry)), "/")
+	ctx := &Context{
+		Server:         make(map[string]string),
+		Get:            make(map[string]string),
+		Post:           make(map[string]string),
+		Cookies:        make(map[string]string),
+		Files:          make(map[string]File),
+		Env:            make(map[string]string),
+		INI:            make(map[string]string),
+		DocumentRoot:   docRoot,
+		ScriptFilename: filepath.Join(docRoot, entry),
+	}
+
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	uri := r.Path
+	if uri == "" {
+		uri = "/" + entry
+	}
+	p, query, _ := strings.Cut(uri, "?")
+	remote := r.RemoteAddr
+	if remote == "" {
+		remote = "127.0.0.1"
+	}
+
+	ctx.Server["REQUEST_METHOD"] = method
+	ctx.Server["REQUEST_URI"] = p
+	ctx.Server["QUERY_STRING"] = query
+	ctx.Server["SERVER_PROTOCOL"] = "HTTP/1.1"
+	ctx.Server["DOCUMENT_ROOT"] = docRoot
+	ctx.Server["SCRIPT_NAME"] = "/" + entry
+	ctx.Server["SCRIPT_FILENAME"] = ctx.ScriptFilename
+	ctx.Server["PHP_SELF"] = "/" + entry
+	ctx.Server["REMOTE_ADDR"] = remote
+
+	contentType := ""
+	for name, value := range r.Headers {
+		name = http.CanonicalHeaderKey(name)
+		switch name {
+		case "Content-Type":
+			contentType = value
+			ctx.Server["CONTENT_TYPE"] = value
+		case "Content-Length":
+			ctx.Server["CONTENT_LENGTH"] = value
+		case "Host":
+			ctx.Server["SERVER_NAME"] = value
+			fallthrough
+		default:
+			ctx.Server["HTTP_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = value
+		}
+	}
+
+	// Malformed pairs are skipped, as net/http does.
+	values, _ := url.ParseQuery(query)
+	for key, v := range values {
+		ctx.Get[key] = v[0]
+	}
+	if len(r.Body) > 0 {
+		ctx.Body = bytes.NewReader(r.Body)
+	}
+	if len(r.Body) > 0 && strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		values, _ := url.ParseQuery(string(r.Body))
+		for key, v := range values {
+			ctx.Post[key] = v[0]
+		}
+	}
+
+	for name, value := range r.ServerVars {
+		ctx.Server[name] = value
+	}
+	return ctx
+}
+
+// Invoke runs r on exec, with docRoot as in NewInternalContext, and
+// waits for it until ctx ends. Executors cannot cancel a script, so one
+// still running then finishes in the background.
+func Invoke(ctx context.Context, exec Executor, docRoot string, r InternalRequest) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	reqCtx := NewInternalContext(r, docRoot)
+	if deadline, ok := ctx.Deadline(); ok {
+		reqCtx.SetTimeout(time.Until(deadline))
+	}
+
+	type result struct {
+		resp *Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := exec.Exec(reqCtx, reqCtx.ScriptFilename)
+		done <- result{resp, err}
+	}()
+	select {
+	case res := <-done:
+		return res.resp, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
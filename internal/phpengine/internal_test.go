@@ -0,0 +1,100 @@
+package phpengine_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+func TestNewInternalContext(t *testing.T) {
+	ctx := phpengine.NewInternalContext(phpengine.InternalRequest{
+		Entry:      "cron/report.php",
+		Method:     "POST",
+		Path:       "/reports?year=2026",
+		Headers:    map[string]string{"content-type": "application/x-www-form-urlencoded", "X-Maboo-Schedule": "nightly", "Host": "app.test"},
+		Body:       []byte("format=csv&to=ops%40example.com"),
+		ServerVars: map[string]string{"REMOTE_ADDR": "10.0.0.9", "APP_STAGE": "cron"},
+	}, "/app")
+
+	for k, want := range map[string]string{
+		"REQUEST_METHOD":        "POST",
+		"REQUEST_URI":           "/reports",
+		"QUERY_STRING":          "year=2026",
+		"SCRIPT_FILENAME":       "/app/cron/report.php",
+		"SCRIPT_NAME":           "/cron/report.php",
+		"DOCUMENT_ROOT":         "/app",
+		"CONTENT_TYPE":          "application/x-www-form-urlencoded",
+		"HTTP_X_MABOO_SCHEDULE": "nightly",
+		"HTTP_HOST":             "app.test",
+		"SERVER_NAME":           "app.test",
+		"REMOTE_ADDR":           "10.0.0.9", // ServerVars win
+		"APP_STAGE":             "cron",
+	} {
+		if got := ctx.Server[k]; got != want {
+			t.Errorf("$_SERVER[%s] = %q, want %q", k, got, want)
+		}
+	}
+	if _, ok := ctx.Server["HTTP_CONTENT_TYPE"]; ok {
+		t.Error("Content-Type also sent as HTTP_CONTENT_TYPE")
+	}
+	if ctx.Get["year"] != "2026" || ctx.Post["format"] != "csv" || ctx.Post["to"] != "ops@example.com" {
+		t.Errorf("$_GET = %v, $_POST = %v", ctx.Get, ctx.Post)
+	}
+
+	// Defaults, and an absolute entry running from its own directory.
+	ctx = phpengine.NewInternalContext(phpengine.InternalRequest{Entry: "/srv/health/check.php"}, "/app")
+	if ctx.ScriptFilename != "/srv/health/check.php" || ctx.DocumentRoot != "/srv/health" {
+		t.Errorf("script %s in %s", ctx.ScriptFilename, ctx.DocumentRoot)
+	}
+	if ctx.Server["REQUEST_METHOD"] != "GET" || ctx.Server["REQUEST_URI"] != "/check.php" || ctx.Server["REMOTE_ADDR"] != "127.0.0.1" {
+		t.Errorf("defaults: $_SERVER = %v", ctx.Server)
+	}
+	ctx = phpengine.NewInternalContext(phpengine.InternalRequest{Entry: "ws.php", DocRoot: "/ws"}, "/app")
+	if ctx.ScriptFilename != "/ws/ws.php" {
+		t.Errorf("DocRoot ignored: script %s", ctx.ScriptFilename)
+	}
+}
+
+// execFunc adapts a function to phpengine.Executor.
+type execFunc func(*phpengine.Context, string) (*phpengine.Response, error)
+
+func (f execFunc) Exec(ctx *phpengine.Context, script string) (*phpengine.Response, error) {
+	return f(ctx, script)
+}
+
+func TestInvoke(t *testing.T) {
+	var got *phpengine.Context
+	resp, err := phpengine.Invoke(context.Background(), execFunc(func(ctx *phpengine.Context, script string) (*phpengine.Response, error) {
+		if script != ctx.ScriptFilename {
+			t.Errorf("ran %s for %s", script, ctx.ScriptFilename)
+		}
+		got = ctx
+		return &phpengine.Response{Status: 204}, nil
+	}), "/app", phpengine.InternalRequest{Entry: "index.php"})
+	if err != nil || resp.Status != 204 {
+		t.Fatalf("Invoke() = %v, %v", resp, err)
+	}
+	if got.INI["max_execution_time"] != "" {
+		t.Errorf("max_execution_time = %q without a deadline", got.INI["max_execution_time"])
+	}
+
+	// A deadline limits PHP, and the caller stops waiting when it passes.
+	release := make(chan struct{})
+	defer close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = phpengine.Invoke(ctx, execFunc(func(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+		if ctx.INI["max_execution_time"] != "1" {
+			t.Errorf("max_execution_time = %q, want 1", ctx.INI["max_execution_time"])
+		}
+		<-release
+		return &phpengine.Response{Status: 200}, nil
+	}), "/app", phpengine.InternalRequest{Entry: "slow.php"})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("Invoke() = %v after %s, want the deadline", err, time.Since(start))
+	}
+}
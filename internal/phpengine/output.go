@@ -0,0 +1,53 @@
+package phpengine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrResponseTooLarge is returned when a script's output passes the
+// request's MaxResponseSize. The worker that ran it is recycled, since the
+// script may have left the interpreter holding the memory.
+var ErrResponseTooLarge = errors.New("response exceeds server.max_response_size")
+
+// Output receives what a script prints, from the SAPI's ub_write. It
+// buffers the body, or with a stream passes it straight on, and fails
+// every write once limit bytes (0 = unlimited) would be passed. The engine
+// then bails out of the script. A streamed response has its status line
+// out by then, so the server aborts it with http.ErrAbortHandler and the
+// client sees the connection close instead of a short body.
+type Output struct {
+	limit  int64
+	n      int64
+	stream io.Writer // nil: buffer
+	buf    bytes.Buffer
+}
+
+// NewOutput returns an Output that buffers, or writes to stream when it
+// is not nil.
+func NewOutput(limit int64, stream io.Writer) *Output {
+	return &Output{limit: limit, stream: stream}
+}
+
+// Write takes p whole or, past the limit, not at all.
+func (o *Output) Write(p []byte) (int, error) {
+	if o.limit > 0 && o.n+int64(len(p)) > o.limit {
+		return 0, ErrResponseTooLarge
+	}
+	o.n += int64(len(p))
+	if o.stream != nil {
+		return o.stream.Write(p)
+	}
+	return o.buf.Write(p)
+}
+
+// Len returns the number of bytes written so far.
+func (o *Output) Len() int64 {
+	return o.n
+}
+
+// Bytes returns the buffered body; it is empty when streaming.
+func (o *Output) Bytes() []byte {
+	return o.buf.Bytes()
+}
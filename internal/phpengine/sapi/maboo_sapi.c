@@ -49,6 +49,14 @@ void php_context_set_env(php_context* ctx, const char* key, const char* value) {
     (void)value;
 }
 
+void php_context_set_ini(php_context* ctx, const char* key, const char* value) {
+    // TODO: Store in hash map, applied with zend_alter_ini_entry_chars()
+    // at ZEND_INI_STAGE_RUNTIME before the script runs
+    (void)ctx;
+    (void)key;
+    (void)value;
+}
+
 void php_context_set_document_root(php_context* ctx, const char* root) {
     if (ctx->document_root) free(ctx->document_root);
     ctx->document_root = strdup(root);
@@ -88,6 +96,29 @@ php_response* php_execute(php_context* ctx, const char* script) {
     return resp;
 }
 
+php_response* php_eval(const char* code, size_t code_len, int timeout) {
+    // TODO: php_request_startup(), zend_set_timeout(timeout),
+    // zend_eval_stringl(code, code_len, NULL, "maboo eval") in a
+    // zend_try block, then php_request_shutdown()
+    (void)code;
+    (void)code_len;
+    (void)timeout;
+    php_response* resp = calloc(1, sizeof(php_response));
+    resp->status = 501;
+    return resp;
+}
+
+char* php_backtrace(void) {
+    // TODO: set EG(vm_interrupt) and, from the interrupt handler, walk
+    // EG(current_execute_data) as zend_fetch_debug_backtrace() does,
+    // printing "#n file(line): function()" for each frame
+    return NULL;
+}
+
+void php_interrupt(void) {
+    // TODO: EG(timed_out) = 1; EG(vm_interrupt) = 1;
+}
+
 void php_response_free(php_response* resp) {
     if (resp) {
         if (resp->headers) free(resp->headers);
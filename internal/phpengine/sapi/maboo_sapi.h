@@ -16,6 +16,9 @@ void php_context_set_post(php_context* ctx, const char* key, const char* value);
 void php_context_set_cookie(php_context* ctx, const char* key, const char* value);
 void php_context_set_env(php_context* ctx, const char* key, const char* value);
 
+// Override a php.ini setting for this request only
+void php_context_set_ini(php_context* ctx, const char* key, const char* value);
+
 // Set document root and script
 void php_context_set_document_root(php_context* ctx, const char* root);
 void php_context_set_script_filename(php_context* ctx, const char* filename);
@@ -37,6 +40,17 @@ typedef struct {
 } php_response;
 
 php_response* php_execute(php_context* ctx, const char* script);
+
+// Evaluate code (PHP without the opening tag) in a request of its own,
+// stopping it after timeout seconds (0 = no limit)
+php_response* php_eval(const char* code, size_t code_len, int timeout);
 void php_response_free(php_response* resp);
 
+// Copy the PHP call stack of the running script, one frame per line, or
+// return NULL when none runs; the caller frees it
+char* php_backtrace(void);
+
+// Stop the running script at its next opcode
+void php_interrupt(void);
+
 #endif // MABOO_SAPI_H
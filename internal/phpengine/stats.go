@@ -0,0 +1,11 @@
+package phpengine
+
+// Stats is what one script run cost PHP, collected when its Context sets
+// CollectStats. An opcache miss compiled a file; hits reused a cached one.
+type Stats struct {
+	OpcacheHits       int64
+	OpcacheMisses     int64
+	IncludedFiles     int
+	PeakMemory        int64 // bytes, since the request started
+	RealpathCacheSize int64 // bytes held by the realpath cache afterwards
+}
@@ -0,0 +1,82 @@
+package phpengine
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// SupportedVersions are the PHP versions maboo can embed.
+var SupportedVersions = []string{"7.4", "8.0", "8.1", "8.2", "8.3", "8.4"}
+
+// IsSupported reports whether version is one of SupportedVersions.
+func IsSupported(version string) bool {
+	return slices.Contains(SupportedVersions, version)
+}
+
+var bundled struct {
+	once     sync.Once
+	versions []string
+}
+
+// BundledVersions returns the PHP versions this binary can run. With the
+// php_embed bindings compiled in, those are the libphp builds found in
+// LibDirs the first time it is called. Without them every supported
+// version is served by the placeholder engine.
+func BundledVersions() []string {
+	if !EmbedCompiled {
+		return slices.Clone(SupportedVersions)
+	}
+	bundled.once.Do(func() {
+		bundled.versions = DiscoverVersions(LibDirs())
+	})
+	return slices.Clone(bundled.versions)
+}
+
+// IsBundled reports whether version is one of BundledVersions.
+func IsBundled(version string) bool {
+	return slices.Contains(BundledVersions(), version)
+}
+
+// LibDirs lists the directories searched for libphp builds: the entries of
+// MABOO_PHP_LIB_DIR, lib/maboo next to the executable's directory, then
+// /usr/local/lib/maboo and /usr/lib/maboo.
+func LibDirs() []string {
+	var dirs []string
+	if env := os.Getenv("MABOO_PHP_LIB_DIR"); env != "" {
+		dirs = append(dirs, filepath.SplitList(env)...)
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Join(filepath.Dir(exe), "..", "lib", "maboo"))
+	}
+	return append(dirs, "/usr/local/lib/maboo", "/usr/lib/maboo")
+}
+
+// libphpName matches libphp-8.3.so, libphp8.3.so and their .dylib forms.
+var libphpName = regexp.MustCompile(`^libphp-?(\d+\.\d+)\.(so|dylib)$`)
+
+// DiscoverVersions returns the supported PHP versions that have a libphp
+// build in dirs, in SupportedVersions order.
+func DiscoverVersions(dirs []string) []string {
+	found := map[string]bool{}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if m := libphpName.FindStringSubmatch(e.Name()); m != nil {
+				found[m[1]] = true
+			}
+		}
+	}
+	var versions []string
+	for _, v := range SupportedVersions {
+		if found[v] {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
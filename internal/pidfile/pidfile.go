@@ -0,0 +1,152 @@
+// Package pidfile manages maboo's PID file. The running server holds an
+// exclusive flock on the file for its whole lifetime, so a file whose lock
+// can be taken belongs to a process that is gone (a stale file), whatever
+// PID it contains.
+package pidfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrLocked is returned by Acquire when a live instance holds the file.
+	ErrLocked = errors.New("another maboo instance is running")
+	// ErrNotRunning is returned by Read when the file is missing or stale.
+	ErrNotRunning = errors.New("maboo is not running")
+)
+
+// File is an acquired, locked PID file.
+type File struct {
+	path string
+	f    *os.File
+}
+
+// Acquire creates or takes over the PID file at path, locks it and writes
+// the current PID. It fails with ErrLocked if another process holds it.
+func Acquire(path string) (*File, error) {
+	return acquire(path, syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// AcquireWait is Acquire, but waits for the current holder to release the
+// file instead of failing. A binary upgrade uses it to take the file over
+// the moment the previous process hands it over.
+func AcquireWait(path string) (*File, error) {
+	return acquire(path, syscall.LOCK_EX)
+}
+
+func acquire(path string, how int) (*File, error) {
+	f, err := lock(path, how)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &File{path: path, f: f}, nil
+}
+
+// lock opens and locks path. A file removed by its previous owner between
+// our open and our lock is retried, so the lock is always on the file
+// that is at path.
+func lock(path string, how int) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), how); err != nil {
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				if pid, err := readPID(path); err == nil {
+					return nil, fmt.Errorf("%w (pid %d, %s)", ErrLocked, pid, path)
+				}
+				return nil, fmt.Errorf("%w (%s)", ErrLocked, path)
+			}
+			return nil, fmt.Errorf("locking %s: %w", path, err)
+		}
+		held, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err == nil && os.SameFile(held, current) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// Path returns the file's location.
+func (p *File) Path() string {
+	return p.path
+}
+
+// Release removes the file and drops the lock.
+func (p *File) Release() error {
+	err := os.Remove(p.path)
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Handover drops the lock but leaves the file in place for a process that
+// is taking over (a binary upgrade), which is waiting in AcquireWait. Commands
+// reading the file in between still find it.
+func (p *File) Handover() error {
+	return p.f.Close()
+}
+
+// Read returns the PID of the live instance holding path. It returns an
+// error wrapping ErrNotRunning when the file is missing, or when nothing
+// holds its lock because the process has exited.
+func Read(path string) (int, error) {
+	pid, err := readPID(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("%w (no pid file at %s)", ErrNotRunning, path)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !Locked(path) {
+		return 0, fmt.Errorf("%w (stale pid file %s, pid %d)", ErrNotRunning, path, pid)
+	}
+	return pid, nil
+}
+
+// Locked reports whether a process holds the lock on path.
+func Locked(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		return errors.Is(err, syscall.EWOULDBLOCK)
+	}
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false
+}
+
+func readPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%s does not contain a pid", path)
+	}
+	return pid, nil
+}
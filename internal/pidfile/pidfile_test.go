@@ -0,0 +1,109 @@
+package pidfile_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/pidfile"
+)
+
+func TestAcquireWritesAndReleases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maboo.pid")
+
+	p, err := pidfile.Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := pidfile.Read(path)
+	if err != nil || pid != os.Getpid() {
+		t.Fatalf("Read = %d, %v; want %d", pid, err, os.Getpid())
+	}
+
+	if err := p.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pid file still present after Release: %v", err)
+	}
+	if _, err := pidfile.Read(path); !errors.Is(err, pidfile.ErrNotRunning) {
+		t.Errorf("Read after Release = %v, want ErrNotRunning", err)
+	}
+}
+
+func TestAcquireRefusesLiveInstance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maboo.pid")
+	p, err := pidfile.Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	_, err = pidfile.Acquire(path)
+	if !errors.Is(err, pidfile.ErrLocked) {
+		t.Fatalf("second Acquire = %v, want ErrLocked", err)
+	}
+	if want := "pid " + strconv.Itoa(os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not name the running %s", err, want)
+	}
+}
+
+func TestStalePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maboo.pid")
+	// Left behind by a crashed process: a PID but no lock holder.
+	if err := os.WriteFile(path, []byte("999999\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pidfile.Read(path); !errors.Is(err, pidfile.ErrNotRunning) {
+		t.Errorf("Read of stale file = %v, want ErrNotRunning", err)
+	}
+
+	p, err := pidfile.Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire over stale file: %v", err)
+	}
+	defer p.Release()
+	if pid, err := pidfile.Read(path); err != nil || pid != os.Getpid() {
+		t.Errorf("Read = %d, %v; want own pid", pid, err)
+	}
+}
+
+func TestHandoverToAcquireWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maboo.pid")
+	old, err := pidfile.Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan *pidfile.File)
+	go func() {
+		p, err := pidfile.AcquireWait(path)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- p
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("AcquireWait returned while the file was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := old.Handover(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-acquired:
+		defer p.Release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("AcquireWait did not take the file over")
+	}
+	if !pidfile.Locked(path) {
+		t.Error("file not locked after the takeover")
+	}
+}
@@ -0,0 +1,112 @@
+package pool
+
+import "time"
+
+// debouncer collects file changes into one reload. A reload is due once
+// no change has arrived for debounce, or maxDelay after the first change
+// of the batch when changes keep coming, but never sooner than cooldown
+// after the previous reload.
+type debouncer struct {
+	debounce time.Duration
+	maxDelay time.Duration // 0 = no ceiling
+	cooldown time.Duration
+
+	batch      batch
+	seen       map[string]bool
+	first      time.Time
+	last       time.Time
+	lastReload time.Time
+
+	timer *time.Timer
+}
+
+// batch is the set of changes handled by one reload.
+type batch struct {
+	paths  []string
+	events int
+	reload bool // a file with ActionReload changed
+	config bool // a file with ActionConfig changed
+	opaque bool // some changes could not be listed file by file
+}
+
+func newDebouncer(debounce, maxDelay, cooldown time.Duration) *debouncer {
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	return &debouncer{
+		debounce: debounce,
+		maxDelay: maxDelay,
+		cooldown: cooldown,
+		seen:     make(map[string]bool),
+		timer:    t,
+	}
+}
+
+// add records a change to path ("" for a change that cannot be
+// attributed, such as dropped events) asking for action, and re-arms the
+// timer.
+func (d *debouncer) add(path string, action Action, now time.Time) {
+	if d.batch.events == 0 {
+		d.first = now
+	}
+	d.batch.events++
+	switch action {
+	case ActionReload:
+		d.batch.reload = true
+	case ActionConfig:
+		d.batch.config = true
+	}
+	if path == "" {
+		d.batch.opaque = true
+	}
+	d.last = now
+	if path != "" && !d.seen[path] {
+		d.seen[path] = true
+		d.batch.paths = append(d.batch.paths, path)
+	}
+	d.timer.Reset(d.deadline().Sub(now))
+}
+
+// markOpaque notes that the pending batch holds changes that cannot be
+// listed file by file, such as a removed directory.
+func (d *debouncer) markOpaque() {
+	d.batch.opaque = true
+}
+
+// C fires when the pending batch may be due.
+func (d *debouncer) C() <-chan time.Time {
+	return d.timer.C
+}
+
+// deadline is when the pending batch is due.
+func (d *debouncer) deadline() time.Time {
+	due := d.last.Add(d.debounce)
+	if d.maxDelay > 0 {
+		if ceiling := d.first.Add(d.maxDelay); ceiling.Before(due) {
+			due = ceiling
+		}
+	}
+	if earliest := d.lastReload.Add(d.cooldown); due.Before(earliest) {
+		due = earliest
+	}
+	return due
+}
+
+// take returns the pending batch if it is due at now, and starts a new
+// one. ok is false (and the timer re-armed) when it is not due yet.
+func (d *debouncer) take(now time.Time) (b batch, ok bool) {
+	if d.batch.events == 0 {
+		return batch{}, false
+	}
+	if due := d.deadline(); now.Before(due) {
+		d.timer.Reset(due.Sub(now))
+		return batch{}, false
+	}
+	b, d.batch = d.batch, batch{}
+	d.seen = make(map[string]bool)
+	d.lastReload = now
+	return b, true
+}
+
+func (d *debouncer) stop() {
+	d.timer.Stop()
+}
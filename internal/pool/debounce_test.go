@@ -0,0 +1,82 @@
+package pool
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	t0 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return t0.Add(time.Duration(ms) * time.Millisecond) }
+
+	d := newDebouncer(500*time.Millisecond, 2*time.Second, time.Second)
+	defer d.stop()
+
+	if _, ok := d.take(at(0)); ok {
+		t.Fatal("take succeeded with nothing pending")
+	}
+
+	// Debounce: due 500ms after the last change.
+	d.add("a.php", ActionReload, at(0))
+	d.add("b.php", ActionReload, at(300))
+	d.add("a.php", ActionReload, at(400))
+	if _, ok := d.take(at(800)); ok {
+		t.Error("batch due before the quiet period ended")
+	}
+	b, ok := d.take(at(900))
+	if !ok || b.events != 3 || !slices.Equal(b.paths, []string{"a.php", "b.php"}) {
+		t.Errorf("take = %v, %d, %v; want [a.php b.php], 3, true", b.paths, b.events, ok)
+	}
+
+	// Cooldown: a change right after the reload waits a second for it.
+	d.add("c.php", ActionReload, at(1000))
+	if _, ok := d.take(at(1500)); ok {
+		t.Error("batch due inside the cooldown")
+	}
+	if _, ok := d.take(at(1900)); !ok {
+		t.Error("batch not due after the cooldown")
+	}
+
+	// Max delay: changes every 400ms never go quiet for 500ms, but the
+	// batch is due 2s after it began.
+	for ms := 3000; ms < 5000; ms += 400 {
+		d.add("d.php", ActionReload, at(ms))
+	}
+	if got, want := d.deadline(), at(5000); !got.Equal(want) {
+		t.Errorf("deadline = %v, want the 2s ceiling %v", got, want)
+	}
+}
+
+func TestDebouncerUnattributedChange(t *testing.T) {
+	d := newDebouncer(0, 0, 0)
+	defer d.stop()
+	d.add("", ActionReload, time.Now())
+	b, ok := d.take(time.Now())
+	if !ok || b.events != 1 || len(b.paths) != 0 {
+		t.Errorf("take = %v, %d, %v; want no paths, 1 event", b.paths, b.events, ok)
+	}
+}
+
+func TestDebouncerActions(t *testing.T) {
+	d := newDebouncer(0, 0, 0)
+	defer d.stop()
+	now := time.Now()
+	d.add("storage/app.log", ActionLog, now)
+	d.add(".env", ActionConfig, now)
+	d.add("app/User.php", ActionReload, now)
+	if b, ok := d.take(now); !ok || !b.reload || !b.config || b.opaque {
+		t.Errorf("batch = %+v, want reload and config", b)
+	}
+
+	d.add("storage/app.log", ActionLog, now)
+	if b, ok := d.take(now); !ok || b.reload || b.config {
+		t.Errorf("batch = %+v, want a log-only batch", b)
+	}
+
+	d.add("app/User.php", ActionReload, now)
+	d.markOpaque()
+	if b, ok := d.take(now); !ok || !b.opaque {
+		t.Errorf("batch = %+v, want opaque", b)
+	}
+}
@@ -0,0 +1,204 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/features"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/protocol"
+)
+
+// ErrNoWorker is returned by Dump and Kill for a worker the pool does not
+// have, as one already recycled.
+var ErrNoWorker = errors.New("no such worker")
+
+// backtraceWait bounds how long Dump waits for a worker to answer
+// SIGUSR2. PHP runs the handler between two opcodes, so a script blocked
+// in a call, on a database say, answers only once the call returns.
+const backtraceWait = time.Second
+
+// activity is the REQUEST a worker is serving, decoded only when dumped.
+type activity struct {
+	req     *protocol.Frame
+	started time.Time
+}
+
+// WorkerDump is what a worker process is doing; see Pool.Dump.
+type WorkerDump struct {
+	ID      int
+	State   WorkerState
+	Jobs    int64
+	Request *protocol.RequestHeader // nil while idle
+	Started time.Time               // when Request was sent
+
+	Backtrace    string // the PHP stack of Request
+	BacktraceErr error  // why a busy worker has no Backtrace
+}
+
+// String returns the state as the admin API reports it.
+func (s WorkerState) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateBusy:
+		return "busy"
+	case StateDraining:
+		return "draining"
+	default:
+		return "stopped"
+	}
+}
+
+// logLevel maps the level of a LOG record to slog's, info when unknown.
+func logLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// declares reports whether the worker declared feature f. Unlike
+// Implements, it is false for a worker that declared nothing, which may
+// predate f.
+func (w *Worker) declares(f string) bool {
+	return w.features.Has(f)
+}
+
+// Backtrace asks the worker for the PHP stack of its request with
+// SIGUSR2 and waits up to wait for the LOG frame it answers with. Only a
+// worker that declared features.Dump may be sent the signal: PHP's
+// default action for it ends the process.
+func (w *Worker) Backtrace(wait time.Duration) (string, error) {
+	w.traceMu.Lock()
+	defer w.traceMu.Unlock()
+
+	select {
+	case <-w.traces: // a late answer to an earlier dump
+	default:
+	}
+	if err := w.cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		return "", fmt.Errorf("signalling worker %d: %w", w.id, err)
+	}
+	select {
+	case trace := <-w.traces:
+		return trace, nil
+	case <-time.After(wait):
+		return "", fmt.Errorf("worker %d sent no backtrace within %s", w.id, wait)
+	}
+}
+
+// kill ends the worker process at once.
+func (w *Worker) kill() error {
+	if err := w.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("killing worker %d: %w", w.id, err)
+	}
+	return nil
+}
+
+// worker returns the pool's worker id, or nil.
+func (p *Pool) worker(id int) *Worker {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, w := range p.workers {
+		if w.ID() == id {
+			return w
+		}
+	}
+	return nil
+}
+
+// dump reports what w is doing, without a backtrace.
+func (w *Worker) dump() WorkerDump {
+	d := WorkerDump{ID: w.ID(), State: w.State(), Jobs: w.Jobs()}
+	if a := w.current.Load(); a != nil {
+		d.Started = a.started
+		if h, _, err := protocol.DecodeRequest(a.req); err == nil {
+			d.Request = h
+		}
+	}
+	return d
+}
+
+// Dump reports what worker id is doing. A busy worker that declared
+// features.Dump is asked for the backtrace of its request as well.
+func (p *Pool) Dump(id int) (WorkerDump, error) {
+	w := p.worker(id)
+	if w == nil {
+		return WorkerDump{}, ErrNoWorker
+	}
+	d := w.dump()
+	if d.Started.IsZero() {
+		return d, nil
+	}
+	if !p.features.Load().Has(features.Dump) || !w.declares(features.Dump) {
+		d.BacktraceErr = fmt.Errorf("worker %d does not implement %s", id, features.Dump)
+		return d, nil
+	}
+	d.Backtrace, d.BacktraceErr = w.Backtrace(backtraceWait)
+	return d, nil
+}
+
+// Kill replaces worker id. An idle worker is replaced at once. A busy one
+// has its process killed, which fails its request, and is replaced then.
+func (p *Pool) Kill(id int) error {
+	w := p.worker(id)
+	if w == nil || w.State() == StateStopped {
+		return ErrNoWorker
+	}
+	if !w.killed.CompareAndSwap(false, true) {
+		return nil // already being replaced
+	}
+	p.logger.Info("worker killed", "worker_id", id, "jobs", w.Jobs())
+	if w.retireIdle() {
+		metrics.RecordWorkerRecycle(metrics.RecycleKilled)
+		p.dropRetired()
+		go p.replaceWorker(w)
+		return nil
+	}
+	// run replaces it once its request fails.
+	return w.kill()
+}
+
+// Busy reports the workers serving a request, the longest-running first.
+// It asks none for a backtrace, so it answers even while the pool is
+// stuck stopping.
+func (p *Pool) Busy() []WorkerDump {
+	p.mu.RLock()
+	workers := slices.Clone(p.workers)
+	p.mu.RUnlock()
+	var busy []WorkerDump
+	for _, w := range workers {
+		if d := w.dump(); !d.Started.IsZero() {
+			busy = append(busy, d)
+		}
+	}
+	slices.SortFunc(busy, func(a, b WorkerDump) int { return a.Started.Compare(b.Started) })
+	return busy
+}
+
+// Abort kills every worker process at once, for a shutdown that cannot
+// wait for them. The pool cannot be used afterwards.
+func (p *Pool) Abort() {
+	p.cancel()
+	p.mu.RLock()
+	workers := slices.Clone(p.workers)
+	p.mu.RUnlock()
+	for _, w := range workers {
+		if err := w.kill(); err != nil {
+			p.logger.Warn("aborting worker", "worker_id", w.ID(), "error", err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package pool_test
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/features"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/protocol"
+)
+
+// dumpScript, as the worker script, starts dumpWorker in place of
+// fakeWorker.
+const dumpScript = "dump"
+
+// dumpWorker declares dump and answers SIGUSR2 with a backtrace while it
+// serves a request, as Maboo\Worker does. A request to /slow is answered once the worker
+// has been dumped, one to /hang never.
+func dumpWorker() int {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR2)
+	first, err := protocol.EncodeWorkerReady([]string{features.Dump})
+	if err != nil {
+		return 1
+	}
+	if err := protocol.WriteFrame(os.Stdout, first); err != nil {
+		return 1
+	}
+	for {
+		f, err := protocol.ReadFrame(os.Stdin)
+		if err != nil || f.Type == protocol.TypeWorkerStop {
+			return 0
+		}
+		h, _, err := protocol.DecodeRequest(f)
+		if err != nil {
+			return 1
+		}
+		if h.URI == "/hang" {
+			select {}
+		}
+		<-sigs
+		for _, rec := range []*protocol.LogRecord{
+			{Level: "info", Message: "dumping"},
+			{Level: "info", Message: "backtrace", Backtrace: "#0 /app/slow.php(3): sleep(10)\n#1 {main}"},
+		} {
+			frame, err := protocol.EncodeLog(rec)
+			if err != nil {
+				return 1
+			}
+			if err := protocol.WriteFrame(os.Stdout, frame); err != nil {
+				return 1
+			}
+		}
+		resp, err := protocol.EncodeResponse(&protocol.ResponseHeader{Status: 200}, []byte("done"))
+		if err != nil {
+			return 1
+		}
+		if err := protocol.WriteFrame(os.Stdout, resp); err != nil {
+			return 1
+		}
+		if err := protocol.WriteFrame(os.Stdout, protocol.NewWorkerReadyFrame()); err != nil {
+			return 1
+		}
+	}
+}
+
+// busyWorker waits for a worker of p to be serving a request and returns
+// its dump.
+func busyWorker(t *testing.T, p *pool.Pool) pool.WorkerDump {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for id := range 10 {
+			if d, err := p.Dump(id); err == nil && d.Request != nil {
+				return d
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no worker became busy")
+	return pool.WorkerDump{}
+}
+
+func TestPoolDump(t *testing.T) {
+	p := startPool(t, dumpScript)
+	req, err := protocol.EncodeRequest(&protocol.RequestHeader{
+		Method: "GET", URI: "/slow", Headers: map[string]string{"X-Request-Id": "abc123"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Exec(req)
+		done <- err
+	}()
+
+	d := busyWorker(t, p)
+	// The busy worker was dumped while busyWorker looked for it; this one
+	// answers with the backtrace.
+	if d.State != pool.StateBusy || d.Request.URI != "/slow" || d.Request.Headers["X-Request-Id"] != "abc123" || d.Started.IsZero() {
+		t.Errorf("dump = %+v", d)
+	}
+	if d.BacktraceErr != nil || !strings.Contains(d.Backtrace, "sleep(10)") {
+		t.Errorf("backtrace = %q, %v", d.Backtrace, d.BacktraceErr)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("request after the dump: %v", err)
+	}
+
+	if d, err := p.Dump(d.ID); err != nil || d.Request != nil || d.State != pool.StateIdle {
+		t.Errorf("dump once idle = %+v, %v", d, err)
+	}
+	if _, err := p.Dump(99); !errors.Is(err, pool.ErrNoWorker) {
+		t.Errorf("Dump(99) error = %v, want ErrNoWorker", err)
+	}
+}
+
+func TestPoolKill(t *testing.T) {
+	p := startPool(t, dumpScript)
+	req, err := protocol.EncodeRequest(&protocol.RequestHeader{Method: "GET", URI: "/hang"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Exec(req)
+		done <- err
+	}()
+
+	// The request of a busy worker fails as it is killed.
+	busy := busyWorker(t, p)
+	if err := p.Kill(busy.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "killed") {
+			t.Errorf("request on the killed worker: error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request still running on the killed worker")
+	}
+
+	// An idle one is replaced at once.
+	idle := 1
+	if busy.ID == 1 {
+		idle = 2
+	}
+	if err := p.Kill(idle); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Kill(99); !errors.Is(err, pool.ErrNoWorker) {
+		t.Errorf("Kill(99) error = %v, want ErrNoWorker", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, errBusy := p.Dump(busy.ID)
+		_, errIdle := p.Dump(idle)
+		if errors.Is(errBusy, pool.ErrNoWorker) && errors.Is(errIdle, pool.ErrNoWorker) && p.Stats().TotalWorkers == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("killed workers not replaced: %+v", p.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPoolBusyAndAbort(t *testing.T) {
+	p := startPool(t, dumpScript)
+	req, err := protocol.EncodeRequest(&protocol.RequestHeader{Method: "GET", URI: "/hang"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Exec(req)
+		done <- err
+	}()
+
+	d := busyWorker(t, p)
+	busy := p.Busy()
+	if len(busy) != 1 || busy[0].ID != d.ID || busy[0].Request.URI != "/hang" || busy[0].Backtrace != "" {
+		t.Errorf("Busy() = %+v", busy)
+	}
+
+	p.Abort()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("request on an aborted pool succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request still running after Abort")
+	}
+}
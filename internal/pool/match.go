@@ -0,0 +1,115 @@
+package pool
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/glob"
+)
+
+// Action is what a changed file asks the watcher's owner to do.
+type Action string
+
+const (
+	ActionReload Action = config.WatchActionReload
+	ActionConfig Action = config.WatchActionConfig
+	ActionLog    Action = config.WatchActionLog
+
+	// ActionInvalidate replaces ActionReload under watch.strategy
+	// invalidate: drop the changed files from the workers' opcache.
+	ActionInvalidate Action = "invalidate"
+)
+
+type watchRule struct {
+	glob   *glob.Glob
+	action Action
+}
+
+// matcher decides which files the watcher cares about, from
+// watch.exclude, watch.include, watch.full_reload and watch.extensions.
+type matcher struct {
+	exts       []string
+	include    []watchRule
+	exclude    []*glob.Glob
+	fullReload []*glob.Glob
+}
+
+func newMatcher(cfg config.WatchConfig) (*matcher, error) {
+	m := &matcher{}
+	for _, ext := range cfg.Extensions {
+		m.exts = append(m.exts, strings.ToLower(ext))
+	}
+	for i, r := range cfg.Include {
+		g, err := glob.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("watch.include[%d].pattern: %w", i, err)
+		}
+		action := Action(r.Action)
+		if action == "" {
+			action = ActionReload
+		}
+		m.include = append(m.include, watchRule{glob: g, action: action})
+	}
+	for i, p := range cfg.Exclude {
+		g, err := glob.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("watch.exclude[%d]: %w", i, err)
+		}
+		m.exclude = append(m.exclude, g)
+	}
+	for i, p := range cfg.FullReload {
+		g, err := glob.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("watch.full_reload[%d]: %w", i, err)
+		}
+		m.fullReload = append(m.fullReload, g)
+	}
+	return m, nil
+}
+
+// match returns the action for the file at rel, a slash-separated path
+// relative to its watched directory, and false for files to ignore.
+func (m *matcher) match(rel string) (Action, bool) {
+	if m.excluded(rel) {
+		return "", false
+	}
+	for _, r := range m.include {
+		if r.glob.Match(rel) {
+			return r.action, true
+		}
+	}
+	if m.needsFullReload(rel) {
+		return ActionReload, true
+	}
+	ext := strings.ToLower(filepath.Ext(rel))
+	for _, e := range m.exts {
+		if ext == e {
+			return ActionReload, true
+		}
+	}
+	return "", false
+}
+
+// needsFullReload reports whether a change to rel always restarts the
+// workers, whatever the strategy.
+func (m *matcher) needsFullReload(rel string) bool {
+	for _, g := range m.fullReload {
+		if g.Match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether rel, a file or directory, matches an exclude
+// pattern.
+func (m *matcher) excluded(rel string) bool {
+	for _, g := range m.exclude {
+		if g.Match(rel) {
+			return true
+		}
+	}
+	return false
+}
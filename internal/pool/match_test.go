@@ -0,0 +1,55 @@
+package pool
+
+import (
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestMatcher(t *testing.T) {
+	cfg := config.Default().Watch
+	cfg.Extensions = []string{".php", ".Twig"}
+	cfg.Include = []config.WatchRule{
+		{Pattern: ".env", Action: config.WatchActionConfig},
+		{Pattern: "config/**/*.yaml", Action: config.WatchActionConfig},
+		{Pattern: "storage/logs/*.log", Action: config.WatchActionLog},
+		{Pattern: "bin/console"},
+		{Pattern: "**/*.php", Action: config.WatchActionLog}, // extensions come last
+	}
+	m, err := newMatcher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rel    string
+		action Action
+		ok     bool
+	}{
+		{".env", ActionConfig, true},
+		{"app/.env", "", false},
+		{"config/packages/prod/monolog.yaml", ActionConfig, true},
+		{"config/app.yaml", ActionConfig, true},
+		{"storage/logs/app.log", ActionLog, true},
+		{"bin/console", ActionReload, true},
+		{"app/User.php", ActionLog, true},
+		{"templates/base.TWIG", ActionReload, true}, // extensions ignore case
+		{"README.md", "", false},
+		{"vendor/autoload.php", "", false}, // excludes win over includes
+		{"modules/billing/node_modules/x.php", "", false},
+	}
+	for _, tt := range tests {
+		action, ok := m.match(tt.rel)
+		if action != tt.action || ok != tt.ok {
+			t.Errorf("match(%q) = %q, %v; want %q, %v", tt.rel, action, ok, tt.action, tt.ok)
+		}
+	}
+}
+
+func TestMatcherInvalidPattern(t *testing.T) {
+	cfg := config.Default().Watch
+	cfg.Exclude = []string{"storage/[a-"}
+	if _, err := newMatcher(cfg); err == nil {
+		t.Error("newMatcher accepted an invalid exclude pattern")
+	}
+}
@@ -2,21 +2,29 @@ package pool
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/features"
+	"github.com/sadewadee/maboo/internal/metrics"
 	"github.com/sadewadee/maboo/internal/protocol"
 )
 
 // Pool manages a pool of PHP worker processes.
 type Pool struct {
-	cfg    config.PoolConfig
-	php    config.PHPConfig
-	logger *slog.Logger
+	cfg      config.PoolConfig
+	php      config.PHPConfig
+	features atomic.Pointer[features.Set] // offered to the workers; see SetFeatures
+	logger   *slog.Logger
 
 	workers   []*Worker
 	mu        sync.RWMutex
@@ -26,6 +34,26 @@ type Pool struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	// replaceMu is held by replaceWorker while it hands a new worker to
+	// available, so that Stop does not close the channel under it.
+	replaceMu sync.RWMutex
+
+	// affinity maps a stream connection to the worker that served its
+	// last event; see ExecStream.
+	affinityMu sync.Mutex
+	affinity   map[string]*Worker
+
+	crashMu  sync.Mutex
+	reporter errreport.Reporter
+	crashes  []time.Time // recent crashes, see recordCrash
+
+	// cfg.OnDemand: whether a cold start is under way (guarded by mu),
+	// when a request last asked for a worker, and when a cold start last
+	// failed (0 once one succeeds), as Unix nanoseconds.
+	coldStarting bool
+	lastUsed     atomic.Int64
+	coldFailed   atomic.Int64
+
 	// Metrics
 	totalRequests atomic.Int64
 	activeWorkers atomic.Int32
@@ -41,13 +69,46 @@ func New(poolCfg config.PoolConfig, phpCfg config.PHPConfig, logger *slog.Logger
 		php:       phpCfg,
 		logger:    logger,
 		available: make(chan *Worker, poolCfg.MaxWorkers),
+		affinity:  make(map[string]*Worker),
+		reporter:  errreport.Nop{},
 		ctx:       ctx,
 		cancel:    cancel,
 	}
+	p.SetFeatures(features.All)
 
 	return p
 }
 
+// SetReporter sends crash loops to r as well as the log.
+func (p *Pool) SetReporter(r errreport.Reporter) {
+	p.crashMu.Lock()
+	p.reporter = r
+	p.crashMu.Unlock()
+}
+
+// SetFeatures sets the features the pool offers, all of them unless
+// called. They are used with the workers that implement them too, and
+// advertised to the worker processes started from then on.
+func (p *Pool) SetFeatures(offered features.Set) {
+	p.features.Store(&offered)
+}
+
+// uses reports whether the pool uses feature f with w.
+func (p *Pool) uses(w *Worker, f string) bool {
+	return p.features.Load().Has(f) && w.Implements(f)
+}
+
+// negotiated returns the features the pool uses with w.
+func (p *Pool) negotiated(w *Worker) features.Set {
+	var s features.Set
+	for _, f := range *p.features.Load() {
+		if w.Implements(f) {
+			s = append(s, f)
+		}
+	}
+	return s
+}
+
 // Start initializes the pool by spawning the minimum number of workers.
 func (p *Pool) Start() error {
 	p.logger.Info("starting worker pool",
@@ -55,9 +116,16 @@ func (p *Pool) Start() error {
 		"max_workers", p.cfg.MaxWorkers,
 		"max_jobs", p.cfg.MaxJobs,
 		"max_memory", p.cfg.MaxMemory,
+		"on_demand", p.cfg.OnDemand,
 	)
 
-	for i := 0; i < p.cfg.MinWorkers; i++ {
+	// An on-demand pool starts its first worker for its first request.
+	initial := p.cfg.MinWorkers
+	if p.cfg.OnDemand {
+		initial = 0
+	}
+	p.lastUsed.Store(time.Now().UnixNano())
+	for i := 0; i < initial; i++ {
 		w, err := p.spawnWorker()
 		if err != nil {
 			return fmt.Errorf("spawning initial worker %d: %w", i, err)
@@ -73,76 +141,302 @@ func (p *Pool) Start() error {
 
 // Exec dispatches a request to an available worker and returns the response.
 func (p *Pool) Exec(req *protocol.Frame) (*protocol.Frame, error) {
+	resp, _, err := p.exec(req)
+	return resp, err
+}
+
+// ExecDebug is Exec for a REQUEST frame that also returns what the request
+// cost the worker, which collects the statistics for this request only.
+func (p *Pool) ExecDebug(req *protocol.Frame) (*protocol.Frame, *protocol.WorkerStats, error) {
+	if req.Type != protocol.TypeRequest {
+		return nil, nil, fmt.Errorf("expected REQUEST frame, got type 0x%02x", req.Type)
+	}
+	debug := *req
+	debug.Flags |= protocol.FlagDebug
+	return p.exec(&debug)
+}
+
+// exec runs req on an idle worker. A request that could not be sent to
+// its worker, because the process was gone or stopped under it, never
+// reached PHP and is run once more on another worker.
+func (p *Pool) exec(req *protocol.Frame) (*protocol.Frame, *protocol.WorkerStats, error) {
 	p.totalRequests.Add(1)
 
-	// Get an available worker with timeout
-	var w *Worker
+	for retried := false; ; retried = true {
+		w, err := p.acquire()
+		if err != nil {
+			return nil, nil, err
+		}
+		send := req
+		if req.Flags&protocol.FlagDebug != 0 && !p.uses(w, features.Stats) {
+			plain := *req
+			plain.Flags &^= protocol.FlagDebug
+			send = &plain
+		}
+		resp, stats, err := p.run(w, send)
+		if !retried && errors.Is(err, errNotSent) {
+			p.logger.Warn("re-queueing request on another worker", "worker_id", w.ID(), "error", err)
+			continue
+		}
+		return resp, stats, err
+	}
+}
+
+// ExecStream dispatches a stream event, preferring the worker that served
+// the connection's previous event so PHP can keep per-connection state in
+// memory. When that worker is busy or gone, any available one serves it.
+func (p *Pool) ExecStream(req *protocol.Frame) (*protocol.Frame, error) {
+	header, _, err := protocol.DecodeStreamData(req)
+	if err != nil {
+		return nil, err
+	}
+	connID := header.ConnectionID
+	p.totalRequests.Add(1)
+
+	p.affinityMu.Lock()
+	preferred := p.affinity[connID]
+	p.affinityMu.Unlock()
+
+	w := p.takeIdle(preferred)
+	if w == nil {
+		if w, err = p.acquire(); err != nil {
+			return nil, err
+		}
+	}
+	if !p.uses(w, features.Streaming) {
+		p.release(w)
+		return nil, fmt.Errorf("worker %d does not implement %s", w.ID(), features.Streaming)
+	}
+	p.affinityMu.Lock()
+	p.affinity[connID] = w
+	p.affinityMu.Unlock()
+
+	resp, _, err := p.run(w, req)
+	if header.Event == protocol.EventClose || rejected(resp, connID) {
+		p.affinityMu.Lock()
+		delete(p.affinity, connID)
+		p.affinityMu.Unlock()
+	}
+	return resp, err
+}
+
+// rejected reports whether PHP answered with a reject for connID, after
+// which the connection is dropped without a close event.
+func rejected(resp *protocol.Frame, connID string) bool {
+	if resp == nil || resp.Type != protocol.TypeStreamData {
+		return false
+	}
+	cmds, err := protocol.DecodeStreamCommands(resp)
+	if err != nil {
+		return false
+	}
+	for _, cmd := range cmds {
+		if cmd.Event == protocol.EventReject && (cmd.ConnectionID == "" || cmd.ConnectionID == connID) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire waits for an available worker, up to the allocate timeout, and
+// checks it out. Workers retired while queued are passed over. A request
+// that finds an on-demand pool scaled to zero starts its first worker at
+// once, and pays for it.
+func (p *Pool) acquire() (*Worker, error) {
+	start := time.Now()
+	p.lastUsed.Store(start.UnixNano())
+	cold := p.coldStart()
+	timeout := time.After(p.cfg.AllocateTimeout.Duration())
+	// The pool may scale to zero as the request comes in; an on-demand
+	// one is checked again while the request waits.
+	var recheck <-chan time.Time
+	if p.cfg.OnDemand {
+		ticker := time.NewTicker(coldProbe)
+		defer ticker.Stop()
+		recheck = ticker.C
+	}
+	for {
+		select {
+		case w, ok := <-p.available:
+			if !ok {
+				return nil, fmt.Errorf("pool shutting down")
+			}
+			if w.checkout() {
+				if cold {
+					metrics.ObserveColdStart(start)
+				}
+				return w, nil
+			}
+		case <-recheck:
+			cold = p.coldStart() || cold
+		case <-timeout:
+			return nil, fmt.Errorf("no available worker within %s (pool exhausted)", p.cfg.AllocateTimeout.Duration())
+		case <-p.ctx.Done():
+			return nil, fmt.Errorf("pool shutting down")
+		}
+	}
+}
+
+// release puts w, whose request is done, back on the idle queue, or
+// stops it if it was retired in the meantime.
+func (p *Pool) release(w *Worker) {
+	if w.checkin() {
+		p.enqueue(w)
+		return
+	}
+	go p.stopWorker(w)
+}
+
+// recycle replaces w, which the caller checked out, with a new worker. A
+// worker retired by a reload meanwhile is only stopped: the reload has
+// started its replacement.
+func (p *Pool) recycle(w *Worker) {
+	if w.retireBusy() {
+		go p.replaceWorker(w)
+	} else {
+		go p.stopWorker(w)
+	}
+}
+
+// enqueue puts w, which is idle, on the idle queue. The queue holds
+// max_workers; a worker finding it full is surplus, as when a replacement
+// raced a reload, and is stopped.
+func (p *Pool) enqueue(w *Worker) {
 	select {
-	case w = <-p.available:
-	case <-time.After(p.cfg.AllocateTimeout.Duration()):
-		return nil, fmt.Errorf("no available worker within %s (pool exhausted)", p.cfg.AllocateTimeout.Duration())
-	case <-p.ctx.Done():
-		return nil, fmt.Errorf("pool shutting down")
+	case p.available <- w:
+	default:
+		if w.retireIdle() {
+			p.logger.Debug("stopping surplus worker", "worker_id", w.ID())
+			go p.stopWorker(w)
+		}
+	}
+}
+
+// stopWorker stops a worker that is out of service and forgets it.
+func (p *Pool) stopWorker(w *Worker) {
+	if err := w.Stop(); err != nil {
+		p.logger.Warn("error stopping worker", "worker_id", w.ID(), "error", err)
+	}
+	p.removeWorker(w)
+}
+
+// takeIdle takes preferred off the idle queue and checks it out, or
+// returns nil if it is not there. The other idle workers it passes over
+// are put back, except those retired meanwhile.
+func (p *Pool) takeIdle(preferred *Worker) *Worker {
+	if preferred == nil {
+		return nil
 	}
+	var passed []*Worker
+	defer func() {
+		for _, w := range passed {
+			if w.State() == StateIdle {
+				p.enqueue(w)
+			}
+		}
+	}()
+	for range cap(p.available) {
+		select {
+		case w, ok := <-p.available:
+			if !ok {
+				return nil
+			}
+			if w == preferred {
+				if w.checkout() {
+					return w
+				}
+				return nil
+			}
+			passed = append(passed, w)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
 
+// run executes req on w, which the caller checked out, and returns w to
+// the idle queue or replaces it.
+func (p *Pool) run(w *Worker, req *protocol.Frame) (*protocol.Frame, *protocol.WorkerStats, error) {
 	p.busyWorkers.Add(1)
 	defer p.busyWorkers.Add(-1)
 
 	// Execute request with timeout
 	type execResult struct {
 		frame *protocol.Frame
+		stats *protocol.WorkerStats
 		err   error
 	}
 	done := make(chan execResult, 1)
 	go func() {
-		f, e := w.Exec(req)
-		done <- execResult{f, e}
+		f, s, e := w.Exec(req)
+		done <- execResult{f, s, e}
 	}()
 
 	var resp *protocol.Frame
+	var stats *protocol.WorkerStats
 	var err error
 	if p.cfg.RequestTimeout.Duration() > 0 {
 		select {
 		case result := <-done:
-			resp, err = result.frame, result.err
+			resp, stats, err = result.frame, result.stats, result.err
 		case <-time.After(p.cfg.RequestTimeout.Duration()):
 			p.logger.Error("worker request timeout", "worker_id", w.ID(), "timeout", p.cfg.RequestTimeout.Duration())
-			go p.replaceWorker(w)
-			return nil, fmt.Errorf("request timeout after %s", p.cfg.RequestTimeout.Duration())
+			metrics.RecordWorkerRecycle(metrics.RecycleTimeout)
+			p.recycle(w)
+			return nil, nil, fmt.Errorf("request timeout after %s", p.cfg.RequestTimeout.Duration())
 		case <-p.ctx.Done():
-			return nil, fmt.Errorf("pool shutting down")
+			return nil, nil, fmt.Errorf("pool shutting down")
 		}
 	} else {
 		result := <-done
-		resp, err = result.frame, result.err
+		resp, stats, err = result.frame, result.stats, result.err
 	}
 
+	if err != nil && w.killed.Load() {
+		metrics.RecordWorkerRecycle(metrics.RecycleKilled)
+		p.recycle(w)
+		return nil, nil, fmt.Errorf("worker %d killed: %w", w.ID(), err)
+	}
 	if err != nil {
 		p.logger.Error("worker exec failed", "worker_id", w.ID(), "error", err)
-		go p.replaceWorker(w)
-		return nil, fmt.Errorf("worker %d exec failed: %w", w.ID(), err)
+		metrics.RecordWorkerExecError()
+		metrics.RecordWorkerRecycle(metrics.RecycleCrash)
+		p.recordCrash(w.ID(), err.Error())
+		p.recycle(w)
+		return nil, nil, fmt.Errorf("worker %d exec failed: %w", w.ID(), err)
 	}
 
 	// Check if worker needs recycling
-	if p.needsRecycle(w) {
-		go p.replaceWorker(w)
+	if w.killed.Load() {
+		metrics.RecordWorkerRecycle(metrics.RecycleKilled)
+		p.recycle(w)
+	} else if p.needsRecycle(w) {
+		metrics.RecordWorkerRecycle(metrics.RecycleMaxJobs)
+		p.recycle(w)
 	} else {
-		// Wait for WORKER_READY before returning to pool
+		// Wait for WORKER_READY before returning to pool. A worker that
+		// exits here instead has hit its PHP-side memory limit.
 		ready, err := w.ReadFrame()
 		if err != nil || ready.Type != protocol.TypeWorkerReady {
-			go p.replaceWorker(w)
+			metrics.RecordWorkerRecycle(metrics.RecycleMemory)
+			p.recycle(w)
 		} else {
-			p.available <- w
+			p.release(w)
 		}
 	}
 
-	return resp, nil
+	return resp, stats, nil
 }
 
 // Stop gracefully shuts down all workers in the pool.
 func (p *Pool) Stop() error {
 	p.logger.Info("stopping worker pool")
 	p.cancel()
+	// Wait for replacements already past their ctx check; later ones see
+	// the pool stopped.
+	p.replaceMu.Lock()
+	p.replaceMu.Unlock()
 
 	p.mu.RLock()
 	workers := make([]*Worker, len(p.workers))
@@ -196,17 +490,20 @@ func (p *Pool) spawnWorker() (*Worker, error) {
 	id := int(p.nextID.Add(1))
 
 	env := p.buildEnv()
-	w, err := NewWorker(id, p.php.Binary, p.php.Worker, env)
+	start := time.Now()
+	w, err := NewWorker(id, p.php.Binary, p.workerArgs(), env, int(p.cfg.MaxFrameSize))
+	metrics.ObserveWorkerSpawn(start, err)
 	if err != nil {
 		return nil, err
 	}
+	w.logger = p.logger
 
 	p.mu.Lock()
 	p.workers = append(p.workers, w)
 	p.activeWorkers.Add(1)
 	p.mu.Unlock()
 
-	p.logger.Debug("worker spawned", "worker_id", id)
+	p.logger.Debug("worker spawned", "worker_id", id, "features", p.negotiated(w).String())
 	return w, nil
 }
 
@@ -229,7 +526,115 @@ func (p *Pool) replaceWorker(old *Worker) {
 		p.logger.Error("failed to spawn replacement worker", "error", err)
 		return
 	}
-	p.available <- w
+	p.replaceMu.RLock()
+	defer p.replaceMu.RUnlock()
+	if p.ctx.Err() != nil {
+		// Stop ran while the replacement was starting.
+		w.Stop()
+		p.removeWorker(w)
+		return
+	}
+	p.enqueue(w)
+}
+
+// coldProbe is how often a request waiting on an empty on-demand pool
+// checks whether it needs a cold start, and coldRetry how long after a
+// failed one the next may begin.
+const (
+	coldProbe = 25 * time.Millisecond
+	coldRetry = time.Second
+)
+
+// coldStart starts the first worker of an on-demand pool that has none,
+// for a request that found it so. Requests arriving together share that
+// one spawn. It reports whether the pool was empty.
+func (p *Pool) coldStart() bool {
+	if !p.cfg.OnDemand {
+		return false
+	}
+	p.mu.Lock()
+	empty := len(p.workers) == 0
+	start := empty && !p.coldStarting && time.Since(time.Unix(0, p.coldFailed.Load())) >= coldRetry
+	if start {
+		p.coldStarting = true
+	}
+	p.mu.Unlock()
+	if start {
+		go p.spawnCold()
+	}
+	return empty
+}
+
+// spawnCold spawns the worker coldStart reserved and queues it.
+func (p *Pool) spawnCold() {
+	w, err := p.spawnWorker()
+	// spawnWorker has added w, so no other cold start can begin.
+	p.mu.Lock()
+	p.coldStarting = false
+	p.mu.Unlock()
+	if err != nil {
+		p.coldFailed.Store(time.Now().UnixNano())
+		p.logger.Error("scale-up failed", "trigger", metrics.ScaleCold, "error", err)
+		return
+	}
+	p.coldFailed.Store(0)
+	p.replaceMu.RLock()
+	defer p.replaceMu.RUnlock()
+	if p.ctx.Err() != nil {
+		w.Stop()
+		p.removeWorker(w)
+		return
+	}
+	metrics.RecordScaleUp(metrics.ScaleCold)
+	p.logger.Info("scaling up workers", "trigger", metrics.ScaleCold, "current", 1)
+	p.enqueue(w)
+}
+
+// Dormant reports whether the pool is on demand and has scaled to zero,
+// its last cold start, if any, having succeeded: it has no workers, but
+// will start one for the next request.
+func (p *Pool) Dormant() bool {
+	return p.cfg.OnDemand && p.Stats().TotalWorkers == 0 && p.coldFailed.Load() == 0
+}
+
+// Crashes this close together are reported as a crash loop.
+const (
+	crashLoopCrashes = 5
+	crashLoopWindow  = time.Minute
+)
+
+// recordCrash notes that worker id crashed because of cause, and reports
+// a crash loop once crashLoopCrashes have happened within crashLoopWindow.
+// The count then starts over, so a pool that keeps crashing is reported
+// at most every crashLoopCrashes crashes.
+func (p *Pool) recordCrash(id int, cause string) {
+	now := time.Now()
+	p.crashMu.Lock()
+	recent := p.crashes[:0]
+	for _, t := range p.crashes {
+		if now.Sub(t) < crashLoopWindow {
+			recent = append(recent, t)
+		}
+	}
+	p.crashes = append(recent, now)
+	loop := len(p.crashes) >= crashLoopCrashes
+	if loop {
+		p.crashes = p.crashes[:0]
+	}
+	reporter := p.reporter
+	p.crashMu.Unlock()
+	if !loop {
+		return
+	}
+
+	p.logger.Error("workers are crashing in a loop", "crashes", crashLoopCrashes, "within", crashLoopWindow, "last_error", cause)
+	reporter.Report(errreport.Event{
+		Kind:    errreport.KindWorkerCrashLoop,
+		Level:   errreport.LevelFatal,
+		Message: fmt.Sprintf("%d PHP worker crashes within %s", crashLoopCrashes, crashLoopWindow),
+		Tags:    map[string]string{"worker_id": strconv.Itoa(id)},
+		Extra:   map[string]string{"last_error": cause, "script": p.php.Worker},
+	})
 }
 
 func (p *Pool) removeWorker(w *Worker) {
@@ -263,10 +668,24 @@ func (p *Pool) buildEnv() []string {
 	for k, v := range p.php.INI {
 		env = append(env, fmt.Sprintf("PHP_INI_%s=%s", k, v))
 	}
+	env = append(env, features.Env(*p.features.Load())...)
 
 	return env
 }
 
+// workerArgs returns the arguments of a worker process. The sandbox
+// settings are given with -d: PHP reads open_basedir and
+// disable_functions only at startup, and they must hold for every
+// request the process serves.
+func (p *Pool) workerArgs() []string {
+	var args []string
+	ini := p.php.Sandbox.Rule().INI()
+	for _, k := range slices.Sorted(maps.Keys(ini)) {
+		args = append(args, "-d", k+"="+ini[k])
+	}
+	return append(args, p.php.Worker)
+}
+
 // watchdog monitors worker health and pool scaling.
 func (p *Pool) watchdog() {
 	ticker := time.NewTicker(5 * time.Second)
@@ -290,11 +709,11 @@ func (p *Pool) checkHealth() {
 	p.mu.RUnlock()
 
 	for _, w := range workers {
-		if w.State() == StateBusy {
-			continue
-		}
-		if !w.IsAlive() {
+		// A busy worker that died fails its request and is replaced then.
+		if !w.IsAlive() && w.retireIdle() {
 			p.logger.Warn("dead worker detected", "worker_id", w.ID())
+			metrics.RecordWorkerRecycle(metrics.RecycleCrash)
+			p.recordCrash(w.ID(), "worker process exited")
 			go p.replaceWorker(w)
 		}
 	}
@@ -303,29 +722,41 @@ func (p *Pool) checkHealth() {
 func (p *Pool) autoScale() {
 	stats := p.Stats()
 
+	// An on-demand pool keeps one worker until no request has come for
+	// idle_timeout, then stops every idle one.
+	floor := p.cfg.MinWorkers
+	if p.cfg.OnDemand {
+		floor = 1
+		if idle := time.Since(time.Unix(0, p.lastUsed.Load())); idle >= p.cfg.IdleTimeout.Duration() {
+			p.scaleToZero(idle)
+			return
+		}
+	}
+
 	// Scale up if busy percentage exceeds threshold (80%)
 	if stats.TotalWorkers > 0 {
 		busyPct := float64(stats.BusyWorkers) / float64(stats.TotalWorkers) * 100
 		if busyPct >= 80 && stats.TotalWorkers < p.cfg.MaxWorkers {
-			p.logger.Info("scaling up workers", "busy_pct", busyPct, "current", stats.TotalWorkers)
+			p.logger.Info("scaling up workers", "trigger", metrics.ScaleTick, "busy_pct", busyPct, "current", stats.TotalWorkers)
 			w, err := p.spawnWorker()
 			if err != nil {
 				p.logger.Error("scale-up failed", "error", err)
 				return
 			}
-			p.available <- w
+			metrics.RecordScaleUp(metrics.ScaleTick)
+			p.enqueue(w)
 		}
 
 		// Scale down if idle workers exceed threshold and above minimum
-		if busyPct <= 20 && stats.TotalWorkers > p.cfg.MinWorkers {
+		if busyPct <= 20 && stats.TotalWorkers > floor {
 			// Find and stop an idle worker
 			select {
 			case w := <-p.available:
-				p.logger.Info("scaling down workers", "busy_pct", busyPct, "current", stats.TotalWorkers)
-				go func() {
-					w.Stop()
-					p.removeWorker(w)
-				}()
+				// One retired while queued is already being stopped.
+				if w.retireIdle() {
+					p.logger.Info("scaling down workers", "busy_pct", busyPct, "current", stats.TotalWorkers)
+					go p.stopWorker(w)
+				}
 			default:
 				// No idle workers available to remove
 			}
@@ -333,7 +764,54 @@ func (p *Pool) autoScale() {
 	}
 }
 
-// Reload gracefully replaces all workers (zero-downtime restart).
+// scaleToZero stops the idle workers of an on-demand pool that has had no
+// request for idle. A busy one is stopped on a later tick, once done. The
+// workers are forgotten before they stop, so that a request coming in
+// meanwhile finds the pool empty and starts a new one.
+func (p *Pool) scaleToZero(idle time.Duration) {
+	stopped := 0
+drain:
+	for {
+		select {
+		case w := <-p.available:
+			if !w.retireIdle() {
+				continue // retired while queued, and already being stopped
+			}
+			p.removeWorker(w)
+			go p.stopWorker(w)
+			stopped++
+		default:
+			break drain
+		}
+	}
+	if stopped > 0 {
+		p.logger.Info("scaling down idle on-demand pool", "stopped", stopped,
+			"workers", p.Stats().TotalWorkers, "idle_for", idle.Round(time.Second).String())
+	}
+}
+
+// Invalidate drops files from every worker's opcache before its next
+// request. Unlike Reload, the workers and the rest of their cache stay.
+func (p *Pool) Invalidate(files []string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	queued := 0
+	for _, w := range p.workers {
+		// Workers without invalidate keep their cache until recycled.
+		if p.uses(w, features.Invalidate) {
+			w.Invalidate(files)
+			queued++
+		}
+	}
+	p.logger.Info("opcache invalidation queued", "files", len(files), "workers", queued)
+}
+
+// Reload gracefully replaces all workers (zero-downtime restart). The new
+// workers are started first; the old ones are then retired, idle ones
+// stopped at once and busy ones once their request is done, and the new
+// ones queued. A request that took an old worker off the queue as it was
+// retired waits for a new one instead.
 func (p *Pool) Reload() error {
 	p.logger.Info("graceful reload starting")
 
@@ -343,8 +821,13 @@ func (p *Pool) Reload() error {
 	p.mu.RUnlock()
 
 	// Spawn new workers first (ensures zero-downtime)
-	newWorkers := make([]*Worker, 0, p.cfg.MinWorkers)
-	for i := 0; i < p.cfg.MinWorkers; i++ {
+	// An on-demand pool that has scaled to zero stays there.
+	replacements := p.cfg.MinWorkers
+	if p.cfg.OnDemand {
+		replacements = min(len(oldWorkers), 1)
+	}
+	newWorkers := make([]*Worker, 0, replacements)
+	for i := 0; i < replacements; i++ {
 		w, err := p.spawnWorker()
 		if err != nil {
 			p.logger.Error("reload: failed to spawn new worker", "error", err)
@@ -354,24 +837,51 @@ func (p *Pool) Reload() error {
 			return fmt.Errorf("reload failed: %w", err)
 		}
 		newWorkers = append(newWorkers, w)
-		p.available <- w
 	}
 
 	p.logger.Info("reload: new workers spawned", "count", len(newWorkers))
 
-	// Drain and stop old workers in background
+	draining := 0
+	for _, w := range oldWorkers {
+		if w.retire() {
+			go p.stopWorker(w)
+		} else {
+			draining++
+		}
+	}
+	p.dropRetired()
+	for _, w := range newWorkers {
+		p.enqueue(w)
+	}
+	p.logger.Info("reload: old workers retired", "count", len(oldWorkers), "draining", draining)
+
 	go func() {
 		for _, w := range oldWorkers {
-			for w.State() == StateBusy {
-				time.Sleep(100 * time.Millisecond)
-			}
-			if err := w.Stop(); err != nil {
-				p.logger.Warn("reload: error stopping old worker", "worker_id", w.ID(), "error", err)
-			}
-			p.removeWorker(w)
+			<-w.stopped
 		}
 		p.logger.Info("graceful reload complete", "old_stopped", len(oldWorkers), "new_active", len(newWorkers))
 	}()
 
 	return nil
 }
+
+// dropRetired removes retired workers from the idle queue, making room
+// for their replacements.
+func (p *Pool) dropRetired() {
+	var keep []*Worker
+	defer func() {
+		for _, w := range keep {
+			p.enqueue(w)
+		}
+	}()
+	for range len(p.available) {
+		select {
+		case w := <-p.available:
+			if w.State() == StateIdle {
+				keep = append(keep, w)
+			}
+		default:
+			return
+		}
+	}
+}
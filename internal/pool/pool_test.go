@@ -0,0 +1,620 @@
+package pool_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/features"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/scratch"
+)
+
+// The pool tests exec the test binary as the PHP worker; started that way
+// (the pool passes php.ini settings as PHP_INI_* variables) it serves the
+// wire protocol instead of running the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv("PHP_INI_maboo_test_worker") == "1" {
+		switch os.Args[1] {
+		case hashScript:
+			os.Exit(hashWorker())
+		case dumpScript:
+			os.Exit(dumpWorker())
+		}
+		os.Exit(fakeWorker(os.Args[1]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeWorker answers every request with the script's contents and its pid,
+// followed by WORKER_STATS when asked, and every stream event with its pid.
+// Like opcache with validate_timestamps=0, it reads a file once and keeps
+// serving that copy until the file is invalidated. Its responses carry
+// the MABOO_FEATURES it was started with in X-Maboo-Features. It declares
+// the features listed in the maboo_test_features INI setting, if set.
+func fakeWorker(script string) int {
+	cache := map[string][]byte{}
+	first := protocol.NewWorkerReadyFrame()
+	if declared, ok := os.LookupEnv("PHP_INI_maboo_test_features"); ok {
+		var err error
+		if first, err = protocol.EncodeWorkerReady(strings.Split(declared, ",")); err != nil {
+			return 1
+		}
+	}
+	if err := protocol.WriteFrame(os.Stdout, first); err != nil {
+		return 1
+	}
+	ready := func() error { return protocol.WriteFrame(os.Stdout, protocol.NewWorkerReadyFrame()) }
+	for {
+		f, err := protocol.ReadFrame(os.Stdin)
+		if err != nil {
+			return 0
+		}
+		switch f.Type {
+		case protocol.TypeWorkerStop:
+			return 0
+		case protocol.TypeInvalidate:
+			files, err := protocol.DecodeInvalidate(f)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			for _, file := range files {
+				delete(cache, file)
+			}
+		case protocol.TypeRequest:
+			body, ok := cache[script]
+			if !ok {
+				if body, err = os.ReadFile(script); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return 1
+				}
+				cache[script] = body
+			}
+			resp, err := protocol.EncodeResponse(&protocol.ResponseHeader{
+				Status:  200,
+				Headers: map[string]string{"X-Maboo-Features": os.Getenv(features.FeaturesVar)},
+			}, fmt.Appendf(bytes.Clone(body), " pid=%d", os.Getpid()))
+			if err != nil {
+				return 1
+			}
+			if err := protocol.WriteFrame(os.Stdout, resp); err != nil {
+				return 1
+			}
+			if f.Flags&protocol.FlagDebug != 0 {
+				stats, err := protocol.EncodeWorkerStats(&protocol.WorkerStats{
+					OpcacheMisses: 1, IncludedFiles: 1, PeakMemory: int64(len(body)),
+				})
+				if err != nil {
+					return 1
+				}
+				if err := protocol.WriteFrame(os.Stdout, stats); err != nil {
+					return 1
+				}
+			}
+		case protocol.TypeStreamData:
+			h, _, err := protocol.DecodeStreamData(f)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			resp, err := protocol.EncodeStreamData(0, &protocol.StreamHeader{
+				Event:        protocol.EventMessage,
+				ConnectionID: h.ConnectionID,
+			}, fmt.Appendf(nil, "pid=%d", os.Getpid()))
+			if err != nil {
+				return 1
+			}
+			if err := protocol.WriteFrame(os.Stdout, resp); err != nil {
+				return 1
+			}
+		}
+		if err := ready(); err != nil {
+			return 1
+		}
+	}
+}
+
+// hashScript, as the worker script, starts hashWorker in place of
+// fakeWorker.
+const hashScript = "sha256"
+
+// hashWorker answers every request with the SHA-256 of its body, which it
+// reads off stdin in chunks rather than as a whole frame, so a body of any
+// size passes through it.
+func hashWorker() int {
+	if err := protocol.WriteFrame(os.Stdout, protocol.NewWorkerReadyFrame()); err != nil {
+		return 1
+	}
+	header := make([]byte, protocol.FrameHeaderSize)
+	for {
+		if _, err := io.ReadFull(os.Stdin, header); err != nil {
+			return 0
+		}
+		if header[3] == protocol.TypeWorkerStop {
+			return 0
+		}
+		hdrSize := int64(header[7])<<16 | int64(header[8])<<8 | int64(header[9])
+		payloadSize := int64(binary.BigEndian.Uint32(header[10:14]))
+		if _, err := io.CopyN(io.Discard, os.Stdin, hdrSize); err != nil {
+			return 1
+		}
+		h := sha256.New()
+		if _, err := io.CopyN(h, os.Stdin, payloadSize); err != nil {
+			return 1
+		}
+		resp, err := protocol.EncodeResponse(&protocol.ResponseHeader{Status: 200}, hex.AppendEncode(nil, h.Sum(nil)))
+		if err != nil {
+			return 1
+		}
+		if err := protocol.WriteFrame(os.Stdout, resp); err != nil {
+			return 1
+		}
+		if err := protocol.WriteFrame(os.Stdout, protocol.NewWorkerReadyFrame()); err != nil {
+			return 1
+		}
+	}
+}
+
+func startPool(t *testing.T, script string) *pool.Pool {
+	t.Helper()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default().Pool
+	cfg.MinWorkers = 2
+	cfg.MaxWorkers = 2
+	p := pool.New(cfg, config.PHPConfig{
+		Binary: self,
+		Worker: script,
+		INI:    map[string]string{"maboo_test_worker": "1"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	return p
+}
+
+// serveAll sends one request per worker, so every worker answers, and
+// returns the bodies without their pid suffix and the set of pids.
+func serveAll(t *testing.T, p *pool.Pool) (bodies, pids map[string]bool) {
+	t.Helper()
+	bodies, pids = map[string]bool{}, map[string]bool{}
+	req, err := protocol.EncodeRequest(&protocol.RequestHeader{Method: "GET", URI: "/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Requests are served one at a time, so the idle queue hands them to
+	// the workers in turn.
+	for range 2 {
+		resp, err := p.Exec(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, body, err := protocol.DecodeResponse(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, pid, _ := strings.Cut(string(body), " pid=")
+		bodies[text] = true
+		pids[pid] = true
+	}
+	return bodies, pids
+}
+
+func TestPoolInvalidateKeepsWorkers(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "index.php")
+	write(t, script, "v1")
+	p := startPool(t, script)
+
+	bodies, before := serveAll(t, p)
+	if !bodies["v1"] || len(bodies) != 1 {
+		t.Fatalf("bodies = %v, want v1", bodies)
+	}
+
+	write(t, script, "v2")
+	if bodies, _ := serveAll(t, p); !bodies["v1"] || len(bodies) != 1 {
+		t.Fatalf("bodies before invalidation = %v, want the cached v1", bodies)
+	}
+
+	cfg := watchConfig(pool.BackendFSNotify, dir)
+	cfg.Strategy = config.WatchStrategyInvalidate
+	changed := make(chan []string, 1)
+	w := newWatcher(t, cfg, func(action pool.Action, paths []string) {
+		if action != pool.ActionInvalidate {
+			t.Errorf("action = %q, want %q", action, pool.ActionInvalidate)
+			return
+		}
+		p.Invalidate(paths)
+		changed <- paths
+	})
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Stop)
+
+	write(t, script, "v3")
+	select {
+	case paths := <-changed:
+		if len(paths) != 1 || paths[0] != script {
+			t.Errorf("invalidated %v, want [%s]", paths, script)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no invalidation")
+	}
+
+	bodies, after := serveAll(t, p)
+	if !bodies["v3"] || len(bodies) != 1 {
+		t.Errorf("bodies after invalidation = %v, want v3 from every worker", bodies)
+	}
+	if fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("worker pids changed from %v to %v, want the same workers", before, after)
+	}
+}
+
+func TestPoolExecDebug(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "index.php")
+	write(t, script, "hello")
+	p := startPool(t, script)
+	req, err := protocol.EncodeRequest(&protocol.RequestHeader{Method: "GET", URI: "/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, stats, err := p.ExecDebug(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != protocol.TypeResponse {
+		t.Errorf("response type = 0x%02x", resp.Type)
+	}
+	if stats == nil || stats.IncludedFiles != 1 || stats.PeakMemory != int64(len("hello")) {
+		t.Errorf("stats = %+v", stats)
+	}
+	if req.Flags&protocol.FlagDebug != 0 {
+		t.Error("ExecDebug changed the caller's frame")
+	}
+
+	// Without the flag the worker sends no stats, and every worker is
+	// still in step with the pool.
+	for range 4 {
+		resp, err := p.Exec(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, body, err := protocol.DecodeResponse(resp); err != nil || !strings.HasPrefix(string(body), "hello") {
+			t.Fatalf("body = %q, err = %v", body, err)
+		}
+	}
+}
+
+func TestPoolNegotiatesFeatures(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(t.TempDir(), "index.php")
+	write(t, script, "hello")
+	cfg := config.Default().Pool
+	cfg.MinWorkers = 1
+	cfg.MaxWorkers = 1
+	p := pool.New(cfg, config.PHPConfig{
+		Binary: self,
+		Worker: script,
+		INI:    map[string]string{"maboo_test_worker": "1", "maboo_test_features": "timeout,invalidate"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	p.SetFeatures(features.Set{features.Stats, features.Invalidate, features.Timeout})
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+
+	req, err := protocol.EncodeRequest(&protocol.RequestHeader{Method: "GET", URI: "/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The worker does not implement stats, so it is not asked for them
+	// and stays in step with the pool.
+	for range 3 {
+		resp, stats, err := p.ExecDebug(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != nil {
+			t.Errorf("stats = %+v from a worker without stats", stats)
+		}
+		h, _, err := protocol.DecodeResponse(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := h.Headers["X-Maboo-Features"]; got != "stats,invalidate,timeout" {
+			t.Errorf("worker started with MABOO_FEATURES=%q, want the pool's offer", got)
+		}
+	}
+	if p.Stats().TotalWorkers != 1 {
+		t.Errorf("TotalWorkers = %d, want the one worker kept", p.Stats().TotalWorkers)
+	}
+
+	// Streaming is neither offered nor declared.
+	event, err := protocol.EncodeStreamData(0, &protocol.StreamHeader{Event: protocol.EventMessage, ConnectionID: "c1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ExecStream(event); err == nil || !strings.Contains(err.Error(), features.Streaming) {
+		t.Errorf("ExecStream() error = %v, want streaming unsupported", err)
+	}
+}
+
+func TestPoolExecSpilledBody(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams a 1GiB body")
+	}
+	const size = 1 << 30
+	p := startPool(t, hashScript)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	sent := sha256.New()
+	src := io.TeeReader(io.LimitReader(rand.NewChaCha8([32]byte{}), size), sent)
+	req := httptest.NewRequest("POST", "/upload", src)
+	area, err := scratch.New(scratch.Options{Dir: t.TempDir()}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, body, err := protocol.NewRequestFrame(req, protocol.DefaultBodyMemoryLimit, area)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if !body.Spilled() || body.Size() != size {
+		t.Fatalf("body spilled = %v, size = %d", body.Spilled(), body.Size())
+	}
+	resp, err := p.Exec(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; !raceEnabled && alloc > 64<<20 {
+		t.Errorf("allocated %d MiB for a %d MiB body", alloc>>20, size>>20)
+	}
+	_, got, err := protocol.DecodeResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hex.EncodeToString(sent.Sum(nil)); string(got) != want {
+		t.Errorf("worker hashed %s, want %s", got, want)
+	}
+}
+
+// streamPID sends event for connID through ExecStream and returns the pid
+// of the worker that handled it.
+func streamPID(t *testing.T, p *pool.Pool, connID, event string) string {
+	t.Helper()
+	req, err := protocol.EncodeStreamData(0, &protocol.StreamHeader{Event: event, ConnectionID: connID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.ExecStream(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := protocol.DecodeStreamData(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPoolExecStreamAffinity(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "ws.php")
+	write(t, script, "")
+	p := startPool(t, script)
+
+	// Without affinity the idle queue would hand consecutive events to
+	// the workers in turn.
+	first := streamPID(t, p, "a", protocol.EventConnect)
+	for i := range 5 {
+		if got := streamPID(t, p, "a", protocol.EventMessage); got != first {
+			t.Fatalf("message %d served by %s, want %s which served connect", i, got, first)
+		}
+	}
+	other := streamPID(t, p, "b", protocol.EventConnect)
+	if got := streamPID(t, p, "a", protocol.EventClose); got != first {
+		t.Errorf("close served by %s, want %s", got, first)
+	}
+	if got := streamPID(t, p, "b", protocol.EventMessage); got != other {
+		t.Errorf("connection b moved from %s to %s", other, got)
+	}
+}
+
+// eventRecorder is an errreport.Reporter keeping what it is sent.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []errreport.Event
+}
+
+func (r *eventRecorder) Report(e errreport.Event) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+func (r *eventRecorder) Close(context.Context) error { return nil }
+
+func (r *eventRecorder) reported() []errreport.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
+
+func TestPoolReportsCrashLoop(t *testing.T) {
+	// The fake worker exits when its script cannot be read.
+	p := startPool(t, filepath.Join(t.TempDir(), "missing.php"))
+	rec := &eventRecorder{}
+	p.SetReporter(rec)
+	req, err := protocol.EncodeRequest(&protocol.RequestHeader{Method: "GET", URI: "/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 9 {
+		if _, err := p.Exec(req); err == nil {
+			t.Fatalf("request %d served by a worker without its script", i)
+		}
+	}
+	events := rec.reported()
+	if len(events) != 1 {
+		t.Fatalf("%d crash loops reported after 9 crashes, want 1", len(events))
+	}
+	if e := events[0]; e.Kind != errreport.KindWorkerCrashLoop || e.Tags["worker_id"] == "" || e.Extra["last_error"] == "" {
+		t.Errorf("crash loop event = %+v", e)
+	}
+	if _, err := p.Exec(req); err == nil {
+		t.Fatal("request served by a worker without its script")
+	}
+	if n := len(rec.reported()); n != 2 {
+		t.Errorf("%d crash loops reported after 10 crashes, want 2", n)
+	}
+}
+
+func TestPoolReloadUnderLoad(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(t.TempDir(), "index.php")
+	write(t, script, "ok")
+	cfg := config.Default().Pool
+	cfg.MinWorkers = 2
+	cfg.MaxWorkers = 4
+	cfg.MaxJobs = 7 // recycle workers in between reloads too
+	p := pool.New(cfg, config.PHPConfig{
+		Binary: self,
+		Worker: script,
+		INI:    map[string]string{"maboo_test_worker": "1"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	req, err := protocol.EncodeRequest(&protocol.RequestHeader{Method: "GET", URI: "/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var served, failed atomic.Int64
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp, err := p.Exec(req)
+				if err == nil {
+					_, body, err := protocol.DecodeResponse(resp)
+					if err == nil && strings.HasPrefix(string(body), "ok") {
+						served.Add(1)
+						continue
+					}
+				}
+				if failed.Add(1) <= 3 {
+					t.Errorf("request failed during reloads: %v", err)
+				}
+			}
+		})
+	}
+	for range 20 {
+		if err := p.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 {
+		t.Errorf("%d of %d requests failed across 20 reloads", n, n+served.Load())
+	}
+	if served.Load() == 0 {
+		t.Error("no request served")
+	}
+	// The retired workers are all stopped in the end.
+	deadline := time.Now().Add(10 * time.Second)
+	for p.Stats().TotalWorkers > cfg.MaxWorkers && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := p.Stats().TotalWorkers; n > cfg.MaxWorkers {
+		t.Errorf("%d workers left after the reloads, want at most %d", n, cfg.MaxWorkers)
+	}
+}
+
+func TestPoolOnDemand(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(t.TempDir(), "index.php")
+	write(t, script, "ok")
+	cfg := config.Default().Pool
+	cfg.OnDemand = true
+	cfg.MaxWorkers = 4
+	p := pool.New(cfg, config.PHPConfig{
+		Binary: self,
+		Worker: script,
+		INI:    map[string]string{"maboo_test_worker": "1"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	if n := p.Stats().TotalWorkers; n != 0 || !p.Dormant() {
+		t.Fatalf("started with %d workers (dormant %v), want none", n, p.Dormant())
+	}
+
+	// Requests that find the pool empty share one cold start.
+	req, err := protocol.EncodeRequest(&protocol.RequestHeader{Method: "GET", URI: "/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			if _, err := p.Exec(req); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	if n := p.Stats().TotalWorkers; n != 1 || p.Dormant() {
+		t.Errorf("after a burst: %d workers (dormant %v), want 1", n, p.Dormant())
+	}
+}
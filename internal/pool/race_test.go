@@ -0,0 +1,7 @@
+//go:build race
+
+package pool_test
+
+// raceEnabled reports whether the tests run under the race detector, which
+// allocates shadow memory of its own and skews allocation counts.
+const raceEnabled = true
@@ -2,139 +2,569 @@ package pool
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+// Watcher backends, selected by watch.backend.
+const (
+	BackendAuto     = "auto"     // fsnotify, falling back to polling if it cannot start
+	BackendFSNotify = "fsnotify" // kernel notifications (inotify, kqueue)
+	BackendPoll     = "poll"     // walk the tree every interval; works on NFS and bind mounts
 )
 
-// Watcher monitors PHP files for changes and triggers pool reload.
+// maxLoggedPaths caps the changed paths listed in the reload log line.
+const maxLoggedPaths = 10
+
+var (
+	watcherReloads = metrics.NewCounter("maboo_watcher_reloads_total",
+		"Worker reloads, opcache invalidations or config reloads triggered by file changes.")
+	watcherDebounced = metrics.NewCounter("maboo_watcher_debounced_events_total",
+		"File change events folded into another event's reload by debouncing.")
+	watcherEvents = metrics.NewCounter("maboo_watcher_events_total",
+		"Changes to watched files seen by the file watcher.")
+	watcherTracked = metrics.NewGauge("maboo_watcher_tracked_files",
+		"Files the file watcher is tracking.")
+)
+
+// WatcherStatus is a snapshot of the file watcher, reported by the admin
+// API and the verbose health output.
+type WatcherStatus struct {
+	Running         bool       `json:"running"`
+	Backend         string     `json:"backend"`
+	Dirs            []string   `json:"dirs"`
+	TrackedFiles    int        `json:"tracked_files"`
+	PendingEvents   int        `json:"pending_events"` // changes waiting for the debounce
+	LastReload      *time.Time `json:"last_reload,omitempty"`
+	LastReloadPaths []string   `json:"last_reload_paths,omitempty"` // at most 10
+	LastError       string     `json:"last_error,omitempty"`
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Watcher monitors files for changes and asks its owner to act on them.
 type Watcher struct {
-	dirs     []string
-	exts     []string
-	interval time.Duration
-	logger   *slog.Logger
-	onChange func()
-	ctx      context.Context
-	cancel   context.CancelFunc
-	mtimes   map[string]time.Time
-}
-
-// NewWatcher creates a file watcher for the given directories.
-func NewWatcher(dirs []string, interval time.Duration, logger *slog.Logger, onChange func()) *Watcher {
+	dirs          []string
+	match         *matcher
+	backend       string
+	interval      time.Duration
+	logger        *slog.Logger
+	onChange      func(action Action, paths []string)
+	strategy      string
+	invalidateMax int
+	pending       *debouncer
+	ctx           context.Context
+	cancel        context.CancelFunc
+	done          chan struct{}
+
+	mtimes  map[string]time.Time // poll backend
+	fsw     *fsnotify.Watcher    // fsnotify backend
+	watched map[string]bool      // directories registered with fsw
+	files   map[string]bool      // files below the watched directories
+
+	mu    sync.Mutex
+	state WatcherStatus
+}
+
+// NewWatcher creates a file watcher for cfg.Dirs. For each debounced
+// batch of changes onChange is called with ActionConfig if a file with
+// that action changed, then with ActionReload or ActionInvalidate if code
+// changed, along with the changed paths. Batches of ActionLog files are
+// only logged.
+func NewWatcher(cfg config.WatchConfig, logger *slog.Logger, onChange func(action Action, paths []string)) (*Watcher, error) {
+	m, err := newMatcher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Absolute paths, as opcache keys its entries by them.
+	dirs := make([]string, len(cfg.Dirs))
+	for i, dir := range cfg.Dirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		dirs[i] = dir
+	}
 	ctx, cancel := context.WithCancel(context.Background())
+	backend := cfg.Backend
+	if backend == "" {
+		backend = BackendAuto
+	}
 	return &Watcher{
-		dirs:     dirs,
-		exts:     []string{".php", ".inc", ".phtml"},
-		interval: interval,
-		logger:   logger,
-		onChange: onChange,
-		ctx:      ctx,
-		cancel:   cancel,
-		mtimes:   make(map[string]time.Time),
+		dirs:          dirs,
+		match:         m,
+		backend:       backend,
+		interval:      cfg.Interval.Duration(),
+		logger:        logger,
+		onChange:      onChange,
+		strategy:      cfg.Strategy,
+		invalidateMax: cfg.InvalidateMax,
+		pending:       newDebouncer(cfg.Debounce.Duration(), cfg.MaxDelay.Duration(), cfg.Cooldown.Duration()),
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		mtimes:        make(map[string]time.Time),
+		watched:       make(map[string]bool),
+		files:         make(map[string]bool),
+		state:         WatcherStatus{Backend: backend, Dirs: dirs},
+	}, nil
+}
+
+// Start begins watching for file changes. With the auto backend a failure
+// to set up fsnotify (no inotify, watch limit reached) falls back to
+// polling; with fsnotify it is returned.
+func (w *Watcher) Start() error {
+	if w.backend != BackendPoll {
+		err := w.startNotify()
+		if err == nil {
+			w.started(BackendFSNotify)
+			w.logger.Info("file watcher started", "dirs", w.dirs, "backend", BackendFSNotify, "files", w.tracked())
+			return nil
+		}
+		w.recordError(err)
+		if w.backend == BackendFSNotify {
+			close(w.done)
+			return err
+		}
+		w.logger.Warn("fsnotify unavailable, falling back to polling", "error", err)
+		w.backend = BackendPoll
 	}
+
+	w.startPoll()
+	w.started(BackendPoll)
+	w.logger.Info("file watcher started", "dirs", w.dirs, "backend", BackendPoll, "interval", w.interval, "files", w.tracked())
+	return nil
+}
+
+// Status returns a snapshot of the watcher's state. It is safe to call
+// from any goroutine.
+func (w *Watcher) Status() WatcherStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state
+}
+
+func (w *Watcher) started(backend string) {
+	w.mu.Lock()
+	w.state.Running = true
+	w.state.Backend = backend
+	w.mu.Unlock()
+}
+
+// stopped runs when the watch loop exits.
+func (w *Watcher) stopped() {
+	w.mu.Lock()
+	w.state.Running = false
+	w.state.PendingEvents = 0
+	w.mu.Unlock()
+}
+
+func (w *Watcher) recordError(err error) {
+	now := time.Now()
+	w.mu.Lock()
+	w.state.LastError = err.Error()
+	w.state.LastErrorAt = &now
+	w.mu.Unlock()
+}
+
+// setTracked records how many files are tracked.
+func (w *Watcher) setTracked(n int) {
+	watcherTracked.Set(float64(n))
+	w.mu.Lock()
+	w.state.TrackedFiles = n
+	w.mu.Unlock()
+}
+
+// tracked returns the number of files watched. The watch loop owns the
+// file maps, so other goroutines read the count from the status.
+func (w *Watcher) tracked() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state.TrackedFiles
+}
+
+// queue adds a change to the pending batch.
+func (w *Watcher) queue(path string, action Action, now time.Time) {
+	watcherEvents.Inc()
+	w.pending.add(path, action, now)
+	w.mu.Lock()
+	w.state.PendingEvents = w.pending.batch.events
+	w.mu.Unlock()
 }
 
-// Start begins watching for file changes.
-func (w *Watcher) Start() {
+// Backend returns the backend in use. After Start it is never auto.
+func (w *Watcher) Backend() string {
+	return w.backend
+}
+
+// Stop stops the file watcher and waits for it to finish.
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *Watcher) startPoll() {
 	w.scan()
 
 	go func() {
+		defer close(w.done)
+		defer w.stopped()
+		defer w.pending.stop()
 		ticker := time.NewTicker(w.interval)
 		defer ticker.Stop()
 
 		for {
 			select {
-			case <-ticker.C:
-				if w.detectChanges() {
-					w.logger.Info("file changes detected, reloading workers")
-					w.onChange()
+			case now := <-ticker.C:
+				w.detectChanges(now)
+			case <-w.pending.C():
+				w.reload()
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (w *Watcher) startNotify() error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		if errors.Is(err, syscall.EMFILE) {
+			err = inotifyLimitError("max_user_instances", 1024, err)
+		}
+		return err
+	}
+	for _, dir := range w.dirs {
+		if err := w.addTree(fsw, dir); err != nil {
+			fsw.Close()
+			return err
+		}
+	}
+	w.fsw = fsw
+	for _, dir := range w.dirs {
+		w.walkFiles(dir, func(path string, _ os.FileInfo, _ Action) {
+			w.files[path] = true
+		})
+	}
+	w.setTracked(len(w.files))
+
+	go func() {
+		defer close(w.done)
+		defer w.stopped()
+		defer fsw.Close()
+		defer w.pending.stop()
+
+		for {
+			select {
+			case ev, ok := <-fsw.Events:
+				if !ok {
+					return
+				}
+				w.handleEvent(ev)
+			case err, ok := <-fsw.Errors:
+				if !ok {
+					return
 				}
+				w.logger.Warn("file watcher error", "error", err)
+				w.recordError(err)
+				// Dropped events may have been changes to code.
+				if err == fsnotify.ErrEventOverflow {
+					w.queue("", ActionReload, time.Now())
+				}
+			case <-w.pending.C():
+				w.reload()
 			case <-w.ctx.Done():
 				return
 			}
 		}
 	}()
+	return nil
+}
 
-	w.logger.Info("file watcher started", "dirs", w.dirs, "interval", w.interval)
+// reload handles the pending batch if it is due.
+func (w *Watcher) reload() {
+	now := time.Now()
+	b, ok := w.pending.take(now)
+	if !ok {
+		return
+	}
+
+	var actions []Action
+	if b.config {
+		actions = append(actions, ActionConfig)
+	}
+	if b.reload {
+		actions = append(actions, w.codeAction(b))
+	}
+
+	shown := b.paths
+	if len(shown) > maxLoggedPaths {
+		shown = shown[:maxLoggedPaths]
+	}
+	msg := "file changes detected"
+	for _, action := range actions {
+		switch action {
+		case ActionReload:
+			msg += ", reloading workers"
+		case ActionInvalidate:
+			msg += ", invalidating opcache"
+		case ActionConfig:
+			msg += ", reloading config"
+		}
+	}
+	w.logger.Info(msg, "files", len(b.paths), "events", b.events, "paths", shown)
+
+	w.mu.Lock()
+	w.state.PendingEvents = 0
+	if len(actions) > 0 {
+		w.state.LastReload = &now
+		w.state.LastReloadPaths = shown
+	}
+	w.mu.Unlock()
+	if len(actions) == 0 {
+		return
+	}
+
+	watcherReloads.Inc()
+	watcherDebounced.Add(float64(b.events - 1))
+	for _, action := range actions {
+		w.onChange(action, b.paths)
+	}
 }
 
-// Stop stops the file watcher.
-func (w *Watcher) Stop() {
-	w.cancel()
+// codeAction picks how changed code reaches the workers: a reload, or
+// with the invalidate strategy an opcache invalidation unless the batch
+// calls for a full reload.
+func (w *Watcher) codeAction(b batch) Action {
+	if w.strategy != config.WatchStrategyInvalidate {
+		return ActionReload
+	}
+	reason := ""
+	switch {
+	case b.opaque:
+		reason = "changes that cannot be listed file by file"
+	case w.invalidateMax > 0 && len(b.paths) > w.invalidateMax:
+		reason = fmt.Sprintf("%d files changed, more than watch.invalidate_max %d", len(b.paths), w.invalidateMax)
+	default:
+		for _, path := range b.paths {
+			if rel, ok := w.rel(path); ok && w.match.needsFullReload(rel) {
+				reason = rel + " changed"
+				break
+			}
+		}
+	}
+	if reason == "" {
+		return ActionInvalidate
+	}
+	w.logger.Info("reloading workers instead of invalidating opcache", "reason", reason)
+	return ActionReload
 }
 
-func (w *Watcher) scan() {
-	for _, dir := range w.dirs {
-		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return nil
+// handleEvent registers newly created directories and queues the change
+// if ev changed a file the watcher cares about.
+func (w *Watcher) handleEvent(ev fsnotify.Event) {
+	now := time.Now()
+	if ev.Has(fsnotify.Create) {
+		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+			if w.skipDir(ev.Name) {
+				return
 			}
-			if info.IsDir() {
-				name := info.Name()
-				if name == "vendor" || name == "node_modules" || name == ".git" {
-					return filepath.SkipDir
-				}
-				return nil
+			if err := w.addTree(w.fsw, ev.Name); err != nil {
+				w.logger.Error("watching new directory, changes below it are missed", "path", ev.Name, "error", err)
+				w.recordError(err)
 			}
-			if w.isWatchedFile(path) {
-				w.mtimes[path] = info.ModTime()
+			// Files may have landed before the watch was added.
+			w.walkFiles(ev.Name, func(path string, _ os.FileInfo, action Action) {
+				w.files[path] = true
+				w.queue(path, action, now)
+			})
+			w.setTracked(len(w.files))
+			return
+		}
+	}
+	// A watched directory moved or deleted as a whole takes its files
+	// with it. Which ones is no longer known, so assume code.
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		if w.forgetTree(ev.Name) {
+			w.logger.Debug("directory removed", "path", ev.Name)
+			w.queue(ev.Name, ActionReload, now)
+			w.pending.markOpaque()
+			return
+		}
+	}
+	if ev.Op == fsnotify.Chmod {
+		return
+	}
+	action, ok := w.fileAction(ev.Name)
+	if !ok {
+		return
+	}
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		delete(w.files, ev.Name)
+	} else {
+		w.files[ev.Name] = true
+	}
+	w.setTracked(len(w.files))
+	w.logger.Debug("file changed", "path", ev.Name, "op", ev.Op.String(), "action", action)
+	w.queue(ev.Name, action, now)
+}
+
+// addTree watches dir and every directory below it. fsnotify watches are
+// not recursive.
+func (w *Watcher) addTree(fsw *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
 			}
+			return nil // vanished or unreadable below the root
+		}
+		if !d.IsDir() {
 			return nil
+		}
+		if path != dir && w.skipDir(path) {
+			return filepath.SkipDir
+		}
+		if err := fsw.Add(path); err != nil {
+			err = fmt.Errorf("watching %s: %w", path, err)
+			if errors.Is(err, syscall.ENOSPC) {
+				err = inotifyLimitError("max_user_watches", 524288, err)
+			}
+			return err
+		}
+		w.watched[path] = true
+		return nil
+	})
+}
+
+// forgetTree drops dir and the directories below it, reporting whether
+// dir was watched.
+func (w *Watcher) forgetTree(dir string) bool {
+	if !w.watched[dir] {
+		return false
+	}
+	prefix := dir + string(filepath.Separator)
+	for path := range w.watched {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(w.watched, path)
+			w.fsw.Remove(path) // already gone when the directory was deleted
+		}
+	}
+	for path := range w.files {
+		if strings.HasPrefix(path, prefix) {
+			delete(w.files, path)
+		}
+	}
+	w.setTracked(len(w.files))
+	return true
+}
+
+// inotifyLimitError explains an error caused by the per-user inotify
+// limit fs.inotify.<name>, including how to raise it.
+func inotifyLimitError(name string, suggested int, err error) error {
+	current := "unknown"
+	if b, rerr := os.ReadFile("/proc/sys/fs/inotify/" + name); rerr == nil {
+		current = strings.TrimSpace(string(b))
+	}
+	return fmt.Errorf("inotify limit fs.inotify.%s (currently %s) reached; raise it with "+
+		"`sysctl -w fs.inotify.%s=%d` (persist it in /etc/sysctl.d), exclude directories with watch.exclude, "+
+		"or set watch.backend to poll: %w", name, current, name, suggested, err)
+}
+
+// walkFiles calls fn for every file below dir the watcher cares about,
+// skipping excluded directories.
+func (w *Watcher) walkFiles(dir string, fn func(path string, info os.FileInfo, action Action)) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != dir && w.skipDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if action, ok := w.fileAction(path); ok {
+			fn(path, info, action)
+		}
+		return nil
+	})
+}
+
+// rel returns path relative to the watched directory holding it, with
+// forward slashes, as the include and exclude patterns expect.
+func (w *Watcher) rel(path string) (string, bool) {
+	for _, dir := range w.dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel), true
+		}
+	}
+	return "", false
+}
+
+// skipDir reports excluded directories, which are not descended into.
+// Dependencies and VCS metadata change wholesale and are excluded by
+// default.
+func (w *Watcher) skipDir(path string) bool {
+	rel, ok := w.rel(path)
+	return ok && rel != "." && w.match.excluded(rel)
+}
+
+func (w *Watcher) fileAction(path string) (Action, bool) {
+	rel, ok := w.rel(path)
+	if !ok {
+		return "", false
+	}
+	return w.match.match(rel)
+}
+
+func (w *Watcher) scan() {
+	for _, dir := range w.dirs {
+		w.walkFiles(dir, func(path string, info os.FileInfo, _ Action) {
+			w.mtimes[path] = info.ModTime()
 		})
 	}
+	w.setTracked(len(w.mtimes))
 }
 
-func (w *Watcher) detectChanges() bool {
-	changed := false
+// detectChanges rescans the tree and queues the watched files created,
+// modified or deleted since the previous scan.
+func (w *Watcher) detectChanges(now time.Time) {
 	currentFiles := make(map[string]time.Time)
 
 	for _, dir := range w.dirs {
-		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return nil
-			}
-			if info.IsDir() {
-				name := info.Name()
-				if name == "vendor" || name == "node_modules" || name == ".git" {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-			if w.isWatchedFile(path) {
-				currentFiles[path] = info.ModTime()
-				if oldTime, exists := w.mtimes[path]; exists {
-					if info.ModTime().After(oldTime) {
-						w.logger.Debug("file changed", "path", path)
-						changed = true
-					}
-				} else {
-					w.logger.Debug("new file detected", "path", path)
-					changed = true
+		w.walkFiles(dir, func(path string, info os.FileInfo, action Action) {
+			currentFiles[path] = info.ModTime()
+			if oldTime, exists := w.mtimes[path]; exists {
+				if info.ModTime().After(oldTime) {
+					w.logger.Debug("file changed", "path", path, "action", action)
+					w.queue(path, action, now)
 				}
+			} else {
+				w.logger.Debug("new file detected", "path", path, "action", action)
+				w.queue(path, action, now)
 			}
-			return nil
 		})
 	}
 
 	for path := range w.mtimes {
 		if _, exists := currentFiles[path]; !exists {
-			w.logger.Debug("file deleted", "path", path)
-			changed = true
+			action, _ := w.fileAction(path)
+			w.logger.Debug("file deleted", "path", path, "action", action)
+			w.queue(path, action, now)
 		}
 	}
 
 	w.mtimes = currentFiles
-	return changed
-}
-
-func (w *Watcher) isWatchedFile(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	for _, e := range w.exts {
-		if ext == e {
-			return true
-		}
-	}
-	return false
+	w.setTracked(len(w.mtimes))
 }
@@ -0,0 +1,362 @@
+package pool_test
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/pool"
+)
+
+// quiet is how long a test waits to be sure no further reload follows.
+const quiet = 400 * time.Millisecond
+
+type change struct {
+	action pool.Action
+	paths  []string
+}
+
+type reloadCounter struct {
+	n  atomic.Int32
+	ch chan change
+}
+
+func newReloadCounter() *reloadCounter {
+	return &reloadCounter{ch: make(chan change, 100)}
+}
+
+func (c *reloadCounter) reload(action pool.Action, paths []string) {
+	c.n.Add(1)
+	c.ch <- change{action, paths}
+}
+
+// expectOne waits for a reload, then checks that no second one follows.
+func (c *reloadCounter) expectOne(t *testing.T, what string) {
+	t.Helper()
+	c.expectAction(t, what, pool.ActionReload)
+}
+
+// expectAction waits for a reload asking for want, then checks that no
+// second one follows. It returns the changed paths.
+func (c *reloadCounter) expectAction(t *testing.T, what string, want pool.Action) []string {
+	t.Helper()
+	got := c.next(t, what, want)
+	c.expectNone(t, what)
+	return got.paths
+}
+
+// next waits for a reload asking for want.
+func (c *reloadCounter) next(t *testing.T, what string, want pool.Action) change {
+	t.Helper()
+	select {
+	case got := <-c.ch:
+		if got.action != want {
+			t.Errorf("%s: action %q, want %q", what, got.action, want)
+		}
+		return got
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: no reload", what)
+		return change{}
+	}
+}
+
+func (c *reloadCounter) expectNone(t *testing.T, what string) {
+	t.Helper()
+	select {
+	case got := <-c.ch:
+		t.Errorf("%s: unexpected %s", what, got.action)
+	case <-time.After(quiet):
+	}
+}
+
+func watchConfig(backend string, dirs ...string) config.WatchConfig {
+	cfg := config.Default().Watch
+	cfg.Dirs = dirs
+	cfg.Backend = backend
+	cfg.Interval = config.Duration(50 * time.Millisecond)
+	cfg.Debounce = config.Duration(100 * time.Millisecond)
+	cfg.MaxDelay = config.Duration(time.Second)
+	cfg.Cooldown = 0
+	return cfg
+}
+
+func newWatcher(t *testing.T, cfg config.WatchConfig, onChange func(pool.Action, []string)) *pool.Watcher {
+	t.Helper()
+	w, err := pool.NewWatcher(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), onChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func startWatcher(t *testing.T, dir, backend string) *reloadCounter {
+	t.Helper()
+	return startWatcherConfig(t, watchConfig(backend, dir))
+}
+
+func startWatcherConfig(t *testing.T, cfg config.WatchConfig) *reloadCounter {
+	t.Helper()
+	backend := cfg.Backend
+	c := newReloadCounter()
+	w := newWatcher(t, cfg, c.reload)
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Stop)
+	if backend != pool.BackendPoll && w.Backend() != backend {
+		t.Fatalf("Backend() = %s, want %s", w.Backend(), backend)
+	}
+	return c
+}
+
+func write(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherEvents(t *testing.T) {
+	for _, backend := range []string{pool.BackendFSNotify, pool.BackendPoll} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			os.MkdirAll(filepath.Join(dir, "app", "Http"), 0o755)
+			write(t, filepath.Join(dir, "app", "Http", "Kernel.php"), "<?php")
+			c := startWatcher(t, dir, backend)
+
+			write(t, filepath.Join(dir, "app", "User.php"), "<?php")
+			c.expectOne(t, "create")
+
+			// Make sure the poll backend sees a newer mtime.
+			time.Sleep(20 * time.Millisecond)
+			write(t, filepath.Join(dir, "app", "Http", "Kernel.php"), "<?php // changed")
+			c.expectOne(t, "modify")
+
+			os.Remove(filepath.Join(dir, "app", "User.php"))
+			c.expectOne(t, "delete")
+
+			write(t, filepath.Join(dir, "README.md"), "docs")
+			c.expectNone(t, "non-PHP file")
+
+			os.MkdirAll(filepath.Join(dir, "vendor", "acme"), 0o755)
+			write(t, filepath.Join(dir, "vendor", "acme", "lib.php"), "<?php")
+			c.expectNone(t, "vendor")
+		})
+	}
+}
+
+func TestWatcherNewDirectories(t *testing.T) {
+	dir := t.TempDir()
+	c := startWatcher(t, dir, pool.BackendFSNotify)
+
+	nested := filepath.Join(dir, "modules", "billing")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	c.expectNone(t, "empty directories")
+
+	// The new directories are watched too.
+	write(t, filepath.Join(nested, "Invoice.php"), "<?php")
+	c.expectOne(t, "file in new directory")
+
+	os.RemoveAll(filepath.Join(dir, "modules"))
+	c.expectOne(t, "directory removed")
+}
+
+func TestWatcherDirectoryMovedIn(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	c := startWatcher(t, dir, pool.BackendFSNotify)
+
+	os.MkdirAll(filepath.Join(outside, "pkg", "sub"), 0o755)
+	write(t, filepath.Join(outside, "pkg", "sub", "Helper.php"), "<?php")
+	if err := os.Rename(filepath.Join(outside, "pkg"), filepath.Join(dir, "pkg")); err != nil {
+		t.Fatal(err)
+	}
+	c.expectOne(t, "directory with PHP files moved in")
+
+	write(t, filepath.Join(dir, "pkg", "sub", "Helper.php"), "<?php // changed")
+	c.expectOne(t, "file in moved directory")
+}
+
+func TestWatcherFSNotifyMissingDir(t *testing.T) {
+	w := newWatcher(t, watchConfig(pool.BackendFSNotify, filepath.Join(t.TempDir(), "missing")), func(pool.Action, []string) {})
+	if err := w.Start(); err == nil {
+		w.Stop()
+		t.Fatal("Start succeeded on a missing directory")
+	}
+	w.Stop() // must not block after a failed Start
+
+	auto := newWatcher(t, watchConfig(pool.BackendAuto, filepath.Join(t.TempDir(), "missing")), func(pool.Action, []string) {})
+	if err := auto.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer auto.Stop()
+	if auto.Backend() != pool.BackendPoll {
+		t.Errorf("auto backend = %s, want fallback to poll", auto.Backend())
+	}
+}
+
+func TestWatcherBatchesBulkChanges(t *testing.T) {
+	dir := t.TempDir()
+	c := startWatcher(t, dir, pool.BackendFSNotify)
+
+	// Like composer dump-autoload or a git checkout: many files at once.
+	for i := range 200 {
+		write(t, filepath.Join(dir, fmt.Sprintf("File%d.php", i)), "<?php")
+	}
+	c.expectOne(t, "bulk change")
+}
+
+func TestWatcherMaxDelayUnderChurn(t *testing.T) {
+	dir := t.TempDir()
+	cfg := watchConfig(pool.BackendFSNotify, dir)
+	cfg.Debounce = config.Duration(300 * time.Millisecond)
+	cfg.MaxDelay = config.Duration(400 * time.Millisecond)
+	c := startWatcherConfig(t, cfg)
+
+	// A change every 50ms never leaves the 300ms quiet period debounce
+	// waits for; max_delay still reloads while the churn goes on.
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for i := 0; time.Now().Before(deadline); i++ {
+		write(t, filepath.Join(dir, "churn.php"), fmt.Sprintf("<?php // %d", i))
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := c.n.Load(); n < 2 || n > 5 {
+		t.Errorf("%d reloads during 1.5s of churn, want 2-5", n)
+	}
+}
+
+func TestWatcherRules(t *testing.T) {
+	for _, backend := range []string{pool.BackendFSNotify, pool.BackendPoll} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, d := range []string{"config/packages", "storage/framework", "bootstrap/cache", "templates"} {
+				os.MkdirAll(filepath.Join(dir, d), 0o755)
+			}
+			cfg := watchConfig(backend, dir)
+			cfg.Extensions = []string{".php", ".twig"}
+			cfg.Include = []config.WatchRule{
+				{Pattern: ".env", Action: config.WatchActionConfig},
+				{Pattern: "config/**/*.yaml", Action: config.WatchActionConfig},
+				{Pattern: "**/*.log", Action: config.WatchActionLog},
+			}
+			cfg.Exclude = []string{"storage/**", "bootstrap/cache/**"}
+			c := startWatcherConfig(t, cfg)
+
+			write(t, filepath.Join(dir, ".env"), "APP_ENV=local")
+			c.expectAction(t, ".env", pool.ActionConfig)
+
+			write(t, filepath.Join(dir, "config", "packages", "cache.yaml"), "pools: {}")
+			c.expectAction(t, "nested yaml", pool.ActionConfig)
+
+			write(t, filepath.Join(dir, "templates", "base.twig"), "{{ title }}")
+			c.expectOne(t, "custom extension")
+
+			write(t, filepath.Join(dir, "app.log"), "line")
+			c.expectNone(t, "log action")
+
+			write(t, filepath.Join(dir, "storage", "framework", "view.php"), "<?php")
+			write(t, filepath.Join(dir, "bootstrap", "cache", "services.php"), "<?php")
+			c.expectNone(t, "excluded directories")
+
+			// With excludes replaced, vendor is watched again.
+			os.MkdirAll(filepath.Join(dir, "vendor"), 0o755)
+			write(t, filepath.Join(dir, "vendor", "autoload.php"), "<?php")
+			c.expectOne(t, "vendor no longer excluded")
+		})
+	}
+}
+
+func TestWatcherBatchWithConfigAndCode(t *testing.T) {
+	dir := t.TempDir()
+	cfg := watchConfig(pool.BackendFSNotify, dir)
+	cfg.Include = []config.WatchRule{{Pattern: "maboo.yaml", Action: config.WatchActionConfig}}
+	c := startWatcherConfig(t, cfg)
+
+	write(t, filepath.Join(dir, "index.php"), "<?php")
+	write(t, filepath.Join(dir, "maboo.yaml"), "watch: {}")
+	c.next(t, "config first", pool.ActionConfig)
+	c.expectAction(t, "then code", pool.ActionReload)
+}
+
+func TestWatcherInvalidateStrategy(t *testing.T) {
+	dir := t.TempDir()
+	cfg := watchConfig(pool.BackendFSNotify, dir)
+	cfg.Strategy = config.WatchStrategyInvalidate
+	cfg.InvalidateMax = 3
+	c := startWatcherConfig(t, cfg)
+
+	view := filepath.Join(dir, "welcome.blade.php")
+	write(t, view, "<h1>{{ $title }}</h1>")
+	if paths := c.expectAction(t, "one template", pool.ActionInvalidate); len(paths) != 1 || paths[0] != view {
+		t.Errorf("invalidated %v, want [%s]", paths, view)
+	}
+
+	for i := range 5 {
+		write(t, filepath.Join(dir, fmt.Sprintf("View%d.php", i)), "<?php")
+	}
+	c.expectAction(t, "more than invalidate_max files", pool.ActionReload)
+
+	// composer.lock is a full_reload file, watched without a .lock extension.
+	write(t, filepath.Join(dir, "composer.lock"), "{}")
+	c.expectAction(t, "composer.lock", pool.ActionReload)
+
+	write(t, filepath.Join(dir, "Home.php"), "<?php")
+	write(t, filepath.Join(dir, ".env"), "APP_DEBUG=1")
+	c.expectAction(t, "code with .env", pool.ActionReload)
+}
+
+func TestWatcherStatus(t *testing.T) {
+	for _, backend := range []string{pool.BackendFSNotify, pool.BackendPoll} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			write(t, filepath.Join(dir, "index.php"), "<?php")
+			write(t, filepath.Join(dir, "README.md"), "docs")
+
+			c := newReloadCounter()
+			w := newWatcher(t, watchConfig(backend, dir), c.reload)
+			if st := w.Status(); st.Running {
+				t.Errorf("running before Start: %+v", st)
+			}
+			if err := w.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			st := w.Status()
+			if !st.Running || st.Backend != backend || st.TrackedFiles != 1 || len(st.Dirs) != 1 || st.LastReload != nil {
+				t.Errorf("after Start: %+v", st)
+			}
+
+			path := filepath.Join(dir, "User.php")
+			write(t, path, "<?php")
+			c.expectOne(t, "create")
+			st = w.Status()
+			if st.TrackedFiles != 2 || st.PendingEvents != 0 || st.LastReload == nil ||
+				len(st.LastReloadPaths) != 1 || st.LastReloadPaths[0] != path {
+				t.Errorf("after reload: %+v", st)
+			}
+
+			w.Stop()
+			if st := w.Status(); st.Running {
+				t.Errorf("running after Stop: %+v", st)
+			}
+		})
+	}
+}
+
+func TestWatcherStatusStartError(t *testing.T) {
+	w := newWatcher(t, watchConfig(pool.BackendFSNotify, filepath.Join(t.TempDir(), "missing")), func(pool.Action, []string) {})
+	if err := w.Start(); err == nil {
+		t.Fatal("Start succeeded on a missing directory")
+	}
+	defer w.Stop()
+	if st := w.Status(); st.Running || st.LastError == "" || st.LastErrorAt == nil {
+		t.Errorf("status after failed Start = %+v, want stopped with the error", st)
+	}
+}
@@ -1,23 +1,35 @@
 package pool
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os/exec"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/sadewadee/maboo/internal/features"
 	"github.com/sadewadee/maboo/internal/protocol"
 )
 
 // WorkerState represents the current state of a worker.
+//
+// A worker is Idle while it waits in the pool's idle queue and Busy from
+// the moment a request takes it off the queue until it is put back. A
+// worker retired by a reload goes Draining: an idle one is stopped at once,
+// a busy one finishes its request first. Either way it is never handed
+// out again and ends Stopped.
 type WorkerState int
 
 const (
-	StateIdle    WorkerState = iota // Worker is ready for a request
-	StateBusy                       // Worker is processing a request
-	StateStopped                    // Worker has been stopped
+	StateIdle     WorkerState = iota // Worker is ready for a request
+	StateBusy                        // Worker is processing a request
+	StateDraining                    // Worker is retired and stops once its request is done
+	StateStopped                     // Worker has been stopped
 )
 
 // Worker represents a single PHP worker process.
@@ -30,11 +42,28 @@ type Worker struct {
 	jobs     atomic.Int64
 	lastUsed atomic.Int64 // unix timestamp
 	mu       sync.Mutex
+
+	maxFrameSize int          // largest frame accepted from the worker
+	features     features.Set // declared in its first WORKER_READY; nil = all
+
+	invalidateMu sync.Mutex
+	invalidate   []string // files to drop from opcache before the next request
+
+	stopOnce sync.Once // the pool and a replacement may both stop a worker
+	stopErr  error
+	stopped  chan struct{} // closed once stopped
+
+	logger  *slog.Logger             // for the LOG frames of its requests; set by the pool
+	current atomic.Pointer[activity] // the REQUEST being served; nil while idle
+	killed  atomic.Bool              // Kill was called; the worker is replaced, never put back
+	traceMu sync.Mutex               // one backtrace asked for at a time
+	traces  chan string              // backtraces sent in answer to SIGUSR2
 }
 
-// NewWorker creates and starts a new PHP worker process.
-func NewWorker(id int, phpBinary string, workerScript string, env []string) (*Worker, error) {
-	cmd := exec.Command(phpBinary, workerScript)
+// NewWorker creates and starts a new PHP worker process, running
+// phpBinary with args: php.ini overrides, then the worker script.
+func NewWorker(id int, phpBinary string, args []string, env []string, maxFrameSize int) (*Worker, error) {
+	cmd := exec.Command(phpBinary, args...)
 	cmd.Env = env
 
 	stdin, err := cmd.StdinPipe()
@@ -55,28 +84,40 @@ func NewWorker(id int, phpBinary string, workerScript string, env []string) (*Wo
 	}
 
 	w := &Worker{
-		id:     id,
-		cmd:    cmd,
-		stdin:  stdin,
-		stdout: stdout,
+		id:           id,
+		cmd:          cmd,
+		stdin:        stdin,
+		stdout:       stdout,
+		maxFrameSize: maxFrameSize,
+		stopped:      make(chan struct{}),
+		logger:       slog.New(slog.DiscardHandler),
+		traces:       make(chan string, 1),
 	}
 	w.state.Store(int32(StateIdle))
 	w.lastUsed.Store(time.Now().Unix())
 
 	// Wait for WORKER_READY signal from PHP
-	frame, err := protocol.ReadFrame(stdout)
+	frame, err := protocol.ReadFrameMax(stdout, maxFrameSize)
 	if err != nil {
 		cmd.Process.Kill()
 		return nil, fmt.Errorf("waiting for worker ready: %w", err)
 	}
-	if frame.Type != protocol.TypeWorkerReady {
+	ready, err := protocol.DecodeWorkerReady(frame)
+	if err != nil {
 		cmd.Process.Kill()
-		return nil, fmt.Errorf("expected WORKER_READY, got type 0x%02x", frame.Type)
+		return nil, fmt.Errorf("waiting for worker ready: %w", err)
 	}
+	w.features = ready.Features
 
 	return w, nil
 }
 
+// Implements reports whether the worker declared feature f, or declared
+// no features at all.
+func (w *Worker) Implements(f string) bool {
+	return w.features == nil || w.features.Has(f)
+}
+
 // ID returns the worker's unique identifier.
 func (w *Worker) ID() int {
 	return w.id
@@ -87,35 +128,137 @@ func (w *Worker) State() WorkerState {
 	return WorkerState(w.state.Load())
 }
 
+// checkout marks the worker busy for a request that took it off the idle
+// queue. It fails for a worker retired while it waited there, which the
+// request must then pass over.
+func (w *Worker) checkout() bool {
+	return w.state.CompareAndSwap(int32(StateIdle), int32(StateBusy))
+}
+
+// checkin marks the worker idle again once its request is done. It fails
+// for a worker retired meanwhile, which must be stopped instead of queued.
+func (w *Worker) checkin() bool {
+	return w.state.CompareAndSwap(int32(StateBusy), int32(StateIdle))
+}
+
+// retireIdle retires the worker if it is idle and reports whether it did;
+// the caller then stops it.
+func (w *Worker) retireIdle() bool {
+	return w.state.CompareAndSwap(int32(StateIdle), int32(StateDraining))
+}
+
+// retireBusy retires the worker if it is busy and reports whether it did.
+func (w *Worker) retireBusy() bool {
+	return w.state.CompareAndSwap(int32(StateBusy), int32(StateDraining))
+}
+
+// retire retires the worker. It reports true for an idle worker, which
+// the caller then stops; a busy one is stopped by the pool when its
+// request is done.
+func (w *Worker) retire() bool {
+	for {
+		if w.retireIdle() {
+			return true
+		}
+		if w.retireBusy() {
+			return false
+		}
+		if s := w.State(); s == StateDraining || s == StateStopped {
+			return false
+		}
+	}
+}
+
 // Jobs returns the number of requests this worker has handled.
 func (w *Worker) Jobs() int64 {
 	return w.jobs.Load()
 }
 
-// Exec sends a request frame to the worker and reads the response.
-func (w *Worker) Exec(req *protocol.Frame) (*protocol.Frame, error) {
+// Invalidate queues files to drop from the worker's opcache. They are
+// sent ahead of the next request, so a busy worker is not interrupted.
+func (w *Worker) Invalidate(files []string) {
+	w.invalidateMu.Lock()
+	w.invalidate = append(w.invalidate, files...)
+	w.invalidateMu.Unlock()
+}
+
+// flushInvalidate sends the queued files and waits for the worker to
+// acknowledge them with WORKER_READY. The caller holds w.mu.
+func (w *Worker) flushInvalidate() error {
+	w.invalidateMu.Lock()
+	files := w.invalidate
+	w.invalidate = nil
+	w.invalidateMu.Unlock()
+	if len(files) == 0 {
+		return nil
+	}
+
+	frame, err := protocol.EncodeInvalidate(files)
+	if err != nil {
+		return err
+	}
+	if err := protocol.WriteFrame(w.stdin, frame); err != nil {
+		return fmt.Errorf("sending invalidate to worker %d: %w", w.id, err)
+	}
+	ack, err := protocol.ReadFrameMax(w.stdout, w.maxFrameSize)
+	if err != nil {
+		return fmt.Errorf("reading invalidate ack from worker %d: %w", w.id, err)
+	}
+	if ack.Type != protocol.TypeWorkerReady {
+		return fmt.Errorf("expected WORKER_READY after invalidate from worker %d, got type 0x%02x", w.id, ack.Type)
+	}
+	return nil
+}
+
+// errNotSent marks an Exec that failed before the worker read the request,
+// as when its process is gone, so the request can safely be sent to
+// another worker.
+var errNotSent = errors.New("request not sent")
+
+// Exec sends a request frame to the worker and reads the response, and
+// for a REQUEST with FlagDebug the WORKER_STATS after it; otherwise the
+// stats are nil. A request whose body was spilled to disk is streamed
+// from the file into the pipe in chunks.
+func (w *Worker) Exec(req *protocol.Frame) (*protocol.Frame, *protocol.WorkerStats, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	w.state.Store(int32(StateBusy))
-	defer func() {
-		w.state.Store(int32(StateIdle))
-		w.lastUsed.Store(time.Now().Unix())
-		w.jobs.Add(1)
-	}()
+	if err := w.flushInvalidate(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errNotSent, err)
+	}
+
+	if req.Type == protocol.TypeRequest {
+		w.current.Store(&activity{req: req, started: time.Now()})
+		defer w.current.Store(nil)
+	}
 
 	// Send request to PHP worker
 	if err := protocol.WriteFrame(w.stdin, req); err != nil {
-		return nil, fmt.Errorf("sending request to worker %d: %w", w.id, err)
+		return nil, nil, fmt.Errorf("sending request to worker %d: %w: %w", w.id, errNotSent, err)
 	}
+	defer func() {
+		w.lastUsed.Store(time.Now().Unix())
+		w.jobs.Add(1)
+	}()
 
 	// Read response from PHP worker
-	resp, err := protocol.ReadFrame(w.stdout)
+	resp, err := w.ReadFrame()
 	if err != nil {
-		return nil, fmt.Errorf("reading response from worker %d: %w", w.id, err)
+		return nil, nil, fmt.Errorf("reading response from worker %d: %w", w.id, err)
+	}
+	if req.Type != protocol.TypeRequest || req.Flags&protocol.FlagDebug == 0 || resp.Type != protocol.TypeResponse {
+		return resp, nil, nil
 	}
 
-	return resp, nil
+	frame, err := w.ReadFrame()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading stats from worker %d: %w", w.id, err)
+	}
+	stats, err := protocol.DecodeWorkerStats(frame)
+	if err != nil {
+		return nil, nil, fmt.Errorf("worker %d: %w", w.id, err)
+	}
+	return resp, stats, nil
 }
 
 // ExecStream sends a stream frame to the worker (non-blocking response).
@@ -126,9 +269,28 @@ func (w *Worker) ExecStream(frame *protocol.Frame) error {
 	return protocol.WriteFrame(w.stdin, frame)
 }
 
-// ReadFrame reads a single frame from the worker's stdout.
+// ReadFrame reads a single frame from the worker's stdout. LOG frames
+// sent ahead of it are logged, or taken as the backtrace Backtrace asked
+// for, and skipped.
 func (w *Worker) ReadFrame() (*protocol.Frame, error) {
-	return protocol.ReadFrame(w.stdout)
+	for {
+		frame, err := protocol.ReadFrameMax(w.stdout, w.maxFrameSize)
+		if err != nil || frame.Type != protocol.TypeLog {
+			return frame, err
+		}
+		rec, err := protocol.DecodeLog(frame)
+		if err != nil {
+			return nil, fmt.Errorf("worker %d: %w", w.id, err)
+		}
+		if rec.Backtrace != "" {
+			select {
+			case w.traces <- rec.Backtrace:
+			default: // nobody is waiting for it
+			}
+			continue
+		}
+		w.logger.Log(context.Background(), logLevel(rec.Level), rec.Message, "worker_id", w.id)
+	}
 }
 
 // Ping sends a health check to the worker and waits for a pong.
@@ -141,7 +303,7 @@ func (w *Worker) Ping(timeout time.Duration) error {
 	}
 
 	// TODO: implement timeout using goroutine + channel
-	frame, err := protocol.ReadFrame(w.stdout)
+	frame, err := protocol.ReadFrameMax(w.stdout, w.maxFrameSize)
 	if err != nil {
 		return fmt.Errorf("reading pong from worker %d: %w", w.id, err)
 	}
@@ -151,8 +313,17 @@ func (w *Worker) Ping(timeout time.Duration) error {
 	return nil
 }
 
-// Stop gracefully stops the worker process.
+// Stop gracefully stops the worker process. Later calls wait for the
+// first and return its result.
 func (w *Worker) Stop() error {
+	w.stopOnce.Do(func() {
+		w.stopErr = w.stop()
+		close(w.stopped)
+	})
+	return w.stopErr
+}
+
+func (w *Worker) stop() error {
 	w.state.Store(int32(StateStopped))
 
 	// Try graceful shutdown first
@@ -167,6 +338,9 @@ func (w *Worker) Stop() error {
 
 	select {
 	case err := <-done:
+		if w.killed.Load() {
+			return nil // Kill ended it
+		}
 		return err
 	case <-time.After(5 * time.Second):
 		// Force kill if graceful shutdown fails
@@ -174,10 +348,12 @@ func (w *Worker) Stop() error {
 	}
 }
 
-// IsAlive checks if the worker process is still running.
+// IsAlive checks if the worker process is still running. It probes the
+// process with signal 0 rather than reading cmd.ProcessState, which Stop
+// may be filling in concurrently.
 func (w *Worker) IsAlive() bool {
 	if w.cmd.Process == nil {
 		return false
 	}
-	return w.cmd.ProcessState == nil || !w.cmd.ProcessState.Exited()
+	return w.cmd.Process.Signal(syscall.Signal(0)) == nil
 }
@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sadewadee/maboo/internal/scratch"
+)
+
+// DefaultBodyMemoryLimit is how much of a request body NewRequestFrame
+// keeps in memory before spilling it to disk.
+const DefaultBodyMemoryLimit = 8 << 20
+
+// maxPayloadSize is the largest payload the 32-bit size field describes.
+const maxPayloadSize = 1<<32 - 1
+
+// Body is a request body read ahead of sending it to a worker: in memory
+// while it is small, in a temporary file once it passes the memory limit.
+// The file is created in a scratch.Area, and counts against its quota
+// until Close releases it.
+type Body struct {
+	mem  []byte
+	file *scratch.File
+	size int64
+}
+
+// ReadBody reads r to the end. Up to memLimit bytes are kept in memory;
+// a longer body is written to a temporary file in area as it is read, so
+// memory use stays flat whatever its size. When area's quota is reached
+// the error wraps a *scratch.QuotaError.
+func ReadBody(r io.Reader, memLimit int64, area *scratch.Area) (*Body, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, memLimit+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+	if n <= memLimit {
+		return &Body{mem: buf.Bytes(), size: n}, nil
+	}
+
+	f, err := area.Create()
+	if err != nil {
+		return nil, fmt.Errorf("spilling request body: %w", err)
+	}
+	b := &Body{file: f}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("spilling request body: %w", err)
+	}
+	rest, err := io.Copy(f, r)
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("spilling request body: %w", err)
+	}
+	b.size = n + rest
+	if b.size > maxPayloadSize {
+		b.Close()
+		return nil, fmt.Errorf("request body of %d bytes exceeds the frame limit of %d", b.size, int64(maxPayloadSize))
+	}
+	return b, nil
+}
+
+// Size returns the body's length in bytes.
+func (b *Body) Size() int64 { return b.size }
+
+// Spilled reports whether the body was written to disk.
+func (b *Body) Spilled() bool { return b.file != nil }
+
+// Reader returns a reader over the whole body. Each call starts from the
+// beginning, so a frame can be written again after a failed attempt.
+func (b *Body) Reader() io.Reader {
+	if b.file != nil {
+		return io.NewSectionReader(b.file, 0, b.size)
+	}
+	return bytes.NewReader(b.mem)
+}
+
+// Close removes a spilled body's file. It is safe to call more than once.
+func (b *Body) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	b.file = nil
+	return err
+}
+
+// NewRequestFrame builds the REQUEST frame for r, reading its body with
+// ReadBody. The caller must Close the returned Body once the worker has
+// answered.
+func NewRequestFrame(r *http.Request, memLimit int64, area *scratch.Area) (*Frame, *Body, error) {
+	var body io.Reader
+	if r.Body != nil && r.Body != http.NoBody {
+		body = r.Body
+	}
+	return EncodeRequestBody(NewRequestHeader(r), body, memLimit, area)
+}
+
+// EncodeRequestBody creates a REQUEST frame whose body is read from body
+// (nil for none) with ReadBody. The frame carries a spilled body by
+// reference, and WriteFrame streams it from disk; the caller must Close
+// the returned Body once the worker has answered.
+func EncodeRequestBody(req *RequestHeader, body io.Reader, memLimit int64, area *scratch.Area) (*Frame, *Body, error) {
+	b := &Body{}
+	if body != nil {
+		var err error
+		if b, err = ReadBody(body, memLimit, area); err != nil {
+			return nil, nil, err
+		}
+	}
+	f, err := EncodeRequest(req, nil)
+	if err != nil {
+		b.Close()
+		return nil, nil, err
+	}
+	if b.Spilled() {
+		f.Body = b
+	} else {
+		f.Payload = b.mem
+	}
+	return f, b, nil
+}
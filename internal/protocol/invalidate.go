@@ -0,0 +1,32 @@
+package protocol
+
+import "fmt"
+
+// InvalidateHeader lists the files a worker should drop from opcache.
+type InvalidateHeader struct {
+	Files []string `msgpack:"files"`
+}
+
+// EncodeInvalidate creates an INVALIDATE frame for files.
+func EncodeInvalidate(files []string) (*Frame, error) {
+	headers, err := MarshalMsgpack(&InvalidateHeader{Files: files})
+	if err != nil {
+		return nil, fmt.Errorf("encoding invalidate headers: %w", err)
+	}
+	return &Frame{
+		Type:    TypeInvalidate,
+		Headers: headers,
+	}, nil
+}
+
+// DecodeInvalidate extracts the file list from an INVALIDATE frame.
+func DecodeInvalidate(f *Frame) ([]string, error) {
+	if f.Type != TypeInvalidate {
+		return nil, fmt.Errorf("expected INVALIDATE frame, got type 0x%02x", f.Type)
+	}
+	var h InvalidateHeader
+	if err := UnmarshalMsgpack(f.Headers, &h); err != nil {
+		return nil, fmt.Errorf("decoding invalidate headers: %w", err)
+	}
+	return h.Files, nil
+}
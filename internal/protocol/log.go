@@ -0,0 +1,37 @@
+package protocol
+
+import "fmt"
+
+// LogRecord is a message a worker sends in a LOG frame while it serves a
+// request, ahead of its RESPONSE. A worker implementing the dump feature
+// sends one with the PHP backtrace of the request when it receives
+// SIGUSR2.
+type LogRecord struct {
+	Level     string `msgpack:"level"` // debug, info, warn or error
+	Message   string `msgpack:"message"`
+	Backtrace string `msgpack:"backtrace,omitempty"` // set in answer to SIGUSR2
+}
+
+// EncodeLog creates a LOG frame.
+func EncodeLog(r *LogRecord) (*Frame, error) {
+	headers, err := MarshalMsgpack(r)
+	if err != nil {
+		return nil, fmt.Errorf("encoding log record: %w", err)
+	}
+	return &Frame{
+		Type:    TypeLog,
+		Headers: headers,
+	}, nil
+}
+
+// DecodeLog extracts the record from a LOG frame.
+func DecodeLog(f *Frame) (*LogRecord, error) {
+	if f.Type != TypeLog {
+		return nil, fmt.Errorf("expected LOG frame, got type 0x%02x", f.Type)
+	}
+	var r LogRecord
+	if err := UnmarshalMsgpack(f.Headers, &r); err != nil {
+		return nil, fmt.Errorf("decoding log record: %w", err)
+	}
+	return &r, nil
+}
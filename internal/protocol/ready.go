@@ -0,0 +1,40 @@
+package protocol
+
+import "fmt"
+
+// ReadyHeader is what a worker declares in its first WORKER_READY. The
+// ones after each request carry no headers.
+type ReadyHeader struct {
+	// Features lists the optional features the worker implements, by
+	// the names in MABOO_FEATURES. A worker declaring nothing is taken to
+	// implement all of them, as workers did before they could declare.
+	Features []string `msgpack:"features,omitempty"`
+}
+
+// EncodeWorkerReady creates a WORKER_READY frame declaring features.
+func EncodeWorkerReady(features []string) (*Frame, error) {
+	headers, err := MarshalMsgpack(&ReadyHeader{Features: features})
+	if err != nil {
+		return nil, fmt.Errorf("encoding worker ready headers: %w", err)
+	}
+	return &Frame{
+		Type:    TypeWorkerReady,
+		Headers: headers,
+	}, nil
+}
+
+// DecodeWorkerReady extracts the declaration from a WORKER_READY frame,
+// which is empty when the frame has no headers.
+func DecodeWorkerReady(f *Frame) (*ReadyHeader, error) {
+	if f.Type != TypeWorkerReady {
+		return nil, fmt.Errorf("expected WORKER_READY frame, got type 0x%02x", f.Type)
+	}
+	var h ReadyHeader
+	if len(f.Headers) == 0 {
+		return &h, nil
+	}
+	if err := UnmarshalMsgpack(f.Headers, &h); err != nil {
+		return nil, fmt.Errorf("decoding worker ready headers: %w", err)
+	}
+	return &h, nil
+}
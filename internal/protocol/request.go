@@ -1,6 +1,13 @@
 package protocol
 
-import "fmt"
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
 
 // RequestHeader holds HTTP request metadata sent to PHP workers.
 type RequestHeader struct {
@@ -11,7 +18,68 @@ type RequestHeader struct {
 	RemoteAddr  string            `msgpack:"remote_addr"`
 	ServerName  string            `msgpack:"server_name"`
 	ServerPort  string            `msgpack:"server_port"`
-	Protocol    string            `msgpack:"protocol"`
+	Protocol    string            `msgpack:"protocol"` // as the client spoke it: HTTP/1.1, HTTP/2.0, HTTP/3.0
+
+	// The client's TLS connection, as $_SERVER names it (see
+	// phpengine.TLSServerVars); empty over plain HTTP.
+	HTTPS             bool   `msgpack:"https,omitempty"`
+	SSLProtocol       string `msgpack:"ssl_protocol,omitempty"`
+	SSLCipher         string `msgpack:"ssl_cipher,omitempty"`
+	SSLSessionResumed bool   `msgpack:"ssl_session_resumed,omitempty"`
+	ALPN              string `msgpack:"alpn,omitempty"`
+
+	// MaxExecutionTime is the set_time_limit the worker script applies
+	// for this request, in seconds; 0 keeps its own limit.
+	MaxExecutionTime int `msgpack:"max_execution_time,omitempty"`
+
+	// Script is the file maboo resolved for the request: the entry
+	// script, a rewrite's target or an internal request's entry. A worker
+	// serving several scripts can dispatch on it.
+	Script string `msgpack:"script,omitempty"`
+}
+
+// NewRequestHeader builds the REQUEST metadata for r. Every request header
+// is forwarded (multiple values joined with ", "), so headers added by
+// middleware, such as a generated traceparent, reach the worker's $_SERVER.
+func NewRequestHeader(r *http.Request) *RequestHeader {
+	headers := make(map[string]string, len(r.Header))
+	for k, v := range r.Header {
+		headers[k] = strings.Join(v, ", ")
+	}
+
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+		port = "80"
+		if r.TLS != nil {
+			port = "443"
+		}
+	}
+
+	h := &RequestHeader{
+		Method:      r.Method,
+		URI:         r.URL.RequestURI(),
+		QueryString: r.URL.RawQuery,
+		Headers:     headers,
+		RemoteAddr:  r.RemoteAddr,
+		ServerName:  host,
+		ServerPort:  port,
+		Protocol:    r.Proto,
+	}
+	if r.TLS != nil {
+		h.SetTLS(phpengine.TLSServerVars(r.TLS))
+	}
+	return h
+}
+
+// SetTLS marks the request as made over TLS and fills in the connection's
+// details from their $_SERVER entries.
+func (h *RequestHeader) SetTLS(vars map[string]string) {
+	h.HTTPS = true
+	h.SSLProtocol = vars["SSL_PROTOCOL"]
+	h.SSLCipher = vars["SSL_CIPHER"]
+	h.SSLSessionResumed = vars["SSL_SESSION_RESUMED"] == "Resumed"
+	h.ALPN = vars["SSL_ALPN_PROTOCOL"]
 }
 
 // EncodeRequest creates a REQUEST frame from HTTP request data.
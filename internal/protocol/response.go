@@ -1,11 +1,101 @@
 package protocol
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/vmihailenco/msgpack/v5"
+)
 
 // ResponseHeader holds HTTP response metadata from PHP workers.
 type ResponseHeader struct {
 	Status  int               `msgpack:"status"`
 	Headers map[string]string `msgpack:"headers"`
+
+	// Fields are Headers in the order the worker encoded them, as a PHP
+	// array keeps its keys; a map loses it.
+	Fields []phpengine.HeaderField `msgpack:"-"`
+}
+
+// EncodeMsgpack writes h as the map {status, headers}. The headers are
+// taken from Fields, in order, when set.
+func (h *ResponseHeader) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if err := enc.EncodeMapLen(2); err != nil {
+		return err
+	}
+	if err := enc.EncodeString("status"); err != nil {
+		return err
+	}
+	if err := enc.EncodeInt(int64(h.Status)); err != nil {
+		return err
+	}
+	if err := enc.EncodeString("headers"); err != nil {
+		return err
+	}
+	if h.Fields == nil {
+		return enc.Encode(h.Headers)
+	}
+	if err := enc.EncodeMapLen(len(h.Fields)); err != nil {
+		return err
+	}
+	for _, f := range h.Fields {
+		if err := enc.EncodeString(f.Name); err != nil {
+			return err
+		}
+		if err := enc.EncodeString(f.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeMsgpack reads the map EncodeMsgpack writes, filling in both
+// Headers and Fields. Unknown keys are skipped.
+func (h *ResponseHeader) DecodeMsgpack(dec *msgpack.Decoder) error {
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+	for range n {
+		key, err := dec.DecodeString()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "status":
+			h.Status, err = dec.DecodeInt()
+		case "headers":
+			err = h.decodeHeaders(dec)
+		default:
+			err = dec.Skip()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (h *ResponseHeader) decodeHeaders(dec *msgpack.Decoder) error {
+	n, err := dec.DecodeMapLen()
+	if err != nil || n < 0 {
+		return err // nil headers
+	}
+	h.Headers = make(map[string]string, n)
+	h.Fields = make([]phpengine.HeaderField, 0, n)
+	for range n {
+		name, err := dec.DecodeString()
+		if err != nil {
+			return err
+		}
+		value, err := dec.DecodeString()
+		if err != nil {
+			return err
+		}
+		h.Headers[name] = value
+		h.Fields = append(h.Fields, phpengine.HeaderField{Name: name, Value: value})
+	}
+	return nil
 }
 
 // EncodeResponse creates a RESPONSE frame from response data.
@@ -0,0 +1,38 @@
+package protocol
+
+import "fmt"
+
+// WorkerStats is what one request cost a PHP worker, sent in the
+// WORKER_STATS frame that follows the RESPONSE to a request with
+// FlagDebug. Workers collect nothing for requests without it.
+type WorkerStats struct {
+	OpcacheHits       int64 `msgpack:"opcache_hits"`
+	OpcacheMisses     int64 `msgpack:"opcache_misses"`
+	IncludedFiles     int   `msgpack:"included_files"`
+	PeakMemory        int64 `msgpack:"peak_memory"`
+	RealpathCacheSize int64 `msgpack:"realpath_cache_size"`
+}
+
+// EncodeWorkerStats creates a WORKER_STATS frame.
+func EncodeWorkerStats(s *WorkerStats) (*Frame, error) {
+	headers, err := MarshalMsgpack(s)
+	if err != nil {
+		return nil, fmt.Errorf("encoding worker stats: %w", err)
+	}
+	return &Frame{
+		Type:    TypeWorkerStats,
+		Headers: headers,
+	}, nil
+}
+
+// DecodeWorkerStats extracts the statistics from a WORKER_STATS frame.
+func DecodeWorkerStats(f *Frame) (*WorkerStats, error) {
+	if f.Type != TypeWorkerStats {
+		return nil, fmt.Errorf("expected WORKER_STATS frame, got type 0x%02x", f.Type)
+	}
+	var s WorkerStats
+	if err := UnmarshalMsgpack(f.Headers, &s); err != nil {
+		return nil, fmt.Errorf("decoding worker stats: %w", err)
+	}
+	return &s, nil
+}
@@ -2,11 +2,76 @@ package protocol
 
 import "fmt"
 
+// Stream events. Go reports connect, resume, message and close to PHP, and
+// PHP answers each with one STREAM_DATA frame holding a command or a batch
+// of them. Go confirms join with joined, or room_full, and leave with left,
+// answers presence with presence, and sends tick periodically if
+// configured. Before a join to a private room, Go sends authorize, which
+// PHP answers with allow or deny.
+const (
+	EventConnect = "connect" // payload is a msgpack ConnectInfo
+	EventResume  = "resume"  // a dropped connection came back; payload is a msgpack ConnectInfo
+	EventMessage = "message" // to PHP: data from a client; from PHP: send data
+	EventClose   = "close"   // to PHP: client gone; from PHP: close the connection
+	EventTick    = "tick"    // no connection; payload is a msgpack TickInfo
+
+	EventAuthorize = "authorize" // may the connection join room?
+	EventAllow     = "allow"     // answers authorize
+	EventDeny      = "deny"      // answers authorize; data is the reason
+
+	EventJoin                  = "join"
+	EventLeave                 = "leave"
+	EventBroadcastExceptSender = "broadcast_except_sender"
+	EventBroadcastPattern      = "broadcast_pattern" // room is a pattern, e.g. game:42:*
+	EventBatch                 = "batch"             // payload is a msgpack array of StreamCommand
+	EventAccept                = "accept"
+	EventReject                = "reject"
+	EventPresence              = "presence" // from PHP: ask who is in a room; to PHP: the answer, a msgpack map
+
+	EventJoined   = "joined"
+	EventLeft     = "left"
+	EventRoomFull = "room_full" // a join refused because the room is at its maximum size
+)
+
 // StreamHeader holds WebSocket stream metadata.
 type StreamHeader struct {
-	ConnectionID string `msgpack:"conn_id"`
-	Event        string `msgpack:"event"` // "connect", "message", "close"
-	Room         string `msgpack:"room"`
+	ConnectionID  string `msgpack:"conn_id"`
+	Event         string `msgpack:"event"`
+	Room          string `msgpack:"room"`
+	ExcludeSender bool   `msgpack:"exclude_sender"` // leave the sending connection out of a room broadcast
+	UserID        string `msgpack:"user_id,omitempty"`
+	Code          int    `msgpack:"code,omitempty"`
+	MaxSize       int    `msgpack:"max_size,omitempty"`
+}
+
+// ConnectInfo describes a new WebSocket connection to PHP.
+type ConnectInfo struct {
+	RemoteAddr  string            `msgpack:"remote_addr"` // client address, behind trusted proxies too
+	Path        string            `msgpack:"path"`
+	Query       string            `msgpack:"query"`
+	Headers     map[string]string `msgpack:"headers"` // only those in websocket.forward_headers
+	Subprotocol string            `msgpack:"subprotocol"`
+	Auth        string            `msgpack:"auth"` // body of the websocket.auth_endpoint answer
+}
+
+// TickInfo is the payload of a tick event.
+type TickInfo struct {
+	Time        int64 `msgpack:"time"` // Unix time in milliseconds
+	Connections int   `msgpack:"connections"`
+	Rooms       int   `msgpack:"rooms"`
+	Users       int   `msgpack:"users"`
+}
+
+// StreamCommand is one instruction from PHP in answer to a stream event.
+type StreamCommand struct {
+	Event         string `msgpack:"event"`
+	ConnectionID  string `msgpack:"conn_id"` // defaults to the connection the event came from, if any
+	Room          string `msgpack:"room"`
+	ExcludeSender bool   `msgpack:"exclude_sender"`
+	Data          []byte `msgpack:"data"`     // message, or the close reason for close and reject
+	UserID        string `msgpack:"user_id"`  // accept: the user the connection belongs to; message, close: every connection of this user
+	Code          int    `msgpack:"code"`     // close and reject: the WebSocket close code
+	MaxSize       int    `msgpack:"max_size"` // join: the most members the room allows from now on
 }
 
 // EncodeStreamData creates a STREAM_DATA frame for WebSocket communication.
@@ -35,11 +100,37 @@ func DecodeStreamData(f *Frame) (*StreamHeader, []byte, error) {
 	return &header, f.Payload, nil
 }
 
+// DecodeStreamCommands extracts the commands from PHP's answer to a stream
+// event: the batch in the payload, or the single command in the header.
+func DecodeStreamCommands(f *Frame) ([]StreamCommand, error) {
+	header, data, err := DecodeStreamData(f)
+	if err != nil {
+		return nil, err
+	}
+	if header.Event != EventBatch {
+		return []StreamCommand{{
+			Event:         header.Event,
+			ConnectionID:  header.ConnectionID,
+			Room:          header.Room,
+			ExcludeSender: header.ExcludeSender,
+			Data:          data,
+			UserID:        header.UserID,
+			Code:          header.Code,
+			MaxSize:       header.MaxSize,
+		}}, nil
+	}
+	var cmds []StreamCommand
+	if err := UnmarshalMsgpack(data, &cmds); err != nil {
+		return nil, fmt.Errorf("decoding stream batch: %w", err)
+	}
+	return cmds, nil
+}
+
 // EncodeStreamClose creates a STREAM_CLOSE frame.
 func EncodeStreamClose(streamID uint16, connID string) (*Frame, error) {
 	header := &StreamHeader{
 		ConnectionID: connID,
-		Event:        "close",
+		Event:        EventClose,
 	}
 	headers, err := MarshalMsgpack(header)
 	if err != nil {
@@ -16,16 +16,23 @@ const Version uint8 = 0x01
 // FrameHeaderSize is the fixed size of a frame header in bytes.
 const FrameHeaderSize = 14
 
+// DefaultMaxFrameSize bounds headers+payload accepted by ReadFrame so a
+// corrupt header cannot trigger a multi-gigabyte allocation.
+const DefaultMaxFrameSize = 16 << 20
+
 // Message types define the purpose of each frame.
 const (
 	TypeRequest     uint8 = 0x01 // Go → PHP: new HTTP request
 	TypeResponse    uint8 = 0x02 // PHP → Go: HTTP response
 	TypeStreamData  uint8 = 0x03 // Bidirectional: WebSocket frame
 	TypeStreamClose uint8 = 0x04 // Either: close WebSocket connection
-	TypeWorkerReady uint8 = 0x05 // PHP → Go: worker is available
+	TypeWorkerReady uint8 = 0x05 // PHP → Go: worker is available; the first declares its features
 	TypeWorkerStop  uint8 = 0x06 // Go → PHP: graceful shutdown
 	TypePing        uint8 = 0x07 // Health check (ping/pong)
 	TypeError       uint8 = 0x08 // Error reporting
+	TypeInvalidate  uint8 = 0x09 // Go → PHP: drop changed files from opcache, answered by WORKER_READY
+	TypeWorkerStats uint8 = 0x0A // PHP → Go: statistics of a FlagDebug request, after its RESPONSE
+	TypeLog         uint8 = 0x0B // PHP → Go: log record sent while serving a request, e.g. a backtrace asked for with SIGUSR2
 )
 
 // Flags modify frame behavior.
@@ -33,6 +40,7 @@ const (
 	FlagCompressed uint8 = 1 << 0 // Payload is compressed
 	FlagChunked    uint8 = 1 << 1 // Chunked transfer
 	FlagFinal      uint8 = 1 << 2 // Final chunk in sequence
+	FlagDebug      uint8 = 1 << 3 // REQUEST: answer with WORKER_STATS as well
 )
 
 // Frame represents a single maboo-wire protocol frame.
@@ -42,6 +50,11 @@ type Frame struct {
 	StreamID uint16
 	Headers  []byte // msgpack encoded
 	Payload  []byte // raw bytes
+
+	// Body, when set, is the payload in place of Payload. WriteFrame
+	// streams it to the writer in chunks, so a body spilled to disk is
+	// never held in memory.
+	Body *Body
 }
 
 // writeBufPool pools scratch buffers for WriteFrame to avoid per-call allocation.
@@ -56,7 +69,12 @@ var writeBufPool = sync.Pool{
 // WriteFrame encodes and writes a frame to the given writer.
 // Coalesces header + headers + payload into a single Write call to reduce
 // syscalls and avoid per-call heap allocations for small frames.
+// A frame with a Body has its header written first and the body streamed
+// after it.
 func WriteFrame(w io.Writer, f *Frame) error {
+	if f.Body != nil {
+		return writeBodyFrame(w, f)
+	}
 	totalSize := FrameHeaderSize + len(f.Headers) + len(f.Payload)
 
 	// Get a pooled buffer, grow if needed
@@ -66,21 +84,7 @@ func WriteFrame(w io.Writer, f *Frame) error {
 		buf = make([]byte, 0, totalSize)
 	}
 	buf = buf[:FrameHeaderSize]
-
-	buf[0] = Magic[0]
-	buf[1] = Magic[1]
-	buf[2] = Version
-	buf[3] = f.Type
-	buf[4] = f.Flags
-	binary.BigEndian.PutUint16(buf[5:7], f.StreamID)
-
-	hdrSize := len(f.Headers)
-	buf[7] = byte(hdrSize >> 16)
-	buf[8] = byte(hdrSize >> 8)
-	buf[9] = byte(hdrSize)
-
-	binary.BigEndian.PutUint32(buf[10:14], uint32(len(f.Payload)))
-
+	putFrameHeader(buf, f, len(f.Payload))
 	buf = append(buf, f.Headers...)
 	buf = append(buf, f.Payload...)
 
@@ -96,6 +100,42 @@ func WriteFrame(w io.Writer, f *Frame) error {
 	return nil
 }
 
+// writeBodyFrame writes a frame whose payload is f.Body: the header and
+// msgpack headers in one Write, then the body in chunks.
+func writeBodyFrame(w io.Writer, f *Frame) error {
+	size := f.Body.Size()
+	if size > maxPayloadSize {
+		return fmt.Errorf("writing frame: payload of %d bytes exceeds the frame limit", size)
+	}
+	buf := make([]byte, FrameHeaderSize, FrameHeaderSize+len(f.Headers))
+	putFrameHeader(buf, f, int(size))
+	buf = append(buf, f.Headers...)
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("writing frame: %w", err)
+	}
+	if _, err := io.Copy(w, f.Body.Reader()); err != nil {
+		return fmt.Errorf("writing frame body: %w", err)
+	}
+	return nil
+}
+
+// putFrameHeader fills the fixed 14-byte header of f into buf.
+func putFrameHeader(buf []byte, f *Frame, payloadSize int) {
+	buf[0] = Magic[0]
+	buf[1] = Magic[1]
+	buf[2] = Version
+	buf[3] = f.Type
+	buf[4] = f.Flags
+	binary.BigEndian.PutUint16(buf[5:7], f.StreamID)
+
+	hdrSize := len(f.Headers)
+	buf[7] = byte(hdrSize >> 16)
+	buf[8] = byte(hdrSize >> 8)
+	buf[9] = byte(hdrSize)
+
+	binary.BigEndian.PutUint32(buf[10:14], uint32(payloadSize))
+}
+
 // readHdrPool pools the 14-byte header buffer for ReadFrame.
 var readHdrPool = sync.Pool{
 	New: func() interface{} {
@@ -104,9 +144,16 @@ var readHdrPool = sync.Pool{
 	},
 }
 
-// ReadFrame reads and decodes a frame from the given reader.
-// Uses pooled header buffer and coalesced data allocation.
+// ReadFrame reads and decodes a frame from the given reader, accepting at
+// most DefaultMaxFrameSize bytes of headers and payload.
 func ReadFrame(r io.Reader) (*Frame, error) {
+	return ReadFrameMax(r, DefaultMaxFrameSize)
+}
+
+// ReadFrameMax reads and decodes a frame whose headers+payload are at most
+// maxSize bytes (<= 0 means unlimited).
+// Uses pooled header buffer and coalesced data allocation.
+func ReadFrameMax(r io.Reader, maxSize int) (*Frame, error) {
 	bp := readHdrPool.Get().(*[]byte)
 	header := *bp
 
@@ -137,6 +184,9 @@ func ReadFrame(r io.Reader) (*Frame, error) {
 
 	// Single allocation for both headers + payload data
 	totalData := hdrSize + payloadSize
+	if maxSize > 0 && totalData > maxSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit of %d", totalData, maxSize)
+	}
 	if totalData > 0 {
 		data := make([]byte, totalData)
 		if _, err := io.ReadFull(r, data); err != nil {
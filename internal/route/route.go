@@ -0,0 +1,137 @@
+// Package route matches request paths against path templates such as
+// "/api/users/:id" or "/blog/*".
+package route
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Matcher maps request paths onto a fixed list of path templates such
+// as "/api/users/:id" or "/blog/*". Templates are compiled into a segment
+// trie; matching walks the path once without allocating.
+//
+// Precedence per segment is static > ":param" > "*", so "/api/users/me"
+// wins over "/api/users/:id" regardless of declaration order.
+type Matcher struct {
+	root      *node
+	templates []string
+}
+
+type node struct {
+	static   map[string]*node
+	param    *node
+	wildcard int // template index for a trailing "*", -1 if none
+	terminal int // template index ending at this node, -1 if none
+}
+
+func newNode() *node {
+	return &node{wildcard: -1, terminal: -1}
+}
+
+// New compiles templates. Each template must start with "/" and
+// may only use "*" as its final segment.
+func New(templates []string) (*Matcher, error) {
+	m := &Matcher{root: newNode(), templates: templates}
+	for i, tpl := range templates {
+		if err := Validate(tpl); err != nil {
+			return nil, err
+		}
+		n := m.root
+		rest := tpl[1:]
+		for {
+			var seg string
+			var more bool
+			seg, rest, more = strings.Cut(rest, "/")
+			switch {
+			case seg == "*":
+				if n.wildcard < 0 {
+					n.wildcard = i
+				}
+				more = false
+			case strings.HasPrefix(seg, ":"):
+				if n.param == nil {
+					n.param = newNode()
+				}
+				n = n.param
+			default:
+				if n.static == nil {
+					n.static = make(map[string]*node)
+				}
+				next, ok := n.static[seg]
+				if !ok {
+					next = newNode()
+					n.static[seg] = next
+				}
+				n = next
+			}
+			if !more {
+				if seg != "*" && n.terminal < 0 {
+					n.terminal = i
+				}
+				break
+			}
+		}
+	}
+	return m, nil
+}
+
+// Validate checks the template syntax accepted by Matcher.
+func Validate(tpl string) error {
+	if !strings.HasPrefix(tpl, "/") {
+		return fmt.Errorf("route template %q must start with /", tpl)
+	}
+	if i := strings.Index(tpl, "*"); i >= 0 && (i != len(tpl)-1 || tpl[i-1] != '/') {
+		return fmt.Errorf("route template %q: * is only allowed as the final segment", tpl)
+	}
+	return nil
+}
+
+// Match returns the index of the template matching path, or -1.
+func (m *Matcher) Match(path string) int {
+	if m == nil || !strings.HasPrefix(path, "/") {
+		return -1
+	}
+	return m.root.match(path[1:])
+}
+
+func (n *node) match(rest string) int {
+	seg, tail, more := strings.Cut(rest, "/")
+	if next, ok := n.static[seg]; ok {
+		if idx := next.follow(tail, more); idx >= 0 {
+			return idx
+		}
+	}
+	if n.param != nil && seg != "" {
+		if idx := n.param.follow(tail, more); idx >= 0 {
+			return idx
+		}
+	}
+	return n.wildcard
+}
+
+func (n *node) follow(tail string, more bool) int {
+	if !more {
+		if n.terminal >= 0 {
+			return n.terminal
+		}
+		return n.wildcard
+	}
+	return n.match(tail)
+}
+
+// Template returns the template string for a Match result.
+func (m *Matcher) Template(idx int) string {
+	if m == nil || idx < 0 || idx >= len(m.templates) {
+		return ""
+	}
+	return m.templates[idx]
+}
+
+// Len returns the number of compiled templates.
+func (m *Matcher) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.templates)
+}
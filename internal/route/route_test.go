@@ -0,0 +1,70 @@
+package route_test
+
+import (
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/route"
+)
+
+func TestMatcher(t *testing.T) {
+	m, err := route.New([]string{
+		"/",
+		"/api/users/:id",
+		"/api/users/me",
+		"/api/users/:id/posts/:post",
+		"/blog/*",
+		"/static/*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"/api/users/42", "/api/users/:id"},
+		{"/api/users/me", "/api/users/me"},
+		{"/api/users/42/posts/7", "/api/users/:id/posts/:post"},
+		{"/api/users/42/posts", ""},
+		{"/api/users/", ""},
+		{"/api/users", ""},
+		{"/blog", "/blog/*"},
+		{"/blog/", "/blog/*"},
+		{"/blog/2024/01/hello", "/blog/*"},
+		{"/blogger", ""},
+		{"/unknown", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := m.Template(m.Match(tt.path)); got != tt.want {
+				t.Errorf("Match(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tpl := range []string{"api/users", "/blog*", "/a/*/b"} {
+		if err := route.Validate(tpl); err == nil {
+			t.Errorf("expected error for %q", tpl)
+		}
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	m, _ := route.New([]string{
+		"/api/users/:id",
+		"/api/users/:id/posts/:post",
+		"/api/orders/:id",
+		"/blog/*",
+		"/wp-admin/*",
+	})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.Match("/api/users/42/posts/7")
+	}
+}
@@ -0,0 +1,170 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// maxOutput caps how much of a run's output is kept for the log.
+const maxOutput = 64 << 10
+
+// processWaitDelay bounds how long a killed php process may keep its
+// output pipes open through children it started.
+const processWaitDelay = 5 * time.Second
+
+// Result is the outcome of one run.
+type Result struct {
+	ExitCode int
+	Output   []byte
+}
+
+// Runner executes one run of a job. A non-nil error means the script did
+// not run to completion (it could not start, or ctx ended it); a script
+// that ran but failed reports a non-zero ExitCode.
+type Runner interface {
+	Run(ctx context.Context, job config.ScheduleJobConfig) (Result, error)
+}
+
+// Executor dispatches a request to the worker pool.
+type Executor interface {
+	Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error)
+}
+
+// NewRunner returns the Runner maboo serve uses. cli jobs run through
+// php.binary when one is configured and the embedded engine otherwise;
+// request jobs go through pool.
+func NewRunner(cfg *config.Config, pool Executor) Runner {
+	return &runner{cfg: cfg, pool: pool}
+}
+
+type runner struct {
+	cfg  *config.Config
+	pool Executor
+}
+
+func (r *runner) Run(ctx context.Context, job config.ScheduleJobConfig) (Result, error) {
+	script := job.Script
+	if !filepath.IsAbs(script) {
+		script = filepath.Join(r.cfg.App.Root, script)
+	}
+	switch {
+	case job.Mode == "request":
+		return r.runRequest(ctx, job, script)
+	case r.cfg.PHP.Binary != "":
+		return r.runProcess(ctx, job, script)
+	default:
+		return r.runEngine(ctx, job, script)
+	}
+}
+
+// runProcess runs the script with the system PHP binary.
+func (r *runner) runProcess(ctx context.Context, job config.ScheduleJobConfig, script string) (Result, error) {
+	args := make([]string, 0, 2*len(r.cfg.PHP.INI)+1+len(job.Args))
+	for _, k := range slices.Sorted(maps.Keys(r.cfg.PHP.INI)) {
+		args = append(args, "-d", k+"="+r.cfg.PHP.INI[k])
+	}
+	args = append(args, script)
+	args = append(args, job.Args...)
+
+	out := &cappedBuffer{max: maxOutput}
+	cmd := exec.CommandContext(ctx, r.cfg.PHP.Binary, args...)
+	cmd.Dir = r.cfg.App.Root
+	cmd.Env = os.Environ()
+	for k, v := range r.cfg.App.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = processWaitDelay
+
+	err := cmd.Run()
+	res := Result{ExitCode: cmd.ProcessState.ExitCode(), Output: out.Bytes()}
+	if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return res, nil
+	}
+	return res, err
+}
+
+// runEngine runs the script in-process with the embedded engine.
+func (r *runner) runEngine(ctx context.Context, job config.ScheduleJobConfig, script string) (Result, error) {
+	engine, err := phpengine.NewEngine(phpengine.SelectVersion(r.cfg.App.Root, r.cfg.PHP.Version))
+	if err != nil {
+		return Result{}, fmt.Errorf("creating PHP engine: %w", err)
+	}
+	if err := engine.Startup(); err != nil {
+		return Result{}, fmt.Errorf("starting PHP engine: %w", err)
+	}
+	defer engine.Shutdown()
+
+	res, err := engine.ExecuteCLI(ctx, script, job.Args, r.cfg.App.Env)
+	if errors.Is(err, phpengine.ErrCLIUnavailable) {
+		return Result{}, fmt.Errorf("%w; set php.binary, or mode: request", err)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{ExitCode: res.ExitCode, Output: res.Output}, nil
+}
+
+// runRequest sends the script through the worker pool as a GET request.
+// The pool has no cancellation, so when ctx ends first the request keeps
+// its worker until it finishes; the run just stops waiting for it.
+func (r *runner) runRequest(ctx context.Context, job config.ScheduleJobConfig, script string) (Result, error) {
+	if r.pool == nil {
+		return Result{}, fmt.Errorf("no worker pool")
+	}
+
+	resp, err := r.pool.Invoke(ctx, phpengine.InternalRequest{
+		Entry:   filepath.Base(script),
+		DocRoot: filepath.Dir(script),
+		Headers: map[string]string{"X-Maboo-Schedule": job.Name},
+		Caller:  metrics.CallerSchedule,
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	body := resp.Body
+	if len(body) > maxOutput {
+		body = body[:maxOutput]
+	}
+	// Report HTTP failures as a failed exit, like php-cli's exit(1).
+	code := 0
+	if resp.Status >= 400 {
+		code = 1
+	}
+	return Result{ExitCode: code, Output: body}, nil
+}
+
+// cappedBuffer keeps the first max bytes written to it and discards the
+// rest, so a chatty script cannot grow the log without bound.
+type cappedBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
@@ -0,0 +1,320 @@
+// Package schedule runs PHP scripts on cron schedules inside maboo serve,
+// replacing a system crontab entry such as Laravel's
+// "* * * * * php artisan schedule:run".
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/cron"
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+// Overlap policies for a job whose previous run is still going.
+const (
+	OverlapSkip  = "skip"  // drop the new run
+	OverlapQueue = "queue" // run once more after the current run (at most one waits)
+	OverlapKill  = "kill"  // cancel the current run and start the new one
+)
+
+// logOutputLimit is how much of a failed run's output is logged.
+const logOutputLimit = 2 << 10
+
+var (
+	lastRunGauge = metrics.NewGaugeVec("maboo_schedule_last_run_timestamp_seconds",
+		"Unix time the most recent run of a scheduled job finished.", "job")
+	lastSuccessGauge = metrics.NewGaugeVec("maboo_schedule_last_success_timestamp_seconds",
+		"Unix time the most recent successful run of a scheduled job finished.", "job")
+	lastDurationGauge = metrics.NewGaugeVec("maboo_schedule_last_duration_seconds",
+		"Duration of the most recent run of a scheduled job.", "job")
+	runsCounter = metrics.NewCounterVec("maboo_schedule_runs_total",
+		"Completed runs of a scheduled job.", "job")
+	failuresCounter = metrics.NewCounterVec("maboo_schedule_failures_total",
+		"Runs of a scheduled job that failed, timed out or were killed.", "job")
+	skippedCounter = metrics.NewCounterVec("maboo_schedule_skipped_total",
+		"Runs of a scheduled job dropped because the previous run was still going.", "job")
+)
+
+// Scheduler triggers the configured jobs. Each job has its own goroutine
+// that sleeps until the next activation of its cron expression.
+type Scheduler struct {
+	jobs   []*job
+	run    Runner
+	logger *slog.Logger
+	loc    *time.Location
+	state  *stateFile
+
+	mu     sync.Mutex      // orders Start against Stop
+	ctx    context.Context // cancelled by Stop; ends the trigger loops
+	cancel context.CancelFunc
+	runCtx context.Context // cancelled by Wait when its deadline passes
+	kill   context.CancelFunc
+	loops  sync.WaitGroup
+	runs   sync.WaitGroup
+}
+
+type job struct {
+	cfg      config.ScheduleJobConfig
+	schedule *cron.Schedule
+	overlap  string
+
+	mu      sync.Mutex
+	running context.CancelFunc // nil when idle
+	done    chan struct{}      // closed when the current run (and any queued one) ends
+	queued  bool
+}
+
+// New prepares a scheduler for cfg. Jobs are run through run.
+func New(cfg config.ScheduleConfig, run Runner, logger *slog.Logger) (*Scheduler, error) {
+	loc := time.Local
+	if cfg.Timezone != "" {
+		l, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("schedule.timezone: %w", err)
+		}
+		loc = l
+	}
+
+	s := &Scheduler{run: run, logger: logger, loc: loc}
+	if cfg.StateFile != "" {
+		st, err := loadState(cfg.StateFile)
+		if err != nil {
+			logger.Warn("ignoring unreadable schedule state, missed runs will not be caught up", "path", cfg.StateFile, "error", err)
+		}
+		s.state = st
+	}
+
+	for _, c := range cfg.Jobs {
+		sched, err := cron.Parse(c.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule job %s: %w", c.Name, err)
+		}
+		j := &job{cfg: c, schedule: sched, overlap: c.Overlap}
+		if j.overlap == "" {
+			j.overlap = OverlapSkip
+		}
+		runsCounter.WithLabelValues(c.Name)
+		failuresCounter.WithLabelValues(c.Name)
+		skippedCounter.WithLabelValues(c.Name)
+		s.jobs = append(s.jobs, j)
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.runCtx, s.kill = context.WithCancel(context.Background())
+	return s, nil
+}
+
+// Start runs the catch_up jobs that missed an activation while maboo was
+// down, then begins triggering every job on its schedule. It does nothing
+// once Stop has been called.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now().In(s.loc)
+	for _, j := range s.jobs {
+		if j.cfg.CatchUp && s.state != nil {
+			if last, ok := s.state.get(j.cfg.Name); ok {
+				if missed := j.schedule.Next(last.In(s.loc)); !missed.IsZero() && !missed.After(now) {
+					s.logger.Info("scheduled job missed a run while stopped, catching up",
+						"job", j.cfg.Name, "missed", missed)
+					s.trigger(j, "catch_up")
+				}
+			}
+		}
+		s.record(j, now)
+
+		s.loops.Add(1)
+		go s.loop(j)
+	}
+	if len(s.jobs) > 0 {
+		s.logger.Info("scheduler started", "jobs", len(s.jobs), "timezone", s.loc.String())
+	}
+}
+
+// Stop stops triggering new runs. Runs in progress continue; call Wait
+// for them.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+	s.loops.Wait()
+}
+
+// Wait waits for runs in progress, cancelling any still going when ctx
+// ends, and records the stop time for catch_up.
+func (s *Scheduler) Wait(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("cancelling scheduled jobs still running at shutdown")
+		s.kill()
+		<-done
+	}
+
+	now := time.Now()
+	for _, j := range s.jobs {
+		s.record(j, now)
+	}
+}
+
+func (s *Scheduler) loop(j *job) {
+	defer s.loops.Done()
+	for {
+		next := j.schedule.Next(time.Now().In(s.loc))
+		if next.IsZero() {
+			s.logger.Warn("scheduled job never runs again", "job", j.cfg.Name, "cron", j.cfg.Cron)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			s.record(j, next)
+			s.trigger(j, "schedule")
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// trigger starts a run of j, applying its overlap policy when the
+// previous run is still going.
+func (s *Scheduler) trigger(j *job, reason string) {
+	j.mu.Lock()
+	for j.running != nil {
+		switch j.overlap {
+		case OverlapQueue:
+			if !j.queued {
+				j.queued = true
+				j.mu.Unlock()
+				s.logger.Info("scheduled job still running, queued the next run", "job", j.cfg.Name)
+				return
+			}
+			fallthrough
+		case OverlapSkip:
+			j.mu.Unlock()
+			skippedCounter.WithLabelValues(j.cfg.Name).Inc()
+			s.logger.Warn("scheduled job still running, skipped a run", "job", j.cfg.Name)
+			return
+		case OverlapKill:
+			cancel, done := j.running, j.done
+			j.mu.Unlock()
+			s.logger.Warn("scheduled job still running, killing it", "job", j.cfg.Name)
+			cancel()
+			<-done
+			j.mu.Lock()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(s.runCtx)
+	j.running = cancel
+	j.done = make(chan struct{})
+	j.mu.Unlock()
+
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+		for {
+			s.execute(ctx, j, reason)
+			cancel()
+
+			j.mu.Lock()
+			if j.queued && s.runCtx.Err() == nil {
+				j.queued = false
+				ctx, cancel = context.WithCancel(s.runCtx)
+				j.running = cancel
+				j.mu.Unlock()
+				reason = "queued"
+				continue
+			}
+			j.queued = false
+			j.running = nil
+			close(j.done)
+			j.mu.Unlock()
+			return
+		}
+	}()
+}
+
+// execute performs one run after the job's jitter delay, then logs and
+// records its outcome.
+func (s *Scheduler) execute(ctx context.Context, j *job, reason string) {
+	if jitter := j.cfg.Jitter.Duration(); jitter > 0 {
+		t := time.NewTimer(rand.N(jitter))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+	if timeout := j.cfg.Timeout.Duration(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	name := j.cfg.Name
+	s.logger.Debug("scheduled job started", "job", name, "trigger", reason)
+	start := time.Now()
+	res, err := s.run.Run(ctx, j.cfg)
+	elapsed := time.Since(start)
+	end := time.Now()
+
+	runsCounter.WithLabelValues(name).Inc()
+	lastRunGauge.WithLabelValues(name).Set(float64(end.Unix()))
+	lastDurationGauge.WithLabelValues(name).Set(elapsed.Seconds())
+
+	attrs := []any{"job", name, "trigger", reason, "duration", elapsed, "exit_code", res.ExitCode}
+	if err == nil && res.ExitCode == 0 {
+		lastSuccessGauge.WithLabelValues(name).Set(float64(end.Unix()))
+		s.logger.Info("scheduled job finished", attrs...)
+		return
+	}
+
+	failuresCounter.WithLabelValues(name).Inc()
+	if err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			err = fmt.Errorf("timed out after %s", j.cfg.Timeout.Duration())
+		case context.Canceled:
+			err = errors.New("killed")
+		}
+		attrs = append(attrs, "error", err)
+	}
+	if out := strings.TrimSpace(string(res.Output)); out != "" {
+		if len(out) > logOutputLimit {
+			out = "..." + out[len(out)-logOutputLimit:]
+		}
+		attrs = append(attrs, "output", out)
+	}
+	s.logger.Error("scheduled job failed", attrs...)
+}
+
+// record notes that j's schedule has been watched up to t, so a restart
+// only catches up activations after it.
+func (s *Scheduler) record(j *job, t time.Time) {
+	if s.state == nil || !j.cfg.CatchUp {
+		return
+	}
+	if err := s.state.set(j.cfg.Name, t); err != nil {
+		s.logger.Warn("saving schedule state", "path", s.state.path, "error", err)
+	}
+}
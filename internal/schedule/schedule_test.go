@@ -0,0 +1,308 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// blockingRunner holds every run until release is closed or the run's
+// context ends.
+type blockingRunner struct {
+	started  chan string
+	release  chan struct{}
+	runs     atomic.Int32
+	canceled atomic.Int32
+}
+
+func newBlockingRunner() *blockingRunner {
+	return &blockingRunner{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (r *blockingRunner) Run(ctx context.Context, job config.ScheduleJobConfig) (Result, error) {
+	r.runs.Add(1)
+	r.started <- job.Name
+	select {
+	case <-r.release:
+		return Result{}, nil
+	case <-ctx.Done():
+		r.canceled.Add(1)
+		return Result{}, ctx.Err()
+	}
+}
+
+func (r *blockingRunner) waitStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run never started")
+	}
+}
+
+func newTestScheduler(t *testing.T, cfg config.ScheduleConfig, run Runner) (*Scheduler, *bytes.Buffer) {
+	t.Helper()
+	var logs bytes.Buffer
+	s, err := New(cfg, run, slog.New(slog.NewTextHandler(&syncWriter{w: &logs}, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &logs
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func yearly(name, overlap string) config.ScheduleConfig {
+	return config.ScheduleConfig{Jobs: []config.ScheduleJobConfig{
+		{Name: name, Cron: "@yearly", Script: "job.php", Overlap: overlap},
+	}}
+}
+
+func TestOverlapSkip(t *testing.T) {
+	run := newBlockingRunner()
+	s, logs := newTestScheduler(t, yearly("skip", ""), run)
+	j := s.jobs[0]
+
+	s.trigger(j, "schedule")
+	run.waitStarted(t)
+	s.trigger(j, "schedule")
+	close(run.release)
+	s.Wait(context.Background())
+
+	if n := run.runs.Load(); n != 1 {
+		t.Errorf("runs = %d, want 1", n)
+	}
+	if !strings.Contains(logs.String(), "skipped a run") {
+		t.Errorf("no skip logged:\n%s", logs)
+	}
+}
+
+func TestOverlapQueue(t *testing.T) {
+	run := newBlockingRunner()
+	s, logs := newTestScheduler(t, yearly("queue", OverlapQueue), run)
+	j := s.jobs[0]
+
+	s.trigger(j, "schedule")
+	run.waitStarted(t)
+	s.trigger(j, "schedule") // queued
+	s.trigger(j, "schedule") // only one run waits; skipped
+	close(run.release)
+	s.Wait(context.Background())
+
+	if n := run.runs.Load(); n != 2 {
+		t.Errorf("runs = %d, want 2", n)
+	}
+	if !strings.Contains(logs.String(), "trigger=queued") || !strings.Contains(logs.String(), "skipped a run") {
+		t.Errorf("queued run or skip not logged:\n%s", logs)
+	}
+}
+
+func TestOverlapKill(t *testing.T) {
+	run := newBlockingRunner()
+	s, logs := newTestScheduler(t, yearly("kill", OverlapKill), run)
+	j := s.jobs[0]
+
+	s.trigger(j, "schedule")
+	run.waitStarted(t)
+	s.trigger(j, "schedule")
+	run.waitStarted(t)
+	close(run.release)
+	s.Wait(context.Background())
+
+	if n, c := run.runs.Load(), run.canceled.Load(); n != 2 || c != 1 {
+		t.Errorf("runs = %d, canceled = %d, want 2 and 1", n, c)
+	}
+	if !strings.Contains(logs.String(), "error=killed") {
+		t.Errorf("killed run not logged as failed:\n%s", logs)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	run := newBlockingRunner()
+	cfg := yearly("slow", "")
+	cfg.Jobs[0].Timeout = config.Duration(20 * time.Millisecond)
+	s, logs := newTestScheduler(t, cfg, run)
+
+	s.trigger(s.jobs[0], "schedule")
+	s.Wait(context.Background())
+
+	if !strings.Contains(logs.String(), "timed out after 20ms") {
+		t.Errorf("timeout not logged:\n%s", logs)
+	}
+}
+
+func TestWaitCancelsAtDeadline(t *testing.T) {
+	run := newBlockingRunner()
+	s, _ := newTestScheduler(t, yearly("stuck", ""), run)
+
+	s.trigger(s.jobs[0], "schedule")
+	run.waitStarted(t)
+	s.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	s.Wait(ctx)
+
+	if c := run.canceled.Load(); c != 1 {
+		t.Errorf("canceled = %d, want 1", c)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "schedule.json")
+	lastSeen := time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Second)
+	os.WriteFile(statePath, []byte(`{"jobs": {"hourly": "`+lastSeen.Format(time.RFC3339)+`", "no-catch-up": "`+lastSeen.Format(time.RFC3339)+`"}}`), 0o644)
+
+	run := newBlockingRunner()
+	close(run.release)
+	s, _ := newTestScheduler(t, config.ScheduleConfig{
+		StateFile: statePath,
+		Jobs: []config.ScheduleJobConfig{
+			{Name: "hourly", Cron: "0 * * * *", Script: "a.php", CatchUp: true},
+			{Name: "no-catch-up", Cron: "0 * * * *", Script: "b.php"},
+			{Name: "new", Cron: "0 * * * *", Script: "c.php", CatchUp: true},
+		},
+	}, run)
+
+	s.Start()
+	run.waitStarted(t)
+	s.Stop()
+	s.Wait(context.Background())
+
+	if n := run.runs.Load(); n != 1 {
+		t.Errorf("runs = %d, want only the missed catch_up job", n)
+	}
+	st, err := loadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"hourly", "new"} {
+		if got, _ := st.get(name); !got.After(lastSeen) {
+			t.Errorf("state for %s = %v, want the stop time", name, got)
+		}
+	}
+}
+
+func TestCatchUpNothingMissed(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "schedule.json")
+	cfg := config.ScheduleConfig{
+		StateFile: statePath,
+		Jobs:      []config.ScheduleJobConfig{{Name: "daily", Cron: "@daily", Script: "a.php", CatchUp: true}},
+	}
+	st, _ := loadState(statePath)
+	if err := st.set("daily", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	run := newBlockingRunner()
+	s, _ := newTestScheduler(t, cfg, run)
+	s.Start()
+	s.Stop()
+	s.Wait(context.Background())
+
+	if n := run.runs.Load(); n != 0 {
+		t.Errorf("runs = %d, want 0", n)
+	}
+}
+
+func TestRunProcess(t *testing.T) {
+	root := t.TempDir()
+	script := `echo "args: $*"; echo "env: $GREETING"; exit 3`
+	if err := os.WriteFile(filepath.Join(root, "job.sh"), []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.App.Root = root
+	cfg.App.Env = map[string]string{"GREETING": "hello"}
+	cfg.PHP.Binary = "/bin/sh"
+	cfg.PHP.INI = nil // sh has no -d
+
+	res, err := NewRunner(cfg, nil).Run(context.Background(), config.ScheduleJobConfig{
+		Name: "sh", Script: "job.sh", Args: []string{"schedule:run", "-v"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("ExitCode = %d, want 3", res.ExitCode)
+	}
+	if want := "args: schedule:run -v\nenv: hello\n"; string(res.Output) != want {
+		t.Errorf("Output = %q, want %q", res.Output, want)
+	}
+}
+
+// A cli job on an engine that cannot run scripts fails rather than
+// passing for a clean exit.
+func TestRunEngineUnavailable(t *testing.T) {
+	if phpengine.EmbedCompiled {
+		t.Skip("the engine may run the script")
+	}
+	cfg := config.Default()
+	cfg.App.Root = t.TempDir()
+	sched := yearly("stub", "")
+	s, logs := newTestScheduler(t, sched, NewRunner(cfg, nil))
+
+	s.trigger(s.jobs[0], "schedule")
+	s.Wait(context.Background())
+
+	if !strings.Contains(logs.String(), "scheduled job failed") || !strings.Contains(logs.String(), "php.binary") {
+		t.Errorf("stub run not reported as failed:\n%s", logs)
+	}
+	if got := testutil.ToFloat64(lastSuccessGauge.WithLabelValues("stub")); got != 0 {
+		t.Errorf("last success = %v, want none", got)
+	}
+}
+
+func TestRunProcessCancelled(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "sleep.sh"), []byte("exec sleep 10"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.App.Root = root
+	cfg.PHP.Binary = "/bin/sh"
+	cfg.PHP.INI = nil // sh has no -d
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewRunner(cfg, nil).Run(ctx, config.ScheduleJobConfig{Name: "sleep", Script: "sleep.sh"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled run took %s", elapsed)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{max: 5}
+	for _, s := range []string{"abc", "defg", "hij"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Errorf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if got := string(b.Bytes()); got != "abcde" {
+		t.Errorf("Bytes = %q, want %q", got, "abcde")
+	}
+}
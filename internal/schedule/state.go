@@ -0,0 +1,79 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateFile persists, per job, the time up to which its schedule was
+// watched. On startup any activation after that time was missed.
+type stateFile struct {
+	path string
+
+	mu   sync.Mutex
+	jobs map[string]time.Time
+}
+
+type stateDoc struct {
+	Jobs map[string]time.Time `json:"jobs"`
+}
+
+// loadState reads path. A missing file is an empty state; an unreadable
+// one is returned empty along with the error.
+func loadState(path string) (*stateFile, error) {
+	st := &stateFile{path: path, jobs: map[string]time.Time{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	var doc stateDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return st, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for name, t := range doc.Jobs {
+		st.jobs[name] = t
+	}
+	return st, nil
+}
+
+func (st *stateFile) get(name string) (time.Time, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	t, ok := st.jobs[name]
+	return t, ok
+}
+
+// set records t for name and rewrites the file. The write goes through a
+// temporary file so a crash never leaves a truncated state behind.
+func (st *stateFile) set(name string, t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.jobs[name] = t.UTC()
+
+	data, err := json.MarshalIndent(stateDoc{Jobs: st.jobs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), filepath.Base(st.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), st.path)
+}
@@ -0,0 +1,317 @@
+// Package scratch manages the disk space maboo writes temporary files to,
+// such as request bodies too large to keep in memory. Every temporary file
+// maboo writes is created through an Area, which keeps count of the bytes
+// they hold and refuses to grow them past its quota, so a runaway upload
+// cannot fill a disk the PHP app also lives on. Files have no name where
+// the system allows it, so nothing is left behind if maboo dies; the Area
+// sweeps up the ones that were.
+package scratch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var (
+	tempBytes = metrics.NewGauge("maboo_temp_bytes",
+		"Bytes held by maboo's temporary files.")
+	tempFiles = metrics.NewGauge("maboo_temp_files",
+		"Temporary files open.")
+	tempRejections = metrics.NewCounter("maboo_temp_rejections_total",
+		"Writes to temporary files refused because temp.max_bytes was reached.")
+	tempOrphansRemoved = metrics.NewCounter("maboo_temp_orphans_removed_total",
+		"Temporary files left behind by earlier processes and removed by the sweep.")
+)
+
+// prefix starts the name of every temporary file, so the sweep knows
+// which files in a shared directory are maboo's.
+const prefix = "maboo-"
+
+// QuotaError is returned when writing to a temporary file would take the
+// Area past its quota.
+type QuotaError struct {
+	Size int64 // bytes the file would have held
+	Used int64 // bytes held by the Area's other files
+	Max  int64 // the quota
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("temporary files over quota: %d bytes needed with %d of %d in use", e.Size, e.Used, e.Max)
+}
+
+// Transient reports whether the file could fit once other files are
+// released; a file larger than the whole quota never can.
+func (e *QuotaError) Transient() bool { return e.Size <= e.Max }
+
+// Options configures an Area.
+type Options struct {
+	Dir           string        // where files are created; os.TempDir() when empty
+	MaxBytes      int64         // bytes all files may hold together; 0 = no quota
+	SweepInterval time.Duration // how often orphaned files are looked for; 0 = never
+	OrphanAge     time.Duration // how long a file must have been left untouched to be removed
+}
+
+// Area is a directory temporary files are created in, with a quota on
+// the bytes they hold. A nil *Area creates files in os.TempDir() with no
+// quota.
+type Area struct {
+	dir    string
+	opts   Options
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	used  int64
+	files int
+	named map[string]bool // open files that still have a name, never orphans
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns an Area in opts.Dir, creating the directory if need be, and
+// starts sweeping it for orphans when opts.SweepInterval is set.
+func New(opts Options, logger *slog.Logger) (*Area, error) {
+	dir := opts.Dir
+	if dir == "" {
+		dir = os.TempDir()
+	} else if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating temp directory: %w", err)
+	}
+	a := &Area{
+		dir:    dir,
+		opts:   opts,
+		logger: logger,
+		named:  make(map[string]bool),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if opts.SweepInterval > 0 {
+		go a.sweepEvery(opts.SweepInterval)
+	} else {
+		close(a.done)
+	}
+	return a, nil
+}
+
+// Dir returns the directory files are created in.
+func (a *Area) Dir() string {
+	if a == nil {
+		return os.TempDir()
+	}
+	return a.dir
+}
+
+// Used returns the bytes held by the Area's open files.
+func (a *Area) Used() int64 {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.used
+}
+
+// Create opens a new, empty temporary file. Close releases it and the
+// bytes it held.
+func (a *Area) Create() (*File, error) {
+	f, name, err := tempFile(a.Dir())
+	if err != nil {
+		return nil, err
+	}
+	if a != nil {
+		a.mu.Lock()
+		a.files++
+		if name != "" {
+			a.named[name] = true
+		}
+		a.updateGauges()
+		a.mu.Unlock()
+	}
+	return &File{area: a, f: f, name: name}, nil
+}
+
+// grow accounts for n more bytes written to f, or returns a *QuotaError.
+func (a *Area) grow(f *File, n int64) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.opts.MaxBytes > 0 && a.used+n > a.opts.MaxBytes {
+		tempRejections.Inc()
+		return &QuotaError{Size: f.size + n, Used: a.used - f.size, Max: a.opts.MaxBytes}
+	}
+	a.used += n
+	a.updateGauges()
+	return nil
+}
+
+// shrink gives back n bytes accounted for by grow but never written.
+func (a *Area) shrink(n int64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.used -= n
+	a.updateGauges()
+}
+
+// release forgets f and the bytes it held.
+func (a *Area) release(f *File) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.used -= f.size
+	a.files--
+	delete(a.named, f.name)
+	a.updateGauges()
+}
+
+func (a *Area) updateGauges() {
+	tempBytes.Set(float64(a.used))
+	tempFiles.Set(float64(a.files))
+}
+
+// Sweep removes the files in the directory that a maboo process left
+// behind: named like maboo's, not open in this process and untouched for
+// olderThan. Files of other maboo processes sharing the directory are
+// written to, or removed, well before then. It returns how many it
+// removed.
+func (a *Area) Sweep(olderThan time.Duration) (int, error) {
+	entries, err := os.ReadDir(a.Dir())
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	removed := 0
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		path := filepath.Join(a.Dir(), e.Name())
+		if a != nil {
+			a.mu.Lock()
+			open := a.named[path]
+			a.mu.Unlock()
+			if open {
+				continue
+			}
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		removed++
+		tempOrphansRemoved.Inc()
+	}
+	return removed, nil
+}
+
+func (a *Area) sweepEvery(interval time.Duration) {
+	defer close(a.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if n, err := a.Sweep(a.opts.OrphanAge); err != nil {
+			a.logger.Warn("sweeping temp directory", "dir", a.dir, "error", err)
+		} else if n > 0 {
+			a.logger.Info("removed orphaned temp files", "dir", a.dir, "files", n)
+		}
+		select {
+		case <-t.C:
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Close stops the sweep. Files still open stay usable.
+func (a *Area) Close(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+	select {
+	case <-a.stop:
+	default:
+		close(a.stop)
+	}
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// File is a temporary file created by an Area. Writes that would take the
+// Area past its quota fail with a *QuotaError and write nothing.
+type File struct {
+	area *Area
+	f    *os.File
+	name string // set while the file still has a name to remove
+	size int64
+}
+
+// Write appends p to the file.
+func (f *File) Write(p []byte) (int, error) {
+	if err := f.area.grow(f, int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	if n < len(p) {
+		f.area.shrink(int64(len(p) - n))
+	}
+	return n, err
+}
+
+// ReadAt reads from the file at off.
+func (f *File) ReadAt(p []byte, off int64) (int, error) { return f.f.ReadAt(p, off) }
+
+// Size returns the bytes written to the file.
+func (f *File) Size() int64 { return f.size }
+
+// Close closes and removes the file, and releases the bytes it held. It
+// is safe to call more than once.
+func (f *File) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	if f.name != "" {
+		if rmErr := os.Remove(f.name); err == nil {
+			err = rmErr
+		}
+	}
+	f.area.release(f)
+	f.f, f.name, f.size = nil, "", 0
+	return err
+}
+
+// unlinkedTemp creates a named temporary file and removes its name at
+// once, leaving the open file as the only reference to it. Where an open
+// file cannot be removed the name is returned for Close to remove.
+func unlinkedTemp(dir string) (*os.File, string, error) {
+	f, err := os.CreateTemp(dir, prefix+"*")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		return f, f.Name(), nil
+	}
+	return f, "", nil
+}
@@ -0,0 +1,122 @@
+package scratch_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/scratch"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestQuota(t *testing.T) {
+	a, err := scratch.New(scratch.Options{Dir: filepath.Join(t.TempDir(), "tmp"), MaxBytes: 100}, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close(context.Background())
+
+	f, err := a.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+	g, err := a.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.Write(make([]byte, 50))
+	var quota *scratch.QuotaError
+	if !errors.As(err, &quota) || !quota.Transient() || quota.Used != 60 || quota.Size != 50 {
+		t.Fatalf("write past the quota: err = %v (%+v)", err, quota)
+	}
+	if g.Size() != 0 || a.Used() != 60 {
+		t.Errorf("refused write counted: file %d bytes, area %d", g.Size(), a.Used())
+	}
+
+	f.Close()
+	if _, err := g.Write(make([]byte, 50)); err != nil {
+		t.Errorf("write once the other file was released: %v", err)
+	}
+	_, err = g.Write(make([]byte, 51))
+	if !errors.As(err, &quota) || quota.Transient() {
+		t.Errorf("file larger than the quota: err = %v, want a QuotaError that is not transient", err)
+	}
+	buf := make([]byte, 50)
+	if n, err := g.ReadAt(buf, 0); n != 50 || err != nil {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+	g.Close()
+	g.Close()
+	if a.Used() != 0 {
+		t.Errorf("%d bytes held after closing every file", a.Used())
+	}
+}
+
+func TestNilArea(t *testing.T) {
+	var a *scratch.Area
+	f, err := a.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != 1<<20 || a.Used() != 0 {
+		t.Errorf("file %d bytes, area %d", f.Size(), a.Used())
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	for name, mtime := range map[string]time.Time{
+		"maboo-123":   old,        // orphan
+		"maboo-456":   time.Now(), // another process's, in use
+		"php8A3F.tmp": old,        // not maboo's
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := scratch.New(scratch.Options{Dir: dir, SweepInterval: time.Hour, OrphanAge: time.Hour}, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first sweep runs at once.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "maboo-123")); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("orphan not swept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := a.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"maboo-456", "php8A3F.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if n, err := a.Sweep(time.Hour); n != 0 || err != nil {
+		t.Errorf("second sweep = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package scratch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// tempFile opens an anonymous file in dir with O_TMPFILE, falling back to
+// an immediately removed named file on filesystems without it.
+func tempFile(dir string) (*os.File, string, error) {
+	fd, err := unix.Open(dir, unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC, 0o600)
+	if err == nil {
+		return os.NewFile(uintptr(fd), filepath.Join(dir, prefix+"body")), "", nil
+	}
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EISDIR) || errors.Is(err, unix.EINVAL) {
+		return unlinkedTemp(dir)
+	}
+	return nil, "", &os.PathError{Op: "open", Path: dir, Err: err}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package scratch
+
+import "os"
+
+// tempFile creates a temporary file in dir, removed as soon as it is open.
+func tempFile(dir string) (*os.File, string, error) {
+	return unlinkedTemp(dir)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/clientip"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/glob"
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var accessDenied = metrics.NewCounterVec("maboo_http_access_denied_total",
+	"Requests denied by access_control, by rule name (default for the default policy).", "rule")
+
+// accessDefault labels denials by access_control.default.
+const accessDefault = "default"
+
+// accessControl is access_control, compiled. Each request is checked
+// against every rule at most once, and each address list is a single
+// binary search.
+type accessControl struct {
+	clients     *clientip.Resolver
+	rules       []accessRule
+	denyDefault bool
+	status      int
+}
+
+type accessRule struct {
+	label    string
+	prefixes []string
+	globs    []*glob.Glob
+	host     string // exact, or ".example.com" for *.example.com
+	wildcard bool
+	allow    *clientip.Set // nil: the rule only denies
+	deny     *clientip.Set
+}
+
+// newAccessControl compiles cfg.Access, or returns nil when every request
+// is allowed. Client addresses are resolved through server.trusted_proxies.
+// Validate has checked the rules; a path that fails to compile anyway is
+// skipped, and an allow list that does allows nobody.
+func newAccessControl(cfg *config.Config) *accessControl {
+	a := cfg.Access
+	if len(a.Rules) == 0 && a.Default != "deny" {
+		return nil
+	}
+	clients, _ := clientip.New(cfg.Server.TrustedProxies)
+	ac := &accessControl{clients: clients, denyDefault: a.Default == "deny", status: a.DenyStatus}
+	if ac.status != http.StatusNotFound {
+		ac.status = http.StatusForbidden
+	}
+	for i, r := range a.Rules {
+		rule := accessRule{label: r.Name}
+		if rule.label == "" {
+			rule.label = "rules[" + strconv.Itoa(i) + "]"
+		}
+		for _, p := range r.Paths {
+			if !config.IsAccessGlob(p) {
+				rule.prefixes = append(rule.prefixes, strings.TrimSuffix(p, "/"))
+			} else if g, err := glob.Compile(strings.TrimPrefix(p, "/")); err == nil {
+				rule.globs = append(rule.globs, g)
+			}
+		}
+		host, wildcard := strings.CutPrefix(strings.ToLower(r.Host), "*")
+		rule.host, rule.wildcard = host, wildcard
+		if len(r.Allow) > 0 {
+			if rule.allow, _ = clientip.NewSet(r.Allow); rule.allow == nil {
+				rule.allow = &clientip.Set{}
+			}
+		}
+		rule.deny, _ = clientip.NewSet(r.Deny)
+		ac.rules = append(ac.rules, rule)
+	}
+	return ac
+}
+
+// refuse answers req with the deny status when access_control denies it,
+// and reports whether it did. A nil accessControl allows everything.
+func (ac *accessControl) refuse(w http.ResponseWriter, req *http.Request) bool {
+	if ac == nil {
+		return false
+	}
+	rule, denied := ac.check(req)
+	if !denied {
+		return false
+	}
+	accessDenied.WithLabelValues(rule).Inc()
+	if ac.status == http.StatusNotFound {
+		http.NotFound(w, req)
+	} else {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+	return true
+}
+
+// check returns whether req is denied and the rule that decided, in rule
+// order: a client in a rule's deny list is denied, one in its allow list
+// allowed, and any other denied if the rule has an allow list. Rules with
+// only a deny list pass everyone else on to the next rule.
+func (ac *accessControl) check(req *http.Request) (string, bool) {
+	p := cleanPath(req.URL.Path)
+	host := requestHost(ac.clients, req)
+	addr := ac.clients.Addr(req)
+	for i := range ac.rules {
+		r := &ac.rules[i]
+		if !r.matches(p, host) {
+			continue
+		}
+		if r.deny.Contains(addr) {
+			return r.label, true
+		}
+		if r.allow != nil {
+			return r.label, !r.allow.Contains(addr)
+		}
+	}
+	return accessDefault, ac.denyDefault
+}
+
+func (r *accessRule) matches(p, host string) bool {
+	if r.host != "" && host != r.host && !(r.wildcard && strings.HasSuffix(host, r.host)) {
+		return false
+	}
+	if len(r.prefixes) == 0 && len(r.globs) == 0 {
+		return true
+	}
+	for _, prefix := range r.prefixes {
+		if prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	for _, g := range r.globs {
+		if g.Match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanPath resolves dot segments and doubled slashes, so "/a/../wp-admin"
+// and "//wp-admin" meet the rules for "/wp-admin".
+func cleanPath(p string) string {
+	if p == "" || p[0] != '/' {
+		p = "/" + p
+	}
+	return path.Clean(p)
+}
+
+// requestHost returns the host the client asked for, in lower case and
+// without port or trailing dot. Behind a trusted proxy it is the one in
+// X-Forwarded-Host.
+func requestHost(clients *clientip.Resolver, req *http.Request) string {
+	host, _ := clients.Host(req)
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
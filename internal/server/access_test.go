@@ -0,0 +1,101 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestAccessControlRules(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.TrustedProxies = []string{"10.0.0.1"}
+	cfg.Access.Rules = []config.AccessRule{
+		{Name: "abusers", Deny: []string{"198.51.100.0/24", "2001:db8:bad::/48"}},
+		{Name: "wp-admin", Paths: []string{"/wp-admin/", "/wp-login.php"}, Allow: []string{"203.0.113.0/24"}},
+		{Name: "dumps", Paths: []string{"/**/*.sql"}, Deny: []string{"0.0.0.0/0", "::/0"}},
+		{Name: "staging", Host: "*.staging.example.com", Allow: []string{"192.0.2.1"}},
+	}
+	ac := newAccessControl(cfg)
+
+	tests := []struct {
+		name, path, host, peer, xff string
+		rule                        string
+		denied                      bool
+	}{
+		{"open page", "/blog/hello", "example.com", "192.0.2.9:1000", "", "default", false},
+		{"abusive network", "/blog/hello", "example.com", "198.51.100.7:1000", "", "abusers", true},
+		{"abusive ipv6", "/", "example.com", "[2001:db8:bad::1]:1000", "", "abusers", true},
+		{"office admin", "/wp-admin/index.php", "example.com", "203.0.113.5:1000", "", "wp-admin", false},
+		{"outsider admin", "/wp-admin/index.php", "example.com", "192.0.2.9:1000", "", "wp-admin", true},
+		{"admin dir itself", "/wp-admin", "example.com", "192.0.2.9:1000", "", "wp-admin", true},
+		{"login", "/wp-login.php", "example.com", "192.0.2.9:1000", "", "wp-admin", true},
+		{"prefix is per segment", "/wp-admin-guide", "example.com", "192.0.2.9:1000", "", "default", false},
+		{"dot segments", "/blog/../wp-admin/", "example.com", "192.0.2.9:1000", "", "wp-admin", true},
+		{"doubled slash", "//wp-admin/x", "example.com", "192.0.2.9:1000", "", "wp-admin", true},
+		{"office via proxy", "/wp-admin/", "example.com", "10.0.0.1:1000", "203.0.113.5", "wp-admin", false},
+		{"outsider via proxy", "/wp-admin/", "example.com", "10.0.0.1:1000", "192.0.2.9", "wp-admin", true},
+		{"forged header", "/wp-admin/", "example.com", "192.0.2.9:1000", "203.0.113.5", "wp-admin", true},
+		{"glob", "/backups/db.sql", "example.com", "203.0.113.5:1000", "", "dumps", true},
+		{"staging host", "/", "app.staging.example.com:8443", "192.0.2.9:1000", "", "staging", true},
+		{"staging allowed", "/", "APP.Staging.Example.com.", "192.0.2.1:1000", "", "staging", false},
+		{"not a subdomain", "/", "staging.example.com", "192.0.2.9:1000", "", "default", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.URL.Path = tt.path
+			req.Host = tt.host
+			req.RemoteAddr = tt.peer
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			rule, denied := ac.check(req)
+			if rule != tt.rule || denied != tt.denied {
+				t.Errorf("check() = %s, %v; want %s, %v", rule, denied, tt.rule, tt.denied)
+			}
+		})
+	}
+
+	if newAccessControl(config.Default()) != nil {
+		t.Error("default config should not compile access control")
+	}
+}
+
+func TestAccessControlRouter(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Static.Root = t.TempDir()
+	cfg.Access.Rules = []config.AccessRule{{Name: "admin", Paths: []string{"/admin"}, Allow: []string{"203.0.113.0/24"}}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	router := NewRouter(cfg, &stubPool{}, logger)
+
+	get := func(path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "192.0.2.9:1000"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	for path, want := range map[string]int{
+		"/admin/users":   http.StatusForbidden,
+		"/admin/app.css": http.StatusForbidden, // before static files
+		"/healthz":       http.StatusOK,        // probes are never filtered
+		"/":              http.StatusOK,
+	} {
+		if got := get(path); got != want {
+			t.Errorf("GET %s: %d, want %d", path, got, want)
+		}
+	}
+
+	// Reloaded rules apply to the next request.
+	next := config.Default()
+	next.Access.DenyStatus = http.StatusNotFound
+	next.Access.Default = "deny"
+	router.SetConfig(next)
+	if got := get("/"); got != http.StatusNotFound {
+		t.Errorf("after reload: GET / = %d, want 404", got)
+	}
+}
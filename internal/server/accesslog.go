@@ -0,0 +1,76 @@
+package server
+
+import (
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+// RequestSampler decides which requests produce an access log record.
+// Errors, slow requests, and requests to listed paths are always logged;
+// everything else is logged with probability SampleRate. The decision path
+// does not allocate, so skipped requests cost only a few comparisons.
+type RequestSampler struct {
+	threshold   uint64 // rand.Uint64() below this value means "log"
+	all         bool   // sample_rate >= 1: skip the random draw
+	slow        time.Duration
+	alwaysPaths []string
+
+	logged     atomic.Int64
+	sampledOut atomic.Int64
+}
+
+// NewRequestSampler creates a sampler from the logging.request config.
+func NewRequestSampler(cfg config.RequestLogConfig) *RequestSampler {
+	s := &RequestSampler{
+		slow:        cfg.SlowThreshold.Duration(),
+		alwaysPaths: cfg.AlwaysPaths,
+	}
+	switch {
+	case cfg.SampleRate >= 1:
+		s.all = true
+	case cfg.SampleRate > 0:
+		s.threshold = uint64(cfg.SampleRate * math.MaxUint64)
+	}
+	return s
+}
+
+// Decide reports whether a finished request should be logged, and whether it
+// crossed the slow-request threshold.
+func (s *RequestSampler) Decide(path string, status int, duration time.Duration) (log, slow bool) {
+	slow = s.slow > 0 && duration >= s.slow
+	if slow || status >= 500 || s.all || s.alwaysLogged(path) {
+		s.logged.Add(1)
+		return true, slow
+	}
+	if s.threshold > 0 && rand.Uint64() < s.threshold {
+		s.logged.Add(1)
+		return true, false
+	}
+	s.sampledOut.Add(1)
+	return false, false
+}
+
+func (s *RequestSampler) alwaysLogged(path string) bool {
+	for _, p := range s.alwaysPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Logged returns the number of requests that produced an access log record.
+func (s *RequestSampler) Logged() int64 {
+	return s.logged.Load()
+}
+
+// SampledOut returns the number of requests skipped by sampling. Together
+// with Logged it reconstructs the real request total from the logs.
+func (s *RequestSampler) SampledOut() int64 {
+	return s.sampledOut.Load()
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestRequestSamplerAlwaysLogs(t *testing.T) {
+	s := NewRequestSampler(config.RequestLogConfig{
+		SampleRate:    0,
+		SlowThreshold: config.Duration(time.Second),
+		AlwaysPaths:   []string{"/admin"},
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		duration time.Duration
+		log      bool
+		slow     bool
+	}{
+		{"fast success", "/", 200, time.Millisecond, false, false},
+		{"server error", "/", 502, time.Millisecond, true, false},
+		{"slow", "/", 200, 2 * time.Second, true, true},
+		{"always path", "/admin/users", 200, time.Millisecond, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, slow := s.Decide(tt.path, tt.status, tt.duration)
+			if log != tt.log || slow != tt.slow {
+				t.Errorf("Decide() = (%v, %v), want (%v, %v)", log, slow, tt.log, tt.slow)
+			}
+		})
+	}
+
+	if got := s.Logged() + s.SampledOut(); got != int64(len(tests)) {
+		t.Errorf("logged+sampled_out = %d, want %d", got, len(tests))
+	}
+}
+
+func TestRequestSamplerRate(t *testing.T) {
+	s := NewRequestSampler(config.RequestLogConfig{SampleRate: 0.25})
+	for i := 0; i < 10000; i++ {
+		s.Decide("/", 200, time.Millisecond)
+	}
+	if n := s.Logged(); n < 2000 || n > 3000 {
+		t.Errorf("expected ~2500 logged requests at rate 0.25, got %d", n)
+	}
+}
+
+func TestRequestSamplerNoAllocs(t *testing.T) {
+	s := NewRequestSampler(config.RequestLogConfig{SampleRate: 0.1, AlwaysPaths: []string{"/admin"}})
+	allocs := testing.AllocsPerRun(1000, func() {
+		s.Decide("/index.php", 200, time.Millisecond)
+	})
+	if allocs != 0 {
+		t.Errorf("expected zero allocations on the sampling path, got %v", allocs)
+	}
+}
+
+// At logging.level warn the request records are dropped, but slow requests
+// are still recorded and every request still counted.
+func TestCoreMiddlewareSlowRequestAtWarn(t *testing.T) {
+	var logs bytes.Buffer
+	accessLog := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	sampler := NewRequestSampler(config.RequestLogConfig{SampleRate: 1, SlowThreshold: config.Duration(20 * time.Millisecond)})
+	h := CoreMiddleware(accessLog, accessLog, sampler, config.TracingConfig{}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+
+	for _, path := range []string{"/fast", "/slow"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if !strings.Contains(logs.String(), "msg=slow_request") || !strings.Contains(logs.String(), "path=/slow") {
+		t.Errorf("no slow_request record at warn:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), "msg=request ") {
+		t.Errorf("request record written at warn:\n%s", logs.String())
+	}
+	if n := sampler.Logged() + sampler.SampledOut(); n != 2 {
+		t.Errorf("sampler decided %d requests, want 2", n)
+	}
+}
+
+// Without tracing or an access log to read it, no request context is
+// attached, sparing its allocations.
+func TestCoreMiddlewareNoRequestCtx(t *testing.T) {
+	accessLog := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError}))
+	var rc *MabooRequestCtx
+	h := CoreMiddleware(accessLog, accessLog, nil, config.TracingConfig{}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc = GetRequestCtx(r.Context())
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if rc != nil {
+		t.Errorf("request context attached with nothing to read it: %+v", rc)
+	}
+}
@@ -2,12 +2,18 @@ package server
 
 import (
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/notify"
 	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -38,6 +44,14 @@ func NewACMEManager(cfg *config.ACMEConfig, logger *slog.Logger) (*autocert.Mana
 		Cache:      autocert.DirCache(cacheDir),
 	}
 
+	if cfg.EABKeyID != "" {
+		key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cfg.EABHMACKey.Value(), "="))
+		if err != nil {
+			return nil, fmt.Errorf("decoding ACME eab_hmac_key: not valid base64url")
+		}
+		manager.ExternalAccountBinding = &acme.ExternalAccountBinding{KID: cfg.EABKeyID, Key: key}
+	}
+
 	if cfg.Staging {
 		// Use Let's Encrypt staging server
 		manager.Client = &acme.Client{DirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory"}
@@ -47,9 +61,10 @@ func NewACMEManager(cfg *config.ACMEConfig, logger *slog.Logger) (*autocert.Mana
 	return manager, nil
 }
 
-// HTTPRedirectServer starts an HTTP server on the given address that redirects to HTTPS.
-// It also handles ACME HTTP-01 challenges for Let's Encrypt certificate issuance.
-func HTTPRedirectServer(addr string, manager *autocert.Manager, logger *slog.Logger) *http.Server {
+// HTTPRedirectServer builds an HTTP server for addr that redirects to HTTPS.
+// It also handles ACME HTTP-01 challenges for Let's Encrypt certificate
+// issuance. The caller starts it on a listener.
+func HTTPRedirectServer(addr string, manager *autocert.Manager) *http.Server {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		httpsURL := "https://" + r.Host + r.URL.Path
@@ -62,19 +77,14 @@ func HTTPRedirectServer(addr string, manager *autocert.Manager, logger *slog.Log
 	// Handle ACME HTTP-01 challenge
 	handler := manager.HTTPHandler(mux)
 
-	srv := &http.Server{Addr: addr, Handler: handler}
-	go func() {
-		logger.Info("starting HTTP redirect server for ACME challenges", "address", addr)
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			logger.Error("HTTP redirect server error", "error", err)
-		}
-	}()
-	return srv
+	return &http.Server{Addr: addr, Handler: handler}
 }
 
 // SetupACME configures TLS with ACME (Let's Encrypt) certificate management.
-// Returns the TLS config and optionally starts an HTTP redirect server.
-func SetupACME(cfg *config.Config, logger *slog.Logger) (*tls.Config, *http.Server, error) {
+// Returns the TLS config and, when http_redirect is set, an HTTP redirect
+// server that has not been started. notifier, if not nil, hears of
+// certificates that cannot be obtained or renewed.
+func SetupACME(cfg *config.Config, logger *slog.Logger, notifier *notify.Notifier) (*tls.Config, *http.Server, error) {
 	if cfg.Server.TLS.ACME.Email == "" {
 		return nil, nil, fmt.Errorf("ACME email is required")
 	}
@@ -88,11 +98,74 @@ func SetupACME(cfg *config.Config, logger *slog.Logger) (*tls.Config, *http.Serv
 		GetCertificate: manager.GetCertificate,
 		MinVersion:     tls.VersionTLS12,
 	}
+	if notifier != nil {
+		tlsConfig.GetCertificate = newACMEWatch(manager, cfg.Server.TLS.ACME.Domains, notifier).GetCertificate
+	}
 
 	var redirectSrv *http.Server
 	if cfg.Server.HTTPRedirect {
-		redirectSrv = HTTPRedirectServer(":80", manager, logger)
+		redirectSrv = HTTPRedirectServer(":80", manager)
 	}
 
 	return tlsConfig, redirectSrv, nil
 }
+
+// Certificates this close to expiring should have been renewed long ago:
+// autocert starts renewing 30 days before.
+const acmeRenewalOverdue = 14 * 24 * time.Hour
+
+// acmeNotifyInterval spaces acme_failure notifications for a domain, which
+// would otherwise go out on every handshake.
+const acmeNotifyInterval = time.Hour
+
+// acmeWatch raises acme_failure notifications for the configured domains:
+// when no certificate can be obtained, and when the one served is close
+// to expiring because renewing it in the background keeps failing.
+type acmeWatch struct {
+	manager  *autocert.Manager
+	domains  []string
+	notifier *notify.Notifier
+
+	mu       sync.Mutex
+	notified map[string]time.Time // domain -> last notification
+}
+
+func newACMEWatch(manager *autocert.Manager, domains []string, notifier *notify.Notifier) *acmeWatch {
+	return &acmeWatch{
+		manager:  manager,
+		domains:  domains,
+		notifier: notifier,
+		notified: make(map[string]time.Time),
+	}
+}
+
+// GetCertificate is the manager's, noting failures for the configured
+// domains. Failures for other names are expected and not noted.
+func (a *acmeWatch) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := a.manager.GetCertificate(hello)
+	domain := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if !slices.Contains(a.domains, domain) {
+		return cert, err
+	}
+	switch {
+	case err != nil:
+		a.notify(domain, fmt.Sprintf("no certificate for %s", domain), map[string]string{"error": err.Error()})
+	case cert.Leaf != nil && time.Until(cert.Leaf.NotAfter) < acmeRenewalOverdue:
+		a.notify(domain, fmt.Sprintf("certificate for %s expires soon and has not been renewed", domain),
+			map[string]string{"not_after": cert.Leaf.NotAfter.UTC().Format(time.RFC3339)})
+	}
+	return cert, err
+}
+
+func (a *acmeWatch) notify(domain, message string, details map[string]string) {
+	now := time.Now()
+	a.mu.Lock()
+	if last, ok := a.notified[domain]; ok && now.Sub(last) < acmeNotifyInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.notified[domain] = now
+	a.mu.Unlock()
+	details["domain"] = domain
+	a.notifier.Notify(notify.Event{Type: notify.EventACMEFailure, Message: message, Details: details})
+}
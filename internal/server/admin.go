@@ -0,0 +1,776 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/audit"
+	"github.com/sadewadee/maboo/internal/handoff"
+	"github.com/sadewadee/maboo/internal/jobs"
+	"github.com/sadewadee/maboo/internal/notify"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/websocket"
+	"github.com/sadewadee/maboo/internal/worker"
+)
+
+// StatusReport is the body of the admin API's /status endpoint.
+type StatusReport struct {
+	Status        string              `json:"status"` // ready, not_ready
+	Version       string              `json:"version"`
+	PID           int                 `json:"pid"`
+	StartedAt     time.Time           `json:"started_at"`
+	UptimeSeconds float64             `json:"uptime_seconds"`
+	LastReload    *time.Time          `json:"last_reload,omitempty"`
+	Listeners     []Listener          `json:"listeners"`
+	Connections   ConnectionStatus    `json:"connections"`
+	PHP           PHPStatus           `json:"php"`
+	GoVersion     string              `json:"go_version"`
+	Pools         []PoolStatus        `json:"pools"`
+	WebSocket     *WebSocketStatus    `json:"websocket,omitempty"`
+	Watcher       *pool.WatcherStatus `json:"watcher,omitempty"`
+	Checks        []ProbeStatus       `json:"checks,omitempty"`
+	Jobs          []jobs.Status       `json:"jobs,omitempty"`
+}
+
+// Listener is one address maboo accepts connections on.
+type Listener struct {
+	Name    string `json:"name"` // http, https, http3, redirect, admin, metrics
+	Address string `json:"address"`
+}
+
+// ConnectionStatus counts the connections on the main listener.
+type ConnectionStatus struct {
+	Open   int              `json:"open"`
+	Limits ConnectionLimits `json:"limits"`
+}
+
+// ConnectionLimitsUpdate is the body of POST /server/limits. Omitted
+// fields keep their current value; 0 removes a limit.
+type ConnectionLimitsUpdate struct {
+	MaxConnections      *int `json:"max_connections"`
+	MaxConnectionsPerIP *int `json:"max_connections_per_ip"`
+}
+
+// CachePurge is the body of POST /cache/purge: a path, or a route
+// template such as /blog/* ("/*" purges everything).
+type CachePurge struct {
+	Path string `json:"path"`
+}
+
+// CachePurgeResult is the body of a successful POST /cache/purge.
+type CachePurgeResult struct {
+	Purged int `json:"purged"`
+}
+
+// WorkerReload is the body of POST /workers/reload: the worker group to
+// reload, as listed under pools in /status ("" = default).
+type WorkerReload struct {
+	Group string `json:"group"`
+}
+
+// WorkerReloadResult is the body of a successful POST /workers/reload.
+type WorkerReloadResult struct {
+	Group string `json:"group"`
+}
+
+// EvalRequest is the body of POST /eval: PHP code without the opening
+// <?php tag.
+type EvalRequest struct {
+	Code string `json:"code"`
+}
+
+// EvalResult is the body of a successful POST /eval.
+type EvalResult struct {
+	Output string `json:"output"`
+}
+
+// evaluator is a Pool that can evaluate PHP snippets: the embedded one.
+type evaluator interface {
+	Eval(ctx context.Context, code string, maxOutput int64) ([]byte, error)
+}
+
+// inspector is a Pool whose workers can be dumped and killed one by one.
+type inspector interface {
+	Dump(id int) (worker.Dump, error)
+	Kill(id int) error
+}
+
+// WorkerKillResult is the body of a successful POST /workers/{id}/kill.
+type WorkerKillResult struct {
+	ID int `json:"id"`
+}
+
+// PHPStatus describes the PHP runtime serving requests.
+type PHPStatus struct {
+	Version string `json:"version"`
+	Engine  string `json:"engine"` // "embedded" or the external binary
+	Mode    string `json:"mode"`
+}
+
+// PoolStatus is the state of one worker group.
+type PoolStatus struct {
+	Name     string `json:"name"`
+	Pool     string `json:"pool,omitempty"`   // default or websocket
+	VHost    string `json:"vhost,omitempty"`  // set for a group serving one virtual host
+	Status   string `json:"status,omitempty"` // ready, degraded or not_ready
+	Workers  int    `json:"workers"`
+	Busy     int    `json:"busy"`
+	Idle     int    `json:"idle"`
+	Requests int64  `json:"requests"`
+}
+
+// WebSocketStatus summarizes the WebSocket manager.
+type WebSocketStatus struct {
+	Connections     int              `json:"connections"`
+	Rooms           int              `json:"rooms"`
+	Users           int              `json:"users"`
+	DroppedSessions int              `json:"dropped_sessions"` // waiting to be resumed
+	Limits          websocket.Limits `json:"limits"`
+}
+
+// WebSocketRooms is the body of GET /websocket/rooms.
+type WebSocketRooms struct {
+	Rooms []websocket.RoomInfo `json:"rooms"`
+}
+
+// WebSocketLimitsUpdate is the body of POST /websocket/limits. Omitted
+// fields keep their current value; 0 removes a limit.
+type WebSocketLimitsUpdate struct {
+	MaxConnections      *int `json:"max_connections"`
+	MaxConnectionsPerIP *int `json:"max_connections_per_ip"`
+}
+
+// maxPublishBody caps the body of POST /internal/ws/publish.
+const maxPublishBody = 1 << 20
+
+// UpgradeResult is the body of a successful POST /upgrade.
+type UpgradeResult struct {
+	PID int `json:"pid"` // the new process, now serving
+}
+
+// AuditReport is the body of GET /audit: the administrative actions
+// kept by the audit log, newest first.
+type AuditReport struct {
+	Records []audit.Record `json:"records"`
+}
+
+// NotificationTest is the body of POST /notifications/test: how sending a
+// test event to each webhook went.
+type NotificationTest struct {
+	Deliveries []notify.Delivery `json:"deliveries"`
+}
+
+// CLIUserAgent starts the User-Agent of the maboo CLI's admin API
+// requests, which the audit log records as the cli actor.
+const CLIUserAgent = "maboo-cli"
+
+// SetVersion records the maboo version reported by the admin API.
+func (s *Server) SetVersion(v string) {
+	s.version = v
+}
+
+// SetWebSocket attaches the WebSocket manager whose stats the admin API
+// reports.
+func (s *Server) SetWebSocket(m *websocket.Manager) {
+	s.websocket = m
+}
+
+// SetWebSocketWorkers attaches the websocket worker group, reported next
+// to the HTTP pool by the admin API and the metrics. WebSocket events run
+// on it from then on, and ticks are skipped while it has no idle worker.
+func (s *Server) SetWebSocketWorkers(p *pool.Pool) {
+	s.wsWorkers = p
+	s.AddWorkerGroup(processGroup(poolWebSocket, poolWebSocket, p))
+	if s.websocket != nil {
+		s.websocket.SetPHPForwarder(p.ExecStream)
+		s.websocket.SetWorkersSaturated(func() bool { return p.Stats().IdleWorkers == 0 })
+	}
+}
+
+// SetWebSocketProtocolWorkers attaches the worker group running the
+// worker of one of websocket.subprotocols. Events of the connections that
+// negotiated it run there; it is reported as the group
+// websocket:<subprotocol>.
+func (s *Server) SetWebSocketProtocolWorkers(subprotocol string, p *pool.Pool) {
+	s.AddWorkerGroup(processGroup(poolWebSocket+":"+subprotocol, poolWebSocket, p))
+	if s.websocket != nil {
+		s.websocket.SetProtocolForwarder(subprotocol, p.ExecStream)
+	}
+}
+
+// AddWorkerGroup reports g next to the HTTP pool in the metrics, the admin
+// API and /health, replacing a group of the same name.
+func (s *Server) AddWorkerGroup(g WorkerGroup) {
+	s.metrics.groups.add(g)
+}
+
+// RemoveWorkerGroup stops reporting the group name, e.g. once a reload has
+// removed it; its series are gone from the next scrape. It reports whether
+// the group was known.
+func (s *Server) RemoveWorkerGroup(name string) bool {
+	return s.metrics.groups.remove(name)
+}
+
+// ReloadWorkerGroup replaces the workers of the group name, the default
+// group when it names none, leaving the other groups serving.
+func (s *Server) ReloadWorkerGroup(name string) error {
+	if name == "" {
+		name = poolDefault
+	}
+	if err := s.metrics.groups.reload(name); err != nil {
+		return err
+	}
+	s.logger.Info("workers reloaded", "group", name)
+	s.RecordReload()
+	return nil
+}
+
+// Invoke runs r in the worker group it names, the default group when it
+// names none, bypassing the HTTP middleware and router. It is the way for
+// Go code to call PHP: see phpengine.InternalRequest.
+func (s *Server) Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	return s.metrics.groups.invoke(ctx, r)
+}
+
+// SetWatcher attaches the file watcher whose state the admin API and the
+// verbose health output report; nil detaches it. A config reload that
+// changes the watch section attaches the new watcher.
+func (s *Server) SetWatcher(w *pool.Watcher) {
+	s.watcher.Store(w)
+	s.router.healthHandler.SetWatcher(w)
+}
+
+// SetJobs attaches the job manager whose processes the admin API and the
+// health endpoints report.
+func (s *Server) SetJobs(m *jobs.Manager) {
+	s.jobs = m
+	s.router.healthHandler.SetJobs(m)
+}
+
+// SetAudit makes the admin API record its actions in l, and serve l's
+// recent records at GET /audit.
+func (s *Server) SetAudit(l *audit.Log) {
+	s.audit = l
+}
+
+// SetNotifier sends notifications of health probes changing state and of
+// ACME failures to n, and enables POST /notifications/test on the admin
+// API. Call before Bind.
+func (s *Server) SetNotifier(n *notify.Notifier) {
+	s.notifier = n
+	s.probes.notifier = n
+}
+
+// SetUpgrade enables POST /upgrade on the admin API. fn starts the new
+// binary and returns its pid once it is serving.
+func (s *Server) SetUpgrade(fn func() (int, error)) {
+	s.upgrade = fn
+}
+
+// RecordReload notes a config or worker reload for the admin API.
+func (s *Server) RecordReload() {
+	s.lastReload.Store(time.Now().UnixNano())
+}
+
+// Status builds the current StatusReport.
+func (s *Server) Status() StatusReport {
+	cfg := s.router.cfg.Load()
+
+	status := "ready"
+	if !s.router.healthHandler.ready() {
+		status = "not_ready"
+	}
+	engine := "embedded"
+	if cfg.PHP.Binary != "" {
+		engine = cfg.PHP.Binary
+	}
+
+	r := StatusReport{
+		Status:        status,
+		Version:       s.version,
+		PID:           os.Getpid(),
+		StartedAt:     startTime,
+		UptimeSeconds: time.Since(startTime).Seconds(),
+		Listeners:     s.listeners(),
+		Connections:   ConnectionStatus{Open: s.conns.Open(), Limits: s.conns.Limits()},
+		PHP: PHPStatus{
+			Version: phpengine.SelectVersion(cfg.App.Root, cfg.PHP.Version),
+			Engine:  engine,
+			Mode:    s.pool.Mode(),
+		},
+		GoVersion: runtime.Version(),
+		Pools:     s.metrics.groups.status(),
+		Checks:    s.probes.Statuses(),
+	}
+	if ns := s.lastReload.Load(); ns != 0 {
+		t := time.Unix(0, ns)
+		r.LastReload = &t
+	}
+	if s.websocket != nil {
+		ws := s.websocket.Stats()
+		r.WebSocket = &WebSocketStatus{
+			Connections:     ws.TotalConnections,
+			Rooms:           ws.TotalRooms,
+			Users:           ws.TotalUsers,
+			DroppedSessions: ws.DroppedSessions,
+			Limits:          s.websocket.Limits(),
+		}
+	}
+	if w := s.watcher.Load(); w != nil {
+		ws := w.Status()
+		r.Watcher = &ws
+	}
+	if s.jobs != nil {
+		r.Jobs = s.jobs.Statuses()
+	}
+	return r
+}
+
+// listeners derives the listener list from the startup config; the
+// servers themselves are created by Start on another goroutine.
+func (s *Server) listeners() []Listener {
+	addr := s.cfg.Server.Address
+	if !s.useTLS() {
+		return s.withSideListeners([]Listener{{Name: "http", Address: addr}})
+	}
+	out := []Listener{{Name: "https", Address: addr}}
+	if s.cfg.Server.HTTP3 {
+		out = append(out, Listener{Name: "http3", Address: addr})
+	}
+	if s.cfg.Server.TLS.ACME.Email != "" && s.cfg.Server.HTTPRedirect {
+		out = append(out, Listener{Name: "redirect", Address: ":80"})
+	}
+	return s.withSideListeners(out)
+}
+
+func (s *Server) withSideListeners(out []Listener) []Listener {
+	if s.admin != nil {
+		out = append(out, Listener{Name: "admin", Address: s.admin.Addr})
+	}
+	if s.metricsSrv != nil {
+		out = append(out, Listener{Name: "metrics", Address: s.metricsSrv.Addr})
+	}
+	return out
+}
+
+// newAdminServer builds the admin API listener.
+func (s *Server) newAdminServer() *http.Server {
+	token := s.cfg.Admin.Token.Value()
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if token != "" && !validBearer(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	// Apps publishing to WebSocket clients can get a token of their own,
+	// which grants nothing else.
+	publishToken := s.cfg.WebSocket.PublishToken.Value()
+	publishAuthorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if publishToken == "" {
+			return authorized(w, r)
+		}
+		if validBearer(r, publishToken) || (token != "" && validBearer(r, token)) {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="publish"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	// Actions that change the server run through the audit log, whether
+	// they succeed, fail or are refused as invalid.
+	tokenID := audit.TokenID(token)
+	audited := func(action string, h func(w http.ResponseWriter, r *http.Request, params map[string]any)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !authorized(w, r) {
+				return
+			}
+			actor := audit.Actor{Kind: audit.ActorAdmin, ID: tokenID}
+			if strings.HasPrefix(r.UserAgent(), CLIUserAgent) {
+				actor.Kind = audit.ActorCLI
+			}
+			params := make(map[string]any)
+			rec := &auditWriter{ResponseWriter: w}
+			s.audit.Do(actor, action, params, func() error {
+				h(rec, r, params)
+				return rec.err()
+			})
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		report := s.Status()
+		code := http.StatusOK
+		if report.Status != "ready" {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	})
+	mux.HandleFunc("POST /upgrade", audited(audit.ActionUpgrade, func(w http.ResponseWriter, r *http.Request, params map[string]any) {
+		if s.upgrade == nil {
+			http.Error(w, "upgrade not available", http.StatusNotImplemented)
+			return
+		}
+		pid, err := s.upgrade()
+		switch {
+		case errors.Is(err, handoff.ErrInProgress), errors.Is(err, handoff.ErrParentDraining):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		params["pid"] = pid
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UpgradeResult{PID: pid})
+	}))
+	mux.HandleFunc("POST /server/limits", audited(audit.ActionSetServerLimits, func(w http.ResponseWriter, r *http.Request, params map[string]any) {
+		var update ConnectionLimitsUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		limits := s.conns.Limits()
+		if update.MaxConnections != nil {
+			limits.MaxConnections = *update.MaxConnections
+			params["max_connections"] = limits.MaxConnections
+		}
+		if update.MaxConnectionsPerIP != nil {
+			limits.MaxConnectionsPerIP = *update.MaxConnectionsPerIP
+			params["max_connections_per_ip"] = limits.MaxConnectionsPerIP
+		}
+		if limits.MaxConnections < 0 || limits.MaxConnectionsPerIP < 0 {
+			http.Error(w, "limits must not be negative", http.StatusBadRequest)
+			return
+		}
+		s.conns.SetLimits(limits)
+		s.logger.Info("connection limits changed", "max_connections", limits.MaxConnections,
+			"max_connections_per_ip", limits.MaxConnectionsPerIP)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(limits)
+	}))
+	mux.HandleFunc("POST /cache/purge", audited(audit.ActionPurgeCache, func(w http.ResponseWriter, r *http.Request, params map[string]any) {
+		var purge CachePurge
+		if err := json.NewDecoder(r.Body).Decode(&purge); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		params["path"] = purge.Path
+		n, err := s.router.cache.Purge(purge.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.logger.Info("response cache purged", "path", purge.Path, "purged", n)
+		params["purged"] = n
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CachePurgeResult{Purged: n})
+	}))
+	mux.HandleFunc("POST /workers/reload", audited(audit.ActionReloadWorkers, func(w http.ResponseWriter, r *http.Request, params map[string]any) {
+		var reload WorkerReload
+		if err := json.NewDecoder(r.Body).Decode(&reload); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if reload.Group == "" {
+			reload.Group = poolDefault
+		}
+		params["group"] = reload.Group
+		err := s.ReloadWorkerGroup(reload.Group)
+		switch {
+		case errors.Is(err, errUnknownGroup):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errNotReloadable):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(WorkerReloadResult{Group: reload.Group})
+	}))
+	// workerOf answers for a request naming a worker of the default group
+	// that cannot be inspected, or returns the pool and the worker's id.
+	workerOf := func(w http.ResponseWriter, r *http.Request, params map[string]any) (inspector, int, bool) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "worker id must be a number", http.StatusBadRequest)
+			return nil, 0, false
+		}
+		params["worker_id"] = id
+		pool, ok := s.pool.(inspector)
+		if !ok {
+			http.Error(w, "worker inspection not supported by this pool", http.StatusNotImplemented)
+			return nil, 0, false
+		}
+		return pool, id, true
+	}
+	mux.HandleFunc("GET /workers/{id}/dump", audited(audit.ActionDumpWorker, func(w http.ResponseWriter, r *http.Request, params map[string]any) {
+		pool, id, ok := workerOf(w, r, params)
+		if !ok {
+			return
+		}
+		dump, err := pool.Dump(id)
+		switch {
+		case errors.Is(err, worker.ErrNoWorker):
+			http.Error(w, fmt.Sprintf("no worker %d", id), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		params["state"] = dump.State
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(dump)
+	}))
+	mux.HandleFunc("POST /workers/{id}/kill", audited(audit.ActionKillWorker, func(w http.ResponseWriter, r *http.Request, params map[string]any) {
+		pool, id, ok := workerOf(w, r, params)
+		if !ok {
+			return
+		}
+		err := pool.Kill(id)
+		switch {
+		case errors.Is(err, worker.ErrNoWorker):
+			http.Error(w, fmt.Sprintf("no worker %d", id), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(WorkerKillResult{ID: id})
+	}))
+	mux.HandleFunc("POST /websocket/limits", audited(audit.ActionSetWebSocketLimits, func(w http.ResponseWriter, r *http.Request, params map[string]any) {
+		if s.websocket == nil {
+			http.Error(w, "websocket not enabled", http.StatusNotImplemented)
+			return
+		}
+		var update WebSocketLimitsUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		limits := s.websocket.Limits()
+		if update.MaxConnections != nil {
+			limits.MaxConnections = *update.MaxConnections
+			params["max_connections"] = limits.MaxConnections
+		}
+		if update.MaxConnectionsPerIP != nil {
+			limits.MaxConnectionsPerIP = *update.MaxConnectionsPerIP
+			params["max_connections_per_ip"] = limits.MaxConnectionsPerIP
+		}
+		if limits.MaxConnections < 0 || limits.MaxConnectionsPerIP < 0 {
+			http.Error(w, "limits must not be negative", http.StatusBadRequest)
+			return
+		}
+		s.websocket.SetLimits(limits)
+		s.logger.Info("websocket limits changed", "max_connections", limits.MaxConnections,
+			"max_connections_per_ip", limits.MaxConnectionsPerIP)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(limits)
+	}))
+	mux.HandleFunc("POST /notifications/test", audited(audit.ActionTestNotifications, func(w http.ResponseWriter, r *http.Request, params map[string]any) {
+		if s.notifier == nil {
+			http.Error(w, "notifications not configured", http.StatusNotImplemented)
+			return
+		}
+		deliveries := s.notifier.Test(r.Context(), "test notification from the maboo admin API")
+		failed := 0
+		for _, d := range deliveries {
+			if d.Error != "" {
+				failed++
+			}
+		}
+		params["webhooks"] = len(deliveries)
+		params["failed"] = failed
+		code := http.StatusOK
+		if failed > 0 {
+			code = http.StatusBadGateway
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(NotificationTest{Deliveries: deliveries})
+	}))
+	evalCfg := s.cfg.Admin.Eval
+	mux.HandleFunc("POST /eval", audited(audit.ActionEval, func(w http.ResponseWriter, r *http.Request, params map[string]any) {
+		if !evalCfg.Enabled {
+			http.Error(w, "eval not enabled (admin.eval.enabled)", http.StatusForbidden)
+			return
+		}
+		var req EvalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		params["code"] = req.Code
+		if strings.TrimSpace(req.Code) == "" {
+			http.Error(w, "code is required", http.StatusBadRequest)
+			return
+		}
+		pool, ok := s.pool.(evaluator)
+		if !ok {
+			http.Error(w, "eval needs the embedded engine, not php.binary", http.StatusNotImplemented)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), evalCfg.Timeout.Duration())
+		defer cancel()
+		out, err := pool.Eval(ctx, req.Code, int64(evalCfg.MaxOutput))
+		switch {
+		case errors.Is(err, phpengine.ErrEvalUnavailable):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, fmt.Sprintf("snippet did not finish within admin.eval.timeout (%s)", evalCfg.Timeout.Duration()), http.StatusGatewayTimeout)
+			return
+		case errors.Is(err, phpengine.ErrResponseTooLarge):
+			http.Error(w, fmt.Sprintf("output exceeds admin.eval.max_output (%d bytes)", evalCfg.MaxOutput), http.StatusBadGateway)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		params["output_bytes"] = len(out)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(EvalResult{Output: string(out)})
+	}))
+	mux.HandleFunc("GET /audit", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AuditReport{Records: s.audit.Recent(limit)})
+	})
+	mux.HandleFunc("GET /websocket/rooms", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		if s.websocket == nil {
+			http.Error(w, "websocket not enabled", http.StatusNotImplemented)
+			return
+		}
+		rooms, err := s.websocket.Rooms(r.URL.Query().Get("pattern"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(WebSocketRooms{Rooms: rooms})
+	})
+	mux.HandleFunc("POST /internal/ws/publish", func(w http.ResponseWriter, r *http.Request) {
+		if !publishAuthorized(w, r) {
+			return
+		}
+		if s.websocket == nil {
+			http.Error(w, "websocket not enabled", http.StatusNotImplemented)
+			return
+		}
+		var p websocket.Publication
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPublishBody)).Decode(&p); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		delivery, err := s.websocket.Publish(p)
+		switch {
+		case errors.Is(err, websocket.ErrPublishRateLimited):
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(delivery)
+	})
+	mux.HandleFunc("GET /internal/ws/presence", func(w http.ResponseWriter, r *http.Request) {
+		if !publishAuthorized(w, r) {
+			return
+		}
+		if s.websocket == nil {
+			http.Error(w, "websocket not enabled", http.StatusNotImplemented)
+			return
+		}
+		room := r.URL.Query().Get("room")
+		if room == "" {
+			http.Error(w, "room is required", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.websocket.Presence(room))
+	})
+	return &http.Server{
+		Addr:         s.cfg.Admin.Address,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 45 * time.Second, // POST /upgrade waits for the new process
+	}
+}
+
+// auditWriter remembers the error an audited admin handler answered with.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte // the start of an error response
+}
+
+// maxAuditError caps the error message an audit record keeps.
+const maxAuditError = 256
+
+func (w *auditWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && len(w.body) < maxAuditError {
+		w.body = append(w.body, b[:min(len(b), maxAuditError-len(w.body))]...)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// err is the handler's error answer, or nil if it succeeded.
+func (w *auditWriter) err() error {
+	if w.status < http.StatusBadRequest {
+		return nil
+	}
+	msg := strings.TrimSpace(string(w.body))
+	if msg == "" {
+		msg = http.StatusText(w.status)
+	}
+	return fmt.Errorf("%d: %s", w.status, msg)
+}
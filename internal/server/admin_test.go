@@ -0,0 +1,675 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sadewadee/maboo/internal/audit"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/handoff"
+	"github.com/sadewadee/maboo/internal/notify"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/websocket"
+	"github.com/sadewadee/maboo/internal/worker"
+)
+
+type stubPool struct{ workers int }
+
+func (p *stubPool) Start() error { return nil }
+func (p *stubPool) Stop() error  { return nil }
+func (p *stubPool) Exec(*phpengine.Context, string) (*phpengine.Response, error) {
+	return &phpengine.Response{Status: 200}, nil
+}
+func (p *stubPool) Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	return phpengine.Invoke(ctx, p, "", r)
+}
+func (p *stubPool) Mode() string                   { return "worker" }
+func (p *stubPool) Stats() worker.StatsGetter      { return stubStats{p.workers} }
+func (p *stubPool) SetConfig(*config.Config) error { return nil }
+func (p *stubPool) Reload() error                  { return nil }
+func (p *stubPool) Invalidate([]string) error      { return nil }
+
+type stubStats struct{ workers int }
+
+func (s stubStats) TotalWorkers() int    { return s.workers }
+func (s stubStats) BusyWorkers() int     { return 0 }
+func (s stubStats) IdleWorkers() int     { return s.workers }
+func (s stubStats) TotalRequests() int64 { return 42 }
+
+func newAdminTestServer(t *testing.T, workers int) *Server {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Admin.Enabled = true
+	cfg.Admin.Token = "s3cret"
+	cfg.PHP.Version = "8.2"
+	s := New(cfg, &stubPool{workers: workers}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.SetVersion("1.2.3")
+	return s
+}
+
+func getStatus(t *testing.T, s *Server, token string) (*httptest.ResponseRecorder, StatusReport) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/status", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.admin.Handler.ServeHTTP(rec, req)
+	var report StatusReport
+	if rec.Code != http.StatusUnauthorized {
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatalf("decoding status: %v", err)
+		}
+	}
+	return rec, report
+}
+
+func TestAdminStatus(t *testing.T) {
+	s := newAdminTestServer(t, 2)
+
+	if rec, _ := getStatus(t, s, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want 401", rec.Code)
+	}
+
+	rec, r := getStatus(t, s, "s3cret")
+	if rec.Code != http.StatusOK || r.Status != "ready" {
+		t.Fatalf("status %d, report %+v", rec.Code, r)
+	}
+	if r.Version != "1.2.3" || r.PHP.Version != "8.2" || r.PHP.Engine != "embedded" {
+		t.Errorf("versions = %q, %+v", r.Version, r.PHP)
+	}
+	if len(r.Pools) != 1 || r.Pools[0].Workers != 2 || r.Pools[0].Requests != 42 {
+		t.Errorf("pools = %+v", r.Pools)
+	}
+	want := []Listener{{"http", "0.0.0.0:8080"}, {"admin", "127.0.0.1:9180"}}
+	if len(r.Listeners) != 2 || r.Listeners[0] != want[0] || r.Listeners[1] != want[1] {
+		t.Errorf("listeners = %+v, want %+v", r.Listeners, want)
+	}
+	if r.LastReload != nil {
+		t.Errorf("last reload = %v before any reload", r.LastReload)
+	}
+
+	s.RecordReload()
+	if _, r := getStatus(t, s, "s3cret"); r.LastReload == nil {
+		t.Error("last reload not reported after RecordReload")
+	}
+}
+
+func TestAdminStatusWatcher(t *testing.T) {
+	s := newAdminTestServer(t, 1)
+	if _, r := getStatus(t, s, "s3cret"); r.Watcher != nil {
+		t.Errorf("watcher = %+v without a watcher", r.Watcher)
+	}
+
+	cfg := config.Default().Watch
+	cfg.Dirs = []string{t.TempDir()}
+	cfg.Backend = pool.BackendPoll
+	cfg.Interval = config.Duration(time.Hour)
+	w, err := pool.NewWatcher(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), func(pool.Action, []string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	s.SetWatcher(w)
+
+	_, r := getStatus(t, s, "s3cret")
+	if r.Watcher == nil || !r.Watcher.Running || r.Watcher.Backend != pool.BackendPoll || len(r.Watcher.Dirs) != 1 {
+		t.Errorf("watcher = %+v", r.Watcher)
+	}
+}
+
+func TestAdminStatusWebSocketWorkers(t *testing.T) {
+	s := newAdminTestServer(t, 2)
+	cfg := config.Default()
+	cfg.WebSocket.MaxWorkers = 3
+	s.SetWebSocketWorkers(pool.New(cfg.WebSocketPool(), cfg.PHP, slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	_, r := getStatus(t, s, "s3cret")
+	if len(r.Pools) != 2 || r.Pools[0].Name != "default" || r.Pools[1].Name != "websocket" {
+		t.Fatalf("pools = %+v, want default and websocket", r.Pools)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s.metrics)
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`maboo_workers_total{group="default",pool="default",vhost=""} 2`,
+		`maboo_workers_total{group="websocket",pool="websocket",vhost=""} 0`,
+		`maboo_pool_requests_total{group="default",pool="default",vhost=""} 42`,
+		`maboo_pool_requests_total{group="websocket",pool="websocket",vhost=""} 0`,
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, rec.Body)
+		}
+	}
+}
+
+func TestAdminStatusNotReady(t *testing.T) {
+	rec, r := getStatus(t, newAdminTestServer(t, 0), "s3cret")
+	if rec.Code != http.StatusServiceUnavailable || r.Status != "not_ready" {
+		t.Errorf("status %d, report status %q; want 503 not_ready", rec.Code, r.Status)
+	}
+}
+
+func TestAdminUpgrade(t *testing.T) {
+	s := newAdminTestServer(t, 2)
+	post := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/upgrade", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post("s3cret"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without SetUpgrade: status %d, want 501", rec.Code)
+	}
+
+	var err error
+	s.SetUpgrade(func() (int, error) { return 4321, err })
+	if rec := post(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want 401", rec.Code)
+	}
+
+	rec := post("s3cret")
+	var result UpgradeResult
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&result) != nil || result.PID != 4321 {
+		t.Errorf("status %d, body %q; want 200 with pid 4321", rec.Code, rec.Body)
+	}
+
+	err = handoff.ErrInProgress
+	if rec := post("s3cret"); rec.Code != http.StatusConflict {
+		t.Errorf("upgrade in progress: status %d, want 409", rec.Code)
+	}
+	err = errors.New("new process exited before it was ready")
+	if rec := post("s3cret"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("failed upgrade: status %d, want 500", rec.Code)
+	}
+}
+
+func TestAdminAudit(t *testing.T) {
+	s := newAdminTestServer(t, 2)
+	s.SetAudit(audit.New(slog.New(slog.NewTextHandler(io.Discard, nil)), 10))
+	do := func(method, target, body, userAgent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		if userAgent != "" {
+			req.Header.Set("User-Agent", userAgent)
+		}
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	s.SetUpgrade(func() (int, error) { return 4321, nil })
+	do("POST", "/upgrade", "", CLIUserAgent+"/1.2.3")
+	do("POST", "/server/limits", `{"max_connections": 500}`, "")
+	do("POST", "/server/limits", `{"max_connections": -1}`, "")
+
+	rec := do("GET", "/audit", "", "")
+	var report AuditReport
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&report) != nil {
+		t.Fatalf("GET /audit: status %d, body %q", rec.Code, rec.Body)
+	}
+	if len(report.Records) != 3 {
+		t.Fatalf("records = %+v, want 3", report.Records)
+	}
+	refused, limits, upgrade := report.Records[0], report.Records[1], report.Records[2]
+	if upgrade.Action != audit.ActionUpgrade || upgrade.Actor != audit.ActorCLI || upgrade.Params["pid"] != float64(4321) {
+		t.Errorf("upgrade record = %+v", upgrade)
+	}
+	if limits.Action != audit.ActionSetServerLimits || limits.Actor != audit.ActorAdmin || limits.Result != audit.ResultOK ||
+		limits.ActorID != audit.TokenID("s3cret") || limits.Params["max_connections"] != float64(500) {
+		t.Errorf("limits record = %+v", limits)
+	}
+	if refused.Result != audit.ResultError || !strings.Contains(refused.Error, "must not be negative") {
+		t.Errorf("refused record = %+v", refused)
+	}
+
+	if rec := do("GET", "/audit?limit=1", "", ""); !strings.Contains(rec.Body.String(), `"max_connections":-1`) || strings.Contains(rec.Body.String(), "upgrade") {
+		t.Errorf("GET /audit?limit=1: %s", rec.Body)
+	}
+	if rec := do("GET", "/audit?limit=x", "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit: status %d, want 400", rec.Code)
+	}
+	req := httptest.NewRequest("GET", "/audit", nil)
+	rec = httptest.NewRecorder()
+	s.admin.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want 401", rec.Code)
+	}
+}
+
+func TestAdminNotificationTest(t *testing.T) {
+	s := newAdminTestServer(t, 2)
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/notifications/test", nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := post(); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without webhooks: status %d, want 501", rec.Code)
+	}
+
+	got := make(chan string, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body notify.Payload
+		json.NewDecoder(r.Body).Decode(&body)
+		got <- body.Event
+	}))
+	defer hook.Close()
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+	n := notify.New([]notify.Webhook{{Name: "ops", URL: hook.URL, Events: []string{notify.EventWorkerCrashLoop}}}, notify.Options{QueueSize: 1}, discard)
+	defer n.Close(context.Background())
+	s.SetNotifier(n)
+	s.SetAudit(audit.New(discard, 10))
+
+	rec := post()
+	var result NotificationTest
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&result) != nil {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+	if len(result.Deliveries) != 1 || result.Deliveries[0] != (notify.Delivery{Webhook: "ops"}) {
+		t.Errorf("deliveries = %+v", result.Deliveries)
+	}
+	if event := <-got; event != notify.EventTest {
+		t.Errorf("webhook got event %q, want test", event)
+	}
+
+	hook.Close()
+	if rec := post(); rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), `"webhook":"ops","error"`) {
+		t.Errorf("webhook down: status %d, body %s", rec.Code, rec.Body)
+	}
+	records := s.audit.Recent(0)
+	if len(records) != 2 || records[0].Action != audit.ActionTestNotifications || records[0].Result != audit.ResultError ||
+		records[1].Result != audit.ResultOK || records[1].Params["webhooks"] != 1 {
+		t.Errorf("audit records = %+v", records)
+	}
+}
+
+// evalPool is a stubPool evaluating PHP with eval.
+type evalPool struct {
+	stubPool
+	eval func(ctx context.Context, code string, maxOutput int64) ([]byte, error)
+}
+
+func (p *evalPool) Eval(ctx context.Context, code string, maxOutput int64) ([]byte, error) {
+	return p.eval(ctx, code, maxOutput)
+}
+
+func TestAdminEval(t *testing.T) {
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+	newServer := func(enabled bool, pool Pool) *Server {
+		cfg := config.Default()
+		cfg.Admin.Enabled = true
+		cfg.Admin.Token = "s3cret"
+		cfg.Admin.Eval.Enabled = enabled
+		cfg.Admin.Eval.Timeout = config.Duration(50 * time.Millisecond)
+		cfg.Admin.Eval.MaxOutput = 16
+		s := New(cfg, pool, discard)
+		s.SetAudit(audit.New(discard, 10))
+		return s
+	}
+	post := func(s *Server, code string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(EvalRequest{Code: code})
+		req := httptest.NewRequest("POST", "/eval", strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	pool := &evalPool{stubPool: stubPool{workers: 1}, eval: func(ctx context.Context, code string, maxOutput int64) ([]byte, error) {
+		switch code {
+		case "sleep(1);":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case "echo str_repeat('x', 100);":
+			return nil, phpengine.ErrResponseTooLarge
+		}
+		if maxOutput != 16 {
+			t.Errorf("maxOutput = %d, want admin.eval.max_output", maxOutput)
+		}
+		return []byte("8.3.4"), nil
+	}}
+
+	if rec := post(newServer(false, pool), "echo PHP_VERSION;"); rec.Code != http.StatusForbidden {
+		t.Errorf("disabled: status %d, want 403", rec.Code)
+	}
+	if rec := post(newServer(true, &stubPool{workers: 1}), "echo PHP_VERSION;"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without the embedded engine: status %d, want 501", rec.Code)
+	}
+
+	s := newServer(true, pool)
+	rec := post(s, "echo PHP_VERSION;")
+	var result EvalResult
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&result) != nil || result.Output != "8.3.4" {
+		t.Errorf("eval: status %d, body %q", rec.Code, rec.Body)
+	}
+	if rec := post(s, "sleep(1);"); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("slow snippet: status %d, want 504", rec.Code)
+	}
+	if rec := post(s, "echo str_repeat('x', 100);"); rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "max_output") {
+		t.Errorf("large output: status %d, body %q", rec.Code, rec.Body)
+	}
+	if rec := post(s, " "); rec.Code != http.StatusBadRequest {
+		t.Errorf("no code: status %d, want 400", rec.Code)
+	}
+
+	// Every snippet is audited with its code, the refused ones too.
+	records := s.audit.Recent(0)
+	if len(records) != 4 {
+		t.Fatalf("records = %+v, want 4", records)
+	}
+	if r := records[3]; r.Action != audit.ActionEval || r.Result != audit.ResultOK || r.Params["code"] != "echo PHP_VERSION;" || r.Params["output_bytes"] != 5 {
+		t.Errorf("eval record = %+v", r)
+	}
+	if r := records[2]; r.Result != audit.ResultError || r.Params["code"] != "sleep(1);" {
+		t.Errorf("timeout record = %+v", r)
+	}
+}
+
+func TestAdminWebSocketLimits(t *testing.T) {
+	s := newAdminTestServer(t, 2)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/websocket/limits", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post(`{"max_connections": 10}`); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without websocket: status %d, want 501", rec.Code)
+	}
+
+	m := websocket.NewManager(s.logger)
+	m.SetLimits(websocket.Limits{MaxConnections: 100, MaxConnectionsPerIP: 5})
+	s.SetWebSocket(m)
+
+	rec := post(`{"max_connections_per_ip": 2}`)
+	var limits websocket.Limits
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&limits) != nil {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+	if want := (websocket.Limits{MaxConnections: 100, MaxConnectionsPerIP: 2}); limits != want || m.Limits() != want {
+		t.Errorf("limits = %+v, manager %+v; want %+v", limits, m.Limits(), want)
+	}
+	if _, r := getStatus(t, s, "s3cret"); r.WebSocket == nil || r.WebSocket.Limits.MaxConnectionsPerIP != 2 {
+		t.Errorf("status websocket = %+v, want the new limits", r.WebSocket)
+	}
+
+	for _, body := range []string{`{"max_connections": -1}`, `not json`} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+	if m.Limits().MaxConnections != 100 {
+		t.Errorf("rejected update changed the limits to %+v", m.Limits())
+	}
+}
+
+func TestAdminWorkerReload(t *testing.T) {
+	s := newAdminTestServer(t, 2)
+	var shopReloads, blogReloads int
+	stats := func() GroupStats { return GroupStats{} }
+	s.AddWorkerGroup(WorkerGroup{Name: "shop", Pool: poolDefault, Stats: stats, Reload: func() error { shopReloads++; return nil }})
+	s.AddWorkerGroup(WorkerGroup{Name: "blog", Pool: poolDefault, Stats: stats, Reload: func() error { blogReloads++; return errors.New("spawn failed") }})
+	s.AddWorkerGroup(WorkerGroup{Name: "static", Pool: poolDefault, Stats: stats})
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/workers/reload", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(`{"group": "shop"}`)
+	var result WorkerReloadResult
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&result) != nil || result.Group != "shop" {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+	if shopReloads != 1 || blogReloads != 0 {
+		t.Errorf("reloads: shop %d, blog %d; want only shop's", shopReloads, blogReloads)
+	}
+	if _, r := getStatus(t, s, "s3cret"); r.LastReload == nil {
+		t.Error("status has no last reload")
+	}
+	if rec := post(""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"group":"default"`) {
+		t.Errorf("no body: status %d, body %q; want the default group reloaded", rec.Code, rec.Body)
+	}
+
+	for body, code := range map[string]int{
+		`{"group": "nope"}`:   http.StatusNotFound,
+		`{"group": "static"}`: http.StatusConflict,
+		`{"group": "blog"}`:   http.StatusInternalServerError,
+		`not json`:            http.StatusBadRequest,
+	} {
+		if rec := post(body); rec.Code != code {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, code)
+		}
+	}
+	if shopReloads != 1 {
+		t.Errorf("shop reloaded %d times, want once", shopReloads)
+	}
+}
+
+// inspectPool is a stubPool with one busy worker, 1, that can be dumped
+// and killed.
+type inspectPool struct {
+	stubPool
+	killed []int
+}
+
+func (p *inspectPool) Dump(id int) (worker.Dump, error) {
+	if id != 1 {
+		return worker.Dump{}, worker.ErrNoWorker
+	}
+	d := worker.Dump{ID: 1, State: "busy", Jobs: 7, Backtrace: "#0 /app/index.php(3): sleep(10)"}
+	d.SetRequest(&worker.Activity{Method: "GET", Path: "/slow", RequestID: "abc123", Started: time.Now().Add(-2 * time.Second)})
+	return d, nil
+}
+
+func (p *inspectPool) Kill(id int) error {
+	if id != 1 {
+		return worker.ErrNoWorker
+	}
+	p.killed = append(p.killed, id)
+	return nil
+}
+
+func TestAdminWorkerDumpAndKill(t *testing.T) {
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+	newServer := func(pool Pool) *Server {
+		cfg := config.Default()
+		cfg.Admin.Enabled = true
+		cfg.Admin.Token = "s3cret"
+		s := New(cfg, pool, discard)
+		s.SetAudit(audit.New(discard, 10))
+		return s
+	}
+	do := func(s *Server, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	pool := &inspectPool{stubPool: stubPool{workers: 1}}
+	s := newServer(pool)
+
+	rec := do(s, "GET", "/workers/1/dump")
+	var dump worker.Dump
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&dump) != nil {
+		t.Fatalf("dump: status %d, body %q", rec.Code, rec.Body)
+	}
+	if dump.State != "busy" || dump.Request == nil || dump.Request.RequestID != "abc123" || dump.ElapsedSeconds < 2 || dump.Backtrace == "" {
+		t.Errorf("dump = %+v", dump)
+	}
+	rec = do(s, "POST", "/workers/1/kill")
+	var killed WorkerKillResult
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&killed) != nil || killed.ID != 1 || len(pool.killed) != 1 {
+		t.Errorf("kill: status %d, body %q", rec.Code, rec.Body)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", "/workers/9/dump", http.StatusNotFound},
+		{"POST", "/workers/9/kill", http.StatusNotFound},
+		{"GET", "/workers/first/dump", http.StatusBadRequest},
+	} {
+		if rec := do(s, tc.method, tc.path); rec.Code != tc.code {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, rec.Code, tc.code)
+		}
+	}
+	if rec := do(newServer(&stubPool{workers: 1}), "POST", "/workers/1/kill"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("pool without inspection: status %d, want 501", rec.Code)
+	}
+
+	// Both go through the audit log, the failed ones too.
+	records := s.audit.Recent(0)
+	if len(records) != 5 {
+		t.Fatalf("records = %+v, want 5", records)
+	}
+	if r := records[4]; r.Action != audit.ActionDumpWorker || r.Result != audit.ResultOK || r.Params["worker_id"] != 1 || r.Params["state"] != "busy" {
+		t.Errorf("dump record = %+v", r)
+	}
+	if r := records[3]; r.Action != audit.ActionKillWorker || r.Result != audit.ResultOK || r.Params["worker_id"] != 1 {
+		t.Errorf("kill record = %+v", r)
+	}
+	if r := records[1]; r.Action != audit.ActionKillWorker || r.Result != audit.ResultError {
+		t.Errorf("failed kill record = %+v", r)
+	}
+}
+
+func TestAdminWebSocketPublish(t *testing.T) {
+	cfg := config.Default()
+	cfg.Admin.Enabled = true
+	cfg.Admin.Token = "s3cret"
+	cfg.WebSocket.PublishToken = "pub"
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	publish := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/internal/ws/publish", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := publish("pub", `{"room": "news", "data": "hi"}`); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without websocket: status %d, want 501", rec.Code)
+	}
+	m := websocket.NewManager(s.logger)
+	s.SetWebSocket(m)
+
+	for _, token := range []string{"pub", "s3cret"} {
+		rec := publish(token, `{"room": "news", "event": "order.shipped", "data": {"id": 7}}`)
+		var d websocket.Delivery
+		if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&d) != nil {
+			t.Fatalf("token %s: status %d, body %q", token, rec.Code, rec.Body)
+		}
+		if d != (websocket.Delivery{}) {
+			t.Errorf("delivery to an empty room = %+v", d)
+		}
+	}
+	if rec := publish("wrong", `{"room": "news", "data": "hi"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want 401", rec.Code)
+	}
+	if rec, _ := getStatus(t, s, "pub"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("publish token on /status: status %d, want 401", rec.Code)
+	}
+
+	for _, body := range []string{`{"data": "hi"}`, `{"room": "a", "user_id": "1", "data": "hi"}`, `not json`} {
+		if rec := publish("pub", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+
+	m.SetPublishRate(0.001, 1)
+	publish("pub", `{"room": "news", "data": "hi"}`)
+	rec := publish("pub", `{"room": "news", "data": "hi"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("over the publish rate: status %d, headers %v; want 429 with Retry-After", rec.Code, rec.Header())
+	}
+}
+
+func TestAdminWebSocketPresence(t *testing.T) {
+	cfg := config.Default()
+	cfg.Admin.Enabled = true
+	cfg.WebSocket.PublishToken = "pub"
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.SetWebSocket(websocket.NewManager(s.logger))
+	presence := func(token, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/internal/ws/presence"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := presence("pub", "?room=lobby")
+	var p websocket.Presence
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&p) != nil {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+	if p.Room != "lobby" || p.Connections != 0 || len(p.Users) != 0 {
+		t.Errorf("presence of an empty room = %+v", p)
+	}
+	if rec := presence("pub", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("without room: status %d, want 400", rec.Code)
+	}
+	if rec := presence("wrong", "?room=lobby"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want 401", rec.Code)
+	}
+}
+
+func TestAdminWebSocketRooms(t *testing.T) {
+	s := newAdminTestServer(t, 1)
+	rooms := func(token, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/websocket/rooms"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := rooms("s3cret", ""); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without websocket: status %d, want 501", rec.Code)
+	}
+	s.SetWebSocket(websocket.NewManager(s.logger))
+
+	rec := rooms("s3cret", "?pattern=game:**")
+	var body WebSocketRooms
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&body) != nil {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+	if body.Rooms == nil || len(body.Rooms) != 0 {
+		t.Errorf("rooms = %#v, want an empty list", body.Rooms)
+	}
+	if rec := rooms("s3cret", "?pattern=game:["); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad pattern: status %d, want 400", rec.Code)
+	}
+	if rec := rooms("wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want 401", rec.Code)
+	}
+}
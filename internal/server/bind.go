@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// BindError is returned by Bind when a listener cannot be bound, most
+// often because the address is taken or the port is privileged.
+type BindError struct {
+	Listener string // admin, metrics, http, redirect or http3
+	Network  string // tcp, udp or unix
+	Addr     string
+	Err      error
+}
+
+func (e *BindError) Error() string {
+	return fmt.Sprintf("binding %s listener on %s: %v", e.Listener, e.Addr, e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// Hint suggests what to do about the error, naming the process holding
+// the address when it can be found; "" when there is nothing to add.
+func (e *BindError) Hint() string {
+	switch {
+	case e.Network == "unix":
+		if errors.Is(e.Err, syscall.EACCES) {
+			return "the socket's directory must be writable by the maboo user"
+		}
+	case errors.Is(e.Err, syscall.EADDRINUSE):
+		if pid := portOwner(e.Network, e.Addr); pid > 0 {
+			return fmt.Sprintf("address already in use by pid %d", pid)
+		}
+		return "address already in use by another process"
+	case errors.Is(e.Err, syscall.EACCES):
+		return "ports below 1024 need root or CAP_NET_BIND_SERVICE, e.g. setcap cap_net_bind_service=+ep on the maboo binary"
+	}
+	return ""
+}
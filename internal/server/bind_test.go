@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+// freeAddr returns a loopback address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestBindAddressInUse(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	cfg := config.Default()
+	cfg.Server.Address = taken.Addr().String()
+	cfg.Admin.Enabled = true
+	cfg.Admin.Address = freeAddr(t)
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	err = s.Bind()
+	var bindErr *BindError
+	if !errors.As(err, &bindErr) {
+		t.Fatalf("Bind = %v, want a *BindError", err)
+	}
+	if bindErr.Listener != "http" || bindErr.Addr != cfg.Server.Address || !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("Bind = %#v", bindErr)
+	}
+	want := "address already in use by another process"
+	if runtime.GOOS == "linux" {
+		want = fmt.Sprintf("address already in use by pid %d", os.Getpid())
+	}
+	if hint := bindErr.Hint(); hint != want {
+		t.Errorf("Hint = %q, want %q", hint, want)
+	}
+
+	select {
+	case <-s.Listening():
+		t.Error("Listening closed after a failed Bind")
+	default:
+	}
+	// The admin listener bound first was closed again.
+	ln, err := net.Listen("tcp", cfg.Admin.Address)
+	if err != nil {
+		t.Fatalf("admin address still bound: %v", err)
+	}
+	ln.Close()
+}
+
+func TestBindPermissionDenied(t *testing.T) {
+	hint := (&BindError{Err: &net.OpError{Op: "listen", Err: os.NewSyscallError("bind", syscall.EACCES)}}).Hint()
+	if !strings.Contains(hint, "CAP_NET_BIND_SERVICE") {
+		t.Errorf("Hint = %q", hint)
+	}
+
+	// A real privileged port, where this process may not bind one.
+	if ln, err := net.Listen("tcp", "127.0.0.1:1"); err == nil {
+		ln.Close()
+		t.Skip("this process may bind privileged ports")
+	}
+	cfg := config.Default()
+	cfg.Server.Address = "127.0.0.1:1"
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	err := s.Bind()
+	var bindErr *BindError
+	if !errors.As(err, &bindErr) || !errors.Is(err, syscall.EACCES) {
+		t.Fatalf("Bind = %v, want a *BindError for EACCES", err)
+	}
+	if !strings.Contains(bindErr.Hint(), "CAP_NET_BIND_SERVICE") {
+		t.Errorf("Hint = %q", bindErr.Hint())
+	}
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/clientip"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/route"
+)
+
+var (
+	cacheRequests = metrics.NewCounterVec("maboo_http_cache_requests_total",
+		"Requests to cached routes, by what the response cache did (hit, miss, bypass).", "result")
+	cacheBytes = metrics.NewGauge("maboo_http_cache_bytes",
+		"Memory held by cached PHP responses.")
+	cacheEntries = metrics.NewGauge("maboo_http_cache_entries",
+		"PHP responses in the response cache.")
+)
+
+// What the response cache did with a request, used as metric label
+// values and, upper-cased, in the X-Maboo-Cache header.
+const (
+	cacheHit    = "hit"    // answered from the cache
+	cacheMiss   = "miss"   // answered by PHP, and the response stored
+	cacheBypass = "bypass" // answered by PHP, and the request or response not cacheable
+)
+
+// cacheHeader tells clients what the cache did with their request.
+const cacheHeader = "X-Maboo-Cache"
+
+// cacheEntryOverhead approximates the memory an entry takes besides its
+// key, path, headers and body.
+const cacheEntryOverhead = 256
+
+// cachePolicy decides which requests the response cache answers and what
+// it keys them on, from the cache section of the config.
+type cachePolicy struct {
+	routes        *route.Matcher
+	ttl           time.Duration
+	varyHeaders   []string
+	varyCookies   []string
+	bypassCookies []string
+}
+
+// newCachePolicy compiles cfg; it returns nil when the cache is disabled
+// or has no routes. Validate has checked the templates, so routes that
+// fail to compile disable the cache as well.
+func newCachePolicy(cfg config.CacheConfig) *cachePolicy {
+	if !cfg.Enabled || len(cfg.Routes) == 0 {
+		return nil
+	}
+	m, err := route.New(cfg.Routes)
+	if err != nil {
+		return nil
+	}
+	return &cachePolicy{
+		routes:        m,
+		ttl:           cfg.TTL.Duration(),
+		varyHeaders:   cfg.VaryHeaders,
+		varyCookies:   cfg.VaryCookies,
+		bypassCookies: cfg.BypassCookies,
+	}
+}
+
+// key returns the key req is cached under. Requests to cached routes that
+// must not be answered from the cache, because they are not GETs, carry
+// credentials or a bypass cookie, get "" and true; requests to other
+// routes get "" and false. The scheme and host are those clients resolves
+// for req, which PHP sees.
+func (p *cachePolicy) key(req *http.Request, clients *clientip.Resolver) (key string, bypass bool) {
+	if p == nil || p.routes.Match(clientPath(req)) < 0 {
+		return "", false
+	}
+	if req.Method != http.MethodGet || req.Header.Get("Authorization") != "" {
+		return "", true
+	}
+	cookies := req.Cookies()
+	for _, c := range cookies {
+		if hasAnyPrefix(c.Name, p.bypassCookies) {
+			return "", true
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(origin(clients, req))
+	b.WriteString(req.URL.RequestURI())
+	if o := rewrittenFrom(req); o != nil {
+		// PHP sees the client's URI too, so it may answer differently.
+		b.WriteByte(0)
+		b.WriteString(o.path)
+	}
+	for _, h := range p.varyHeaders {
+		b.WriteByte(0)
+		b.WriteString(req.Header.Get(h))
+	}
+	for _, prefix := range p.varyCookies {
+		present := false
+		for _, c := range cookies {
+			if strings.HasPrefix(c.Name, prefix) {
+				present = true
+				break
+			}
+		}
+		b.WriteByte(0)
+		b.WriteString(strconv.FormatBool(present))
+	}
+	return b.String(), false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheable reports whether resp may be shared with other clients: a 200
+// that sets no cookie, and that PHP did not mark private, no-store or
+// no-cache.
+func cacheable(resp *phpengine.Response) bool {
+	if resp.Status != http.StatusOK {
+		return false
+	}
+	for k, v := range resp.Headers {
+		switch {
+		case strings.EqualFold(k, "Set-Cookie"):
+			return false
+		case strings.EqualFold(k, "Cache-Control"):
+			for _, directive := range strings.Split(v, ",") {
+				name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+				switch strings.ToLower(name) {
+				case "private", "no-store", "no-cache":
+					return false
+				}
+			}
+		case strings.EqualFold(k, "Vary") && strings.TrimSpace(v) == "*":
+			return false
+		}
+	}
+	return true
+}
+
+// responseCache holds PHP responses in memory, up to a number of bytes,
+// evicting the least recently used first.
+type responseCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List // of *cacheEntry, most recently used first
+	entries  map[string]*list.Element
+	filling  map[string]*cacheFill
+}
+
+type cacheEntry struct {
+	key     string
+	path    string
+	resp    *phpengine.Response
+	stored  time.Time
+	expires time.Time
+	size    int64
+}
+
+// cacheFill is a miss PHP is serving. Requests for the same key wait for
+// it rather than run PHP again.
+type cacheFill struct {
+	done  chan struct{}
+	entry *cacheEntry // set before done is closed; nil if nothing was stored
+}
+
+func newResponseCache(maxBytes int64) *responseCache {
+	return &responseCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		filling:  make(map[string]*cacheFill),
+	}
+}
+
+func newCacheEntry(key, path string, resp *phpengine.Response, ttl time.Duration) *cacheEntry {
+	size := int64(len(key) + len(path) + len(resp.Body) + cacheEntryOverhead)
+	for k, v := range resp.Headers {
+		size += int64(len(k) + len(v))
+	}
+	now := time.Now()
+	return &cacheEntry{key: key, path: path, resp: resp, stored: now, expires: now.Add(ttl), size: size}
+}
+
+// lookup returns the fresh entry for key. Without one, the first caller
+// gets a fill to complete with finish (leader is true); callers arriving
+// before it finishes get the same fill to wait for.
+func (c *responseCache) lookup(key string) (e *cacheEntry, fill *cacheFill, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if time.Now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			return e, nil, false
+		}
+		c.remove(el)
+	}
+	if fill, ok := c.filling[key]; ok {
+		return nil, fill, false
+	}
+	fill = &cacheFill{done: make(chan struct{})}
+	c.filling[key] = fill
+	return nil, fill, true
+}
+
+// finish completes fill, storing e unless it is nil, and wakes the
+// requests waiting for it.
+func (c *responseCache) finish(key string, fill *cacheFill, e *cacheEntry) {
+	c.mu.Lock()
+	delete(c.filling, key)
+	if e != nil && e.size <= c.maxBytes {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+		c.entries[key] = c.lru.PushFront(e)
+		c.bytes += e.size
+		c.evict()
+	} else {
+		e = nil
+	}
+	c.updateGauges()
+	c.mu.Unlock()
+	fill.entry = e
+	close(fill.done)
+}
+
+// SetMaxBytes changes how much the cache may hold, evicting entries to
+// fit; 0 empties it.
+func (c *responseCache) SetMaxBytes(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBytes = n
+	c.evict()
+	c.updateGauges()
+}
+
+// Purge drops the entries whose path matches the route template tpl, e.g.
+// "/blog/*" or an exact path, and returns how many it dropped.
+func (c *responseCache) Purge(tpl string) (int, error) {
+	m, err := route.New([]string{tpl})
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if m.Match(el.Value.(*cacheEntry).path) >= 0 {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+	c.updateGauges()
+	return n, nil
+}
+
+// Len returns how many responses are cached.
+func (c *responseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *responseCache) evict() {
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.bytes -= e.size
+}
+
+func (c *responseCache) updateGauges() {
+	cacheBytes.Set(float64(c.bytes))
+	cacheEntries.Set(float64(c.lru.Len()))
+}
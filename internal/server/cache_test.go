@@ -0,0 +1,236 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// pagePool answers every request with a page naming its path and counts
+// how often PHP ran. Paths in headers get those response headers.
+type pagePool struct {
+	stubPool
+	delay   time.Duration
+	headers map[string]map[string]string
+	runs    atomic.Int64
+}
+
+func (p *pagePool) Exec(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+	p.runs.Add(1)
+	time.Sleep(p.delay)
+	path := ctx.Server["REQUEST_URI"]
+	headers := map[string]string{"Content-Type": "text/html"}
+	for k, v := range p.headers[path] {
+		headers[k] = v
+	}
+	return &phpengine.Response{Status: http.StatusOK, Headers: headers, Body: []byte("page " + path)}, nil
+}
+
+func newCacheRouter(t *testing.T, pool *pagePool) *Router {
+	t.Helper()
+	cfg := appConfig(t)
+	cfg.Cache.Enabled = true
+	cfg.Cache.Routes = []string{"/", "/blog/*"}
+	cfg.Cache.VaryCookies = []string{"consent"}
+	cfg.Cache.BypassCookies = []string{"wordpress_logged_in_"}
+	return NewRouter(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// cacheGet sends req through r and returns the response's X-Maboo-Cache
+// header and body.
+func cacheGet(r *Router, req *http.Request) (string, string) {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec.Header().Get(cacheHeader), rec.Body.String()
+}
+
+func TestResponseCache(t *testing.T) {
+	pool := &pagePool{headers: map[string]map[string]string{
+		"/blog/session": {"Set-Cookie": "sid=1"},
+		"/blog/account": {"Cache-Control": "no-cache, private"},
+	}}
+	r := newCacheRouter(t, pool)
+	get := func(path string) *http.Request { return httptest.NewRequest("GET", path, nil) }
+	withCookie := func(req *http.Request, name string) *http.Request {
+		req.AddCookie(&http.Cookie{Name: name, Value: "1"})
+		return req
+	}
+
+	tests := []struct {
+		name  string
+		req   *http.Request
+		cache string
+		runs  int64 // PHP runs so far
+	}{
+		{"first view", get("/blog/hello"), "MISS", 1},
+		{"second view", get("/blog/hello"), "HIT", 1},
+		{"other query", get("/blog/hello?page=2"), "MISS", 2},
+		{"vary cookie", withCookie(get("/blog/hello"), "consent_given"), "MISS", 3},
+		{"vary cookie again", withCookie(get("/blog/hello"), "consent_given"), "HIT", 3},
+		{"unrelated cookie", withCookie(get("/blog/hello"), "theme"), "HIT", 3},
+		{"logged in", withCookie(get("/blog/hello"), "wordpress_logged_in_abc"), "BYPASS", 4},
+		{"post", httptest.NewRequest("POST", "/blog/hello", nil), "BYPASS", 5},
+		{"set-cookie", get("/blog/session"), "BYPASS", 6},
+		{"set-cookie again", get("/blog/session"), "BYPASS", 7},
+		{"private", get("/blog/account"), "BYPASS", 8},
+		{"not a cached route", get("/shop"), "", 9},
+	}
+	for _, tt := range tests {
+		cache, body := cacheGet(r, tt.req)
+		if cache != tt.cache {
+			t.Errorf("%s: %s = %q, want %q", tt.name, cacheHeader, cache, tt.cache)
+		}
+		if want := "page " + tt.req.URL.Path; body != want {
+			t.Errorf("%s: body %q, want %q", tt.name, body, want)
+		}
+		if got := pool.runs.Load(); got != tt.runs {
+			t.Errorf("%s: PHP ran %d times, want %d", tt.name, got, tt.runs)
+		}
+	}
+}
+
+// PHP sees the host and scheme a trusted proxy forwards, so they key the
+// cache rather than the proxy's own request line.
+func TestResponseCacheKeysForwardedHost(t *testing.T) {
+	pool := &pagePool{}
+	r := newCacheRouter(t, pool)
+	next := *r.cfg.Load()
+	next.Server.TrustedProxies = []string{"192.0.2.1"} // httptest's RemoteAddr
+	r.SetConfig(&next)
+	forwarded := func(host, proto string) *http.Request {
+		req := httptest.NewRequest("GET", "http://maboo.internal/blog/hello", nil)
+		req.Header.Set("X-Forwarded-Host", host)
+		req.Header.Set("X-Forwarded-Proto", proto)
+		return req
+	}
+
+	tests := []struct {
+		name  string
+		req   *http.Request
+		cache string
+	}{
+		{"first host", forwarded("a.example", "http"), "MISS"},
+		{"first host again", forwarded("a.example", "http"), "HIT"},
+		{"second host", forwarded("b.example", "http"), "MISS"},
+		{"https", forwarded("a.example", "https"), "MISS"},
+		{"https again", forwarded("a.example", "https"), "HIT"},
+	}
+	for _, tt := range tests {
+		if cache, _ := cacheGet(r, tt.req); cache != tt.cache {
+			t.Errorf("%s: %s = %q, want %q", tt.name, cacheHeader, cache, tt.cache)
+		}
+	}
+}
+
+func TestResponseCacheCollapsesMisses(t *testing.T) {
+	pool := &pagePool{delay: 100 * time.Millisecond}
+	r := newCacheRouter(t, pool)
+
+	const requests = 10
+	results := make([]string, requests)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = cacheGet(r, httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+	wg.Wait()
+	if n := pool.runs.Load(); n != 1 {
+		t.Errorf("PHP ran %d times for %d concurrent misses, want 1", n, requests)
+	}
+	misses := 0
+	for _, res := range results {
+		if res == "MISS" {
+			misses++
+		} else if res != "HIT" {
+			t.Errorf("%s = %q, want HIT or MISS", cacheHeader, res)
+		}
+	}
+	if misses != 1 {
+		t.Errorf("%d misses, want 1", misses)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	c := newResponseCache(0)
+	resp := &phpengine.Response{Status: http.StatusOK, Body: make([]byte, 1000)}
+	put := func(path string) {
+		_, fill, leader := c.lookup(path)
+		if !leader {
+			t.Fatalf("%s: not the first lookup", path)
+		}
+		c.finish(path, fill, newCacheEntry(path, path, resp, time.Minute))
+	}
+	cached := func(path string) bool {
+		e, fill, _ := c.lookup(path)
+		if e == nil {
+			c.finish(path, fill, nil)
+		}
+		return e != nil
+	}
+
+	size := newCacheEntry("/a", "/a", resp, 0).size
+	c.SetMaxBytes(3 * size)
+	put("/a")
+	put("/b")
+	put("/c")
+	cached("/a") // now more recently used than /b
+	put("/d")
+	for path, want := range map[string]bool{"/a": true, "/b": false, "/c": true, "/d": true} {
+		if got := cached(path); got != want {
+			t.Errorf("%s cached = %v, want %v", path, got, want)
+		}
+	}
+	c.SetMaxBytes(0)
+	if c.Len() != 0 {
+		t.Errorf("%d entries after emptying the cache", c.Len())
+	}
+}
+
+func TestAdminCachePurge(t *testing.T) {
+	s := newAdminTestServer(t, 1)
+	c := s.router.cache
+	c.SetMaxBytes(1 << 20)
+	for _, path := range []string{"/", "/blog/a", "/blog/b", "/shop"} {
+		_, fill, _ := c.lookup(path)
+		c.finish(path, fill, newCacheEntry(path, path, &phpengine.Response{Status: http.StatusOK}, time.Minute))
+	}
+	purge := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/cache/purge", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tt := range []struct {
+		path   string
+		purged string
+		left   int
+	}{
+		{"/blog/*", `{"purged":2}`, 2},
+		{"/shop", `{"purged":1}`, 1},
+		{"/*", `{"purged":1}`, 0},
+	} {
+		rec := purge(`{"path": "` + tt.path + `"}`)
+		if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != tt.purged {
+			t.Errorf("purge %s: status %d, body %q, want %s", tt.path, rec.Code, rec.Body, tt.purged)
+		}
+		if c.Len() != tt.left {
+			t.Errorf("purge %s: %d entries left, want %d", tt.path, c.Len(), tt.left)
+		}
+	}
+	if rec := purge(`{"path": "blog"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("purge of an invalid template: status %d, want 400", rec.Code)
+	}
+}
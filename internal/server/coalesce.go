@@ -0,0 +1,178 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/clientip"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/route"
+)
+
+var (
+	coalescedRequests = metrics.NewCounterVec("maboo_http_coalesced_requests_total",
+		"Requests to coalesced routes that found another identical request running PHP, by outcome (shared, timeout, unshared).", "result")
+	coalesceSaved = metrics.NewCounter("maboo_http_coalesce_executions_saved_total",
+		"PHP executions saved by answering requests with a copy of another's response.")
+)
+
+// What became of a request that waited for another's response, used as
+// metric label values.
+const (
+	coalesceShared   = "shared"   // answered with a copy of the response
+	coalesceTimeout  = "timeout"  // coalesce.max_wait passed first, and it ran PHP itself
+	coalesceUnshared = "unshared" // the response could not be shared, or there was none, and it ran PHP itself
+)
+
+// coalescePolicy decides which requests may share a PHP execution, and
+// what makes two of them identical, from the coalesce section of the
+// config.
+type coalescePolicy struct {
+	routes      *route.Matcher
+	varyHeaders []string
+	maxWait     time.Duration
+}
+
+// newCoalescePolicy compiles cfg; it returns nil when coalescing is
+// disabled or has no routes.
+func newCoalescePolicy(cfg config.CoalesceConfig) *coalescePolicy {
+	if !cfg.Enabled || len(cfg.Routes) == 0 {
+		return nil
+	}
+	m, err := route.New(cfg.Routes)
+	if err != nil {
+		return nil
+	}
+	return &coalescePolicy{routes: m, varyHeaders: cfg.VaryHeaders, maxWait: cfg.MaxWait.Duration()}
+}
+
+// key returns the key under which req may share a PHP execution, or ""
+// when it may not: it is not a GET or HEAD to a coalesced route, or it
+// carries cookies, credentials or a debug statistics request, any of
+// which may make PHP answer it differently. The scheme and host are those
+// clients resolves for req, which PHP sees.
+func (p *coalescePolicy) key(req *http.Request, clients *clientip.Resolver) string {
+	if p == nil || p.routes.Match(clientPath(req)) < 0 {
+		return ""
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return ""
+	}
+	for _, h := range []string{"Cookie", "Authorization", debugHeader} {
+		if req.Header.Get(h) != "" {
+			return ""
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(origin(clients, req))
+	b.WriteString(req.URL.RequestURI())
+	if o := rewrittenFrom(req); o != nil {
+		b.WriteByte(0)
+		b.WriteString(o.path)
+	}
+	for _, h := range p.varyHeaders {
+		b.WriteByte(0)
+		b.WriteString(req.Header.Get(h))
+	}
+	return b.String()
+}
+
+// shareable reports whether resp, made for one anonymous client, may be
+// given to another: it sets no cookie, and PHP did not mark it private.
+func shareable(resp *phpengine.Response) bool {
+	for k, v := range resp.Headers {
+		switch {
+		case strings.EqualFold(k, "Set-Cookie"):
+			return false
+		case strings.EqualFold(k, "Cache-Control"):
+			for _, directive := range strings.Split(v, ",") {
+				name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+				if strings.EqualFold(name, "private") {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// coalescer tracks the PHP executions identical requests may share.
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall is a request running PHP, which identical requests
+// arriving meanwhile wait for.
+type coalescedCall struct {
+	done chan struct{}
+	resp *phpengine.Response // set before done is closed; nil if it cannot be shared
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{calls: make(map[string]*coalescedCall)}
+}
+
+// join returns the call running for key. The first caller starts it and
+// must complete it with finish (leader is true); callers arriving before
+// then get the same call to wait for.
+func (c *coalescer) join(key string) (call *coalescedCall, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[key]; ok {
+		return call, false
+	}
+	call = &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	return call, true
+}
+
+// finish completes call with resp, unless it is nil or not shareable, and
+// wakes the requests waiting for it.
+func (c *coalescer) finish(key string, call *coalescedCall, resp *phpengine.Response) {
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	if resp != nil && shareable(resp) {
+		call.resp = resp
+	}
+	close(call.done)
+}
+
+// serveCoalesced answers a request to a coalesced route. The first of
+// several identical requests runs PHP; the others wait up to
+// coalesce.max_wait for its response and are answered with a copy, or
+// else run PHP themselves.
+func (r *Router) serveCoalesced(w http.ResponseWriter, req *http.Request, cfg *config.Config, policy *coalescePolicy, key string) *phpengine.Response {
+	call, leader := r.coalescer.join(key)
+	if leader {
+		var resp *phpengine.Response
+		defer func() { r.coalescer.finish(key, call, resp) }()
+		resp = r.execPHP(w, req, cfg)
+		return resp
+	}
+
+	timer := time.NewTimer(policy.maxWait)
+	defer timer.Stop()
+	select {
+	case <-call.done:
+		if call.resp != nil {
+			coalescedRequests.WithLabelValues(coalesceShared).Inc()
+			coalesceSaved.Inc()
+			return call.resp
+		}
+		coalescedRequests.WithLabelValues(coalesceUnshared).Inc()
+	case <-timer.C:
+		coalescedRequests.WithLabelValues(coalesceTimeout).Inc()
+	case <-req.Context().Done():
+		return nil
+	}
+	return r.execPHP(w, req, cfg)
+}
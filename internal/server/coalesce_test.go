@@ -0,0 +1,141 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func newCoalesceRouter(t *testing.T, pool *pagePool, maxWait time.Duration) *Router {
+	t.Helper()
+	cfg := appConfig(t)
+	cfg.Coalesce.Enabled = true
+	cfg.Coalesce.Routes = []string{"/", "/blog/*"}
+	cfg.Coalesce.MaxWait = config.Duration(maxWait)
+	return NewRouter(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// getConcurrently sends the requests through r at once and returns the
+// response bodies.
+func getConcurrently(r *Router, reqs []*http.Request) []string {
+	bodies := make([]string, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			bodies[i] = rec.Body.String()
+		}()
+	}
+	wg.Wait()
+	return bodies
+}
+
+func TestCoalesce(t *testing.T) {
+	pool := &pagePool{delay: 100 * time.Millisecond}
+	r := newCoalesceRouter(t, pool, 5*time.Second)
+
+	// Anonymous requests share one execution; those with cookies or
+	// credentials, and those to other paths or routes, run their own.
+	var reqs []*http.Request
+	for range 10 {
+		reqs = append(reqs, httptest.NewRequest("GET", "/blog/hello", nil))
+	}
+	for i := range 4 {
+		req := httptest.NewRequest("GET", "/blog/hello", nil)
+		if i%2 == 0 {
+			req.AddCookie(&http.Cookie{Name: "wordpress_logged_in_abc", Value: "1"})
+		} else {
+			req.Header.Set("Authorization", "Bearer t")
+		}
+		reqs = append(reqs, req)
+	}
+	reqs = append(reqs,
+		httptest.NewRequest("GET", "/blog/other", nil),
+		httptest.NewRequest("GET", "/shop", nil),
+		httptest.NewRequest("GET", "/shop", nil),
+		httptest.NewRequest("POST", "/blog/hello", nil),
+	)
+
+	bodies := getConcurrently(r, reqs)
+	for i, body := range bodies {
+		if want := "page " + reqs[i].URL.Path; body != want {
+			t.Errorf("%s %s: body %q, want %q", reqs[i].Method, reqs[i].URL, body, want)
+		}
+	}
+	// One for the anonymous GETs, 4 with cookies or credentials, 1 for
+	// the other post, 2 outside the routes and 1 POST.
+	if n := pool.runs.Load(); n != 9 {
+		t.Errorf("PHP ran %d times, want 9", n)
+	}
+}
+
+// Requests a trusted proxy forwards for different hosts or schemes reach
+// PHP as different requests, so they do not share an execution.
+func TestCoalesceForwardedHost(t *testing.T) {
+	pool := &pagePool{delay: 100 * time.Millisecond}
+	r := newCoalesceRouter(t, pool, 5*time.Second)
+	next := *r.cfg.Load()
+	next.Server.TrustedProxies = []string{"192.0.2.1"} // httptest's RemoteAddr
+	r.SetConfig(&next)
+
+	var reqs []*http.Request
+	for _, fwd := range [][2]string{{"a.example", "http"}, {"b.example", "http"}, {"a.example", "https"}} {
+		for range 3 {
+			req := httptest.NewRequest("GET", "http://maboo.internal/blog/hello", nil)
+			req.Header.Set("X-Forwarded-Host", fwd[0])
+			req.Header.Set("X-Forwarded-Proto", fwd[1])
+			reqs = append(reqs, req)
+		}
+	}
+	getConcurrently(r, reqs)
+	if n := pool.runs.Load(); n != 3 {
+		t.Errorf("PHP ran %d times, want once for each host and scheme", n)
+	}
+}
+
+func TestCoalesceUnshareable(t *testing.T) {
+	pool := &pagePool{delay: 100 * time.Millisecond, headers: map[string]map[string]string{
+		"/blog/session": {"Set-Cookie": "sid=1"},
+		"/blog/account": {"Cache-Control": "private"},
+	}}
+	r := newCoalesceRouter(t, pool, 5*time.Second)
+
+	for _, path := range []string{"/blog/session", "/blog/account"} {
+		pool.runs.Store(0)
+		reqs := make([]*http.Request, 5)
+		for i := range reqs {
+			reqs[i] = httptest.NewRequest("GET", path, nil)
+		}
+		getConcurrently(r, reqs)
+		if n := pool.runs.Load(); n != 5 {
+			t.Errorf("%s: PHP ran %d times, want once for each request", path, n)
+		}
+	}
+}
+
+func TestCoalesceMaxWait(t *testing.T) {
+	pool := &pagePool{delay: 200 * time.Millisecond}
+	r := newCoalesceRouter(t, pool, 10*time.Millisecond)
+
+	reqs := make([]*http.Request, 5)
+	for i := range reqs {
+		reqs[i] = httptest.NewRequest("GET", "/", nil)
+	}
+	start := time.Now()
+	getConcurrently(r, reqs)
+	if n := pool.runs.Load(); n != 5 {
+		t.Errorf("PHP ran %d times, want once for each request that stopped waiting", n)
+	}
+	if d := time.Since(start); d > 350*time.Millisecond {
+		t.Errorf("requests took %s: followers waited for the slow leader", d)
+	}
+}
@@ -2,20 +2,60 @@ package server
 
 import (
 	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"maps"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/route"
 )
 
-// Pool for gzip.Writer - fixes #1 (813KB/op → ~2KB/op)
-var gzWriterPool = sync.Pool{
-	New: func() interface{} {
-		// Use BestSpeed for lower latency (fix #10)
-		// Compression ratio is only ~5-10% worse than DefaultCompression
-		// but throughput doubles
-		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
-		return w
-	},
+// Content codings the compression middleware can produce.
+const (
+	codingGzip    = "gzip"
+	codingDeflate = "deflate" // zlib (RFC 1950) framing, as RFC 9110 defines it
+)
+
+// encoder is a gzip or zlib writer.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// Pools of idle encoders by coding and level - fixes #1 (813KB/op → ~2KB/op)
+var gzipPools, deflatePools [10]sync.Pool
+
+// getEncoder returns an encoder for coding at level, writing to w.
+func getEncoder(coding string, level int, w io.Writer) encoder {
+	pools := &gzipPools
+	if coding == codingDeflate {
+		pools = &deflatePools
+	}
+	if e, ok := pools[level].Get().(encoder); ok {
+		e.Reset(w)
+		return e
+	}
+	if coding == codingDeflate {
+		e, _ := zlib.NewWriterLevel(w, level)
+		return e
+	}
+	e, _ := gzip.NewWriterLevel(w, level)
+	return e
+}
+
+// putEncoder returns e, closed, to the pool of coding and level.
+func putEncoder(coding string, level int, e encoder) {
+	if coding == codingDeflate {
+		deflatePools[level].Put(e)
+	} else {
+		gzipPools[level].Put(e)
+	}
 }
 
 // Pool for compressWriter structs - fixes #8
@@ -25,20 +65,122 @@ var compressWriterPool = sync.Pool{
 	},
 }
 
-const compressMinSize = 1024
+// compressionPolicy is the compression section compiled: what is
+// compressed, how, and the routes that differ.
+type compressionPolicy struct {
+	algorithms   []string
+	minSize      int64
+	level        int
+	contentTypes []string
+	matcher      *route.Matcher // nil without routes
+	routes       []config.CompressionRoute
+}
+
+// newCompressionPolicy compiles cfg; it returns nil when compression is
+// off. Validate has checked the templates, so routes that fail to
+// compile leave every request on the section's own settings.
+func newCompressionPolicy(cfg config.CompressionConfig) *compressionPolicy {
+	if !cfg.Enabled {
+		return nil
+	}
+	p := &compressionPolicy{
+		algorithms:   cfg.Algorithms,
+		minSize:      cfg.MinSize.Bytes(),
+		level:        cfg.Level,
+		contentTypes: cfg.ContentTypes,
+	}
+	templates := slices.Sorted(maps.Keys(cfg.Routes))
+	if m, err := route.New(templates); err == nil && len(templates) > 0 {
+		p.matcher = m
+		for _, tpl := range templates {
+			p.routes = append(p.routes, cfg.Routes[tpl])
+		}
+	}
+	return p
+}
+
+// lookup returns the level responses to path are compressed at, or 0
+// when its route disables compression.
+func (p *compressionPolicy) lookup(path string) int {
+	if p.matcher == nil {
+		return p.level
+	}
+	i := p.matcher.Match(path)
+	switch {
+	case i < 0:
+		return p.level
+	case p.routes[i].Disable:
+		return 0
+	case p.routes[i].Level > 0:
+		return p.routes[i].Level
+	}
+	return p.level
+}
+
+// compressible reports whether ct is one of the content types the policy
+// compresses: an entry ending in "/" covers every subtype, and one
+// starting with "+" every type with that structured syntax suffix. Types
+// that are compressed already never are, whatever the list says.
+func (p *compressionPolicy) compressible(ct string) bool {
+	mt, _, _ := strings.Cut(ct, ";")
+	mt = strings.TrimSpace(mt)
+	if mt == "" || isIncompressibleContentType(mt) {
+		return false
+	}
+	for _, t := range p.contentTypes {
+		switch {
+		case strings.HasSuffix(t, "/"):
+			if hasPrefixFold(mt, t) {
+				return true
+			}
+		case strings.HasPrefix(t, "+"):
+			if len(mt) > len(t) && strings.EqualFold(mt[len(mt)-len(t):], t) {
+				return true
+			}
+		case strings.EqualFold(mt, t):
+			return true
+		}
+	}
+	return false
+}
+
+// CompressionMiddleware compresses eligible responses as cfg says.
+func CompressionMiddleware(cfg config.CompressionConfig) func(http.Handler) http.Handler {
+	p := newCompressionPolicy(cfg)
+	return compression(func() *compressionPolicy { return p })
+}
 
-// CompressionMiddleware applies gzip compression to eligible responses.
-func CompressionMiddleware() func(http.Handler) http.Handler {
+// compression is CompressionMiddleware with the policy loaded for each
+// request, so that a reloaded compression section applies at once. The
+// route is resolved once, before the handler runs.
+func compression(policy func() *compressionPolicy) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Fast path: skip if client doesn't accept gzip
-			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			p := policy()
+			// An upgrade hijacks the connection and has no body to compress.
+			if p == nil || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// A route with compression off is sent as the handler wrote it.
+			level := p.lookup(r.URL.Path)
+			if level == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Fast path: skip if the client accepts none of the codings.
+			// The response still varies on Accept-Encoding for shared
+			// caches.
+			coding := negotiateEncoding(r.Header.Get("Accept-Encoding"), p.algorithms)
+			if coding == "" {
+				addVary(w.Header())
 				next.ServeHTTP(w, r)
 				return
 			}
 
 			cw := compressWriterPool.Get().(*compressWriter)
-			cw.reset(w)
+			cw.reset(w, p, coding, level)
 			defer func() {
 				cw.Close()
 				compressWriterPool.Put(cw)
@@ -49,22 +191,52 @@ func CompressionMiddleware() func(http.Handler) http.Handler {
 	}
 }
 
+// isUpgrade reports whether r asks to switch protocols, e.g. to WebSocket.
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compressState tracks where a compressWriter is in its lifecycle. Every
+// response starts buffering and commits exactly once to either passthrough
+// or compressing; the status line is sent at that commit.
+type compressState uint8
+
+const (
+	stateBuffering   compressState = iota // collecting bytes until the compress decision
+	statePassthrough                      // headers sent, bytes go straight to the client
+	stateCompressing                      // headers sent with Content-Encoding
+)
+
 type compressWriter struct {
 	http.ResponseWriter
-	gzWriter    *gzip.Writer
-	buf         []byte // lazy-allocated only when needed (fix #3)
-	wroteHeader bool
-	compressed  bool
-	headerCode  int
+	policy  *compressionPolicy
+	coding  string // negotiated with the client
+	level   int    // of the request's route
+	encoder encoder
+	buf     []byte // lazy-allocated only when needed (fix #3)
+	state   compressState
+	status  int // status requested by the handler, 0 until WriteHeader/Write
 }
 
-func (cw *compressWriter) reset(w http.ResponseWriter) {
+func (cw *compressWriter) reset(w http.ResponseWriter, p *compressionPolicy, coding string, level int) {
 	cw.ResponseWriter = w
-	cw.gzWriter = nil
+	cw.policy = p
+	cw.coding = coding
+	cw.level = level
+	cw.encoder = nil
 	cw.buf = cw.buf[:0] // reuse backing array if available
-	cw.wroteHeader = false
-	cw.compressed = false
-	cw.headerCode = 0
+	cw.state = stateBuffering
+	cw.status = 0
 }
 
 func (cw *compressWriter) shouldCompress() bool {
@@ -75,88 +247,276 @@ func (cw *compressWriter) shouldCompress() bool {
 	if cw.Header().Get("Content-Encoding") != "" {
 		return false
 	}
-	// Fast check without ToLower allocation
-	return isCompressibleContentType(ct)
+	// A byte range is a slice of the identity encoding
+	if cw.Header().Get("Content-Range") != "" {
+		return false
+	}
+	// A declared length below the threshold is not worth the framing
+	if cl := cw.Header().Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n < cw.policy.minSize {
+			return false
+		}
+	}
+	return cw.policy.compressible(ct)
 }
 
-// isCompressibleContentType checks without allocating a lowercased copy.
-func isCompressibleContentType(ct string) bool {
-	// Most common cases first for fast path
-	if len(ct) >= 5 {
+// incompressibleTypes are content types that are already compressed; running
+// them through gzip costs CPU and usually grows the payload.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/pdf",
+	"application/wasm",
+	"application/octet-stream",
+}
+
+// isIncompressibleContentType reports whether ct is on the incompressible
+// list. image/svg+xml is text and stays compressible.
+func isIncompressibleContentType(ct string) bool {
+	for _, prefix := range incompressibleTypes {
+		if hasPrefixFold(ct, prefix) {
+			return !hasPrefixFold(ct, "image/svg+xml")
+		}
+	}
+	return false
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// negotiateEncoding picks the coding of offered, listed in order of
+// preference, that the Accept-Encoding header gives the highest q-value;
+// "" when it accepts none of them.
+func negotiateEncoding(header string, offered []string) string {
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		if q := acceptedQ(header, coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// acceptedQ parses an Accept-Encoding header (RFC 9110 §12.5.3) and returns
+// the q-value it gives coding; x-gzip counts as gzip. An explicit entry
+// wins over "*"; a q-value of 0 means "not acceptable". Parsing does not
+// allocate.
+func acceptedQ(header, coding string) float64 {
+	codingQ, starQ := -1.0, -1.0
+	for header != "" {
+		var part string
+		part, header, _ = strings.Cut(header, ",")
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		q := parseQValue(params)
 		switch {
-		case strings.HasPrefix(ct, "text/"),
-			strings.HasPrefix(ct, "Text/"),
-			strings.HasPrefix(ct, "TEXT/"):
-			return true
+		case strings.EqualFold(name, coding), coding == codingGzip && strings.EqualFold(name, "x-gzip"):
+			if q > codingQ {
+				codingQ = q
+			}
+		case name == "*":
+			starQ = q
+		}
+	}
+	if codingQ >= 0 {
+		return codingQ
+	}
+	return max(starQ, 0)
+}
+
+// parseQValue extracts the q parameter from the ";"-separated parameters of an
+// Accept-Encoding element. Missing or malformed values count as q=1, values
+// are clamped to [0, 1].
+func parseQValue(params string) float64 {
+	for params != "" {
+		var param string
+		param, params, _ = strings.Cut(params, ";")
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 1
+		}
+		if q < 0 {
+			return 0
+		}
+		if q > 1 {
+			return 1
+		}
+		return q
+	}
+	return 1
+}
+
+// addVary adds Accept-Encoding to the Vary header unless already present.
+func addVary(h http.Header) {
+	for _, v := range h.Values("Vary") {
+		for v != "" {
+			var token string
+			token, v, _ = strings.Cut(v, ",")
+			token = strings.TrimSpace(token)
+			if token == "*" || strings.EqualFold(token, "Accept-Encoding") {
+				return
+			}
 		}
 	}
-	return strings.Contains(ct, "application/json") ||
-		strings.Contains(ct, "application/javascript") ||
-		strings.Contains(ct, "application/xml") ||
-		strings.Contains(ct, "application/xhtml") ||
-		strings.Contains(ct, "image/svg+xml")
+	h.Add("Vary", "Accept-Encoding")
 }
 
 func (cw *compressWriter) WriteHeader(code int) {
-	if cw.wroteHeader {
+	// Informational responses (103 Early Hints) go out immediately and do
+	// not count as the final status.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
 		return
 	}
-	cw.headerCode = code
-	cw.wroteHeader = true
-
-	// If we have enough buffered data and content is compressible, start compression
-	if len(cw.buf) >= compressMinSize && cw.shouldCompress() {
-		cw.startCompress()
+	if cw.status != 0 {
+		return
 	}
+	cw.status = code
 
-	cw.ResponseWriter.WriteHeader(code)
+	// Decide now if the answer can't change: bodiless statuses and
+	// responses that aren't eligible for compression never buffer.
+	if !bodyAllowedForStatus(code) || !cw.shouldCompress() {
+		cw.commitPassthrough()
+	}
 }
 
 func (cw *compressWriter) Write(b []byte) (int, error) {
-	if cw.compressed {
-		return cw.gzWriter.Write(b)
+	switch cw.state {
+	case statePassthrough:
+		return cw.ResponseWriter.Write(b)
+	case stateCompressing:
+		return cw.encoder.Write(b)
+	}
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+		if !cw.shouldCompress() {
+			cw.commitPassthrough()
+			return cw.ResponseWriter.Write(b)
+		}
 	}
 
 	// Buffer data until we can decide about compression
 	cw.buf = append(cw.buf, b...)
+	if int64(len(cw.buf)) < cw.policy.minSize {
+		return len(b), nil
+	}
 
-	if len(cw.buf) >= compressMinSize && !cw.wroteHeader {
-		if cw.shouldCompress() {
-			cw.startCompress()
-			cw.wroteHeader = true
-			cw.ResponseWriter.WriteHeader(http.StatusOK)
-			n, err := cw.gzWriter.Write(cw.buf)
-			// Return original write size to caller
-			if n > len(b) {
-				return len(b), err
-			}
-			return n, err
+	// Threshold reached: flush the buffer through the encoder. Bytes
+	// before b were already reported as written, so only the tail of n
+	// belongs to b.
+	cw.commitCompress()
+	prior := len(cw.buf) - len(b)
+	n, err := cw.encoder.Write(cw.buf)
+	cw.buf = cw.buf[:0]
+	n -= prior
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// Flush sends buffered data to the client. Flushing before the threshold
+// commits the response: eligible content starts compressing (so streamed
+// text stays compressed), everything else passes through.
+func (cw *compressWriter) Flush() {
+	if cw.state == stateBuffering {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		if cw.shouldCompress() && bodyAllowedForStatus(cw.status) {
+			cw.commitCompress()
+		} else {
+			cw.commitPassthrough()
 		}
+		cw.writeBuffered()
 	}
+	if cw.state == stateCompressing {
+		cw.encoder.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
 
-	return len(b), nil
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
 }
 
-func (cw *compressWriter) startCompress() {
-	cw.Header().Set("Content-Encoding", "gzip")
-	cw.Header().Set("Vary", "Accept-Encoding")
+func (cw *compressWriter) commitPassthrough() {
+	cw.state = statePassthrough
+	addVary(cw.Header())
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.writeBuffered()
+}
+
+func (cw *compressWriter) commitCompress() {
+	cw.state = stateCompressing
+	cw.Header().Set("Content-Encoding", cw.coding)
 	cw.Header().Del("Content-Length")
-	cw.compressed = true
+	addVary(cw.Header())
+	// The compressed body is another representation than the one a strong
+	// ETag names; like nginx, keep the tag but make it weak.
+	if tag := cw.Header().Get("ETag"); strings.HasPrefix(tag, `"`) {
+		cw.Header().Set("ETag", "W/"+tag)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.encoder = getEncoder(cw.coding, cw.level, cw.ResponseWriter) // Reuse pooled writer (fix #1)
+}
 
-	gz := gzWriterPool.Get().(*gzip.Writer)
-	gz.Reset(cw.ResponseWriter) // Reuse pooled writer (fix #1)
-	cw.gzWriter = gz
+// writeBuffered drains the buffer through the committed path.
+func (cw *compressWriter) writeBuffered() {
+	if len(cw.buf) == 0 {
+		return
+	}
+	if cw.state == stateCompressing {
+		cw.encoder.Write(cw.buf)
+	} else {
+		cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = cw.buf[:0]
 }
 
+// Close finishes the response. A response still buffering is below the
+// threshold and goes out uncompressed with the handler's status.
 func (cw *compressWriter) Close() {
-	if cw.compressed && cw.gzWriter != nil {
-		cw.gzWriter.Close()
-		gzWriterPool.Put(cw.gzWriter)
-		cw.gzWriter = nil
-	} else if len(cw.buf) > 0 {
-		if !cw.wroteHeader {
-			cw.ResponseWriter.WriteHeader(http.StatusOK)
+	switch cw.state {
+	case stateBuffering:
+		if cw.status != 0 || len(cw.buf) > 0 {
+			if cw.status == 0 {
+				cw.status = http.StatusOK
+			}
+			cw.commitPassthrough()
 		}
-		cw.ResponseWriter.Write(cw.buf)
+	case stateCompressing:
+		cw.encoder.Close()
+		putEncoder(cw.coding, cw.level, cw.encoder)
+		cw.encoder = nil
+	}
+}
+
+// bodyAllowedForStatus mirrors net/http: 1xx, 204 and 304 carry no body.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
 	}
+	return true
 }
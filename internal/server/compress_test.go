@@ -0,0 +1,484 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestAcceptedQ(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"x-gzip", true},
+		{"gzip, deflate, br", true},
+		{"deflate, br", false},
+		{"gzip;q=0", false},
+		{"gzip; q=0", false},
+		{"gzip;q=0.000", false},
+		{"gzip;q=0.5", true},
+		{"gzip ; Q = 0.1", true},
+		{"gzip;q=1.0", true},
+		{"gzip;q=", true},
+		{"gzip;q=abc", true},
+		{"gzip;q=-1", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"*;q=0, gzip", true},
+		{"*, gzip;q=0", false},
+		{"identity", false},
+		{"identity;q=1, *;q=0", false},
+		{"br;q=1.0, gzip;q=0.8, *;q=0.1", true},
+		{" , ,gzip", true},
+		{"gzipx", false},
+		{"deflate;q=0.5;level=1, gzip;foo=bar", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := acceptedQ(tt.header, "gzip") > 0; got != tt.want {
+				t.Errorf("acceptedQ(%q, gzip) > 0 = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncompressibleContentType(t *testing.T) {
+	tests := []struct {
+		ct   string
+		want bool
+	}{
+		{"image/png", true},
+		{"IMAGE/JPEG", true},
+		{"image/svg+xml", false},
+		{"video/mp4", true},
+		{"font/woff2", true},
+		{"application/zip", true},
+		{"text/html; charset=utf-8", false},
+		{"application/json", false},
+	}
+
+	for _, tt := range tests {
+		if got := isIncompressibleContentType(tt.ct); got != tt.want {
+			t.Errorf("isIncompressibleContentType(%q) = %v, want %v", tt.ct, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionRespectsQZero(t *testing.T) {
+	h := CompressionMiddleware(config.Default().Compression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(strings.Repeat("a", 4096)))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("expected no Content-Encoding, got %q", enc)
+	}
+	if vary := rec.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("expected Vary: Accept-Encoding on uncompressed path, got %q", vary)
+	}
+}
+
+func TestCompressionSkipsIncompressible(t *testing.T) {
+	h := CompressionMiddleware(config.Default().Compression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "font/woff2")
+		w.Write([]byte(strings.Repeat("a", 4096)))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("expected no Content-Encoding for font/woff2, got %q", enc)
+	}
+	if rec.Body.Len() != 4096 {
+		t.Errorf("expected body passed through untouched, got %d bytes", rec.Body.Len())
+	}
+}
+
+func TestCompressionSkipsUpgrade(t *testing.T) {
+	rec := httptest.NewRecorder()
+	h := CompressionMiddleware(config.Default().Compression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if w != http.ResponseWriter(rec) {
+			t.Errorf("upgrade got a %T, want the connection's own writer", w)
+		}
+	}))
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	h.ServeHTTP(rec, req)
+
+	if vary := rec.Header().Get("Vary"); vary != "" {
+		t.Errorf("expected no Vary on an upgrade, got %q", vary)
+	}
+}
+
+func TestCompressionSkipsSmallContentLength(t *testing.T) {
+	h := CompressionMiddleware(config.Default().Compression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "10")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("0123456789"))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("expected no Content-Encoding below threshold, got %q", enc)
+	}
+}
+
+func gunzipBody(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		return rec.Body.String()
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip body: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading gzip body: %v", err)
+	}
+	return string(data)
+}
+
+func TestCompressWriterStates(t *testing.T) {
+	small := strings.Repeat("s", 100)
+	large := strings.Repeat("L", 4096)
+
+	tests := []struct {
+		name        string
+		contentType string
+		status      int  // 0 = never call WriteHeader
+		late        bool // call WriteHeader after the first Write
+		chunks      []string
+		wantStatus  int
+		wantGzip    bool
+	}{
+		{"small implicit 200", "text/html", 0, false, []string{small}, 200, false},
+		{"small early 404", "text/html", 404, false, []string{small}, 404, false},
+		{"small late 404 ignored", "text/html", 404, true, []string{small}, 200, false},
+		{"large implicit 200", "text/html", 0, false, []string{large}, 200, true},
+		{"large early 500", "text/html", 500, false, []string{large}, 500, true},
+		{"large in small chunks", "text/html", 201, false, strings.SplitAfter(large, "LLLLLLLL"), 201, true},
+		{"large incompressible", "image/png", 0, false, []string{large}, 200, false},
+		{"large no content type", "", 404, false, []string{large}, 404, false},
+		{"status only", "text/html", 404, false, nil, 404, false},
+		{"no content", "text/html", 204, false, nil, 204, false},
+		{"not modified", "text/html", 304, false, nil, 304, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := strings.Join(tt.chunks, "")
+			h := CompressionMiddleware(config.Default().Compression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.status != 0 && !tt.late {
+					w.WriteHeader(tt.status)
+				}
+				for i, c := range tt.chunks {
+					n, err := w.Write([]byte(c))
+					if err != nil || n != len(c) {
+						t.Errorf("Write #%d = (%d, %v), want (%d, nil)", i, n, err, len(c))
+					}
+					if i == 0 && tt.late {
+						w.WriteHeader(tt.status)
+					}
+				}
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gz := rec.Header().Get("Content-Encoding") == "gzip"; gz != tt.wantGzip {
+				t.Errorf("gzip = %v, want %v", gz, tt.wantGzip)
+			}
+			if tt.wantGzip && rec.Header().Get("Content-Length") != "" {
+				t.Error("Content-Length must be removed when compressing")
+			}
+			if got := gunzipBody(t, rec); got != want {
+				t.Errorf("body mismatch: got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestCompressWriterFlushBeforeThreshold(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		wantGzip    bool
+	}{
+		{"compressible", "text/event-stream", true},
+		{"incompressible", "application/octet-stream", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flushed := make(chan struct{})
+			var afterFlush int
+			h := CompressionMiddleware(config.Default().Compression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte("event: one\n\n"))
+				w.(http.Flusher).Flush()
+				afterFlush = w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder).Body.Len()
+				close(flushed)
+				w.Write([]byte("event: two\n\n"))
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			<-flushed
+
+			if rec.Code != http.StatusAccepted {
+				t.Errorf("status = %d, want 202", rec.Code)
+			}
+			if !rec.Flushed {
+				t.Error("expected underlying writer to be flushed")
+			}
+			if afterFlush == 0 {
+				t.Error("expected buffered bytes to reach the client on Flush")
+			}
+			if gz := rec.Header().Get("Content-Encoding") == "gzip"; gz != tt.wantGzip {
+				t.Errorf("gzip = %v, want %v", gz, tt.wantGzip)
+			}
+			if got := gunzipBody(t, rec); got != "event: one\n\nevent: two\n\n" {
+				t.Errorf("unexpected body %q", got)
+			}
+		})
+	}
+}
+
+// statusRecorder records every WriteHeader call, including informational ones.
+type statusRecorder struct {
+	*httptest.ResponseRecorder
+	codes []int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.codes = append(r.codes, code)
+	if code >= 200 {
+		r.ResponseRecorder.WriteHeader(code)
+	}
+}
+
+func TestCompressWriterEarlyHintsPassThrough(t *testing.T) {
+	h := CompressionMiddleware(config.Default().Compression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("missing"))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := &statusRecorder{ResponseRecorder: httptest.NewRecorder()}
+	h.ServeHTTP(rec, req)
+
+	if len(rec.codes) != 2 || rec.codes[0] != http.StatusEarlyHints || rec.codes[1] != http.StatusNotFound {
+		t.Errorf("expected 103 then 404, got %v", rec.codes)
+	}
+}
+
+// textHandler writes n bytes of text/html.
+func textHandler(n int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(strings.Repeat("a", n)))
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header  string
+		offered []string
+		want    string
+	}{
+		{"gzip, deflate", []string{"gzip", "deflate"}, "gzip"},
+		{"gzip, deflate", []string{"deflate", "gzip"}, "deflate"},
+		{"gzip;q=0.5, deflate", []string{"gzip", "deflate"}, "deflate"},
+		{"deflate", []string{"gzip"}, ""},
+		{"*", []string{"deflate", "gzip"}, "deflate"},
+		{"*, gzip;q=0", []string{"gzip", "deflate"}, "deflate"},
+		{"identity", []string{"gzip", "deflate"}, ""},
+		{"", []string{"gzip"}, ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header, tt.offered); got != tt.want {
+			t.Errorf("negotiateEncoding(%q, %q) = %q, want %q", tt.header, tt.offered, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionDeflate(t *testing.T) {
+	cfg := config.Default().Compression
+	cfg.Algorithms = []string{"deflate", "gzip"}
+	cfg.Level = 9
+	h := CompressionMiddleware(cfg)(textHandler(4096))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if enc := rec.Header().Get("Content-Encoding"); enc != "deflate" {
+		t.Fatalf("Content-Encoding = %q, want deflate", enc)
+	}
+	zr, err := zlib.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("invalid deflate body: %v", err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != strings.Repeat("a", 4096) {
+		t.Errorf("body %d bytes after inflating, want 4096", len(body))
+	}
+}
+
+func TestCompressionContentTypes(t *testing.T) {
+	p := newCompressionPolicy(config.Default().Compression)
+	tests := []struct {
+		ct   string
+		want bool
+	}{
+		{"text/html; charset=utf-8", true},
+		{"TEXT/CSS", true},
+		{"application/json", true},
+		{"application/problem+json", true},
+		{"application/atom+xml", true},
+		{"image/svg+xml", true},
+		{"application/x-www-form-urlencoded", false},
+		{"application/jsonx", false},
+		{"image/png", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := p.compressible(tt.ct); got != tt.want {
+			t.Errorf("compressible(%q) = %v, want %v", tt.ct, got, tt.want)
+		}
+	}
+
+	// The incompressible guard holds whatever the list says.
+	cfg := config.Default().Compression
+	cfg.ContentTypes = []string{"text/", "application/"}
+	if p := newCompressionPolicy(cfg); p.compressible("application/zip") || !p.compressible("application/ld+json") {
+		t.Error("application/ entry overrode the incompressible list")
+	}
+}
+
+func TestCompressionRoutes(t *testing.T) {
+	cfg := config.Default().Compression
+	cfg.Routes = map[string]config.CompressionRoute{
+		"/download/*":  {Disable: true},
+		"/api/reports": {Level: 9},
+	}
+	h := CompressionMiddleware(cfg)(textHandler(4096))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The disabled route is passed through untouched: no encoding, no
+	// Vary, the body byte for byte.
+	rec := get("/download/report.html")
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("disabled route Content-Encoding = %q", enc)
+	}
+	if vary := rec.Header().Get("Vary"); vary != "" {
+		t.Errorf("disabled route Vary = %q", vary)
+	}
+	if rec.Body.String() != strings.Repeat("a", 4096) {
+		t.Errorf("disabled route body changed: %d bytes", rec.Body.Len())
+	}
+
+	// Its siblings compress, at the section's level or their own.
+	for _, path := range []string{"/", "/downloads", "/api/reports"} {
+		rec := get(path)
+		if enc := rec.Header().Get("Content-Encoding"); enc != "gzip" {
+			t.Errorf("%s Content-Encoding = %q, want gzip", path, enc)
+			continue
+		}
+		if body := gunzipBody(t, rec); body != strings.Repeat("a", 4096) {
+			t.Errorf("%s body %d bytes after gunzip, want 4096", path, len(body))
+		}
+	}
+	p := newCompressionPolicy(cfg)
+	if got := p.lookup("/api/reports"); got != 9 {
+		t.Errorf("forced level = %d, want 9", got)
+	}
+	if got := p.lookup("/api/users"); got != cfg.Level {
+		t.Errorf("unmatched level = %d, want %d", got, cfg.Level)
+	}
+}
+
+func TestCompressionReload(t *testing.T) {
+	cfg := appConfig(t)
+	r := NewRouter(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h := compression(r.compression.Load)(textHandler(4096))
+	encoding := func() string {
+		req := httptest.NewRequest("GET", "/export/all", nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header().Get("Content-Encoding")
+	}
+	if got := encoding(); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+
+	// Each reload applies to the next request.
+	next := *cfg
+	next.Compression.Routes = map[string]config.CompressionRoute{"/export/*": {Disable: true}}
+	r.SetConfig(&next)
+	if got := encoding(); got != "" {
+		t.Errorf("after disabling the route, Content-Encoding = %q", got)
+	}
+	next.Compression.Routes = nil
+	next.Compression.Algorithms = []string{"deflate"}
+	r.SetConfig(&next)
+	if got := encoding(); got != "deflate" {
+		t.Errorf("after preferring deflate, Content-Encoding = %q", got)
+	}
+	next.Compression.Enabled = false
+	r.SetConfig(&next)
+	if got := encoding(); got != "" {
+		t.Errorf("after disabling compression, Content-Encoding = %q", got)
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var (
+	connectionsGauge = metrics.NewGauge("maboo_http_connections",
+		"Open connections on the main listener.")
+	connectionPeersGauge = metrics.NewGauge("maboo_http_connection_peers",
+		"Distinct peer addresses with open connections on the main listener.")
+	connectionsRejected = metrics.NewCounterVec("maboo_http_connections_rejected_total",
+		"Connections closed at accept time, by the limit they exceeded (max_connections, max_connections_per_ip).", "reason")
+)
+
+// Limits a connection can exceed, used as metric label values.
+const (
+	rejectMaxConnections      = "max_connections"
+	rejectMaxConnectionsPerIP = "max_connections_per_ip"
+)
+
+// ConnectionLimits caps the open connections on the main listener; 0
+// means unlimited.
+type ConnectionLimits struct {
+	MaxConnections      int `json:"max_connections"`
+	MaxConnectionsPerIP int `json:"max_connections_per_ip"`
+}
+
+// connLimiter counts the open connections, in total and per peer address,
+// and refuses those over its limits.
+type connLimiter struct {
+	mu     sync.Mutex
+	limits ConnectionLimits
+	open   int
+	perIP  map[string]int
+
+	watch *connWatch // logs the connections refused; nil = none
+}
+
+func newConnLimiter(l ConnectionLimits) *connLimiter {
+	return &connLimiter{limits: l, perIP: make(map[string]int)}
+}
+
+// SetLimits changes the limits. Lowering them refuses new connections
+// only; open ones are kept.
+func (c *connLimiter) SetLimits(l ConnectionLimits) {
+	c.mu.Lock()
+	c.limits = l
+	c.mu.Unlock()
+}
+
+// Limits returns the limits in force.
+func (c *connLimiter) Limits() ConnectionLimits {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limits
+}
+
+// Open returns how many connections are open.
+func (c *connLimiter) Open() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open
+}
+
+// admit counts a connection from ip, or returns the limit it would
+// exceed.
+func (c *connLimiter) admit(ip string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if max := c.limits.MaxConnections; max > 0 && c.open >= max {
+		return rejectMaxConnections
+	}
+	if max := c.limits.MaxConnectionsPerIP; max > 0 && c.perIP[ip] >= max {
+		return rejectMaxConnectionsPerIP
+	}
+	c.open++
+	c.perIP[ip]++
+	connectionsGauge.Set(float64(c.open))
+	connectionPeersGauge.Set(float64(len(c.perIP)))
+	return ""
+}
+
+func (c *connLimiter) release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open--
+	if c.perIP[ip] <= 1 {
+		delete(c.perIP, ip)
+	} else {
+		c.perIP[ip]--
+	}
+	connectionsGauge.Set(float64(c.open))
+	connectionPeersGauge.Set(float64(len(c.perIP)))
+}
+
+// Listener wraps ln so that connections over the limits are closed as
+// soon as they are accepted, before a request is read. Peers are told
+// apart by their socket address, never by forwarded headers.
+func (c *connLimiter) Listener(ln net.Listener) net.Listener {
+	return &limitListener{Listener: ln, limiter: c}
+}
+
+type limitListener struct {
+	net.Listener
+	limiter *connLimiter
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := peerIP(conn.RemoteAddr())
+		if reason := l.limiter.admit(ip); reason != "" {
+			connectionsRejected.WithLabelValues(reason).Inc()
+			if l.limiter.watch != nil {
+				l.limiter.watch.rejected(ip, reason)
+			}
+			conn.Close()
+			continue
+		}
+		return &limitConn{Conn: conn, limiter: l.limiter, ip: ip}, nil
+	}
+}
+
+// limitConn gives its place back when closed, including after a hijack.
+type limitConn struct {
+	net.Conn
+	limiter  *connLimiter
+	ip       string
+	once     sync.Once
+	hijacked atomic.Bool // counted in maboo_http_connection_states until closed
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.limiter.release(c.ip)
+		if c.hijacked.Load() {
+			connectionStates.WithLabelValues(http.StateHijacked.String()).Dec()
+		}
+	})
+	return err
+}
+
+// ReadFrom keeps sendfile for static files.
+func (c *limitConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(c.Conn, r)
+}
+
+// peerIP is the host part of a socket address.
+func peerIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+// rejected reads maboo_http_connections_rejected_total for reason.
+func rejected(t *testing.T, reason string) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "maboo_http_connections_rejected_total" {
+			continue
+		}
+		for _, m := range f.Metric {
+			if m.Label[0].GetValue() == reason {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// healthOn sends a request on conn and reports whether it was answered.
+func healthOn(conn net.Conn) bool {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "GET /health HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func TestConnectionLimitPerIP(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.Address = "127.0.0.1:0"
+	cfg.Server.MaxConnectionsPerIP = 3
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+	<-s.Listening()
+	t.Cleanup(func() {
+		s.Stop(context.Background())
+		<-started
+	})
+	addr := s.mainLns[0].Addr().String()
+	before := rejected(t, rejectMaxConnectionsPerIP)
+
+	const dials = 10
+	conns := make([]net.Conn, dials)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conns[i] = conn
+		}()
+	}
+	wg.Wait()
+	var served []net.Conn
+	for _, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		defer conn.Close()
+		if healthOn(conn) {
+			served = append(served, conn)
+		}
+	}
+	if len(served) != 3 {
+		t.Fatalf("%d of %d simultaneous connections served, want 3", len(served), dials)
+	}
+	if got := rejected(t, rejectMaxConnectionsPerIP) - before; got != dials-3 {
+		t.Errorf("rejections counted %g, want %d", got, dials-3)
+	}
+	if open := s.conns.Open(); open != 3 {
+		t.Errorf("%d connections open, want 3", open)
+	}
+
+	// A closed connection makes room for another.
+	served[0].Close()
+	for deadline := time.Now().Add(5 * time.Second); s.conns.Open() != 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections open after closing one, want 2", s.conns.Open())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !healthOn(conn) {
+		t.Error("connection refused after one was closed")
+	}
+}
+
+func TestConnectionLimitTotal(t *testing.T) {
+	c := newConnLimiter(ConnectionLimits{MaxConnections: 2})
+	if c.admit("10.0.0.1") != "" || c.admit("10.0.0.2") != "" {
+		t.Fatal("connections under the limit refused")
+	}
+	if reason := c.admit("10.0.0.3"); reason != rejectMaxConnections {
+		t.Errorf("third connection: %q, want %s", reason, rejectMaxConnections)
+	}
+	c.SetLimits(ConnectionLimits{})
+	if c.admit("10.0.0.3") != "" {
+		t.Error("connection refused without limits")
+	}
+	c.release("10.0.0.1")
+	if c.Open() != 2 || len(c.perIP) != 2 {
+		t.Errorf("%d open from %d peers, want 2 from 2", c.Open(), len(c.perIP))
+	}
+}
+
+func TestAdminConnectionLimits(t *testing.T) {
+	s := newAdminTestServer(t, 1)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/server/limits", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	s.conns.SetLimits(ConnectionLimits{MaxConnections: 1000, MaxConnectionsPerIP: 50})
+	rec := post(`{"max_connections_per_ip": 10}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+	if want := (ConnectionLimits{MaxConnections: 1000, MaxConnectionsPerIP: 10}); s.conns.Limits() != want {
+		t.Errorf("limits = %+v, want %+v", s.conns.Limits(), want)
+	}
+	if _, r := getStatus(t, s, "s3cret"); r.Connections.Limits.MaxConnectionsPerIP != 10 {
+		t.Errorf("status connections = %+v, want the new limits", r.Connections)
+	}
+	for _, body := range []string{`{"max_connections": -1}`, `not json`} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var (
+	tlsHandshakeErrors = metrics.NewCounterVec("maboo_tls_handshake_errors_total",
+		"TLS handshakes that failed on the main listener, by cause: unknown_sni, protocol_version, cert_rejected, not_tls, timeout, eof or other.", "cause")
+	serverErrors = metrics.NewCounterVec("maboo_http_server_errors_total",
+		"Errors the HTTP servers logged outside any request, such as malformed HTTP/2 or HTTP/3 frames, by listener (http, http3).", "listener")
+	connectionStates = metrics.NewGaugeVec("maboo_http_connection_states",
+		"Connections on the main listener by state: new (no request yet), active, idle, or hijacked (handed over, e.g. to a WebSocket).", "state")
+	http3Connections = metrics.NewGauge("maboo_http3_connections",
+		"Open QUIC connections on the HTTP/3 listener.")
+)
+
+// Why a TLS handshake failed, used as metric label values.
+const (
+	causeUnknownSNI      = "unknown_sni"      // no certificate for the name the client asked for
+	causeProtocolVersion = "protocol_version" // no TLS version or cipher suite in common
+	causeCertRejected    = "cert_rejected"    // the client refused the certificate
+	causeNotTLS          = "not_tls"          // the client spoke something else, e.g. a scanner
+	causeTimeout         = "timeout"          // the client sent no hello in time
+	causeEOF             = "eof"              // the client hung up mid-handshake
+	causeOther           = "other"
+)
+
+// connLogBurst records of a kind are logged per connLogInterval; the
+// others are counted, and the count logged with the first record of the
+// next interval. A flood of scanners fills the metrics, not the log.
+const (
+	connLogBurst    = 10
+	connLogInterval = time.Minute
+)
+
+// connWatch reports what happens to connections outside any request,
+// which no middleware sees: TLS handshakes that fail, errors net/http and
+// HTTP/3 log on their own, connection states, and peers the connection
+// limits refuse at accept time.
+type connWatch struct {
+	logger *slog.Logger
+
+	mu         sync.Mutex
+	start      time.Time      // of the current interval
+	logged     map[string]int // records logged this interval, by kind
+	suppressed map[string]int
+
+	helloMu sync.Mutex
+	sni     map[string]string // server name asked for, by peer address, until the handshake ends
+
+	statesMu sync.Mutex
+	states   map[net.Conn]http.ConnState
+}
+
+func newConnWatch(logger *slog.Logger) *connWatch {
+	return &connWatch{
+		logger:     logger,
+		logged:     make(map[string]int),
+		suppressed: make(map[string]int),
+		sni:        make(map[string]string),
+		states:     make(map[net.Conn]http.ConnState),
+	}
+}
+
+// allow reports whether a record of kind may be logged now.
+func (cw *connWatch) allow(kind string) bool {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if now := time.Now(); now.Sub(cw.start) >= connLogInterval {
+		for k, n := range cw.suppressed {
+			cw.logger.Warn("connection log records suppressed", "kind", k, "count", n, "interval", connLogInterval.String())
+		}
+		clear(cw.logged)
+		clear(cw.suppressed)
+		cw.start = now
+	}
+	if cw.logged[kind] >= connLogBurst {
+		cw.suppressed[kind]++
+		return false
+	}
+	cw.logged[kind]++
+	return true
+}
+
+func (cw *connWatch) log(level slog.Level, kind, msg string, args ...any) {
+	if cw.logger.Enabled(context.Background(), level) && cw.allow(kind) {
+		cw.logger.Log(context.Background(), level, msg, args...)
+	}
+}
+
+// Write takes the lines net/http logs through http.Server.ErrorLog.
+func (cw *connWatch) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	if rest, ok := strings.CutPrefix(msg, "http: TLS handshake error from "); ok {
+		addr, err, _ := strings.Cut(rest, ": ")
+		cw.handshakeFailed(addr, err)
+		return len(p), nil
+	}
+	serverErrors.WithLabelValues("http").Inc()
+	cw.log(slog.LevelWarn, "http", "http server error", "error", msg)
+	return len(p), nil
+}
+
+// handshakeFailed counts and logs the failed handshake of peer addr.
+// Clients giving up or speaking another protocol are the background
+// noise of any public port, logged at debug only.
+func (cw *connWatch) handshakeFailed(addr, err string) {
+	cw.helloMu.Lock()
+	sni := cw.sni[addr]
+	delete(cw.sni, addr)
+	cw.helloMu.Unlock()
+
+	cause := handshakeCause(err)
+	tlsHandshakeErrors.WithLabelValues(cause).Inc()
+	level := slog.LevelWarn
+	switch cause {
+	case causeNotTLS, causeTimeout, causeEOF:
+		level = slog.LevelDebug
+	}
+	peer := addr
+	if host, _, splitErr := net.SplitHostPort(addr); splitErr == nil {
+		peer = host
+	}
+	cw.log(level, "tls_"+cause, "TLS handshake failed", "peer", peer, "sni", sni, "cause", cause, "error", err)
+}
+
+// handshakeCause classifies the error net/http logs for a handshake.
+func handshakeCause(err string) string {
+	switch {
+	case strings.Contains(err, "missing server name"),
+		strings.Contains(err, "not configured"),
+		strings.Contains(err, "no certificate"):
+		return causeUnknownSNI
+	case strings.Contains(err, "unsupported versions"),
+		strings.Contains(err, "protocol version"),
+		strings.Contains(err, "unsupported SSLv2"),
+		strings.Contains(err, "no cipher suite"),
+		strings.Contains(err, "no mutually supported"):
+		return causeProtocolVersion
+	case strings.Contains(err, "bad certificate"),
+		strings.Contains(err, "unknown certificate authority"),
+		strings.Contains(err, "certificate unknown"),
+		strings.Contains(err, "certificate expired"),
+		strings.Contains(err, "unsupported certificate"):
+		return causeCertRejected
+	case strings.Contains(err, "does not look like a TLS handshake"):
+		return causeNotTLS
+	case strings.Contains(err, "timeout"):
+		return causeTimeout
+	case strings.Contains(err, "EOF"), strings.Contains(err, "connection reset"):
+		return causeEOF
+	}
+	return causeOther
+}
+
+// watchTLS notes the server name of each TCP client hello on cfg, for the
+// log of a handshake that fails.
+func (cw *connWatch) watchTLS(cfg *tls.Config) {
+	next := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		// QUIC handshakes have no ConnState to forget them by.
+		if hello.Conn != nil && hello.ServerName != "" {
+			if addr, ok := hello.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				cw.helloMu.Lock()
+				cw.sni[addr.String()] = hello.ServerName
+				cw.helloMu.Unlock()
+			}
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+}
+
+// track is the main listener's http.Server ConnState hook. A hijacked
+// connection counts as such until it is closed.
+func (cw *connWatch) track(c net.Conn, state http.ConnState) {
+	cw.statesMu.Lock()
+	defer cw.statesMu.Unlock()
+	if old, ok := cw.states[c]; ok {
+		connectionStates.WithLabelValues(old.String()).Dec()
+	} else if state != http.StateNew {
+		return // never seen new
+	}
+	switch state {
+	case http.StateClosed:
+		delete(cw.states, c)
+	case http.StateHijacked:
+		delete(cw.states, c)
+		if lc := asLimitConn(c); lc != nil {
+			connectionStates.WithLabelValues(state.String()).Inc()
+			lc.hijacked.Store(true)
+		}
+	default:
+		cw.states[c] = state
+		connectionStates.WithLabelValues(state.String()).Inc()
+	}
+	if state != http.StateNew {
+		cw.helloMu.Lock()
+		delete(cw.sni, c.RemoteAddr().String())
+		cw.helloMu.Unlock()
+	}
+}
+
+// asLimitConn returns the connection the limiter accepted under c, or nil.
+func asLimitConn(c net.Conn) *limitConn {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	lc, _ := c.(*limitConn)
+	return lc
+}
+
+// rejected logs a peer the connection limits refused.
+func (cw *connWatch) rejected(ip, reason string) {
+	cw.log(slog.LevelWarn, "reject_"+reason, "connection rejected", "peer", ip, "limit", reason)
+}
+
+// watchHTTP3 has srv log through cw's limits and count its connections.
+func (cw *connWatch) watchHTTP3(s *HTTP3Server) {
+	if s == nil {
+		return
+	}
+	s.server.Logger = slog.New(&http3LogHandler{Handler: cw.logger.With("listener", "http3").Handler(), watch: cw})
+	s.server.ConnContext = func(ctx context.Context, c *quic.Conn) context.Context {
+		http3Connections.Inc()
+		go func() {
+			<-c.Context().Done()
+			http3Connections.Dec()
+		}()
+		return ctx
+	}
+}
+
+// http3LogHandler counts the records HTTP/3 logs, which are about
+// connections and streams that failed, and drops those over cw's limits.
+type http3LogHandler struct {
+	slog.Handler
+	watch *connWatch
+}
+
+// Enabled is true so that every record is counted, whatever the level.
+func (h *http3LogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *http3LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	serverErrors.WithLabelValues("http3").Inc()
+	if !h.Handler.Enabled(ctx, r.Level) || !h.watch.allow("http3") {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *http3LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &http3LogHandler{Handler: h.Handler.WithAttrs(attrs), watch: h.watch}
+}
+
+func (h *http3LogHandler) WithGroup(name string) slog.Handler {
+	return &http3LogHandler{Handler: h.Handler.WithGroup(name), watch: h.watch}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+// lockedBuffer is a log output written from connection goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// metricValue reads the counter or gauge name for the label value label.
+func metricValue(t *testing.T, name, label string) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.Metric {
+			if m.Label[0].GetValue() == label {
+				return m.GetCounter().GetValue() + m.GetGauge().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestTLSHandshakeErrors(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.Address = "127.0.0.1:0"
+	cfg.Server.TLS.Auto = true
+	var logs lockedBuffer
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+	<-s.Listening()
+	t.Cleanup(func() {
+		s.Stop(context.Background())
+		<-started
+	})
+	addr := s.mainLns[0].Addr().String()
+	version := metricValue(t, "maboo_tls_handshake_errors_total", causeProtocolVersion)
+	rejected := metricValue(t, "maboo_tls_handshake_errors_total", causeCertRejected)
+
+	// A client that only speaks TLS 1.1.
+	if _, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "shop.example", MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11, InsecureSkipVerify: true}); err == nil {
+		t.Fatal("TLS 1.1 handshake succeeded")
+	}
+	// A client that does not trust the self-signed certificate.
+	if _, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "localhost"}); err == nil {
+		t.Fatal("handshake with an untrusted certificate succeeded")
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if metricValue(t, "maboo_tls_handshake_errors_total", causeProtocolVersion) == version+1 &&
+			metricValue(t, "maboo_tls_handshake_errors_total", causeCertRejected) == rejected+1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("handshake errors not counted; log:\n%s", logs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, want := range []string{
+		`level=WARN msg="TLS handshake failed" peer=127.0.0.1 sni=shop.example cause=protocol_version`,
+		`level=WARN msg="TLS handshake failed" peer=127.0.0.1 sni=localhost cause=cert_rejected`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestHandshakeCause(t *testing.T) {
+	tests := []struct{ err, want string }{
+		{`acme/autocert: host "evil.example" not configured in HostWhitelist`, causeUnknownSNI},
+		{"acme/autocert: missing server name", causeUnknownSNI},
+		{"tls: client offered only unsupported versions: [302 301]", causeProtocolVersion},
+		{"tls: no cipher suite supported by both client and server", causeProtocolVersion},
+		{"remote error: tls: bad certificate", causeCertRejected},
+		{"remote error: tls: unknown certificate authority", causeCertRejected},
+		{"tls: first record does not look like a TLS handshake", causeNotTLS},
+		{"read tcp 127.0.0.1:443->10.0.0.1:5000: i/o timeout", causeTimeout},
+		{"EOF", causeEOF},
+		{"read tcp 127.0.0.1:443->10.0.0.1:5000: read: connection reset by peer", causeEOF},
+		{"tls: received unexpected handshake message", causeOther},
+	}
+	for _, tt := range tests {
+		if got := handshakeCause(tt.err); got != tt.want {
+			t.Errorf("handshakeCause(%q) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestConnWatchRateLimit(t *testing.T) {
+	var logs lockedBuffer
+	cw := newConnWatch(slog.New(slog.NewTextHandler(&logs, nil)))
+	before := metricValue(t, "maboo_tls_handshake_errors_total", causeCertRejected)
+	for range 25 {
+		io.WriteString(cw, "http: TLS handshake error from 10.0.0.1:5000: remote error: tls: bad certificate\n")
+	}
+	if n := metricValue(t, "maboo_tls_handshake_errors_total", causeCertRejected) - before; n != 25 {
+		t.Errorf("counted %v handshake errors, want 25", n)
+	}
+	if n := strings.Count(logs.String(), "TLS handshake failed"); n != connLogBurst {
+		t.Errorf("logged %d handshake errors, want %d", n, connLogBurst)
+	}
+
+	// Other kinds have their own allowance.
+	io.WriteString(cw, "http2: server: error reading preface from client 10.0.0.1:5000: bogus greeting\n")
+	cw.rejected("10.0.0.2", rejectMaxConnectionsPerIP)
+	for _, want := range []string{
+		`msg="http server error" error="http2: server: error reading preface`,
+		`msg="connection rejected" peer=10.0.0.2 limit=max_connections_per_ip`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logs.String())
+		}
+	}
+
+	// The next interval reports what was left out of the last.
+	cw.start = cw.start.Add(-connLogInterval)
+	io.WriteString(cw, "http: TLS handshake error from 10.0.0.1:5001: remote error: tls: bad certificate\n")
+	if want := `msg="connection log records suppressed" kind=tls_cert_rejected count=15`; !strings.Contains(logs.String(), want) {
+		t.Errorf("log lacks %q:\n%s", want, logs.String())
+	}
+	if n := strings.Count(logs.String(), "TLS handshake failed"); n != connLogBurst+1 {
+		t.Errorf("logged %d handshake errors after the interval, want %d", n, connLogBurst+1)
+	}
+}
+
+func TestConnectionStates(t *testing.T) {
+	cw := newConnWatch(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	limiter := newConnLimiter(ConnectionLimits{})
+	state := func(s http.ConnState) float64 {
+		return metricValue(t, "maboo_http_connection_states", s.String())
+	}
+	states := []http.ConnState{http.StateNew, http.StateActive, http.StateIdle, http.StateHijacked}
+	before := make(map[http.ConnState]float64)
+	for _, s := range states {
+		before[s] = state(s)
+	}
+	check := func(step string, want http.ConnState) {
+		t.Helper()
+		for _, s := range states {
+			n := state(s) - before[s]
+			if (s == want && n != 1) || (s != want && n != 0) {
+				t.Errorf("%s: %d connections %s", step, int(n), s)
+			}
+		}
+	}
+
+	c, peer := net.Pipe()
+	defer peer.Close()
+	limiter.admit("10.0.0.1")
+	conn := &limitConn{Conn: c, limiter: limiter, ip: "10.0.0.1"}
+	cw.track(conn, http.StateNew)
+	check("accepted", http.StateNew)
+	cw.track(conn, http.StateActive)
+	check("serving a request", http.StateActive)
+	cw.track(conn, http.StateIdle)
+	check("between requests", http.StateIdle)
+	cw.track(conn, http.StateActive)
+	cw.track(conn, http.StateHijacked)
+	check("hijacked", http.StateHijacked)
+	conn.Close()
+	check("hijacked and closed", -1)
+
+	c, peer = net.Pipe()
+	defer peer.Close()
+	limiter.admit("10.0.0.1")
+	conn = &limitConn{Conn: c, limiter: limiter, ip: "10.0.0.1"}
+	cw.track(conn, http.StateNew)
+	cw.track(conn, http.StateClosed)
+	conn.Close()
+	check("closed before a request", -1)
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// debugHeader carries debug.secret on a request asking for its PHP
+// statistics.
+const debugHeader = "X-Maboo-Debug"
+
+// statsUnavailableHeader answers a request that asked for statistics its
+// engine could not collect, as the embedded engine without its libphp
+// bindings cannot.
+const statsUnavailableHeader = "X-Maboo-Debug-Stats"
+
+// wantStats reports whether req gets the statistics of its PHP run: always
+// with debug.stats, otherwise when it sends debug.secret. The header is
+// removed either way, so PHP never sees the secret.
+func wantStats(cfg *config.Config, req *http.Request) bool {
+	got := req.Header.Get(debugHeader)
+	if got == "" {
+		return cfg.Debug.Stats
+	}
+	req.Header.Del(debugHeader)
+	secret := cfg.Debug.Secret.Value()
+	return cfg.Debug.Stats || secret != "" && subtle.ConstantTimeCompare([]byte(got), []byte(secret)) == 1
+}
+
+// writeStats adds s to the response as X-Maboo-Debug-* headers.
+func writeStats(h http.Header, s *phpengine.Stats) {
+	h.Set("X-Maboo-Debug-Opcache-Hits", strconv.FormatInt(s.OpcacheHits, 10))
+	h.Set("X-Maboo-Debug-Opcache-Misses", strconv.FormatInt(s.OpcacheMisses, 10))
+	h.Set("X-Maboo-Debug-Included-Files", strconv.Itoa(s.IncludedFiles))
+	h.Set("X-Maboo-Debug-Peak-Memory", strconv.FormatInt(s.PeakMemory, 10))
+	h.Set("X-Maboo-Debug-Realpath-Cache-Size", strconv.FormatInt(s.RealpathCacheSize, 10))
+}
+
+// statsAttr is s as the access log records it.
+func statsAttr(s *phpengine.Stats) slog.Attr {
+	return slog.Group("debug",
+		slog.Int64("opcache_hits", s.OpcacheHits),
+		slog.Int64("opcache_misses", s.OpcacheMisses),
+		slog.Int("included_files", s.IncludedFiles),
+		slog.Int64("peak_memory", s.PeakMemory),
+		slog.Int64("realpath_cache_size", s.RealpathCacheSize),
+	)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// statsPool reports stats for the requests asking for them, and records
+// the $_SERVER of the last one.
+type statsPool struct {
+	stubPool
+	mu     sync.Mutex
+	server map[string]string
+}
+
+func (p *statsPool) Exec(ctx *phpengine.Context, script string) (*phpengine.Response, error) {
+	p.mu.Lock()
+	p.server = ctx.Server
+	p.mu.Unlock()
+	resp := &phpengine.Response{Status: http.StatusOK}
+	if ctx.CollectStats {
+		resp.Stats = &phpengine.Stats{OpcacheHits: 40, OpcacheMisses: 2, IncludedFiles: 42, PeakMemory: 2 << 20, RealpathCacheSize: 4096}
+	}
+	return resp, nil
+}
+
+func TestRouterDebugStats(t *testing.T) {
+	const secret = "0123456789abcdef"
+	cfg := appConfig(t)
+	cfg.Debug.Secret = secret
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	accessLog := slog.New(slog.NewJSONHandler(&logs, nil))
+	pool := &statsPool{}
+	router := NewRouter(cfg, pool, logger)
+	h := CoreMiddleware(logger, accessLog, nil, config.TracingConfig{}, nil)(router)
+
+	type entry struct {
+		Debug *struct {
+			OpcacheMisses int64 `json:"opcache_misses"`
+			IncludedFiles int   `json:"included_files"`
+		} `json:"debug"`
+	}
+	get := func(header string) (http.Header, entry) {
+		logs.Reset()
+		req := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			req.Header.Set(debugHeader, header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		var e entry
+		if err := json.Unmarshal(logs.Bytes(), &e); err != nil {
+			t.Fatalf("access log %q: %v", logs.String(), err)
+		}
+		return w.Header(), e
+	}
+
+	hdr, e := get(secret)
+	for name, want := range map[string]string{
+		"X-Maboo-Debug-Opcache-Hits":        "40",
+		"X-Maboo-Debug-Opcache-Misses":      "2",
+		"X-Maboo-Debug-Included-Files":      "42",
+		"X-Maboo-Debug-Peak-Memory":         "2097152",
+		"X-Maboo-Debug-Realpath-Cache-Size": "4096",
+	} {
+		if got := hdr.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if e.Debug == nil || e.Debug.OpcacheMisses != 2 || e.Debug.IncludedFiles != 42 {
+		t.Errorf("access log debug = %+v", e.Debug)
+	}
+	if _, ok := pool.server["HTTP_X_MABOO_DEBUG"]; ok {
+		t.Error("the secret reached PHP")
+	}
+
+	for _, header := range []string{"", "wrong-secret-0000"} {
+		hdr, e := get(header)
+		if hdr.Get("X-Maboo-Debug-Included-Files") != "" || e.Debug != nil {
+			t.Errorf("header %q: got stats", header)
+		}
+		if _, ok := pool.server["HTTP_X_MABOO_DEBUG"]; ok {
+			t.Errorf("header %q reached PHP", header)
+		}
+	}
+
+	// debug.stats reports every request.
+	next := *cfg
+	next.Debug = config.DebugConfig{Stats: true}
+	router.SetConfig(&next)
+	if hdr, e := get(""); hdr.Get("X-Maboo-Debug-Included-Files") != "42" || e.Debug == nil {
+		t.Errorf("debug.stats: headers %v, log %+v", hdr, e.Debug)
+	}
+}
+
+func TestRouterDebugStatsUnavailable(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Debug.Stats = true
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	router := NewRouter(cfg, &stubPool{workers: 1}, logger)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get(statsUnavailableHeader); got != "unavailable" {
+		t.Errorf("%s = %q, want unavailable", statsUnavailableHeader, got)
+	}
+	if got := w.Header().Get("X-Maboo-Debug-Included-Files"); got != "" {
+		t.Errorf("X-Maboo-Debug-Included-Files = %q for stats nobody collected", got)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var inFlightGauge = metrics.NewGauge("maboo_http_requests_in_flight",
+	"Requests received on the main listeners and not yet answered.")
+
+// drainPoll is how often Stop checks whether the requests in flight have
+// finished.
+const drainPoll = 5 * time.Millisecond
+
+// inFlight counts the requests being served, over every protocol and
+// including hijacked connections. Stop waits for it to reach zero, so the
+// pools behind the router are never stopped under a request.
+type inFlight struct {
+	n atomic.Int64
+}
+
+// middleware counts the requests passing through next. It is the
+// outermost middleware, so a request is counted until its last byte is
+// written.
+func (f *inFlight) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.n.Add(1)
+		inFlightGauge.Inc()
+		defer func() {
+			f.n.Add(-1)
+			inFlightGauge.Dec()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wait returns once no request is in flight, or with ctx's error if it
+// ends first.
+func (f *inFlight) wait(ctx context.Context) error {
+	tick := time.NewTicker(drainPoll)
+	defer tick.Stop()
+	for f.n.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// stoppingPool takes delay over each request and fails every request once
+// stopped, as a real pool does.
+type stoppingPool struct {
+	stubPool
+	delay   time.Duration
+	stopped atomic.Bool
+}
+
+func (p *stoppingPool) Exec(*phpengine.Context, string) (*phpengine.Response, error) {
+	time.Sleep(p.delay)
+	if p.stopped.Load() {
+		return nil, errors.New("pool stopped")
+	}
+	return &phpengine.Response{Status: http.StatusOK, Body: []byte("ok")}, nil
+}
+
+func (p *stoppingPool) Stop() error {
+	p.stopped.Store(true)
+	return nil
+}
+
+// startTestServer starts a server for pool on a free local port and
+// returns its address.
+func startTestServer(t *testing.T, pool *stoppingPool) (*Server, string) {
+	t.Helper()
+	cfg := appConfig(t)
+	cfg.Server.Address = "127.0.0.1:0"
+	s := New(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	go s.Start()
+	<-s.Listening()
+	return s, "http://" + s.mainLns[0].Addr().String()
+}
+
+func TestInFlightWait(t *testing.T) {
+	var f inFlight
+	release := make(chan struct{})
+	h := f.middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release }))
+	served := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(served)
+	}()
+	for f.n.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := f.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait with a request in flight = %v, want the deadline", err)
+	}
+
+	close(release)
+	<-served
+	if err := f.wait(context.Background()); err != nil {
+		t.Errorf("wait after the request = %v", err)
+	}
+}
+
+func TestStopTurnsReadinessOff(t *testing.T) {
+	s := New(appConfig(t), &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.http.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	var body struct {
+		Status string `json:"status"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusServiceUnavailable || body.Status != statusShuttingDown {
+		t.Errorf("/ready after Stop: %d %q, want 503 %q", rec.Code, body.Status, statusShuttingDown)
+	}
+	if s.Status().Status != "not_ready" {
+		t.Errorf("admin status %q, want not_ready", s.Status().Status)
+	}
+}
+
+// TestRestartUnderLoad replaces a server by a new one while clients keep
+// sending requests, stopping the old pool right after the old server's
+// Stop returns as maboo serve does. No request may fail with a 5xx
+// because of the shutdown.
+func TestRestartUnderLoad(t *testing.T) {
+	oldPool := &stoppingPool{stubPool: stubPool{workers: 1}, delay: 20 * time.Millisecond}
+	old, oldURL := startTestServer(t, oldPool)
+	next, nextURL := startTestServer(t, &stoppingPool{stubPool: stubPool{workers: 1}, delay: 20 * time.Millisecond})
+	defer next.Stop(context.Background())
+
+	var (
+		target  atomic.Pointer[string]
+		done    = make(chan struct{})
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		served  int
+		failed  []int
+		refused int
+	)
+	target.Store(&oldURL)
+	client := &http.Client{Timeout: 5 * time.Second}
+	for range 8 {
+		wg.Go(func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				resp, err := client.Get(*target.Load() + "/")
+				if err != nil {
+					// A connection the old server closed; a balancer retries.
+					mu.Lock()
+					refused++
+					mu.Unlock()
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				mu.Lock()
+				served++
+				if resp.StatusCode >= 500 {
+					failed = append(failed, resp.StatusCode)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !cond(); {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor("requests in flight on the old server", func() bool { return old.inFlight.n.Load() >= 4 })
+
+	// The old server is taken out of rotation as its Stop begins; requests
+	// already sent to it must still complete.
+	target.Store(&nextURL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := old.Stop(ctx); err != nil {
+		t.Errorf("Stop = %v", err)
+	}
+	if n := old.inFlight.n.Load(); n != 0 {
+		t.Errorf("%d requests in flight after Stop", n)
+	}
+	oldPool.Stop()
+
+	mu.Lock()
+	before := served
+	mu.Unlock()
+	waitFor("requests served by the new server", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return served > before+8
+	})
+	close(done)
+	wg.Wait()
+
+	if len(failed) > 0 {
+		t.Errorf("%d of %d requests failed with 5xx during the restart: %v", len(failed), served, failed)
+	}
+	t.Logf("%d requests served, %d connections closed by the old server", served, refused)
+}
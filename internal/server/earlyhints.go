@@ -1,21 +1,110 @@
 package server
 
 import (
+	"maps"
 	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/route"
+)
+
+var earlyHintsTotal = metrics.NewCounterVec("maboo_http_early_hints_total",
+	"103 Early Hints responses sent, by source (config, php).", "source")
+
+// Sources of an early hint, used as metric label values.
+const (
+	hintsFromConfig = "config" // early_hints.routes
+	hintsFromPHP    = "php"    // sent by the script while it runs
 )
 
-// EarlyHintsMiddleware sends HTTP 103 Early Hints headers to the client.
-// This allows the browser to start preloading resources before the main response.
-func EarlyHintsMiddleware() func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Check if client supports early hints (HTTP/2+)
-			if r.ProtoMajor >= 2 {
-				// Early hints can be sent here for known resources
-				// For now, this is a placeholder for future implementation
-				// Example: w.Header().Add("Link", "</style.css>; rel=preload; as=style")
-			}
-			next.ServeHTTP(w, r)
-		})
+// earlyHints is early_hints, compiled.
+type earlyHints struct {
+	matcher *route.Matcher
+	links   [][]string // per template of matcher
+	http1   bool
+}
+
+// newEarlyHints compiles cfg, or returns nil when early hints are off.
+// Validate has checked the templates, so ones that fail to compile leave
+// only the hints PHP sends.
+func newEarlyHints(cfg config.HintsConfig) *earlyHints {
+	if !cfg.Enabled {
+		return nil
+	}
+	h := &earlyHints{http1: cfg.HTTP1}
+	templates := slices.Sorted(maps.Keys(cfg.Routes))
+	if m, err := route.New(templates); err == nil {
+		h.matcher = m
+		for _, tpl := range templates {
+			h.links = append(h.links, cfg.Routes[tpl])
+		}
+	}
+	return h
+}
+
+// sender returns the hintSender for req, or nil if its client must not
+// get informational responses: HTTP/1.0 clients never do (RFC 9110 15.2),
+// and HTTP/1.1 ones only with early_hints.http1, since some proxies
+// mishandle them.
+func (h *earlyHints) sender(w http.ResponseWriter, req *http.Request) *hintSender {
+	if h == nil || (req.ProtoMajor < 2 && !(h.http1 && req.ProtoAtLeast(1, 1))) {
+		return nil
+	}
+	return &hintSender{w: w}
+}
+
+// routeLinks returns the Link values configured for path.
+func (h *earlyHints) routeLinks(path string) []string {
+	if i := h.matcher.Match(path); i >= 0 {
+		return h.links[i]
+	}
+	return nil
+}
+
+// hintSender sends 103 responses until the final response begins. Hints
+// from PHP arrive on the goroutine running the script, which may outlive
+// the request after a timeout, so sends and close are serialized.
+type hintSender struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	closed bool
+}
+
+// send writes a 103 with links added to the response's Link headers. It
+// is a no-op once close has been called. A nil sender drops the hints.
+func (s *hintSender) send(source string, links []string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	added := false
+	for _, link := range links {
+		if phpengine.ValidHeaderValue(link) {
+			s.w.Header().Add("Link", link)
+			added = true
+		}
+	}
+	if added {
+		s.w.WriteHeader(http.StatusEarlyHints)
+		earlyHintsTotal.WithLabelValues(source).Inc()
+	}
+}
+
+// close stops further hints; the caller is about to write the final
+// response.
+func (s *hintSender) close() {
+	if s == nil {
+		return
 	}
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
 }
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// hintingPool sends PHP's own early hints, then waits for release before
+// answering, so a test can check the hints arrived while PHP still ran.
+type hintingPool struct {
+	stubPool
+	links   []string
+	release chan struct{}
+}
+
+func (p *hintingPool) Exec(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+	if ctx.EarlyHints != nil && p.links != nil {
+		ctx.EarlyHints(p.links)
+	}
+	if p.release != nil {
+		select {
+		case <-p.release:
+		case <-time.After(2 * time.Second):
+			return &phpengine.Response{Status: http.StatusInternalServerError}, nil // no hint reached the client
+		}
+	}
+	return &phpengine.Response{Status: http.StatusOK, Body: []byte("page")}, nil
+}
+
+func newHintsServer(t *testing.T, hints config.HintsConfig, pool Pool) *httptest.Server {
+	t.Helper()
+	cfg := appConfig(t)
+	cfg.Hints = hints
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := httptest.NewServer(CoreMiddleware(logger, logger, nil, cfg.Tracing, nil)(NewRouter(cfg, pool, logger)))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// interimEvent is a 103 or the final response, in the order the client
+// saw them.
+type interimEvent struct {
+	code  int
+	links []string
+}
+
+func getWithHints(t *testing.T, url string, onHint func()) ([]interimEvent, *http.Response) {
+	t.Helper()
+	var mu sync.Mutex
+	var events []interimEvent
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, h textproto.MIMEHeader) error {
+			mu.Lock()
+			events = append(events, interimEvent{code, h.Values("Link")})
+			mu.Unlock()
+			if onHint != nil {
+				onHint()
+			}
+			return nil
+		},
+	}
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	return append(events, interimEvent{resp.StatusCode, resp.Header.Values("Link")}), resp
+}
+
+func TestEarlyHintsBeforePHP(t *testing.T) {
+	pool := &hintingPool{release: make(chan struct{})}
+	srv := newHintsServer(t, config.HintsConfig{
+		Enabled: true,
+		HTTP1:   true,
+		Routes: map[string][]string{
+			"/products/:slug": {"</app.css>; rel=preload; as=style", "<https://cdn.example.com>; rel=preconnect"},
+		},
+	}, pool)
+
+	// PHP answers only once the client has the 103, so the hint cannot
+	// have waited for the response.
+	var once sync.Once
+	events, _ := getWithHints(t, srv.URL+"/products/lamp", func() { once.Do(func() { close(pool.release) }) })
+	if len(events) != 2 || events[0].code != http.StatusEarlyHints || events[1].code != http.StatusOK {
+		t.Fatalf("responses = %+v, want 103 then 200", events)
+	}
+	if len(events[0].links) != 2 || events[0].links[0] != "</app.css>; rel=preload; as=style" {
+		t.Errorf("103 links = %q", events[0].links)
+	}
+
+	// No hints configured for this path.
+	events, _ = getWithHints(t, srv.URL+"/about", nil)
+	if len(events) != 1 || events[0].code != http.StatusOK {
+		t.Errorf("responses = %+v, want only 200", events)
+	}
+}
+
+func TestEarlyHintsFromPHP(t *testing.T) {
+	pool := &hintingPool{links: []string{"</hero.webp>; rel=preload; as=image", "bad\r\nSet-Cookie: x=1"}, release: make(chan struct{})}
+	srv := newHintsServer(t, config.HintsConfig{Enabled: true, HTTP1: true}, pool)
+
+	var once sync.Once
+	events, resp := getWithHints(t, srv.URL+"/", func() { once.Do(func() { close(pool.release) }) })
+	if len(events) != 2 || events[0].code != http.StatusEarlyHints || events[1].code != http.StatusOK {
+		t.Fatalf("responses = %+v, want 103 then 200", events)
+	}
+	if len(events[0].links) != 1 || events[0].links[0] != "</hero.webp>; rel=preload; as=image" {
+		t.Errorf("103 links = %q", events[0].links)
+	}
+	if resp.Header.Get("Set-Cookie") != "" {
+		t.Errorf("injected header reached the client")
+	}
+}
+
+func TestEarlyHintsGuardsHTTP1(t *testing.T) {
+	hints := config.HintsConfig{Enabled: true, Routes: map[string][]string{"/": {"</app.css>; rel=preload; as=style"}}}
+
+	// HTTP/1.1 clients get hints only with early_hints.http1.
+	srv := newHintsServer(t, hints, &hintingPool{})
+	if events, _ := getWithHints(t, srv.URL+"/", nil); len(events) != 1 {
+		t.Errorf("HTTP/1.1 without http1: responses = %+v", events)
+	}
+
+	// HTTP/1.0 clients never do.
+	hints.HTTP1 = true
+	srv = newHintsServer(t, hints, &hintingPool{})
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.0\r\nHost: example.com\r\n\r\n")
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(status, "HTTP/1.0 200") {
+		t.Errorf("HTTP/1.0 client got %q first", status)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// entryCheckInterval is how long the entry script is taken to be where it
+// was last found, or missing, before it is looked for again.
+const entryCheckInterval = time.Second
+
+// entryState is the entry script as last checked.
+type entryState struct {
+	root       string // app.root and app.entry it was resolved from
+	configured string
+	script     string // relative to root
+	err        error  // phpengine.ErrEntryMissing while the script is missing
+	checked    time.Time
+}
+
+// entryPoint returns the entry script for cfg, checked at most every
+// entryCheckInterval. While it is missing requests are answered with 503
+// and /health reports entry_missing; a deploy that puts it back is picked
+// up by the next check.
+func (r *Router) entryPoint(cfg *config.Config) *entryState {
+	root := cfg.App.Root
+	if root == "" {
+		root = "."
+	}
+	prev := r.entry.Load()
+	if prev != nil && prev.root == root && prev.configured == cfg.App.Entry && time.Since(prev.checked) < entryCheckInterval {
+		return prev
+	}
+
+	script, err := phpengine.CheckEntryPoint(root, cfg.App.Entry)
+	next := &entryState{root: root, configured: cfg.App.Entry, script: script, err: err, checked: time.Now()}
+	if !r.entry.CompareAndSwap(prev, next) {
+		return next // another request checked at the same time
+	}
+	switch {
+	case err != nil && (prev == nil || prev.err == nil):
+		r.logger.Error("entry script missing, answering 503", "error", err)
+	case err == nil && prev != nil && prev.err != nil:
+		r.logger.Info("entry script found again", "script", script)
+	}
+	return next
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+// appConfig returns the default config with app.root set to a temporary
+// directory holding an index.php, so that requests reach the pool.
+func appConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg := config.Default()
+	cfg.App.Root = t.TempDir()
+	if err := os.WriteFile(filepath.Join(cfg.App.Root, "index.php"), []byte("<?php\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestRouterEntryMissing(t *testing.T) {
+	cfg := config.Default()
+	cfg.App.Root = t.TempDir()
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	accessLog := slog.New(slog.NewJSONHandler(&logs, nil))
+	r := NewRouter(cfg, &downloadPool{stubPool: stubPool{workers: 1}, body: []byte("page")}, logger)
+	h := CoreMiddleware(logger, accessLog, nil, config.TracingConfig{}, nil)(r)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	rec := serve("/")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", rec.Code)
+	}
+	if ra := rec.Header().Get("Retry-After"); ra != "1" {
+		t.Errorf("Retry-After %q, want 1", ra)
+	}
+	var entry struct {
+		ErrorClass string `json:"error_class"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("access log %q: %v", logs.String(), err)
+	}
+	if entry.ErrorClass != errClassEntryMissing {
+		t.Errorf("error_class %q, want %q", entry.ErrorClass, errClassEntryMissing)
+	}
+
+	for _, path := range []string{"/health", "/ready"} {
+		rec := serve(path)
+		var body struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		if body.Status != statusEntryMissing || !strings.Contains(body.Error, "index.php") {
+			t.Errorf("%s: %+v, want entry_missing naming index.php", path, body)
+		}
+		want := http.StatusOK
+		if path == "/ready" {
+			want = http.StatusServiceUnavailable
+		}
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+
+	// A deploy puts the script back; it is found on the next check.
+	if err := os.WriteFile(filepath.Join(cfg.App.Root, "index.php"), []byte("<?php\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve("/"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("within the check interval: status %d, want 503", rec.Code)
+	}
+	stale := *r.entry.Load()
+	stale.checked = time.Now().Add(-entryCheckInterval)
+	r.entry.Store(&stale)
+	if rec := serve("/"); rec.Code != http.StatusOK || rec.Body.String() != "page" {
+		t.Errorf("after the check interval: status %d, body %q", rec.Code, rec.Body)
+	}
+	if rec := serve("/ready"); rec.Code != http.StatusOK {
+		t.Errorf("/ready after the script returned: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/route"
+)
+
+var (
+	etagNotModified = metrics.NewCounter("maboo_http_etag_not_modified_total",
+		"PHP responses turned into 304 Not Modified because the client's If-None-Match named their ETag.")
+	etagBytesSaved = metrics.NewCounter("maboo_http_etag_bytes_saved_total",
+		"Body bytes of PHP responses not sent because they were answered with 304, before compression.")
+)
+
+// etagLength is how many bytes of the body's SHA-256 an ETag carries.
+const etagLength = 16
+
+// etagPolicy decides which PHP responses get an ETag, from the etag
+// section of the config.
+type etagPolicy struct {
+	routes *route.Matcher // nil = every route
+}
+
+// newETagPolicy compiles cfg; it returns nil when ETags are disabled.
+// Validate has checked the templates, so routes that fail to compile
+// disable ETags as well.
+func newETagPolicy(cfg config.ETagConfig) *etagPolicy {
+	if !cfg.Enabled {
+		return nil
+	}
+	p := &etagPolicy{}
+	if len(cfg.Routes) > 0 {
+		m, err := route.New(cfg.Routes)
+		if err != nil {
+			return nil
+		}
+		p.routes = m
+	}
+	return p
+}
+
+// notModified tags a 200 answer to a GET with an ETag, computed over
+// body unless PHP sent one, and reports whether the client already has
+// it; the caller then sends nothing more. h holds the response headers
+// as they will be sent. Responses that set a cookie or must not be
+// stored are left alone: their body is not the same for everyone.
+//
+// The tag is computed over the body PHP produced. CompressionMiddleware
+// weakens it when it gzips the body, and If-None-Match is compared
+// weakly, so a client holding the compressed copy gets its 304 too.
+func (p *etagPolicy) notModified(w http.ResponseWriter, req *http.Request, status int, body []byte) bool {
+	if p == nil || status != http.StatusOK || req.Method != http.MethodGet {
+		return false
+	}
+	if p.routes != nil && p.routes.Match(clientPath(req)) < 0 {
+		return false
+	}
+	h := w.Header()
+	if h.Get("Set-Cookie") != "" || hasCacheDirective(h, "no-store") {
+		return false
+	}
+	tag := h.Get("ETag")
+	if tag == "" {
+		tag = bodyETag(body)
+		h.Set("ETag", tag)
+	}
+	if !etagListMatches(req.Header.Get("If-None-Match"), tag) {
+		return false
+	}
+
+	// As http.ServeContent does: the headers describing the body go,
+	// the ones a cache updates its copy with stay.
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	delete(h, "Content-Encoding")
+	h.Del("Last-Modified")
+	w.WriteHeader(http.StatusNotModified)
+	etagNotModified.Inc()
+	etagBytesSaved.Add(float64(len(body)))
+	return true
+}
+
+// bodyETag returns the strong entity tag of body.
+func bodyETag(body []byte) string {
+	hash := sha256.New()
+	hash.Write(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)[:etagLength]) + `"`
+}
+
+// hasCacheDirective reports whether the Cache-Control headers in h carry
+// directive.
+func hasCacheDirective(h http.Header, directive string) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for d := range strings.SplitSeq(v, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if strings.EqualFold(name, directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// etagListMatches reports whether the If-None-Match value list names tag,
+// by the weak comparison RFC 9110 section 13.1.2 asks for: W/ prefixes are
+// ignored. "*" matches any tag.
+func etagListMatches(list, tag string) bool {
+	tag = strings.TrimPrefix(tag, "W/")
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return false
+		}
+		if list[0] == '*' {
+			return true
+		}
+		list = strings.TrimPrefix(list, "W/")
+		if len(list) < 2 || list[0] != '"' {
+			return false
+		}
+		end := strings.IndexByte(list[1:], '"')
+		if end < 0 {
+			return false
+		}
+		if list[:end+2] == tag {
+			return true
+		}
+		list = list[end+2:]
+	}
+}
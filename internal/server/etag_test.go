@@ -0,0 +1,110 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// cmsPool answers like a CMS rendering a full page, with the headers
+// set per request path.
+type cmsPool struct {
+	stubPool
+	headers map[string]map[string]string
+}
+
+func (p *cmsPool) Exec(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+	h := map[string]string{"Content-Type": "text/html"}
+	for k, v := range p.headers[ctx.Server["REQUEST_URI"]] {
+		h[k] = v
+	}
+	return &phpengine.Response{Status: http.StatusOK, Headers: h, Body: []byte(strings.Repeat("<p>page</p>", 200))}, nil
+}
+
+func TestETag(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.ETag.Enabled = true
+	cfg.ETag.Routes = []string{"/blog/:slug", "/"}
+	pool := &cmsPool{stubPool: stubPool{workers: 1}, headers: map[string]map[string]string{
+		"/blog/cookie":   {"Set-Cookie": "session=abc"},
+		"/blog/no-store": {"Cache-Control": "private, no-store"},
+		"/blog/own":      {"ETag": `"v7"`},
+	}}
+	r := NewRouter(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h := CompressionMiddleware(config.Default().Compression)(r)
+
+	get := func(target string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("/blog/hello")
+	tag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || !strings.HasPrefix(tag, `"`) {
+		t.Fatalf("first request: %d, ETag %q", first.Code, tag)
+	}
+	if again := get("/blog/hello").Header().Get("ETag"); again != tag {
+		t.Errorf("ETag changed for the same body: %q, then %q", tag, again)
+	}
+
+	rec := get("/blog/hello", "If-None-Match", `"other", `+tag)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("If-None-Match %s: %d with %d bytes, want an empty 304", tag, rec.Code, rec.Body.Len())
+	}
+	if rec.Header().Get("ETag") != tag || rec.Header().Get("Content-Type") != "" {
+		t.Errorf("304 headers: %v", rec.Header())
+	}
+	if rec := get("/blog/hello", "If-None-Match", `"other"`); rec.Code != http.StatusOK {
+		t.Errorf("If-None-Match of another tag: %d", rec.Code)
+	}
+	if rec := get("/blog/hello", "If-None-Match", "*"); rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match *: %d", rec.Code)
+	}
+
+	// The tag is over the body PHP produced: gzipping weakens it, and the
+	// weak tag a client then holds still gets its 304.
+	gz := get("/blog/hello", "Accept-Encoding", "gzip")
+	if gz.Header().Get("Content-Encoding") != "gzip" || gz.Header().Get("ETag") != "W/"+tag {
+		t.Fatalf("gzipped: Content-Encoding %q, ETag %q; want W/%s", gz.Header().Get("Content-Encoding"), gz.Header().Get("ETag"), tag)
+	}
+	if rec := get("/blog/hello", "Accept-Encoding", "gzip", "If-None-Match", "W/"+tag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("If-None-Match W/%s: %d with %d bytes", tag, rec.Code, rec.Body.Len())
+	}
+
+	// PHP's own ETag is kept and honored.
+	if rec := get("/blog/own", "If-None-Match", `"v7"`); rec.Code != http.StatusNotModified {
+		t.Errorf("PHP's ETag: %d", rec.Code)
+	}
+
+	for _, target := range []string{"/blog/cookie", "/blog/no-store", "/shop/cart"} {
+		rec := get(target, "If-None-Match", "*")
+		if rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" {
+			t.Errorf("%s: %d, ETag %q; want 200 untagged", target, rec.Code, rec.Header().Get("ETag"))
+		}
+	}
+	req := httptest.NewRequest("POST", "/blog/hello", strings.NewReader("a=b"))
+	req.Header.Set("If-None-Match", "*")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("POST: %d", rec.Code)
+	}
+
+	// etag is hot-reloaded.
+	cfg.ETag.Enabled = false
+	r.SetConfig(cfg)
+	if rec := get("/blog/hello", "If-None-Match", tag); rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" {
+		t.Errorf("disabled: %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
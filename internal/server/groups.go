@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/pool"
+)
+
+var groupReloads = metrics.NewCounterVec("maboo_worker_group_reloads_total",
+	"Worker reloads by group and result (ok, error).", "group", "result")
+
+// Values of the pool label on the worker metrics: the kind of work a
+// group's workers do.
+const (
+	poolDefault   = "default"   // HTTP requests
+	poolWebSocket = "websocket" // WebSocket events
+)
+
+// States of one worker group in /health and the admin API. A group's state
+// is its own: a degraded vhost does not make the server not ready.
+const (
+	groupReady    = "ready"     // has an idle worker, or is on demand and scaled to zero
+	groupDegraded = "degraded"  // every worker is busy; requests queue
+	groupNotReady = "not_ready" // has no workers, and is not waiting for a request to start one
+)
+
+// WorkerGroup is a pool of PHP workers reported on its own: under its
+// group and vhost labels in the worker metrics, as one of the admin API's
+// pools and in /health's groups.
+type WorkerGroup struct {
+	Name  string // group label, unique
+	Pool  string // pool label: default or websocket
+	VHost string // vhost label; "" unless the group serves one virtual host
+	Stats func() GroupStats
+
+	// Invoke runs internal requests naming the group; nil for a group
+	// that only speaks its own protocol, like websocket workers.
+	Invoke func(context.Context, phpengine.InternalRequest) (*phpengine.Response, error)
+
+	// Reload replaces the group's workers, leaving other groups alone;
+	// nil for a group that cannot be reloaded.
+	Reload func() error
+}
+
+// GroupStats is a snapshot of one group's workers.
+type GroupStats struct {
+	Workers  int
+	Busy     int
+	Idle     int
+	Requests int64
+	Dormant  bool // on demand and scaled to zero; see dormant
+}
+
+// state derives the group's readiness from its workers.
+func (s GroupStats) state() string {
+	switch {
+	case s.Dormant:
+		return groupReady
+	case s.Workers == 0:
+		return groupNotReady
+	case s.Idle == 0:
+		return groupDegraded
+	}
+	return groupReady
+}
+
+// httpGroup reports an HTTP pool as the group name.
+func httpGroup(name string, p Pool) WorkerGroup {
+	return WorkerGroup{Name: name, Pool: poolDefault, Invoke: p.Invoke, Reload: p.Reload, Stats: func() GroupStats {
+		s := p.Stats()
+		return GroupStats{Workers: s.TotalWorkers(), Busy: s.BusyWorkers(), Idle: s.IdleWorkers(), Requests: s.TotalRequests(), Dormant: dormant(p)}
+	}}
+}
+
+// processGroup reports a process worker pool as the group name.
+func processGroup(name, kind string, p *pool.Pool) WorkerGroup {
+	return WorkerGroup{Name: name, Pool: kind, Reload: p.Reload, Stats: func() GroupStats {
+		s := p.Stats()
+		return GroupStats{Workers: s.TotalWorkers, Busy: s.BusyWorkers, Idle: s.IdleWorkers, Requests: s.TotalRequests, Dormant: p.Dormant()}
+	}}
+}
+
+// workerGroups is the set of groups the metrics, the admin API and /health
+// report. A group removed on reload is gone from the next scrape: its
+// series are built from this set each time, never registered.
+type workerGroups struct {
+	mu     sync.RWMutex
+	groups []WorkerGroup // default first, then by name
+}
+
+// newWorkerGroups returns a set holding p as the default group, or an
+// empty set for a nil p.
+func newWorkerGroups(p Pool) *workerGroups {
+	g := &workerGroups{}
+	if p != nil {
+		g.add(httpGroup(poolDefault, p))
+	}
+	return g
+}
+
+// add registers wg, replacing a group of the same name.
+func (g *workerGroups) add(wg WorkerGroup) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.groups = slices.DeleteFunc(g.groups, func(o WorkerGroup) bool { return o.Name == wg.Name })
+	g.groups = append(g.groups, wg)
+	slices.SortStableFunc(g.groups, func(a, b WorkerGroup) int {
+		if (a.Name == poolDefault) != (b.Name == poolDefault) {
+			if a.Name == poolDefault {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// remove unregisters the group name; it reports whether there was one.
+func (g *workerGroups) remove(name string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := len(g.groups)
+	g.groups = slices.DeleteFunc(g.groups, func(o WorkerGroup) bool { return o.Name == name })
+	return len(g.groups) != n
+}
+
+// get returns the group name.
+func (g *workerGroups) get(name string) (WorkerGroup, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, wg := range g.groups {
+		if wg.Name == name {
+			return wg, true
+		}
+	}
+	return WorkerGroup{}, false
+}
+
+// list returns the registered groups.
+func (g *workerGroups) list() []WorkerGroup {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return slices.Clone(g.groups)
+}
+
+// status snapshots every group, as the admin API's pools and /health's
+// groups report them.
+func (g *workerGroups) status() []PoolStatus {
+	groups := g.list()
+	out := make([]PoolStatus, len(groups))
+	for i, wg := range groups {
+		s := wg.Stats()
+		out[i] = PoolStatus{
+			Name: wg.Name, Pool: wg.Pool, VHost: wg.VHost, Status: s.state(),
+			Workers: s.Workers, Busy: s.Busy, Idle: s.Idle, Requests: s.Requests,
+		}
+	}
+	return out
+}
+
+// invoke runs r in the group it names.
+func (g *workerGroups) invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	name := r.Group
+	if name == "" {
+		name = poolDefault
+	}
+	wg, ok := g.get(name)
+	if !ok {
+		return nil, fmt.Errorf("no worker group %q", name)
+	}
+	if wg.Invoke == nil {
+		return nil, fmt.Errorf("worker group %q does not run internal requests", name)
+	}
+	return wg.Invoke(ctx, r)
+}
+
+// Errors of reload, told apart by the admin API.
+var (
+	errUnknownGroup  = errors.New("no such worker group")
+	errNotReloadable = errors.New("worker group cannot be reloaded")
+)
+
+// reload replaces the workers of the group name, the default group when
+// it names none.
+func (g *workerGroups) reload(name string) error {
+	if name == "" {
+		name = poolDefault
+	}
+	wg, ok := g.get(name)
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownGroup, name)
+	}
+	if wg.Reload == nil {
+		return fmt.Errorf("%w: %q", errNotReloadable, name)
+	}
+	err := wg.Reload()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	groupReloads.WithLabelValues(name, result).Inc()
+	return err
+}
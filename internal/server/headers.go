@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var responseHeadersDropped = metrics.NewCounterVec("maboo_http_response_headers_dropped_total",
+	"Headers of PHP responses not sent to the client, by reason: hop_by_hop, internal, denied or invalid.", "reason")
+
+// Why a header of a PHP response was dropped.
+const (
+	dropHopByHop = "hop_by_hop" // meaningful for one connection only; net/http manages its own
+	dropInternal = "internal"   // one of response_headers.internal_prefixes
+	dropDenied   = "denied"     // hide_server or response_headers.deny
+	dropInvalid  = "invalid"    // a name or value that could inject headers
+)
+
+// hopByHop are the headers RFC 7230 section 6.1 reserves for a single
+// connection. Passed on, they contradict the framing net/http chooses
+// and are forbidden in HTTP/2 and HTTP/3.
+var hopByHop = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// headerFilter is response_headers, compiled.
+type headerFilter struct {
+	internal []string        // canonical prefixes
+	deny     map[string]bool // canonical names
+	allow    map[string]bool
+
+	preserveCase bool
+}
+
+func newHeaderFilter(cfg config.HeadersConfig) *headerFilter {
+	f := &headerFilter{deny: make(map[string]bool), allow: make(map[string]bool), preserveCase: cfg.PreserveCase}
+	for _, p := range cfg.InternalPrefixes {
+		f.internal = append(f.internal, http.CanonicalHeaderKey(p))
+	}
+	if cfg.HideServer {
+		f.deny["X-Powered-By"] = true
+		f.deny["Server"] = true
+	}
+	for _, name := range cfg.Deny {
+		f.deny[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range cfg.Allow {
+		f.allow[http.CanonicalHeaderKey(name)] = true
+	}
+	return f
+}
+
+// connectionTokens returns the headers a Connection header in headers
+// names, which are hop-by-hop too.
+func connectionTokens(headers map[string]string) map[string]bool {
+	var tokens map[string]bool
+	for k, v := range headers {
+		if !strings.EqualFold(k, "Connection") {
+			continue
+		}
+		for token := range strings.SplitSeq(v, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				if tokens == nil {
+					tokens = make(map[string]bool)
+				}
+				tokens[http.CanonicalHeaderKey(token)] = true
+			}
+		}
+	}
+	return tokens
+}
+
+// drop returns why the header name (canonical) of a PHP response must not
+// reach the client, or "" when it may. connection holds the names the
+// response's Connection header lists.
+func (f *headerFilter) drop(name string, connection map[string]bool) string {
+	if hopByHop[name] || connection[name] {
+		return dropHopByHop
+	}
+	if f.allow[name] {
+		return ""
+	}
+	if f.deny[name] {
+		return dropDenied
+	}
+	if hasAnyPrefix(name, f.internal) {
+		return dropInternal
+	}
+	return ""
+}
+
+// framingHeaders are the headers net/http reads back from the header map
+// as it writes a response, to frame it, sniff its type or date it. They
+// keep their canonical names, or net/http would add its own beside them.
+var framingHeaders = map[string]bool{
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Date":              true,
+	"Transfer-Encoding": true,
+}
+
+type headerCaseKey struct{}
+
+// headerCase holds the names a response's headers go out under, by
+// canonical name, as writeResponse finds them in the script's output.
+type headerCase struct {
+	names map[string]string
+}
+
+func headerCaseOf(ctx context.Context) *headerCase {
+	hc, _ := ctx.Value(headerCaseKey{}).(*headerCase)
+	return hc
+}
+
+// preserveHeaderCase sends PHP's response headers under the names the
+// script wrote, such as "x-Request-ID" or "ETag", while
+// response_headers.preserve_case is on. Everything up to here reads and
+// writes the header map by canonical name, as net/http does; the names
+// are only swapped in the map once the status line goes out, so it must
+// be outermost. HTTP/2 and HTTP/3 lower-case every name regardless.
+func preserveHeaderCase(r *Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !r.headers.Load().preserveCase || isUpgrade(req) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			hc := &headerCase{names: make(map[string]string)}
+			cw := &caseWriter{ResponseWriter: w, names: hc.names}
+			next.ServeHTTP(cw, req.WithContext(context.WithValue(req.Context(), headerCaseKey{}, hc)))
+		})
+	}
+}
+
+// caseWriter renames headers in the map of the writer it wraps just
+// before the final response's headers are written.
+type caseWriter struct {
+	http.ResponseWriter
+	names map[string]string
+	done  bool
+}
+
+func (cw *caseWriter) restore() {
+	if cw.done {
+		return
+	}
+	cw.done = true
+	h := cw.ResponseWriter.Header()
+	for canonical, name := range cw.names {
+		if v, ok := h[canonical]; ok && !framingHeaders[canonical] {
+			delete(h, canonical)
+			h[name] = v
+		}
+	}
+}
+
+func (cw *caseWriter) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints go out with the
+	// headers set so far, which the final response still needs.
+	if code >= 200 {
+		cw.restore()
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *caseWriter) Write(b []byte) (int, error) {
+	cw.restore()
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends the headers, restored, if they have not gone out yet.
+func (cw *caseWriter) Flush() {
+	cw.restore()
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (cw *caseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
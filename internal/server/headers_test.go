@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// maliciousHeaders is what a compromised or careless script might send.
+// Keys are as a worker process may send them, not canonicalized.
+var maliciousHeaders = map[string]string{
+	"Content-Type":          "text/plain",
+	"X-Request-Cost":        "3",
+	"connection":            "close, X-Backend-Secret",
+	"X-Backend-Secret":      "db=prod-primary",
+	"Transfer-Encoding":     "chunked",
+	"Keep-Alive":            "timeout=5",
+	"Upgrade":               "h2c",
+	"x-maboo-cache":         "HIT",
+	"X-Maboo-Internal-Auth": "token",
+	"X-Powered-By":          "PHP/8.3.4",
+	"Server":                "Apache/2.4.1",
+	"X-Split":               "a\r\nSet-Cookie: admin=1",
+	"Bad Name":              "v",
+}
+
+type headersPool struct{ stubPool }
+
+func (p *headersPool) Exec(*phpengine.Context, string) (*phpengine.Response, error) {
+	return &phpengine.Response{Status: http.StatusOK, Headers: maliciousHeaders, Body: []byte("body")}, nil
+}
+
+func TestResponseHeaderFilter(t *testing.T) {
+	cfg := appConfig(t)
+	r := NewRouter(cfg, &headersPool{stubPool{workers: 1}}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	get := func(t *testing.T) *http.Response {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "body" {
+			t.Errorf("body %q, want the response framed as net/http chose", body)
+		}
+		return resp
+	}
+
+	resp := get(t)
+	for _, name := range []string{"X-Backend-Secret", "Keep-Alive", "Upgrade", "X-Maboo-Cache", "X-Maboo-Internal-Auth", "X-Split", "Set-Cookie", "Bad Name"} {
+		if v := resp.Header.Get(name); v != "" {
+			t.Errorf("%s: %q reached the client", name, v)
+		}
+	}
+	if len(resp.TransferEncoding) > 0 || resp.Close {
+		t.Errorf("PHP's framing reached the client: Transfer-Encoding %v, close %v", resp.TransferEncoding, resp.Close)
+	}
+	for name, want := range map[string]string{"Content-Type": "text/plain", "X-Request-Cost": "3", "X-Powered-By": "PHP/8.3.4", "Server": "Apache/2.4.1"} {
+		if got := resp.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// hide_server, deny and allow apply on the next request.
+	cfg.Headers.HideServer = true
+	cfg.Headers.Deny = []string{"x-request-cost"}
+	cfg.Headers.Allow = []string{"X-Maboo-Cache"}
+	r.SetConfig(cfg)
+	resp = get(t)
+	for _, name := range []string{"X-Powered-By", "Server", "X-Request-Cost", "X-Maboo-Internal-Auth"} {
+		if v := resp.Header.Get(name); v != "" {
+			t.Errorf("%s: %q reached the client", name, v)
+		}
+	}
+	if v := resp.Header.Get("X-Maboo-Cache"); v != "HIT" {
+		t.Errorf("allowed X-Maboo-Cache = %q", v)
+	}
+	if v := resp.Header.Get("Connection"); strings.Contains(v, "X-Backend-Secret") {
+		t.Errorf("Connection %q", v)
+	}
+}
+
+// casedPool answers with headers cased and ordered as a script wrote them.
+type casedPool struct{ stubPool }
+
+var casedBody = strings.Repeat("<p>hello</p>", 200)
+
+func (p *casedPool) Exec(*phpengine.Context, string) (*phpengine.Response, error) {
+	fields := []phpengine.HeaderField{
+		{Name: "content-type", Value: "text/html"},
+		{Name: "x-Request-ID", Value: "7"},
+		{Name: "ETag", Value: `"v1"`},
+		{Name: "Set-Cookie", Value: "b=2"},
+		{Name: "set-cookie", Value: "a=1"},
+		{Name: "x-maboo-cache", Value: "HIT"},
+	}
+	headers := make(map[string]string, len(fields))
+	for _, f := range fields {
+		headers[f.Name] = f.Value
+	}
+	return &phpengine.Response{Status: http.StatusOK, Headers: headers, Fields: fields, Body: []byte(casedBody)}, nil
+}
+
+// rawGet sends a GET asking for gzip and returns the response's header
+// block as sent, and the response.
+func rawGet(t *testing.T, addr, path string) (string, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n", path)
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _, _ := bytes.Cut(raw, []byte("\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(block) + "\r\n", resp
+}
+
+func TestResponseHeaderCase(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Compression.Enabled = true
+	cfg.Metrics.Enabled = true
+	cfg.Headers.PreserveCase = true
+	s := New(cfg, &casedPool{stubPool{workers: 1}}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv := httptest.NewServer(s.http.Handler)
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	block, resp := rawGet(t, addr, "/")
+	for _, line := range []string{
+		"\r\nx-Request-ID: 7\r\n",
+		"\r\nETag: W/\"v1\"\r\n", // weakened by compression
+		"\r\nSet-Cookie: b=2\r\nSet-Cookie: a=1\r\n",
+		// Added by compression, and read back by net/http, under their
+		// canonical names.
+		"\r\nContent-Type: text/html\r\n",
+		"\r\nContent-Encoding: gzip\r\n",
+		"\r\nVary: Accept-Encoding\r\n",
+	} {
+		if !strings.Contains(block, line) {
+			t.Errorf("missing %q in:\n%s", line, block)
+		}
+	}
+	for _, name := range []string{"X-Request-Id:", "Etag:", "x-maboo-cache:"} {
+		if strings.Contains(block, "\r\n"+name) {
+			t.Errorf("%s sent in:\n%s", name, block)
+		}
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != casedBody {
+		t.Errorf("body %d bytes, want %d", len(body), len(casedBody))
+	}
+
+	// The flag applies on the next request.
+	cfg.Headers.PreserveCase = false
+	s.router.SetConfig(cfg)
+	block, _ = rawGet(t, addr, "/")
+	if !strings.Contains(block, "\r\nX-Request-Id: 7\r\n") || !strings.Contains(block, "\r\nEtag: ") {
+		t.Errorf("canonical names expected with the flag off:\n%s", block)
+	}
+
+	// The metrics wrapper saw both responses.
+	resp, err = srv.Client().Get(srv.URL + cfg.Metrics.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	metrics, _ := io.ReadAll(resp.Body)
+	if want := `maboo_http_requests_total{method="GET",route="other",status="200"} 2`; !strings.Contains(string(metrics), want) {
+		t.Errorf("metrics lack %q:\n%s", want, metrics)
+	}
+}
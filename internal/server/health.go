@@ -4,71 +4,230 @@ import (
 	"encoding/json"
 	"net/http"
 	"runtime"
+	"sync/atomic"
 	"time"
+
+	"github.com/sadewadee/maboo/internal/buildinfo"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/jobs"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/pool"
 )
 
 var startTime = time.Now()
 
+// Health statuses besides ok, ready and not_ready.
+const (
+	statusEntryMissing   = "entry_missing"    // the entry script is missing
+	statusShuttingDown   = "shutting_down"    // Stop has begun
+	statusSelfTestFailed = "self_test_failed" // the last self-test failed
+)
+
 // HealthHandler serves health check and readiness endpoints.
 type HealthHandler struct {
-	pool Pool
+	pool    Pool
+	groups  *workerGroups // reported per group; the server's, once attached
+	probes  *ProbeChecker
+	watcher atomic.Pointer[pool.Watcher] // nil while files are not watched
+	jobs    *jobs.Manager
+	build   buildinfo.Info
+	sizing  func() config.PoolSizing // the running config's, once attached
+	entry   func() error             // the entry script's state, once attached
+
+	selfTest     atomic.Pointer[SelfTestResult] // nil until run; readiness fails while the last one failed
+	shuttingDown atomic.Bool                    // set by Server.Stop; readiness fails from then on
 }
 
 // NewHealthHandler creates a new health check handler.
 func NewHealthHandler(p Pool) *HealthHandler {
-	return &HealthHandler{pool: p}
+	return &HealthHandler{pool: p, groups: newWorkerGroups(p), build: buildinfo.Get()}
+}
+
+// SetProbes attaches dependency probes whose aggregate state gates
+// readiness.
+func (h *HealthHandler) SetProbes(pc *ProbeChecker) {
+	h.probes = pc
+}
+
+// SetWatcher attaches the file watcher reported in the verbose output;
+// nil detaches it.
+func (h *HealthHandler) SetWatcher(w *pool.Watcher) {
+	h.watcher.Store(w)
+}
+
+// SetJobs attaches the job manager whose processes are reported under
+// jobs. Jobs do not gate readiness.
+func (h *HealthHandler) SetJobs(m *jobs.Manager) {
+	h.jobs = m
 }
 
 func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/ready", "/readyz":
-		h.readiness(w)
+		h.readiness(w, isVerbose(r))
 	default:
-		h.liveness(w)
+		h.liveness(w, isVerbose(r))
 	}
 }
 
-func (h *HealthHandler) liveness(w http.ResponseWriter) {
+// isVerbose reports whether the caller asked for the detailed body with
+// ?verbose (any value other than 0 or false).
+func isVerbose(r *http.Request) bool {
+	if !r.URL.Query().Has("verbose") {
+		return false
+	}
+	v := r.URL.Query().Get("verbose")
+	return v != "0" && v != "false"
+}
+
+// entryErr returns the error of a missing entry script, if any.
+func (h *HealthHandler) entryErr() error {
+	if h.entry == nil {
+		return nil
+	}
+	return h.entry()
+}
+
+// liveness answers 200 while the process runs. A missing entry script is
+// reported as entry_missing, but it is left to readiness to take the
+// instance out of rotation: restarting does not bring the script back.
+// The same goes for a failed self-test.
+func (h *HealthHandler) liveness(w http.ResponseWriter, verbose bool) {
+	status := "ok"
+	entryErr := h.entryErr()
+	if entryErr != nil {
+		status = statusEntryMissing
+	}
+	body := map[string]interface{}{
+		"status": status,
+		"uptime": time.Since(startTime).String(),
+		"build":  h.build,
+	}
+	if entryErr != nil {
+		body["error"] = entryErr.Error()
+	}
+	if st := h.selfTest.Load(); st != nil {
+		body["self_test"] = st
+	}
+	if verbose {
+		stats := h.pool.Stats()
+		body["workers"] = map[string]interface{}{
+			"total":    stats.TotalWorkers(),
+			"busy":     stats.BusyWorkers(),
+			"idle":     stats.IdleWorkers(),
+			"requests": stats.TotalRequests(),
+		}
+		body["groups"] = h.groups.status()
+		body["resources"] = h.resources()
+		body["worker_recycles_last_hour"] = metrics.RecentWorkerRecycles()
+		if h.probes != nil {
+			body["checks"] = h.probes.Statuses()
+		}
+		if w := h.watcher.Load(); w != nil {
+			body["watcher"] = w.Status()
+		}
+		if h.jobs != nil {
+			body["jobs"] = h.jobs.Statuses()
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status": "ok",
-		"uptime": time.Since(startTime).String(),
-	})
+	json.NewEncoder(w).Encode(body)
+}
+
+// resources reports the container limits found at startup, GOMAXPROCS,
+// and the pool sizes with whether they were derived from the limits.
+func (h *HealthHandler) resources() map[string]interface{} {
+	var sizing config.PoolSizing
+	if h.sizing != nil {
+		sizing = h.sizing()
+	}
+	return map[string]interface{}{
+		"limits":     sizing.Limits,
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"cpus":       runtime.NumCPU(),
+		"pool":       sizing,
+	}
+}
+
+// ready reports whether the pool has workers, or is on demand and scaled
+// to zero, the probes and the last self-test pass, and the server is not
+// shutting down. Other groups only report their own state in groups.
+func (h *HealthHandler) ready() bool {
+	if h.shuttingDown.Load() || (h.pool.Stats().TotalWorkers() == 0 && !dormant(h.pool)) {
+		return false
+	}
+	if st := h.selfTest.Load(); st != nil && !st.Passed {
+		return false
+	}
+	return h.probes == nil || h.probes.Ready()
 }
 
-func (h *HealthHandler) readiness(w http.ResponseWriter) {
+func (h *HealthHandler) readiness(w http.ResponseWriter, verbose bool) {
 	stats := h.pool.Stats()
 
-	ready := stats.TotalWorkers() > 0
 	status := http.StatusOK
 	statusStr := "ready"
-	if !ready {
+	entryErr := h.entryErr()
+	selfTest := h.selfTest.Load()
+	switch {
+	case h.shuttingDown.Load():
+		status = http.StatusServiceUnavailable
+		statusStr = statusShuttingDown
+	case entryErr != nil:
+		status = http.StatusServiceUnavailable
+		statusStr = statusEntryMissing
+	case selfTest != nil && !selfTest.Passed:
+		status = http.StatusServiceUnavailable
+		statusStr = statusSelfTestFailed
+	case !h.ready():
 		status = http.StatusServiceUnavailable
 		statusStr = "not_ready"
 	}
 
-	var mem runtime.MemStats
-	runtime.ReadMemStats(&mem)
+	rt := metrics.ReadRuntime()
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	body := map[string]interface{}{
 		"status":         statusStr,
 		"uptime":         time.Since(startTime).String(),
 		"uptime_seconds": time.Since(startTime).Seconds(),
 		"workers": map[string]interface{}{
-			"total":      stats.TotalWorkers(),
-			"busy":       stats.BusyWorkers(),
-			"idle":       stats.IdleWorkers(),
-			"requests":   stats.TotalRequests(),
+			"total":    stats.TotalWorkers(),
+			"busy":     stats.BusyWorkers(),
+			"idle":     stats.IdleWorkers(),
+			"requests": stats.TotalRequests(),
 		},
 		"memory": map[string]interface{}{
-			"alloc_mb":  mem.Alloc / 1024 / 1024,
-			"sys_mb":    mem.Sys / 1024 / 1024,
-			"gc_cycles": mem.NumGC,
+			"alloc_mb":  rt.HeapAlloc / 1024 / 1024,
+			"sys_mb":    rt.TotalMemory / 1024 / 1024,
+			"gc_cycles": rt.GCCycles,
 		},
+		"groups":     h.groups.status(),
+		"resources":  h.resources(),
 		"go_version": runtime.Version(),
-		"goroutines": runtime.NumGoroutine(),
-	})
+		"goroutines": rt.Goroutines,
+	}
+	if verbose {
+		body["worker_recycles_last_hour"] = metrics.RecentWorkerRecycles()
+		if w := h.watcher.Load(); w != nil {
+			body["watcher"] = w.Status()
+		}
+	}
+	if h.probes != nil {
+		body["checks"] = h.probes.Statuses()
+	}
+	if h.jobs != nil {
+		body["jobs"] = h.jobs.Statuses()
+	}
+	if entryErr != nil {
+		body["error"] = entryErr.Error()
+	}
+	if selfTest != nil {
+		body["self_test"] = selfTest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
 }
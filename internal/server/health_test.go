@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sadewadee/maboo/internal/cgroup"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/jobs"
+)
+
+func TestHealthReportsBuild(t *testing.T) {
+	h := NewHealthHandler(&stubPool{workers: 1})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+
+	var body struct {
+		Build struct {
+			Version     string   `json:"version"`
+			GoVersion   string   `json:"go_version"`
+			PHPVersions []string `json:"php_versions"`
+		} `json:"build"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Build.Version == "" || body.Build.GoVersion == "" || body.Build.PHPVersions == nil {
+		t.Errorf("build = %+v", body.Build)
+	}
+}
+
+func TestHealthWorkerGroups(t *testing.T) {
+	s := New(appConfig(t), &stubPool{workers: 2}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	shop := GroupStats{Workers: 2, Busy: 2, Requests: 7}
+	s.AddWorkerGroup(WorkerGroup{Name: "shop", Pool: poolDefault, VHost: "shop.example.com", Stats: func() GroupStats { return shop }})
+
+	get := func() (int, []PoolStatus) {
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+		var body struct {
+			Groups []PoolStatus `json:"groups"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, body.Groups
+	}
+
+	// A vhost without an idle worker is degraded on its own.
+	code, groups := get()
+	if code != http.StatusOK {
+		t.Errorf("status %d with one group degraded, want 200", code)
+	}
+	want := []PoolStatus{
+		{Name: "default", Pool: poolDefault, Status: groupReady, Workers: 2, Idle: 2, Requests: 42},
+		{Name: "shop", Pool: poolDefault, VHost: "shop.example.com", Status: groupDegraded, Workers: 2, Busy: 2, Requests: 7},
+	}
+	if !slices.Equal(groups, want) {
+		t.Errorf("groups = %+v, want %+v", groups, want)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s.metrics)
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+	const line = `maboo_workers_busy{group="shop",pool="default",vhost="shop.example.com"} 2`
+	if body := scrape(); !strings.Contains(body, line+"\n") {
+		t.Errorf("missing line %q in:\n%s", line, body)
+	}
+
+	// A removed group leaves every report.
+	if !s.RemoveWorkerGroup("shop") || s.RemoveWorkerGroup("shop") {
+		t.Error("RemoveWorkerGroup did not report the group exactly once")
+	}
+	if _, groups := get(); len(groups) != 1 || groups[0].Name != "default" {
+		t.Errorf("groups after removal = %+v", groups)
+	}
+	if body := scrape(); strings.Contains(body, `group="shop"`) {
+		t.Errorf("removed group still scraped:\n%s", body)
+	}
+	if r := s.Status(); len(r.Pools) != 1 {
+		t.Errorf("admin pools after removal = %+v", r.Pools)
+	}
+}
+
+// onDemandPool is a pool with pool.on_demand that has scaled to zero, its
+// last cold start having succeeded or not.
+type onDemandPool struct {
+	stubPool
+	healthy bool
+}
+
+func (p *onDemandPool) Dormant() bool { return p.healthy }
+
+func TestReadinessOnDemandPool(t *testing.T) {
+	for _, tt := range []struct {
+		healthy bool
+		code    int
+		group   string
+	}{
+		{true, http.StatusOK, groupReady},
+		{false, http.StatusServiceUnavailable, groupNotReady},
+	} {
+		h := NewHealthHandler(&onDemandPool{healthy: tt.healthy})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+		var body struct {
+			Groups []PoolStatus `json:"groups"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.code || len(body.Groups) != 1 || body.Groups[0].Status != tt.group {
+			t.Errorf("healthy %v: status %d, groups %+v; want %d and %s", tt.healthy, rec.Code, body.Groups, tt.code, tt.group)
+		}
+	}
+}
+
+func TestHealthReportsJobs(t *testing.T) {
+	s := New(appConfig(t), &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m, err := jobs.New([]config.JobConfig{{Name: "queue", Script: "artisan", Count: 2}}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetJobs(m)
+
+	for _, path := range []string{"/ready", "/health?verbose=1"} {
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var body struct {
+			Status string        `json:"status"`
+			Jobs   []jobs.Status `json:"jobs"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK || len(body.Jobs) != 1 || body.Jobs[0].Name != "queue" || len(body.Jobs[0].Processes) != 2 {
+			t.Errorf("%s: status %d, jobs %+v", path, rec.Code, body.Jobs)
+		}
+	}
+	if r := s.Status(); len(r.Jobs) != 1 || r.Jobs[0].Count != 2 {
+		t.Errorf("admin jobs = %+v", r.Jobs)
+	}
+}
+
+func TestHealthReportsResources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maboo.yaml")
+	if err := os.WriteFile(path, []byte("static:\n  root: \"\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	limits := cgroup.Limits{Version: 2, CPU: 1.5, Memory: 512 << 20}
+	cfg, err := config.LoadWithOptions(path, config.Options{Limits: &limits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, target := range []string{"/ready", "/health?verbose"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		var body struct {
+			Resources struct {
+				Limits     cgroup.Limits     `json:"limits"`
+				GOMAXPROCS int               `json:"gomaxprocs"`
+				Pool       config.PoolSizing `json:"pool"`
+			} `json:"resources"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		res := body.Resources
+		if res.Limits != limits || res.GOMAXPROCS < 1 {
+			t.Errorf("%s: resources = %+v", target, res)
+		}
+		if p := res.Pool; !p.Derived || p.MinWorkers != 4 || p.MaxWorkers != 4 || p.WorkerMemory != 128<<20 {
+			t.Errorf("%s: pool = %+v, want 4-4 workers derived at 128M each", target, p)
+		}
+	}
+}
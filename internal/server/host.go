@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/clientip"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// acmePath is where ACME HTTP-01 challenges are answered; a certificate
+// authority must reach it under every host it validates.
+const acmePath = "/.well-known/acme-challenge/"
+
+// canonicalHost is server.canonical_host, parsed.
+type canonicalHost struct {
+	clients *clientip.Resolver
+	host    string // lowercased, without a trailing dot
+	port    string // "" matches any port
+	target  string // host and port as written into redirects
+}
+
+// newCanonicalHost returns nil when server.canonical_host is unset.
+func newCanonicalHost(cfg *config.Config) *canonicalHost {
+	h := cfg.Server.CanonicalHost
+	if h == "" {
+		return nil
+	}
+	clients, _ := clientip.New(cfg.Server.TrustedProxies)
+	c := &canonicalHost{clients: clients, target: strings.TrimSuffix(strings.ToLower(h), ".")}
+	c.host = c.target
+	if host, port, err := net.SplitHostPort(c.target); err == nil {
+		c.host, c.port = strings.TrimSuffix(host, "."), port
+		c.target = net.JoinHostPort(c.host, port)
+	}
+	return c
+}
+
+// redirect answers a request for another host with a 308 to the same path
+// and query on the canonical host, and reports whether it did. 308 keeps
+// the method and body. ACME challenges are served under every host, and a
+// nil canonicalHost redirects nothing.
+func (c *canonicalHost) redirect(w http.ResponseWriter, req *http.Request) bool {
+	if c == nil || strings.HasPrefix(req.URL.Path, acmePath) {
+		return false
+	}
+	host, port := c.clients.Host(req)
+	if strings.TrimSuffix(strings.ToLower(host), ".") == c.host && (c.port == "" || port == c.port) {
+		return false
+	}
+	target := c.clients.Proto(req) + "://" + c.target + req.URL.RequestURI()
+	http.Redirect(w, req, target, http.StatusPermanentRedirect)
+	return true
+}
+
+// setHost gives PHP the host and port the client asked for, which behind a
+// trusted proxy are those of X-Forwarded-Host and X-Forwarded-Port rather
+// than the proxy's own name for maboo.
+func setHost(ctx *phpengine.Context, clients *clientip.Resolver, req *http.Request) {
+	host, port := clients.Host(req)
+	ctx.Server["SERVER_NAME"] = host
+	ctx.Server["SERVER_PORT"] = port
+	if port == defaultPort(clients.Proto(req)) {
+		ctx.Server["HTTP_HOST"] = host
+	} else {
+		ctx.Server["HTTP_HOST"] = net.JoinHostPort(host, port)
+	}
+}
+
+// origin returns the scheme, host and port of req as setHost gives them
+// to PHP, for keying responses that may differ between them.
+func origin(clients *clientip.Resolver, req *http.Request) string {
+	host, port := clients.Host(req)
+	return clients.Proto(req) + "://" + net.JoinHostPort(host, port)
+}
+
+// defaultPort returns the port a URL with scheme omits.
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/protocol"
+)
+
+// serverPool records the $_SERVER of the last request it ran.
+type serverPool struct {
+	stubPool
+	mu     sync.Mutex
+	server map[string]string
+}
+
+func (p *serverPool) Exec(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+	p.mu.Lock()
+	p.server = ctx.Server
+	p.mu.Unlock()
+	return &phpengine.Response{Status: http.StatusOK, Body: []byte("php")}, nil
+}
+
+func (p *serverPool) Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	return phpengine.Invoke(ctx, p, "", r)
+}
+
+func TestForwardedHost(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	pool := &serverPool{stubPool: stubPool{workers: 1}}
+	r := NewRouter(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	forwarded := map[string]string{"X-Forwarded-Host": "shop.example.com", "X-Forwarded-Port": "443", "X-Forwarded-Proto": "https"}
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    [3]string // SERVER_NAME, SERVER_PORT, HTTP_HOST
+	}{
+		{"direct", "203.0.113.7:5000", nil, [3]string{"maboo-svc", "8080", "maboo-svc:8080"}},
+		{"trusted proxy", "10.1.2.3:5000", forwarded, [3]string{"shop.example.com", "443", "shop.example.com"}},
+		{"untrusted proxy", "203.0.113.7:5000", forwarded, [3]string{"maboo-svc", "8080", "maboo-svc:8080"}},
+		{"trusted, other port", "10.1.2.3:5000", map[string]string{"X-Forwarded-Host": "shop.example.com:8443"}, [3]string{"shop.example.com", "8443", "shop.example.com:8443"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = "maboo-svc:8080"
+			req.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			pool.mu.Lock()
+			srv := pool.server
+			pool.mu.Unlock()
+			got := [3]string{srv["SERVER_NAME"], srv["SERVER_PORT"], srv["HTTP_HOST"]}
+			if got != tt.want {
+				t.Errorf("SERVER_NAME, SERVER_PORT, HTTP_HOST = %q, want %q", got, tt.want)
+			}
+
+			// Worker processes build their $_SERVER from the same values.
+			h := requestHeader(&phpengine.Context{Server: srv}, "index.php")
+			if h.ServerName != tt.want[0] || h.ServerPort != tt.want[1] || h.Headers["Host"] != tt.want[2] {
+				t.Errorf("worker request: server %s:%s, Host %q; want %q", h.ServerName, h.ServerPort, h.Headers["Host"], tt.want)
+			}
+		})
+	}
+}
+
+// A proxy list that does not parse is logged and leaves the proxies
+// trusted so far in place.
+func TestForwardedHostBadProxies(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	pool := &serverPool{stubPool: stubPool{workers: 1}}
+	var logs strings.Builder
+	r := NewRouter(cfg, pool, slog.New(slog.NewTextHandler(&logs, nil)))
+
+	next := *cfg
+	next.Server.TrustedProxies = []string{"lb.internal"}
+	r.SetConfig(&next)
+	if !strings.Contains(logs.String(), "server.trusted_proxies not applied") {
+		t.Errorf("logs = %q, want the error", logs.String())
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	req.Header.Set("X-Forwarded-Host", "shop.example.com")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if got := pool.server["SERVER_NAME"]; got != "shop.example.com" {
+		t.Errorf("SERVER_NAME = %q, want the one 10.0.0.0/8 forwarded", got)
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Server.CanonicalHost = "example.com"
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	r := NewRouter(cfg, &serverPool{stubPool: stubPool{workers: 1}}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name, method, target, host, remote string
+		headers                            map[string]string
+		location                           string // "" when served
+	}{
+		{"canonical", "GET", "/shop", "example.com", "203.0.113.7:5000", nil, ""},
+		{"canonical with port and dot", "GET", "/", "Example.COM.:8080", "203.0.113.7:5000", nil, ""},
+		{"www", "GET", "/shop/cart?item=3&qty=1", "www.example.com", "203.0.113.7:5000", nil, "http://example.com/shop/cart?item=3&qty=1"},
+		{"old domain, POST", "POST", "/orders", "old-shop.test", "203.0.113.7:5000", nil, "http://example.com/orders"},
+		{"trusted proxy", "GET", "/", "maboo-svc:8080", "10.1.2.3:5000", map[string]string{"X-Forwarded-Host": "example.com"}, ""},
+		{"trusted proxy, www", "GET", "/a?b", "maboo-svc:8080", "10.1.2.3:5000", map[string]string{"X-Forwarded-Host": "www.example.com", "X-Forwarded-Proto": "https"}, "https://example.com/a?b"},
+		{"untrusted forwarded host", "GET", "/", "www.example.com", "203.0.113.7:5000", map[string]string{"X-Forwarded-Host": "example.com"}, "http://example.com/"},
+		{"health", "GET", "/ready", "10.0.0.5:8080", "203.0.113.7:5000", nil, ""},
+		{"acme", "GET", acmePath + "token", "www.example.com", "203.0.113.7:5000", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader("a=b"))
+			req.Host = tt.host
+			req.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if tt.location == "" {
+				if rec.Code == http.StatusPermanentRedirect {
+					t.Errorf("redirected to %s", rec.Header().Get("Location"))
+				}
+				return
+			}
+			if rec.Code != http.StatusPermanentRedirect {
+				t.Fatalf("status %d, want 308", rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.location {
+				t.Errorf("Location %q, want %q", loc, tt.location)
+			}
+		})
+	}
+
+	// A port in canonical_host must match too.
+	cfg.Server.CanonicalHost = "example.com:8443"
+	r.SetConfig(cfg)
+	req := httptest.NewRequest("GET", "/x", nil)
+	req.Host = "example.com:8080"
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusPermanentRedirect || loc != "http://example.com:8443/x" {
+		t.Errorf("other port: %d to %q, want 308 to http://example.com:8443/x", rec.Code, loc)
+	}
+}
+
+func TestRequestProtocol(t *testing.T) {
+	pool := &serverPool{stubPool: stubPool{workers: 1}}
+	r := NewRouter(appConfig(t), pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	plain := httptest.NewServer(r)
+	defer plain.Close()
+	tlsSrv := httptest.NewTLSServer(r)
+	defer tlsSrv.Close()
+	h2 := httptest.NewUnstartedServer(r)
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	tests := []struct {
+		name   string
+		srv    *httptest.Server
+		want   map[string]string // "" for absent
+		header protocol.RequestHeader
+	}{
+		{"plain", plain, map[string]string{
+			"SERVER_PROTOCOL": "HTTP/1.1", "HTTPS": "", "SSL_PROTOCOL": "", "HTTP2": "",
+		}, protocol.RequestHeader{Protocol: "HTTP/1.1"}},
+		{"tls", tlsSrv, map[string]string{
+			"SERVER_PROTOCOL": "HTTP/1.1", "HTTPS": "on", "SSL_PROTOCOL": "TLSv1.3", "SSL_CIPHER": "TLS_AES_128_GCM_SHA256",
+			"SSL_SESSION_RESUMED": "Initial", "SSL_ALPN_PROTOCOL": "", "HTTP2": "",
+		}, protocol.RequestHeader{Protocol: "HTTP/1.1", HTTPS: true, SSLProtocol: "TLSv1.3", SSLCipher: "TLS_AES_128_GCM_SHA256"}},
+		{"h2", h2, map[string]string{
+			"SERVER_PROTOCOL": "HTTP/2.0", "HTTPS": "on", "SSL_PROTOCOL": "TLSv1.3", "SSL_CIPHER": "TLS_AES_128_GCM_SHA256",
+			"SSL_SESSION_RESUMED": "Initial", "SSL_ALPN_PROTOCOL": "h2", "HTTP2": "on",
+		}, protocol.RequestHeader{Protocol: "HTTP/2.0", HTTPS: true, SSLProtocol: "TLSv1.3", SSLCipher: "TLS_AES_128_GCM_SHA256", ALPN: "h2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.srv.Client().Get(tt.srv.URL + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			pool.mu.Lock()
+			srv := pool.server
+			pool.mu.Unlock()
+			for k, want := range tt.want {
+				if got := srv[k]; got != want {
+					t.Errorf("$_SERVER[%s] = %q, want %q", k, got, want)
+				}
+			}
+
+			// Worker processes get the same through the request header.
+			h := requestHeader(&phpengine.Context{Server: srv}, "index.php")
+			got := protocol.RequestHeader{Protocol: h.Protocol, HTTPS: h.HTTPS, SSLProtocol: h.SSLProtocol,
+				SSLCipher: h.SSLCipher, SSLSessionResumed: h.SSLSessionResumed, ALPN: h.ALPN}
+			if !reflect.DeepEqual(got, tt.header) {
+				t.Errorf("worker request = %+v, want %+v", got, tt.header)
+			}
+		})
+	}
+}
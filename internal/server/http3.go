@@ -5,10 +5,11 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 
-	"github.com/sadewadee/maboo/internal/config"
 	"github.com/quic-go/quic-go/http3"
+	"github.com/sadewadee/maboo/internal/config"
 )
 
 // HTTP3Server wraps the HTTP/3 (QUIC) server.
@@ -46,6 +47,16 @@ func (s *HTTP3Server) Start() error {
 	return s.server.ListenAndServe()
 }
 
+// Serve accepts HTTP/3 connections on conn, which may be inherited from a
+// previous process.
+func (s *HTTP3Server) Serve(conn net.PacketConn) error {
+	if s == nil {
+		return nil
+	}
+	s.logger.Info("starting HTTP/3 server", "address", conn.LocalAddr().String())
+	return s.server.Serve(conn)
+}
+
 // Stop gracefully shuts down the HTTP/3 server.
 func (s *HTTP3Server) Stop(ctx context.Context) error {
 	if s == nil {
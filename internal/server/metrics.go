@@ -1,43 +1,179 @@
 package server
 
 import (
-	"fmt"
+	"bufio"
+	"crypto/subtle"
+	"net"
 	"net/http"
-	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/route"
+)
+
+var (
+	requestSize = metrics.NewHistogram("maboo_http_request_size_bytes",
+		"Request body bytes read, per request.", metrics.DefaultSizeBuckets)
+	responseSize = metrics.NewHistogram("maboo_http_response_size_bytes",
+		"Response body bytes written before compression, per request.", metrics.DefaultSizeBuckets)
 )
 
-// Metrics collects Prometheus-compatible metrics.
+// otherRoute is the route label for requests matching no template.
+const otherRoute = "other"
+
+// Request methods get a small fixed index so counters live in arrays
+// instead of a string-keyed map. Non-standard methods share "OTHER",
+// which also keeps arbitrary client-sent methods from creating series.
+const (
+	methodGet = iota
+	methodHead
+	methodPost
+	methodPut
+	methodPatch
+	methodDelete
+	methodOptions
+	methodConnect
+	methodTrace
+	methodOther
+	numMethods
+)
+
+var methodNames = [numMethods]string{
+	"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE", "OTHER",
+}
+
+func methodIndex(method string) int {
+	switch method {
+	case http.MethodGet:
+		return methodGet
+	case http.MethodHead:
+		return methodHead
+	case http.MethodPost:
+		return methodPost
+	case http.MethodPut:
+		return methodPut
+	case http.MethodPatch:
+		return methodPatch
+	case http.MethodDelete:
+		return methodDelete
+	case http.MethodOptions:
+		return methodOptions
+	case http.MethodConnect:
+		return methodConnect
+	case http.MethodTrace:
+		return methodTrace
+	}
+	return methodOther
+}
+
+// Status codes 100-999 are stored by class (first digit) and code within
+// the class, so one block covers every representable status.
+const (
+	minStatus      = 100
+	numStatusClass = 9
+	codesPerClass  = 100
+)
+
+const (
+	cacheLinePad    = 64 - 8 // pads an 8-byte counter to a 64-byte line
+	durationBuckets = 11
+)
+
+// statusCounters holds one request counter per status code for a single
+// route and method. Blocks are allocated on first use and never freed.
+type statusCounters [numStatusClass][codesPerClass]atomic.Int64
+
+// paddedInt64 keeps hot counters on separate cache lines.
+type paddedInt64 struct {
+	atomic.Int64
+	_ [cacheLinePad]byte
+}
+
+// Metrics collects Prometheus-compatible metrics. The record path indexes
+// fixed arrays and touches only atomics; labels are formatted at scrape time.
 type Metrics struct {
-	totalRequests  sync.Map // "method:status" -> *atomic.Int64
-	activeRequests atomic.Int32
-	totalBytes     atomic.Int64
+	activeRequests paddedInt64
+	totalBytes     paddedInt64
+
+	// requests[route][method] -> per-status counters
+	requests [][numMethods]atomic.Pointer[statusCounters]
+
+	bucketBounds [durationBuckets]time.Duration
+	routes       *route.Matcher
+	durations    []durationHistogram // one per route template, plus "other" last
+
+	groups  *workerGroups
+	sampler *RequestSampler
+	handler http.Handler
+}
+
+// durationHistogram holds per-bucket (non-cumulative) counts for one route.
+type durationHistogram struct {
+	counts [durationBuckets]paddedInt64
+	sum    paddedInt64
+	count  paddedInt64
+}
+
+var defaultDurationBuckets = [durationBuckets]float64(metrics.DefaultDurationBuckets)
 
-	durationBuckets []float64
-	durationCounts  sync.Map // bucket key -> *atomic.Int64
-	durationSum     atomic.Int64
-	durationCount   atomic.Int64
+// NewMetrics creates a new metrics collector. routeLabels are the
+// metrics.route_labels templates; requests matching none get route="other".
+// The collector is registered with the shared metrics.Registry, replacing
+// any previous server instance.
+func NewMetrics(p Pool, routeLabels []string) (*Metrics, error) {
+	routes, err := route.New(routeLabels)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metrics{
+		groups:  newWorkerGroups(p),
+		routes:  routes,
+		handler: metrics.Handler(),
+	}
+	for i, sec := range defaultDurationBuckets {
+		m.bucketBounds[i] = time.Duration(sec * float64(time.Second))
+	}
+	m.durations = make([]durationHistogram, routes.Len()+1)
+	m.requests = make([][numMethods]atomic.Pointer[statusCounters], routes.Len()+1)
+	metrics.Replace(m)
+	return m, nil
+}
 
-	pool Pool
+// routeIndex maps a path to its slot in m.durations.
+func (m *Metrics) routeIndex(path string) int {
+	if idx := m.routes.Match(path); idx >= 0 {
+		return idx
+	}
+	return len(m.durations) - 1
 }
 
-// NewMetrics creates a new metrics collector.
-func NewMetrics(p Pool) *Metrics {
-	return &Metrics{
-		pool:            p,
-		durationBuckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0},
+func (m *Metrics) routeLabel(idx int) string {
+	if idx == len(m.durations)-1 {
+		return otherRoute
 	}
+	return m.routes.Template(idx)
+}
+
+var metricsRWPool = sync.Pool{
+	New: func() interface{} {
+		return &metricsResponseWriter{}
+	},
 }
 
-// Middleware returns a middleware that collects metrics and serves the metrics endpoint.
-func (m *Metrics) Middleware(metricsPath string) func(http.Handler) http.Handler {
+// Middleware returns a middleware that collects metrics and serves the metrics endpoint
+// at metricsPath ("" = not on this listener), to the scrapes guard lets through.
+func (m *Metrics) Middleware(metricsPath string, guard *scrapeGuard) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == metricsPath {
-				m.serveMetrics(w)
+			if metricsPath != "" && r.URL.Path == metricsPath {
+				if guard.authorize(w, r) {
+					m.handler.ServeHTTP(w, r)
+				}
 				return
 			}
 
@@ -45,99 +181,156 @@ func (m *Metrics) Middleware(metricsPath string) func(http.Handler) http.Handler
 			m.activeRequests.Add(1)
 			defer m.activeRequests.Add(-1)
 
-			rw := &metricsResponseWriter{ResponseWriter: w, statusCode: 200}
+			rw := metricsRWPool.Get().(*metricsResponseWriter)
+			rw.ResponseWriter, rw.statusCode, rw.bytesWritten = w, 200, 0
+			body := countBody(r)
 			next.ServeHTTP(rw, r)
 
-			duration := time.Since(start)
+			m.record(r.Method, r.URL.Path, rw.statusCode, rw.bytesWritten, time.Since(start))
+			requestSize.Observe(float64(body.len()))
+			responseSize.Observe(float64(rw.bytesWritten))
 
-			key := fmt.Sprintf("%s:%d", r.Method, rw.statusCode)
-			counter, _ := m.totalRequests.LoadOrStore(key, &atomic.Int64{})
-			counter.(*atomic.Int64).Add(1)
+			rw.ResponseWriter = nil
+			metricsRWPool.Put(rw)
+		})
+	}
+}
+
+// validBearer reports whether r carries the expected bearer token.
+func validBearer(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
 
-			m.totalBytes.Add(int64(rw.bytesWritten))
+// record accounts one finished request. It does not allocate once the
+// counter block for a route/method pair exists.
+func (m *Metrics) record(method, path string, status, bytes int, duration time.Duration) {
+	routeIdx := m.routeIndex(path)
 
-			m.durationSum.Add(int64(duration))
-			m.durationCount.Add(1)
-			durationSec := duration.Seconds()
-			for _, bucket := range m.durationBuckets {
-				if durationSec <= bucket {
-					bkey := fmt.Sprintf("%.3f", bucket)
-					bc, _ := m.durationCounts.LoadOrStore(bkey, &atomic.Int64{})
-					bc.(*atomic.Int64).Add(1)
-				}
-			}
-		})
+	if status >= minStatus && status < minStatus+numStatusClass*codesPerClass {
+		slot := &m.requests[routeIdx][methodIndex(method)]
+		block := slot.Load()
+		if block == nil {
+			slot.CompareAndSwap(nil, new(statusCounters))
+			block = slot.Load()
+		}
+		code := status - minStatus
+		block[code/codesPerClass][code%codesPerClass].Add(1)
+	}
+
+	m.totalBytes.Add(int64(bytes))
+
+	h := &m.durations[routeIdx]
+	h.sum.Add(int64(duration))
+	h.count.Add(1)
+	for i, bound := range m.bucketBounds {
+		if duration <= bound {
+			h.counts[i].Add(1)
+			break
+		}
+	}
+}
+
+var (
+	descRequestsTotal = prometheus.NewDesc("maboo_http_requests_total",
+		"Total number of HTTP requests.", []string{"method", "status", "route"}, nil)
+	descRequestsActive = prometheus.NewDesc("maboo_http_requests_active",
+		"Current number of active HTTP requests.", nil, nil)
+	descResponseBytes = prometheus.NewDesc("maboo_http_response_bytes_total",
+		"Total bytes sent in HTTP responses.", nil, nil)
+	descRequestDuration = prometheus.NewDesc("maboo_http_request_duration_seconds",
+		"HTTP request duration in seconds.", []string{"route"}, nil)
+	descLogLogged = prometheus.NewDesc("maboo_log_requests_logged_total",
+		"Requests that produced an access log record.", nil, nil)
+	descLogSampledOut = prometheus.NewDesc("maboo_log_requests_sampled_out_total",
+		"Requests skipped by access log sampling.", nil, nil)
+	// Worker metrics are per group: pool is the kind of work, vhost is
+	// empty unless the group serves one virtual host.
+	workerLabels     = []string{"pool", "group", "vhost"}
+	descWorkersTotal = prometheus.NewDesc("maboo_workers_total",
+		"Total number of PHP workers.", workerLabels, nil)
+	descWorkersBusy = prometheus.NewDesc("maboo_workers_busy",
+		"Number of busy PHP workers.", workerLabels, nil)
+	descWorkersIdle = prometheus.NewDesc("maboo_workers_idle",
+		"Number of idle PHP workers.", workerLabels, nil)
+	descPoolRequests = prometheus.NewDesc("maboo_pool_requests_total",
+		"Total requests processed by worker pool.", workerLabels, nil)
+	descGoroutines = prometheus.NewDesc("maboo_go_goroutines",
+		"Number of goroutines.", nil, nil)
+	descMemAlloc = prometheus.NewDesc("maboo_go_memstats_alloc_bytes",
+		"Number of bytes allocated.", nil, nil)
+)
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		descRequestsTotal, descRequestsActive, descResponseBytes, descRequestDuration,
+		descLogLogged, descLogSampledOut,
+		descWorkersTotal, descWorkersBusy, descWorkersIdle, descPoolRequests,
+		descGoroutines, descMemAlloc,
+	} {
+		ch <- d
 	}
 }
 
-func (m *Metrics) serveMetrics(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
-
-	var b strings.Builder
-
-	b.WriteString("# HELP maboo_http_requests_total Total number of HTTP requests.\n")
-	b.WriteString("# TYPE maboo_http_requests_total counter\n")
-	m.totalRequests.Range(func(key, value interface{}) bool {
-		parts := strings.SplitN(key.(string), ":", 2)
-		method, status := parts[0], parts[1]
-		count := value.(*atomic.Int64).Load()
-		fmt.Fprintf(&b, "maboo_http_requests_total{method=\"%s\",status=\"%s\"} %d\n", method, status, count)
-		return true
-	})
-
-	b.WriteString("# HELP maboo_http_requests_active Current number of active HTTP requests.\n")
-	b.WriteString("# TYPE maboo_http_requests_active gauge\n")
-	fmt.Fprintf(&b, "maboo_http_requests_active %d\n", m.activeRequests.Load())
-
-	b.WriteString("# HELP maboo_http_response_bytes_total Total bytes sent in HTTP responses.\n")
-	b.WriteString("# TYPE maboo_http_response_bytes_total counter\n")
-	fmt.Fprintf(&b, "maboo_http_response_bytes_total %d\n", m.totalBytes.Load())
-
-	b.WriteString("# HELP maboo_http_request_duration_seconds HTTP request duration in seconds.\n")
-	b.WriteString("# TYPE maboo_http_request_duration_seconds histogram\n")
-	cumulative := int64(0)
-	totalCount := m.durationCount.Load()
-	for _, bucket := range m.durationBuckets {
-		bkey := fmt.Sprintf("%.3f", bucket)
-		if bc, ok := m.durationCounts.Load(bkey); ok {
-			cumulative += bc.(*atomic.Int64).Load()
+// Collect implements prometheus.Collector, snapshotting the atomic counters
+// into const metrics at scrape time.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for routeIdx := range m.requests {
+		for methodIdx := range m.requests[routeIdx] {
+			block := m.requests[routeIdx][methodIdx].Load()
+			if block == nil {
+				continue
+			}
+			for class := range block {
+				for code := range block[class] {
+					count := block[class][code].Load()
+					if count == 0 {
+						continue
+					}
+					status := strconv.Itoa(minStatus + class*codesPerClass + code)
+					ch <- prometheus.MustNewConstMetric(descRequestsTotal, prometheus.CounterValue, float64(count),
+						methodNames[methodIdx], status, m.routeLabel(routeIdx))
+				}
+			}
 		}
-		fmt.Fprintf(&b, "maboo_http_request_duration_seconds_bucket{le=\"%.3f\"} %d\n", bucket, cumulative)
 	}
-	fmt.Fprintf(&b, "maboo_http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", totalCount)
-	fmt.Fprintf(&b, "maboo_http_request_duration_seconds_sum %.6f\n", float64(m.durationSum.Load())/float64(time.Second))
-	fmt.Fprintf(&b, "maboo_http_request_duration_seconds_count %d\n", totalCount)
-
-	if m.pool != nil {
-		stats := m.pool.Stats()
-		b.WriteString("# HELP maboo_workers_total Total number of PHP workers.\n")
-		b.WriteString("# TYPE maboo_workers_total gauge\n")
-		fmt.Fprintf(&b, "maboo_workers_total %d\n", stats.TotalWorkers())
-
-		b.WriteString("# HELP maboo_workers_busy Number of busy PHP workers.\n")
-		b.WriteString("# TYPE maboo_workers_busy gauge\n")
-		fmt.Fprintf(&b, "maboo_workers_busy %d\n", stats.BusyWorkers())
-
-		b.WriteString("# HELP maboo_workers_idle Number of idle PHP workers.\n")
-		b.WriteString("# TYPE maboo_workers_idle gauge\n")
-		fmt.Fprintf(&b, "maboo_workers_idle %d\n", stats.IdleWorkers())
-
-		b.WriteString("# HELP maboo_pool_requests_total Total requests processed by worker pool.\n")
-		b.WriteString("# TYPE maboo_pool_requests_total counter\n")
-		fmt.Fprintf(&b, "maboo_pool_requests_total %d\n", stats.TotalRequests())
+
+	ch <- prometheus.MustNewConstMetric(descRequestsActive, prometheus.GaugeValue, float64(m.activeRequests.Load()))
+	ch <- prometheus.MustNewConstMetric(descResponseBytes, prometheus.CounterValue, float64(m.totalBytes.Load()))
+
+	for idx := range m.durations {
+		h := &m.durations[idx]
+		totalCount := h.count.Load()
+		if totalCount == 0 && idx != len(m.durations)-1 {
+			continue
+		}
+		buckets := make(map[float64]uint64, len(h.counts))
+		cumulative := int64(0)
+		for i := range h.counts {
+			cumulative += h.counts[i].Load()
+			buckets[defaultDurationBuckets[i]] = uint64(cumulative)
+		}
+		sum := float64(h.sum.Load()) / float64(time.Second)
+		ch <- prometheus.MustNewConstHistogram(descRequestDuration, uint64(totalCount), sum, buckets, m.routeLabel(idx))
 	}
 
-	b.WriteString("# HELP maboo_go_goroutines Number of goroutines.\n")
-	b.WriteString("# TYPE maboo_go_goroutines gauge\n")
-	fmt.Fprintf(&b, "maboo_go_goroutines %d\n", runtime.NumGoroutine())
+	if m.sampler != nil {
+		ch <- prometheus.MustNewConstMetric(descLogLogged, prometheus.CounterValue, float64(m.sampler.Logged()))
+		ch <- prometheus.MustNewConstMetric(descLogSampledOut, prometheus.CounterValue, float64(m.sampler.SampledOut()))
+	}
 
-	var mem runtime.MemStats
-	runtime.ReadMemStats(&mem)
-	b.WriteString("# HELP maboo_go_memstats_alloc_bytes Number of bytes allocated.\n")
-	b.WriteString("# TYPE maboo_go_memstats_alloc_bytes gauge\n")
-	fmt.Fprintf(&b, "maboo_go_memstats_alloc_bytes %d\n", mem.Alloc)
+	for _, g := range m.groups.list() {
+		stats := g.Stats()
+		ch <- prometheus.MustNewConstMetric(descWorkersTotal, prometheus.GaugeValue, float64(stats.Workers), g.Pool, g.Name, g.VHost)
+		ch <- prometheus.MustNewConstMetric(descWorkersBusy, prometheus.GaugeValue, float64(stats.Busy), g.Pool, g.Name, g.VHost)
+		ch <- prometheus.MustNewConstMetric(descWorkersIdle, prometheus.GaugeValue, float64(stats.Idle), g.Pool, g.Name, g.VHost)
+		ch <- prometheus.MustNewConstMetric(descPoolRequests, prometheus.CounterValue, float64(stats.Requests), g.Pool, g.Name, g.VHost)
+	}
 
-	w.Write([]byte(b.String()))
+	rt := metrics.ReadRuntime()
+	ch <- prometheus.MustNewConstMetric(descGoroutines, prometheus.GaugeValue, float64(rt.Goroutines))
+	ch <- prometheus.MustNewConstMetric(descMemAlloc, prometheus.GaugeValue, float64(rt.HeapAlloc))
 }
 
 type metricsResponseWriter struct {
@@ -156,3 +349,18 @@ func (rw *metricsResponseWriter) Write(b []byte) (int, error) {
 	rw.bytesWritten += n
 	return n, err
 }
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack hands the connection over, e.g. to a WebSocket upgrade, which is
+// counted as 101 Switching Protocols.
+func (rw *metricsResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m, err := NewMetrics(nil, []string{"/api/users/:id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.record("GET", "/api/users/1", 200, 100, 3*time.Millisecond)
+	m.record("GET", "/api/users/2", 200, 100, 20*time.Millisecond)
+	m.record("POST", "/login", 302, 0, time.Millisecond)
+	m.record("BREW", "/pot", 418, 0, time.Millisecond)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m)
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, line := range []string{
+		`maboo_http_requests_total{method="GET",route="/api/users/:id",status="200"} 2`,
+		`maboo_http_requests_total{method="POST",route="other",status="302"} 1`,
+		`maboo_http_requests_total{method="OTHER",route="other",status="418"} 1`,
+		`maboo_http_response_bytes_total 200`,
+		`maboo_http_request_duration_seconds_bucket{route="/api/users/:id",le="0.005"} 1`,
+		`maboo_http_request_duration_seconds_bucket{route="/api/users/:id",le="0.025"} 2`,
+		`maboo_http_request_duration_seconds_bucket{route="/api/users/:id",le="+Inf"} 2`,
+		`maboo_http_request_duration_seconds_count{route="/api/users/:id"} 2`,
+		`maboo_http_request_duration_seconds_sum{route="other"} 0.002`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsToken(t *testing.T) {
+	m, err := NewMetrics(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Middleware("/metrics", &scrapeGuard{token: "s3cret"})(http.NotFoundHandler())
+
+	tests := []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("Authorization %q: status = %d, want %d", tt.auth, rec.Code, tt.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("token must only guard the metrics path, got %d", rec.Code)
+	}
+}
+
+func TestMetricsRecordNoAllocs(t *testing.T) {
+	m, _ := NewMetrics(nil, []string{"/api/users/:id", "/blog/*"})
+	m.record("GET", "/api/users/1", 200, 10, time.Millisecond)
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		m.record("GET", "/api/users/1", 200, 10, time.Millisecond)
+	})
+	if allocs != 0 {
+		t.Errorf("expected zero allocations in the record path, got %v", allocs)
+	}
+}
+
+func BenchmarkMetricsRecord(b *testing.B) {
+	m, _ := NewMetrics(nil, []string{"/api/users/:id", "/blog/*"})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.record("GET", "/api/users/1", 200, 512, 7*time.Millisecond)
+		}
+	})
+}
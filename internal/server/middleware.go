@@ -1,16 +1,24 @@
 package server
 
 import (
+	"bufio"
 	"context"
 	"encoding/hex"
+	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"runtime/debug"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"crypto/rand"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/phpengine"
 )
 
 // --- Single context key for all middleware data (fix #4) ---
@@ -22,6 +30,26 @@ type mabooCtxKey struct{}
 type MabooRequestCtx struct {
 	RequestID string
 	StartTime time.Time
+
+	// W3C trace context; empty when tracing is disabled.
+	TraceID string
+	SpanID  string
+
+	// Filled in by the PHP handler once the pool has served the request.
+	WorkerID int
+	PoolWait time.Duration
+	PHPTime  time.Duration
+
+	// TimeoutLimit names the setting the PHP handler took the request's
+	// timeout from, e.g. pool.route_timeouts[/admin/reports/*].
+	TimeoutLimit string
+
+	// ErrorClass says why maboo answered instead of PHP, e.g.
+	// response_too_large; empty when PHP answered.
+	ErrorClass string
+
+	// Stats are the PHP statistics of a request run with debug stats on.
+	Stats *phpengine.Stats
 }
 
 // GetRequestCtx retrieves the request context from the context.
@@ -45,7 +73,6 @@ type mabooResponseWriter struct {
 	statusCode   int
 	bytesWritten int
 	wroteHeader  bool
-	hintsSent    bool // early hints tracking baked in (no separate wrapper)
 }
 
 func (rw *mabooResponseWriter) reset(w http.ResponseWriter) {
@@ -53,22 +80,17 @@ func (rw *mabooResponseWriter) reset(w http.ResponseWriter) {
 	rw.statusCode = 200
 	rw.bytesWritten = 0
 	rw.wroteHeader = false
-	rw.hintsSent = false
 }
 
 func (rw *mabooResponseWriter) WriteHeader(code int) {
-	// Baked-in early hints check (eliminates earlyHintsWriter allocation)
-	if !rw.hintsSent {
-		rw.hintsSent = true
-		links := rw.Header().Values("Link")
-		for _, link := range links {
-			if strings.Contains(link, "rel=preload") || strings.Contains(link, "rel=preconnect") {
-				rw.ResponseWriter.WriteHeader(http.StatusEarlyHints)
-				break
-			}
+	// Informational responses such as 103 Early Hints go out ahead of the
+	// final response and are not logged as the status.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		if !rw.wroteHeader {
+			rw.ResponseWriter.WriteHeader(code)
 		}
+		return
 	}
-
 	if rw.wroteHeader {
 		return
 	}
@@ -91,6 +113,48 @@ func (rw *mabooResponseWriter) Unwrap() http.ResponseWriter {
 	return rw.ResponseWriter
 }
 
+// Hijack hands the connection over, e.g. to a WebSocket upgrade, which is
+// logged as 101 Switching Protocols.
+func (rw *mabooResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.wroteHeader = true
+		rw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// countingBody counts the request body bytes the handler reads.
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64 // read by a PHP worker that may outlive the request
+}
+
+// countBody wraps r.Body so the bytes read from it can be logged, or
+// returns nil for a request without a body.
+func countBody(r *http.Request) *countingBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	b := &countingBody{ReadCloser: r.Body}
+	r.Body = b
+	return b
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// len returns the bytes read so far; 0 for a nil body.
+func (b *countingBody) len() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.n.Load()
+}
+
 // --- Request ID generation (fix #7) ---
 
 var ridBufPool = sync.Pool{
@@ -111,23 +175,37 @@ func fastRequestID() string {
 }
 
 // --- Collapsed middleware (fix #2, #4) ---
-// Recovery + RequestID + EarlyHints + Logging in ONE handler.
-// This eliminates 3 closure allocations, 3 function call layers,
-// and the separate earlyHintsWriter allocation per request.
+// Recovery + RequestID + Logging in ONE handler.
+// This eliminates closure allocations and function call layers per
+// request.
 
-// CoreMiddleware combines recovery, request ID, early hints, and logging
-// into a single middleware to minimize allocation and call overhead.
-func CoreMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+// CoreMiddleware combines recovery, request ID and logging into a single
+// middleware to minimize allocation and call overhead.
+// Request records go to accessLog, panics to logger and, unless it is nil,
+// to report. A nil sampler logs every request.
+func CoreMiddleware(logger, accessLog *slog.Logger, sampler *RequestSampler, tracing config.TracingConfig, report func(*http.Request, errreport.Event)) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// 1. Recovery (defer at top)
 			defer func() {
 				if err := recover(); err != nil {
-					logger.Error("panic recovered",
+					if err == http.ErrAbortHandler {
+						panic(err) // abort the response; net/http closes the connection
+					}
+					stack := string(debug.Stack())
+					logger.ErrorContext(r.Context(), "panic recovered",
 						"error", err,
-						"stack", string(debug.Stack()),
+						"stack", stack,
 						"path", r.URL.Path,
 					)
+					if report != nil {
+						report(r, errreport.Event{
+							Kind:    errreport.KindPanic,
+							Level:   errreport.LevelFatal,
+							Message: fmt.Sprint(err),
+							Extra:   map[string]string{"stack": stack},
+						})
+					}
 					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 				}
 			}()
@@ -140,26 +218,78 @@ func CoreMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 			}
 			w.Header().Set("X-Request-ID", id)
 
-			// 3. Pooled response writer with baked-in early hints
+			// 3. Pooled response writer
 			start := time.Now()
 			rw := rwPool.Get().(*mabooResponseWriter)
 			rw.reset(w)
 
+			// The request context is only attached for those reading it:
+			// tracing, and the access log's request and slow_request
+			// records, of which the latter is the least severe.
+			var rc *MabooRequestCtx
+			if tracing.Enabled || accessLog.Enabled(r.Context(), slog.LevelWarn) {
+				rc = &MabooRequestCtx{RequestID: id, StartTime: start}
+				if tracing.Enabled {
+					applyTraceContext(r, rc, tracing.Generate)
+					if rc.TraceID != "" && tracing.ResponseHeader != "" {
+						w.Header().Set(tracing.ResponseHeader, rc.TraceID)
+					}
+				}
+				r = r.WithContext(context.WithValue(r.Context(), mabooCtxKey{}, rc))
+			}
+			body := countBody(r)
+
 			next.ServeHTTP(rw, r)
 
-			// 4. Logging (after response, guarded by level check)
+			// 4. Logging (after response). The sampler decides every
+			// request, so its counters hold at any level; each record is
+			// guarded by its own level check. rc is nil when the level
+			// was raised during the request.
 			// Stack-allocated attrs array avoids slice header + grow alloc
-			if logger.Enabled(r.Context(), slog.LevelInfo) {
-				attrs := [7]slog.Attr{
-					slog.String("method", r.Method),
-					slog.String("path", r.URL.Path),
-					slog.Int("status", rw.statusCode),
-					slog.Duration("duration", time.Since(start)),
-					slog.Int("bytes", rw.bytesWritten),
-					slog.String("remote_addr", r.RemoteAddr),
-					slog.String("request_id", id),
+			duration := time.Since(start)
+			log, slow := true, false
+			if sampler != nil {
+				log, slow = sampler.Decide(r.URL.Path, rw.statusCode, duration)
+			}
+			if rc != nil {
+				if log && accessLog.Enabled(r.Context(), slog.LevelInfo) {
+					attrs := [11]slog.Attr{
+						slog.String("method", r.Method),
+						slog.String("path", r.URL.Path),
+						slog.Int("status", rw.statusCode),
+						slog.Duration("duration", duration),
+						slog.Int("bytes", rw.bytesWritten),
+						slog.Int64("request_bytes", body.len()),
+						slog.String("remote_addr", r.RemoteAddr),
+						slog.String("request_id", id),
+					}
+					n := 8
+					if rc.TimeoutLimit != "" {
+						attrs[n] = slog.String("timeout_limit", rc.TimeoutLimit)
+						n++
+					}
+					if rc.ErrorClass != "" {
+						attrs[n] = slog.String("error_class", rc.ErrorClass)
+						n++
+					}
+					if rc.Stats != nil {
+						attrs[n] = statsAttr(rc.Stats)
+						n++
+					}
+					accessLog.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs[:n]...)
+				}
+				if slow && accessLog.Enabled(r.Context(), slog.LevelWarn) {
+					attrs := [7]slog.Attr{
+						slog.String("method", r.Method),
+						slog.String("path", r.URL.Path),
+						slog.Duration("duration", duration),
+						slog.Duration("pool_wait", rc.PoolWait),
+						slog.Duration("php_time", rc.PHPTime),
+						slog.Int("worker_id", rc.WorkerID),
+						slog.String("request_id", id),
+					}
+					accessLog.LogAttrs(r.Context(), slog.LevelWarn, "slow_request", attrs[:]...)
 				}
-				logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs[:]...)
 			}
 
 			rwPool.Put(rw)
@@ -174,6 +304,9 @@ func RecoveryMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
 					logger.Error("panic recovered",
 						"error", err,
 						"stack", string(debug.Stack()),
@@ -1,15 +1,56 @@
 package server
 
 import (
+	"context"
+	"log/slog"
+
+	"github.com/sadewadee/maboo/internal/config"
 	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/pool"
 	"github.com/sadewadee/maboo/internal/worker"
 )
 
-// Pool is the interface for worker pools.
+// Pool is what the server runs PHP on. Router, Metrics and HealthHandler
+// only see this interface; NewPool picks the implementation.
 type Pool interface {
 	Start() error
 	Stop() error
 	Exec(ctx *phpengine.Context, script string) (*phpengine.Response, error)
+	Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error)
 	Mode() string
 	Stats() worker.StatsGetter
+
+	// SetConfig applies a reloaded config, Reload replaces every worker,
+	// and Invalidate drops files from the workers' opcache, failing when
+	// they cannot.
+	SetConfig(cfg *config.Config) error
+	Reload() error
+	Invalidate(files []string) error
+}
+
+// dormantPool is a Pool that can scale to zero: one with pool.on_demand.
+type dormantPool interface {
+	Dormant() bool
+}
+
+// dormant reports whether p has scaled to zero on demand: it has no
+// workers, but starts one for the next request, so it counts as ready.
+func dormant(p Pool) bool {
+	d, ok := p.(dormantPool)
+	return ok && d.Dormant()
+}
+
+// NewPool returns the pool HTTP requests run on: worker processes under
+// php.binary when cfg has a worker group for them (see
+// Config.HTTPWorkers), and embedded workers otherwise. It does not start
+// it.
+func NewPool(cfg *config.Config, logger *slog.Logger) Pool {
+	if group, poolCfg, ok := cfg.HTTPWorkers(); ok {
+		php := cfg.PHP
+		php.Worker = group.Script
+		return NewProcessPool(pool.New(poolCfg, php, logger.With("pool", "http")), cfg)
+	}
+	p := worker.NewPool(cfg)
+	p.SetLogger(logger)
+	return p
 }
@@ -0,0 +1,85 @@
+//go:build linux
+
+package server
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// portOwner finds the process listening on addr's port, best effort: it
+// looks the socket up in /proc/net, then among the file descriptors of the
+// processes it may read. It returns 0 when it cannot tell.
+func portOwner(network, addr string) int {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return 0
+	}
+	inodes := map[string]bool{}
+	for _, table := range []string{network, network + "6"} {
+		socketInodes("/proc/net/"+table, network, uint16(port), inodes)
+	}
+	if len(inodes) == 0 {
+		return 0
+	}
+
+	procs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, proc := range procs {
+		fds, err := os.ReadDir(filepath.Join(proc, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(proc, "fd", fd.Name()))
+			if err != nil {
+				continue
+			}
+			if inode, ok := strings.CutPrefix(link, "socket:["); ok && inodes[strings.TrimSuffix(inode, "]")] {
+				pid, _ := strconv.Atoi(filepath.Base(proc))
+				return pid
+			}
+		}
+	}
+	return 0
+}
+
+// socketInodes adds the inodes of the sockets bound to port in a
+// /proc/net table: listening TCP sockets, or any UDP one.
+func socketInodes(table, network string, port uint16, inodes map[string]bool) {
+	f, err := os.Open(table)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		_, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		p, err := strconv.ParseUint(hexPort, 16, 16)
+		if err != nil || uint16(p) != port {
+			continue
+		}
+		if network == "tcp" && fields[3] != "0A" { // TCP_LISTEN
+			continue
+		}
+		if fields[9] != "0" {
+			inodes[fields[9]] = true
+		}
+	}
+}
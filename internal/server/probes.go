@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/notify"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// Probe defaults for health.checks entries that leave them unset.
+const (
+	defaultProbeInterval         = 10 * time.Second
+	defaultProbeTimeout          = 2 * time.Second
+	defaultProbeFailureThreshold = 3
+)
+
+var (
+	probeUpGauge = metrics.NewGaugeVec("maboo_health_probe_up",
+		"Whether a dependency probe is currently healthy (1) or not (0).", "probe")
+	probeLatencyGauge = metrics.NewGaugeVec("maboo_health_probe_latency_seconds",
+		"Latency of the most recent dependency probe run.", "probe")
+)
+
+// ProbeStatus is the last observed state of one dependency probe.
+type ProbeStatus struct {
+	Name                string    `json:"name"`
+	Type                string    `json:"type"`
+	Healthy             bool      `json:"healthy"`
+	LastError           string    `json:"last_error,omitempty"`
+	LatencyMS           float64   `json:"latency_ms"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastCheck           time.Time `json:"last_check"`
+}
+
+// ProbeChecker runs health.checks in the background so readiness requests
+// only read cached results.
+type ProbeChecker struct {
+	policy string
+	probes []*probe
+	pool   Pool
+	logger *slog.Logger
+	client *http.Client
+
+	// notifier hears of probes going unhealthy and recovering.
+	notifier *notify.Notifier
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type probe struct {
+	cfg       config.HealthCheckConfig
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+
+	mu     sync.RWMutex
+	status ProbeStatus
+	down   bool // a health_probe_failing notification went out
+}
+
+// NewProbeChecker creates a checker for the configured probes. Call Start
+// to begin probing.
+func NewProbeChecker(cfg config.HealthConfig, pool Pool, logger *slog.Logger) *ProbeChecker {
+	pc := &ProbeChecker{
+		policy: cfg.Policy,
+		pool:   pool,
+		logger: logger,
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+	for _, c := range cfg.Checks {
+		p := &probe{
+			cfg:       c,
+			interval:  c.Interval.Duration(),
+			timeout:   c.Timeout.Duration(),
+			threshold: c.FailureThreshold,
+			status:    ProbeStatus{Name: c.Name, Type: c.Type},
+		}
+		if p.interval <= 0 {
+			p.interval = defaultProbeInterval
+		}
+		if p.timeout <= 0 {
+			p.timeout = defaultProbeTimeout
+		}
+		if p.threshold <= 0 {
+			p.threshold = defaultProbeFailureThreshold
+		}
+		if p.cfg.Type == "http" && p.cfg.ExpectStatus == 0 {
+			p.cfg.ExpectStatus = http.StatusOK
+		}
+		probeUpGauge.WithLabelValues(c.Name).Set(0)
+		pc.probes = append(pc.probes, p)
+	}
+	return pc
+}
+
+// Start launches one goroutine per probe. Each probe runs immediately and
+// then every interval.
+func (pc *ProbeChecker) Start() {
+	if len(pc.probes) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pc.cancel = cancel
+
+	for _, p := range pc.probes {
+		pc.wg.Add(1)
+		go pc.loop(ctx, p)
+	}
+}
+
+// Stop halts probing and waits for in-flight probes to return.
+func (pc *ProbeChecker) Stop() {
+	if pc.cancel == nil {
+		return
+	}
+	pc.cancel()
+	pc.wg.Wait()
+}
+
+// Ready aggregates probe states according to health.policy. A probe that
+// has not completed its first run counts as failing.
+func (pc *ProbeChecker) Ready() bool {
+	if len(pc.probes) == 0 {
+		return true
+	}
+
+	healthy := 0
+	for _, p := range pc.probes {
+		p.mu.RLock()
+		if p.status.Healthy {
+			healthy++
+		}
+		p.mu.RUnlock()
+	}
+
+	if pc.policy == "quorum" {
+		return healthy*2 > len(pc.probes)
+	}
+	return healthy == len(pc.probes)
+}
+
+// Statuses returns a snapshot of every probe's last result.
+func (pc *ProbeChecker) Statuses() []ProbeStatus {
+	out := make([]ProbeStatus, len(pc.probes))
+	for i, p := range pc.probes {
+		p.mu.RLock()
+		out[i] = p.status
+		p.mu.RUnlock()
+	}
+	return out
+}
+
+func (pc *ProbeChecker) loop(ctx context.Context, p *probe) {
+	defer pc.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		pc.runOnce(ctx, p)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (pc *ProbeChecker) runOnce(ctx context.Context, p *probe) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := pc.check(ctx, p)
+	latency := time.Since(start)
+
+	p.mu.Lock()
+	wasHealthy := p.status.Healthy
+	p.status.LastCheck = start
+	p.status.LatencyMS = float64(latency.Microseconds()) / 1000
+	if err == nil {
+		p.status.Healthy = true
+		p.status.LastError = ""
+		p.status.ConsecutiveFailures = 0
+	} else {
+		p.status.LastError = err.Error()
+		p.status.ConsecutiveFailures++
+		if p.status.ConsecutiveFailures >= p.threshold {
+			p.status.Healthy = false
+		}
+	}
+	healthy := p.status.Healthy
+	// Failing is notified on reaching the threshold rather than on
+	// leaving the healthy state, so a probe failing from the start is
+	// notified too, and recovering only after failing was.
+	failing := err != nil && p.status.ConsecutiveFailures == p.threshold
+	recovered := healthy && p.down
+	if failing {
+		p.down = true
+	} else if recovered {
+		p.down = false
+	}
+	failures := p.status.ConsecutiveFailures
+	p.mu.Unlock()
+
+	probeLatencyGauge.WithLabelValues(p.cfg.Name).Set(latency.Seconds())
+	if healthy {
+		probeUpGauge.WithLabelValues(p.cfg.Name).Set(1)
+	} else {
+		probeUpGauge.WithLabelValues(p.cfg.Name).Set(0)
+	}
+
+	if wasHealthy != healthy && pc.logger != nil {
+		if healthy {
+			pc.logger.Info("health probe recovered", "probe", p.cfg.Name)
+		} else {
+			pc.logger.Warn("health probe failing", "probe", p.cfg.Name, "error", err)
+		}
+	}
+	switch {
+	case failing:
+		pc.notifier.Notify(notify.Event{
+			Type:    notify.EventProbeFailing,
+			Message: fmt.Sprintf("health probe %s failing", p.cfg.Name),
+			Details: map[string]string{"probe": p.cfg.Name, "type": p.cfg.Type, "error": err.Error(), "failures": strconv.Itoa(failures)},
+		})
+	case recovered:
+		pc.notifier.Notify(notify.Event{
+			Type:    notify.EventProbeRecovered,
+			Message: fmt.Sprintf("health probe %s recovered", p.cfg.Name),
+			Details: map[string]string{"probe": p.cfg.Name, "type": p.cfg.Type},
+		})
+	}
+}
+
+func (pc *ProbeChecker) check(ctx context.Context, p *probe) error {
+	switch p.cfg.Type {
+	case "tcp":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", p.cfg.Address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case "http":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := pc.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != p.cfg.ExpectStatus {
+			return fmt.Errorf("status %d, expected %d", resp.StatusCode, p.cfg.ExpectStatus)
+		}
+		return nil
+
+	case "php":
+		return pc.checkPHP(ctx, p.cfg.Script)
+	}
+	return fmt.Errorf("unknown probe type %q", p.cfg.Type)
+}
+
+// checkPHP runs script through the worker pool. The pool has no
+// cancellation, so a timed-out script keeps its worker until it finishes;
+// the probe just stops waiting for it.
+func (pc *ProbeChecker) checkPHP(ctx context.Context, script string) error {
+	if pc.pool == nil {
+		return fmt.Errorf("no worker pool")
+	}
+	resp, err := pc.pool.Invoke(ctx, phpengine.InternalRequest{
+		Entry:   filepath.Base(script),
+		DocRoot: filepath.Dir(script),
+		Caller:  metrics.CallerProbe,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status >= 400 {
+		return fmt.Errorf("script returned status %d", resp.Status)
+	}
+	return nil
+}
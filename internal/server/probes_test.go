@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/notify"
+)
+
+func TestProbeCheckerTCPAndHTTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	pc := NewProbeChecker(config.HealthConfig{Checks: []config.HealthCheckConfig{
+		{Name: "db", Type: "tcp", Address: ln.Addr().String()},
+		{Name: "api", Type: "http", URL: srv.URL, ExpectStatus: http.StatusNoContent},
+		{Name: "api-wrong-status", Type: "http", URL: srv.URL, FailureThreshold: 1},
+	}}, nil, nil)
+
+	if pc.Ready() {
+		t.Fatal("expected not ready before the first probe run")
+	}
+	for _, p := range pc.probes {
+		pc.runOnce(context.Background(), p)
+	}
+
+	got := map[string]bool{}
+	for _, st := range pc.Statuses() {
+		got[st.Name] = st.Healthy
+	}
+	if !got["db"] || !got["api"] || got["api-wrong-status"] {
+		t.Errorf("unexpected probe states: %v", got)
+	}
+	if pc.Ready() {
+		t.Error("policy any: one failing probe must fail readiness")
+	}
+
+	pc.policy = "quorum"
+	if !pc.Ready() {
+		t.Error("policy quorum: two of three healthy should be ready")
+	}
+}
+
+func TestProbeFailureThreshold(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	pc := NewProbeChecker(config.HealthConfig{Checks: []config.HealthCheckConfig{
+		{Name: "redis", Type: "tcp", Address: addr, Timeout: config.Duration(time.Second), FailureThreshold: 2},
+	}}, nil, nil)
+	p := pc.probes[0]
+
+	pc.runOnce(context.Background(), p)
+	if !pc.Ready() {
+		t.Fatal("expected ready while the listener is up")
+	}
+
+	ln.Close()
+	pc.runOnce(context.Background(), p)
+	if !pc.Ready() {
+		t.Error("a single failure below the threshold must not flip readiness")
+	}
+	pc.runOnce(context.Background(), p)
+	if pc.Ready() {
+		t.Error("expected not ready after reaching the failure threshold")
+	}
+	if st := pc.Statuses()[0]; st.ConsecutiveFailures != 2 || st.LastError == "" {
+		t.Errorf("unexpected status %+v", st)
+	}
+}
+
+func TestProbeNotifications(t *testing.T) {
+	events := make(chan notify.Payload, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p notify.Payload
+		json.NewDecoder(r.Body).Decode(&p)
+		events <- p
+	}))
+	defer hook.Close()
+	n := notify.New([]notify.Webhook{{Name: "ops", URL: hook.URL}}, notify.Options{QueueSize: 10},
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	pc := NewProbeChecker(config.HealthConfig{Checks: []config.HealthCheckConfig{
+		{Name: "redis", Type: "tcp", Address: addr, Timeout: config.Duration(time.Second), FailureThreshold: 2},
+	}}, nil, nil)
+	pc.notifier = n
+	p := pc.probes[0]
+
+	// Down from the start: failing once the threshold is reached, and
+	// only then.
+	for range 4 {
+		pc.runOnce(context.Background(), p)
+	}
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", addr, err)
+	}
+	defer ln.Close()
+	pc.runOnce(context.Background(), p)
+	pc.runOnce(context.Background(), p)
+	n.Close(context.Background())
+
+	if len(events) != 2 {
+		t.Fatalf("%d notifications, want failing and recovered", len(events))
+	}
+	if e := <-events; e.Event != notify.EventProbeFailing || e.Details["probe"] != "redis" || e.Details["failures"] != "2" || e.Details["error"] == "" {
+		t.Errorf("failing = %+v", e)
+	}
+	if e := <-events; e.Event != notify.EventProbeRecovered || e.Details["probe"] != "redis" {
+		t.Errorf("recovered = %+v", e)
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/features"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/scratch"
+	"github.com/sadewadee/maboo/internal/worker"
+)
+
+// processPool serves requests from PHP worker processes. Each Context is
+// sent as a REQUEST frame and the RESPONSE frame read back, where the
+// embedded pool would run it on an engine.
+type processPool struct {
+	pool    *pool.Pool
+	cfg     atomic.Pointer[config.Config]
+	scratch *scratch.Area // where large request bodies are spilled
+}
+
+// NewProcessPool adapts p to Pool. cfg supplies the document root of
+// internal requests and the features p offers; SetConfig replaces it,
+// while p keeps the pool settings it was created with.
+func NewProcessPool(p *pool.Pool, cfg *config.Config) Pool {
+	pp := &processPool{pool: p}
+	pp.SetConfig(cfg)
+	return pp
+}
+
+func (p *processPool) Start() error { return p.pool.Start() }
+func (p *processPool) Stop() error  { return p.pool.Stop() }
+func (p *processPool) Mode() string { return "process" }
+
+func (p *processPool) SetReporter(r errreport.Reporter) { p.pool.SetReporter(r) }
+func (p *processPool) SetScratch(a *scratch.Area)       { p.scratch = a }
+
+func (p *processPool) Reload() error { return p.pool.Reload() }
+func (p *processPool) Invalidate(files []string) error {
+	p.pool.Invalidate(files)
+	return nil
+}
+func (p *processPool) Stats() worker.StatsGetter { return processStats{p.pool.Stats()} }
+func (p *processPool) SetConfig(cfg *config.Config) error {
+	p.cfg.Store(cfg)
+	p.pool.SetFeatures(features.Offered(cfg))
+	return nil
+}
+
+// Exec sends ctx to an idle worker process. A body over
+// protocol.DefaultBodyMemoryLimit is spilled to the scratch area and
+// streamed to the worker from there.
+func (p *processPool) Exec(ctx *phpengine.Context, script string) (*phpengine.Response, error) {
+	frame, body, err := protocol.EncodeRequestBody(requestHeader(ctx, script), ctx.Body, protocol.DefaultBodyMemoryLimit, p.scratch)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	start := time.Now()
+	var resp *protocol.Frame
+	var stats *protocol.WorkerStats
+	if ctx.CollectStats {
+		resp, stats, err = p.pool.ExecDebug(frame)
+	} else {
+		resp, err = p.pool.Exec(frame)
+	}
+	ctx.ExecTime = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == protocol.TypeError {
+		return nil, fmt.Errorf("worker error: %s", resp.Payload)
+	}
+	h, out, err := protocol.DecodeResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.MaxResponseSize > 0 && int64(len(out)) > ctx.MaxResponseSize {
+		return nil, phpengine.ErrResponseTooLarge
+	}
+	r := &phpengine.Response{Status: h.Status, Headers: h.Headers, Fields: h.Fields, Body: out}
+	if r.Headers == nil {
+		r.Headers = map[string]string{}
+	}
+	if stats != nil {
+		r.Stats = &phpengine.Stats{
+			OpcacheHits:       stats.OpcacheHits,
+			OpcacheMisses:     stats.OpcacheMisses,
+			IncludedFiles:     stats.IncludedFiles,
+			PeakMemory:        stats.PeakMemory,
+			RealpathCacheSize: stats.RealpathCacheSize,
+		}
+	}
+	return r, nil
+}
+
+// Dump reports what worker id is doing; see pool.Pool.Dump.
+func (p *processPool) Dump(id int) (worker.Dump, error) {
+	d, err := p.pool.Dump(id)
+	if errors.Is(err, pool.ErrNoWorker) {
+		return worker.Dump{}, worker.ErrNoWorker
+	}
+	if err != nil {
+		return worker.Dump{}, err
+	}
+	return workerDump(d), nil
+}
+
+// Busy reports the workers serving a request; see pool.Pool.Busy.
+func (p *processPool) Busy() []worker.Dump {
+	var busy []worker.Dump
+	for _, d := range p.pool.Busy() {
+		busy = append(busy, workerDump(d))
+	}
+	return busy
+}
+
+// Abort kills every worker process; see pool.Pool.Abort.
+func (p *processPool) Abort() {
+	p.pool.Abort()
+}
+
+// Dormant reports whether the on-demand pool has scaled to zero; see
+// pool.Pool.Dormant.
+func (p *processPool) Dormant() bool {
+	return p.pool.Dormant()
+}
+
+// workerDump reports d as the admin API does for embedded workers.
+func workerDump(d pool.WorkerDump) worker.Dump {
+	out := worker.Dump{ID: d.ID, State: d.State.String(), Jobs: d.Jobs, Backtrace: d.Backtrace}
+	if d.BacktraceErr != nil {
+		out.BacktraceError = d.BacktraceErr.Error()
+	}
+	if d.Request != nil {
+		out.SetRequest(&worker.Activity{
+			Method:    d.Request.Method,
+			Path:      d.Request.URI,
+			RequestID: d.Request.Headers["X-Request-Id"],
+			Started:   d.Started,
+		})
+	}
+	return out
+}
+
+// Kill replaces worker id; see pool.Pool.Kill.
+func (p *processPool) Kill(id int) error {
+	err := p.pool.Kill(id)
+	if errors.Is(err, pool.ErrNoWorker) {
+		return worker.ErrNoWorker
+	}
+	return err
+}
+
+func (p *processPool) Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	return phpengine.Invoke(ctx, p, p.cfg.Load().App.Root, r)
+}
+
+// requestHeader turns ctx's $_SERVER back into the REQUEST metadata a
+// worker process builds its own $_SERVER from.
+func requestHeader(ctx *phpengine.Context, script string) *protocol.RequestHeader {
+	srv := ctx.Server
+	headers := make(map[string]string)
+	for k, v := range srv {
+		if name, ok := strings.CutPrefix(k, "HTTP_"); ok {
+			headers[textproto.CanonicalMIMEHeaderKey(strings.ReplaceAll(name, "_", "-"))] = v
+		}
+	}
+	if v := srv["CONTENT_TYPE"]; v != "" {
+		headers["Content-Type"] = v
+	}
+	if v := srv["CONTENT_LENGTH"]; v != "" {
+		headers["Content-Length"] = v
+	}
+
+	// The router sets SERVER_PORT; internal requests name a host alone,
+	// or host:port.
+	host, port := srv["SERVER_NAME"], srv["SERVER_PORT"]
+	if port == "" {
+		var err error
+		if host, port, err = net.SplitHostPort(srv["SERVER_NAME"]); err != nil {
+			host, port = srv["SERVER_NAME"], "80"
+			if srv["HTTPS"] == "on" {
+				port = "443"
+			}
+		}
+	}
+	maxExec, _ := strconv.Atoi(ctx.INI["max_execution_time"])
+	h := &protocol.RequestHeader{
+		Method:           srv["REQUEST_METHOD"],
+		URI:              srv["REQUEST_URI"],
+		QueryString:      srv["QUERY_STRING"],
+		Headers:          headers,
+		RemoteAddr:       srv["REMOTE_ADDR"],
+		ServerName:       host,
+		ServerPort:       port,
+		Protocol:         srv["SERVER_PROTOCOL"],
+		MaxExecutionTime: maxExec,
+		Script:           script,
+	}
+	if srv["HTTPS"] == "on" {
+		h.SetTLS(srv)
+	}
+	return h
+}
+
+// processStats presents pool.PoolStats as a worker.StatsGetter.
+type processStats struct{ s pool.PoolStats }
+
+func (p processStats) TotalWorkers() int    { return p.s.TotalWorkers }
+func (p processStats) BusyWorkers() int     { return p.s.BusyWorkers }
+func (p processStats) IdleWorkers() int     { return p.s.IdleWorkers }
+func (p processStats) TotalRequests() int64 { return p.s.TotalRequests }
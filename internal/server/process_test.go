@@ -0,0 +1,224 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/scratch"
+	"github.com/sadewadee/maboo/internal/worker"
+)
+
+// The process pool tests exec the test binary as the PHP worker; started
+// that way (the pool passes php.ini settings as PHP_INI_* variables) it
+// serves the wire protocol instead of running the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv("PHP_INI_maboo_test_worker") == "1" {
+		os.Exit(echoWorker())
+	}
+	os.Exit(m.Run())
+}
+
+// echoWorker answers every request with what it received, and with
+// WORKER_STATS when asked.
+func echoWorker() int {
+	ready := func() error { return protocol.WriteFrame(os.Stdout, protocol.NewWorkerReadyFrame()) }
+	if err := ready(); err != nil {
+		return 1
+	}
+	for {
+		f, err := protocol.ReadFrame(os.Stdin)
+		if err != nil || f.Type == protocol.TypeWorkerStop {
+			return 0
+		}
+		req, body, err := protocol.DecodeRequest(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		resp, err := protocol.EncodeResponse(&protocol.ResponseHeader{
+			Status: http.StatusOK,
+			Fields: []phpengine.HeaderField{{Name: "content-type", Value: "text/plain"}, {Name: "x-Worker", Value: "echo"}},
+		}, fmt.Appendf(nil, "%s %s?%s script=%s type=%s body=%s",
+			req.Method, req.URI, req.QueryString, filepath.Base(req.Script), req.Headers["Content-Type"], body))
+		if err != nil {
+			return 1
+		}
+		if err := protocol.WriteFrame(os.Stdout, resp); err != nil {
+			return 1
+		}
+		if f.Flags&protocol.FlagDebug != 0 {
+			stats, err := protocol.EncodeWorkerStats(&protocol.WorkerStats{IncludedFiles: 3})
+			if err != nil || protocol.WriteFrame(os.Stdout, stats) != nil {
+				return 1
+			}
+		}
+		if err := ready(); err != nil {
+			return 1
+		}
+	}
+}
+
+// newProcessPool starts a process pool of echo workers.
+func newProcessPool(t *testing.T, cfg *config.Config) Pool {
+	t.Helper()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolCfg := cfg.Pool
+	poolCfg.MinWorkers, poolCfg.MaxWorkers = 1, 2
+	p := NewProcessPool(pool.New(poolCfg, config.PHPConfig{
+		Binary: self,
+		Worker: "worker.php",
+		INI:    map[string]string{"maboo_test_worker": "1"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil))), cfg)
+	return p
+}
+
+func TestRouterBackends(t *testing.T) {
+	const secret = "0123456789abcdef"
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.php"), []byte("<?php echo 'hi';"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.App.Root = root
+	cfg.App.Entry = "index.php"
+	cfg.Static.Root = ""
+	cfg.Pool.MinWorkers, cfg.Pool.MaxWorkers = 1, 2
+	cfg.Debug.Secret = secret
+
+	backends := []struct {
+		name string
+		pool Pool
+		body string // "" when the engine's output is not checked
+	}{
+		{"embedded", worker.NewPool(cfg), ""},
+		{"process", newProcessPool(t, cfg), "POST /submit?x=1 script=index.php type=application/x-www-form-urlencoded body=a=b"},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			if err := b.pool.Start(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { b.pool.Stop() })
+			router := NewRouter(cfg, b.pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+			req := httptest.NewRequest("POST", "/submit?x=1", strings.NewReader("a=b"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set(debugHeader, secret)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") == "" {
+				t.Fatalf("status %d, headers %v, body %q", w.Code, w.Header(), w.Body)
+			}
+			if b.body != "" && w.Body.String() != b.body {
+				t.Errorf("body = %q, want %q", w.Body, b.body)
+			}
+			if b.name == "process" && w.Header().Get("X-Worker") != "echo" {
+				t.Errorf("headers %v, want the worker's X-Worker", w.Header())
+			}
+			// The embedded engine cannot count them without libphp.
+			if b.name == "embedded" {
+				if w.Header().Get(statsUnavailableHeader) != "unavailable" {
+					t.Errorf("headers %v, want the stats reported unavailable", w.Header())
+				}
+			} else if w.Header().Get("X-Maboo-Debug-Included-Files") == "" {
+				t.Errorf("no debug stats in %v", w.Header())
+			}
+
+			if s := b.pool.Stats(); s.TotalWorkers() < 1 || s.TotalRequests() < 1 {
+				t.Errorf("stats: %d workers, %d requests", s.TotalWorkers(), s.TotalRequests())
+			}
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("/ready = %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestNewPoolSelectsBackend(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := config.Default()
+	if _, ok := NewPool(cfg, logger).(*worker.Pool); !ok {
+		t.Error("default config: want the embedded pool")
+	}
+
+	// php.binary alone runs WebSocket workers and CLI jobs; HTTP stays
+	// embedded until a worker group serves it.
+	cfg.PHP.Binary = "/usr/bin/php"
+	if _, ok := NewPool(cfg, logger).(*worker.Pool); !ok {
+		t.Error("php.binary without workers: want the embedded pool")
+	}
+	cfg.Workers = []config.WorkerConfig{{Script: "worker.php", Count: 2}}
+	if p := NewPool(cfg, logger); p.Mode() != "process" {
+		t.Errorf("php.binary with a worker group: mode %q, want process", p.Mode())
+	}
+}
+
+func TestProcessPoolTempQuota(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.Default()
+	cfg.App.Root = root
+	cfg.App.Entry = "index.php"
+	cfg.Static.Root = ""
+	if err := os.WriteFile(filepath.Join(root, "index.php"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	area, err := scratch.New(scratch.Options{Dir: t.TempDir(), MaxBytes: 12 << 20}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProcessPool(t, cfg)
+	p.(*processPool).SetScratch(area)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	router := NewRouter(cfg, p, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	post := func(size int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", size))))
+		return w
+	}
+
+	if w := post(10 << 20); w.Code != http.StatusOK {
+		t.Errorf("body within the quota: status %d", w.Code)
+	}
+	if area.Used() != 0 {
+		t.Errorf("%d bytes still held after the request", area.Used())
+	}
+	if w := post(16 << 20); w.Code != http.StatusInsufficientStorage {
+		t.Errorf("body larger than the quota: status %d, want 507", w.Code)
+	}
+
+	// While other files hold most of the quota, the client may retry.
+	held, err := area.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := held.Write(make([]byte, 8<<20)); err != nil {
+		t.Fatal(err)
+	}
+	w := post(10 << 20)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("quota in use: status %d, Retry-After %q; want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	held.Close()
+	if area.Used() != 0 {
+		t.Errorf("%d bytes still held", area.Used())
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sendfileHeader names a file maboo sends in place of the body PHP
+// wrote, as X-Sendfile does under Apache and X-Accel-Redirect under nginx.
+const sendfileHeader = "X-Maboo-Sendfile"
+
+var (
+	errRangeIgnored       = errors.New("range ignored")
+	errRangeUnsatisfiable = errors.New("range not satisfiable")
+)
+
+// sendFile answers with the file PHP named in X-Maboo-Sendfile, through
+// http.ServeContent, so ranges, If-Range and conditional requests work as
+// they do for static files. The path is relative to app.root, or absolute
+// inside it; a path leading out of app.root is refused.
+func (r *Router) sendFile(w http.ResponseWriter, req *http.Request, name string) {
+	root := r.cfg.Load().App.Root
+	if root == "" {
+		root = "."
+	}
+	rel := name
+	if filepath.IsAbs(name) {
+		abs, err := filepath.Abs(root)
+		if err == nil {
+			rel, err = filepath.Rel(abs, name)
+		}
+		if err != nil {
+			rel = name
+		}
+	}
+
+	f, err := os.OpenInRoot(root, rel)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		r.logger.WarnContext(req.Context(), "refusing sendfile from PHP", "file", name, "error", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Del("Content-Length")
+	http.ServeContent(w, req, fi.Name(), fi.ModTime(), f)
+}
+
+// writeRange answers a Range request for a response PHP buffered and
+// marked Accept-Ranges: bytes. A single range is answered with 206, and
+// one starting past the end with 416. A request for several ranges, or
+// whose If-Range no longer matches, gets the whole body with 200.
+func writeRange(w http.ResponseWriter, req *http.Request, body []byte) {
+	h := w.Header()
+	size := int64(len(body))
+	start, length, err := parseRange(req.Header.Get("Range"), size)
+	if err == nil || errors.Is(err, errRangeUnsatisfiable) {
+		if !ifRangeMatches(req, h) {
+			err = errRangeIgnored
+		}
+	}
+	switch {
+	case errors.Is(err, errRangeUnsatisfiable):
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable)
+	case err != nil:
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	default:
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		h.Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(body[start : start+length])
+	}
+}
+
+// acceptsRanges reports whether req asks for part of a response with
+// headers h, which PHP offered in byte ranges.
+func acceptsRanges(req *http.Request, h http.Header) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Header.Get("Range") != "" && strings.EqualFold(strings.TrimSpace(h.Get("Accept-Ranges")), "bytes")
+}
+
+// parseRange reads a Range header for a body of size bytes. It returns
+// errRangeIgnored for a header to be ignored: another unit, a malformed
+// range or more than one range.
+func parseRange(header string, size int64) (start, length int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errRangeIgnored
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errRangeIgnored
+	}
+
+	if first == "" {
+		// A suffix: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errRangeIgnored
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeUnsatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errRangeIgnored
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errRangeIgnored
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, errRangeUnsatisfiable
+	}
+	return start, end - start + 1, nil
+}
+
+// ifRangeMatches reports whether req's If-Range, if any, still names the
+// response with headers h: its strong ETag, or exactly its Last-Modified.
+func ifRangeMatches(req *http.Request, h http.Header) bool {
+	cond := strings.TrimSpace(req.Header.Get("If-Range"))
+	if cond == "" {
+		return true
+	}
+	if strings.HasPrefix(cond, `"`) || strings.HasPrefix(cond, "W/") {
+		return strings.HasPrefix(cond, `"`) && cond == strings.TrimSpace(h.Get("ETag"))
+	}
+	at, err := http.ParseTime(cond)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && at.Equal(modified)
+}
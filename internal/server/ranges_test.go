@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// downloadPool answers every request with the same headers and body.
+type downloadPool struct {
+	stubPool
+	headers map[string]string
+	body    []byte
+}
+
+func (p *downloadPool) Exec(*phpengine.Context, string) (*phpengine.Response, error) {
+	return &phpengine.Response{Status: http.StatusOK, Headers: p.headers, Body: p.body}, nil
+}
+
+func payload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+// get requests /download from h with the given headers.
+func get(t *testing.T, h http.Handler, headers map[string]string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/download", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+// resume fetches the rest of body from several offsets, as a client
+// resuming an interrupted download does, and checks each answer.
+func resume(t *testing.T, h http.Handler, body []byte, etag string) {
+	t.Helper()
+	size := len(body)
+	for _, offset := range []int{0, 1, 4095, size / 2, size - 1} {
+		resp := get(t, h, map[string]string{"Range": "bytes=" + strconv.Itoa(offset) + "-", "If-Range": etag})
+		got, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("offset %d: status %d, want 206", offset, resp.StatusCode)
+		}
+		want := "bytes " + strconv.Itoa(offset) + "-" + strconv.Itoa(size-1) + "/" + strconv.Itoa(size)
+		if cr := resp.Header.Get("Content-Range"); cr != want {
+			t.Errorf("offset %d: Content-Range %q, want %q", offset, cr, want)
+		}
+		if cl := resp.Header.Get("Content-Length"); cl != strconv.Itoa(size-offset) {
+			t.Errorf("offset %d: Content-Length %q, want %d", offset, cl, size-offset)
+		}
+		if !bytes.Equal(got, body[offset:]) {
+			t.Errorf("offset %d: body differs from the download's tail", offset)
+		}
+	}
+}
+
+func TestRouterResumesDownload(t *testing.T) {
+	body := payload(10_000)
+	pool := &downloadPool{body: body, headers: map[string]string{
+		"Content-Type":  "application/zip",
+		"Accept-Ranges": "bytes",
+		"ETag":          `"v1"`,
+		"Last-Modified": "Mon, 02 Jan 2006 15:04:05 GMT",
+	}}
+	h := NewRouter(appConfig(t), pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	resume(t, h, body, `"v1"`)
+
+	for _, tc := range []struct {
+		name         string
+		headers      map[string]string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{"no range", nil, 200, "", body},
+		{"closed range", map[string]string{"Range": "bytes=10-19"}, 206, "bytes 10-19/10000", body[10:20]},
+		{"range past the end", map[string]string{"Range": "bytes=9990-20000"}, 206, "bytes 9990-9999/10000", body[9990:]},
+		{"suffix", map[string]string{"Range": "bytes=-100"}, 206, "bytes 9900-9999/10000", body[9900:]},
+		{"if-range date", map[string]string{"Range": "bytes=5-", "If-Range": "Mon, 02 Jan 2006 15:04:05 GMT"}, 206, "bytes 5-9999/10000", body[5:]},
+		{"changed etag", map[string]string{"Range": "bytes=5-", "If-Range": `"v2"`}, 200, "", body},
+		{"weak etag", map[string]string{"Range": "bytes=5-", "If-Range": `W/"v1"`}, 200, "", body},
+		{"multipart", map[string]string{"Range": "bytes=0-1,5-6"}, 200, "", body},
+		{"malformed", map[string]string{"Range": "bytes=9-1"}, 200, "", body},
+		{"unsatisfiable", map[string]string{"Range": "bytes=10000-"}, 416, "bytes */10000", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := get(t, h, tc.headers)
+			got, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tc.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tc.status)
+			}
+			if cr := resp.Header.Get("Content-Range"); cr != tc.contentRange {
+				t.Errorf("Content-Range %q, want %q", cr, tc.contentRange)
+			}
+			if tc.body != nil && !bytes.Equal(got, tc.body) {
+				t.Errorf("body of %d bytes, want %d", len(got), len(tc.body))
+			}
+		})
+	}
+
+	// Without Accept-Ranges, PHP's body goes out whole.
+	delete(pool.headers, "Accept-Ranges")
+	if resp := get(t, h, map[string]string{"Range": "bytes=10-19"}); resp.StatusCode != 200 {
+		t.Errorf("range without Accept-Ranges: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestRouterSendfile(t *testing.T) {
+	cfg := appConfig(t)
+	root := cfg.App.Root
+	body := payload(100_000)
+	if err := os.MkdirAll(filepath.Join(root, "storage"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "storage", "release.zip"), body, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pool := &downloadPool{body: []byte("ignored"), headers: map[string]string{
+		"Content-Type": "application/zip",
+		"ETag":         `"r1"`,
+	}}
+	h := NewRouter(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, name := range []string{"storage/release.zip", filepath.Join(root, "storage", "release.zip")} {
+		pool.headers[sendfileHeader] = name
+		resp := get(t, h, nil)
+		got, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != 200 || !bytes.Equal(got, body) {
+			t.Fatalf("%s: status %d with %d bytes, want the file", name, resp.StatusCode, len(got))
+		}
+		if v := resp.Header.Get(sendfileHeader); v != "" {
+			t.Errorf("%s: %s %q sent to the client", name, sendfileHeader, v)
+		}
+		if resp.Header.Get("Accept-Ranges") != "bytes" {
+			t.Errorf("%s: Accept-Ranges %q", name, resp.Header.Get("Accept-Ranges"))
+		}
+		resume(t, h, body, `"r1"`)
+	}
+
+	for name, status := range map[string]int{
+		"storage/missing.zip":  http.StatusNotFound,
+		"storage":              http.StatusNotFound,
+		"../outside.zip":       http.StatusBadGateway,
+		"/etc/passwd":          http.StatusBadGateway,
+		"storage/../../escape": http.StatusBadGateway,
+	} {
+		pool.headers[sendfileHeader] = name
+		if resp := get(t, h, nil); resp.StatusCode != status {
+			t.Errorf("%s: status %d, want %d", name, resp.StatusCode, status)
+		}
+	}
+}
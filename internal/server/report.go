@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/sadewadee/maboo/internal/errreport"
+)
+
+// requestReporter reports errors met while handling requests, tagged with
+// what identifies the request.
+type requestReporter struct {
+	reporter errreport.Reporter
+	metrics  *Metrics // maps paths to the route label of metrics.route_labels
+}
+
+// report sends e for r, adding the request ID, trace ID, method and route
+// to its tags and the path to its extra context.
+func (rr *requestReporter) report(r *http.Request, e errreport.Event) {
+	if rr == nil {
+		return
+	}
+	if e.Tags == nil {
+		e.Tags = make(map[string]string)
+	}
+	if e.Extra == nil {
+		e.Extra = make(map[string]string)
+	}
+	e.Tags["request_id"] = r.Header.Get("X-Request-ID")
+	if rc := GetRequestCtx(r.Context()); rc != nil && rc.TraceID != "" {
+		e.Tags["trace_id"] = rc.TraceID
+	}
+	e.Tags["method"] = r.Method
+	e.Tags["route"] = rr.metrics.routeLabel(rr.metrics.routeIndex(r.URL.Path))
+	e.Extra["path"] = r.URL.Path
+	rr.reporter.Report(e)
+}
+
+// SetReporter sends recovered panics and PHP errors to reporter instead of
+// only logging them, and crash loops of PHP worker processes serving HTTP.
+// Call before Start.
+func (s *Server) SetReporter(reporter errreport.Reporter) {
+	s.reports.reporter = reporter
+	if p, ok := s.pool.(interface{ SetReporter(errreport.Reporter) }); ok {
+		p.SetReporter(reporter)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// eventRecorder is an errreport.Reporter keeping what it is sent.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []errreport.Event
+}
+
+func (r *eventRecorder) Report(e errreport.Event) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+func (r *eventRecorder) Close(context.Context) error { return nil }
+
+// failingPool fails every request, as a worker dying mid-request would.
+type failingPool struct{ stubPool }
+
+func (p *failingPool) Exec(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+	ctx.WorkerID = 3
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestReportPanic(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Metrics.RouteLabels = []string{"/api/users/:id"}
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	rec := &eventRecorder{}
+	s.SetReporter(rec)
+	h := CoreMiddleware(s.logger, s.logger, nil, cfg.Tracing, s.reports.report)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("nil map write")
+	}))
+
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest("POST", "/api/users/7", nil))
+	if resp.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", resp.Code)
+	}
+	if len(rec.events) != 1 {
+		t.Fatalf("%d events reported, want 1", len(rec.events))
+	}
+	e := rec.events[0]
+	if e.Kind != errreport.KindPanic || e.Level != errreport.LevelFatal || e.Message != "nil map write" {
+		t.Errorf("event = %s/%s %q", e.Kind, e.Level, e.Message)
+	}
+	if e.Tags["request_id"] != resp.Header().Get("X-Request-ID") || e.Tags["route"] != "/api/users/:id" || e.Tags["method"] != "POST" {
+		t.Errorf("tags = %v", e.Tags)
+	}
+	if e.Extra["path"] != "/api/users/7" || !strings.Contains(e.Extra["stack"], "TestReportPanic") {
+		t.Errorf("extra = %v", e.Extra)
+	}
+}
+
+func TestReportPHPError(t *testing.T) {
+	s := New(appConfig(t), &failingPool{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	rec := &eventRecorder{}
+	s.SetReporter(rec)
+
+	resp := httptest.NewRecorder()
+	s.router.ServeHTTP(resp, httptest.NewRequest("GET", "/checkout", nil))
+	if resp.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502", resp.Code)
+	}
+	if len(rec.events) != 1 {
+		t.Fatalf("%d events reported, want 1", len(rec.events))
+	}
+	if e := rec.events[0]; e.Kind != errreport.KindPHPError || e.Tags["worker_id"] != "3" || e.Tags["route"] != "other" {
+		t.Errorf("event = %+v", e)
+	}
+}
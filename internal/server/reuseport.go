@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net"
+	"runtime"
+	"strconv"
+)
+
+// mainListeners binds server.address. With server.reuse_port it binds
+// server.listeners sockets with SO_REUSEPORT, and the kernel spreads new
+// connections across them so no single accept loop is the bottleneck.
+// Another process can then bind the port too, e.g. a new maboo started
+// before the old one is stopped. Where that is unsupported, or the first
+// socket cannot be bound that way, it falls back to one plain listener.
+func (s *Server) mainListeners() ([]net.Listener, error) {
+	addr := s.cfg.Server.Address
+	if s.cfg.Server.ReusePort && !reusePortSupported {
+		s.logger.Warn("server.reuse_port is not supported on this platform, using one listener")
+	}
+	if !s.cfg.Server.ReusePort || !reusePortSupported {
+		ln, err := s.listen("http", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+
+	n := s.cfg.Server.Listeners
+	if n == 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	lc := &net.ListenConfig{Control: reusePort}
+	first, err := s.listenConfig(lc, "http", addr)
+	if err != nil {
+		s.logger.Warn("binding with SO_REUSEPORT failed, using one listener", "error", err)
+		if first, err = s.listen("http", addr); err != nil {
+			return nil, err
+		}
+		return []net.Listener{first}, nil
+	}
+	// The others bind the port the first got, should addr not name one.
+	addr = first.Addr().String()
+	lns := []net.Listener{first}
+	for i := 1; i < n; i++ {
+		ln, err := s.listenConfig(lc, "http."+strconv.Itoa(i), addr)
+		if err != nil {
+			s.logger.Warn("binding another SO_REUSEPORT listener failed", "listeners", len(lns), "error", err)
+			break
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// serveAll serves each listener with serve until one of them stops, and
+// returns why.
+func serveAll(lns []net.Listener, serve func(net.Listener) error) error {
+	if len(lns) == 1 {
+		return serve(lns[0])
+	}
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() { errs <- serve(ln) }()
+	}
+	return <-errs
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package server
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortSupported reports whether server.reuse_port spreads
+// connections across listeners on this platform.
+const reusePortSupported = true
+
+// reusePort is a net.ListenConfig control function setting SO_REUSEPORT,
+// so several sockets, in this process or another, can bind one address.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
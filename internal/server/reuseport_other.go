@@ -0,0 +1,14 @@
+//go:build !linux
+
+package server
+
+import "syscall"
+
+// reusePortSupported reports whether server.reuse_port spreads
+// connections across listeners on this platform. Elsewhere SO_REUSEPORT
+// either does not balance connections or does not exist.
+const reusePortSupported = false
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+// startReusePort starts a server on a free port with n SO_REUSEPORT
+// listeners and returns its address.
+func startReusePort(tb testing.TB, n int) (*Server, string) {
+	tb.Helper()
+	if !reusePortSupported {
+		tb.Skip("SO_REUSEPORT listeners are only used on Linux")
+	}
+	cfg := config.Default()
+	cfg.Server.Address = "127.0.0.1:0"
+	cfg.Server.ReusePort = true
+	cfg.Server.Listeners = n
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+	select {
+	case <-s.Listening():
+	case err := <-started:
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		s.Stop(context.Background())
+		if err := <-started; err != http.ErrServerClosed {
+			tb.Errorf("Start = %v, want http.ErrServerClosed", err)
+		}
+	})
+	return s, s.mainLns[0].Addr().String()
+}
+
+func TestReusePortListeners(t *testing.T) {
+	s, addr := startReusePort(t, 3)
+	if len(s.mainLns) != 3 {
+		t.Fatalf("%d listeners, want 3", len(s.mainLns))
+	}
+	for _, ln := range s.mainLns {
+		if ln.Addr().String() != addr {
+			t.Errorf("listener on %s, want all on %s", ln.Addr(), addr)
+		}
+	}
+
+	// Another process, such as the next maboo, can bind the port too.
+	lc := net.ListenConfig{Control: reusePort}
+	other, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("second bind: %v", err)
+	}
+	other.Close()
+
+	for range 20 {
+		resp, err := http.Get("http://" + addr + "/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+	}
+}
+
+// BenchmarkAccept measures new connections per second, each sending one
+// request, against one accept loop and against one listener per CPU. The
+// difference only shows with several CPUs and many concurrent clients, e.g.
+// go test -run x -bench Accept -cpu 8 ./internal/server.
+func BenchmarkAccept(b *testing.B) {
+	for _, n := range slices.Compact([]int{1, runtime.GOMAXPROCS(0)}) {
+		b.Run(fmt.Sprintf("listeners=%d", n), func(b *testing.B) {
+			_, addr := startReusePort(b, n)
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Get("http://" + addr + "/health")
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sadewadee/maboo/internal/clientip"
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+// Rewrite flags, as in rewrites.rules[].flag. Without one, the following
+// rules see the rewritten URL.
+const (
+	rewriteLast     = "last"     // stop this pass
+	rewriteRedirect = "redirect" // answer with a redirect
+	rewritePHP      = "php"      // hand the request to PHP, skipping static files
+)
+
+// rewriter is rewrites, compiled. Like mod_rewrite in .htaccess, the rules
+// run again on a path a pass has changed, until a pass leaves the path
+// alone; maxPasses stops rules that keep rewriting each other.
+type rewriter struct {
+	rules     []rewriteRule
+	maxPasses int
+	roots     []string // checked by unless_exists: static.root, app.root
+	clients   *clientip.Resolver
+	logger    *slog.Logger
+}
+
+type rewriteRule struct {
+	match        *regexp.Regexp
+	host         *regexp.Regexp // nil: any host
+	query        *regexp.Regexp // nil: any query string
+	unlessExists bool
+	keep         bool   // to is "-": only the flag applies
+	toPath       string // the substitution before its "?"
+	toQuery      string // and after it
+	hasQuery     bool   // to has a "?", so it replaces the query string
+	appendQuery  bool
+	flag         string
+	status       int
+}
+
+// rewriteOrigin records the URL a rewritten request came in with.
+type rewriteOrigin struct {
+	path, query string
+	php         bool // rewritten with the php flag
+}
+
+type rewriteKey struct{}
+
+// newRewriter compiles cfg.Rewrites, or returns nil without rules.
+// Validate has checked the rules; one that fails to compile anyway is
+// skipped.
+func newRewriter(cfg *config.Config, logger *slog.Logger) *rewriter {
+	clients, _ := clientip.New(cfg.Server.TrustedProxies)
+	rw := &rewriter{maxPasses: cfg.Rewrites.MaxIterations, clients: clients, logger: logger}
+	if rw.maxPasses <= 0 {
+		rw.maxPasses = 10
+	}
+	for _, root := range []string{cfg.Static.Root, cfg.App.Root} {
+		if root != "" {
+			rw.roots = append(rw.roots, root)
+		}
+	}
+	for _, r := range cfg.Rewrites.Rules {
+		rule, err := compileRewrite(r)
+		if err != nil {
+			continue
+		}
+		rw.rules = append(rw.rules, rule)
+	}
+	if len(rw.rules) == 0 {
+		return nil
+	}
+	return rw
+}
+
+func compileRewrite(r config.RewriteRule) (rewriteRule, error) {
+	rule := rewriteRule{
+		unlessExists: r.UnlessExists,
+		keep:         r.To == "-",
+		appendQuery:  r.AppendQuery,
+		flag:         r.Flag,
+		status:       r.Status,
+	}
+	if rule.status == 0 {
+		rule.status = http.StatusFound
+	}
+	var err error
+	if rule.match, err = regexp.Compile(r.Match); err != nil {
+		return rule, err
+	}
+	if r.Host != "" {
+		if rule.host, err = regexp.Compile(r.Host); err != nil {
+			return rule, err
+		}
+	}
+	if r.Query != "" {
+		if rule.query, err = regexp.Compile(r.Query); err != nil {
+			return rule, err
+		}
+	}
+	rule.toPath, rule.toQuery, rule.hasQuery = strings.Cut(r.To, "?")
+	return rule, nil
+}
+
+// rewrite runs the rules over req. It returns the request to serve, which
+// is req itself when no rule changed its URL, and whether it has answered
+// req already, with a redirect or, when the rules loop, an error.
+func (rw *rewriter) rewrite(w http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	if rw == nil {
+		return req, false
+	}
+	p, q := req.URL.Path, req.URL.RawQuery
+	host := requestHost(rw.clients, req)
+	php := false
+passes:
+	for pass := 0; ; pass++ {
+		if pass == rw.maxPasses {
+			rw.logger.ErrorContext(req.Context(), "rewrite rules loop", "path", req.URL.Path, "rewritten", p, "max_iterations", rw.maxPasses)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return req, true
+		}
+		start := p
+		for i := range rw.rules {
+			r := &rw.rules[i]
+			np, nq, ok := r.apply(p, q, host, rw.exists)
+			if !ok {
+				continue
+			}
+			if r.flag == rewriteRedirect {
+				target := np
+				if nq != "" {
+					target += "?" + nq
+				}
+				http.Redirect(w, req, target, r.status)
+				return req, true
+			}
+			p, q = np, nq
+			if r.flag == rewritePHP {
+				php = true
+				break passes
+			}
+			if r.flag == rewriteLast {
+				break
+			}
+		}
+		if p == start {
+			break
+		}
+	}
+	if p == req.URL.Path && q == req.URL.RawQuery && !php {
+		return req, false
+	}
+
+	origin := &rewriteOrigin{path: req.URL.Path, query: req.URL.RawQuery, php: php}
+	r2 := req.WithContext(context.WithValue(req.Context(), rewriteKey{}, origin))
+	u := *req.URL
+	u.Path, u.RawPath, u.RawQuery = p, "", q
+	r2.URL = &u
+	return r2, false
+}
+
+// apply rewrites path p and query q if the rule matches them and host.
+// Path captures fill $1..$9 and ${name}, those of the query condition
+// %1..%9; in the query they are escaped, so a capture cannot add
+// parameters.
+func (r *rewriteRule) apply(p, q, host string, exists func(string) bool) (string, string, bool) {
+	m := r.match.FindStringSubmatch(p)
+	if m == nil {
+		return "", "", false
+	}
+	if r.host != nil && !r.host.MatchString(host) {
+		return "", "", false
+	}
+	var qm []string
+	if r.query != nil {
+		if qm = r.query.FindStringSubmatch(q); qm == nil {
+			return "", "", false
+		}
+	}
+	if r.unlessExists && exists(p) {
+		return "", "", false
+	}
+	if r.keep {
+		return p, q, true
+	}
+
+	np := expandRewrite(r.toPath, r.match, m, qm, func(s string) string { return s })
+	if !r.hasQuery {
+		return np, q, true
+	}
+	nq := expandRewrite(r.toQuery, r.match, m, qm, url.QueryEscape)
+	if r.appendQuery && q != "" {
+		if nq == "" {
+			nq = q
+		} else {
+			nq += "&" + q
+		}
+	}
+	return np, nq, true
+}
+
+// expandRewrite substitutes $N, ${N}, ${name} and %N in tpl, passing each
+// capture through esc. Unknown references expand to nothing; "$$" and
+// "%%" are literal.
+func expandRewrite(tpl string, re *regexp.Regexp, m, qm []string, esc func(string) string) string {
+	if !strings.ContainsAny(tpl, "$%") {
+		return tpl
+	}
+	var b strings.Builder
+	for i := 0; i < len(tpl); i++ {
+		c := tpl[i]
+		if (c != '$' && c != '%') || i+1 == len(tpl) {
+			b.WriteByte(c)
+			continue
+		}
+		next := tpl[i+1]
+		switch {
+		case next == c:
+			b.WriteByte(c)
+			i++
+		case next >= '0' && next <= '9':
+			groups := m
+			if c == '%' {
+				groups = qm
+			}
+			if n := int(next - '0'); n < len(groups) {
+				b.WriteString(esc(groups[n]))
+			}
+			i++
+		case c == '$' && next == '{':
+			end := strings.IndexByte(tpl[i:], '}')
+			if end < 0 {
+				b.WriteByte(c)
+				continue
+			}
+			name := tpl[i+2 : i+end]
+			if n := re.SubexpIndex(name); n >= 0 {
+				b.WriteString(esc(m[n]))
+			} else if len(name) == 1 && name[0] >= '0' && name[0] <= '9' && int(name[0]-'0') < len(m) {
+				b.WriteString(esc(m[name[0]-'0']))
+			}
+			i += end
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// exists reports whether p names a file or directory under one of the
+// roots.
+func (rw *rewriter) exists(p string) bool {
+	name := filepath.FromSlash(path.Clean("/" + p))
+	for _, root := range rw.roots {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrittenFrom returns the URL req came in with when rewrites changed
+// it, or nil.
+func rewrittenFrom(req *http.Request) *rewriteOrigin {
+	o, _ := req.Context().Value(rewriteKey{}).(*rewriteOrigin)
+	return o
+}
+
+// clientPath returns the path the client asked for, before any rewrite.
+// Route templates in the config refer to it.
+func clientPath(req *http.Request) string {
+	if o := rewrittenFrom(req); o != nil {
+		return o.path
+	}
+	return req.URL.Path
+}
+
+// rewrittenScript returns the PHP file, relative to docRoot, that a
+// rewritten request names, or "" when it names none and the entry point
+// runs.
+func rewrittenScript(req *http.Request, docRoot string) string {
+	if rewrittenFrom(req) == nil || !strings.HasSuffix(req.URL.Path, ".php") {
+		return ""
+	}
+	rel := strings.TrimPrefix(path.Clean("/"+req.URL.Path), "/")
+	if fi, err := os.Stat(filepath.Join(docRoot, filepath.FromSlash(rel))); err != nil || !fi.Mode().IsRegular() {
+		return ""
+	}
+	return rel
+}
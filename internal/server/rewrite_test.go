@@ -0,0 +1,210 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+func newTestRewriter(t *testing.T, rules ...config.RewriteRule) *rewriter {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Static.Root = ""
+	cfg.App.Root = t.TempDir()
+	cfg.Rewrites.Rules = rules
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return newRewriter(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestRewriteSubstitution(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   config.RewriteRule
+		target string // request path and query
+		want   string // rewritten path and query; "" = untouched
+	}{
+		{"captures", config.RewriteRule{Match: `^/product/(\d+)/([a-z-]+)$`, To: "/product.php?id=$1&slug=$2"},
+			"/product/42/red-lamp", "/product.php?id=42&slug=red-lamp"},
+		{"braced and named", config.RewriteRule{Match: `^/u/(?P<user>[^/]+)/(\d)$`, To: "/profile/${user}/${2}0"},
+			"/u/ada/7", "/profile/ada/70"},
+		{"no match", config.RewriteRule{Match: `^/product/(\d+)$`, To: "/product.php?id=$1"},
+			"/product/lamp", ""},
+		{"missing group is empty", config.RewriteRule{Match: `^/a/(.*)$`, To: "/b/$3"},
+			"/a/x", "/b/"},
+		{"literal dollar and percent", config.RewriteRule{Match: `^/price$`, To: "/p.php?c=$$&d=100%%"},
+			"/price", "/p.php?c=$&d=100%"},
+		{"captures escaped in the query", config.RewriteRule{Match: `^/search/(.*)$`, To: "/s.php?q=$1"},
+			"/search/a&admin=1%20b", "/s.php?q=a%26admin%3D1+b"},
+		{"no ? keeps the query", config.RewriteRule{Match: `^/old/(.*)$`, To: "/new/$1"},
+			"/old/page?utm=x", "/new/page?utm=x"},
+		{"? replaces the query", config.RewriteRule{Match: `^/old/(.*)$`, To: "/new.php?p=$1"},
+			"/old/page?utm=x", "/new.php?p=page"},
+		{"trailing ? drops the query", config.RewriteRule{Match: `^/old$`, To: "/new?"},
+			"/old?utm=x", "/new"},
+		{"append_query merges", config.RewriteRule{Match: `^/old/(.*)$`, To: "/new.php?p=$1", AppendQuery: true},
+			"/old/page?utm=x&p=2", "/new.php?p=page&utm=x&p=2"},
+		{"append_query without a new query", config.RewriteRule{Match: `^/old$`, To: "/new?", AppendQuery: true},
+			"/old?utm=x", "/new?utm=x"},
+		{"query condition captures", config.RewriteRule{Match: `^/item\.php$`, Query: `^id=(\d+)$`, To: "/items/%1?"},
+			"/item.php?id=9", "/items/9"},
+		{"query condition fails", config.RewriteRule{Match: `^/item\.php$`, Query: `^id=(\d+)$`, To: "/items/%1?"},
+			"/item.php?id=x", ""},
+		{"host condition", config.RewriteRule{Match: `^/(post)$`, Host: `^blog\.`, To: "/blog/$1", Flag: "last"},
+			"/post", "/blog/post"},
+		{"dash keeps the URL", config.RewriteRule{Match: `^/index\.php$`, To: "-", Flag: "last"},
+			"/index.php?a=1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := newTestRewriter(t, tt.rule)
+			req := httptest.NewRequest("GET", "http://blog.example.com"+tt.target, nil)
+			w := httptest.NewRecorder()
+			got, answered := rw.rewrite(w, req)
+			if answered {
+				t.Fatalf("answered with %d", w.Code)
+			}
+			if tt.want == "" {
+				if got != req {
+					t.Errorf("rewritten to %s", got.URL.RequestURI())
+				}
+				return
+			}
+			if uri := got.URL.RequestURI(); uri != tt.want {
+				t.Errorf("rewritten to %s, want %s", uri, tt.want)
+			}
+			if o := rewrittenFrom(got); o == nil || o.path != req.URL.Path || o.query != req.URL.RawQuery {
+				t.Errorf("origin = %+v", o)
+			}
+			if req.URL.RequestURI() != tt.target {
+				t.Errorf("original request changed to %s", req.URL.RequestURI())
+			}
+		})
+	}
+}
+
+func TestRewritePasses(t *testing.T) {
+	// Rules without a flag chain; a changed path runs the rules again.
+	rw := newTestRewriter(t,
+		config.RewriteRule{Match: `^/a/(.*)$`, To: "/b/$1"},
+		config.RewriteRule{Match: `^/b/(.*)$`, To: "/c/$1", Flag: "last"},
+		config.RewriteRule{Match: `^/c/(.*)$`, To: "/d/$1"},
+	)
+	req, _ := rw.rewrite(httptest.NewRecorder(), httptest.NewRequest("GET", "/a/x", nil))
+	if req.URL.Path != "/d/x" {
+		t.Errorf("path = %s, want /d/x", req.URL.Path)
+	}
+
+	// Rules that never settle fail the request.
+	rw = newTestRewriter(t, config.RewriteRule{Match: `^/(.*)$`, To: "/index.php/$1", Flag: "last"})
+	w := httptest.NewRecorder()
+	if _, answered := rw.rewrite(w, httptest.NewRequest("GET", "/loop", nil)); !answered || w.Code != http.StatusInternalServerError {
+		t.Errorf("looping rules: answered %v with %d", answered, w.Code)
+	}
+}
+
+func TestRewriteRedirect(t *testing.T) {
+	rw := newTestRewriter(t,
+		config.RewriteRule{Match: `^/old-blog/(.*)$`, To: "https://blog.example.com/$1", Flag: "redirect", Status: 301, AppendQuery: true},
+		config.RewriteRule{Match: `^/moved$`, To: "/new?from=moved", Flag: "redirect"},
+	)
+	for target, want := range map[string]struct {
+		code     int
+		location string
+	}{
+		"/old-blog/post?ref=x": {http.StatusMovedPermanently, "https://blog.example.com/post?ref=x"},
+		"/moved":               {http.StatusFound, "/new?from=moved"},
+	} {
+		w := httptest.NewRecorder()
+		if _, answered := rw.rewrite(w, httptest.NewRequest("GET", target, nil)); !answered {
+			t.Fatalf("%s: not redirected", target)
+		}
+		if w.Code != want.code || w.Header().Get("Location") != want.location {
+			t.Errorf("%s: %d %s, want %d %s", target, w.Code, w.Header().Get("Location"), want.code, want.location)
+		}
+	}
+}
+
+// scriptPool records the script and $_SERVER each request ran with.
+type scriptPool struct {
+	stubPool
+	mu     sync.Mutex
+	script string
+	server map[string]string
+	get    map[string]string
+}
+
+func (p *scriptPool) Exec(ctx *phpengine.Context, script string) (*phpengine.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.script, p.server, p.get = script, ctx.Server, ctx.Get
+	return &phpengine.Response{Status: http.StatusOK}, nil
+}
+
+func TestRewriteToPHP(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"index.php", "product.php", "style.css"} {
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := config.Default()
+	cfg.App.Root = root
+	cfg.Static.Root = root
+	cfg.Rewrites.Rules = []config.RewriteRule{
+		{Match: `^/product/(\d+)$`, To: "/product.php?id=$1", Flag: "last", AppendQuery: true},
+		{Match: `^/assets/(.*)$`, To: "/index.php?asset=$1", Flag: "php"},
+		{Match: `^/(.*)$`, To: "/index.php?q=$1", UnlessExists: true, Flag: "last"},
+	}
+	pool := &scriptPool{}
+	router := NewRouter(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	get := func(target string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w.Code
+	}
+
+	get("/product/7?ref=home")
+	if pool.script != filepath.Join(root, "product.php") {
+		t.Errorf("script = %s, want product.php", pool.script)
+	}
+	for k, want := range map[string]string{
+		"REQUEST_URI":           "/product/7",
+		"QUERY_STRING":          "id=7&ref=home",
+		"SCRIPT_NAME":           "/product.php",
+		"REDIRECT_URL":          "/product/7",
+		"REDIRECT_QUERY_STRING": "ref=home",
+	} {
+		if got := pool.server[k]; got != want {
+			t.Errorf("$_SERVER[%s] = %q, want %q", k, got, want)
+		}
+	}
+	if pool.get["id"] != "7" {
+		t.Errorf("$_GET = %v", pool.get)
+	}
+
+	// The php flag skips static files; unless_exists leaves real files
+	// alone.
+	pool.script = ""
+	get("/assets/app.css")
+	if pool.server["QUERY_STRING"] != "asset=app.css" {
+		t.Errorf("php flag: QUERY_STRING = %q", pool.server["QUERY_STRING"])
+	}
+	pool.script = ""
+	if code := get("/style.css"); code != http.StatusOK || pool.script != "" {
+		t.Errorf("existing file: %d, ran %q", code, pool.script)
+	}
+	get("/about")
+	if pool.server["QUERY_STRING"] != "q=about" || pool.server["REQUEST_URI"] != "/about" {
+		t.Errorf("front controller: $_SERVER = %v", pool.server)
+	}
+}
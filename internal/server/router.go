@@ -1,32 +1,64 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/sadewadee/maboo/internal/clientip"
 	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/errreport"
 	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/route"
+	"github.com/sadewadee/maboo/internal/scratch"
 )
 
 // Router dispatches incoming HTTP requests to the appropriate handler.
 type Router struct {
-	cfg           *config.Config
+	cfg           atomic.Pointer[config.Config]
+	timeouts      atomic.Pointer[routeTimeouts]
+	sandbox       atomic.Pointer[routeSandbox]
+	cachePolicy   atomic.Pointer[cachePolicy]    // nil when the response cache is off
+	coalescing    atomic.Pointer[coalescePolicy] // nil when coalescing is off
+	hints         atomic.Pointer[earlyHints]     // nil when early hints are off
+	access        atomic.Pointer[accessControl]  // nil when every request is allowed
+	rewrites      atomic.Pointer[rewriter]       // nil without rewrite rules
+	headers       atomic.Pointer[headerFilter]
+	etags         atomic.Pointer[etagPolicy]        // nil when etag is off
+	compression   atomic.Pointer[compressionPolicy] // nil when compression is off
+	entry         atomic.Pointer[entryState]        // nil until the first check
+	canonical     atomic.Pointer[canonicalHost]     // nil without server.canonical_host
+	clients       atomic.Pointer[clientip.Resolver]
+	cache         *responseCache
+	coalescer     *coalescer
+	reports       *requestReporter // nil: errors are only logged
 	pool          Pool
 	logger        *slog.Logger
 	static        http.Handler
 	phpHandler    http.Handler
 	healthHandler *HealthHandler
+	wsPath        string
+	wsHandler     http.Handler // nil unless websocket.enabled
 }
 
 // NewRouter creates a new request router.
 func NewRouter(cfg *config.Config, workerPool Pool, logger *slog.Logger) *Router {
 	r := &Router{
-		cfg:    cfg,
-		pool:   workerPool,
-		logger: logger,
+		pool:      workerPool,
+		logger:    logger,
+		cache:     newResponseCache(0),
+		coalescer: newCoalescer(),
 	}
+	r.SetConfig(cfg)
 
 	// Static file handler
 	if cfg.Static.Root != "" {
@@ -38,10 +70,50 @@ func NewRouter(cfg *config.Config, workerPool Pool, logger *slog.Logger) *Router
 
 	// Health check handler
 	r.healthHandler = NewHealthHandler(workerPool)
+	r.healthHandler.sizing = func() config.PoolSizing { return r.cfg.Load().PoolSizing() }
+	r.healthHandler.entry = func() error { return r.entryPoint(r.cfg.Load()).err }
 
 	return r
 }
 
+// SetConfig swaps the configuration used per request (static
+// cache-control, app root and entry, timeouts, response cache,
+// coalescing, early hints, access control, rewrites, canonical host, response headers). The static file root is fixed at
+// construction.
+func (r *Router) SetConfig(cfg *config.Config) {
+	r.timeouts.Store(newRouteTimeouts(cfg.Pool))
+	r.sandbox.Store(newRouteSandbox(cfg.PHP.Sandbox))
+	r.access.Store(newAccessControl(cfg))
+	r.rewrites.Store(newRewriter(cfg, r.logger))
+	r.headers.Store(newHeaderFilter(cfg.Headers))
+	r.etags.Store(newETagPolicy(cfg.ETag))
+	r.compression.Store(newCompressionPolicy(cfg.Compression))
+	r.hints.Store(newEarlyHints(cfg.Hints))
+	r.canonical.Store(newCanonicalHost(cfg))
+	if clients, err := clientip.New(cfg.Server.TrustedProxies); err != nil {
+		// Validate rejects these; keep the proxies trusted so far.
+		r.logger.Error("server.trusted_proxies not applied", "error", err)
+	} else {
+		r.clients.Store(clients)
+	}
+	policy := newCachePolicy(cfg.Cache)
+	if policy == nil {
+		r.cache.SetMaxBytes(0)
+	} else {
+		r.cache.SetMaxBytes(cfg.Cache.MaxSize.Bytes())
+	}
+	r.cachePolicy.Store(policy)
+	r.coalescing.Store(newCoalescePolicy(cfg.Coalesce))
+	r.cfg.Store(cfg)
+}
+
+// SetWebSocket mounts the WebSocket handler at path and the paths below
+// it, ahead of static files and PHP.
+func (r *Router) SetWebSocket(path string, h http.Handler) {
+	r.wsPath = strings.TrimSuffix(path, "/")
+	r.wsHandler = h
+}
+
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Health check endpoints
 	switch req.URL.Path {
@@ -50,10 +122,29 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if r.canonical.Load().redirect(w, req) {
+		return
+	}
+
+	if r.access.Load().refuse(w, req) {
+		return
+	}
+
+	if r.wsHandler != nil && r.isWebSocketPath(req.URL.Path) {
+		r.wsHandler.ServeHTTP(w, req)
+		return
+	}
+
+	req, answered := r.rewrites.Load().rewrite(w, req)
+	if answered {
+		return
+	}
+
 	// Check if it's a static file first
-	if r.static != nil && r.isStaticFile(req.URL.Path) {
-		if r.cfg.Static.CacheControl != "" {
-			w.Header().Set("Cache-Control", r.cfg.Static.CacheControl)
+	toPHP := rewrittenFrom(req) != nil && rewrittenFrom(req).php
+	if r.static != nil && !toPHP && r.isStaticFile(req.URL.Path) {
+		if cc := r.cfg.Load().Static.CacheControl; cc != "" {
+			w.Header().Set("Cache-Control", cc)
 		}
 		r.static.ServeHTTP(w, req)
 		return
@@ -63,6 +154,11 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	r.phpHandler.ServeHTTP(w, req)
 }
 
+func (r *Router) isWebSocketPath(path string) bool {
+	rest, ok := strings.CutPrefix(path, r.wsPath)
+	return ok && (rest == "" || rest[0] == '/')
+}
+
 func (r *Router) isStaticFile(path string) bool {
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
@@ -76,31 +172,410 @@ func (r *Router) isStaticFile(path string) bool {
 
 func (r *Router) newPHPHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		// Determine document root and entry point
-		docRoot := r.cfg.App.Root
-		if docRoot == "" {
-			docRoot = "."
+		cfg := r.cfg.Load()
+		req = guardBody(w, req, cfg.Server.MinBodyRate.Bytes(), cfg.Server.BodyRateInterval.Duration())
+		if limit := cfg.Server.MaxBodySize.Bytes(); limit > 0 {
+			if req.ContentLength > limit {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			req.Body = http.MaxBytesReader(w, req.Body, limit)
 		}
 
-		entryPoint := phpengine.DetectEntryPoint(docRoot, r.cfg.App.Entry)
-		script := filepath.Join(docRoot, entryPoint)
-
-		// Create PHP context from HTTP request
-		ctx := phpengine.NewContext(req, docRoot, entryPoint)
+		policy := r.cachePolicy.Load()
+		key, bypass := policy.key(req, r.clients.Load())
+		if key != "" {
+			r.serveCached(w, req, cfg, policy, key)
+			return
+		}
+		var resp *phpengine.Response
+		coalescing := r.coalescing.Load()
+		if ckey := coalescing.key(req, r.clients.Load()); ckey != "" {
+			resp = r.serveCoalesced(w, req, cfg, coalescing, ckey)
+		} else {
+			resp = r.execPHP(w, req, cfg)
+		}
+		if resp == nil {
+			return
+		}
+		if bypass {
+			cacheRequests.WithLabelValues(cacheBypass).Inc()
+			w.Header().Set(cacheHeader, "BYPASS")
+		}
+		r.writeResponse(w, req, resp)
+	})
+}
 
-		// Dispatch to worker pool
-		resp, err := r.pool.Exec(ctx, script)
-		if err != nil {
-			r.logger.Error("worker exec", "error", err)
-			http.Error(w, "Internal Server Error: "+err.Error(), http.StatusBadGateway)
+// serveCached answers a request to a cached route from the response
+// cache, or else from PHP, storing the response if it can be shared.
+// Concurrent misses for the same key run PHP once; the others wait for
+// its response.
+func (r *Router) serveCached(w http.ResponseWriter, req *http.Request, cfg *config.Config, policy *cachePolicy, key string) {
+	e, fill, leader := r.cache.lookup(key)
+	if e == nil && !leader {
+		select {
+		case <-fill.done:
+			e = fill.entry
+		case <-req.Context().Done():
 			return
 		}
+	}
+	if e != nil {
+		cacheRequests.WithLabelValues(cacheHit).Inc()
+		w.Header().Set(cacheHeader, "HIT")
+		w.Header().Set("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+		r.writeResponse(w, req, e.resp)
+		return
+	}
+
+	var resp *phpengine.Response
+	if leader {
+		resp, e = r.fillCache(w, req, cfg, policy, key, fill)
+	} else {
+		// The response the others waited for could not be shared.
+		resp = r.execPHP(w, req, cfg)
+	}
+	if resp == nil {
+		return
+	}
+	if e != nil {
+		cacheRequests.WithLabelValues(cacheMiss).Inc()
+		w.Header().Set(cacheHeader, "MISS")
+	} else {
+		cacheRequests.WithLabelValues(cacheBypass).Inc()
+		w.Header().Set(cacheHeader, "BYPASS")
+	}
+	r.writeResponse(w, req, resp)
+}
+
+// fillCache runs PHP for the request that missed the cache first, and
+// completes fill with the entry it stored, if any.
+func (r *Router) fillCache(w http.ResponseWriter, req *http.Request, cfg *config.Config, policy *cachePolicy, key string, fill *cacheFill) (resp *phpengine.Response, e *cacheEntry) {
+	defer func() { r.cache.finish(key, fill, e) }()
+	resp = r.execPHP(w, req, cfg)
+	if resp != nil && cacheable(resp) {
+		e = newCacheEntry(key, clientPath(req), resp, policy.ttl)
+	}
+	return resp, e
+}
+
+// execPHP runs the request through the worker pool. When PHP could not
+// answer, it writes the error response itself and returns nil.
+func (r *Router) execPHP(w http.ResponseWriter, req *http.Request, cfg *config.Config) *phpengine.Response {
+	// Determine document root and entry point
+	docRoot := cfg.App.Root
+	if docRoot == "" {
+		docRoot = "."
+	}
+
+	rc := GetRequestCtx(req.Context())
+	entry := r.entryPoint(cfg)
+	entryPoint := entry.script
+	if s := rewrittenScript(req, docRoot); s != "" {
+		entryPoint = s
+	} else if entry.err != nil {
+		if rc != nil {
+			rc.ErrorClass = errClassEntryMissing
+		}
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return nil
+	}
+	script := filepath.Join(docRoot, entryPoint)
+	if err := awaitBody(req); err != nil {
+		if bodyTooSlow(req) {
+			r.slowBody(w, req, rc)
+		}
+		return nil
+	}
+
+	// Create PHP context from HTTP request. After a rewrite, PHP sees the
+	// new query string but, as under mod_rewrite, the URI the client sent.
+	stats := wantStats(cfg, req)
+	ctx := phpengine.NewContext(req, docRoot, entryPoint)
+	ctx.CollectStats = stats
+	setHost(ctx, r.clients.Load(), req)
+	if o := rewrittenFrom(req); o != nil {
+		ctx.Server["REQUEST_URI"] = o.path
+		ctx.Server["REDIRECT_URL"] = o.path
+		ctx.Server["REDIRECT_STATUS"] = "200"
+		if o.query != "" {
+			ctx.Server["REDIRECT_QUERY_STRING"] = o.query
+		}
+	}
+
+	// Dispatch to worker pool, with PHP told to stop a little before
+	// the request times out
+	timeout, limit := r.timeouts.Load().lookup(clientPath(req))
+	ctx.SetTimeout(timeout)
+	maps.Copy(ctx.INI, r.sandbox.Load().lookup(clientPath(req)))
+	ctx.MaxResponseSize = cfg.Server.MaxResponseSize.Bytes()
+	if rc != nil {
+		rc.TimeoutLimit = limit
+	}
 
-		// Write response headers
+	// Early hints go out before PHP runs, and while it runs if it sends
+	// its own; none once the final response is being written.
+	eh := r.hints.Load()
+	hints := eh.sender(w, req)
+	if hints != nil {
+		hints.send(hintsFromConfig, eh.routeLinks(clientPath(req)))
+		ctx.EarlyHints = func(links []string) { hints.send(hintsFromPHP, links) }
+	}
+	resp, err := r.exec(req.Context(), ctx, script, timeout)
+	hints.close()
+	if err != nil && bodyTooSlow(req) {
+		r.slowBody(w, req, rc)
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		r.logger.ErrorContext(req.Context(), "request timeout", "path", req.URL.Path, "timeout", timeout, "limit", limit)
+		if rc != nil {
+			rc.ErrorClass = errClassTimeout
+		}
+		http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+		return nil
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil // the client went away
+	}
+	if rc != nil {
+		rc.WorkerID = ctx.WorkerID
+		rc.PoolWait = ctx.PoolWait
+		rc.PHPTime = ctx.ExecTime
+	}
+	var quota *scratch.QuotaError
+	if errors.As(err, &quota) {
+		r.logger.WarnContext(req.Context(), "request body refused", "path", req.URL.Path, "error", err)
+		if rc != nil {
+			rc.ErrorClass = errClassTempQuota
+		}
+		if !quota.Transient() {
+			http.Error(w, "Insufficient Storage", http.StatusInsufficientStorage)
+			return nil
+		}
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return nil
+	}
+	if err != nil {
+		class := errClassPHP
+		if errors.Is(err, phpengine.ErrResponseTooLarge) {
+			class = errClassResponseTooLarge
+		}
+		if rc != nil {
+			rc.ErrorClass = class
+		}
+		r.logger.ErrorContext(req.Context(), "worker exec", "error", err, "error_class", class)
+		r.reports.report(req, errreport.Event{
+			Kind:    errreport.KindPHPError,
+			Level:   errreport.LevelError,
+			Message: err.Error(),
+			Tags:    map[string]string{"worker_id": strconv.Itoa(ctx.WorkerID), "error_class": class},
+			Extra:   map[string]string{"script": script},
+		})
+		if !cfg.App.Debug {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return nil
+		}
+		http.Error(w, fmt.Sprintf("Bad Gateway\n\nerror:      %v\nscript:     %s\nrequest id: %s",
+			err, script, req.Header.Get("X-Request-ID")), http.StatusBadGateway)
+		return nil
+	}
+	if resp.Stats != nil {
+		writeStats(w.Header(), resp.Stats)
+		if rc != nil {
+			rc.Stats = resp.Stats
+		}
+	} else if stats {
+		w.Header().Set(statsUnavailableHeader, "unavailable")
+	}
+	return resp
+}
+
+// writeResponse sends a PHP response to the client. Headers that could
+// end the header block early or inject another header are dropped, as
+// are hop-by-hop headers and those response_headers filters out. A 200
+// naming a file in X-Maboo-Sendfile is answered with that file, one the
+// client holds by its ETag with 304 when etag is on, and one offering
+// Accept-Ranges: bytes answers a Range request with a slice. With
+// response_headers.preserve_case, the headers are written in the order
+// the script sent them, and the names it cased differently are restored
+// as the response goes out; see preserveHeaderCase.
+func (r *Router) writeResponse(w http.ResponseWriter, req *http.Request, resp *phpengine.Response) {
+	filter := r.headers.Load()
+	connection := connectionTokens(resp.Headers)
+	var file string
+	// accept returns the canonical name to write a header under, or ""
+	// when it is dropped or, for X-Maboo-Sendfile, acted on.
+	accept := func(name, v string) string {
+		if !phpengine.ValidHeaderName(name) || !phpengine.ValidHeaderValue(v) {
+			r.logger.WarnContext(req.Context(), "dropping invalid response header from PHP", "header", strconv.Quote(name), "path", req.URL.Path)
+			responseHeadersDropped.WithLabelValues(dropInvalid).Inc()
+			return ""
+		}
+		k := http.CanonicalHeaderKey(name)
+		if k == sendfileHeader {
+			file = v
+			return ""
+		}
+		if reason := filter.drop(k, connection); reason != "" {
+			responseHeadersDropped.WithLabelValues(reason).Inc()
+			return ""
+		}
+		return k
+	}
+	if filter.preserveCase && resp.Fields != nil {
+		hc := headerCaseOf(req.Context())
+		set := make(map[string]bool, len(resp.Fields))
+		for _, f := range resp.Fields {
+			k := accept(f.Name, f.Value)
+			if k == "" {
+				continue
+			}
+			if set[k] {
+				w.Header().Add(k, f.Value)
+			} else {
+				w.Header().Set(k, f.Value)
+				set[k] = true
+				if hc != nil && f.Name != k {
+					hc.names[k] = f.Name
+				}
+			}
+		}
+	} else {
 		for k, v := range resp.Headers {
-			w.Header().Set(k, v)
+			if k = accept(k, v); k != "" {
+				w.Header().Set(k, v)
+			}
 		}
-		w.WriteHeader(resp.Status)
-		w.Write(resp.Body)
-	})
+	}
+	if file != "" && resp.Status == http.StatusOK {
+		r.sendFile(w, req, file)
+		return
+	}
+	if r.etags.Load().notModified(w, req, resp.Status, resp.Body) {
+		return
+	}
+	if resp.Status == http.StatusOK && acceptsRanges(req, w.Header()) {
+		writeRange(w, req, resp.Body)
+		return
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// exec runs script on the pool, giving up once timeout has passed (0 =
+// never) or the client has gone away; it then returns the context's error.
+// A request given up on keeps its worker until PHP finishes it, and ctx
+// must not be read again.
+func (r *Router) exec(reqCtx context.Context, ctx *phpengine.Context, script string, timeout time.Duration) (*phpengine.Response, error) {
+	if timeout <= 0 {
+		return r.pool.Exec(ctx, script)
+	}
+	reqCtx, cancel := context.WithTimeout(reqCtx, timeout)
+	defer cancel()
+
+	type result struct {
+		resp *phpengine.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := r.pool.Exec(ctx, script)
+		done <- result{resp, err}
+	}()
+	select {
+	case res := <-done:
+		return res.resp, res.err
+	case <-reqCtx.Done():
+		return nil, reqCtx.Err()
+	}
+}
+
+// Why maboo answered for PHP, as recorded in the access log's
+// error_class.
+const (
+	errClassTimeout          = "timeout"            // 504: pool.request_timeout or a route timeout passed
+	errClassPHP              = "php_error"          // 502: the worker failed the request
+	errClassResponseTooLarge = "response_too_large" // 502: output passed server.max_response_size
+	errClassEntryMissing     = "entry_missing"      // 503: the entry script was not found
+	errClassTempQuota        = "temp_quota"         // 503 or 507: the request body did not fit within temp.max_bytes
+	errClassSlowBody         = "slow_body"          // 408: the client sent its body slower than server.min_body_rate
+)
+
+// Timeout limits, as recorded in the access log.
+const (
+	limitRequestTimeout = "pool.request_timeout"
+	limitRouteTimeout   = "pool.route_timeouts"
+)
+
+// routeTimeouts picks the timeout of a request: the one given for the
+// route template its path matches, or else pool.request_timeout.
+type routeTimeouts struct {
+	fallback  time.Duration
+	matcher   *route.Matcher
+	templates []string
+	timeouts  []time.Duration
+	limits    []string // limitRouteTimeout[template], precomputed for the access log
+}
+
+// newRouteTimeouts compiles pool.route_timeouts. Validate has checked the
+// templates, so one that fails to compile leaves every request on
+// pool.request_timeout.
+func newRouteTimeouts(pool config.PoolConfig) *routeTimeouts {
+	t := &routeTimeouts{fallback: pool.RequestTimeout.Duration()}
+	t.templates = slices.Sorted(maps.Keys(pool.RouteTimeouts))
+	m, err := route.New(t.templates)
+	if err != nil {
+		return t
+	}
+	t.matcher = m
+	for _, tpl := range t.templates {
+		t.timeouts = append(t.timeouts, pool.RouteTimeouts[tpl].Duration())
+		t.limits = append(t.limits, limitRouteTimeout+"["+tpl+"]")
+	}
+	return t
+}
+
+// lookup returns the timeout for path and the limit it comes from.
+func (t *routeTimeouts) lookup(path string) (time.Duration, string) {
+	if i := t.matcher.Match(path); i >= 0 {
+		return t.timeouts[i], t.limits[i]
+	}
+	return t.fallback, limitRequestTimeout
+}
+
+// routeSandbox picks the sandbox settings of a request: those of the
+// first of php.sandbox.routes matching its path, or else the top-level
+// ones.
+type routeSandbox struct {
+	matcher  *route.Matcher
+	ini      []map[string]string
+	fallback map[string]string
+}
+
+// newRouteSandbox compiles php.sandbox. Validate has checked the
+// templates, as for newRouteTimeouts.
+func newRouteSandbox(cfg config.SandboxConfig) *routeSandbox {
+	base := cfg.Rule()
+	s := &routeSandbox{fallback: base.INI()}
+	templates := slices.Sorted(maps.Keys(cfg.Routes))
+	m, err := route.New(templates)
+	if err != nil {
+		return s
+	}
+	s.matcher = m
+	for _, tpl := range templates {
+		s.ini = append(s.ini, cfg.Routes[tpl].Over(base).INI())
+	}
+	return s
+}
+
+// lookup returns the php.ini settings that sandbox requests to path.
+func (s *routeSandbox) lookup(path string) map[string]string {
+	if i := s.matcher.Match(path); i >= 0 {
+		return s.ini[i]
+	}
+	return s.fallback
 }
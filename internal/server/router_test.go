@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// slowPool takes delay to answer and records the max_execution_time each
+// request was given.
+type slowPool struct {
+	stubPool
+	delay time.Duration
+
+	mu  sync.Mutex
+	ini map[string]string // path -> max_execution_time
+}
+
+func (p *slowPool) Exec(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+	p.mu.Lock()
+	p.ini[ctx.Server["REQUEST_URI"]] = ctx.INI["max_execution_time"]
+	p.mu.Unlock()
+	time.Sleep(p.delay)
+	return &phpengine.Response{Status: http.StatusOK}, nil
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Pool.RequestTimeout = config.Duration(20 * time.Millisecond)
+	cfg.Pool.RouteTimeouts = map[string]config.Duration{
+		"/reports/*":     config.Duration(90 * time.Second),
+		"/reports/quick": config.Duration(10 * time.Millisecond),
+	}
+	pool := &slowPool{delay: 200 * time.Millisecond, ini: make(map[string]string)}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	accessLog := slog.New(slog.NewJSONHandler(&logs, nil))
+	h := CoreMiddleware(logger, accessLog, nil, config.TracingConfig{}, nil)(NewRouter(cfg, pool, logger))
+
+	tests := []struct {
+		path   string
+		status int
+		limit  string
+		maxExe string
+	}{
+		{"/reports/yearly", http.StatusOK, "pool.route_timeouts[/reports/*]", "89"},
+		{"/reports/quick", http.StatusGatewayTimeout, "pool.route_timeouts[/reports/quick]", "1"},
+		{"/index", http.StatusGatewayTimeout, "pool.request_timeout", "1"},
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, rec.Code, tt.status)
+		}
+		var entry struct {
+			TimeoutLimit string `json:"timeout_limit"`
+			ErrorClass   string `json:"error_class"`
+		}
+		if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: access log %q: %v", tt.path, logs.String(), err)
+		}
+		if entry.TimeoutLimit != tt.limit {
+			t.Errorf("%s: logged timeout_limit %q, want %q", tt.path, entry.TimeoutLimit, tt.limit)
+		}
+		if timedOut := tt.status == http.StatusGatewayTimeout; timedOut != (entry.ErrorClass == errClassTimeout) {
+			t.Errorf("%s: logged error_class %q", tt.path, entry.ErrorClass)
+		}
+	}
+	time.Sleep(pool.delay) // let the requests given up on finish
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, tt := range tests {
+		if got := pool.ini[tt.path]; got != tt.maxExe {
+			t.Errorf("%s: max_execution_time %q, want %q", tt.path, got, tt.maxExe)
+		}
+	}
+}
+
+// iniPool records the php.ini settings each request was given.
+type iniPool struct {
+	stubPool
+	mu  sync.Mutex
+	ini map[string]map[string]string // path -> INI
+}
+
+func (p *iniPool) Exec(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+	p.mu.Lock()
+	p.ini[ctx.Server["REQUEST_URI"]] = ctx.INI
+	p.mu.Unlock()
+	return &phpengine.Response{Status: http.StatusOK}, nil
+}
+
+func TestRouteSandbox(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.PHP.Sandbox = config.SandboxConfig{
+		OpenBasedir:      []string{cfg.App.Root, "/tmp"},
+		DisableFunctions: []string{"exec", "proc_open"},
+		Routes: map[string]config.SandboxRule{
+			"/admin/*":    {AllowURLFopen: "off"},
+			"/uploads/*":  {OpenBasedir: []string{cfg.App.Root}, DisableFunctions: []string{"exec", "proc_open", "system"}},
+			"/admin/feed": {AllowURLFopen: "on"},
+		},
+	}
+	pool := &iniPool{stubPool: stubPool{workers: 1}, ini: make(map[string]map[string]string)}
+	r := NewRouter(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	basedir := cfg.App.Root + ":/tmp"
+	tests := []struct {
+		path string
+		want [3]string // open_basedir, disable_functions, allow_url_fopen
+	}{
+		{"/", [3]string{basedir, "exec,proc_open", ""}},
+		{"/admin/users", [3]string{basedir, "exec,proc_open", "0"}},
+		{"/admin/feed", [3]string{basedir, "exec,proc_open", "1"}},
+		{"/uploads/view", [3]string{cfg.App.Root, "exec,proc_open,system", ""}},
+	}
+	for _, tt := range tests {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+		pool.mu.Lock()
+		ini := pool.ini[tt.path]
+		pool.mu.Unlock()
+		got := [3]string{ini["open_basedir"], ini["disable_functions"], ini["allow_url_fopen"]}
+		if got != tt.want {
+			t.Errorf("%s: sandbox %q, want %q", tt.path, got, tt.want)
+		}
+		if ini["max_execution_time"] == "" {
+			t.Errorf("%s: max_execution_time lost", tt.path)
+		}
+	}
+}
+
+// headerPool answers with the headers it is given.
+type headerPool struct {
+	stubPool
+	headers map[string]string
+}
+
+func (p *headerPool) Exec(*phpengine.Context, string) (*phpengine.Response, error) {
+	return &phpengine.Response{Status: http.StatusOK, Headers: p.headers, Body: []byte("ok")}, nil
+}
+
+func TestRouterDropsInjectedHeaders(t *testing.T) {
+	pool := &headerPool{headers: map[string]string{
+		"Content-Type":        "text/html; charset=UTF-8",
+		"Content-Disposition": "attachment; filename=\"r\xe9sum\xe9.pdf\"",
+		"X-Redirect":          "/next\r\nSet-Cookie: session=stolen",
+		"X-Nul":               "a\x00b",
+		"X-Bad Name":          "1",
+	}}
+	srv := httptest.NewServer(NewRouter(appConfig(t), pool, slog.New(slog.NewTextHandler(io.Discard, nil))))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/html; charset=UTF-8" || resp.Header.Get("Content-Disposition") == "" {
+		t.Errorf("valid headers lost: %v", resp.Header)
+	}
+	for _, name := range []string{"X-Redirect", "Set-Cookie", "X-Nul", "X-Bad Name"} {
+		if v, ok := resp.Header[name]; ok {
+			t.Errorf("%s: %q written", name, v)
+		}
+	}
+}
+
+// echoPool answers with the posted field d, written through the same
+// bounded buffer the engine uses.
+type echoPool struct{ stubPool }
+
+func (p *echoPool) Exec(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+	out := phpengine.NewOutput(ctx.MaxResponseSize, nil)
+	if _, err := out.Write([]byte(ctx.Post["d"])); err != nil {
+		return nil, err
+	}
+	return &phpengine.Response{Status: http.StatusOK, Body: out.Bytes()}, nil
+}
+
+func TestRouterResponseLimit(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Server.MaxResponseSize = 1024
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	accessLog := slog.New(slog.NewJSONHandler(&logs, nil))
+	h := CoreMiddleware(logger, accessLog, nil, config.TracingConfig{}, nil)(NewRouter(cfg, &echoPool{}, logger))
+
+	type entry struct {
+		Status       int    `json:"status"`
+		Bytes        int    `json:"bytes"`
+		RequestBytes int64  `json:"request_bytes"`
+		ErrorClass   string `json:"error_class"`
+	}
+	post := func(size int) entry {
+		logs.Reset()
+		req := httptest.NewRequest("POST", "/upload", strings.NewReader("d="+strings.Repeat("x", size)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		var e entry
+		if err := json.Unmarshal(logs.Bytes(), &e); err != nil {
+			t.Fatalf("access log %q: %v", logs.String(), err)
+		}
+		return e
+	}
+
+	if e := post(1000); e.Status != http.StatusOK || e.Bytes != 1000 || e.RequestBytes != 1002 || e.ErrorClass != "" {
+		t.Errorf("under the limit: logged %+v", e)
+	}
+	if e := post(2000); e.Status != http.StatusBadGateway || e.RequestBytes != 2002 || e.ErrorClass != errClassResponseTooLarge {
+		t.Errorf("over the limit: logged %+v", e)
+	}
+}
+
+func TestCoreMiddlewareAbort(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := CoreMiddleware(logger, logger, nil, config.TracingConfig{}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	t.Error("abort was swallowed")
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/clientip"
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+// scrapeGuard decides who may read the metrics, on the main listener's
+// metrics.path and on metrics.address alike.
+type scrapeGuard struct {
+	token   string
+	allow   *clientip.Set // nil = any address
+	clients *clientip.Resolver
+}
+
+// newScrapeGuard compiles metrics.token and metrics.allow. Client
+// addresses are taken from the forwarding headers of trusted proxies, as
+// for access_control. Validate has checked the entries.
+func newScrapeGuard(cfg *config.Config) *scrapeGuard {
+	g := &scrapeGuard{token: cfg.Metrics.Token.Value()}
+	if len(cfg.Metrics.Allow) > 0 {
+		g.allow, _ = clientip.NewSet(cfg.Metrics.Allow)
+		if g.allow == nil {
+			g.allow = &clientip.Set{}
+		}
+	}
+	g.clients, _ = clientip.New(cfg.Server.TrustedProxies)
+	return g
+}
+
+// forUnix returns the guard for the unix socket listener: peers there
+// have no address, and the socket's file permissions stand in for allow.
+func (g *scrapeGuard) forUnix() *scrapeGuard {
+	unix := *g
+	unix.allow = nil
+	return &unix
+}
+
+// authorize reports whether r may scrape, answering it if not: 403 for a
+// client outside metrics.allow, 401 without metrics.token.
+func (g *scrapeGuard) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if g == nil {
+		return true
+	}
+	if g.allow != nil && !g.allow.Contains(g.clients.Addr(r)) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	if g.token != "" && !validBearer(r, g.token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// MetricsListenerPath is where the metrics.address listener serves the
+// metrics, whatever metrics.path says.
+const MetricsListenerPath = "/metrics"
+
+// newMetricsServer builds the metrics.address listener. It serves the
+// same registry as the main listener's metrics.path, behind the same
+// guard.
+func (s *Server) newMetricsServer() *http.Server {
+	guard := newScrapeGuard(s.cfg)
+	if isUnixAddr(s.cfg.Metrics.Address) {
+		guard = guard.forUnix()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+MetricsListenerPath, func(w http.ResponseWriter, r *http.Request) {
+		if guard.authorize(w, r) {
+			s.metrics.handler.ServeHTTP(w, r)
+		}
+	})
+	return &http.Server{
+		Addr:              s.cfg.Metrics.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+}
+
+// listenMetrics binds metrics.address. A unix socket left over by an
+// earlier run is replaced. Closing the listener leaves the socket file,
+// so a process taking over during an upgrade keeps the one it bound.
+func (s *Server) listenMetrics() (ln net.Listener, network string, err error) {
+	addr := s.cfg.Metrics.Address
+	path, ok := strings.CutPrefix(addr, config.MetricsUnixPrefix)
+	if !ok {
+		ln, err = s.listen("metrics", addr)
+		return ln, "tcp", err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, "unix", err
+	}
+	ln, err = net.Listen("unix", path)
+	if err != nil {
+		return nil, "unix", err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	return ln, "unix", nil
+}
+
+// isUnixAddr reports whether a metrics.address is a unix socket.
+func isUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, config.MetricsUnixPrefix)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScrapeGuard(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Metrics.Token = "s3cret"
+	cfg.Metrics.Allow = []string{"10.0.0.0/8"}
+	m, err := NewMetrics(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Middleware("/metrics", newScrapeGuard(cfg))(http.NotFoundHandler())
+
+	tests := []struct {
+		remote, auth string
+		want         int
+	}{
+		{"192.0.2.1:1234", "Bearer s3cret", http.StatusForbidden},
+		{"10.1.2.3:1234", "", http.StatusUnauthorized},
+		{"10.1.2.3:1234", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.RemoteAddr = tt.remote
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s with %q: status = %d, want %d", tt.remote, tt.auth, rec.Code, tt.want)
+		}
+	}
+
+	// Without metrics.path the main listener leaves /metrics to the app.
+	h = m.Middleware("", newScrapeGuard(cfg))(http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("empty metrics.path: status = %d, want the app's 404", rec.Code)
+	}
+}
+
+func TestMetricsListener(t *testing.T) {
+	tests := []struct {
+		name    string
+		address func(t *testing.T) string
+	}{
+		{"tcp", freeAddr},
+		{"unix", func(t *testing.T) string { return "unix:" + filepath.Join(t.TempDir(), "metrics.sock") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := appConfig(t)
+			cfg.Server.Address = freeAddr(t)
+			cfg.Metrics.Path = ""
+			cfg.Metrics.Address = tt.address(t)
+			cfg.Metrics.Token = "s3cret"
+			// Matches no TCP test client; a unix socket ignores it.
+			if tt.name == "unix" {
+				cfg.Metrics.Allow = []string{"10.0.0.0/8"}
+			}
+			s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if err := s.Bind(); err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() { done <- s.Serve() }()
+
+			client := &http.Client{}
+			base := "http://" + s.metricsLn.Addr().String()
+			if path, ok := strings.CutPrefix(cfg.Metrics.Address, "unix:"); ok {
+				base = "http://metrics"
+				client.Transport = &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				}}
+			}
+			scrape := func(auth string) (int, string) {
+				t.Helper()
+				req, _ := http.NewRequest("GET", base+MetricsListenerPath, nil)
+				if auth != "" {
+					req.Header.Set("Authorization", auth)
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+				return resp.StatusCode, string(body)
+			}
+
+			if code, _ := scrape(""); code != http.StatusUnauthorized {
+				t.Errorf("without token: status %d, want 401", code)
+			}
+			if code, body := scrape("Bearer s3cret"); code != http.StatusOK || !strings.Contains(body, "maboo_http_requests_active") {
+				t.Errorf("with token: status %d, body:\n%.200s", code, body)
+			}
+
+			// The main listener no longer serves them.
+			resp, err := http.Get("http://" + cfg.Server.Address + "/metrics")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if strings.Contains(string(body), "maboo_http_requests_active") {
+				t.Error("metrics served on the main listener with metrics.path empty")
+			}
+
+			if err := s.Stop(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-done; err != http.ErrServerClosed {
+				t.Errorf("Serve = %v", err)
+			}
+			client.CloseIdleConnections()
+			if _, err := client.Get(base + MetricsListenerPath); err == nil {
+				t.Error("metrics listener still accepting after Stop")
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// selfTestOutput is how much of a failed self-test's output is logged and
+// reported; PHP prints its fatal errors at the top.
+const selfTestOutput = 1024
+
+// SelfTestResult is the outcome of the startup self-test, reported under
+// self_test by /health and /ready.
+type SelfTestResult struct {
+	Passed    bool      `json:"passed"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status,omitempty"` // 0 when PHP did not answer
+	Error     string    `json:"error,omitempty"`
+	Output    string    `json:"output,omitempty"` // the start of a failed response's body
+	LatencyMS float64   `json:"latency_ms"`
+	At        time.Time `json:"at"`
+}
+
+// SelfTest runs one request through the pool to the entry script, for
+// health.self_test.path, and records the result. It fails when the entry
+// script is missing, PHP does not answer within health.self_test.timeout,
+// or it answers with a 5xx. Until a self-test passes, readiness fails.
+func (s *Server) SelfTest(ctx context.Context) error {
+	cfg := s.router.cfg.Load()
+	st := cfg.Health.SelfTest
+	res := SelfTestResult{Path: st.Path, At: time.Now()}
+	if res.Path == "" {
+		res.Path = "/"
+	}
+	if st.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.Timeout.Duration())
+		defer cancel()
+	}
+
+	err := s.selfTest(ctx, cfg, &res)
+	res.LatencyMS = float64(time.Since(res.At).Microseconds()) / 1000
+	res.Passed = err == nil
+	if err != nil {
+		res.Error = err.Error()
+		s.logger.Error("self-test failed", "path", res.Path, "status", res.Status, "error", err, "output", res.Output)
+	} else {
+		s.logger.Info("self-test passed", "path", res.Path, "status", res.Status, "latency_ms", res.LatencyMS)
+	}
+	s.router.healthHandler.selfTest.Store(&res)
+	return err
+}
+
+func (s *Server) selfTest(ctx context.Context, cfg *config.Config, res *SelfTestResult) error {
+	entry := s.router.entryPoint(cfg)
+	if entry.err != nil {
+		return entry.err
+	}
+	resp, err := s.pool.Invoke(ctx, phpengine.InternalRequest{
+		Entry:   entry.script,
+		DocRoot: cfg.App.Root,
+		Path:    res.Path,
+		Caller:  metrics.CallerSelfTest,
+	})
+	if err != nil {
+		return err
+	}
+	res.Status = resp.Status
+	if resp.Status >= http.StatusInternalServerError {
+		out := resp.Body
+		if len(out) > selfTestOutput {
+			out = out[:selfTestOutput]
+		}
+		res.Output = string(bytes.TrimSpace(out))
+		return fmt.Errorf("%s answered with status %d", res.Path, resp.Status)
+	}
+	return nil
+}
+
+// RetrySelfTest runs the self-test again if the last one failed, as after
+// a worker reload that may have brought the fix.
+func (s *Server) RetrySelfTest(ctx context.Context) {
+	if last := s.router.healthHandler.selfTest.Load(); last != nil && !last.Passed {
+		s.SelfTest(ctx)
+	}
+}
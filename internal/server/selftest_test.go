@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// brokenPool answers like an app missing a PHP extension until fixed.
+type brokenPool struct {
+	stubPool
+	fixed atomic.Bool
+}
+
+func (p *brokenPool) Exec(*phpengine.Context, string) (*phpengine.Response, error) {
+	if p.fixed.Load() {
+		return &phpengine.Response{Status: http.StatusOK}, nil
+	}
+	return &phpengine.Response{
+		Status: http.StatusInternalServerError,
+		Body:   []byte("\nPHP Fatal error:  Uncaught Error: Call to undefined function mb_strlen() in /app/index.php:3\n"),
+	}, nil
+}
+
+func (p *brokenPool) Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	return phpengine.Invoke(ctx, p, "", r)
+}
+
+// errPool fails every request before PHP answers.
+type errPool struct{ stubPool }
+
+func (p *errPool) Exec(*phpengine.Context, string) (*phpengine.Response, error) {
+	return nil, errors.New("worker crashed")
+}
+
+func (p *errPool) Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	return phpengine.Invoke(ctx, p, "", r)
+}
+
+// healthBody requests path from s and decodes the answer.
+func healthBody(t *testing.T, s *Server, path string) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	var body map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return rec.Code, body
+}
+
+func TestSelfTestPasses(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Health.SelfTest.Path = "/up?check=1"
+	pool := &serverPool{stubPool: stubPool{workers: 1}}
+	s := New(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if err := s.SelfTest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if uri, script := pool.server["REQUEST_URI"], pool.server["SCRIPT_NAME"]; uri != "/up" || script != "/index.php" {
+		t.Errorf("ran %s for %s, want /index.php for /up", script, uri)
+	}
+	code, body := healthBody(t, s, "/ready")
+	st, _ := body["self_test"].(map[string]any)
+	if code != http.StatusOK || st["passed"] != true || st["path"] != "/up?check=1" || st["status"] != float64(200) {
+		t.Errorf("/ready: %d, self_test %v", code, body["self_test"])
+	}
+	if at, _ := time.Parse(time.RFC3339Nano, st["at"].(string)); time.Since(at) > time.Minute {
+		t.Errorf("self_test.at = %v", st["at"])
+	}
+}
+
+func TestSelfTestFails(t *testing.T) {
+	pool := &brokenPool{stubPool: stubPool{workers: 1}}
+	logs := &strings.Builder{}
+	s := New(appConfig(t), pool, slog.New(slog.NewTextHandler(logs, nil)))
+
+	err := s.SelfTest(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "status 500") {
+		t.Fatalf("SelfTest = %v, want a 500 failure", err)
+	}
+	if !strings.Contains(logs.String(), "mb_strlen") {
+		t.Errorf("PHP error not logged: %s", logs)
+	}
+
+	code, body := healthBody(t, s, "/ready")
+	if code != http.StatusServiceUnavailable || body["status"] != statusSelfTestFailed {
+		t.Errorf("/ready: %d %v, want 503 %s", code, body["status"], statusSelfTestFailed)
+	}
+	code, body = healthBody(t, s, "/health")
+	st, _ := body["self_test"].(map[string]any)
+	if code != http.StatusOK || st["passed"] != false || !strings.HasPrefix(st["output"].(string), "PHP Fatal error") {
+		t.Errorf("/health: %d, self_test %v", code, body["self_test"])
+	}
+	if s.Status().Status != "not_ready" {
+		t.Errorf("admin status %q, want not_ready", s.Status().Status)
+	}
+
+	// A reload brings the fix; the retried self-test makes the server ready.
+	pool.fixed.Store(true)
+	s.RetrySelfTest(context.Background())
+	if code, _ := healthBody(t, s, "/ready"); code != http.StatusOK {
+		t.Errorf("/ready after the retry: %d", code)
+	}
+}
+
+func TestSelfTestErrors(t *testing.T) {
+	cfg := appConfig(t)
+	s := New(cfg, &errPool{stubPool: stubPool{workers: 1}}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := s.SelfTest(context.Background()); err == nil || !strings.Contains(err.Error(), "worker crashed") {
+		t.Errorf("SelfTest with a failing worker = %v", err)
+	}
+
+	cfg = appConfig(t)
+	cfg.App.Entry = "missing.php"
+	s = New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := s.SelfTest(context.Background()); !errors.Is(err, phpengine.ErrEntryMissing) {
+		t.Errorf("SelfTest without the entry script = %v", err)
+	}
+}
@@ -4,11 +4,26 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
+	"log"
 	"log/slog"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/sadewadee/maboo/internal/audit"
+	"github.com/sadewadee/maboo/internal/clientip"
 	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/errreport"
+	"github.com/sadewadee/maboo/internal/handoff"
+	"github.com/sadewadee/maboo/internal/jobs"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/notify"
+	"github.com/sadewadee/maboo/internal/pool"
+	"github.com/sadewadee/maboo/internal/scratch"
+	"github.com/sadewadee/maboo/internal/websocket"
 )
 
 // Server is the main maboo HTTP server.
@@ -16,47 +31,194 @@ type Server struct {
 	cfg         *config.Config
 	pool        Pool
 	logger      *slog.Logger
+	accessLog   *slog.Logger // request records; logger unless SetAccessLogger is called
 	http        *http.Server
 	http3       *HTTP3Server
 	router      *Router
 	metrics     *Metrics
+	sampler     *RequestSampler
+	probes      *ProbeChecker
+	reports     *requestReporter
 	redirectSrv *http.Server // HTTP redirect server for ACME
+	admin       *http.Server // admin API, nil unless admin.enabled
+	metricsSrv  *http.Server // metrics.address listener, nil without one
+
+	// Bound by Bind for Serve; nil when not used.
+	adminLn    net.Listener
+	metricsLn  net.Listener
+	redirectLn net.Listener
+	http3Conn  net.PacketConn
+
+	version    string
+	websocket  *websocket.Manager
+	wsWorkers  *pool.Pool                   // websocket worker group, nil unless attached
+	watcher    atomic.Pointer[pool.Watcher] // nil while files are not watched
+	jobs       *jobs.Manager
+	lastReload atomic.Int64 // unix nanoseconds, 0 = never
+
+	sockets   *handoff.Listeners // nil: bind every address directly
+	listening chan struct{}      // closed once every listener is bound
+	upgrade   func() (int, error)
+	audit     *audit.Log       // nil: admin actions are not recorded
+	notifier  *notify.Notifier // nil: no notifications
+
+	mainLns  []net.Listener // set before listening is closed
+	conns    *connLimiter   // limits the connections on mainLns
+	watch    *connWatch     // logs and counts what happens to them outside requests
+	stopping atomic.Bool
+	pending  pendingConns
+	inFlight inFlight // requests on every listener but admin
 }
 
+// firstRequestGrace bounds how long Stop waits for accepted connections to
+// send their first request before shutting down.
+const firstRequestGrace = time.Second
+
 // New creates a new maboo server.
 func New(cfg *config.Config, workerPool Pool, logger *slog.Logger) *Server {
+	if cfg.Tracing.Enabled {
+		logger = slog.New(NewTraceHandler(logger.Handler()))
+	}
 	s := &Server{
-		cfg:    cfg,
-		pool:   workerPool,
-		logger: logger,
+		cfg:       cfg,
+		pool:      workerPool,
+		logger:    logger,
+		accessLog: logger,
+		listening: make(chan struct{}),
+		conns: newConnLimiter(ConnectionLimits{
+			MaxConnections:      cfg.Server.MaxConnections,
+			MaxConnectionsPerIP: cfg.Server.MaxConnectionsPerIP,
+		}),
 	}
 
-	s.metrics = NewMetrics(workerPool)
+	s.watch = newConnWatch(logger)
+	s.conns.watch = s.watch
+	s.sampler = NewRequestSampler(cfg.Logging.Request)
+	collector, err := NewMetrics(workerPool, cfg.Metrics.RouteLabels)
+	if err != nil {
+		logger.Warn("invalid metrics.route_labels, route label disabled", "error", err)
+		collector, _ = NewMetrics(workerPool, nil)
+	}
+	s.metrics = collector
+	s.metrics.sampler = s.sampler
+	if cfg.Metrics.Enabled && cfg.Metrics.RuntimeCollectors {
+		metrics.EnableRuntimeCollectors()
+	}
+	s.reports = &requestReporter{reporter: errreport.Nop{}, metrics: s.metrics}
 	s.router = NewRouter(cfg, workerPool, logger)
+	s.router.reports = s.reports
+	s.probes = NewProbeChecker(cfg.Health, workerPool, logger)
+	s.router.healthHandler.SetProbes(s.probes)
+	s.router.healthHandler.groups = s.metrics.groups
+	if cfg.WebSocket.Enabled {
+		s.mountWebSocket()
+	}
 
-	s.http = &http.Server{
-		Addr:         cfg.Server.Address,
-		Handler:      s.buildMiddleware(s.router),
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	s.http = s.newHTTPServer()
+
+	if cfg.Admin.Enabled {
+		s.admin = s.newAdminServer()
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.Address != "" {
+		s.metricsSrv = s.newMetricsServer()
+	}
+
+	return s
+}
+
+// mountWebSocket creates the WebSocket manager and mounts its handler at
+// websocket.path. Events run websocket.worker, or the worker of the
+// connection's subprotocol, on the HTTP pool until SetWebSocketWorkers and
+// SetWebSocketProtocolWorkers attach worker groups of their own.
+func (s *Server) mountWebSocket() {
+	ws := s.cfg.WebSocket
+	m := websocket.NewManager(s.logger)
+	h := websocket.NewHandler(m, ws, s.logger)
+	if resolver, err := clientip.New(s.cfg.Server.TrustedProxies); err == nil {
+		h.SetClientIP(resolver)
+	} else {
+		s.logger.Warn("invalid server.trusted_proxies, websocket uses peer addresses", "error", err)
+	}
+	h.SetAuthPool(s.pool, s.cfg.App.Root)
+	m.SetPHPForwarder(websocket.EmbeddedForwarder(s.pool, s.cfg.App.Root, ws.Worker))
+	for _, sp := range ws.Subprotocols {
+		if sp.Worker != "" {
+			m.SetProtocolForwarder(sp.Name, websocket.EmbeddedForwarder(s.pool, s.cfg.App.Root, sp.Worker))
+		}
+	}
+
+	s.websocket = m
+	s.router.SetWebSocket(ws.Path, h)
+}
+
+// SetAccessLogger sends request records to their own logger, so the access
+// log can have its own output. Call before Start.
+func (s *Server) SetAccessLogger(logger *slog.Logger) {
+	if s.cfg.Tracing.Enabled {
+		logger = slog.New(NewTraceHandler(logger.Handler()))
+	}
+	s.accessLog = logger
+	s.http = s.newHTTPServer()
+}
+
+// SetScratch has the worker processes' pool spill large request bodies to
+// a, within its quota. Call before Bind.
+func (s *Server) SetScratch(a *scratch.Area) {
+	if p, ok := s.pool.(interface{ SetScratch(*scratch.Area) }); ok {
+		p.SetScratch(a)
+	}
+}
+
+// newHTTPServer builds the main listener around the middleware chain.
+func (s *Server) newHTTPServer() *http.Server {
+	// No ReadTimeout: it would cancel requests PHP takes longer over.
+	// The body is held to server.min_body_rate instead, by the router.
+	srv := &http.Server{
+		Addr:              s.cfg.Server.Address,
+		Handler:           s.buildMiddleware(s.router),
+		ReadHeaderTimeout: s.cfg.Server.ReadHeaderTimeout.Duration(),
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ConnState:         s.connState,
+		ErrorLog:          log.New(s.watch, "", 0),
 	}
 
 	// Enable HTTP/2 if configured
-	if cfg.Server.HTTP2 {
-		useTLS := cfg.Server.TLS.Auto || (cfg.Server.TLS.Cert != "" && cfg.Server.TLS.Key != "") || cfg.Server.TLS.ACME.Email != ""
-		if err := EnableHTTP2(s.http, useTLS); err != nil {
-			logger.Warn("failed to enable HTTP/2", "error", err)
+	if s.cfg.Server.HTTP2 {
+		if err := EnableHTTP2(srv, s.useTLS()); err != nil {
+			s.logger.Warn("failed to enable HTTP/2", "error", err)
 		} else {
-			logger.Debug("HTTP/2 enabled")
+			s.logger.Debug("HTTP/2 enabled")
 		}
 	}
+	return srv
+}
 
-	return s
+// useTLS reports whether the main listener serves HTTPS.
+func (s *Server) useTLS() bool {
+	tls := s.cfg.Server.TLS
+	return tls.Auto || (tls.Cert != "" && tls.Key != "") || tls.ACME.Email != ""
 }
 
-// Start begins listening for HTTP connections.
+// UpdateConfig applies the hot-reloadable parts of cfg to the running
+// server. Listener, TLS and middleware settings keep their startup values.
+func (s *Server) UpdateConfig(cfg *config.Config) {
+	s.router.SetConfig(cfg)
+}
+
+// Start binds the listeners, then serves them until Stop. Use Bind and
+// Serve to report a bind failure before anything else runs.
 func (s *Server) Start() error {
+	if err := s.Bind(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Bind loads the TLS certificate and binds every listener: admin,
+// metrics, main, and with TLS the ACME redirect and HTTP/3 ones. A listener that cannot
+// be bound fails it with a *BindError, after closing those bound before.
+func (s *Server) Bind() (err error) {
 	s.logger.Info("maboo server starting",
 		"address", s.cfg.Server.Address,
 		"http2", s.cfg.Server.HTTP2,
@@ -64,15 +226,180 @@ func (s *Server) Start() error {
 		"tls", s.cfg.Server.TLS.Auto,
 	)
 
-	if s.cfg.Server.TLS.Auto || (s.cfg.Server.TLS.Cert != "" && s.cfg.Server.TLS.Key != "") || s.cfg.Server.TLS.ACME.Email != "" {
-		return s.startTLS()
+	var bound []io.Closer
+	defer func() {
+		if err != nil {
+			for _, c := range bound {
+				c.Close()
+			}
+		}
+	}()
+
+	if s.admin != nil {
+		ln, err := s.listen("admin", s.admin.Addr)
+		if err != nil {
+			return &BindError{Listener: "admin", Network: "tcp", Addr: s.admin.Addr, Err: err}
+		}
+		s.adminLn = ln
+		bound = append(bound, ln)
+	}
+
+	if s.metricsSrv != nil {
+		ln, network, err := s.listenMetrics()
+		if err != nil {
+			return &BindError{Listener: "metrics", Network: network, Addr: s.metricsSrv.Addr, Err: err}
+		}
+		s.metricsLn = ln
+		bound = append(bound, ln)
+	}
+
+	lns, err := s.mainListeners()
+	if err != nil {
+		return &BindError{Listener: "http", Network: "tcp", Addr: s.cfg.Server.Address, Err: err}
+	}
+	for i, ln := range lns {
+		bound = append(bound, ln)
+		lns[i] = s.conns.Listener(ln)
+	}
+	s.mainLns = lns
+	if len(lns) > 1 {
+		s.logger.Info("accepting on SO_REUSEPORT listeners", "listeners", len(lns))
+	}
+
+	if s.useTLS() {
+		if err := s.setupTLS(); err != nil {
+			return err
+		}
+		if s.redirectSrv != nil {
+			ln, err := s.listen("redirect", s.redirectSrv.Addr)
+			if err != nil {
+				return &BindError{Listener: "redirect", Network: "tcp", Addr: s.redirectSrv.Addr, Err: err}
+			}
+			s.redirectLn = ln
+			bound = append(bound, ln)
+		}
+		if s.cfg.Server.HTTP3 {
+			conn, err := s.listenPacket("http3", s.cfg.Server.Address)
+			if err != nil {
+				return &BindError{Listener: "http3", Network: "udp", Addr: s.cfg.Server.Address, Err: err}
+			}
+			s.http3Conn = conn
+			bound = append(bound, conn)
+		}
 	}
-	return s.http.ListenAndServe()
+
+	close(s.listening)
+	return nil
 }
 
-// Stop gracefully shuts down the server.
+// Serve serves the listeners Bind bound until Stop, when it returns
+// http.ErrServerClosed.
+func (s *Server) Serve() error {
+	s.probes.Start()
+
+	if s.adminLn != nil {
+		s.logger.Info("admin API listening", "address", s.admin.Addr)
+		go func() {
+			if err := s.admin.Serve(s.adminLn); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("admin server error", "error", err)
+			}
+		}()
+	}
+
+	if s.metricsLn != nil {
+		s.logger.Info("metrics listening", "address", s.metricsSrv.Addr, "path", MetricsListenerPath)
+		go func() {
+			if err := s.metricsSrv.Serve(s.metricsLn); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("metrics server error", "error", err)
+			}
+		}()
+	}
+
+	if !s.useTLS() {
+		return s.served(serveAll(s.mainLns, s.http.Serve))
+	}
+
+	if s.redirectLn != nil {
+		s.logger.Info("starting HTTP redirect server for ACME challenges", "address", s.redirectSrv.Addr)
+		go func() {
+			if err := s.redirectSrv.Serve(s.redirectLn); err != http.ErrServerClosed {
+				s.logger.Error("HTTP redirect server error", "error", err)
+			}
+		}()
+	}
+	if s.http3Conn != nil {
+		s.http3 = NewHTTP3Server(s.cfg, s.buildMiddleware(s.router), s.http.TLSConfig, s.logger)
+		s.watch.watchHTTP3(s.http3)
+		go func() {
+			if err := s.http3.Serve(s.http3Conn); err != nil {
+				s.logger.Error("HTTP/3 server error", "error", err)
+			}
+		}()
+	}
+	return s.served(serveAll(s.mainLns, func(ln net.Listener) error {
+		return s.http.ServeTLS(ln, "", "")
+	}))
+}
+
+// served reports the listener being closed by Stop as a normal shutdown.
+func (s *Server) served(err error) error {
+	if s.stopping.Load() {
+		return http.ErrServerClosed
+	}
+	return err
+}
+
+// SetListeners makes Start take its sockets from l, so they can be
+// inherited from, and handed to, another process. Call before Start.
+func (s *Server) SetListeners(l *handoff.Listeners) {
+	s.sockets = l
+}
+
+// Listening is closed once Start has bound every listener.
+func (s *Server) Listening() <-chan struct{} {
+	return s.listening
+}
+
+func (s *Server) listen(name, addr string) (net.Listener, error) {
+	if s.sockets != nil {
+		return s.sockets.Listen(name, addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func (s *Server) listenConfig(lc *net.ListenConfig, name, addr string) (net.Listener, error) {
+	if s.sockets != nil {
+		return s.sockets.ListenConfig(lc, name, addr)
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+func (s *Server) listenPacket(name, addr string) (net.PacketConn, error) {
+	if s.sockets != nil {
+		return s.sockets.ListenPacket(name, addr)
+	}
+	return net.ListenPacket("udp", addr)
+}
+
+// Stop gracefully shuts down the server, in order: readiness turns to
+// shutting_down, the listeners stop accepting, open connections drain, and
+// Stop waits for every request still in flight, HTTP/3 and hijacked ones
+// included. Only after it returns may the pools behind the router be
+// stopped. When ctx ends first, Stop returns its error with requests still
+// running.
 func (s *Server) Stop(ctx context.Context) error {
 	s.logger.Info("maboo server shutting down")
+	s.router.healthHandler.shuttingDown.Store(true)
+	s.probes.Stop()
+	s.stopAccepting(ctx)
+
+	// Shutdown does not wait for hijacked connections, so close the
+	// WebSocket clients properly while the workers serving them still run.
+	if s.websocket != nil {
+		if err := s.websocket.Shutdown(ctx); err != nil {
+			s.logger.Warn("error closing websocket connections", "error", err)
+		}
+	}
 
 	// Stop HTTP/3 server if running
 	if s.http3 != nil {
@@ -88,22 +415,111 @@ func (s *Server) Stop(ctx context.Context) error {
 		}
 	}
 
-	return s.http.Shutdown(ctx)
+	if s.admin != nil {
+		if err := s.admin.Shutdown(ctx); err != nil {
+			s.logger.Warn("error shutting down admin server", "error", err)
+		}
+	}
+
+	if s.metricsSrv != nil {
+		if err := s.metricsSrv.Shutdown(ctx); err != nil {
+			s.logger.Warn("error shutting down metrics server", "error", err)
+		}
+	}
+
+	err := s.http.Shutdown(ctx)
+	if werr := s.inFlight.wait(ctx); werr != nil {
+		s.logger.Warn("shutdown deadline passed with requests in flight", "requests", s.inFlight.n.Load())
+		if err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// stopAccepting closes the main listeners, then waits briefly for the
+// connections already accepted to send their first request. Shutdown hangs
+// up without a response on a connection whose request arrives after it
+// starts, which would drop requests accepted just before the listener was
+// handed to a new process.
+func (s *Server) stopAccepting(ctx context.Context) {
+	select {
+	case <-s.listening:
+	default:
+		return // never started serving
+	}
+	s.stopping.Store(true)
+	for _, ln := range s.mainLns {
+		ln.Close()
+	}
+
+	deadline := time.NewTimer(firstRequestGrace)
+	defer deadline.Stop()
+	tick := time.NewTicker(5 * time.Millisecond)
+	defer tick.Stop()
+	for s.pending.len() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// connState is the main listener's http.Server ConnState hook.
+func (s *Server) connState(c net.Conn, state http.ConnState) {
+	s.pending.track(c, state)
+	s.watch.track(c, state)
+}
+
+// pendingConns tracks connections that have been accepted but have not
+// sent their first request yet.
+type pendingConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// track is an http.Server ConnState hook.
+func (p *pendingConns) track(c net.Conn, state http.ConnState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state == http.StateNew {
+		if p.conns == nil {
+			p.conns = map[net.Conn]struct{}{}
+		}
+		p.conns[c] = struct{}{}
+		return
+	}
+	delete(p.conns, c)
+}
+
+func (p *pendingConns) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
 }
 
-func (s *Server) startTLS() error {
+// setupTLS loads or creates the certificate of the main listeners and,
+// for ACME, the redirect server answering its challenges.
+func (s *Server) setupTLS() error {
 	var tlsConfig *tls.Config
 
 	// Check for ACME config first (Let's Encrypt)
 	if s.cfg.Server.TLS.ACME.Email != "" {
 		var err error
-		tlsConfig, s.redirectSrv, err = SetupACME(s.cfg, s.logger)
+		tlsConfig, s.redirectSrv, err = SetupACME(s.cfg, s.logger, s.notifier)
 		if err != nil {
 			return fmt.Errorf("setting up ACME: %w", err)
 		}
 	} else if s.cfg.Server.TLS.Cert != "" && s.cfg.Server.TLS.Key != "" {
 		// Use custom cert/key if provided
-		return s.http.ListenAndServeTLS(s.cfg.Server.TLS.Cert, s.cfg.Server.TLS.Key)
+		cert, err := tls.LoadX509KeyPair(s.cfg.Server.TLS.Cert, s.cfg.Server.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
 	} else if s.cfg.Server.TLS.Auto {
 		// Self-signed cert for development
 		s.logger.Warn("auto-TLS: using self-signed certificate for development")
@@ -126,37 +542,32 @@ func (s *Server) startTLS() error {
 		return fmt.Errorf("TLS enabled but no cert/key provided and auto-TLS is disabled")
 	}
 
+	s.watch.watchTLS(tlsConfig)
 	s.http.TLSConfig = tlsConfig
-
-	// Start HTTP/3 server if enabled
-	if s.cfg.Server.HTTP3 {
-		s.http3 = NewHTTP3Server(s.cfg, s.buildMiddleware(s.router), tlsConfig, s.logger)
-		go func() {
-			if err := s.http3.Start(); err != nil {
-				s.logger.Error("HTTP/3 server error", "error", err)
-			}
-		}()
-	}
-
-	return s.http.ListenAndServeTLS("", "")
+	return nil
 }
 
 func (s *Server) buildMiddleware(handler http.Handler) http.Handler {
-	// CoreMiddleware collapses Recovery + RequestID + EarlyHints + Logging
+	// CoreMiddleware collapses Recovery + RequestID + Logging
 	// into a single handler with one pooled response writer and one context value.
-	handler = CoreMiddleware(s.logger)(handler)
+	handler = CoreMiddleware(s.logger, s.accessLog, s.sampler, s.cfg.Tracing, s.reports.report)(handler)
 
 	if s.cfg.Metrics.Enabled {
-		handler = s.metrics.Middleware(s.cfg.Metrics.Path)(handler)
+		handler = s.metrics.Middleware(s.cfg.Metrics.Path, newScrapeGuard(s.cfg))(handler)
 	}
 
-	// Compression is outermost (wraps everything including metrics)
-	handler = CompressionMiddleware()(handler)
+	// Compression is outermost (wraps everything including metrics). It
+	// is always in place: the router holds the policy, nil when off.
+	handler = compression(s.router.compression.Load)(handler)
 
 	// Add Alt-Svc header for HTTP/3 advertisement
 	if s.cfg.Server.HTTP3 {
 		handler = AltSvcMiddleware(443)(handler)
 	}
 
-	return handler
+	// Header names are restored after every other layer has set its own
+	handler = preserveHeaderCase(s.router)(handler)
+
+	// Counting in flight is outermost, so Stop waits for whole responses
+	return s.inFlight.middleware(handler)
 }
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/protocol"
+)
+
+func TestStopServesAcceptedConnections(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.Address = "127.0.0.1:0"
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+	<-s.Listening()
+	addr := s.mainLns[0].Addr().String()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for deadline := time.Now().Add(5 * time.Second); s.pending.len() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("connection never accepted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The request arrives only after Stop has begun, as it can for a
+	// connection accepted just before a binary upgrade hands over.
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.Stop(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("new connection accepted after Stop")
+	}
+
+	fmt.Fprint(conn, "GET /health HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	resp.Body.Close()
+
+	if err := <-stopped; err != nil {
+		t.Errorf("Stop = %v", err)
+	}
+	if err := <-started; err != http.ErrServerClosed {
+		t.Errorf("Start = %v, want http.ErrServerClosed", err)
+	}
+}
+
+func TestWebSocketRoute(t *testing.T) {
+	cfg := appConfig(t)
+	cfg.Compression.Enabled = true
+	cfg.Metrics.Enabled = true
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.Worker = "ws.php"
+	s := New(cfg, &stubPool{workers: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.websocket.SetPHPForwarder(func(f *protocol.Frame) (*protocol.Frame, error) {
+		h, data, err := protocol.DecodeStreamData(f)
+		if err != nil || h.Event != protocol.EventMessage {
+			return nil, err
+		}
+		reply := &protocol.StreamHeader{Event: protocol.EventMessage, ConnectionID: h.ConnectionID}
+		return protocol.EncodeStreamData(0, reply, append([]byte("echo: "), data...))
+	})
+	srv := httptest.NewServer(s.http.Handler)
+	defer srv.Close()
+
+	// Through the whole middleware chain, compression included
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + cfg.WebSocket.Path
+	conn, _, err := gorilla.DefaultDialer.Dial(url, http.Header{"Accept-Encoding": {"gzip"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "echo: hello" {
+		t.Errorf("reply = %q, want %q", msg, "echo: hello")
+	}
+
+	// Paths merely sharing the prefix still reach PHP
+	resp, err := http.Get(srv.URL + cfg.WebSocket.Path + "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET %sx = %d, want 200 from PHP", cfg.WebSocket.Path, resp.StatusCode)
+	}
+}
+
+func TestServerInvoke(t *testing.T) {
+	cfg := config.Default()
+	cfg.App.Root = "/app"
+	s := New(cfg, &scriptPool{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// The default group, with a script relative to the pool's root.
+	resp, err := s.Invoke(context.Background(), phpengine.InternalRequest{Entry: "index.php"})
+	if err != nil || resp.Status != http.StatusOK {
+		t.Fatalf("default group: %v, %v", resp, err)
+	}
+
+	var ran string
+	s.AddWorkerGroup(WorkerGroup{
+		Name:  "reports",
+		Pool:  poolDefault,
+		Stats: func() GroupStats { return GroupStats{} },
+		Invoke: func(_ context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+			ran = r.Entry
+			return &phpengine.Response{Status: http.StatusAccepted}, nil
+		},
+	})
+	s.AddWorkerGroup(WorkerGroup{Name: "chat", Pool: poolWebSocket, Stats: func() GroupStats { return GroupStats{} }})
+
+	if resp, err := s.Invoke(context.Background(), phpengine.InternalRequest{Entry: "build.php", Group: "reports"}); err != nil || resp.Status != http.StatusAccepted || ran != "build.php" {
+		t.Errorf("named group: %v, %v, ran %q", resp, err, ran)
+	}
+	for _, group := range []string{"missing", "chat"} {
+		if _, err := s.Invoke(context.Background(), phpengine.InternalRequest{Entry: "x.php", Group: group}); err == nil || !strings.Contains(err.Error(), group) {
+			t.Errorf("group %s: error %v", group, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+var slowBodyAborts = metrics.NewCounter("maboo_http_slow_body_aborts_total",
+	"Requests answered with 408 because the client sent its body slower than server.min_body_rate.")
+
+// errSlowBody is returned by the body of a request whose client sends it
+// slower than server.min_body_rate.
+var errSlowBody = errors.New("request body sent too slowly")
+
+// rateBody enforces server.min_body_rate on a request body: reading each
+// min bytes may wait for the client for interval in all. Only the time
+// spent waiting for the client counts, so a script reading its input
+// slowly is not taken for a slow client. A wait is cut short with a read
+// deadline on the connection; where the connection has none, the rate is
+// checked as reads return.
+type rateBody struct {
+	io.ReadCloser
+	rc       *http.ResponseController
+	min      int64
+	interval time.Duration
+
+	got    int64         // bytes read towards min
+	waited time.Duration // time spent waiting for them
+
+	// slow is read by the handler while an execution abandoned on a
+	// timeout may still be reading the body.
+	slow atomic.Bool
+}
+
+type rateBodyKey struct{}
+
+// guardBody has req's body read at server.min_body_rate or fail with
+// errSlowBody. It returns req unchanged when there is no body or no
+// minimum.
+func guardBody(w http.ResponseWriter, req *http.Request, min int64, interval time.Duration) *http.Request {
+	if min <= 0 || req.Body == nil || req.Body == http.NoBody {
+		return req
+	}
+	b := &rateBody{ReadCloser: req.Body, rc: http.NewResponseController(w), min: min, interval: interval}
+	req = req.WithContext(context.WithValue(req.Context(), rateBodyKey{}, b))
+	req.Body = b
+	return req
+}
+
+func (b *rateBody) Read(p []byte) (int, error) {
+	if b.slow.Load() {
+		return 0, errSlowBody
+	}
+	start := time.Now()
+	deadline := b.rc.SetReadDeadline(start.Add(b.interval-b.waited)) == nil
+	n, err := b.ReadCloser.Read(p)
+	if deadline {
+		b.rc.SetReadDeadline(time.Time{})
+	}
+	b.waited += time.Since(start)
+	b.got += int64(n)
+	if b.got >= b.min {
+		b.got, b.waited = 0, 0
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) || (err == nil && b.waited >= b.interval) {
+		b.slow.Store(true)
+		slowBodyAborts.Inc()
+		return n, errSlowBody
+	}
+	return n, err
+}
+
+// bodyTooSlow reports whether reading req's body failed because the
+// client sent it too slowly. The request's context is then done too, as
+// the connection gave up on the client.
+func bodyTooSlow(req *http.Request) bool {
+	b, _ := req.Context().Value(rateBodyKey{}).(*rateBody)
+	return b != nil && b.slow.Load()
+}
+
+// awaitBody waits for the first bytes of req's body, so that no worker is
+// taken for a request whose client has yet to send it. With Expect:
+// 100-continue, this is when the client is told to go ahead.
+func awaitBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	br := bufio.NewReader(req.Body)
+	if _, err := br.Peek(1); err != nil && err != io.EOF {
+		return err
+	}
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, req.Body}
+	return nil
+}
+
+// slowBody answers a request whose client sent its body too slowly.
+func (r *Router) slowBody(w http.ResponseWriter, req *http.Request, rc *MabooRequestCtx) {
+	r.logger.WarnContext(req.Context(), "request body too slow", "path", req.URL.Path)
+	if rc != nil {
+		rc.ErrorClass = errClassSlowBody
+	}
+	w.Header().Set("Connection", "close")
+	http.Error(w, "Request Timeout", http.StatusRequestTimeout)
+}
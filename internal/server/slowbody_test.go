@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// bodyPool reads the whole request body, as a process worker's pool does
+// before it takes a worker, and answers with its length.
+type bodyPool struct {
+	stubPool
+	runs atomic.Int32
+}
+
+func (p *bodyPool) Exec(ctx *phpengine.Context, _ string) (*phpengine.Response, error) {
+	p.runs.Add(1)
+	var n int64
+	if ctx.Body != nil {
+		var err error
+		if n, err = io.Copy(io.Discard, ctx.Body); err != nil {
+			return nil, fmt.Errorf("reading request body: %w", err)
+		}
+	}
+	return &phpengine.Response{Status: http.StatusOK, Body: []byte(strconv.FormatInt(n, 10))}, nil
+}
+
+func newSlowBodyServer(t *testing.T, pool *bodyPool) string {
+	t.Helper()
+	cfg := appConfig(t)
+	cfg.Server.MaxBodySize = 4096
+	cfg.Server.MinBodyRate = 100
+	cfg.Server.BodyRateInterval = config.Duration(200 * time.Millisecond)
+	srv := httptest.NewServer(NewRouter(cfg, pool, slog.New(slog.NewTextHandler(io.Discard, nil))))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+// postHeaders sends the headers of a POST with a body of size bytes,
+// plus extra headers, and returns the connection and a reader for the
+// responses.
+func postHeaders(t *testing.T, addr string, size int, extra string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: %d\r\n%s\r\n", size, extra)
+	return conn, bufio.NewReader(conn)
+}
+
+func readStatus(t *testing.T, br *bufio.Reader) (int, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestSlowBody(t *testing.T) {
+	pool := &bodyPool{}
+	addr := newSlowBodyServer(t, pool)
+
+	// A body sent at once is read whole.
+	conn, br := postHeaders(t, addr, 1000, "")
+	conn.Write([]byte(strings.Repeat("x", 1000)))
+	if code, body := readStatus(t, br); code != http.StatusOK || body != "1000" {
+		t.Errorf("fast body: %d %q", code, body)
+	}
+
+	// One trickled a byte at a time is cut off.
+	conn, br = postHeaders(t, addr, 1000, "")
+	go func() {
+		for range 1000 {
+			if _, err := conn.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	if code, _ := readStatus(t, br); code != http.StatusRequestTimeout {
+		t.Errorf("trickled body: status %d, want 408", code)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("trickled body answered after %s", d)
+	}
+
+	// A client that never sends its body gets no worker.
+	runs := pool.runs.Load()
+	_, br = postHeaders(t, addr, 1000, "")
+	if code, _ := readStatus(t, br); code != http.StatusRequestTimeout {
+		t.Errorf("missing body: status %d, want 408", code)
+	}
+	if n := pool.runs.Load(); n != runs {
+		t.Errorf("a worker ran %d times for a body never sent", n-runs)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	pool := &bodyPool{}
+	addr := newSlowBodyServer(t, pool)
+
+	// The client is told to go ahead, then sends the body.
+	conn, br := postHeaders(t, addr, 10, "Expect: 100-continue\r\n")
+	if code, _ := readStatus(t, br); code != http.StatusContinue {
+		t.Fatalf("status %d, want 100 Continue", code)
+	}
+	conn.Write([]byte("0123456789"))
+	if code, body := readStatus(t, br); code != http.StatusOK || body != "10" {
+		t.Errorf("after 100 Continue: %d %q", code, body)
+	}
+
+	// A body over server.max_body_size is refused before it is sent.
+	_, br = postHeaders(t, addr, 1<<20, "Expect: 100-continue\r\n")
+	if code, _ := readStatus(t, br); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d, want 413 without 100 Continue", code)
+	}
+	if n := pool.runs.Load(); n != 1 {
+		t.Errorf("PHP ran %d times, want once", n)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// W3C Trace Context (https://www.w3.org/TR/trace-context/) propagation.
+// maboo does not create spans of its own; it only makes sure every request
+// reaching PHP carries a valid traceparent and that maboo's log records can
+// be joined to the trace.
+
+const (
+	traceparentHeader = "Traceparent"
+	tracestateHeader  = "Tracestate"
+	traceparentLen    = 55 // "00-" + 32 + "-" + 16 + "-" + 2
+)
+
+// applyTraceContext validates the incoming traceparent, replacing it when
+// malformed (or missing and generate is set), and records the trace and
+// parent span IDs on rc. The header is rewritten on r so both the embedded
+// and process worker paths expose it to PHP as HTTP_TRACEPARENT.
+func applyTraceContext(r *http.Request, rc *MabooRequestCtx, generate bool) {
+	tp := r.Header.Get(traceparentHeader)
+	if tp != "" {
+		if traceID, spanID, ok := parseTraceparent(tp); ok {
+			rc.TraceID, rc.SpanID = traceID, spanID
+			return
+		}
+		// A malformed traceparent invalidates tracestate too (spec §3.3).
+		r.Header.Del(tracestateHeader)
+	} else if !generate {
+		return
+	}
+
+	rc.TraceID, rc.SpanID, tp = newTraceparent()
+	r.Header.Set(traceparentHeader, tp)
+}
+
+// parseTraceparent returns the trace-id and parent-id of a version 00
+// traceparent. Higher versions are accepted if their first 55 bytes parse,
+// as the spec requires. It never panics on arbitrary input.
+func parseTraceparent(s string) (traceID, spanID string, ok bool) {
+	if len(s) < traceparentLen {
+		return "", "", false
+	}
+	version := s[0:2]
+	if !isLowerHex(version) || version == "ff" {
+		return "", "", false
+	}
+	if version == "00" && len(s) != traceparentLen {
+		return "", "", false
+	}
+	if len(s) > traceparentLen && s[traceparentLen] != '-' {
+		return "", "", false
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return "", "", false
+	}
+
+	traceID, spanID, flags := s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return "", "", false
+	}
+	if allZero(traceID) || allZero(spanID) {
+		return "", "", false
+	}
+	return traceID, spanID, true
+}
+
+// newTraceparent generates a sampled version 00 traceparent.
+func newTraceparent() (traceID, spanID, header string) {
+	var raw [24]byte
+	rand.Read(raw[:])
+
+	var buf [traceparentLen]byte
+	copy(buf[0:3], "00-")
+	hex.Encode(buf[3:35], raw[:16])
+	buf[35] = '-'
+	hex.Encode(buf[36:52], raw[16:])
+	copy(buf[52:], "-01")
+
+	header = string(buf[:])
+	return header[3:35], header[36:52], header
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func allZero(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '0' {
+			return false
+		}
+	}
+	return true
+}
+
+// TraceHandler is a slog.Handler that adds trace_id and span_id to every
+// record logged with a request context.
+type TraceHandler struct {
+	slog.Handler
+}
+
+// NewTraceHandler wraps h so request-scoped records carry trace IDs.
+func NewTraceHandler(h slog.Handler) *TraceHandler {
+	if th, ok := h.(*TraceHandler); ok {
+		return th
+	}
+	return &TraceHandler{Handler: h}
+}
+
+// Handle implements slog.Handler.
+func (h *TraceHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if rc := GetRequestCtx(ctx); rc != nil && rc.TraceID != "" {
+		rec.AddAttrs(slog.String("trace_id", rc.TraceID), slog.String("span_id", rc.SpanID))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *TraceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &TraceHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (h *TraceHandler) WithGroup(name string) slog.Handler {
+	return &TraceHandler{Handler: h.Handler.WithGroup(name)}
+}
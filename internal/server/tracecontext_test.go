@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sadewadee/maboo/internal/config"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{valid, true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"", false},
+		{"00", false},
+		{valid + "-", false}, // v00 must be exactly 55 bytes
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false}, // forbidden version
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false}, // uppercase
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false}, // zero trace-id
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false}, // zero parent-id
+		{"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01", false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01x", false},
+	}
+	for _, tt := range tests {
+		traceID, spanID, ok := parseTraceparent(tt.in)
+		if ok != tt.ok {
+			t.Errorf("parseTraceparent(%q) ok = %v, want %v", tt.in, ok, tt.ok)
+			continue
+		}
+		if ok && (traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spanID != "00f067aa0ba902b7") {
+			t.Errorf("parseTraceparent(%q) = %q, %q", tt.in, traceID, spanID)
+		}
+	}
+}
+
+func TestNewTraceparentRoundTrip(t *testing.T) {
+	traceID, spanID, header := newTraceparent()
+	gotTrace, gotSpan, ok := parseTraceparent(header)
+	if !ok || gotTrace != traceID || gotSpan != spanID {
+		t.Fatalf("generated %q does not round-trip", header)
+	}
+}
+
+func TestCoreMiddlewareTraceContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewTraceHandler(slog.NewJSONHandler(&buf, nil)))
+	tracing := config.TracingConfig{Enabled: true, Generate: true, ResponseHeader: "X-Trace-Id"}
+
+	var seen http.Header
+	h := CoreMiddleware(logger, logger, nil, tracing, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		logger.InfoContext(r.Context(), "from handler")
+	}))
+
+	t.Run("propagates valid header", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		req.Header.Set("tracestate", "vendor=x")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("X-Trace-Id"); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("X-Trace-Id = %q", got)
+		}
+		if seen.Get("Tracestate") != "vendor=x" {
+			t.Error("tracestate must be forwarded with a valid traceparent")
+		}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if !strings.Contains(line, `"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`) ||
+				!strings.Contains(line, `"span_id":"00f067aa0ba902b7"`) {
+				t.Errorf("log record missing trace fields: %s", line)
+			}
+		}
+	})
+
+	t.Run("regenerates malformed header", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("traceparent", "garbage")
+		req.Header.Set("tracestate", "vendor=x")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if _, _, ok := parseTraceparent(seen.Get("Traceparent")); !ok {
+			t.Errorf("expected a regenerated traceparent, got %q", seen.Get("Traceparent"))
+		}
+		if seen.Get("Tracestate") != "" {
+			t.Error("tracestate must be dropped with a malformed traceparent")
+		}
+	})
+
+	t.Run("generates when absent", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if _, _, ok := parseTraceparent(seen.Get("Traceparent")); !ok {
+			t.Errorf("expected a generated traceparent, got %q", seen.Get("Traceparent"))
+		}
+		if len(rec.Header().Get("X-Trace-Id")) != 32 {
+			t.Errorf("X-Trace-Id = %q", rec.Header().Get("X-Trace-Id"))
+		}
+	})
+}
+
+func FuzzParseTraceparent(f *testing.F) {
+	f.Add("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what")
+	f.Fuzz(func(t *testing.T, s string) {
+		parseTraceparent(s)
+	})
+}
@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// ScriptPool runs PHP scripts for WebSocket connections: the
+// websocket.auth_endpoint and, on the embedded engine, websocket.worker.
+// The worker pool satisfies it.
+type ScriptPool interface {
+	Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error)
+}
+
+var errAuthTimeout = errors.New("websocket auth endpoint timed out")
+
+// authHeaders are always passed to the auth endpoint, on top of
+// websocket.forward_headers.
+var authHeaders = []string{"Cookie", "Authorization"}
+
+// SetAuthPool sets the pool that runs websocket.auth_endpoint, with docRoot
+// the application root the endpoint is relative to. Without it, a
+// configured endpoint refuses every upgrade.
+func (h *Handler) SetAuthPool(pool ScriptPool, docRoot string) {
+	if docRoot == "" {
+		docRoot = "."
+	}
+	h.authPool = pool
+	h.authRoot = docRoot
+}
+
+// authenticate asks the auth endpoint whether r may be upgraded. A 2xx
+// answer allows it, and its body is returned for the connect event. Any
+// other answer is written to w as-is and ok is false.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request, ip string) (body []byte, ok bool) {
+	if h.authEndpoint == "" {
+		return nil, true
+	}
+
+	start := time.Now()
+	resp, err := h.execAuth(r)
+	authDuration.Observe(time.Since(start).Seconds())
+
+	switch {
+	case errors.Is(err, errAuthTimeout):
+		authTotal.WithLabelValues(authTimeout).Inc()
+		h.logger.Warn("websocket auth endpoint timed out", "client_ip", ip, "timeout", h.authTimeout)
+		http.Error(w, "authentication timed out", http.StatusGatewayTimeout)
+		return nil, false
+	case err != nil:
+		authTotal.WithLabelValues(authError).Inc()
+		h.logger.Error("websocket auth endpoint failed", "client_ip", ip, "error", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return nil, false
+	case resp.Status < 200 || resp.Status > 299:
+		authTotal.WithLabelValues(authDenied).Inc()
+		h.logger.Debug("websocket auth denied", "client_ip", ip, "status", resp.Status)
+		for k, v := range resp.Headers {
+			if phpengine.ValidHeaderName(k) && phpengine.ValidHeaderValue(v) {
+				w.Header().Set(k, v)
+			}
+		}
+		w.WriteHeader(resp.Status)
+		w.Write(resp.Body)
+		return nil, false
+	}
+	authTotal.WithLabelValues(authAllowed).Inc()
+	return resp.Body, true
+}
+
+// execAuth runs the auth endpoint with a GET for the original path and
+// query, carrying only the credential headers. A worker still busy when
+// the timeout expires finishes in the background.
+func (h *Handler) execAuth(r *http.Request) (*phpengine.Response, error) {
+	if h.authPool == nil {
+		return nil, fmt.Errorf("websocket.auth_endpoint is set but no worker pool runs it")
+	}
+
+	req := phpengine.InternalRequest{
+		Entry:      h.authEndpoint,
+		DocRoot:    h.authRoot,
+		Path:       r.URL.RequestURI(),
+		Headers:    map[string]string{"Host": r.Host},
+		RemoteAddr: r.RemoteAddr,
+		Caller:     metrics.CallerWebSocketAuth,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.RemoteAddr = host
+	}
+	if r.TLS != nil {
+		req.ServerVars = map[string]string{"HTTPS": "on"}
+	}
+	for _, names := range [][]string{authHeaders, h.headers} {
+		for _, name := range names {
+			if value := r.Header.Get(name); value != "" {
+				req.Headers[name] = value
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.authTimeout)
+	defer cancel()
+	resp, err := h.authPool.Invoke(ctx, req)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, errAuthTimeout
+	}
+	return resp, err
+}
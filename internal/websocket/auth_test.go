@@ -0,0 +1,138 @@
+package websocket_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+// authPool answers the auth endpoint with exec, handing each request's
+// context to requests.
+type authPool struct {
+	requests chan *phpengine.Context
+	exec     func(ctx *phpengine.Context) *phpengine.Response
+}
+
+func (p *authPool) Exec(ctx *phpengine.Context, script string) (*phpengine.Response, error) {
+	ctx.ScriptFilename = script
+	p.requests <- ctx
+	return p.exec(ctx), nil
+}
+
+func (p *authPool) Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	return phpengine.Invoke(ctx, p, "", r)
+}
+
+func serveAuth(t *testing.T, timeout time.Duration, exec func(*phpengine.Context) *phpengine.Response) (*authPool, *fakePHP, string) {
+	t.Helper()
+	cfg := config.Default().WebSocket
+	cfg.AuthEndpoint = "ws-auth.php"
+	cfg.AuthTimeout = config.Duration(timeout)
+	pool := &authPool{requests: make(chan *phpengine.Context, 10), exec: exec}
+	m, url := serve(t, cfg, func(h *websocket.Handler) { h.SetAuthPool(pool, "/app") })
+	php := &fakePHP{}
+	m.SetPHPForwarder(php.forward)
+	return pool, php, url
+}
+
+func TestAuthAllows(t *testing.T) {
+	pool, php, url := serveAuth(t, time.Second, func(*phpengine.Context) *phpengine.Response {
+		return &phpengine.Response{Status: 200, Body: []byte(`{"sub":"42"}`)}
+	})
+	infos := make(chan protocol.ConnectInfo, 1)
+	php.setReply(func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		if h.Event == protocol.EventConnect {
+			var info protocol.ConnectInfo
+			protocol.UnmarshalMsgpack(data, &info)
+			infos <- info
+		}
+		return nil
+	})
+	before := counter(t, "maboo_websocket_auth_total", "allowed")
+
+	dialHeader(t, url+"/chat?room=1", http.Header{
+		"Cookie":        {"session=abc"},
+		"Authorization": {"Bearer xyz"},
+		"X-Internal":    {"secret"},
+	})
+
+	ctx := <-pool.requests
+	if ctx.ScriptFilename != "/app/ws-auth.php" {
+		t.Errorf("script = %q, want /app/ws-auth.php", ctx.ScriptFilename)
+	}
+	if ctx.Server["REQUEST_METHOD"] != "GET" || ctx.Server["REQUEST_URI"] != "/chat" || ctx.Server["QUERY_STRING"] != "room=1" {
+		t.Errorf("request %s %s?%s, want GET /chat?room=1",
+			ctx.Server["REQUEST_METHOD"], ctx.Server["REQUEST_URI"], ctx.Server["QUERY_STRING"])
+	}
+	if ctx.Server["HTTP_COOKIE"] != "session=abc" || ctx.Server["HTTP_AUTHORIZATION"] != "Bearer xyz" {
+		t.Errorf("credentials not passed: %v", ctx.Server)
+	}
+	for _, h := range []string{"HTTP_X_INTERNAL", "HTTP_UPGRADE", "HTTP_SEC_WEBSOCKET_KEY"} {
+		if _, ok := ctx.Server[h]; ok {
+			t.Errorf("%s passed to the auth endpoint", h)
+		}
+	}
+
+	if info := <-infos; info.Auth != `{"sub":"42"}` {
+		t.Errorf("connect auth = %q, want the endpoint's body", info.Auth)
+	}
+	if got := counter(t, "maboo_websocket_auth_total", "allowed") - before; got != 1 {
+		t.Errorf("auth{allowed} grew by %v, want 1", got)
+	}
+}
+
+func TestAuthDenies(t *testing.T) {
+	_, php, url := serveAuth(t, time.Second, func(*phpengine.Context) *phpengine.Response {
+		return &phpengine.Response{
+			Status:  401,
+			Headers: map[string]string{"WWW-Authenticate": "Bearer"},
+			Body:    []byte("login first"),
+		}
+	})
+
+	conn, resp, err := gorilla.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		conn.Close()
+		t.Fatal("upgrade allowed, want 401")
+	}
+	if resp == nil || resp.StatusCode != 401 {
+		t.Fatalf("dial error %v, response %v; want 401", err, resp)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "login first" || resp.Header.Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("response %q with headers %v, want the endpoint's answer", body, resp.Header)
+	}
+	if got := php.seen(protocol.EventConnect); len(got) != 0 {
+		t.Errorf("PHP was told about a denied connection: %+v", got)
+	}
+}
+
+func TestAuthTimeout(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	_, _, url := serveAuth(t, 50*time.Millisecond, func(*phpengine.Context) *phpengine.Response {
+		<-release
+		return &phpengine.Response{Status: 200}
+	})
+	before := counter(t, "maboo_websocket_auth_total", "timeout")
+
+	conn, resp, err := gorilla.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		conn.Close()
+		t.Fatal("upgrade allowed, want 504")
+	}
+	if resp == nil || resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("dial error %v, response %v; want 504", err, resp)
+	}
+	if got := counter(t, "maboo_websocket_auth_total", "timeout") - before; got != 1 {
+		t.Errorf("auth{timeout} grew by %v, want 1", got)
+	}
+}
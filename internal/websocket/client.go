@@ -0,0 +1,224 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Backpressure controls each client's outbound queue. Sends only enqueue;
+// a writer goroutine per client does the I/O, so a slow client cannot
+// stall broadcasts to the others.
+type Backpressure struct {
+	QueueSize    int           // messages buffered per client
+	WriteTimeout time.Duration // how long one write may take before the client is dropped
+	Drop         bool          // drop messages for a full queue instead of disconnecting
+}
+
+// DefaultBackpressure is used until SetBackpressure is called.
+var DefaultBackpressure = Backpressure{QueueSize: 256, WriteTimeout: 10 * time.Second}
+
+// Errors returned by Send.
+var (
+	errClientClosed   = errors.New("websocket client closed")
+	errSendQueueFull  = errors.New("websocket send queue full, message dropped")
+	errSlowClientGone = errors.New("websocket send queue full, client disconnected")
+)
+
+// Client represents a single WebSocket connection.
+type Client struct {
+	ID          string
+	Conn        *websocket.Conn
+	RemoteAddr  string
+	IP          string // client address the connection limits count against
+	UserID      string // assigned by PHP when accepting the connection; guarded by the manager's mu
+	Auth        []byte // body of the websocket.auth_endpoint answer, e.g. user claims
+	Subprotocol string // negotiated from websocket.subprotocols; "" = none
+	Rooms       map[string]bool
+	ConnectedAt time.Time
+
+	authorized map[string]roomDecision // private rooms PHP decided on, guarded by the manager's mu
+
+	bp          Backpressure
+	compressMin int      // messages this long or longer are compressed, if negotiated
+	session     *session // nil unless the connection can be resumed
+	queue       chan message
+	closing     chan struct{} // closed to make the writer flush and stop
+	done        chan struct{} // closed when the writer has stopped
+	gone        chan struct{} // closed when the manager has unregistered the client
+
+	// Why the server closed the connection, set once before closing is
+	// closed. sendClose makes the writer send them in a close frame, and
+	// awaitAnswer keep the connection open until the client answers it.
+	stopOnce    sync.Once
+	closeCode   int
+	closeReason string
+	sendClose   bool
+	awaitAnswer bool
+}
+
+func newClient(id string, conn *websocket.Conn, bp Backpressure, compressMin int) *Client {
+	c := &Client{
+		ID:          id,
+		Conn:        conn,
+		Rooms:       make(map[string]bool),
+		ConnectedAt: time.Now(),
+		bp:          bp,
+		compressMin: compressMin,
+		queue:       make(chan message, bp.QueueSize),
+		closing:     make(chan struct{}),
+		done:        make(chan struct{}),
+		gone:        make(chan struct{}),
+	}
+	go c.writePump()
+	return c
+}
+
+// Send queues a message for this WebSocket client without waiting for
+// the write. When the queue is full the message is dropped or the client
+// disconnected, according to its Backpressure. A resumable client's
+// messages go through its session, which keeps them for a resume.
+func (c *Client) Send(data []byte) error {
+	return c.send(message{data: data})
+}
+
+func (c *Client) send(msg message) error {
+	if c.session != nil {
+		return c.session.send(msg)
+	}
+	select {
+	case <-c.closing:
+		return errClientClosed
+	default:
+	}
+	select {
+	case c.queue <- msg:
+		return nil
+	default:
+	}
+
+	if c.bp.Drop {
+		droppedTotal.WithLabelValues(slowDrop).Inc()
+		return errSendQueueFull
+	}
+	droppedTotal.WithLabelValues(slowDisconnect).Inc()
+	// The client cannot keep up, so there is no point flushing to it. Its
+	// read loop then fails and unregisters it.
+	c.stop(websocket.ClosePolicyViolation, "send queue full")
+	c.Conn.Close()
+	return errSlowClientGone
+}
+
+// close makes the writer send the queued messages, then a close frame
+// with code and reason, and close the connection.
+func (c *Client) close(code int, reason string) {
+	c.closeWith(code, reason, false)
+}
+
+// closeAwaiting is close, but the writer keeps the connection open until
+// the client answers the close frame, completing the closing handshake,
+// and its read loop ends.
+func (c *Client) closeAwaiting(code int, reason string) {
+	c.closeWith(code, reason, true)
+}
+
+func (c *Client) closeWith(code int, reason string, await bool) {
+	if code < websocket.CloseNormalClosure || code > 4999 {
+		code = websocket.ClosePolicyViolation
+	}
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+	c.stopOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		c.sendClose, c.awaitAnswer = true, await
+		close(c.closing)
+	})
+}
+
+// maxCloseReason is the longest close reason: a control frame carries 125
+// bytes, two of which hold the code.
+const maxCloseReason = 123
+
+// stop makes the writer flush the queue and exit without a close frame.
+// Only the first call, or the first close, counts; code 0 records no
+// reason.
+func (c *Client) stop(code int, reason string) {
+	c.stopOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		close(c.closing)
+	})
+}
+
+// closeStatus returns the code and reason the server closed the
+// connection with, or 0 if it did not.
+func (c *Client) closeStatus() (int, string) {
+	select {
+	case <-c.closing:
+		return c.closeCode, c.closeReason
+	default:
+		return 0, ""
+	}
+}
+
+// writePump writes queued messages until stop is called, then flushes
+// what is left. A failed or timed-out write closes the connection, which
+// ends the read loop.
+func (c *Client) writePump() {
+	defer close(c.done)
+	for {
+		select {
+		case msg := <-c.queue:
+			if !c.write(msg) {
+				return
+			}
+		case <-c.closing:
+			c.flush()
+			return
+		}
+	}
+}
+
+func (c *Client) flush() {
+	for {
+		select {
+		case msg := <-c.queue:
+			if !c.write(msg) {
+				return
+			}
+		default:
+			if c.sendClose {
+				msg := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+				c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				if c.awaitAnswer {
+					<-c.gone
+				}
+				c.Conn.Close()
+			}
+			return
+		}
+	}
+}
+
+func (c *Client) write(msg message) bool {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.bp.WriteTimeout))
+	// Deflating short messages costs more than it saves. This only has an
+	// effect when the client negotiated permessage-deflate.
+	c.Conn.EnableWriteCompression(len(msg.data) >= c.compressMin)
+	var err error
+	if msg.prepared != nil {
+		err = c.Conn.WritePreparedMessage(msg.prepared)
+	} else {
+		err = c.Conn.WriteMessage(websocket.TextMessage, msg.data)
+	}
+	if err != nil {
+		sendFailuresTotal.WithLabelValues(dirOut).Inc()
+		c.stop(0, "")
+		c.Conn.Close()
+		return false
+	}
+	bytesTotal.WithLabelValues(dirOut).Add(float64(len(msg.data)))
+	return true
+}
@@ -0,0 +1,257 @@
+package websocket_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+// floodSlowClient broadcasts more than the socket buffers and send queue
+// of a client that never reads can hold, waiting for a reading client to
+// receive each message. A stalled broadcast would stall the test.
+func floodSlowClient(t *testing.T, policy string) {
+	t.Helper()
+	cfg := config.Default().WebSocket
+	cfg.SendQueue = 4
+	cfg.SlowClient = policy
+	m, url := serve(t, cfg, nil)
+	dial(t, url) // never reads
+	fast := dial(t, url)
+	waitConnections(t, m, 2)
+
+	msg := bytes.Repeat([]byte("x"), 256<<10)
+	for i := range 128 {
+		m.Broadcast(msg, "")
+		fast.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := fast.ReadMessage(); err != nil {
+			t.Fatalf("fast client, message %d: %v", i, err)
+		}
+	}
+}
+
+func TestSlowClientDrop(t *testing.T) {
+	before := counter(t, "maboo_websocket_dropped_messages_total", "drop")
+	floodSlowClient(t, "drop")
+	if counter(t, "maboo_websocket_dropped_messages_total", "drop") == before {
+		t.Error("no messages counted as dropped")
+	}
+}
+
+func TestSlowClientDisconnect(t *testing.T) {
+	before := counter(t, "maboo_websocket_dropped_messages_total", "disconnect")
+	floodSlowClient(t, "disconnect")
+	if counter(t, "maboo_websocket_dropped_messages_total", "disconnect") == before {
+		t.Error("no messages counted as dropped")
+	}
+}
+
+func TestSlowClientDisconnectUnregisters(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.SendQueue = 1
+	m, url := serve(t, cfg, nil)
+	dial(t, url) // never reads
+	waitConnections(t, m, 1)
+
+	msg := bytes.Repeat([]byte("x"), 64<<10)
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Stats().TotalConnections != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("slow client was not disconnected")
+		}
+		m.Broadcast(msg, "")
+	}
+}
+
+// shutdown runs m.Shutdown in the background and returns its result.
+func shutdown(m *websocket.Manager) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		result <- m.Shutdown(ctx)
+	}()
+	return result
+}
+
+func TestShutdownFlushes(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.ShutdownMessage = `{"event":"server.going_away"}`
+	m, url := serve(t, cfg, nil)
+	conn := dial(t, url)
+	waitConnections(t, m, 1)
+	before := counter(t, "maboo_websocket_shutdown_closes_total", "graceful")
+
+	for _, msg := range []string{"one", "two", "three"} {
+		m.Broadcast([]byte(msg), "")
+	}
+	result := shutdown(m)
+
+	for _, want := range []string{"one", "two", "three", cfg.ShutdownMessage} {
+		expectBroadcast(t, conn, want)
+	}
+	// Reading the close frame answers it.
+	if _, _, err := conn.ReadMessage(); !gorilla.IsCloseError(err, gorilla.CloseGoingAway) {
+		t.Errorf("read after shutdown: %v, want going away", err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	waitConnections(t, m, 0)
+	if got := counter(t, "maboo_websocket_shutdown_closes_total", "graceful") - before; got != 1 {
+		t.Errorf("shutdown closes{graceful} grew by %v, want 1", got)
+	}
+}
+
+func TestShutdownForcesSilentClients(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.DrainTimeout = config.Duration(100 * time.Millisecond)
+	cfg.CloseSpread = 0
+	m, url := serve(t, cfg, nil)
+	dial(t, url) // never reads, so never answers the close frame
+	waitConnections(t, m, 1)
+	before := counter(t, "maboo_websocket_shutdown_closes_total", "forced")
+
+	start := time.Now()
+	if err := <-shutdown(m); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("Shutdown took %s with a 100ms drain timeout", took)
+	}
+	waitConnections(t, m, 0)
+	if got := counter(t, "maboo_websocket_shutdown_closes_total", "forced") - before; got != 1 {
+		t.Errorf("shutdown closes{forced} grew by %v, want 1", got)
+	}
+}
+
+func TestShutdownSpreadsCloseFrames(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.CloseSpread = config.Duration(400 * time.Millisecond)
+	m, url := serve(t, cfg, nil)
+	conns := make([]*gorilla.Conn, 3)
+	for i := range conns {
+		conns[i] = dial(t, url)
+	}
+	waitConnections(t, m, len(conns))
+
+	result := shutdown(m)
+	closedAt := make(chan time.Time, len(conns))
+	for _, conn := range conns {
+		go func() {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			conn.ReadMessage()
+			closedAt <- time.Now()
+		}()
+	}
+	first, last := <-closedAt, time.Time{}
+	for range len(conns) - 1 {
+		last = <-closedAt
+	}
+	if spread := last.Sub(first); spread < 300*time.Millisecond {
+		t.Errorf("close frames arrived within %s, want them spread over about 400ms", spread)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+// countingConn counts the bytes read from the wire.
+type countingConn struct {
+	net.Conn
+	read *atomic.Int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// dialCompressed dials offering permessage-deflate and returns the
+// connection with a counter of the bytes it read off the wire.
+func dialCompressed(t *testing.T, url string) (*gorilla.Conn, *atomic.Int64) {
+	t.Helper()
+	read := new(atomic.Int64)
+	dialer := gorilla.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			return countingConn{conn, read}, err
+		},
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, read
+}
+
+// wireSize sends msg from the server and returns the bytes the client
+// read for it.
+func wireSize(t *testing.T, m *websocket.Manager, conn *gorilla.Conn, read *atomic.Int64, msg []byte) int64 {
+	t.Helper()
+	before := read.Load()
+	m.Broadcast(msg, "")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, got, err := conn.ReadMessage()
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("read %d bytes, %v; want the %d sent", len(got), err, len(msg))
+	}
+	return read.Load() - before
+}
+
+func chatJSON(t *testing.T, n int) []byte {
+	t.Helper()
+	type message struct {
+		User string `json:"user"`
+		Room string `json:"room"`
+		Text string `json:"text"`
+	}
+	msgs := make([]message, n)
+	for i := range msgs {
+		msgs[i] = message{User: "alice", Room: "lobby", Text: "hello everyone, how is it going?"}
+	}
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCompression(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.Compression.Enabled = true
+	cfg.Compression.Threshold = 512
+	m, url := serve(t, cfg, nil)
+	conn, read := dialCompressed(t, url)
+	waitConnections(t, m, 1)
+
+	large := chatJSON(t, 500)
+	if n := wireSize(t, m, conn, read, large); n*4 > int64(len(large)) {
+		t.Errorf("%d byte JSON message took %d bytes on the wire, want it compressed", len(large), n)
+	}
+	small := chatJSON(t, 5)
+	if n := wireSize(t, m, conn, read, small); n < int64(len(small)) {
+		t.Errorf("%d byte message under the threshold took %d bytes on the wire, want it uncompressed", len(small), n)
+	}
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	m, url := serve(t, config.Default().WebSocket, nil)
+	conn, read := dialCompressed(t, url)
+	waitConnections(t, m, 1)
+
+	large := chatJSON(t, 500)
+	if n := wireSize(t, m, conn, read, large); n < int64(len(large)) {
+		t.Errorf("%d byte message took %d bytes on the wire with compression disabled", len(large), n)
+	}
+}
@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/protocol"
+)
+
+// $_SERVER variables describing a stream event to websocket.worker on the
+// embedded engine.
+const (
+	serverEvent  = "MABOO_WS_EVENT"
+	serverConnID = "MABOO_WS_CONN_ID"
+	serverRoom   = "MABOO_WS_ROOM"
+	serverUserID = "MABOO_WS_USER_ID"
+	serverCode   = "MABOO_WS_CODE"
+)
+
+// EmbeddedForwarder returns a forwarder for SetPHPForwarder that runs
+// script, relative to docRoot, on the embedded engine's pool, which speaks
+// requests rather than protocol frames. Each event becomes a POST: the
+// header fields in $_SERVER (MABOO_WS_EVENT, MABOO_WS_CONN_ID,
+// MABOO_WS_ROOM, MABOO_WS_USER_ID and MABOO_WS_CODE) and the payload in
+// $_POST["data"]. The response body is the msgpack array of commands a
+// batch frame carries; an empty body carries none.
+func EmbeddedForwarder(pool ScriptPool, docRoot, script string) func(*protocol.Frame) (*protocol.Frame, error) {
+	if docRoot == "" {
+		docRoot = "."
+	}
+	return func(frame *protocol.Frame) (*protocol.Frame, error) {
+		header, data, err := protocol.DecodeStreamData(frame)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := pool.Invoke(context.Background(), streamRequest(header, data, docRoot, script))
+		if err != nil {
+			return nil, err
+		}
+		if resp.Status >= http.StatusBadRequest {
+			return nil, fmt.Errorf("%s answered the %s event with status %d", script, header.Event, resp.Status)
+		}
+		if len(resp.Body) == 0 {
+			return nil, nil
+		}
+		return protocol.EncodeStreamData(frame.StreamID, &protocol.StreamHeader{Event: protocol.EventBatch}, resp.Body)
+	}
+}
+
+// streamRequest builds the request that hands one stream event to script.
+func streamRequest(header *protocol.StreamHeader, data []byte, docRoot, script string) phpengine.InternalRequest {
+	vars := map[string]string{
+		serverEvent:  header.Event,
+		serverConnID: header.ConnectionID,
+		serverRoom:   header.Room,
+		serverUserID: header.UserID,
+	}
+	if header.Code != 0 {
+		vars[serverCode] = strconv.Itoa(header.Code)
+	}
+	return phpengine.InternalRequest{
+		Entry:      script,
+		DocRoot:    docRoot,
+		Method:     http.MethodPost,
+		Headers:    map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+		Body:       []byte(url.Values{"data": {string(data)}}.Encode()),
+		ServerVars: vars,
+		Caller:     metrics.CallerWebSocketEvent,
+	}
+}
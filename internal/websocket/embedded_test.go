@@ -0,0 +1,56 @@
+package websocket_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+func TestEmbeddedForwarder(t *testing.T) {
+	pool := &authPool{
+		requests: make(chan *phpengine.Context, 10),
+		exec: func(ctx *phpengine.Context) *phpengine.Response {
+			if ctx.Server["MABOO_WS_EVENT"] != protocol.EventMessage {
+				return &phpengine.Response{Status: 200}
+			}
+			body, err := protocol.MarshalMsgpack([]protocol.StreamCommand{{
+				Event:        protocol.EventMessage,
+				ConnectionID: ctx.Server["MABOO_WS_CONN_ID"],
+				Data:         []byte("echo: " + ctx.Post["data"]),
+			}})
+			if err != nil {
+				t.Error(err)
+			}
+			return &phpengine.Response{Status: 200, Body: body}
+		},
+	}
+	m, url := serve(t, config.Default().WebSocket, nil)
+	m.SetPHPForwarder(websocket.EmbeddedForwarder(pool, "/app", "ws.php"))
+
+	conn := dial(t, url)
+	send(t, conn, "hi")
+	expectBroadcast(t, conn, "echo: hi")
+
+	var events []string
+	for len(events) < 2 {
+		select {
+		case ctx := <-pool.requests:
+			if ctx.ScriptFilename != "/app/ws.php" || ctx.Server["REQUEST_METHOD"] != "POST" {
+				t.Errorf("event ran %s %s, want POST /app/ws.php", ctx.Server["REQUEST_METHOD"], ctx.ScriptFilename)
+			}
+			if ctx.Server["MABOO_WS_CONN_ID"] == "" {
+				t.Error("event without MABOO_WS_CONN_ID")
+			}
+			events = append(events, ctx.Server["MABOO_WS_EVENT"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events = %v, want connect and message", events)
+		}
+	}
+	if events[0] != protocol.EventConnect || events[1] != protocol.EventMessage {
+		t.Errorf("events = %v, want connect and message", events)
+	}
+}
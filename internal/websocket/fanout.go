@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fanoutChunk is how many recipients one goroutine delivers a broadcast
+// to. Broadcasts to fewer than two chunks are delivered by the caller
+// alone, as starting goroutines would cost more than it saves.
+const fanoutChunk = 512
+
+// message is one outbound message. A broadcast prepares its frames once,
+// compressed or not, for all the recipients to share.
+type message struct {
+	data     []byte
+	prepared *websocket.PreparedMessage // nil for a message to one client
+}
+
+// SetBroadcastParallelism sets how many goroutines deliver a broadcast to
+// many clients, counting the one broadcasting: 1 delivers sequentially and
+// 0 or less uses one per CPU. The extra goroutines are shared by every
+// broadcast; one that finds none free delivers its share itself.
+func (m *Manager) SetBroadcastParallelism(n int) {
+	m.mu.Lock()
+	m.fanout = fanoutSlots(n)
+	m.mu.Unlock()
+}
+
+func fanoutSlots(parallelism int) chan struct{} {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	return make(chan struct{}, parallelism-1)
+}
+
+// broadcast delivers data to each client, accounting it as a broadcast.
+// Large broadcasts are split among the fan-out goroutines; it returns once
+// all are queued, so the broadcasts of one caller reach every client in
+// the order they were made.
+func (m *Manager) broadcast(clients []*Client, data []byte, room string) Delivery {
+	start, recipients := time.Now(), len(clients)
+	messagesTotal.WithLabelValues(dirBroadcast).Inc()
+	msg := message{data: data}
+	if len(clients) > 1 {
+		// Only fails for an invalid message type.
+		msg.prepared, _ = websocket.NewPreparedMessage(websocket.TextMessage, data)
+	}
+
+	m.mu.RLock()
+	slots := m.fanout
+	m.mu.RUnlock()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		delivered int
+	)
+	deliver := func(clients []*Client) {
+		n := m.deliver(clients, msg, room)
+		mu.Lock()
+		delivered += n
+		mu.Unlock()
+	}
+	for len(clients) >= 2*fanoutChunk {
+		chunk := clients[:fanoutChunk]
+		clients = clients[fanoutChunk:]
+		select {
+		case slots <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer func() { <-slots; wg.Done() }()
+				deliver(chunk)
+			}()
+		default:
+			deliver(chunk)
+		}
+	}
+	deliver(clients)
+	wg.Wait()
+
+	broadcastDuration.Observe(time.Since(start).Seconds())
+	return Delivery{Recipients: recipients, Delivered: delivered}
+}
+
+// deliver queues msg for each client and returns for how many it was.
+func (m *Manager) deliver(clients []*Client, msg message, room string) int {
+	delivered := 0
+	for _, c := range clients {
+		if err := c.send(msg); err != nil {
+			sendFailuresTotal.WithLabelValues(dirBroadcast).Inc()
+			m.logger.Debug("broadcast send failed", "conn_id", c.ID, "room", room, "error", err)
+			continue
+		}
+		delivered++
+	}
+	messagesTotal.WithLabelValues(dirOut).Add(float64(delivered))
+	return delivered
+}
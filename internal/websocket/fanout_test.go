@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"testing"
+)
+
+// fakeRoom fills room with n clients that have no connection, only a
+// send queue of size queue.
+func fakeRoom(m *Manager, room string, n, queue int) []*Client {
+	clients := make([]*Client, n)
+	for i := range clients {
+		c := &Client{
+			ID:      strconv.Itoa(i),
+			Rooms:   make(map[string]bool),
+			queue:   make(chan message, queue),
+			closing: make(chan struct{}),
+		}
+		m.clients[c.ID] = c
+		m.JoinRoom(c.ID, room)
+		clients[i] = c
+	}
+	return clients
+}
+
+func TestBroadcastFanoutKeepsOrder(t *testing.T) {
+	const members, messages = 5 * fanoutChunk, 20
+	m := NewManager(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.SetBroadcastParallelism(4)
+	clients := fakeRoom(m, "big", members, messages)
+
+	for i := range messages {
+		d := m.BroadcastToRoom("big", []byte(strconv.Itoa(i)), "0")
+		if d.Recipients != members-1 || d.Delivered != members-1 {
+			t.Fatalf("broadcast %d: %+v, want %d recipients, all delivered", i, d, members-1)
+		}
+	}
+	if n := len(clients[0].queue); n != 0 {
+		t.Errorf("excluded client got %d messages", n)
+	}
+	for _, c := range clients[1:] {
+		for i := range messages {
+			msg := <-c.queue
+			if string(msg.data) != strconv.Itoa(i) {
+				t.Fatalf("client %s got %q as message %d", c.ID, msg.data, i)
+			}
+			if msg.prepared == nil {
+				t.Fatalf("client %s got a broadcast that was not prepared", c.ID)
+			}
+		}
+	}
+}
+
+func BenchmarkBroadcastRoom(b *testing.B) {
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			m := NewManager(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			m.SetBroadcastParallelism(parallelism)
+			const queue = 256
+			clients := fakeRoom(m, "big", 10000, queue)
+			msg := []byte(`{"event":"chat","data":"hello, everyone"}`)
+			b.ResetTimer()
+			for i := range b.N {
+				if i > 0 && i%queue == 0 {
+					b.StopTimer()
+					for _, c := range clients {
+						for len(c.queue) > 0 {
+							<-c.queue
+						}
+					}
+					b.StartTimer()
+				}
+				m.BroadcastToRoom("big", msg, "")
+			}
+		})
+	}
+}
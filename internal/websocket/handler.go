@@ -1,64 +1,355 @@
 package websocket
 
 import (
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/clientip"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/origin"
+	"github.com/sadewadee/maboo/internal/protocol"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // TODO: configurable origin check
-	},
-}
+// retryAfter is the Retry-After, in seconds, sent with refused upgrades.
+const retryAfter = "5"
 
 // Handler handles WebSocket upgrade requests and manages connections.
 type Handler struct {
-	manager *Manager
-	logger  *slog.Logger
+	upgrader websocket.Upgrader
+	manager  *Manager
+	logger   *slog.Logger
+	clientIP *clientip.Resolver
+	origins  *origin.Checker
+	headers  []string // request headers forwarded to PHP on connect
+
+	subprotocols map[string]bool // websocket.subprotocols; nil = none negotiated
+
+	authEndpoint string // script asked before upgrading; "" disables
+	authTimeout  time.Duration
+	authPool     ScriptPool
+	authRoot     string
+
+	pingInterval time.Duration // 0 disables keepalive pings
+	pongTimeout  time.Duration
+
+	compressionLevel int // 0 when compression is disabled
+
+	maxMessageSize int64   // 0 = unlimited
+	messageRate    float64 // inbound messages per second; 0 = unlimited
+	messageBurst   int
+	rateClose      bool // close instead of dropping messages over the rate
 }
 
-// NewHandler creates a new WebSocket handler.
-func NewHandler(manager *Manager, logger *slog.Logger) *Handler {
-	return &Handler{
-		manager: manager,
-		logger:  logger,
+// NewHandler creates a new WebSocket handler and applies the configured
+// connection and room limits, private rooms, backpressure, broadcast
+// parallelism, publish rate, shutdown and resume policies to manager, and
+// starts its ticker if websocket.tick_interval is set.
+func NewHandler(manager *Manager, cfg config.WebSocketConfig, logger *slog.Logger) *Handler {
+	manager.SetLimits(Limits{
+		MaxConnections:      cfg.MaxConnections,
+		MaxConnectionsPerIP: cfg.MaxConnectionsPerIP,
+	})
+	manager.SetPublishRate(cfg.PublishRate, cfg.PublishBurst)
+	manager.SetCompressionThreshold(int(cfg.Compression.Threshold.Bytes()))
+	manager.SetBackpressure(Backpressure{
+		QueueSize:    cfg.SendQueue,
+		WriteTimeout: cfg.WriteTimeout.Duration(),
+		Drop:         cfg.SlowClient == slowDrop,
+	})
+	manager.SetBroadcastParallelism(cfg.BroadcastParallelism)
+	manager.SetMaxRoomSize(cfg.MaxRoomSize)
+	manager.SetPrivateRooms(PrivateRooms{
+		Prefixes: cfg.PrivateRooms.Prefixes,
+		CacheTTL: cfg.PrivateRooms.CacheTTL.Duration(),
+	})
+	shutdown := ShutdownPolicy{Drain: cfg.DrainTimeout.Duration(), Spread: cfg.CloseSpread.Duration()}
+	if cfg.ShutdownMessage != "" {
+		shutdown.Message = []byte(cfg.ShutdownMessage)
+	}
+	manager.SetShutdownPolicy(shutdown)
+	if tick := cfg.TickInterval.Duration(); tick > 0 {
+		manager.StartTicker(tick)
 	}
+	if r := cfg.Resume; r.Enabled {
+		manager.SetResumePolicy(ResumePolicy{
+			Buffer:      r.Buffer,
+			BufferBytes: int(r.BufferSize.Bytes()),
+			TTL:         r.TTL.Duration(),
+			MaxSessions: r.MaxSessions,
+		})
+	}
+
+	origins, err := origin.Compile(cfg.AllowedOrigins)
+	if err != nil {
+		// Config validation rejects this; fail closed if it slipped through.
+		logger.Error("invalid websocket.allowed_origins, allowing the same origin only", "error", err)
+		origins, _ = origin.Compile(nil)
+	}
+	if origins.AllowsAny() {
+		logger.Warn("websocket.allowed_origins contains \"*\": any website can open WebSocket connections with its visitors' cookies")
+	}
+
+	h := &Handler{
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			EnableCompression: cfg.Compression.Enabled,
+			CheckOrigin: func(r *http.Request) bool {
+				return true // ServeHTTP checks websocket.allowed_origins before upgrading
+			},
+		},
+		manager:      manager,
+		logger:       logger,
+		origins:      origins,
+		headers:      cfg.ForwardHeaders,
+		authEndpoint: cfg.AuthEndpoint,
+		authTimeout:  cfg.AuthTimeout.Duration(),
+		pingInterval: cfg.PingInterval.Duration(),
+		pongTimeout:  cfg.PongTimeout.Duration(),
+
+		maxMessageSize: cfg.MaxMessageSize.Bytes(),
+		messageRate:    cfg.MessageRate,
+		messageBurst:   cfg.MessageBurst,
+		rateClose:      cfg.RateExceeded == "close",
+	}
+	if cfg.Compression.Enabled {
+		h.compressionLevel = cfg.Compression.Level
+	}
+	if len(cfg.Subprotocols) > 0 {
+		h.subprotocols = make(map[string]bool, len(cfg.Subprotocols))
+		for _, sp := range cfg.Subprotocols {
+			h.upgrader.Subprotocols = append(h.upgrader.Subprotocols, sp.Name)
+			h.subprotocols[sp.Name] = true
+		}
+	}
+	return h
+}
+
+// SetClientIP sets how client addresses and schemes are resolved for the
+// per-IP limit and the same-origin check. Without it, the peer address and
+// the connection's own scheme are used.
+func (h *Handler) SetClientIP(r *clientip.Resolver) {
+	h.clientIP = r
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Refuse before upgrading, so a refused client costs no goroutine
+	ip := h.clientIP.IP(r)
+	if !h.origins.Allow(r, h.clientIP.Proto(r)) {
+		rejectedTotal.WithLabelValues(rejectOrigin).Inc()
+		h.logger.Debug("websocket origin not allowed", "client_ip", ip, "origin", r.Header.Get("Origin"))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if !h.supportsSubprotocol(r) {
+		rejectedTotal.WithLabelValues(rejectSubprotocol).Inc()
+		h.logger.Debug("websocket subprotocol not supported", "client_ip", ip, "subprotocols", r.Header.Values("Sec-WebSocket-Protocol"))
+		http.Error(w, "unsupported subprotocol", http.StatusBadRequest)
+		return
+	}
+	if err := h.manager.admit(ip); err != nil {
+		h.refuse(w, ip, err)
+		return
+	}
+	auth, ok := h.authenticate(w, r, ip)
+	if !ok {
+		h.manager.release(ip)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		h.manager.release(ip)
 		h.logger.Error("websocket upgrade failed", "error", err)
 		return
 	}
+	if h.compressionLevel != 0 {
+		// Only takes effect if the client negotiated permessage-deflate
+		conn.SetCompressionLevel(h.compressionLevel)
+	}
 
-	client := h.manager.AddConnection(conn, r)
+	info := h.connectInfo(r, ip, conn)
+	info.Auth = string(auth)
+	client := h.manager.AddConnection(conn, r, info)
+	if client == nil {
+		return // rejected by PHP
+	}
 	h.logger.Debug("websocket connected", "conn_id", client.ID)
 
-	// Read loop
-	go h.readPump(client)
+	// Read loop, plus keepalive pings that let it notice dead clients
+	done := make(chan struct{})
+	go h.readPump(client, done)
+	if h.pingInterval > 0 {
+		go h.keepalive(client, done)
+	}
+}
+
+// connectInfo describes a new connection to PHP.
+func (h *Handler) connectInfo(r *http.Request, ip string, conn *websocket.Conn) *protocol.ConnectInfo {
+	headers := make(map[string]string, len(h.headers))
+	for _, name := range h.headers {
+		name = http.CanonicalHeaderKey(name)
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		sep := ", "
+		if name == "Cookie" {
+			sep = "; " // HTTP/2 may split cookies across headers
+		}
+		headers[name] = strings.Join(values, sep)
+	}
+	return &protocol.ConnectInfo{
+		RemoteAddr:  ip,
+		Path:        r.URL.Path,
+		Query:       r.URL.RawQuery,
+		Headers:     headers,
+		Subprotocol: conn.Subprotocol(),
+	}
 }
 
-func (h *Handler) readPump(client *Client) {
+// supportsSubprotocol reports whether r offers no subprotocol or one of
+// websocket.subprotocols, which the upgrader then selects. With none
+// configured, offers are ignored and the upgrade selects none.
+func (h *Handler) supportsSubprotocol(r *http.Request) bool {
+	offered := websocket.Subprotocols(r)
+	if h.subprotocols == nil || len(offered) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(offered, func(name string) bool { return h.subprotocols[name] })
+}
+
+// refuse answers an upgrade that would exceed a connection limit: 503 for
+// the global cap, 429 for the per-IP one.
+func (h *Handler) refuse(w http.ResponseWriter, ip string, err error) {
+	reason, status := rejectMaxConnections, http.StatusServiceUnavailable
+	if errors.Is(err, errMaxConnectionsPerIP) {
+		reason, status = rejectMaxConnectionsPerIP, http.StatusTooManyRequests
+	}
+	rejectedTotal.WithLabelValues(reason).Inc()
+	h.logger.Debug("websocket connection refused", "client_ip", ip, "reason", reason)
+
+	w.Header().Set("Retry-After", retryAfter)
+	http.Error(w, err.Error(), status)
+}
+
+// readPump reads messages until the connection fails or closes. With
+// keepalive enabled, the read deadline lies one ping interval plus the
+// pong timeout ahead and moves on with every pong or message, so a client
+// that stops answering is reaped instead of lingering forever.
+//
+// Messages over websocket.max_message_size end the connection with 1009.
+// Messages over websocket.message_rate are dropped, or end it with 1008.
+// PHP's close event carries the code and reason either way.
+func (h *Handler) readPump(client *Client, done chan struct{}) {
+	code, reason := websocket.CloseAbnormalClosure, ""
 	defer func() {
-		h.manager.RemoveConnection(client.ID)
+		close(done)
+		// A close the server started, e.g. for PHP or the rate limit, explains
+		// the end better than the read error it caused.
+		if c, r := client.closeStatus(); c != 0 {
+			code, reason = c, r
+		}
+		h.manager.RemoveConnection(client.ID, code, reason)
 		client.Conn.Close()
-		h.logger.Debug("websocket disconnected", "conn_id", client.ID)
+		h.logger.Debug("websocket disconnected", "conn_id", client.ID, "code", code)
 	}()
 
+	if h.maxMessageSize > 0 {
+		client.Conn.SetReadLimit(h.maxMessageSize)
+	}
+	var limiter *bucket
+	if h.messageRate > 0 {
+		limiter = newBucket(h.messageRate, h.messageBurst)
+	}
+
+	if h.pingInterval > 0 {
+		client.Conn.SetPongHandler(func(string) error {
+			return h.extendDeadline(client)
+		})
+		h.extendDeadline(client)
+	}
+
 	for {
 		_, message, err := client.Conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				h.logger.Warn("websocket read error", "conn_id", client.ID, "error", err)
+			var ne net.Error
+			var ce *websocket.CloseError
+			switch {
+			case errors.As(err, &ce):
+				code, reason = ce.Code, ce.Text
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+					h.logger.Warn("websocket read error", "conn_id", client.ID, "error", err)
+				}
+			case errors.Is(err, websocket.ErrReadLimit):
+				// gorilla has already sent the client a 1009 close frame
+				code, reason = websocket.CloseMessageTooBig, "message too big"
+				limitedTotal.WithLabelValues(limitMessageSize).Inc()
+				h.logger.Debug("websocket message too big, closing", "conn_id", client.ID, "limit", h.maxMessageSize)
+			case errors.As(err, &ne) && ne.Timeout():
+				reason = "pong timeout"
+				reapedTotal.WithLabelValues(reapPongTimeout).Inc()
+				h.logger.Debug("websocket client missed pong, closing", "conn_id", client.ID, "timeout", h.pongTimeout)
+			}
+			break
+		}
+
+		if limiter != nil && !limiter.allow(time.Now()) {
+			if !h.rateClose {
+				limitedTotal.WithLabelValues(limitRateDrop).Inc()
+				continue
 			}
+			limitedTotal.WithLabelValues(limitRateClose).Inc()
+			h.logger.Debug("websocket message rate exceeded, closing", "conn_id", client.ID, "rate", h.messageRate)
+			client.close(websocket.ClosePolicyViolation, "message rate exceeded")
+			<-client.done // let the writer send the close frame before the conn is closed
 			break
 		}
 
 		h.manager.HandleMessage(client, message)
+		// Pongs that arrived while PHP handled the message are only read
+		// now, so the deadline moves after handling, not before.
+		if h.pingInterval > 0 {
+			h.extendDeadline(client)
+		}
+	}
+}
+
+func (h *Handler) extendDeadline(client *Client) error {
+	return client.Conn.SetReadDeadline(time.Now().Add(h.pingInterval + h.pongTimeout))
+}
+
+// keepalive pings the client every ping interval until readPump exits. A
+// ping that cannot be written within the pong timeout closes the
+// connection, which ends readPump.
+func (h *Handler) keepalive(client *Client, done <-chan struct{}) {
+	ticker := time.NewTicker(h.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := client.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(h.pongTimeout))
+			if err == nil {
+				continue
+			}
+			select {
+			case <-done:
+				// readPump closed the connection first
+			default:
+				reapedTotal.WithLabelValues(reapPingFailed).Inc()
+				h.logger.Debug("websocket ping failed, closing", "conn_id", client.ID, "error", err)
+				client.Conn.Close()
+			}
+			return
+		case <-done:
+			return
+		}
 	}
 }
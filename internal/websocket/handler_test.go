@@ -0,0 +1,435 @@
+package websocket_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/clientip"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+// startServer serves a Handler with the given keepalive settings and
+// returns its manager and ws:// URL.
+func startServer(t *testing.T, ping, pong time.Duration) (*websocket.Manager, string) {
+	t.Helper()
+	cfg := config.Default().WebSocket
+	cfg.PingInterval = config.Duration(ping)
+	cfg.PongTimeout = config.Duration(pong)
+	return serve(t, cfg, nil)
+}
+
+// serve serves a Handler built from cfg, after passing it to configure
+// when that is set.
+func serve(t *testing.T, cfg config.WebSocketConfig, configure func(*websocket.Handler)) (*websocket.Manager, string) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := websocket.NewManager(logger)
+	h := websocket.NewHandler(m, cfg, logger)
+	if configure != nil {
+		configure(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return m, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, url string) *gorilla.Conn {
+	t.Helper()
+	return dialHeader(t, url, nil)
+}
+
+func dialHeader(t *testing.T, url string, header http.Header) *gorilla.Conn {
+	t.Helper()
+	conn, _, err := gorilla.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// dialRefused expects the upgrade to be refused with status and a
+// Retry-After header.
+func dialRefused(t *testing.T, url string, header http.Header, status int) {
+	t.Helper()
+	conn, resp, err := gorilla.DefaultDialer.Dial(url, header)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("connection accepted, want %d", status)
+	}
+	if resp == nil || resp.StatusCode != status {
+		t.Fatalf("dial error %v, response %v; want status %d", err, resp, status)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("refusal without Retry-After")
+	}
+}
+
+func forwardedFor(ip string) http.Header {
+	return http.Header{"X-Forwarded-For": {ip}}
+}
+
+// expectBroadcast checks that conn still receives messages.
+func expectBroadcast(t *testing.T, conn *gorilla.Conn, want string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil || string(msg) != want {
+		t.Errorf("read %q, %v; want %q", msg, err, want)
+	}
+}
+
+// waitConnections waits until the manager holds want connections.
+func waitConnections(t *testing.T, m *websocket.Manager, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Stats().TotalConnections != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("connections = %d, want %d", m.Stats().TotalConnections, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func reaped(t *testing.T, reason string) float64 {
+	t.Helper()
+	return counter(t, "maboo_websocket_reaped_total", reason)
+}
+
+// counter reads the value of a counter with one label, reason or result,
+// or of one without labels if value is empty.
+func counter(t *testing.T, name, value string) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.Metric {
+			if value == "" && len(m.Label) == 0 {
+				return m.GetCounter().GetValue()
+			}
+			for _, l := range m.Label {
+				if l.GetValue() == value {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestKeepaliveReapsDeadClient(t *testing.T) {
+	m, url := startServer(t, 50*time.Millisecond, 100*time.Millisecond)
+	before := reaped(t, "pong_timeout")
+
+	// A client that never reads never answers the server's pings, like a
+	// laptop that went to sleep.
+	dial(t, url)
+	waitConnections(t, m, 1)
+	waitConnections(t, m, 0)
+
+	if got := reaped(t, "pong_timeout") - before; got != 1 {
+		t.Errorf("reaped pong_timeout = %v, want 1", got)
+	}
+}
+
+func TestKeepaliveKeepsLiveClient(t *testing.T) {
+	m, url := startServer(t, 50*time.Millisecond, 100*time.Millisecond)
+
+	// Reading lets the client's default ping handler answer with pongs.
+	conn := dial(t, url)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	waitConnections(t, m, 1)
+
+	time.Sleep(600 * time.Millisecond)
+	if n := m.Stats().TotalConnections; n != 1 {
+		t.Errorf("connections after several ping intervals = %d, want 1", n)
+	}
+}
+
+func TestKeepaliveDisabled(t *testing.T) {
+	m, url := startServer(t, 0, 0)
+
+	dial(t, url)
+	waitConnections(t, m, 1)
+	time.Sleep(200 * time.Millisecond)
+	if n := m.Stats().TotalConnections; n != 1 {
+		t.Errorf("connections without keepalive = %d, want 1", n)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.MaxConnections = 3
+	m, url := serve(t, cfg, nil)
+	before := counter(t, "maboo_websocket_rejected_total", "max_connections")
+
+	var conns []*gorilla.Conn
+	for range 3 {
+		conns = append(conns, dial(t, url))
+	}
+	waitConnections(t, m, 3)
+	dialRefused(t, url, nil, http.StatusServiceUnavailable)
+
+	if got := counter(t, "maboo_websocket_rejected_total", "max_connections") - before; got != 1 {
+		t.Errorf("rejected max_connections = %v, want 1", got)
+	}
+	m.Broadcast([]byte("still here"), "")
+	for _, c := range conns {
+		expectBroadcast(t, c, "still here")
+	}
+
+	// A closed connection frees its slot.
+	conns[0].Close()
+	waitConnections(t, m, 2)
+	dial(t, url)
+	waitConnections(t, m, 3)
+
+	// Raising the limit at runtime admits more.
+	m.SetLimits(websocket.Limits{MaxConnections: 4})
+	dial(t, url)
+	waitConnections(t, m, 4)
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.MaxConnectionsPerIP = 2
+	m, url := serve(t, cfg, func(h *websocket.Handler) {
+		r, err := clientip.New([]string{"127.0.0.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.SetClientIP(r)
+	})
+	before := counter(t, "maboo_websocket_rejected_total", "max_connections_per_ip")
+
+	a1 := dialHeader(t, url, forwardedFor("198.51.100.1"))
+	a2 := dialHeader(t, url, forwardedFor("198.51.100.1"))
+	dialRefused(t, url, forwardedFor("198.51.100.1"), http.StatusTooManyRequests)
+
+	// Another client behind the same proxy has its own allowance.
+	dialHeader(t, url, forwardedFor("198.51.100.2"))
+	waitConnections(t, m, 3)
+
+	if got := counter(t, "maboo_websocket_rejected_total", "max_connections_per_ip") - before; got != 1 {
+		t.Errorf("rejected max_connections_per_ip = %v, want 1", got)
+	}
+	m.Broadcast([]byte("hello"), "")
+	expectBroadcast(t, a1, "hello")
+	expectBroadcast(t, a2, "hello")
+}
+
+func TestOriginCheck(t *testing.T) {
+	m, url := serve(t, config.Default().WebSocket, nil)
+	host := strings.TrimPrefix(url, "ws://")
+	before := counter(t, "maboo_websocket_rejected_total", "origin")
+
+	// Without an Origin header (non-browser clients) and from the page's
+	// own origin the upgrade succeeds.
+	dial(t, url)
+	dialHeader(t, url, http.Header{"Origin": {"http://" + host}})
+	waitConnections(t, m, 2)
+
+	for _, o := range []string{"http://evil.test", "https://" + host} {
+		conn, resp, err := gorilla.DefaultDialer.Dial(url, http.Header{"Origin": {o}})
+		if err == nil {
+			conn.Close()
+			t.Errorf("origin %s: connection accepted, want 403", o)
+			continue
+		}
+		if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("origin %s: dial error %v, response %v; want 403", o, err, resp)
+		}
+	}
+	if got := counter(t, "maboo_websocket_rejected_total", "origin") - before; got != 2 {
+		t.Errorf("rejected origin = %v, want 2", got)
+	}
+	if n := m.Stats().TotalConnections; n != 2 {
+		t.Errorf("connections = %d, want 2", n)
+	}
+}
+
+func TestSubprotocols(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.Subprotocols = []config.WebSocketSubprotocolConfig{{Name: "graphql-ws", Worker: "graphql.php"}, {Name: "mqtt"}}
+	subprotocols := make(chan string, 3)
+	decode := func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		if h.Event == protocol.EventConnect {
+			var info protocol.ConnectInfo
+			protocol.UnmarshalMsgpack(data, &info)
+			subprotocols <- info.Subprotocol
+		}
+		return nil
+	}
+	php, graphql := &fakePHP{reply: decode}, &fakePHP{reply: decode}
+	m, url := serve(t, cfg, nil)
+	m.SetPHPForwarder(php.forward)
+	m.SetProtocolForwarder("graphql-ws", graphql.forward)
+
+	tests := []struct {
+		offer []string
+		want  string
+		php   *fakePHP // worker told about the connection
+	}{
+		{nil, "", php},
+		{[]string{"graphql-transport-ws", "graphql-ws"}, "graphql-ws", graphql},
+		{[]string{"mqtt"}, "mqtt", php},
+	}
+	for _, tt := range tests {
+		before := len(tt.php.seen(protocol.EventConnect))
+		d := gorilla.Dialer{Subprotocols: tt.offer}
+		conn, _, err := d.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("offering %q: %v", tt.offer, err)
+		}
+		conn.Close()
+		if got := conn.Subprotocol(); got != tt.want {
+			t.Errorf("offering %q: negotiated %q, want %q", tt.offer, got, tt.want)
+		}
+		if got := <-subprotocols; got != tt.want {
+			t.Errorf("offering %q: connect event has subprotocol %q, want %q", tt.offer, got, tt.want)
+		}
+		if len(tt.php.seen(protocol.EventConnect)) != before+1 {
+			t.Errorf("offering %q: connect event went to the wrong worker", tt.offer)
+		}
+	}
+
+	before := counter(t, "maboo_websocket_rejected_total", "subprotocol")
+	d := gorilla.Dialer{Subprotocols: []string{"stomp"}}
+	if conn, resp, err := d.Dial(url, nil); err == nil {
+		conn.Close()
+		t.Error("unsupported subprotocol: connection accepted, want 400")
+	} else if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unsupported subprotocol: dial error %v, response %v; want 400", err, resp)
+	}
+	if got := counter(t, "maboo_websocket_rejected_total", "subprotocol") - before; got != 1 {
+		t.Errorf("rejected subprotocol = %v, want 1", got)
+	}
+
+	// Without websocket.subprotocols, offers are ignored as before.
+	_, url = serve(t, config.Default().WebSocket, nil)
+	conn, _, err := d.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("no subprotocols configured: %v", err)
+	}
+	conn.Close()
+	if conn.Subprotocol() != "" {
+		t.Errorf("no subprotocols configured: negotiated %q", conn.Subprotocol())
+	}
+}
+
+func TestOriginAnyWarns(t *testing.T) {
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	cfg := config.Default().WebSocket
+	cfg.AllowedOrigins = []string{"*"}
+
+	websocket.NewHandler(websocket.NewManager(logger), cfg, logger)
+	if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "allowed_origins") {
+		t.Errorf("no startup warning for \"*\", logs: %s", logs.String())
+	}
+}
+
+// serveLimited serves cfg with PHP answering nothing and returns a dialled
+// connection.
+func serveLimited(t *testing.T, cfg config.WebSocketConfig) (*fakePHP, *gorilla.Conn) {
+	t.Helper()
+	php := &fakePHP{}
+	m, url := serve(t, cfg, nil)
+	m.SetPHPForwarder(php.forward)
+	conn, _ := connect(t, php, url)
+	return php, conn
+}
+
+// expectClose reads until conn is closed with code, and checks PHP was
+// told the same code.
+func expectClose(t *testing.T, php *fakePHP, conn *gorilla.Conn, code int) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+	if !gorilla.IsCloseError(err, code) {
+		t.Errorf("read: %v, want close %d", err, code)
+	}
+	if closes := php.waitSeen(t, protocol.EventClose); closes[0].Code != code {
+		t.Errorf("PHP close event %+v, want code %d", closes[0], code)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.MaxMessageSize = config.KiB
+	php, conn := serveLimited(t, cfg)
+	before := counter(t, "maboo_websocket_inbound_limited_total", "message_size")
+
+	send(t, conn, strings.Repeat("x", 1024))
+	send(t, conn, strings.Repeat("x", 1025))
+	expectClose(t, php, conn, gorilla.CloseMessageTooBig)
+	if got := len(php.seen(protocol.EventMessage)); got != 1 {
+		t.Errorf("PHP got %d messages, want only the one within the limit", got)
+	}
+	if got := counter(t, "maboo_websocket_inbound_limited_total", "message_size") - before; got != 1 {
+		t.Errorf("limited{message_size} grew by %v, want 1", got)
+	}
+}
+
+func TestMessageRateDrop(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.MessageRate = 0.1
+	cfg.MessageBurst = 2
+	php, conn := serveLimited(t, cfg)
+	before := counter(t, "maboo_websocket_inbound_limited_total", "rate_drop")
+
+	for range 5 {
+		send(t, conn, "spam")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for counter(t, "maboo_websocket_inbound_limited_total", "rate_drop")-before < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("messages over the rate were not dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := len(php.seen(protocol.EventMessage)); got != 2 {
+		t.Errorf("PHP got %d messages, want the burst of 2", got)
+	}
+	if got := php.seen(protocol.EventClose); len(got) != 0 {
+		t.Errorf("connection closed by the drop policy: %+v", got)
+	}
+}
+
+func TestMessageRateClose(t *testing.T) {
+	cfg := config.Default().WebSocket
+	cfg.MessageRate = 0.1
+	cfg.MessageBurst = 1
+	cfg.RateExceeded = "close"
+	php, conn := serveLimited(t, cfg)
+
+	send(t, conn, "one")
+	send(t, conn, "two")
+	expectClose(t, php, conn, gorilla.ClosePolicyViolation)
+	if got := php.waitSeen(t, protocol.EventClose); len(got) != 1 || got[0].Code != gorilla.ClosePolicyViolation {
+		t.Errorf("close events %+v, want one with 1008", got)
+	}
+}
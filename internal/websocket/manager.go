@@ -1,243 +1,657 @@
 package websocket
 
 import (
+	"container/list"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"log/slog"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sadewadee/maboo/internal/protocol"
 )
 
-// Client represents a single WebSocket connection.
-type Client struct {
-	ID         string
-	Conn       *websocket.Conn
-	RemoteAddr string
-	Rooms      map[string]bool
-	mu         sync.Mutex
-}
-
-// Send sends a message to this WebSocket client.
-func (c *Client) Send(data []byte) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.Conn.WriteMessage(websocket.TextMessage, data)
-}
-
 // Manager manages all WebSocket connections, rooms, and message routing.
 type Manager struct {
 	clients    map[string]*Client
-	rooms      map[string]map[string]*Client
+	rooms      map[string]*room
+	users      map[string]map[string]*Client // user ID → its connections, guarded by mu
 	mu         sync.RWMutex
 	logger     *slog.Logger
-	onMessage  func(client *Client, message []byte) // handler for incoming messages
-	phpForward func(frame *protocol.Frame) (*protocol.Frame, error)
+	onMessage  func(client *Client, message []byte)                 // handler for incoming messages
+	phpForward func(frame *protocol.Frame) (*protocol.Frame, error) // guarded by mu
+	// Forwarders for the connections of one subprotocol, guarded by mu.
+	protocolForward map[string]func(frame *protocol.Frame) (*protocol.Frame, error)
+
+	// Connection accounting for the limits, guarded by mu. Upgrades in
+	// progress already count, so a burst cannot overshoot the caps.
+	limits   Limits
+	admitted int
+	perIP    map[string]int
+
+	bp          Backpressure   // for new clients, guarded by mu
+	compressMin int            // for new clients, guarded by mu
+	shutdown    ShutdownPolicy // guarded by mu
+	fanout      chan struct{}  // a token per extra broadcast goroutine running, guarded by mu
+	roomIndex   roomNode       // rooms by name segment, guarded by mu
+	maxRoomSize int            // for new rooms, guarded by mu
+	private     PrivateRooms   // guarded by mu
+	ticker      *ticker        // nil unless ticking, guarded by mu
+	saturated   func() bool    // whether the PHP workers have none free, guarded by mu
+
+	// Resumable sessions, guarded by mu: every one by token, and the
+	// dropped ones by connection ID and in the order they dropped.
+	resume       ResumePolicy
+	sessions     map[string]*session
+	dropped      map[string]*session
+	droppedOrder *list.List
+
+	publishMu    sync.Mutex
+	publishLimit *bucket // nil = unlimited
 }
 
+// Limits caps the open connections; 0 means unlimited.
+type Limits struct {
+	MaxConnections      int `json:"max_connections"`
+	MaxConnectionsPerIP int `json:"max_connections_per_ip"`
+}
+
+// Errors returned by admit when a connection would exceed the limits.
+var (
+	errMaxConnections      = errors.New("too many websocket connections")
+	errMaxConnectionsPerIP = errors.New("too many websocket connections from this address")
+)
+
 // NewManager creates a new WebSocket connection manager.
 func NewManager(logger *slog.Logger) *Manager {
 	return &Manager{
-		clients: make(map[string]*Client),
-		rooms:   make(map[string]map[string]*Client),
-		logger:  logger,
+		clients:  make(map[string]*Client),
+		rooms:    make(map[string]*room),
+		users:    make(map[string]map[string]*Client),
+		perIP:    make(map[string]int),
+		bp:       DefaultBackpressure,
+		shutdown: DefaultShutdownPolicy,
+		fanout:   fanoutSlots(0),
+		logger:   logger,
+
+		sessions:     make(map[string]*session),
+		dropped:      make(map[string]*session),
+		droppedOrder: list.New(),
+	}
+}
+
+// SetCompressionThreshold sets the size from which messages to clients
+// that negotiated permessage-deflate are compressed, for clients
+// connecting from now on.
+func (m *Manager) SetCompressionThreshold(n int) {
+	m.mu.Lock()
+	m.compressMin = n
+	m.mu.Unlock()
+}
+
+// SetBackpressure changes the outbound queue of clients connecting from
+// now on.
+func (m *Manager) SetBackpressure(bp Backpressure) {
+	m.mu.Lock()
+	m.bp = bp
+	m.mu.Unlock()
+}
+
+// SetLimits changes the connection limits. Lowering them refuses new
+// connections only; open ones are kept.
+func (m *Manager) SetLimits(l Limits) {
+	m.mu.Lock()
+	m.limits = l
+	m.mu.Unlock()
+}
+
+// Limits returns the current connection limits.
+func (m *Manager) Limits() Limits {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.limits
+}
+
+// admit reserves a connection for ip, or reports which limit it would
+// exceed. The reservation is released by RemoveConnection, or by release
+// when the upgrade fails.
+func (m *Manager) admit(ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if max := m.limits.MaxConnections; max > 0 && m.admitted >= max {
+		return errMaxConnections
+	}
+	if max := m.limits.MaxConnectionsPerIP; max > 0 && m.perIP[ip] >= max {
+		return errMaxConnectionsPerIP
+	}
+	m.admitted++
+	m.perIP[ip]++
+	return nil
+}
+
+func (m *Manager) release(ip string) {
+	m.mu.Lock()
+	m.releaseLocked(ip)
+	m.mu.Unlock()
+}
+
+func (m *Manager) releaseLocked(ip string) {
+	m.admitted--
+	if m.perIP[ip] <= 1 {
+		delete(m.perIP, ip)
+	} else {
+		m.perIP[ip]--
 	}
 }
 
 // SetPHPForwarder sets the function to forward WebSocket messages to PHP workers.
 func (m *Manager) SetPHPForwarder(fn func(frame *protocol.Frame) (*protocol.Frame, error)) {
+	m.mu.Lock()
 	m.phpForward = fn
+	m.mu.Unlock()
 }
 
-// AddConnection registers a new WebSocket connection.
-func (m *Manager) AddConnection(conn *websocket.Conn, r *http.Request) *Client {
-	id := generateConnID()
-	client := &Client{
-		ID:         id,
-		Conn:       conn,
-		RemoteAddr: r.RemoteAddr,
-		Rooms:      make(map[string]bool),
+// SetProtocolForwarder sends the events of connections that negotiated
+// subprotocol to fn instead of the forwarder SetPHPForwarder set. Ticks
+// belong to no connection and stay with that one.
+func (m *Manager) SetProtocolForwarder(subprotocol string, fn func(frame *protocol.Frame) (*protocol.Frame, error)) {
+	m.mu.Lock()
+	if m.protocolForward == nil {
+		m.protocolForward = make(map[string]func(frame *protocol.Frame) (*protocol.Frame, error))
+	}
+	m.protocolForward[subprotocol] = fn
+	m.mu.Unlock()
+}
+
+// AddConnection registers a new WebSocket connection from info.RemoteAddr,
+// which admit has already counted, and tells PHP about it. A connection
+// resuming a dropped session takes its place instead. It returns nil when
+// PHP rejected the connection, which is then already closed.
+func (m *Manager) AddConnection(conn *websocket.Conn, r *http.Request, info *protocol.ConnectInfo) *Client {
+	if client, resumed := m.resumeSession(conn, r, info); resumed {
+		return client
 	}
 
+	id := generateConnID()
 	m.mu.Lock()
+	client := newClient(id, conn, m.bp, m.compressMin)
+	client.RemoteAddr = r.RemoteAddr
+	client.IP = info.RemoteAddr
+	client.Auth = []byte(info.Auth)
+	client.Subprotocol = info.Subprotocol
+	if m.resume.TTL > 0 {
+		m.startSessionLocked(client)
+	}
 	m.clients[id] = client
+	connectionsGauge.Set(float64(len(m.clients)))
 	m.mu.Unlock()
 
-	// Notify PHP worker of new connection
-	if m.phpForward != nil {
-		header := &protocol.StreamHeader{
-			ConnectionID: id,
-			Event:        "connect",
-		}
-		frame, _ := protocol.EncodeStreamData(0, header, nil)
-		m.phpForward(frame)
+	payload, err := protocol.MarshalMsgpack(info)
+	if err != nil {
+		m.logger.Error("encoding connect info", "error", err)
 	}
+	m.notify(client, protocol.StreamHeader{Event: protocol.EventConnect}, payload, true)
 
+	m.mu.RLock()
+	_, accepted := m.clients[id]
+	m.mu.RUnlock()
+	if !accepted {
+		return nil
+	}
 	return client
 }
 
-// RemoveConnection unregisters a WebSocket connection and removes it from all rooms.
-func (m *Manager) RemoveConnection(id string) {
+// RemoveConnection unregisters a WebSocket connection, removes it from all
+// rooms and tells PHP it closed with code and reason. A resumable
+// connection that dropped keeps its session until it resumes or the
+// session ends.
+func (m *Manager) RemoveConnection(id string, code int, reason string) {
+	if code == websocket.CloseAbnormalClosure && m.drop(id, code, reason) {
+		return
+	}
+	client, exists := m.unregister(id)
+	if !exists {
+		return
+	}
+
+	m.notify(client, protocol.StreamHeader{Event: protocol.EventClose, Code: code}, []byte(reason), true)
+}
+
+// unregister removes a connection from the manager and its rooms,
+// releases its slot in the connection limits and stops its writer.
+func (m *Manager) unregister(id string) (*Client, bool) {
 	m.mu.Lock()
 	client, exists := m.clients[id]
 	if !exists {
 		m.mu.Unlock()
-		return
+		return nil, false
 	}
 
-	// Remove from all rooms
+	delete(m.clients, id)
+	m.leaveAllLocked(client)
+	m.releaseLocked(client.IP)
+	connectionsGauge.Set(float64(len(m.clients)))
+	s := client.session
+	if s != nil {
+		delete(m.sessions, s.token)
+		m.resumeGaugesLocked()
+	}
+	m.mu.Unlock()
+
+	if s != nil {
+		s.end()
+	}
+	client.stop(0, "")
+	close(client.gone)
+	connectionDuration.Observe(time.Since(client.ConnectedAt).Seconds())
+	return client, true
+}
+
+// leaveAllLocked takes client out of its rooms and its user's
+// connections. The caller holds mu.
+func (m *Manager) leaveAllLocked(client *Client) {
 	for room := range client.Rooms {
-		if members, ok := m.rooms[room]; ok {
-			delete(members, id)
-			if len(members) == 0 {
-				delete(m.rooms, room)
+		m.leaveRoomLocked(client, room)
+	}
+	m.removeUserLocked(client)
+}
+
+// CloseConnection closes a connection with a normal close frame once the
+// messages queued for it are written. Its read loop then unregisters it.
+func (m *Manager) CloseConnection(id string) {
+	m.closeConnection(id, websocket.CloseNormalClosure, "")
+}
+
+// closeConnection closes a connection with code after flushing its queue,
+// or ends its session if it dropped. Codes a client would not accept fall
+// back to policy violation.
+func (m *Manager) closeConnection(id string, code int, reason string) {
+	m.mu.RLock()
+	client, exists := m.clients[id]
+	dropped := m.dropped[id]
+	m.mu.RUnlock()
+	if exists {
+		client.close(code, reason)
+	} else if dropped != nil {
+		m.endDropped(dropped, endClosed)
+	}
+}
+
+// reject closes a connection PHP refused and unregisters it at once,
+// without telling PHP about the close.
+func (m *Manager) reject(id string, code int, reason string) {
+	m.mu.RLock()
+	client, exists := m.clients[id]
+	m.mu.RUnlock()
+	if !exists {
+		return
+	}
+	// Close first: unregistering stops the writer without a close frame.
+	client.close(code, reason)
+	if _, exists := m.unregister(id); !exists {
+		return
+	}
+	rejectedTotal.WithLabelValues(rejectPHP).Inc()
+	m.logger.Debug("websocket connection rejected by PHP", "conn_id", id, "client_ip", client.IP, "code", code)
+}
+
+// ShutdownPolicy controls how Shutdown closes the connections.
+type ShutdownPolicy struct {
+	Message []byte        // sent to every client before the close frames; nil = none
+	Drain   time.Duration // how long clients get to answer the close frame
+	Spread  time.Duration // the close frames are spread evenly over this long
+}
+
+// DefaultShutdownPolicy is used until SetShutdownPolicy is called.
+var DefaultShutdownPolicy = ShutdownPolicy{Drain: 10 * time.Second}
+
+// SetShutdownPolicy sets how Shutdown closes the connections.
+func (m *Manager) SetShutdownPolicy(p ShutdownPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shutdown = p
+}
+
+// Shutdown sends every client the policy's message, then a going-away
+// close frame once its queued messages are written. The close frames are
+// spread over the policy's Spread, so the clients do not all reconnect to
+// the next instance at once. Clients that have not answered when Drain or
+// ctx ends are disconnected; with no Drain, Shutdown only waits for the
+// close frames to be written. The read loops then unregister the
+// connections. The ticker stops first.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.StopTicker()
+
+	m.mu.RLock()
+	policy := m.shutdown
+	clients := make([]*Client, 0, len(m.clients))
+	for _, c := range m.clients {
+		clients = append(clients, c)
+	}
+	dropped := m.droppedLocked()
+	m.mu.RUnlock()
+
+	// Nothing outlives this process to resume.
+	for _, s := range dropped {
+		m.endDropped(s, endClosed)
+	}
+	if len(clients) == 0 {
+		return nil
+	}
+
+	if policy.Message != nil {
+		m.broadcast(clients, policy.Message, "")
+	}
+	if policy.Drain <= 0 {
+		// Send the close frames without waiting for answers.
+		for _, c := range clients {
+			c.close(websocket.CloseGoingAway, "server shutting down")
+		}
+		shutdownClosesTotal.WithLabelValues(shutdownForced).Add(float64(len(clients)))
+		for _, c := range clients {
+			select {
+			case <-c.done:
+			case <-ctx.Done():
+				return ctx.Err()
 			}
 		}
+		return nil
 	}
 
-	delete(m.clients, id)
-	m.mu.Unlock()
+	drain, cancel := context.WithTimeout(ctx, policy.Drain)
+	defer cancel()
+	var step time.Duration
+	if len(clients) > 1 {
+		step = policy.Spread / time.Duration(len(clients)-1)
+	}
+	for i, c := range clients {
+		if i > 0 && step > 0 {
+			t := time.NewTimer(step)
+			select {
+			case <-t.C:
+			case <-drain.Done():
+				t.Stop()
+			}
+		}
+		if drain.Err() != nil {
+			break
+		}
+		c.closeAwaiting(websocket.CloseGoingAway, "server shutting down")
+	}
 
-	// Notify PHP worker of disconnection
-	if m.phpForward != nil {
-		header := &protocol.StreamHeader{
-			ConnectionID: id,
-			Event:        "close",
+	// A client answering the close frame ends its read loop, which
+	// unregisters it.
+	for _, c := range clients {
+		select {
+		case <-c.gone:
+		case <-drain.Done():
 		}
-		frame, _ := protocol.EncodeStreamData(0, header, nil)
-		m.phpForward(frame)
 	}
+	forced := 0
+	for _, c := range clients {
+		select {
+		case <-c.gone:
+		default:
+			c.Conn.Close()
+			forced++
+		}
+	}
+	graceful := len(clients) - forced
+	shutdownClosesTotal.WithLabelValues(shutdownGraceful).Add(float64(graceful))
+	shutdownClosesTotal.WithLabelValues(shutdownForced).Add(float64(forced))
+	if forced > 0 {
+		m.logger.Info("websocket clients disconnected without answering the close frame", "forced", forced, "graceful", graceful)
+	}
+	return ctx.Err()
 }
 
 // HandleMessage processes an incoming WebSocket message.
 func (m *Manager) HandleMessage(client *Client, message []byte) {
-	if m.phpForward != nil {
-		header := &protocol.StreamHeader{
-			ConnectionID: client.ID,
-			Event:        "message",
+	messagesTotal.WithLabelValues(dirIn).Inc()
+	bytesTotal.WithLabelValues(dirIn).Add(float64(len(message)))
+
+	m.notify(client, protocol.StreamHeader{Event: protocol.EventMessage}, message, true)
+}
+
+// notify forwards an event about client, or about none if nil, to PHP
+// and carries out the commands PHP answers with. Join and leave are confirmed back to PHP
+// when confirm is set; the answer to a confirmation is carried out
+// without confirming again, so PHP cannot loop.
+func (m *Manager) notify(client *Client, header protocol.StreamHeader, data []byte, confirm bool) {
+	m.mu.RLock()
+	forward := m.phpForward
+	if client != nil && m.protocolForward[client.Subprotocol] != nil {
+		forward = m.protocolForward[client.Subprotocol]
+	}
+	m.mu.RUnlock()
+	if forward == nil {
+		return
+	}
+	if client != nil {
+		header.ConnectionID = client.ID
+	}
+	frame, err := protocol.EncodeStreamData(0, &header, data)
+	if err != nil {
+		m.logger.Error("encoding stream data", "error", err)
+		return
+	}
+
+	resp, err := forward(frame)
+	if err != nil {
+		m.logger.Error("forwarding to PHP", "event", header.Event, "error", err)
+		return
+	}
+	if resp == nil || resp.Type != protocol.TypeStreamData {
+		return
+	}
+	cmds, err := protocol.DecodeStreamCommands(resp)
+	if err != nil {
+		m.logger.Error("decoding PHP stream response", "error", err)
+		return
+	}
+	for _, cmd := range cmds {
+		m.apply(client, cmd, confirm)
+	}
+}
+
+// apply carries out one command from PHP about the event from sender,
+// which is nil for a tick. The command's connection, sender unless PHP
+// names another, is the one
+// joined, left, closed or excluded from a broadcast. A message or close
+// naming a user goes to every connection of that user.
+func (m *Manager) apply(sender *Client, cmd protocol.StreamCommand, confirm bool) {
+	target := cmd.ConnectionID
+	if target == "" && sender != nil {
+		target = sender.ID
+	}
+	exclude := ""
+	if cmd.ExcludeSender {
+		exclude = target
+	}
+
+	switch cmd.Event {
+	case protocol.EventMessage, "":
+		if cmd.Room != "" {
+			m.BroadcastToRoom(cmd.Room, cmd.Data, exclude)
+		} else if cmd.UserID != "" {
+			m.SendToUser(cmd.UserID, cmd.Data, exclude)
+		} else if cmd.ConnectionID != "" {
+			m.SendToClient(cmd.ConnectionID, cmd.Data)
 		}
-		frame, err := protocol.EncodeStreamData(0, header, message)
-		if err != nil {
-			m.logger.Error("encoding stream data", "error", err)
-			return
+	case protocol.EventBroadcastPattern:
+		if _, err := m.BroadcastToPattern(cmd.Room, cmd.Data, exclude); err != nil {
+			m.logger.Warn("PHP sent an invalid room pattern", "conn_id", target, "pattern", cmd.Room, "error", err)
 		}
-
-		resp, err := m.phpForward(frame)
-		if err != nil {
-			m.logger.Error("forwarding to PHP", "error", err)
+	case protocol.EventBroadcastExceptSender:
+		if cmd.Room != "" {
+			m.BroadcastToRoom(cmd.Room, cmd.Data, target)
+		} else {
+			m.Broadcast(cmd.Data, target)
+		}
+	case protocol.EventJoin, protocol.EventLeave:
+		if cmd.Room == "" {
+			m.logger.Warn("PHP sent a room command without a room", "event", cmd.Event, "conn_id", target)
 			return
 		}
-
-		// If PHP responds with a stream frame, forward it back to the client
-		if resp != nil && resp.Type == protocol.TypeStreamData {
-			streamHeader, data, err := protocol.DecodeStreamData(resp)
-			if err != nil {
-				m.logger.Error("decoding PHP stream response", "error", err)
-				return
+		var confirmation string
+		if cmd.Event == protocol.EventLeave {
+			if m.LeaveRoom(target, cmd.Room) {
+				confirmation = protocol.EventLeft
 			}
-
-			// Route response based on PHP's instruction
-			if streamHeader.Room != "" {
-				m.BroadcastToRoom(streamHeader.Room, data, "")
-			} else if streamHeader.ConnectionID != "" {
-				m.SendToClient(streamHeader.ConnectionID, data)
+		} else if m.authorizeJoin(target, cmd.Room) {
+			switch err := m.join(target, cmd.Room, cmd.MaxSize); err {
+			case nil:
+				confirmation = protocol.EventJoined
+			case errRoomFull:
+				confirmation = protocol.EventRoomFull
 			}
 		}
+		if confirmation != "" && confirm {
+			m.mu.RLock()
+			client := m.clients[target]
+			m.mu.RUnlock()
+			if client != nil {
+				m.notify(client, protocol.StreamHeader{Event: confirmation, Room: cmd.Room}, nil, false)
+			}
+		}
+	case protocol.EventAccept:
+		if cmd.UserID != "" {
+			m.mu.Lock()
+			if client, ok := m.clients[target]; ok {
+				m.setUserLocked(client, cmd.UserID)
+			}
+			m.mu.Unlock()
+		}
+	case protocol.EventReject:
+		code := cmd.Code
+		if code == 0 {
+			code = websocket.ClosePolicyViolation
+		}
+		m.reject(target, code, string(cmd.Data))
+	case protocol.EventClose:
+		code := cmd.Code
+		if code == 0 {
+			code = websocket.CloseNormalClosure
+		}
+		if cmd.UserID != "" {
+			m.DisconnectUser(cmd.UserID, code, string(cmd.Data))
+			return
+		}
+		m.closeConnection(target, code, string(cmd.Data))
+	case protocol.EventPresence:
+		if !confirm {
+			m.logger.Warn("PHP asked for presence while handling an answer; ignored", "conn_id", target, "room", cmd.Room)
+			return
+		}
+		payload, err := protocol.MarshalMsgpack(m.Presence(cmd.Room))
+		if err != nil {
+			m.logger.Error("encoding presence", "error", err)
+			return
+		}
+		m.mu.RLock()
+		client := m.clients[target]
+		m.mu.RUnlock()
+		if client != nil {
+			m.notify(client, protocol.StreamHeader{Event: protocol.EventPresence, Room: cmd.Room}, payload, false)
+		}
+	default:
+		m.logger.Warn("unknown stream command from PHP", "event", cmd.Event, "conn_id", target)
 	}
 }
 
-// JoinRoom adds a client to a room.
-func (m *Manager) JoinRoom(clientID, room string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	client, exists := m.clients[clientID]
-	if !exists {
-		return
-	}
-
-	if _, ok := m.rooms[room]; !ok {
-		m.rooms[room] = make(map[string]*Client)
-	}
-	m.rooms[room][clientID] = client
-	client.Rooms[room] = true
+// JoinRoom adds a client to a room and reports whether it did: the client
+// must exist and the room have space.
+func (m *Manager) JoinRoom(clientID, room string) bool {
+	return m.join(clientID, room, 0) == nil
 }
 
-// LeaveRoom removes a client from a room.
-func (m *Manager) LeaveRoom(clientID, room string) {
+// LeaveRoom removes a client from a room and reports whether the client
+// exists.
+func (m *Manager) LeaveRoom(clientID, room string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	client, exists := m.clients[clientID]
 	if !exists {
-		return
-	}
-
-	if members, ok := m.rooms[room]; ok {
-		delete(members, clientID)
-		if len(members) == 0 {
-			delete(m.rooms, room)
-		}
+		return false
 	}
-	delete(client.Rooms, room)
+	m.leaveRoomLocked(client, room)
+	return true
 }
 
-// BroadcastToRoom sends a message to all clients in a room.
-func (m *Manager) BroadcastToRoom(room string, data []byte, excludeID string) {
+// BroadcastToRoom sends a message to all clients in a room and reports
+// how many it was queued for.
+func (m *Manager) BroadcastToRoom(room string, data []byte, excludeID string) Delivery {
 	m.mu.RLock()
-	members, exists := m.rooms[room]
+	r, exists := m.rooms[room]
 	if !exists {
 		m.mu.RUnlock()
-		return
+		return Delivery{}
 	}
 	// Copy to avoid holding lock during sends
-	clients := make([]*Client, 0, len(members))
-	for _, c := range members {
+	clients := make([]*Client, 0, len(r.members))
+	for _, c := range r.members {
 		if c.ID != excludeID {
 			clients = append(clients, c)
 		}
 	}
 	m.mu.RUnlock()
 
-	for _, c := range clients {
-		if err := c.Send(data); err != nil {
-			m.logger.Warn("broadcast send failed", "conn_id", c.ID, "room", room, "error", err)
-		}
-	}
+	return m.broadcast(clients, data, room)
 }
 
 // SendToClient sends a message to a specific client.
 func (m *Manager) SendToClient(clientID string, data []byte) {
 	m.mu.RLock()
-	client, exists := m.clients[clientID]
+	client := m.clientLocked(clientID)
 	m.mu.RUnlock()
 
-	if !exists {
+	if client == nil {
 		return
 	}
 	if err := client.Send(data); err != nil {
-		m.logger.Warn("send to client failed", "conn_id", clientID, "error", err)
+		sendFailuresTotal.WithLabelValues(dirOut).Inc()
+		m.logger.Debug("send to client failed", "conn_id", clientID, "error", err)
+		return
+	}
+	messagesTotal.WithLabelValues(dirOut).Inc()
+}
+
+// clientLocked returns the connection with id, or the dropped one whose
+// session waits to be resumed, or nil. The caller holds mu.
+func (m *Manager) clientLocked(id string) *Client {
+	if c, ok := m.clients[id]; ok {
+		return c
+	}
+	if s, ok := m.dropped[id]; ok {
+		return s.ghost
 	}
+	return nil
 }
 
-// Broadcast sends a message to all connected clients.
-func (m *Manager) Broadcast(data []byte, excludeID string) {
+// Broadcast sends a message to all clients, including those whose dropped
+// session waits to be resumed, and reports how many it was queued for.
+func (m *Manager) Broadcast(data []byte, excludeID string) Delivery {
 	m.mu.RLock()
-	clients := make([]*Client, 0, len(m.clients))
+	clients := make([]*Client, 0, len(m.clients)+len(m.dropped))
 	for _, c := range m.clients {
 		if c.ID != excludeID {
 			clients = append(clients, c)
 		}
 	}
-	m.mu.RUnlock()
-
-	for _, c := range clients {
-		if err := c.Send(data); err != nil {
-			m.logger.Warn("broadcast send failed", "conn_id", c.ID, "error", err)
+	for id, s := range m.dropped {
+		if id != excludeID {
+			clients = append(clients, s.ghost)
 		}
 	}
+	m.mu.RUnlock()
+
+	return m.broadcast(clients, data, "")
 }
 
 // Stats returns current WebSocket statistics.
@@ -248,6 +662,8 @@ func (m *Manager) Stats() ManagerStats {
 	return ManagerStats{
 		TotalConnections: len(m.clients),
 		TotalRooms:       len(m.rooms),
+		TotalUsers:       len(m.users),
+		DroppedSessions:  len(m.dropped),
 	}
 }
 
@@ -255,6 +671,20 @@ func (m *Manager) Stats() ManagerStats {
 type ManagerStats struct {
 	TotalConnections int `json:"total_connections"`
 	TotalRooms       int `json:"total_rooms"`
+	TotalUsers       int `json:"total_users"`      // users with at least one connection
+	DroppedSessions  int `json:"dropped_sessions"` // sessions of dropped connections waiting to be resumed
+}
+
+// RoomStats returns the member count of every room, for the admin API.
+func (m *Manager) RoomStats() map[string]int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rooms := make(map[string]int, len(m.rooms))
+	for name, r := range m.rooms {
+		rooms[name] = len(r.members)
+	}
+	return rooms
 }
 
 func generateConnID() string {
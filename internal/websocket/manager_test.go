@@ -0,0 +1,381 @@
+package websocket_test
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+// fakePHP stands in for the PHP worker: it records the events it is sent
+// and answers them with reply.
+type fakePHP struct {
+	mu     sync.Mutex
+	events []protocol.StreamHeader
+	reply  func(h *protocol.StreamHeader, data []byte) *protocol.Frame
+}
+
+func (p *fakePHP) forward(f *protocol.Frame) (*protocol.Frame, error) {
+	h, data, err := protocol.DecodeStreamData(f)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.events = append(p.events, *h)
+	reply := p.reply
+	p.mu.Unlock()
+	if reply == nil {
+		return nil, nil
+	}
+	return reply(h, data), nil
+}
+
+func (p *fakePHP) setReply(reply func(h *protocol.StreamHeader, data []byte) *protocol.Frame) {
+	p.mu.Lock()
+	p.reply = reply
+	p.mu.Unlock()
+}
+
+// seen returns the events of one kind, in order.
+func (p *fakePHP) seen(event string) []protocol.StreamHeader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []protocol.StreamHeader
+	for _, h := range p.events {
+		if h.Event == event {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// waitSeen waits until PHP has been sent an event of one kind and returns
+// those events.
+func (p *fakePHP) waitSeen(t *testing.T, event string) []protocol.StreamHeader {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got := p.seen(event); len(got) > 0 {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("PHP was not sent a %s event", event)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func command(t *testing.T, cmd protocol.StreamCommand) *protocol.Frame {
+	t.Helper()
+	f, err := protocol.EncodeStreamData(0, &protocol.StreamHeader{
+		ConnectionID:  cmd.ConnectionID,
+		Event:         cmd.Event,
+		Room:          cmd.Room,
+		ExcludeSender: cmd.ExcludeSender,
+		UserID:        cmd.UserID,
+		Code:          cmd.Code,
+		MaxSize:       cmd.MaxSize,
+	}, cmd.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func batch(t *testing.T, cmds ...protocol.StreamCommand) *protocol.Frame {
+	t.Helper()
+	payload, err := protocol.MarshalMsgpack(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := protocol.EncodeStreamData(0, &protocol.StreamHeader{Event: protocol.EventBatch}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func servePHP(t *testing.T, php *fakePHP) (*websocket.Manager, string) {
+	t.Helper()
+	m, url := serve(t, config.Default().WebSocket, nil)
+	m.SetPHPForwarder(php.forward)
+	return m, url
+}
+
+// connect dials and returns the connection with the ID PHP was told.
+func connect(t *testing.T, php *fakePHP, url string) (*gorilla.Conn, string) {
+	t.Helper()
+	before := len(php.seen(protocol.EventConnect))
+	conn := dial(t, url)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if connects := php.seen(protocol.EventConnect); len(connects) > before {
+			return conn, connects[before].ConnectionID
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("PHP was not told about the connection")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitRoom(t *testing.T, m *websocket.Manager, room string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.RoomStats()[room] != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("room %s has %d members, want %d", room, m.RoomStats()[room], want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func send(t *testing.T, conn *gorilla.Conn, msg string) {
+	t.Helper()
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// onMessage answers each client message with reply(text).
+func onMessage(reply func(text string) *protocol.Frame) func(*protocol.StreamHeader, []byte) *protocol.Frame {
+	return func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		if h.Event != protocol.EventMessage {
+			return nil
+		}
+		return reply(string(data))
+	}
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	php := &fakePHP{}
+	m, url := servePHP(t, php)
+	php.setReply(onMessage(func(text string) *protocol.Frame {
+		return command(t, protocol.StreamCommand{Event: text, Room: "lobby"})
+	}))
+	conn, id := connect(t, php, url)
+
+	send(t, conn, protocol.EventJoin)
+	waitRoom(t, m, "lobby", 1)
+	if got := php.seen(protocol.EventJoined); len(got) != 1 || got[0].ConnectionID != id || got[0].Room != "lobby" {
+		t.Errorf("joined confirmations = %+v, want one for %s in lobby", got, id)
+	}
+
+	send(t, conn, protocol.EventLeave)
+	waitRoom(t, m, "lobby", 0)
+	if got := php.seen(protocol.EventLeft); len(got) != 1 || got[0].ConnectionID != id || got[0].Room != "lobby" {
+		t.Errorf("left confirmations = %+v, want one for %s in lobby", got, id)
+	}
+}
+
+func TestJoinOnConnect(t *testing.T) {
+	php := &fakePHP{reply: func(h *protocol.StreamHeader, _ []byte) *protocol.Frame {
+		if h.Event != protocol.EventConnect {
+			return nil
+		}
+		return command(t, protocol.StreamCommand{Event: protocol.EventJoin, Room: "everyone"})
+	}}
+	m, url := servePHP(t, php)
+
+	connect(t, php, url)
+	connect(t, php, url)
+	waitRoom(t, m, "everyone", 2)
+}
+
+func TestBroadcastExcludesSender(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cmd  protocol.StreamCommand
+	}{
+		{"broadcast_except_sender", protocol.StreamCommand{Event: protocol.EventBroadcastExceptSender, Room: "chat"}},
+		{"message with exclude_sender", protocol.StreamCommand{Event: protocol.EventMessage, Room: "chat", ExcludeSender: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			php := &fakePHP{reply: func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+				switch h.Event {
+				case protocol.EventConnect:
+					return command(t, protocol.StreamCommand{Event: protocol.EventJoin, Room: "chat"})
+				case protocol.EventMessage:
+					cmd := tt.cmd
+					cmd.Data = data
+					return command(t, cmd)
+				}
+				return nil
+			}}
+			m, url := servePHP(t, php)
+			a, aID := connect(t, php, url)
+			b, _ := connect(t, php, url)
+			c, _ := connect(t, php, url)
+			waitRoom(t, m, "chat", 3)
+
+			send(t, a, "hi")
+			expectBroadcast(t, b, "hi")
+			expectBroadcast(t, c, "hi")
+			// The sender's next message is the direct one, not its own echo.
+			m.SendToClient(aID, []byte("direct"))
+			expectBroadcast(t, a, "direct")
+		})
+	}
+}
+
+func TestBatchCommands(t *testing.T) {
+	php := &fakePHP{}
+	m, url := servePHP(t, php)
+	conn, id := connect(t, php, url)
+	php.setReply(onMessage(func(string) *protocol.Frame {
+		return batch(t,
+			protocol.StreamCommand{Event: protocol.EventJoin, Room: "news"},
+			protocol.StreamCommand{Event: protocol.EventMessage, Room: "news", Data: []byte("welcome")},
+			protocol.StreamCommand{Event: protocol.EventMessage, ConnectionID: id, Data: []byte("ack")},
+		)
+	}))
+
+	send(t, conn, "subscribe")
+	expectBroadcast(t, conn, "welcome")
+	expectBroadcast(t, conn, "ack")
+	if n := m.RoomStats()["news"]; n != 1 {
+		t.Errorf("news members = %d, want 1", n)
+	}
+}
+
+func TestConfirmationIsNotConfirmedAgain(t *testing.T) {
+	php := &fakePHP{}
+	m, url := servePHP(t, php)
+	conn, _ := connect(t, php, url)
+	php.setReply(func(h *protocol.StreamHeader, _ []byte) *protocol.Frame {
+		switch h.Event {
+		case protocol.EventMessage:
+			return command(t, protocol.StreamCommand{Event: protocol.EventJoin, Room: "a"})
+		case protocol.EventJoined:
+			// Answering a confirmation with more room commands must not
+			// trigger another confirmation.
+			return batch(t,
+				protocol.StreamCommand{Event: protocol.EventJoin, Room: "b"},
+				protocol.StreamCommand{Event: protocol.EventMessage, Room: h.Room, Data: []byte("joined " + h.Room)},
+			)
+		}
+		return nil
+	})
+
+	send(t, conn, "go")
+	expectBroadcast(t, conn, "joined a")
+	waitRoom(t, m, "b", 1)
+	if got := php.seen(protocol.EventJoined); len(got) != 1 {
+		t.Errorf("joined confirmations = %+v, want only the first", got)
+	}
+}
+
+func TestCloseCommand(t *testing.T) {
+	php := &fakePHP{}
+	m, url := servePHP(t, php)
+	conn, id := connect(t, php, url)
+	php.setReply(onMessage(func(string) *protocol.Frame {
+		return command(t, protocol.StreamCommand{Event: protocol.EventClose})
+	}))
+
+	send(t, conn, "bye")
+	waitConnections(t, m, 0)
+	if _, _, err := conn.ReadMessage(); !gorilla.IsCloseError(err, gorilla.CloseNormalClosure) {
+		t.Errorf("read after close command: %v, want a normal close", err)
+	}
+	closes := php.waitSeen(t, protocol.EventClose)
+	if !slices.ContainsFunc(closes, func(h protocol.StreamHeader) bool {
+		return h.ConnectionID == id && h.Code == gorilla.CloseNormalClosure
+	}) {
+		t.Errorf("PHP was not told about the normal close, events %+v", closes)
+	}
+}
+
+func TestConnectInfo(t *testing.T) {
+	infos := make(chan protocol.ConnectInfo, 1)
+	php := &fakePHP{reply: func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		if h.Event == protocol.EventConnect {
+			var info protocol.ConnectInfo
+			if err := protocol.UnmarshalMsgpack(data, &info); err != nil {
+				t.Errorf("decoding connect info: %v", err)
+			}
+			infos <- info
+		}
+		return nil
+	}}
+	_, url := servePHP(t, php)
+
+	dialHeader(t, url+"/chat/room?token=abc&x=1", http.Header{
+		"Cookie":        {"session=s3cret"},
+		"Authorization": {"Bearer t0ken"},
+		"User-Agent":    {"test-client"},
+		"X-Internal":    {"not forwarded"},
+	})
+	info := <-infos
+	if info.Path != "/chat/room" || info.Query != "token=abc&x=1" {
+		t.Errorf("path %q query %q, want /chat/room and token=abc&x=1", info.Path, info.Query)
+	}
+	if info.RemoteAddr != "127.0.0.1" {
+		t.Errorf("remote addr = %q, want 127.0.0.1", info.RemoteAddr)
+	}
+	want := map[string]string{
+		"Cookie":        "session=s3cret",
+		"Authorization": "Bearer t0ken",
+		"User-Agent":    "test-client",
+	}
+	if len(info.Headers) != len(want) {
+		t.Errorf("headers = %v, want %v", info.Headers, want)
+	}
+	for k, v := range want {
+		if info.Headers[k] != v {
+			t.Errorf("header %s = %q, want %q", k, info.Headers[k], v)
+		}
+	}
+}
+
+func TestAcceptAssignsUser(t *testing.T) {
+	php := &fakePHP{reply: func(h *protocol.StreamHeader, _ []byte) *protocol.Frame {
+		if h.Event != protocol.EventConnect {
+			return nil
+		}
+		return command(t, protocol.StreamCommand{Event: protocol.EventAccept, UserID: "user-42"})
+	}}
+	m, url := servePHP(t, php)
+
+	_, id := connect(t, php, url)
+	deadline := time.Now().Add(5 * time.Second)
+	for m.UserID(id) != "user-42" {
+		if time.Now().After(deadline) {
+			t.Fatalf("user = %q, want user-42", m.UserID(id))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRejectOnConnect(t *testing.T) {
+	php := &fakePHP{reply: func(h *protocol.StreamHeader, _ []byte) *protocol.Frame {
+		if h.Event != protocol.EventConnect {
+			return nil
+		}
+		return batch(t, protocol.StreamCommand{Event: protocol.EventReject, Code: 4401, Data: []byte("unauthorized")})
+	}}
+	m, url := servePHP(t, php)
+	before := counter(t, "maboo_websocket_rejected_total", "php")
+
+	conn := dial(t, url)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	var ce *gorilla.CloseError
+	if !errors.As(err, &ce) || ce.Code != 4401 || ce.Text != "unauthorized" {
+		t.Fatalf("read after reject: %v, want close 4401 unauthorized", err)
+	}
+	waitConnections(t, m, 0)
+	if got := php.seen(protocol.EventClose); len(got) != 0 {
+		t.Errorf("PHP was told about the close of a rejected connection: %+v", got)
+	}
+	if got := counter(t, "maboo_websocket_rejected_total", "php") - before; got != 1 {
+		t.Errorf("rejected{php} grew by %v, want 1", got)
+	}
+}
@@ -0,0 +1,146 @@
+package websocket
+
+import "github.com/sadewadee/maboo/internal/metrics"
+
+var (
+	connectionsGauge = metrics.NewGauge("maboo_websocket_connections",
+		"Current number of open WebSocket connections.")
+	roomsGauge = metrics.NewGauge("maboo_websocket_rooms",
+		"Current number of WebSocket rooms with at least one member.")
+	messagesTotal = metrics.NewCounterVec("maboo_websocket_messages_total",
+		"WebSocket messages by direction (in, out, broadcast).", "direction")
+	bytesTotal = metrics.NewCounterVec("maboo_websocket_bytes_total",
+		"WebSocket payload bytes by direction (in, out).", "direction")
+	sendFailuresTotal = metrics.NewCounterVec("maboo_websocket_send_failures_total",
+		"WebSocket sends that failed, by direction (out, broadcast).", "direction")
+	reapedTotal = metrics.NewCounterVec("maboo_websocket_reaped_total",
+		"WebSocket connections closed by the keepalive, by reason (pong_timeout, ping_failed).", "reason")
+	rejectedTotal = metrics.NewCounterVec("maboo_websocket_rejected_total",
+		"WebSocket upgrades refused, by reason (origin, max_connections, max_connections_per_ip, php).", "reason")
+	authTotal = metrics.NewCounterVec("maboo_websocket_auth_total",
+		"WebSocket upgrades checked by websocket.auth_endpoint, by result (allowed, denied, timeout, error).", "result")
+	authDuration = metrics.NewHistogram("maboo_websocket_auth_duration_seconds",
+		"Time websocket.auth_endpoint took to answer, in seconds.",
+		[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10})
+	limitedTotal = metrics.NewCounterVec("maboo_websocket_inbound_limited_total",
+		"Inbound WebSocket messages over a limit, by outcome (message_size, rate_drop, rate_close).", "reason")
+	publishedTotal = metrics.NewCounterVec("maboo_websocket_publish_requests_total",
+		"Publish requests from outside WebSocket events, by result (ok, invalid, rate_limited).", "result")
+	droppedTotal = metrics.NewCounterVec("maboo_websocket_dropped_messages_total",
+		"WebSocket messages not delivered because the client's send queue was full, by websocket.slow_client policy (drop, disconnect).", "policy")
+	shutdownClosesTotal = metrics.NewCounterVec("maboo_websocket_shutdown_closes_total",
+		"WebSocket connections closed on shutdown, by whether the client answered the close frame within websocket.drain_timeout (graceful, forced).", "result")
+	resumeSessionsGauge = metrics.NewGaugeVec("maboo_websocket_resume_sessions",
+		"Resumable WebSocket sessions, by state (connected, dropped).", "state")
+	resumeBufferBytes = metrics.NewGauge("maboo_websocket_resume_buffer_bytes",
+		"Bytes of messages kept for replay by resumable WebSocket sessions.")
+	resumesTotal = metrics.NewCounterVec("maboo_websocket_resumes_total",
+		"WebSocket resume attempts, by result (resumed, unknown, gap).", "result")
+	sessionsEndedTotal = metrics.NewCounterVec("maboo_websocket_resume_sessions_ended_total",
+		"Dropped WebSocket sessions that ended without being resumed, by reason (ttl, max_sessions, gap, closed).", "reason")
+	broadcastDuration = metrics.NewHistogram("maboo_websocket_broadcast_duration_seconds",
+		"Time taken to queue a WebSocket broadcast for all its recipients, in seconds.",
+		[]float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25})
+	roomFullTotal = metrics.NewCounter("maboo_websocket_room_full_total",
+		"Joins refused because the WebSocket room had reached its maximum size.")
+	roomAuthTotal = metrics.NewCounterVec("maboo_websocket_room_authorizations_total",
+		"Joins to private WebSocket rooms, by decision (allowed, denied, error), including those answered from the cache.", "result")
+	ticksTotal = metrics.NewCounterVec("maboo_websocket_ticks_total",
+		"Tick events for the WebSocket worker, by result (sent, saturated, busy).", "result")
+	connectionDuration = metrics.NewHistogram("maboo_websocket_connection_duration_seconds",
+		"Lifetime of closed WebSocket connections in seconds.",
+		[]float64{1, 5, 15, 60, 300, 900, 1800, 3600, 4 * 3600, 12 * 3600})
+)
+
+// Message directions used as metric label values.
+const (
+	dirIn        = "in"
+	dirOut       = "out"
+	dirBroadcast = "broadcast"
+)
+
+// Reasons a keepalive closes a connection, used as metric label values.
+const (
+	reapPongTimeout = "pong_timeout"
+	reapPingFailed  = "ping_failed"
+)
+
+// Inbound limits hit, used as metric label values.
+const (
+	limitMessageSize = "message_size"
+	limitRateDrop    = "rate_drop"
+	limitRateClose   = "rate_close"
+)
+
+// websocket.slow_client policies, used as metric label values.
+const (
+	slowDrop       = "drop"
+	slowDisconnect = "disconnect"
+)
+
+// Publish results, used as metric label values.
+const (
+	publishOK          = "ok"
+	publishInvalid     = "invalid"
+	publishRateLimited = "rate_limited"
+)
+
+// Results of the auth endpoint, used as metric label values.
+const (
+	authAllowed = "allowed"
+	authDenied  = "denied"
+	authTimeout = "timeout"
+	authError   = "error"
+)
+
+// Reasons an upgrade is refused, used as metric label values.
+const (
+	rejectOrigin              = "origin"
+	rejectMaxConnections      = "max_connections"
+	rejectMaxConnectionsPerIP = "max_connections_per_ip"
+	rejectSubprotocol         = "subprotocol"
+	rejectPHP                 = "php" // PHP rejected the connection
+)
+
+// How a connection ended on shutdown, used as metric label values.
+const (
+	shutdownGraceful = "graceful"
+	shutdownForced   = "forced"
+)
+
+// Session states, used as metric label values.
+const (
+	sessionConnected = "connected"
+	sessionDropped   = "dropped"
+)
+
+// Resume results, used as metric label values.
+const (
+	resumeResumed = "resumed"
+	resumeUnknown = "unknown" // no such session, e.g. it expired
+	resumeGap     = "gap"     // messages the client missed are no longer kept
+)
+
+// Why a dropped session ended, used as metric label values.
+const (
+	endTTL         = "ttl"
+	endMaxSessions = "max_sessions"
+	endGap         = "gap"
+	endClosed      = "closed" // PHP closed it, or the server shut down
+)
+
+// Private room join decisions, used as metric label values. An error is
+// a worker that could not be asked, which denies the join.
+const (
+	roomAuthAllowed = "allowed"
+	roomAuthDenied  = "denied"
+	roomAuthError   = "error"
+)
+
+// Tick outcomes, used as metric label values: sent to PHP, or skipped
+// because no worker was free or the previous tick was still running.
+const (
+	tickSent      = "sent"
+	tickSaturated = "saturated"
+	tickBusy      = "busy"
+)
@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/protocol"
+)
+
+// PrivateRooms names the rooms a connection only joins once PHP has
+// authorized it.
+type PrivateRooms struct {
+	Prefixes []string      // rooms whose names start with one of these
+	CacheTTL time.Duration // how long a decision holds per connection and room; 0 = ask every time
+}
+
+// private reports whether joining the room called name needs PHP's
+// permission.
+func (p PrivateRooms) private(name string) bool {
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// roomDecision is PHP's answer to authorize, kept until expires.
+type roomDecision struct {
+	allow   bool
+	reason  string
+	expires time.Time
+}
+
+// errorEvent names the messages telling a client that something it asked
+// for was refused.
+const errorEvent = "maboo.error"
+
+// errorMessage tells a client that a request was refused.
+type errorMessage struct {
+	Event  string `json:"event"`
+	Error  string `json:"error"` // e.g. join_denied
+	Room   string `json:"room,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// SetPrivateRooms changes which rooms need PHP's permission to join.
+// Decisions already cached are kept until they expire.
+func (m *Manager) SetPrivateRooms(p PrivateRooms) {
+	m.mu.Lock()
+	m.private = p
+	m.mu.Unlock()
+}
+
+// authorizeJoin reports whether the connection clientID may join the room
+// called name. Private rooms are asked of PHP with an authorize event,
+// unless a decision for the connection and room is still cached; a
+// connection refused is sent a maboo.error message saying why. Other rooms,
+// and connections that do not exist, need no permission.
+func (m *Manager) authorizeJoin(clientID, name string) bool {
+	m.mu.RLock()
+	policy := m.private
+	client := m.clients[clientID]
+	forward := m.phpForward
+	var cached roomDecision
+	if client != nil {
+		cached = client.authorized[name]
+	}
+	m.mu.RUnlock()
+	if client == nil || !policy.private(name) {
+		return true
+	}
+
+	d := cached
+	if !time.Now().Before(d.expires) {
+		var ok bool
+		if d, ok = m.askAuthorize(client, name, forward); !ok {
+			roomAuthTotal.WithLabelValues(roomAuthError).Inc()
+			m.refuseJoin(client, name, "")
+			return false
+		}
+		if policy.CacheTTL > 0 {
+			d.expires = time.Now().Add(policy.CacheTTL)
+			m.mu.Lock()
+			if client.authorized == nil {
+				client.authorized = make(map[string]roomDecision)
+			}
+			client.authorized[name] = d
+			m.mu.Unlock()
+		}
+	}
+	if !d.allow {
+		roomAuthTotal.WithLabelValues(roomAuthDenied).Inc()
+		m.refuseJoin(client, name, d.reason)
+		return false
+	}
+	roomAuthTotal.WithLabelValues(roomAuthAllowed).Inc()
+	return true
+}
+
+// askAuthorize sends PHP an authorize event for client and the room called
+// name, and returns its decision: an allow for the room, or else a denial.
+// Other commands in the answer are ignored. It reports false if PHP could
+// not be asked.
+func (m *Manager) askAuthorize(client *Client, name string, forward func(*protocol.Frame) (*protocol.Frame, error)) (roomDecision, bool) {
+	if forward == nil {
+		return roomDecision{}, false
+	}
+	m.mu.RLock()
+	userID := client.UserID
+	m.mu.RUnlock()
+	header := protocol.StreamHeader{Event: protocol.EventAuthorize, ConnectionID: client.ID, Room: name, UserID: userID}
+	frame, err := protocol.EncodeStreamData(0, &header, nil)
+	if err != nil {
+		m.logger.Error("encoding stream data", "error", err)
+		return roomDecision{}, false
+	}
+	resp, err := forward(frame)
+	if err != nil {
+		m.logger.Error("asking PHP to authorize a join", "conn_id", client.ID, "room", name, "error", err)
+		return roomDecision{}, false
+	}
+	if resp == nil || resp.Type != protocol.TypeStreamData {
+		return roomDecision{}, true
+	}
+	cmds, err := protocol.DecodeStreamCommands(resp)
+	if err != nil {
+		m.logger.Error("decoding PHP stream response", "error", err)
+		return roomDecision{}, false
+	}
+	for _, cmd := range cmds {
+		if cmd.Room != "" && cmd.Room != name {
+			continue
+		}
+		switch cmd.Event {
+		case protocol.EventAllow:
+			return roomDecision{allow: true}, true
+		case protocol.EventDeny:
+			return roomDecision{reason: string(cmd.Data)}, true
+		}
+	}
+	return roomDecision{}, true
+}
+
+// refuseJoin tells client it may not join the room called name.
+func (m *Manager) refuseJoin(client *Client, name, reason string) {
+	m.logger.Debug("websocket join denied", "conn_id", client.ID, "room", name, "reason", reason)
+	msg, _ := json.Marshal(errorMessage{Event: errorEvent, Error: "join_denied", Room: name, Reason: reason})
+	m.SendToClient(client.ID, msg)
+}
@@ -0,0 +1,117 @@
+package websocket_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+// servePrivate serves connections that join the room named in a "join
+// <room>" message, with rooms under private- needing authorization, which
+// authorize decides.
+func servePrivate(t *testing.T, ttl time.Duration, authorize func(room string) *protocol.Frame) (*fakePHP, *websocket.Manager, string) {
+	t.Helper()
+	php := &fakePHP{}
+	php.setReply(func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		switch h.Event {
+		case protocol.EventAuthorize:
+			return authorize(h.Room)
+		case protocol.EventMessage:
+			room, _ := strings.CutPrefix(string(data), "join ")
+			return command(t, protocol.StreamCommand{Event: protocol.EventJoin, Room: room})
+		}
+		return nil
+	})
+	m, url := servePHP(t, php)
+	m.SetPrivateRooms(websocket.PrivateRooms{Prefixes: []string{"private-", "presence-"}, CacheTTL: ttl})
+	return php, m, url
+}
+
+// expectDenied reads the message telling conn it may not join room.
+func expectDenied(t *testing.T, conn *gorilla.Conn, room, reason string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]string
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("denial %q: %v", data, err)
+	}
+	if msg["event"] != "maboo.error" || msg["error"] != "join_denied" || msg["room"] != room || msg["reason"] != reason {
+		t.Errorf("denial = %q, want join_denied for %s because %q", data, room, reason)
+	}
+}
+
+func TestPrivateRoomAuthorization(t *testing.T) {
+	php, m, url := servePrivate(t, time.Minute, func(room string) *protocol.Frame {
+		if room == "private-team" {
+			return command(t, protocol.StreamCommand{Event: protocol.EventAllow, Room: room})
+		}
+		return command(t, protocol.StreamCommand{Event: protocol.EventDeny, Room: room, Data: []byte("not a member")})
+	})
+	allowed := counter(t, "maboo_websocket_room_authorizations_total", "allowed")
+	denied := counter(t, "maboo_websocket_room_authorizations_total", "denied")
+	conn, id := connect(t, php, url)
+
+	send(t, conn, "join private-team")
+	waitRoom(t, m, "private-team", 1)
+	asked := php.waitSeen(t, protocol.EventAuthorize)
+	if asked[0].ConnectionID != id || asked[0].Room != "private-team" {
+		t.Errorf("authorize event = %+v, want %s asking for private-team", asked[0], id)
+	}
+
+	send(t, conn, "join presence-ceo")
+	expectDenied(t, conn, "presence-ceo", "not a member")
+	if n := m.RoomStats()["presence-ceo"]; n != 0 {
+		t.Errorf("denied room has %d members", n)
+	}
+
+	send(t, conn, "join lobby")
+	waitRoom(t, m, "lobby", 1)
+	if n := len(php.seen(protocol.EventAuthorize)); n != 2 {
+		t.Errorf("PHP asked %d times, want 2: public rooms need no authorization", n)
+	}
+
+	// Decisions are cached per connection and room.
+	m.LeaveRoom(id, "private-team")
+	send(t, conn, "join private-team")
+	send(t, conn, "join presence-ceo")
+	waitRoom(t, m, "private-team", 1)
+	expectDenied(t, conn, "presence-ceo", "not a member")
+	if n := len(php.seen(protocol.EventAuthorize)); n != 2 {
+		t.Errorf("PHP asked %d times, want the decisions cached", n)
+	}
+	if got := counter(t, "maboo_websocket_room_authorizations_total", "allowed") - allowed; got != 2 {
+		t.Errorf("allowed joins counted %g, want 2", got)
+	}
+	if got := counter(t, "maboo_websocket_room_authorizations_total", "denied") - denied; got != 2 {
+		t.Errorf("denied joins counted %g, want 2", got)
+	}
+}
+
+func TestPrivateRoomWithoutAnswer(t *testing.T) {
+	// Without a cache every join asks again, and an answer that neither
+	// allows nor denies the room denies it.
+	php, m, url := servePrivate(t, 0, func(room string) *protocol.Frame {
+		return command(t, protocol.StreamCommand{Event: protocol.EventAllow, Room: "private-other"})
+	})
+	conn, _ := connect(t, php, url)
+
+	for range 2 {
+		send(t, conn, "join private-team")
+		expectDenied(t, conn, "private-team", "")
+	}
+	if n := len(php.seen(protocol.EventAuthorize)); n != 2 {
+		t.Errorf("PHP asked %d times, want 2", n)
+	}
+	if n := m.RoomStats()["private-team"]; n != 0 {
+		t.Errorf("private-team has %d members, want 0", n)
+	}
+}
@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Publication is a message pushed to WebSocket clients from outside a
+// WebSocket event, e.g. by a PHP request through the admin API. Exactly
+// one of Room, Pattern, ConnectionID and UserID names the recipients.
+type Publication struct {
+	Room         string          `json:"room,omitempty"`
+	Pattern      string          `json:"pattern,omitempty"` // rooms matching it, as for BroadcastToPattern
+	ConnectionID string          `json:"connection_id,omitempty"`
+	UserID       string          `json:"user_id,omitempty"`
+	Event        string          `json:"event,omitempty"`   // wraps data as {"event": ..., "data": ...}
+	Data         json.RawMessage `json:"data"`              // a JSON string is sent as its text
+	Exclude      string          `json:"exclude,omitempty"` // connection left out, e.g. the one that caused the event
+}
+
+// Delivery reports whom a publication reached.
+type Delivery struct {
+	Recipients int `json:"recipients"` // matching connections
+	Delivered  int `json:"delivered"`  // connections the message was queued for
+}
+
+// Errors returned by Publish.
+var (
+	ErrPublishTarget      = errors.New("exactly one of room, pattern, connection_id and user_id is required")
+	ErrPublishRateLimited = errors.New("publish rate exceeded")
+)
+
+// SetPublishRate limits Publish to rate calls per second with bursts of
+// burst; rate 0 removes the limit.
+func (m *Manager) SetPublishRate(rate float64, burst int) {
+	m.publishMu.Lock()
+	defer m.publishMu.Unlock()
+	m.publishLimit = nil
+	if rate > 0 {
+		m.publishLimit = newBucket(rate, burst)
+	}
+}
+
+// Publish sends p to its recipients.
+func (m *Manager) Publish(p Publication) (Delivery, error) {
+	targets := 0
+	for _, t := range []string{p.Room, p.Pattern, p.ConnectionID, p.UserID} {
+		if t != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		publishedTotal.WithLabelValues(publishInvalid).Inc()
+		return Delivery{}, ErrPublishTarget
+	}
+	var pattern []string
+	if p.Pattern != "" {
+		var err error
+		if pattern, err = compileRoomPattern(p.Pattern); err != nil {
+			publishedTotal.WithLabelValues(publishInvalid).Inc()
+			return Delivery{}, err
+		}
+	}
+	msg, err := p.message()
+	if err != nil {
+		publishedTotal.WithLabelValues(publishInvalid).Inc()
+		return Delivery{}, err
+	}
+
+	m.publishMu.Lock()
+	allowed := m.publishLimit == nil || m.publishLimit.allow(time.Now())
+	m.publishMu.Unlock()
+	if !allowed {
+		publishedTotal.WithLabelValues(publishRateLimited).Inc()
+		return Delivery{}, ErrPublishRateLimited
+	}
+
+	clients := m.recipients(p, pattern)
+	publishedTotal.WithLabelValues(publishOK).Inc()
+	return m.broadcast(clients, msg, p.Room+p.Pattern), nil
+}
+
+// message builds the text sent to clients.
+func (p Publication) message() ([]byte, error) {
+	data := p.Data
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+	if p.Event != "" {
+		return json.Marshal(struct {
+			Event string          `json:"event"`
+			Data  json.RawMessage `json:"data"`
+		}{p.Event, data})
+	}
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		return []byte(text), nil
+	}
+	if !json.Valid(data) {
+		return nil, errors.New("data is not valid JSON")
+	}
+	return data, nil
+}
+
+// recipients returns the connections p is for, given its compiled
+// pattern if it has one.
+func (m *Manager) recipients(p Publication, pattern []string) []*Client {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var clients []*Client
+	switch {
+	case p.Room != "":
+		for _, c := range m.membersLocked(p.Room) {
+			clients = append(clients, c)
+		}
+	case pattern != nil:
+		clients = m.patternClientsLocked(pattern)
+	case p.ConnectionID != "":
+		if c := m.clientLocked(p.ConnectionID); c != nil {
+			clients = append(clients, c)
+		}
+	default:
+		clients = m.userClientsLocked(p.UserID)
+	}
+	return slices.DeleteFunc(clients, func(c *Client) bool { return c.ID == p.Exclude })
+}
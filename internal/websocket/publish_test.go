@@ -0,0 +1,64 @@
+package websocket_test
+
+import (
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+func TestPublish(t *testing.T) {
+	// Every connection joins "news"; the first also belongs to user 7.
+	php := &fakePHP{}
+	php.setReply(func(h *protocol.StreamHeader, _ []byte) *protocol.Frame {
+		if h.Event != protocol.EventConnect {
+			return nil
+		}
+		cmds := []protocol.StreamCommand{{Event: protocol.EventJoin, Room: "news"}}
+		if len(php.seen(protocol.EventConnect)) == 1 {
+			cmds = append(cmds, protocol.StreamCommand{Event: protocol.EventAccept, UserID: "7"})
+		}
+		return batch(t, cmds...)
+	})
+	m, url := servePHP(t, php)
+	a, aID := connect(t, php, url)
+	b, _ := connect(t, php, url)
+	waitRoom(t, m, "news", 2)
+	deadline := time.Now().Add(5 * time.Second)
+	for m.UserID(aID) != "7" {
+		if time.Now().After(deadline) {
+			t.Fatal("user 7 was not assigned")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	publish := func(p websocket.Publication, want websocket.Delivery) {
+		t.Helper()
+		got, err := m.Publish(p)
+		if err != nil || got != want {
+			t.Fatalf("Publish(%+v) = %+v, %v; want %+v", p, got, err, want)
+		}
+	}
+
+	publish(websocket.Publication{Room: "news", Event: "order.shipped", Data: []byte(`{"id":7}`)},
+		websocket.Delivery{Recipients: 2, Delivered: 2})
+	for _, conn := range []*gorilla.Conn{a, b} {
+		expectBroadcast(t, conn, `{"event":"order.shipped","data":{"id":7}}`)
+	}
+
+	publish(websocket.Publication{Room: "news", Data: []byte(`"plain text"`), Exclude: aID},
+		websocket.Delivery{Recipients: 1, Delivered: 1})
+	expectBroadcast(t, b, "plain text")
+
+	publish(websocket.Publication{UserID: "7", Data: []byte(`[1,2]`)},
+		websocket.Delivery{Recipients: 1, Delivered: 1})
+	expectBroadcast(t, a, "[1,2]")
+
+	publish(websocket.Publication{ConnectionID: "gone", Data: []byte(`"x"`)}, websocket.Delivery{})
+
+	if _, err := m.Publish(websocket.Publication{Data: []byte(`"x"`)}); err != websocket.ErrPublishTarget {
+		t.Errorf("Publish without a target: %v, want ErrPublishTarget", err)
+	}
+}
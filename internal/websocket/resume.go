@@ -0,0 +1,341 @@
+package websocket
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/protocol"
+)
+
+// ResumePolicy lets clients whose connection dropped resume their session.
+// Every message sent in a session is numbered and the last ones are kept,
+// so a client reconnecting with its token and the number of messages it
+// has had gets the ones it missed, back in its rooms.
+type ResumePolicy struct {
+	Buffer      int           // messages kept per session
+	BufferBytes int           // bytes kept per session; older messages go first
+	TTL         time.Duration // how long a dropped session waits; 0 disables resuming
+	MaxSessions int           // dropped sessions kept at once, the oldest ending first; 0 = unlimited
+}
+
+// takeoverTimeout bounds how long a resume waits for the connection it
+// replaces, which may not have noticed it is gone, to be torn down.
+const takeoverTimeout = 5 * time.Second
+
+// sessionEvent names the message that opens every resumable connection.
+const sessionEvent = "maboo.session"
+
+// sessionMessage is the first message on a resumable connection. It is not
+// numbered: the client counts the messages after it on from Seq.
+type sessionMessage struct {
+	Event   string `json:"event"`
+	Token   string `json:"token"`
+	Seq     uint64 `json:"seq"`
+	Resumed bool   `json:"resumed"`
+}
+
+// session numbers and keeps the messages sent to one logical connection,
+// across the WebSocket connections that carry it.
+type session struct {
+	token  string
+	policy ResumePolicy
+
+	mu    sync.Mutex
+	live  *Client  // connection the messages are written to; nil while dropped
+	seq   uint64   // messages sent in the session so far
+	buf   [][]byte // the last of them, buf[len(buf)-1] being number seq
+	bytes int
+	ended bool
+
+	// While dropped, guarded by the manager's mu.
+	ghost       *Client // the dropped connection, still in its rooms and under its user
+	elem        *list.Element
+	timer       *time.Timer
+	closeCode   int // why it dropped, for PHP's close event when the session ends
+	closeReason string
+}
+
+// SetResumePolicy sets how sessions of connections opened from now on can
+// be resumed.
+func (m *Manager) SetResumePolicy(p ResumePolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resume = p
+}
+
+// startSessionLocked makes client resumable and queues the message telling
+// it its token. The caller holds mu, before client is visible to senders.
+func (m *Manager) startSessionLocked(client *Client) {
+	s := &session{token: generateConnID(), policy: m.resume, live: client}
+	client.session = s
+	client.queue <- message{data: s.hello(0, false)}
+	m.sessions[s.token] = s
+	m.resumeGaugesLocked()
+}
+
+func (s *session) hello(seq uint64, resumed bool) []byte {
+	msg, _ := json.Marshal(sessionMessage{Event: sessionEvent, Token: s.token, Seq: seq, Resumed: resumed})
+	return msg
+}
+
+// send numbers and keeps msg, and queues it for the live connection, if
+// any. A connection too slow to take it is dropped rather than the
+// message, which the client gets back by resuming.
+func (s *session) send(msg message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return errClientClosed
+	}
+	s.record(msg.data)
+
+	c := s.live
+	if c == nil {
+		return nil
+	}
+	select {
+	case <-c.closing:
+		return nil
+	default:
+	}
+	select {
+	case c.queue <- msg:
+	default:
+		droppedTotal.WithLabelValues(slowDisconnect).Inc()
+		c.Conn.Close()
+	}
+	return nil
+}
+
+// record keeps data as message number seq+1, forgetting the oldest
+// messages beyond the policy's limits. The caller holds s.mu.
+func (s *session) record(data []byte) {
+	s.seq++
+	s.buf = append(s.buf, data)
+	before := s.bytes
+	s.bytes += len(data)
+	for len(s.buf) > 0 && (len(s.buf) > s.policy.Buffer || s.bytes > s.policy.BufferBytes) {
+		s.bytes -= len(s.buf[0])
+		s.buf[0] = nil
+		s.buf = s.buf[1:]
+	}
+	resumeBufferBytes.Add(float64(s.bytes - before))
+}
+
+// missedLocked returns the messages after the first lastSeq, or false if
+// some are no longer kept or lastSeq is ahead of the session. The caller
+// holds s.mu.
+func (s *session) missedLocked(lastSeq uint64) ([][]byte, bool) {
+	first := s.seq - uint64(len(s.buf)) + 1
+	if lastSeq > s.seq || lastSeq+1 < first {
+		return nil, false
+	}
+	return s.buf[lastSeq+1-first:], true
+}
+
+// end frees the kept messages; sends from then on fail.
+func (s *session) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resumeBufferBytes.Sub(float64(s.bytes))
+	s.buf, s.bytes, s.live, s.ended = nil, 0, nil, true
+}
+
+// drop keeps the session of a resumable connection that ended without a
+// close frame either way, instead of unregistering it. The connection
+// stays in its rooms and under its user, so what is sent meanwhile is
+// kept for the resume; PHP hears of the close only when the session ends.
+// It reports whether the connection was kept.
+func (m *Manager) drop(id string, code int, reason string) bool {
+	m.mu.Lock()
+	client, ok := m.clients[id]
+	if !ok || client.session == nil || m.resume.TTL <= 0 {
+		m.mu.Unlock()
+		return false
+	}
+	if c, _ := client.closeStatus(); c != 0 {
+		m.mu.Unlock()
+		return false // the server closed it on purpose
+	}
+	s := client.session
+	delete(m.clients, id)
+	m.releaseLocked(client.IP)
+	connectionsGauge.Set(float64(len(m.clients)))
+
+	s.mu.Lock()
+	s.live = nil
+	s.mu.Unlock()
+	s.ghost, s.closeCode, s.closeReason = client, code, reason
+	s.elem = m.droppedOrder.PushBack(s)
+	s.timer = time.AfterFunc(m.resume.TTL, func() { m.endDropped(s, endTTL) })
+	m.dropped[id] = s
+	var evicted *session
+	if max := m.resume.MaxSessions; max > 0 && m.droppedOrder.Len() > max {
+		evicted = m.droppedOrder.Front().Value.(*session)
+	}
+	m.resumeGaugesLocked()
+	m.mu.Unlock()
+
+	client.stop(0, "")
+	close(client.gone)
+	connectionDuration.Observe(time.Since(client.ConnectedAt).Seconds())
+	m.logger.Debug("websocket connection dropped, session kept for resuming", "conn_id", id, "code", code)
+	if evicted != nil {
+		m.endDropped(evicted, endMaxSessions)
+	}
+	return true
+}
+
+// endDropped ends a dropped session: its connection leaves its rooms and
+// user, and PHP gets the close event it was waiting for. It reports
+// whether the session was still dropped.
+func (m *Manager) endDropped(s *session, why string) bool {
+	m.mu.Lock()
+	if s.elem == nil {
+		m.mu.Unlock()
+		return false // resumed or ended meanwhile
+	}
+	ghost, code, reason := s.ghost, s.closeCode, s.closeReason
+	m.takeDroppedLocked(s)
+	delete(m.sessions, s.token)
+	m.leaveAllLocked(ghost)
+	m.resumeGaugesLocked()
+	m.mu.Unlock()
+
+	s.end()
+	sessionsEndedTotal.WithLabelValues(why).Inc()
+	m.notify(ghost, protocol.StreamHeader{Event: protocol.EventClose, Code: code}, []byte(reason), true)
+	return true
+}
+
+// takeDroppedLocked takes s off the dropped list. The caller holds mu.
+func (m *Manager) takeDroppedLocked(s *session) {
+	m.droppedOrder.Remove(s.elem)
+	s.elem = nil
+	s.timer.Stop()
+	delete(m.dropped, s.ghost.ID)
+	s.ghost = nil
+}
+
+// droppedLocked returns the dropped sessions, oldest first. The caller
+// holds mu.
+func (m *Manager) droppedLocked() []*session {
+	sessions := make([]*session, 0, m.droppedOrder.Len())
+	for e := m.droppedOrder.Front(); e != nil; e = e.Next() {
+		sessions = append(sessions, e.Value.(*session))
+	}
+	return sessions
+}
+
+// resumeSession carries a reconnecting client's session over to conn, if
+// r names one with ?resume=<token>, and reports whether it did. The client
+// gets the messages after the first ?last_seq= it had, and PHP a resume
+// event instead of connect. The client is nil if PHP rejected it.
+func (m *Manager) resumeSession(conn *websocket.Conn, r *http.Request, info *protocol.ConnectInfo) (*Client, bool) {
+	query := r.URL.Query()
+	token := query.Get("resume")
+	if token == "" {
+		return nil, false
+	}
+	lastSeq, _ := strconv.ParseUint(query.Get("last_seq"), 10, 64)
+
+	m.mu.RLock()
+	s := m.sessions[token]
+	m.mu.RUnlock()
+	if s == nil {
+		resumesTotal.WithLabelValues(resumeUnknown).Inc()
+		return nil, false
+	}
+
+	// The connection being replaced may not have noticed it is gone yet.
+	s.mu.Lock()
+	old := s.live
+	s.mu.Unlock()
+	if old != nil {
+		old.Conn.Close()
+		select {
+		case <-old.gone:
+		case <-time.After(takeoverTimeout):
+		}
+	}
+
+	m.mu.Lock()
+	if s.elem == nil {
+		m.mu.Unlock()
+		resumesTotal.WithLabelValues(resumeUnknown).Inc()
+		return nil, false // ended, or resumed by another connection
+	}
+	ghost := s.ghost
+	if ghost.Subprotocol != info.Subprotocol {
+		// Its events would reach a worker that never saw the session.
+		m.mu.Unlock()
+		resumesTotal.WithLabelValues(resumeUnknown).Inc()
+		return nil, false
+	}
+
+	s.mu.Lock()
+	missed, ok := s.missedLocked(lastSeq)
+	if !ok {
+		s.mu.Unlock()
+		m.mu.Unlock()
+		resumesTotal.WithLabelValues(resumeGap).Inc()
+		m.endDropped(s, endGap)
+		return nil, false
+	}
+	bp := m.bp
+	bp.QueueSize += len(missed) + 1
+	client := newClient(ghost.ID, conn, bp, m.compressMin)
+	client.RemoteAddr = r.RemoteAddr
+	client.IP = info.RemoteAddr
+	client.Auth = []byte(info.Auth)
+	client.Subprotocol = ghost.Subprotocol
+	client.Rooms = ghost.Rooms
+	client.session = s
+	client.queue <- message{data: s.hello(lastSeq, true)}
+	for _, data := range missed {
+		client.queue <- message{data: data}
+	}
+	s.live = client
+	s.mu.Unlock()
+
+	m.takeDroppedLocked(s)
+	for room := range client.Rooms {
+		m.rooms[room].members[client.ID] = client
+	}
+	if userID := ghost.UserID; userID != "" {
+		m.removeUserLocked(ghost)
+		m.setUserLocked(client, userID)
+	}
+	m.clients[client.ID] = client
+	connectionsGauge.Set(float64(len(m.clients)))
+	m.resumeGaugesLocked()
+	m.mu.Unlock()
+
+	resumesTotal.WithLabelValues(resumeResumed).Inc()
+	m.logger.Debug("websocket session resumed", "conn_id", client.ID, "last_seq", lastSeq, "replayed", len(missed))
+
+	payload, err := protocol.MarshalMsgpack(info)
+	if err != nil {
+		m.logger.Error("encoding connect info", "error", err)
+	}
+	m.notify(client, protocol.StreamHeader{Event: protocol.EventResume}, payload, true)
+
+	m.mu.RLock()
+	accepted := m.clients[client.ID] == client
+	m.mu.RUnlock()
+	if !accepted {
+		return nil, true
+	}
+	return client, true
+}
+
+// resumeGaugesLocked updates the session gauges. The caller holds mu.
+func (m *Manager) resumeGaugesLocked() {
+	resumeSessionsGauge.WithLabelValues(sessionConnected).Set(float64(len(m.sessions) - len(m.dropped)))
+	resumeSessionsGauge.WithLabelValues(sessionDropped).Set(float64(len(m.dropped)))
+}
@@ -0,0 +1,287 @@
+package websocket_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+type sessionMessage struct {
+	Event   string `json:"event"`
+	Token   string `json:"token"`
+	Seq     uint64 `json:"seq"`
+	Resumed bool   `json:"resumed"`
+}
+
+// serveResume serves connections that join the lobby and can be resumed
+// under the given resume settings.
+func serveResume(t *testing.T, resume config.WebSocketResumeConfig) (*fakePHP, *websocket.Manager, string) {
+	t.Helper()
+	cfg := config.Default().WebSocket
+	resume.Enabled = true
+	cfg.Resume = resume
+	m, url := serve(t, cfg, nil)
+	php := &fakePHP{}
+	php.setReply(acceptUser(t, nil))
+	m.SetPHPForwarder(php.forward)
+	return php, m, url
+}
+
+func defaultResume() config.WebSocketResumeConfig {
+	return config.Default().WebSocket.Resume
+}
+
+// readSession reads the message that opens a resumable connection.
+func readSession(t *testing.T, conn *gorilla.Conn) sessionMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s sessionMessage
+	if err := json.Unmarshal(data, &s); err != nil || s.Event != "maboo.session" || s.Token == "" {
+		t.Fatalf("first message = %s, want a maboo.session message", data)
+	}
+	return s
+}
+
+// cut drops conn without a close frame, as a lost network does, and waits
+// for the server to keep its session.
+func cut(t *testing.T, m *websocket.Manager, conn *gorilla.Conn) {
+	t.Helper()
+	want := m.Stats().DroppedSessions + 1
+	conn.UnderlyingConn().Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Stats().DroppedSessions != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("dropped sessions = %d, want %d", m.Stats().DroppedSessions, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func resumeURL(url string, s sessionMessage, lastSeq uint64) string {
+	return fmt.Sprintf("%s?resume=%s&last_seq=%d", url, s.Token, lastSeq)
+}
+
+func gauge(t *testing.T, name string) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name && len(f.Metric) == 1 {
+			return f.Metric[0].GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestResumeReplaysMissedMessages(t *testing.T) {
+	php, m, url := serveResume(t, defaultResume())
+	conn, id := connect(t, php, url+"?user=42")
+	session := readSession(t, conn)
+	if session.Seq != 0 || session.Resumed {
+		t.Errorf("new session = %+v, want seq 0, not resumed", session)
+	}
+	waitRoom(t, m, "lobby", 1)
+
+	m.BroadcastToRoom("lobby", []byte("one"), "")
+	expectBroadcast(t, conn, "one")
+
+	cut(t, m, conn)
+	kept := gauge(t, "maboo_websocket_resume_buffer_bytes")
+	m.BroadcastToRoom("lobby", []byte("two"), "")
+	m.SendToClient(id, []byte("three"))
+	if n := m.SendToUser("42", []byte("four"), ""); n != 1 {
+		t.Errorf("SendToUser reached %d connections while dropped, want 1", n)
+	}
+	if got := gauge(t, "maboo_websocket_resume_buffer_bytes") - kept; got != 12 {
+		t.Errorf("buffered bytes grew by %g, want 12", got)
+	}
+	if got := php.seen(protocol.EventClose); len(got) != 0 {
+		t.Errorf("PHP was told of %d closes while the session can be resumed", len(got))
+	}
+
+	conn = dial(t, resumeURL(url, session, 1))
+	resumed := readSession(t, conn)
+	if !resumed.Resumed || resumed.Seq != 1 || resumed.Token != session.Token {
+		t.Errorf("resumed session = %+v, want the same token at seq 1", resumed)
+	}
+	for _, want := range []string{"two", "three", "four"} {
+		expectBroadcast(t, conn, want)
+	}
+
+	resumes := php.waitSeen(t, protocol.EventResume)
+	if resumes[0].ConnectionID != id {
+		t.Errorf("resume event for %s, want the original connection %s", resumes[0].ConnectionID, id)
+	}
+	if got := php.seen(protocol.EventConnect); len(got) != 1 {
+		t.Errorf("%d connect events, want only the first", len(got))
+	}
+	if s := m.Stats(); s.TotalConnections != 1 || s.DroppedSessions != 0 || s.TotalUsers != 1 {
+		t.Errorf("stats after resume = %+v", s)
+	}
+
+	// Back in its rooms, and numbering goes on
+	m.BroadcastToRoom("lobby", []byte("five"), "")
+	expectBroadcast(t, conn, "five")
+	cut(t, m, conn)
+	conn = dial(t, resumeURL(url, session, 5))
+	if s := readSession(t, conn); !s.Resumed || s.Seq != 5 {
+		t.Errorf("second resume = %+v, want seq 5", s)
+	}
+	m.BroadcastToRoom("lobby", []byte("six"), "")
+	expectBroadcast(t, conn, "six")
+}
+
+func TestResumeTakesOverLiveConnection(t *testing.T) {
+	php, m, url := serveResume(t, defaultResume())
+	old, id := connect(t, php, url)
+	session := readSession(t, old)
+	waitRoom(t, m, "lobby", 1)
+
+	// The client knows it lost the connection before the server does.
+	conn := dial(t, resumeURL(url, session, 0))
+	if s := readSession(t, conn); !s.Resumed {
+		t.Errorf("session = %+v, want resumed", s)
+	}
+	resumes := php.waitSeen(t, protocol.EventResume)
+	if resumes[0].ConnectionID != id {
+		t.Errorf("resume event for %s, want %s", resumes[0].ConnectionID, id)
+	}
+	waitConnections(t, m, 1)
+	waitRoom(t, m, "lobby", 1)
+	m.BroadcastToRoom("lobby", []byte("hi"), "")
+	expectBroadcast(t, conn, "hi")
+}
+
+func TestResumeFallsBackToConnect(t *testing.T) {
+	resume := defaultResume()
+	resume.Buffer = 2
+	php, m, url := serveResume(t, resume)
+	unknown := counter(t, "maboo_websocket_resumes_total", "unknown")
+	gaps := counter(t, "maboo_websocket_resumes_total", "gap")
+
+	conn, _ := connect(t, php, url+"?resume=nosuchtoken&last_seq=3")
+	if s := readSession(t, conn); s.Resumed || s.Seq != 0 {
+		t.Errorf("unknown token: session = %+v, want a new one", s)
+	}
+	if got := counter(t, "maboo_websocket_resumes_total", "unknown") - unknown; got != 1 {
+		t.Errorf("unknown resumes counted %g, want 1", got)
+	}
+
+	// Three messages while dropped, with two kept: the first is lost.
+	conn, id := connect(t, php, url)
+	session := readSession(t, conn)
+	cut(t, m, conn)
+	for _, msg := range []string{"a", "b", "c"} {
+		m.SendToClient(id, []byte(msg))
+	}
+	conn, _ = connect(t, php, resumeURL(url, session, 0))
+	if s := readSession(t, conn); s.Resumed || s.Token == session.Token {
+		t.Errorf("resume across a gap: session = %+v, want a new one", s)
+	}
+	if got := counter(t, "maboo_websocket_resumes_total", "gap") - gaps; got != 1 {
+		t.Errorf("gaps counted %g, want 1", got)
+	}
+	closes := php.waitSeen(t, protocol.EventClose)
+	if closes[0].ConnectionID != id || closes[0].Code != gorilla.CloseAbnormalClosure {
+		t.Errorf("close event = %+v, want %s closed with %d", closes[0], id, gorilla.CloseAbnormalClosure)
+	}
+	waitRoom(t, m, "lobby", 2)
+
+	// The session is gone for good.
+	conn = dial(t, resumeURL(url, session, 3))
+	if s := readSession(t, conn); s.Resumed {
+		t.Errorf("second resume of an ended session = %+v", s)
+	}
+}
+
+func TestResumeSessionExpires(t *testing.T) {
+	resume := defaultResume()
+	resume.TTL = config.Duration(50 * time.Millisecond)
+	php, m, url := serveResume(t, resume)
+	before := counter(t, "maboo_websocket_resume_sessions_ended_total", "ttl")
+
+	conn, id := connect(t, php, url+"?user=7")
+	session := readSession(t, conn)
+	waitRoom(t, m, "lobby", 1)
+	cut(t, m, conn)
+
+	closes := php.waitSeen(t, protocol.EventClose)
+	if closes[0].ConnectionID != id || closes[0].Code != gorilla.CloseAbnormalClosure {
+		t.Errorf("close event = %+v, want %s closed with %d", closes[0], id, gorilla.CloseAbnormalClosure)
+	}
+	waitRoom(t, m, "lobby", 0)
+	waitUsers(t, m, 0)
+	if s := m.Stats(); s.DroppedSessions != 0 {
+		t.Errorf("dropped sessions = %d after the TTL", s.DroppedSessions)
+	}
+	if got := counter(t, "maboo_websocket_resume_sessions_ended_total", "ttl") - before; got != 1 {
+		t.Errorf("sessions ended by TTL = %g, want 1", got)
+	}
+
+	conn = dial(t, resumeURL(url, session, 0))
+	if s := readSession(t, conn); s.Resumed {
+		t.Errorf("resumed an expired session: %+v", s)
+	}
+}
+
+func TestResumeMaxSessions(t *testing.T) {
+	resume := defaultResume()
+	resume.MaxSessions = 1
+	php, m, url := serveResume(t, resume)
+	before := counter(t, "maboo_websocket_resume_sessions_ended_total", "max_sessions")
+
+	first, firstID := connect(t, php, url)
+	readSession(t, first)
+	second, _ := connect(t, php, url)
+	session := readSession(t, second)
+	waitRoom(t, m, "lobby", 2)
+
+	cut(t, m, first)
+	second.UnderlyingConn().Close()
+	closes := php.waitSeen(t, protocol.EventClose)
+	if len(closes) != 1 || closes[0].ConnectionID != firstID {
+		t.Errorf("close events = %+v, want only the oldest session's", closes)
+	}
+	if got := counter(t, "maboo_websocket_resume_sessions_ended_total", "max_sessions") - before; got != 1 {
+		t.Errorf("sessions evicted = %g, want 1", got)
+	}
+	waitRoom(t, m, "lobby", 1)
+
+	conn := dial(t, resumeURL(url, session, 0))
+	if s := readSession(t, conn); !s.Resumed {
+		t.Errorf("newest session = %+v, want resumed", s)
+	}
+}
+
+func TestResumeCloseFrameEndsSession(t *testing.T) {
+	php, m, url := serveResume(t, defaultResume())
+	conn, id := connect(t, php, url)
+	session := readSession(t, conn)
+	waitRoom(t, m, "lobby", 1)
+
+	conn.WriteMessage(gorilla.CloseMessage, gorilla.FormatCloseMessage(gorilla.CloseNormalClosure, "bye"))
+	closes := php.waitSeen(t, protocol.EventClose)
+	if closes[0].ConnectionID != id {
+		t.Errorf("close event for %s, want %s", closes[0].ConnectionID, id)
+	}
+	waitRoom(t, m, "lobby", 0)
+	if s := m.Stats(); s.DroppedSessions != 0 {
+		t.Errorf("dropped sessions = %d after a clean close", s.DroppedSessions)
+	}
+	if s := readSession(t, dial(t, resumeURL(url, session, 0))); s.Resumed {
+		t.Errorf("resumed a closed session: %+v", s)
+	}
+}
@@ -0,0 +1,251 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// roomSeparator splits room names into the segments patterns match, e.g.
+// "game:42:chat".
+const roomSeparator = ":"
+
+// room is a set of connections and what is known about it.
+type room struct {
+	name    string
+	members map[string]*Client
+	created time.Time
+	maxSize int // members allowed; 0 = unlimited
+}
+
+// RoomInfo describes a room, for the admin API.
+type RoomInfo struct {
+	Name    string    `json:"name"`
+	Members int       `json:"members"`
+	Created time.Time `json:"created"`
+	MaxSize int       `json:"max_size,omitempty"` // 0 = unlimited
+}
+
+// Errors returned when joining a room.
+var (
+	errNoClient = errors.New("no such websocket connection")
+	errRoomFull = errors.New("websocket room is full")
+)
+
+// roomNode indexes rooms by the segments of their names, so a pattern
+// only visits the rooms below its literal prefix.
+type roomNode struct {
+	children map[string]*roomNode
+	room     *room // nil unless a room has the name leading here
+}
+
+func (n *roomNode) insert(r *room) {
+	for _, seg := range strings.Split(r.name, roomSeparator) {
+		child := n.children[seg]
+		if child == nil {
+			if n.children == nil {
+				n.children = make(map[string]*roomNode)
+			}
+			child = &roomNode{}
+			n.children[seg] = child
+		}
+		n = child
+	}
+	n.room = r
+}
+
+// remove takes the room called name out of the index, pruning the nodes
+// left without rooms below them.
+func (n *roomNode) remove(name string) {
+	n.removePath(strings.Split(name, roomSeparator))
+}
+
+func (n *roomNode) removePath(segs []string) bool {
+	if len(segs) == 0 {
+		n.room = nil
+	} else if child := n.children[segs[0]]; child != nil && child.removePath(segs[1:]) {
+		delete(n.children, segs[0])
+	}
+	return n.room == nil && len(n.children) == 0
+}
+
+// match calls visit for each room matching pattern, once per room.
+func (n *roomNode) match(pattern []string, visit func(*room)) {
+	type state struct {
+		node *roomNode
+		rest int
+	}
+	seen := make(map[state]bool)
+	var walk func(n *roomNode, pattern []string)
+	walk = func(n *roomNode, pattern []string) {
+		s := state{n, len(pattern)}
+		if seen[s] {
+			return
+		}
+		seen[s] = true
+		if len(pattern) == 0 {
+			if n.room != nil {
+				visit(n.room)
+			}
+			return
+		}
+		switch seg := pattern[0]; {
+		case seg == "**":
+			walk(n, pattern[1:])
+			for _, child := range n.children {
+				walk(child, pattern)
+			}
+		case !strings.ContainsAny(seg, `*?[\`):
+			if child := n.children[seg]; child != nil {
+				walk(child, pattern[1:])
+			}
+		default:
+			for name, child := range n.children {
+				if ok, _ := path.Match(seg, name); ok {
+					walk(child, pattern[1:])
+				}
+			}
+		}
+	}
+	walk(n, pattern)
+}
+
+// compileRoomPattern splits pattern into segments: "**" matches any
+// number of segments, including none, and others use the path.Match
+// syntax ("*", "?", "[a-z]") within one segment.
+func compileRoomPattern(pattern string) ([]string, error) {
+	if pattern == "" {
+		return nil, errors.New("empty room pattern")
+	}
+	segs := strings.Split(pattern, roomSeparator)
+	for _, seg := range segs {
+		if _, err := path.Match(seg, ""); err != nil {
+			return nil, fmt.Errorf("room pattern %q: bad segment %q", pattern, seg)
+		}
+	}
+	return segs, nil
+}
+
+// SetMaxRoomSize sets how many members rooms created from now on allow,
+// unless the join creating them says otherwise; 0 is unlimited.
+func (m *Manager) SetMaxRoomSize(n int) {
+	m.mu.Lock()
+	m.maxRoomSize = n
+	m.mu.Unlock()
+}
+
+// join adds a client to a room, creating it if needed. A max above 0
+// becomes the room's member limit.
+func (m *Manager) join(clientID, name string, max int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	client, exists := m.clients[clientID]
+	if !exists {
+		return errNoClient
+	}
+	r := m.rooms[name]
+	if r == nil {
+		r = &room{name: name, members: make(map[string]*Client), created: time.Now(), maxSize: m.maxRoomSize}
+		m.rooms[name] = r
+		m.roomIndex.insert(r)
+		roomsGauge.Set(float64(len(m.rooms)))
+	}
+	if max > 0 {
+		r.maxSize = max
+	}
+	if _, member := r.members[clientID]; !member && r.maxSize > 0 && len(r.members) >= r.maxSize {
+		roomFullTotal.Inc()
+		return errRoomFull
+	}
+	r.members[clientID] = client
+	client.Rooms[name] = true
+	return nil
+}
+
+// membersLocked returns the members of the room called name, if any. The
+// caller holds mu.
+func (m *Manager) membersLocked(name string) map[string]*Client {
+	if r := m.rooms[name]; r != nil {
+		return r.members
+	}
+	return nil
+}
+
+// leaveRoomLocked takes client out of the room called name, deleting the
+// room once empty. The caller holds mu.
+func (m *Manager) leaveRoomLocked(client *Client, name string) {
+	if r := m.rooms[name]; r != nil {
+		delete(r.members, client.ID)
+		if len(r.members) == 0 {
+			m.deleteRoomLocked(r)
+		}
+	}
+	delete(client.Rooms, name)
+}
+
+func (m *Manager) deleteRoomLocked(r *room) {
+	delete(m.rooms, r.name)
+	m.roomIndex.remove(r.name)
+	roomsGauge.Set(float64(len(m.rooms)))
+}
+
+// BroadcastToPattern sends a message to the clients in every room whose
+// name matches pattern, once each, and reports how many it was queued
+// for. Room names are split on ":" into segments: "game:42:*" matches
+// each sub-channel of game:42, and "game:**" every room below game.
+func (m *Manager) BroadcastToPattern(pattern string, data []byte, excludeID string) (Delivery, error) {
+	segs, err := compileRoomPattern(pattern)
+	if err != nil {
+		return Delivery{}, err
+	}
+	m.mu.RLock()
+	clients := m.patternClientsLocked(segs)
+	m.mu.RUnlock()
+	clients = slices.DeleteFunc(clients, func(c *Client) bool { return c.ID == excludeID })
+	return m.broadcast(clients, data, pattern), nil
+}
+
+// patternClientsLocked returns the members of the rooms matching the
+// compiled pattern segs, each once. The caller holds mu.
+func (m *Manager) patternClientsLocked(segs []string) []*Client {
+	seen := make(map[string]bool)
+	var clients []*Client
+	m.roomIndex.match(segs, func(r *room) {
+		for id, c := range r.members {
+			if !seen[id] {
+				seen[id] = true
+				clients = append(clients, c)
+			}
+		}
+	})
+	return clients
+}
+
+// Rooms describes the rooms whose names match pattern, or every room if
+// it is empty, sorted by name.
+func (m *Manager) Rooms(pattern string) ([]RoomInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rooms := make([]RoomInfo, 0)
+	add := func(r *room) {
+		rooms = append(rooms, RoomInfo{Name: r.name, Members: len(r.members), Created: r.created, MaxSize: r.maxSize})
+	}
+	if pattern == "" {
+		for _, r := range m.rooms {
+			add(r)
+		}
+	} else {
+		segs, err := compileRoomPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m.roomIndex.match(segs, add)
+	}
+	slices.SortFunc(rooms, func(a, b RoomInfo) int { return strings.Compare(a.Name, b.Name) })
+	return rooms, nil
+}
@@ -0,0 +1,188 @@
+package websocket_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+// member connects a client and puts it in rooms.
+func member(t *testing.T, m *websocket.Manager, php *fakePHP, url string, rooms ...string) (*gorilla.Conn, string) {
+	t.Helper()
+	conn, id := connect(t, php, url)
+	for _, room := range rooms {
+		if !m.JoinRoom(id, room) {
+			t.Fatalf("%s could not join %s", id, room)
+		}
+	}
+	return conn, id
+}
+
+func TestBroadcastToPattern(t *testing.T) {
+	php := &fakePHP{}
+	m, url := servePHP(t, php)
+	chat, _ := member(t, m, php, url, "game:42:chat")
+	both, bothID := member(t, m, php, url, "game:42:chat", "game:42:moves")
+	other, _ := member(t, m, php, url, "game:43:chat")
+	parent, _ := member(t, m, php, url, "game:42")
+	deeper, _ := member(t, m, php, url, "game:42:chat:team")
+	all := []*gorilla.Conn{chat, both, other, parent, deeper}
+
+	tests := []struct {
+		pattern string
+		exclude string
+		want    []*gorilla.Conn
+	}{
+		{"game:42:*", "", []*gorilla.Conn{chat, both}},
+		{"game:*:chat", "", []*gorilla.Conn{chat, both, other}},
+		{"game:42:**", "", []*gorilla.Conn{chat, both, parent, deeper}},
+		{"game:**", bothID, []*gorilla.Conn{chat, other, parent, deeper}},
+		{"game:4[3-9]:*", "", []*gorilla.Conn{other}},
+		{"game:42:moves", "", []*gorilla.Conn{both}},
+		{"lobby:*", "", nil},
+	}
+	expected := make(map[*gorilla.Conn][]string)
+	for _, tt := range tests {
+		msg := "to " + tt.pattern
+		d, err := m.BroadcastToPattern(tt.pattern, []byte(msg), tt.exclude)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.pattern, err)
+		}
+		if d.Recipients != len(tt.want) || d.Delivered != len(tt.want) {
+			t.Errorf("%s: delivery %+v, want %d recipients", tt.pattern, d, len(tt.want))
+		}
+		for _, conn := range tt.want {
+			expected[conn] = append(expected[conn], msg)
+		}
+	}
+	// Each gets what it was sent, once even as a member of several
+	// matching rooms, and nothing else before the last message.
+	if _, err := m.BroadcastToPattern("**", []byte("end"), ""); err != nil {
+		t.Fatal(err)
+	}
+	for i, conn := range all {
+		for _, msg := range append(expected[conn], "end") {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, got, err := conn.ReadMessage()
+			if err != nil || string(got) != msg {
+				t.Errorf("connection %d read %q, %v; want %q", i, got, err, msg)
+				break
+			}
+		}
+	}
+
+	if _, err := m.BroadcastToPattern("game:[", []byte("x"), ""); err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
+
+func TestBroadcastPatternCommand(t *testing.T) {
+	php := &fakePHP{}
+	php.setReply(onMessage(func(text string) *protocol.Frame {
+		return command(t, protocol.StreamCommand{Event: protocol.EventBroadcastPattern, Room: "match:1:*", Data: []byte(text), ExcludeSender: true})
+	}))
+	m, url := servePHP(t, php)
+	sender, _ := member(t, m, php, url, "match:1:red")
+	red, _ := member(t, m, php, url, "match:1:red")
+	blue, _ := member(t, m, php, url, "match:1:blue")
+
+	send(t, sender, "go")
+	expectBroadcast(t, red, "go")
+	expectBroadcast(t, blue, "go")
+	m.BroadcastToRoom("match:1:red", []byte("next"), "")
+	expectBroadcast(t, sender, "next")
+}
+
+func TestBroadcastPatternWhileRoomsChange(t *testing.T) {
+	php := &fakePHP{}
+	m, url := servePHP(t, php)
+	stable, _ := member(t, m, php, url, "game:7:stable")
+	_, churnID := member(t, m, php, url)
+
+	// Rooms matching the pattern come and go during the broadcasts.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			room := fmt.Sprintf("game:7:room%d", i%10)
+			m.JoinRoom(churnID, room)
+			m.LeaveRoom(churnID, room)
+		}
+	}()
+
+	const messages = 100
+	for i := range messages {
+		if d, err := m.BroadcastToPattern("game:7:*", []byte(fmt.Sprint(i)), ""); err != nil || d.Delivered < 1 {
+			t.Fatalf("broadcast %d: %+v, %v", i, d, err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	for i := range messages {
+		expectBroadcast(t, stable, fmt.Sprint(i))
+	}
+	rooms, err := m.Rooms("game:7:**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].Name != "game:7:stable" {
+		t.Errorf("rooms left = %+v, want only game:7:stable", rooms)
+	}
+}
+
+func TestRoomMaxSize(t *testing.T) {
+	php := &fakePHP{}
+	php.setReply(func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		if h.Event != protocol.EventConnect {
+			return nil
+		}
+		return command(t, protocol.StreamCommand{Event: protocol.EventJoin, Room: "duel", MaxSize: 2})
+	})
+	m, url := servePHP(t, php)
+	before := counter(t, "maboo_websocket_room_full_total", "")
+
+	connect(t, php, url)
+	connect(t, php, url)
+	waitRoom(t, m, "duel", 2)
+	_, third := connect(t, php, url)
+
+	full := php.waitSeen(t, protocol.EventRoomFull)
+	if full[0].ConnectionID != third || full[0].Room != "duel" {
+		t.Errorf("room_full event = %+v, want %s refused from duel", full[0], third)
+	}
+	if n := m.RoomStats()["duel"]; n != 2 {
+		t.Errorf("duel has %d members, want 2", n)
+	}
+	if got := counter(t, "maboo_websocket_room_full_total", "") - before; got != 1 {
+		t.Errorf("refused joins counted %g, want 1", got)
+	}
+
+	rooms, err := m.Rooms("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].Name != "duel" || rooms[0].Members != 2 || rooms[0].MaxSize != 2 ||
+		time.Since(rooms[0].Created) > time.Minute {
+		t.Errorf("rooms = %+v, want duel with 2 of 2 members", rooms)
+	}
+
+	// The default limit applies to rooms created afterwards.
+	m.SetMaxRoomSize(1)
+	_, id := connect(t, php, url)
+	if !m.JoinRoom(id, "solo") || m.JoinRoom(third, "solo") {
+		t.Error("solo room took more than one member")
+	}
+}
@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/protocol"
+)
+
+// ticker sends PHP tick events at an interval.
+type ticker struct {
+	stop     chan struct{}
+	done     chan struct{}
+	inFlight sync.Mutex // held while a tick is with PHP
+}
+
+// SetWorkersSaturated sets how ticks find out that the PHP workers have
+// none free, in which case the tick is skipped instead of waiting for one.
+// Ticks are also skipped while the previous one is still being handled.
+func (m *Manager) SetWorkersSaturated(fn func() bool) {
+	m.mu.Lock()
+	m.saturated = fn
+	m.mu.Unlock()
+}
+
+// StartTicker sends PHP a tick event every interval until Shutdown or
+// StopTicker, replacing any ticker already running. PHP's answer is
+// carried out like any other, except that commands have no connection to
+// default to.
+func (m *Manager) StartTicker(interval time.Duration) {
+	m.StopTicker()
+	t := &ticker{stop: make(chan struct{}), done: make(chan struct{})}
+	m.mu.Lock()
+	m.ticker = t
+	m.mu.Unlock()
+	go m.tickLoop(t, interval)
+}
+
+// StopTicker stops the ticker, if any, and waits for the tick being
+// handled to finish.
+func (m *Manager) StopTicker() {
+	m.mu.Lock()
+	t := m.ticker
+	m.ticker = nil
+	m.mu.Unlock()
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+}
+
+func (m *Manager) tickLoop(t *ticker, interval time.Duration) {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		close(t.done)
+	}()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case now := <-tick.C:
+			m.mu.RLock()
+			saturated := m.saturated
+			m.mu.RUnlock()
+			if saturated != nil && saturated() {
+				ticksTotal.WithLabelValues(tickSaturated).Inc()
+				continue
+			}
+			if !t.inFlight.TryLock() {
+				ticksTotal.WithLabelValues(tickBusy).Inc()
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer t.inFlight.Unlock()
+				m.tick(now)
+			}()
+		}
+	}
+}
+
+// tick tells PHP the time and how many connections, rooms and users there
+// are, and carries out its answer.
+func (m *Manager) tick(now time.Time) {
+	stats := m.Stats()
+	payload, err := protocol.MarshalMsgpack(protocol.TickInfo{
+		Time:        now.UnixMilli(),
+		Connections: stats.TotalConnections,
+		Rooms:       stats.TotalRooms,
+		Users:       stats.TotalUsers,
+	})
+	if err != nil {
+		m.logger.Error("encoding tick", "error", err)
+		return
+	}
+	ticksTotal.WithLabelValues(tickSent).Inc()
+	m.notify(nil, protocol.StreamHeader{Event: protocol.EventTick}, payload, true)
+}
@@ -0,0 +1,126 @@
+package websocket_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+const tickInterval = 50 * time.Millisecond
+
+// serveTicks serves connections that join the lobby, with a tick every
+// tickInterval answered by reply.
+func serveTicks(t *testing.T, reply func(h *protocol.StreamHeader, data []byte) *protocol.Frame) (*fakePHP, *websocket.Manager, string) {
+	t.Helper()
+	cfg := config.Default().WebSocket
+	cfg.TickInterval = config.Duration(tickInterval)
+	m, url := serve(t, cfg, nil)
+	t.Cleanup(m.StopTicker)
+	php := &fakePHP{}
+	php.setReply(acceptUser(t, reply))
+	m.SetPHPForwarder(php.forward)
+	return php, m, url
+}
+
+func TestTickerRoutesAnswers(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+		infos []protocol.TickInfo
+	)
+	php, m, url := serveTicks(t, func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		if h.Event != protocol.EventTick {
+			return nil
+		}
+		var info protocol.TickInfo
+		if err := protocol.UnmarshalMsgpack(data, &info); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		times = append(times, time.Now())
+		infos = append(infos, info)
+		mu.Unlock()
+		if info.Connections == 0 {
+			return nil
+		}
+		return command(t, protocol.StreamCommand{Event: protocol.EventMessage, Room: "lobby", Data: []byte("tick")})
+	})
+	conn, _ := connect(t, php, url)
+	waitRoom(t, m, "lobby", 1)
+	expectBroadcast(t, conn, "tick")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(php.seen(protocol.EventTick)) < 5 {
+		if time.Now().After(deadline) {
+			t.Fatal("fewer than 5 ticks in 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, h := range php.seen(protocol.EventTick) {
+		if h.ConnectionID != "" {
+			t.Errorf("tick for connection %q, want none", h.ConnectionID)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < tickInterval/4 {
+			t.Errorf("ticks %d and %d came %s apart, want about %s", i-1, i, gap, tickInterval)
+		}
+	}
+	last := infos[len(infos)-1]
+	if last.Connections != 1 || last.Rooms != 1 || last.Time == 0 {
+		t.Errorf("tick info = %+v, want 1 connection in 1 room", last)
+	}
+}
+
+func TestTickerSkipsWhenSaturated(t *testing.T) {
+	before := counter(t, "maboo_websocket_ticks_total", "saturated")
+	php, m, _ := serveTicks(t, nil)
+	m.SetWorkersSaturated(func() bool { return true })
+
+	time.Sleep(5 * tickInterval)
+	if got := counter(t, "maboo_websocket_ticks_total", "saturated") - before; got < 2 {
+		t.Errorf("skipped %g ticks as saturated, want at least 2", got)
+	}
+	// The first tick may have been sent before the workers were saturated.
+	if n := len(php.seen(protocol.EventTick)); n > 1 {
+		t.Errorf("%d ticks sent to saturated workers", n)
+	}
+}
+
+func TestTickerSkipsWhileBusy(t *testing.T) {
+	before := counter(t, "maboo_websocket_ticks_total", "busy")
+	release := make(chan struct{})
+	php, m, _ := serveTicks(t, func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		<-release
+		return nil
+	})
+
+	time.Sleep(5 * tickInterval)
+	if n := len(php.seen(protocol.EventTick)); n != 1 {
+		t.Errorf("%d ticks sent while the first was handled, want 1", n)
+	}
+	if got := counter(t, "maboo_websocket_ticks_total", "busy") - before; got < 2 {
+		t.Errorf("skipped %g ticks as busy, want at least 2", got)
+	}
+
+	// Shutdown waits for the tick in progress, then no more are sent.
+	stopped := make(chan error)
+	go func() { stopped <- m.Shutdown(context.Background()) }()
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	n := len(php.seen(protocol.EventTick))
+	time.Sleep(3 * tickInterval)
+	if after := len(php.seen(protocol.EventTick)); after != n {
+		t.Errorf("%d ticks sent after shutdown", after-n)
+	}
+}
@@ -0,0 +1,109 @@
+package websocket
+
+import "slices"
+
+// Presence describes who is in a room.
+type Presence struct {
+	Room        string         `json:"room" msgpack:"room"`
+	Users       map[string]int `json:"users" msgpack:"users"`             // user ID → connections in the room
+	Connections int            `json:"connections" msgpack:"connections"` // every member, with or without a user
+}
+
+// setUserLocked assigns client to userID, moving it from the user it had.
+// The caller holds mu.
+func (m *Manager) setUserLocked(client *Client, userID string) {
+	if client.UserID == userID {
+		return
+	}
+	m.removeUserLocked(client)
+	client.UserID = userID
+	conns, ok := m.users[userID]
+	if !ok {
+		conns = make(map[string]*Client)
+		m.users[userID] = conns
+	}
+	conns[client.ID] = client
+}
+
+// removeUserLocked takes client out of its user's connections. The caller
+// holds mu.
+func (m *Manager) removeUserLocked(client *Client) {
+	if client.UserID == "" {
+		return
+	}
+	if conns, ok := m.users[client.UserID]; ok {
+		delete(conns, client.ID)
+		if len(conns) == 0 {
+			delete(m.users, client.UserID)
+		}
+	}
+}
+
+// UserID returns the user PHP assigned to a connection when accepting it,
+// or "" if none.
+func (m *Manager) UserID(connID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if client, ok := m.clients[connID]; ok {
+		return client.UserID
+	}
+	return ""
+}
+
+// SendToUser sends a message to every connection PHP assigned to userID
+// and returns how many it was queued for.
+func (m *Manager) SendToUser(userID string, data []byte, excludeID string) int {
+	m.mu.RLock()
+	clients := slices.DeleteFunc(m.userClientsLocked(userID), func(c *Client) bool { return c.ID == excludeID })
+	m.mu.RUnlock()
+	return m.broadcast(clients, data, "").Delivered
+}
+
+// DisconnectUser closes every connection of userID with code and reason
+// once the messages queued for them are written, and returns how many it
+// closed.
+func (m *Manager) DisconnectUser(userID string, code int, reason string) int {
+	m.mu.RLock()
+	clients := m.userClientsLocked(userID)
+	var dropped []*session
+	for _, c := range clients {
+		if s := m.dropped[c.ID]; s != nil && s.ghost == c {
+			dropped = append(dropped, s)
+		}
+	}
+	m.mu.RUnlock()
+	for _, c := range clients {
+		c.close(code, reason)
+	}
+	// A dropped connection must not come back by resuming.
+	for _, s := range dropped {
+		m.endDropped(s, endClosed)
+	}
+	return len(clients)
+}
+
+// Presence returns the users with a connection in room.
+func (m *Manager) Presence(room string) Presence {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p := Presence{Room: room, Users: make(map[string]int)}
+	for _, c := range m.membersLocked(room) {
+		p.Connections++
+		if c.UserID != "" {
+			p.Users[c.UserID]++
+		}
+	}
+	return p
+}
+
+func (m *Manager) userClientsLocked(userID string) []*Client {
+	if userID == "" {
+		return nil
+	}
+	clients := make([]*Client, 0, len(m.users[userID]))
+	for _, c := range m.users[userID] {
+		clients = append(clients, c)
+	}
+	return clients
+}
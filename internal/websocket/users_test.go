@@ -0,0 +1,189 @@
+package websocket_test
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/sadewadee/maboo/internal/protocol"
+	"github.com/sadewadee/maboo/internal/websocket"
+)
+
+// acceptUser answers connect by joining the lobby and, for a ?user= query,
+// accepting the connection as that user. Other events go to then.
+func acceptUser(t *testing.T, then func(*protocol.StreamHeader, []byte) *protocol.Frame) func(*protocol.StreamHeader, []byte) *protocol.Frame {
+	return func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		if h.Event != protocol.EventConnect {
+			if then == nil {
+				return nil
+			}
+			return then(h, data)
+		}
+		var info protocol.ConnectInfo
+		if err := protocol.UnmarshalMsgpack(data, &info); err != nil {
+			t.Error(err)
+			return nil
+		}
+		cmds := []protocol.StreamCommand{{Event: protocol.EventJoin, Room: "lobby"}}
+		q, _ := url.ParseQuery(info.Query)
+		if user := q.Get("user"); user != "" {
+			cmds = append(cmds, protocol.StreamCommand{Event: protocol.EventAccept, UserID: user})
+		}
+		return batch(t, cmds...)
+	}
+}
+
+func waitUsers(t *testing.T, m *websocket.Manager, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Stats().TotalUsers != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("users = %d, want %d", m.Stats().TotalUsers, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUserCommands(t *testing.T) {
+	php := &fakePHP{}
+	php.setReply(acceptUser(t, onMessage(func(text string) *protocol.Frame {
+		switch text {
+		case "dm":
+			return command(t, protocol.StreamCommand{Event: protocol.EventMessage, UserID: "42", Data: []byte("hi 42")})
+		case "who":
+			return command(t, protocol.StreamCommand{Event: protocol.EventPresence, Room: "lobby"})
+		case "kick":
+			return command(t, protocol.StreamCommand{Event: protocol.EventClose, UserID: "42", Code: 4001, Data: []byte("kicked")})
+		}
+		return nil
+	})))
+	m, url := servePHP(t, php)
+	phone, _ := connect(t, php, url+"?user=42")
+	laptop, _ := connect(t, php, url+"?user=42")
+	connect(t, php, url+"?user=7")
+	anon, _ := connect(t, php, url)
+	waitRoom(t, m, "lobby", 4)
+	waitUsers(t, m, 2)
+
+	p := m.Presence("lobby")
+	if p.Connections != 4 || len(p.Users) != 2 || p.Users["42"] != 2 || p.Users["7"] != 1 {
+		t.Errorf("presence = %+v, want users 42 x2 and 7 in 4 connections", p)
+	}
+
+	send(t, anon, "dm")
+	expectBroadcast(t, phone, "hi 42")
+	expectBroadcast(t, laptop, "hi 42")
+
+	send(t, anon, "who")
+	answers := php.waitSeen(t, protocol.EventPresence)
+	if answers[0].Room != "lobby" {
+		t.Errorf("presence answer for room %q, want lobby", answers[0].Room)
+	}
+
+	send(t, anon, "kick")
+	for _, conn := range []*gorilla.Conn{phone, laptop} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err := conn.ReadMessage()
+		var ce *gorilla.CloseError
+		if !errors.As(err, &ce) || ce.Code != 4001 {
+			t.Errorf("read after kick: %v, want close 4001", err)
+		}
+	}
+	waitConnections(t, m, 2)
+	waitUsers(t, m, 1)
+	if n := m.SendToUser("42", []byte("gone"), ""); n != 0 {
+		t.Errorf("SendToUser after disconnect reached %d connections", n)
+	}
+}
+
+func TestPresenceAnswerPayload(t *testing.T) {
+	php := &fakePHP{}
+	payloads := make(chan []byte, 1)
+	php.setReply(acceptUser(t, func(h *protocol.StreamHeader, data []byte) *protocol.Frame {
+		switch h.Event {
+		case protocol.EventMessage:
+			return command(t, protocol.StreamCommand{Event: protocol.EventPresence, Room: "lobby"})
+		case protocol.EventPresence:
+			payloads <- data
+			// Asking again from the answer must not loop.
+			return command(t, protocol.StreamCommand{Event: protocol.EventPresence, Room: "lobby"})
+		}
+		return nil
+	}))
+	m, url := servePHP(t, php)
+	conn, _ := connect(t, php, url+"?user=42")
+	waitRoom(t, m, "lobby", 1)
+
+	send(t, conn, "who")
+	var p websocket.Presence
+	select {
+	case data := <-payloads:
+		if err := protocol.UnmarshalMsgpack(data, &p); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no presence answer")
+	}
+	if p.Room != "lobby" || p.Connections != 1 || p.Users["42"] != 1 {
+		t.Errorf("presence = %+v, want user 42 alone in lobby", p)
+	}
+	if got := php.seen(protocol.EventPresence); len(got) != 1 {
+		t.Errorf("%d presence answers, want 1", len(got))
+	}
+}
+
+func TestUserIndexConcurrent(t *testing.T) {
+	php := &fakePHP{}
+	php.setReply(acceptUser(t, nil))
+	m, url := servePHP(t, php)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				conn, _, err := gorilla.DefaultDialer.Dial(url+"?user=42", nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				m.SendToUser("42", []byte("ping"), "")
+				m.Presence("lobby")
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitConnections(t, m, 0)
+	waitUsers(t, m, 0)
+	if p := m.Presence("lobby"); p.Connections != 0 || len(p.Users) != 0 {
+		t.Errorf("presence after every connection closed = %+v", p)
+	}
+}
+
+func TestDisconnectUser(t *testing.T) {
+	php := &fakePHP{}
+	php.setReply(acceptUser(t, nil))
+	m, url := servePHP(t, php)
+	a, _ := connect(t, php, url+"?user=42")
+	b, _ := connect(t, php, url+"?user=42")
+	connect(t, php, url+"?user=7")
+	waitUsers(t, m, 2)
+
+	if n := m.DisconnectUser("42", gorilla.ClosePolicyViolation, "banned"); n != 2 {
+		t.Errorf("DisconnectUser closed %d connections, want 2", n)
+	}
+	for _, conn := range []*gorilla.Conn{a, b} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := conn.ReadMessage(); !gorilla.IsCloseError(err, gorilla.ClosePolicyViolation) {
+			t.Errorf("read after disconnect: %v, want policy violation", err)
+		}
+	}
+	waitConnections(t, m, 1)
+	waitUsers(t, m, 1)
+}
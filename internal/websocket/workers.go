@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/features"
+	"github.com/sadewadee/maboo/internal/pool"
+)
+
+// NewWorkerGroup creates the PHP workers that handle WebSocket events:
+// websocket.worker run in a pool of its own, sized by websocket.min_workers
+// and max_workers, so a burst of messages waits for these workers and never
+// takes one rendering pages. Pass its ExecStream to SetPHPForwarder; events
+// for a connection then go to the same worker whenever it is free.
+func NewWorkerGroup(cfg *config.Config, logger *slog.Logger) (*pool.Pool, error) {
+	if cfg.PHP.Binary == "" {
+		return nil, errors.New("websocket worker group needs php.binary to run websocket.worker")
+	}
+	return newWorkerGroup(cfg, cfg.WebSocket.Worker, logger.With("pool", "websocket")), nil
+}
+
+// NewProtocolWorkerGroups creates a worker group, sized like the one
+// NewWorkerGroup creates, for each of websocket.subprotocols that names a
+// worker of its own. They are keyed by subprotocol; pass their ExecStream
+// to SetProtocolForwarder.
+func NewProtocolWorkerGroups(cfg *config.Config, logger *slog.Logger) (map[string]*pool.Pool, error) {
+	groups := make(map[string]*pool.Pool)
+	for _, sp := range cfg.WebSocket.Subprotocols {
+		if sp.Worker == "" {
+			continue
+		}
+		if cfg.PHP.Binary == "" {
+			return nil, errors.New("websocket subprotocol worker groups need php.binary to run their workers")
+		}
+		groups[sp.Name] = newWorkerGroup(cfg, sp.Worker, logger.With("pool", "websocket", "subprotocol", sp.Name))
+	}
+	return groups, nil
+}
+
+func newWorkerGroup(cfg *config.Config, script string, logger *slog.Logger) *pool.Pool {
+	php := cfg.PHP
+	php.Worker = script
+	p := pool.New(cfg.WebSocketPool(), php, logger)
+	p.SetFeatures(features.Offered(cfg))
+	return p
+}
@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// ErrNoWorker is returned by Dump and Kill for a worker the pool does not
+// have, as one already recycled.
+var ErrNoWorker = errors.New("no such worker")
+
+// Activity is the request a worker is executing.
+type Activity struct {
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	RequestID string    `json:"request_id,omitempty"`
+	Started   time.Time `json:"started_at"`
+}
+
+// newActivity describes the request of ctx, which starts now.
+func newActivity(ctx *phpengine.Context) *Activity {
+	return &Activity{
+		Method:    ctx.Server["REQUEST_METHOD"],
+		Path:      ctx.Server["REQUEST_URI"],
+		RequestID: ctx.Server["HTTP_X_REQUEST_ID"],
+		Started:   time.Now(),
+	}
+}
+
+// Dump is what a worker is doing, as the admin API reports it.
+type Dump struct {
+	ID             int       `json:"id"`
+	State          string    `json:"state"`
+	Jobs           int64     `json:"jobs"`
+	Request        *Activity `json:"request,omitempty"`         // nil while idle
+	ElapsedSeconds float64   `json:"elapsed_seconds,omitempty"` // since Request started
+	Backtrace      string    `json:"backtrace,omitempty"`       // the PHP stack of Request
+	BacktraceError string    `json:"backtrace_error,omitempty"` // why a busy worker has no Backtrace
+}
+
+// SetRequest fills in the request of d and the time it has been running.
+func (d *Dump) SetRequest(a *Activity) {
+	if a == nil {
+		return
+	}
+	d.Request = a
+	d.ElapsedSeconds = time.Since(a.Started).Seconds()
+}
+
+// String returns the state as Dump reports it.
+func (s WorkerState) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateBusy:
+		return "busy"
+	default:
+		return "stopped"
+	}
+}
+
+// Dump reports what worker w is doing, with the PHP backtrace of its
+// request when it is executing one.
+func (w *Worker) Dump() Dump {
+	d := Dump{ID: w.id, State: w.State().String(), Jobs: w.Jobs()}
+	d.SetRequest(w.current.Load())
+	if d.Request == nil {
+		return d
+	}
+	trace, err := w.engine.Backtrace()
+	if err != nil {
+		d.BacktraceError = err.Error()
+	}
+	d.Backtrace = trace
+	return d
+}
+
+// worker returns the pool's worker id, or nil.
+func (p *Pool) worker(id int) *Worker {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, w := range p.workers {
+		if w.ID() == id {
+			return w
+		}
+	}
+	return nil
+}
+
+// Dump reports what worker id is doing; see Worker.Dump.
+func (p *Pool) Dump(id int) (Dump, error) {
+	w := p.worker(id)
+	if w == nil {
+		return Dump{}, ErrNoWorker
+	}
+	return w.Dump(), nil
+}
+
+// Busy reports the workers executing a request, the longest-running
+// first, without their backtraces.
+func (p *Pool) Busy() []Dump {
+	p.mu.RLock()
+	workers := slices.Clone(p.workers)
+	p.mu.RUnlock()
+	var busy []Dump
+	for _, w := range workers {
+		d := Dump{ID: w.id, State: w.State().String(), Jobs: w.Jobs()}
+		if d.SetRequest(w.current.Load()); d.Request != nil {
+			busy = append(busy, d)
+		}
+	}
+	slices.SortFunc(busy, func(a, b Dump) int { return a.Request.Started.Compare(b.Request.Started) })
+	return busy
+}
+
+// Kill replaces worker id. An idle worker is replaced at once. A busy one
+// has its script interrupted and is replaced when its request returns,
+// which then fails.
+func (p *Pool) Kill(id int) error {
+	w := p.worker(id)
+	if w == nil || w.State() == StateStopped {
+		return ErrNoWorker
+	}
+	if !w.killed.CompareAndSwap(false, true) {
+		return nil // already being replaced
+	}
+	if p.takeIdle(w) {
+		p.recycleKilled(w)
+		return nil
+	}
+	// Exec, or acquire if the worker goes back meanwhile, replaces it.
+	w.engine.Interrupt()
+	return nil
+}
+
+// recycleKilled replaces w, which Kill marked, now that the pool holds it.
+func (p *Pool) recycleKilled(w *Worker) {
+	if p.logger != nil {
+		p.logger.Info("worker killed", "worker_id", w.ID(), "jobs", w.Jobs())
+	}
+	metrics.RecordWorkerRecycle(metrics.RecycleKilled)
+	go p.replaceWorker(w)
+}
+
+// takeIdle takes w off the idle queue and reports whether it was there.
+// The other idle workers it passes over are put back.
+func (p *Pool) takeIdle(w *Worker) bool {
+	var passed []*Worker
+	defer func() {
+		for _, other := range passed {
+			p.release(other)
+		}
+	}()
+	for range len(p.available) {
+		select {
+		case idle := <-p.available:
+			if idle == w {
+				return true
+			}
+			passed = append(passed, idle)
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
@@ -9,6 +10,8 @@ import (
 	"time"
 
 	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/features"
+	"github.com/sadewadee/maboo/internal/metrics"
 	"github.com/sadewadee/maboo/internal/phpengine"
 )
 
@@ -22,8 +25,9 @@ type StatsGetter interface {
 
 // Pool manages embedded PHP workers.
 type Pool struct {
-	cfg    *config.Config
-	logger *slog.Logger
+	cfg     atomic.Pointer[config.Config]
+	offered atomic.Pointer[features.Set] // features.Offered(cfg)
+	logger  *slog.Logger
 
 	workers   []*Worker
 	mu        sync.RWMutex
@@ -33,6 +37,19 @@ type Pool struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	// Scaling: spawns under way (guarded by mu), requests past the spawn
+	// probe, the limiter on demand spawns, and the watchdog's smoothed
+	// busy percentage.
+	spawning   int
+	waiting    atomic.Int32
+	spawnLimit spawnLimiter
+	load       float64
+
+	// pool.on_demand: when a request last asked for a worker, and when a
+	// cold start last failed (0 once one succeeds), as Unix nanoseconds.
+	lastUsed   atomic.Int64
+	coldFailed atomic.Int64
+
 	// Metrics
 	totalRequests atomic.Int64
 	activeWorkers atomic.Int32
@@ -43,12 +60,38 @@ type Pool struct {
 func NewPool(cfg *config.Config) *Pool {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Pool{
-		cfg:       cfg,
+	p := &Pool{
 		available: make(chan *Worker, cfg.Pool.MaxWorkers),
 		ctx:       ctx,
 		cancel:    cancel,
 	}
+	p.store(cfg)
+	return p
+}
+
+// store makes cfg the running configuration.
+func (p *Pool) store(cfg *config.Config) {
+	offered := features.Offered(cfg)
+	p.offered.Store(&offered)
+	p.cfg.Store(cfg)
+}
+
+// config returns the running configuration. Callers must treat it as
+// read-only; SetConfig replaces it wholesale.
+func (p *Pool) config() *config.Config {
+	return p.cfg.Load()
+}
+
+// SetConfig swaps in a new configuration. Pool sizing, max_jobs and the
+// timeouts take effect immediately; PHP and app settings apply to workers
+// spawned afterwards (call Reload to respawn them all). max_workers cannot
+// grow past the value the pool was started with.
+func (p *Pool) SetConfig(cfg *config.Config) error {
+	if cfg.Pool.MaxWorkers > cap(p.available) {
+		return fmt.Errorf("pool.max_workers %d exceeds the startup capacity of %d (restart required)", cfg.Pool.MaxWorkers, cap(p.available))
+	}
+	p.store(cfg)
+	return nil
 }
 
 // SetLogger sets the pool logger.
@@ -58,20 +101,28 @@ func (p *Pool) SetLogger(logger *slog.Logger) {
 
 // Mode returns the execution mode (worker/request).
 func (p *Pool) Mode() string {
-	return p.cfg.PHP.Mode
+	return p.config().PHP.Mode
 }
 
 // Start initializes the pool.
 func (p *Pool) Start() error {
+	cfg := p.config()
 	if p.logger != nil {
 		p.logger.Info("starting embedded worker pool",
-			"mode", p.cfg.PHP.Mode,
-			"min_workers", p.cfg.Pool.MinWorkers,
-			"max_workers", p.cfg.Pool.MaxWorkers,
+			"mode", cfg.PHP.Mode,
+			"min_workers", cfg.Pool.MinWorkers,
+			"max_workers", cfg.Pool.MaxWorkers,
+			"on_demand", cfg.Pool.OnDemand,
 		)
 	}
 
-	for i := 0; i < p.cfg.Pool.MinWorkers; i++ {
+	// An on-demand pool starts its first worker for its first request.
+	initial := cfg.Pool.MinWorkers
+	if cfg.Pool.OnDemand {
+		initial = 0
+	}
+	p.lastUsed.Store(time.Now().UnixNano())
+	for i := 0; i < initial; i++ {
 		w, err := p.spawnWorker()
 		if err != nil {
 			return fmt.Errorf("spawning initial worker %d: %w", i, err)
@@ -87,29 +138,152 @@ func (p *Pool) Start() error {
 func (p *Pool) Exec(reqCtx *phpengine.Context, script string) (*phpengine.Response, error) {
 	p.totalRequests.Add(1)
 
-	var w *Worker
-	select {
-	case w = <-p.available:
-	case <-time.After(p.cfg.Pool.AllocateTimeout.Duration()):
-		return nil, fmt.Errorf("no available worker within %s", p.cfg.Pool.AllocateTimeout.Duration())
-	case <-p.ctx.Done():
-		return nil, fmt.Errorf("pool shutting down")
+	waitStart := time.Now()
+	w, err := p.acquire()
+	if err != nil {
+		return nil, err
 	}
 
 	p.busyWorkers.Add(1)
 	defer p.busyWorkers.Add(-1)
 
+	if reqCtx.Server == nil {
+		reqCtx.Server = make(map[string]string)
+	}
+	features.Advertise(reqCtx.Server, *p.offered.Load())
+
+	execStart := time.Now()
+	reqCtx.PoolWait = execStart.Sub(waitStart)
+	reqCtx.WorkerID = w.ID()
+
 	resp, err := w.Exec(reqCtx, script)
+	reqCtx.ExecTime = time.Since(execStart)
+	if err != nil {
+		metrics.RecordWorkerExecError()
+	}
 
-	if w.NeedsRecycle() {
+	switch maxJobs := p.config().Pool.MaxJobs; {
+	case w.retired.Load():
+		p.release(w) // stops it; Reload queued its replacement
+	case w.killed.Load():
+		p.recycleKilled(w)
+	case errors.Is(err, phpengine.ErrResponseTooLarge):
+		metrics.RecordWorkerRecycle(metrics.RecycleResponse)
 		go p.replaceWorker(w)
-	} else {
-		p.available <- w
+	case maxJobs > 0 && w.Jobs() >= int64(maxJobs):
+		metrics.RecordWorkerRecycle(metrics.RecycleMaxJobs)
+		go p.replaceWorker(w)
+	default:
+		p.release(w)
+	}
+
+	return resp, err
+}
+
+// acquire waits for an idle worker, up to pool.allocate_timeout. A request
+// still waiting after spawnProbe asks for a worker to be started for it.
+// One that finds an on-demand pool scaled to zero starts its first worker
+// at once, and pays for it.
+func (p *Pool) acquire() (*Worker, error) {
+	start := time.Now()
+	p.lastUsed.Store(start.UnixNano())
+	cold := p.coldStart()
+	allocateTimeout := p.config().Pool.AllocateTimeout.Duration()
+	timer := time.NewTimer(allocateTimeout)
+	defer timer.Stop()
+	probe := time.NewTimer(spawnProbe)
+	defer probe.Stop()
+
+	waiting := false
+	defer func() {
+		if waiting {
+			p.waiting.Add(-1)
+		}
+	}()
+
+	for {
+		select {
+		case w := <-p.available:
+			// Workers retired by Reload may still be queued; drop them.
+			// One killed while it went back is replaced.
+			if w.killed.Load() {
+				p.recycleKilled(w)
+				continue
+			}
+			if w.retired.Load() {
+				p.release(w) // stops it
+				continue
+			}
+			if w.State() != StateStopped {
+				if cold {
+					metrics.ObserveColdStart(start)
+				}
+				return w, nil
+			}
+		case <-probe.C:
+			if !waiting {
+				waiting = true
+				p.waiting.Add(1)
+			}
+			// The pool may have scaled to zero as the request came in.
+			cold = p.coldStart() || cold
+			p.scaleOnDemand()
+			probe.Reset(spawnProbe)
+		case <-timer.C:
+			return nil, fmt.Errorf("no available worker within %s", allocateTimeout)
+		case <-p.ctx.Done():
+			return nil, fmt.Errorf("pool shutting down")
+		}
 	}
+}
 
+// Invoke runs r, a call into PHP from Go code, without going through HTTP.
+// Relative entries are taken from app.root. It is counted in the internal
+// request metrics under r.Caller instead of the HTTP ones.
+func (p *Pool) Invoke(ctx context.Context, r phpengine.InternalRequest) (*phpengine.Response, error) {
+	start := time.Now()
+	resp, err := phpengine.Invoke(ctx, p, p.config().App.Root, r)
+	status := 0
+	if resp != nil {
+		status = resp.Status
+	}
+	metrics.RecordInternalRequest(r.Caller, status, err, time.Since(start))
 	return resp, err
 }
 
+// Eval evaluates code, PHP without the opening tag, on an idle worker and
+// returns what it printed; see phpengine.Engine.Eval. The worker is
+// replaced afterwards, since the snippet may have changed its interpreter.
+// Eval returns when ctx is done, even if the snippet is still running; the
+// worker is replaced once it stops.
+func (p *Pool) Eval(ctx context.Context, code string, maxOutput int64) ([]byte, error) {
+	w, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := w.Eval(ctx, code, maxOutput)
+		done <- result{out, err}
+		if errors.Is(err, phpengine.ErrEvalUnavailable) {
+			p.release(w) // nothing ran
+			return
+		}
+		metrics.RecordWorkerRecycle(metrics.RecycleEval)
+		p.replaceWorker(w)
+	}()
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // Stop gracefully shuts down the pool.
 func (p *Pool) Stop() error {
 	if p.logger != nil {
@@ -131,8 +305,6 @@ func (p *Pool) Stop() error {
 		}(w)
 	}
 	wg.Wait()
-
-	close(p.available)
 	return nil
 }
 
@@ -153,11 +325,11 @@ func (p *Pool) Stats() StatsGetter {
 
 // PoolStats holds pool metrics.
 type PoolStats struct {
-	totalWorkers  int   `json:"total_workers"`
-	activeWorkers int   `json:"active_workers"`
-	busyWorkers   int   `json:"busy_workers"`
-	idleWorkers   int   `json:"idle_workers"`
-	totalRequests int64 `json:"total_requests"`
+	totalWorkers  int
+	activeWorkers int
+	busyWorkers   int
+	idleWorkers   int
+	totalRequests int64
 }
 
 // TotalWorkers returns the total number of workers.
@@ -180,16 +352,19 @@ func (s PoolStats) TotalRequests() int64 {
 	return s.totalRequests
 }
 
-func (p *Pool) spawnWorker() (*Worker, error) {
+func (p *Pool) spawnWorker() (w *Worker, err error) {
 	id := int(p.nextID.Add(1))
+	start := time.Now()
+	defer func() { metrics.ObserveWorkerSpawn(start, err) }()
 
-	w, err := NewWorker(id, p.cfg)
+	cfg := p.config()
+	w, err = NewWorker(id, cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	// In worker mode, start the PHP engine once
-	if p.cfg.PHP.Mode == "worker" {
+	if cfg.PHP.Mode == "worker" {
 		if err := w.Start(); err != nil {
 			return nil, fmt.Errorf("starting worker %d: %w", id, err)
 		}
@@ -218,7 +393,45 @@ func (p *Pool) replaceWorker(old *Worker) {
 		}
 		return
 	}
-	p.available <- w
+	p.release(w)
+}
+
+// release queues w for the next request, or stops it once the pool is
+// stopping or Reload retired it. The queue is never closed, as a replacement or a finished
+// request may still hand back a worker after Stop.
+func (p *Pool) release(w *Worker) {
+	if p.ctx.Err() == nil && !w.retired.Load() {
+		select {
+		case p.available <- w:
+			return
+		case <-p.ctx.Done():
+		}
+	}
+	w.Stop()
+	p.removeWorker(w)
+}
+
+// dropRetired stops the retired workers waiting in the idle queue and
+// queues the others again.
+func (p *Pool) dropRetired() {
+	var keep []*Worker
+drain:
+	for range len(p.available) {
+		select {
+		case w := <-p.available:
+			if !w.retired.Load() {
+				keep = append(keep, w)
+				continue
+			}
+			w.Stop()
+			p.removeWorker(w)
+		default:
+			break drain
+		}
+	}
+	for _, w := range keep {
+		p.release(w)
+	}
 }
 
 func (p *Pool) removeWorker(w *Worker) {
@@ -248,29 +461,23 @@ func (p *Pool) watchdog() {
 	}
 }
 
-func (p *Pool) autoScale() {
-	stats := p.Stats()
-
-	if stats.TotalWorkers() > 0 {
-		busyPct := float64(stats.BusyWorkers()) / float64(stats.TotalWorkers()) * 100
-		if busyPct >= 80 && stats.TotalWorkers() < p.cfg.Pool.MaxWorkers {
-			w, err := p.spawnWorker()
-			if err == nil {
-				p.available <- w
-			}
-		}
+// Invalidate drops files from every worker's opcache before its next
+// request. Unlike Reload, the workers and the rest of their cache stay.
+// It fails with phpengine.ErrInvalidateUnavailable when the engine cannot
+// invalidate; the caller should reload the workers instead.
+func (p *Pool) Invalidate(files []string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
-		if busyPct <= 20 && stats.TotalWorkers() > p.cfg.Pool.MinWorkers {
-			select {
-			case w := <-p.available:
-				go func() {
-					w.Stop()
-					p.removeWorker(w)
-				}()
-			default:
-			}
+	for _, w := range p.workers {
+		if err := w.Invalidate(files); err != nil {
+			return err
 		}
 	}
+	if p.logger != nil {
+		p.logger.Info("opcache invalidation queued", "files", len(files), "workers", len(p.workers))
+	}
+	return nil
 }
 
 // Reload gracefully replaces all workers.
@@ -284,12 +491,26 @@ func (p *Pool) Reload() error {
 	copy(oldWorkers, p.workers)
 	p.mu.RUnlock()
 
-	for i := 0; i < p.cfg.Pool.MinWorkers; i++ {
+	// The old workers are retired before their replacements are queued:
+	// the idle ones now, the busy ones as they finish. The queue holds
+	// max_workers, so a pool at that size has no room for a replacement
+	// until then.
+	for _, w := range oldWorkers {
+		w.retired.Store(true)
+	}
+	p.dropRetired()
+
+	// An on-demand pool that has scaled to zero stays there.
+	replacements := p.config().Pool.MinWorkers
+	if p.config().Pool.OnDemand {
+		replacements = min(len(oldWorkers), 1)
+	}
+	for i := 0; i < replacements; i++ {
 		w, err := p.spawnWorker()
 		if err != nil {
 			return fmt.Errorf("reload failed: %w", err)
 		}
-		p.available <- w
+		p.release(w)
 	}
 
 	go func() {
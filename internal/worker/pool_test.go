@@ -1,9 +1,14 @@
 package worker_test
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/features"
+	"github.com/sadewadee/maboo/internal/phpengine"
 	"github.com/sadewadee/maboo/internal/worker"
 )
 
@@ -45,3 +50,235 @@ func TestPoolStats(t *testing.T) {
 		t.Errorf("expected 0 total workers before start, got %d", stats.TotalWorkers())
 	}
 }
+
+func TestPoolInvalidateKeepsWorkers(t *testing.T) {
+	cfg := config.Default()
+	cfg.PHP.Mode = "worker"
+	cfg.Pool.MinWorkers = 2
+	cfg.Pool.MaxWorkers = 2
+
+	pool := worker.NewPool(cfg)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	// Without libphp the engine cannot reach its opcache, which the
+	// caller is told so that it reloads instead; nothing is queued.
+	if err := pool.Invalidate([]string{"/app/routes/web.php"}); !errors.Is(err, phpengine.ErrInvalidateUnavailable) {
+		t.Errorf("Invalidate() = %v, want ErrInvalidateUnavailable", err)
+	}
+
+	for range 4 {
+		ctx := &phpengine.Context{}
+		if _, err := pool.Exec(ctx, "/app/public/index.php"); err != nil {
+			t.Fatalf("exec after invalidate: %v", err)
+		}
+		if ctx.WorkerID > 2 {
+			t.Errorf("request served by worker %d, want one of the original two", ctx.WorkerID)
+		}
+	}
+	if n := pool.Stats().TotalWorkers(); n != 2 {
+		t.Errorf("TotalWorkers() = %d, want 2", n)
+	}
+}
+
+func TestPoolRecyclesOnOversizedResponse(t *testing.T) {
+	cfg := config.Default()
+	cfg.PHP.Mode = "worker"
+	cfg.Pool.MinWorkers = 1
+	cfg.Pool.MaxWorkers = 1
+
+	pool := worker.NewPool(cfg)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	ctx := &phpengine.Context{MaxResponseSize: 10}
+	if _, err := pool.Exec(ctx, "/app/public/index.php"); !errors.Is(err, phpengine.ErrResponseTooLarge) {
+		t.Fatalf("Exec() error = %v, want ErrResponseTooLarge", err)
+	}
+
+	// The worker that overran is replaced.
+	next := &phpengine.Context{}
+	if _, err := pool.Exec(next, "/app/public/index.php"); err != nil {
+		t.Fatal(err)
+	}
+	if next.WorkerID == ctx.WorkerID {
+		t.Errorf("worker %d served again after its response was too large", next.WorkerID)
+	}
+}
+
+func TestPoolInvoke(t *testing.T) {
+	cfg := config.Default()
+	cfg.App.Root = t.TempDir()
+	cfg.Pool.MinWorkers = 1
+	cfg.Pool.MaxWorkers = 1
+
+	pool := worker.NewPool(cfg)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	resp, err := pool.Invoke(context.Background(), phpengine.InternalRequest{Entry: "index.php", Caller: "test"})
+	if err != nil || resp.Status != 200 {
+		t.Fatalf("Invoke() = %v, %v", resp, err)
+	}
+	if got := pool.Stats().TotalRequests(); got != 1 {
+		t.Errorf("pool served %d requests, want 1", got)
+	}
+}
+
+func TestPoolEval(t *testing.T) {
+	cfg := config.Default()
+	cfg.PHP.Mode = "worker"
+	cfg.Pool.MinWorkers = 1
+	cfg.Pool.MaxWorkers = 1
+
+	pool := worker.NewPool(cfg)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	// The placeholder engine cannot evaluate; the worker goes back to
+	// serving requests.
+	if _, err := pool.Eval(context.Background(), "echo PHP_VERSION;", 1024); !errors.Is(err, phpengine.ErrEvalUnavailable) {
+		t.Fatalf("Eval() error = %v, want ErrEvalUnavailable", err)
+	}
+	if _, err := pool.Exec(&phpengine.Context{}, "/app/public/index.php"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.Eval(ctx, "echo 1;", 1024); !errors.Is(err, context.Canceled) {
+		t.Errorf("Eval() with a cancelled context: error = %v", err)
+	}
+}
+
+func TestPoolAdvertisesFeatures(t *testing.T) {
+	cfg := config.Default()
+	cfg.Debug.Stats = true
+	cfg.Pool.MinWorkers = 1
+	cfg.Pool.MaxWorkers = 1
+
+	pool := worker.NewPool(cfg)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	ctx := &phpengine.Context{}
+	if _, err := pool.Exec(ctx, "/app/public/index.php"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ctx.Server[features.FeaturesVar]; got != "stats,timeout,sendfile" {
+		t.Errorf("$_SERVER[MABOO_FEATURES] = %q", got)
+	}
+	if ctx.Server[features.VersionVar] == "" {
+		t.Error("$_SERVER[MABOO_VERSION] is empty")
+	}
+
+	// Turning debug.stats off stops advertising stats.
+	next := *cfg
+	next.Debug.Stats = false
+	if err := pool.SetConfig(&next); err != nil {
+		t.Fatal(err)
+	}
+	ctx = &phpengine.Context{}
+	if _, err := pool.Exec(ctx, "/app/public/index.php"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ctx.Server[features.FeaturesVar]; got != "timeout,sendfile" {
+		t.Errorf("$_SERVER[MABOO_FEATURES] after disabling debug.stats = %q", got)
+	}
+}
+
+// A pool at max_workers with every worker idle has a full queue; Reload
+// must make room for the replacements rather than wait for it.
+func TestPoolReloadAtMaxWorkers(t *testing.T) {
+	cfg := config.Default()
+	cfg.PHP.Mode = "worker"
+	cfg.Pool.MinWorkers = 2
+	cfg.Pool.MaxWorkers = 2
+
+	pool := worker.NewPool(cfg)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	done := make(chan error, 1)
+	go func() { done <- pool.Reload() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reload blocked")
+	}
+
+	for range 4 {
+		ctx := &phpengine.Context{}
+		if _, err := pool.Exec(ctx, "/app/public/index.php"); err != nil {
+			t.Fatal(err)
+		}
+		if ctx.WorkerID <= 2 {
+			t.Fatalf("worker %d served after the reload retired it", ctx.WorkerID)
+		}
+	}
+	if n := pool.Stats().TotalWorkers(); n != 2 {
+		t.Errorf("%d workers after the reload, want 2", n)
+	}
+}
+
+func TestPoolDumpAndKill(t *testing.T) {
+	cfg := config.Default()
+	cfg.PHP.Mode = "worker"
+	cfg.Pool.MinWorkers = 2
+	cfg.Pool.MaxWorkers = 2
+
+	pool := worker.NewPool(cfg)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	d, err := pool.Dump(1)
+	if err != nil || d.ID != 1 || d.State != "idle" || d.Request != nil || d.Backtrace != "" {
+		t.Fatalf("Dump(1) = %+v, %v", d, err)
+	}
+	if _, err := pool.Dump(99); !errors.Is(err, worker.ErrNoWorker) {
+		t.Errorf("Dump(99) error = %v, want ErrNoWorker", err)
+	}
+	if err := pool.Kill(99); !errors.Is(err, worker.ErrNoWorker) {
+		t.Errorf("Kill(99) error = %v, want ErrNoWorker", err)
+	}
+
+	if err := pool.Kill(1); err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		ctx := &phpengine.Context{}
+		if _, err := pool.Exec(ctx, "/app/public/index.php"); err != nil {
+			t.Fatal(err)
+		}
+		if ctx.WorkerID == 1 {
+			t.Fatal("killed worker served a request")
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := pool.Dump(1); errors.Is(err, worker.ErrNoWorker) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("killed worker still in the pool")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/metrics"
+)
+
+// spawnProbe is how long a request waits for an idle worker before asking
+// for a new one. Requests served within it never cause a spawn.
+const spawnProbe = 25 * time.Millisecond
+
+// loadSmoothing is the weight of the latest busy percentage in the load
+// the watchdog scales on. The rest is the previous load, so a single busy
+// or idle tick does not add a worker only to remove it on the next.
+const loadSmoothing = 0.5
+
+// coldRetry is how long after a failed cold start requests to an empty
+// on-demand pool wait before starting another.
+const coldRetry = time.Second
+
+// autoScale runs on every watchdog tick: it adds a worker while the
+// smoothed load is at or above 80% and stops an idle one at or below 20%,
+// keeping pool.min_workers. An on-demand pool keeps one until no request
+// has come for pool.idle_timeout, then stops every idle worker.
+func (p *Pool) autoScale() {
+	stats := p.Stats()
+	cfg := p.config()
+	floor := cfg.Pool.MinWorkers
+	if cfg.Pool.OnDemand {
+		floor = 1
+		if idle := time.Since(time.Unix(0, p.lastUsed.Load())); idle >= cfg.Pool.IdleTimeout.Duration() {
+			p.scaleToZero(idle)
+			return
+		}
+	}
+	if stats.TotalWorkers() == 0 {
+		return
+	}
+
+	busyPct := float64(stats.BusyWorkers()) / float64(stats.TotalWorkers()) * 100
+	p.load = loadSmoothing*busyPct + (1-loadSmoothing)*p.load
+
+	if p.load >= 80 && p.reserveSpawn(metrics.ScaleTick) {
+		p.grow(metrics.ScaleTick, "load", math.Round(p.load))
+	}
+
+	if p.load <= 20 && stats.TotalWorkers() > floor {
+		select {
+		case w := <-p.available:
+			go func() {
+				w.Stop()
+				p.removeWorker(w)
+			}()
+		default:
+		}
+	}
+}
+
+// scaleToZero stops the idle workers of an on-demand pool that has had no
+// request for idle. A busy one is stopped on a later tick, once done. The
+// workers are forgotten before they stop, so that a request coming in
+// meanwhile finds the pool empty and starts a new one.
+func (p *Pool) scaleToZero(idle time.Duration) {
+	stopped := 0
+drain:
+	for {
+		select {
+		case w := <-p.available:
+			if w.State() == StateStopped {
+				continue // retired by Reload while queued
+			}
+			p.removeWorker(w)
+			go w.Stop()
+			stopped++
+		default:
+			break drain
+		}
+	}
+	if stopped == 0 {
+		return
+	}
+	p.load = 0
+	if p.logger != nil {
+		p.logger.Info("scaling down idle on-demand pool", "stopped", stopped,
+			"workers", p.Stats().TotalWorkers(), "idle_for", idle.Round(time.Second).String())
+	}
+}
+
+// coldStart starts the first worker of an on-demand pool that has none,
+// for a request that found it so. Requests arriving together share that
+// one spawn: reserveSpawn admits a cold start only while no worker exists
+// or is being started. coldStart reports whether the pool was empty.
+func (p *Pool) coldStart() bool {
+	if !p.config().Pool.OnDemand {
+		return false
+	}
+	p.mu.RLock()
+	empty := len(p.workers) == 0
+	p.mu.RUnlock()
+	if !empty {
+		return false
+	}
+	if p.reserveSpawn(metrics.ScaleCold) {
+		go p.grow(metrics.ScaleCold)
+	}
+	return true
+}
+
+// Dormant reports whether the pool is on demand and has scaled to zero,
+// its last cold start, if any, having succeeded: it has no workers, but
+// will start one for the next request. Readiness counts it as ready.
+func (p *Pool) Dormant() bool {
+	return p.config().Pool.OnDemand && p.Stats().TotalWorkers() == 0 && p.coldFailed.Load() == 0
+}
+
+// scaleOnDemand starts a worker for a request that has waited spawnProbe
+// without finding one idle, instead of leaving it to the next tick. It
+// starts no more spawns than there are requests waiting, and no more than
+// pool.spawn_rate a second.
+func (p *Pool) scaleOnDemand() {
+	if p.config().Pool.SpawnRate <= 0 {
+		return
+	}
+	if p.reserveSpawn(metrics.ScaleDemand) {
+		go p.grow(metrics.ScaleDemand, "waiting", p.waiting.Load())
+	}
+}
+
+// reserveSpawn claims room under pool.max_workers for one worker, counting
+// the spawns already under way. A demand spawn also needs a request left
+// without one and a token from the limiter; a cold start, an empty pool
+// and no failed one within coldRetry. grow must follow a reservation.
+func (p *Pool) reserveSpawn(trigger string) bool {
+	cfg := p.config()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.workers)+p.spawning >= cfg.Pool.MaxWorkers {
+		return false
+	}
+	switch trigger {
+	case metrics.ScaleDemand:
+		if p.spawning >= int(p.waiting.Load()) || !p.spawnLimit.allow(time.Now(), cfg.Pool.SpawnRate) {
+			return false
+		}
+	case metrics.ScaleCold:
+		if len(p.workers)+p.spawning > 0 || time.Since(time.Unix(0, p.coldFailed.Load())) < coldRetry {
+			return false
+		}
+	}
+	p.spawning++
+	return true
+}
+
+// grow spawns the worker reserved by reserveSpawn and queues it. attrs
+// describe what triggered it in the log.
+func (p *Pool) grow(trigger string, attrs ...any) {
+	w, err := p.spawnWorker()
+	p.mu.Lock()
+	p.spawning--
+	total := len(p.workers)
+	p.mu.Unlock()
+
+	if err != nil {
+		if trigger == metrics.ScaleCold {
+			p.coldFailed.Store(time.Now().UnixNano())
+		}
+		if p.logger != nil {
+			p.logger.Error("scale-up failed", "trigger", trigger, "error", err)
+		}
+		return
+	}
+	if trigger == metrics.ScaleCold {
+		p.coldFailed.Store(0)
+	}
+	if p.ctx.Err() != nil {
+		w.Stop()
+		return
+	}
+	metrics.RecordScaleUp(trigger)
+	if p.logger != nil {
+		p.logger.Info("scaling up workers", append([]any{"trigger", trigger, "workers", total}, attrs...)...)
+	}
+	p.release(w)
+}
+
+// spawnLimiter is a token bucket holding up to a second's worth of spawns,
+// at least one. The rate is passed on each call so that a reloaded
+// pool.spawn_rate applies at once.
+type spawnLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token if one is left.
+func (l *spawnLimiter) allow(now time.Time, rate float64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := max(1, math.Floor(rate))
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens = min(burst, l.tokens+now.Sub(l.last).Seconds()*rate)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+func TestSpawnLimiter(t *testing.T) {
+	var l spawnLimiter
+	now := time.Unix(1_700_000_000, 0)
+
+	for i := range 2 {
+		if !l.allow(now, 2) {
+			t.Fatalf("spawn %d refused within the burst", i+1)
+		}
+	}
+	if l.allow(now, 2) {
+		t.Error("third spawn in the same instant allowed at 2/s")
+	}
+	if !l.allow(now.Add(500*time.Millisecond), 2) {
+		t.Error("spawn refused after half a second at 2/s")
+	}
+
+	// Below one a second, one spawn is still allowed at a time.
+	var slow spawnLimiter
+	if !slow.allow(now, 0.5) || slow.allow(now.Add(time.Second), 0.5) {
+		t.Error("0.5/s should allow one spawn, then none for two seconds")
+	}
+}
+
+// startBusyPool starts a one-worker pool and takes its worker, as a
+// request in progress would.
+func startBusyPool(t *testing.T, cfg *config.Config) (*Pool, *Worker) {
+	t.Helper()
+	cfg.PHP.Mode = "worker"
+	cfg.Pool.MinWorkers = 1
+	p := NewPool(cfg)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	return p, <-p.available
+}
+
+func TestExecScalesOnDemand(t *testing.T) {
+	cfg := config.Default()
+	cfg.Pool.MaxWorkers = 4
+	cfg.Pool.AllocateTimeout = config.Duration(5 * time.Second)
+	p, busy := startBusyPool(t, cfg)
+	defer func() { p.available <- busy }()
+
+	start := time.Now()
+	ctx := &phpengine.Context{}
+	if _, err := p.Exec(ctx, "/app/public/index.php"); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("request waited %s for a worker", waited)
+	}
+	if ctx.WorkerID == busy.ID() {
+		t.Errorf("request served by the busy worker %d", ctx.WorkerID)
+	}
+	if n := p.Stats().TotalWorkers(); n != 2 {
+		t.Errorf("TotalWorkers() = %d, want 2: one spawn per waiting request", n)
+	}
+}
+
+func TestExecWithoutDemandScaling(t *testing.T) {
+	for name, set := range map[string]func(*config.Config){
+		"spawn_rate 0": func(c *config.Config) { c.Pool.SpawnRate = 0 },
+		"max_workers":  func(c *config.Config) { c.Pool.MaxWorkers = 1 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Pool.AllocateTimeout = config.Duration(200 * time.Millisecond)
+			set(cfg)
+			p, busy := startBusyPool(t, cfg)
+			defer func() { p.available <- busy }()
+
+			if _, err := p.Exec(&phpengine.Context{}, "/app/public/index.php"); err == nil {
+				t.Error("Exec() found a worker, want allocate timeout")
+			}
+			if n := p.Stats().TotalWorkers(); n != 1 {
+				t.Errorf("TotalWorkers() = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestAutoScaleSmoothsLoad(t *testing.T) {
+	cfg := config.Default()
+	cfg.Pool.MaxWorkers = 4
+	p, busy := startBusyPool(t, cfg)
+	defer func() { p.available <- busy }()
+	p.busyWorkers.Store(1)
+	defer p.busyWorkers.Store(0)
+
+	// A fully busy pool reads 50%, then 75%, before crossing 80%.
+	for tick, want := range []int{1, 1, 2} {
+		p.autoScale()
+		if n := p.Stats().TotalWorkers(); n != want {
+			t.Fatalf("after tick %d: TotalWorkers() = %d, want %d (load %.1f)", tick+1, n, want, p.load)
+		}
+	}
+}
+
+func TestOnDemandPool(t *testing.T) {
+	cfg := config.Default()
+	cfg.PHP.Mode = "worker"
+	cfg.Pool.OnDemand = true
+	cfg.Pool.MaxWorkers = 4
+	cfg.Pool.SpawnRate = 0
+	cfg.Pool.AllocateTimeout = config.Duration(5 * time.Second)
+	p := NewPool(cfg)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	if n := p.Stats().TotalWorkers(); n != 0 || !p.Dormant() {
+		t.Fatalf("started with %d workers (dormant %v), want none", n, p.Dormant())
+	}
+
+	// Requests that find the pool empty share one cold start.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.Exec(&phpengine.Context{}, "/app/public/index.php")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := p.Stats().TotalWorkers(); n != 1 || p.Dormant() {
+		t.Fatalf("after a burst: %d workers (dormant %v), want 1", n, p.Dormant())
+	}
+
+	// A tick within idle_timeout keeps the worker; one after stops it.
+	p.autoScale()
+	if n := p.Stats().TotalWorkers(); n != 1 {
+		t.Fatalf("TotalWorkers() = %d within idle_timeout, want 1", n)
+	}
+	p.lastUsed.Add(-int64(cfg.Pool.IdleTimeout.Duration()))
+	p.autoScale()
+	if n := p.Stats().TotalWorkers(); n != 0 || !p.Dormant() {
+		t.Fatalf("after idle_timeout: %d workers (dormant %v), want none", n, p.Dormant())
+	}
+
+	// The next request starts it again.
+	if _, err := p.Exec(&phpengine.Context{}, "/app/public/index.php"); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Stats().TotalWorkers(); n != 1 {
+		t.Errorf("TotalWorkers() = %d after a cold start, want 1", n)
+	}
+}
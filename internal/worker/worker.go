@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -26,7 +27,12 @@ type Worker struct {
 	jobs    atomic.Int64
 	maxJobs int
 
-	mu sync.RWMutex
+	mu         sync.RWMutex
+	invalidate []string // files to drop from opcache before the next request, guarded by mu
+
+	current atomic.Pointer[Activity] // the request being executed; nil while idle
+	killed  atomic.Bool              // Kill was called; the worker is replaced, never put back
+	retired atomic.Bool              // Reload replaced it; stopped once idle, never put back
 }
 
 // NewWorker creates a new embedded PHP worker.
@@ -73,10 +79,35 @@ func (w *Worker) Stop() error {
 	return w.engine.Shutdown()
 }
 
+// Invalidate queues files to drop from the worker's opcache ahead of its
+// next request, so a busy worker is not interrupted. It queues nothing
+// when the engine cannot invalidate, and returns why.
+func (w *Worker) Invalidate(files []string) error {
+	if err := w.engine.Invalidate(nil); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.invalidate = append(w.invalidate, files...)
+	w.mu.Unlock()
+	return nil
+}
+
 // Exec executes a PHP request.
 func (w *Worker) Exec(ctx *phpengine.Context, script string) (*phpengine.Response, error) {
 	w.state.Store(int32(StateBusy))
 	defer w.state.Store(int32(StateIdle))
+	w.current.Store(newActivity(ctx))
+	defer w.current.Store(nil)
+
+	w.mu.Lock()
+	files := w.invalidate
+	w.invalidate = nil
+	w.mu.Unlock()
+	if len(files) > 0 {
+		if err := w.engine.Invalidate(files); err != nil {
+			return nil, fmt.Errorf("invalidating opcache: %w", err)
+		}
+	}
 
 	resp, err := w.engine.Execute(ctx, script)
 	if err != nil {
@@ -87,6 +118,13 @@ func (w *Worker) Exec(ctx *phpengine.Context, script string) (*phpengine.Respons
 	return resp, nil
 }
 
+// Eval evaluates code on the worker's engine; see phpengine.Engine.Eval.
+func (w *Worker) Eval(ctx context.Context, code string, maxOutput int64) ([]byte, error) {
+	w.state.Store(int32(StateBusy))
+	defer w.state.Store(int32(StateIdle))
+	return w.engine.Eval(ctx, code, maxOutput)
+}
+
 // NeedsRecycle checks if worker should be recycled.
 func (w *Worker) NeedsRecycle() bool {
 	return w.maxJobs > 0 && w.jobs.Load() >= int64(w.maxJobs)
@@ -0,0 +1,58 @@
+// Package maboo embeds maboo's PHP execution in a Go program.
+//
+// It is the public face of the packages under internal/, which can change
+// from one release to the next: the names exported here keep their
+// meaning and signatures across minor releases, and anything else is
+// reached through them. Three levels are offered:
+//
+//   - An Engine runs scripts directly, one Context at a time.
+//   - A Pool keeps workers, each with an engine of its own, and runs
+//     requests on whichever is idle. NewPool takes functional options in
+//     place of a maboo.yaml.
+//   - Pool.Handler serves HTTP from a pool, as the maboo binary does, and
+//     can be mounted into an existing mux.
+//
+// Builds with the php_embed tag run libphp; without it every supported
+// version is served by a placeholder page, which is what the examples
+// print.
+package maboo
+
+import (
+	"net/http"
+
+	"github.com/sadewadee/maboo/internal/phpengine"
+)
+
+// Engine is an embedded PHP interpreter. Startup must be called before
+// Execute, and Shutdown when done with it.
+type Engine = phpengine.Engine
+
+// Context is everything one script run sees: $_SERVER, $_GET, $_POST,
+// $_COOKIE, $_FILES, the environment and INI overrides.
+type Context = phpengine.Context
+
+// File is an uploaded file in a Context's $_FILES.
+type File = phpengine.File
+
+// Response is what a script answered: its status, headers and body.
+type Response = phpengine.Response
+
+// Request is a call into PHP from Go code, without an http.Request. A
+// relative Entry is taken from the pool's root.
+type Request = phpengine.InternalRequest
+
+// NewEngine returns an engine for a PHP version, one of Versions.
+func NewEngine(version string) (*Engine, error) {
+	return phpengine.NewEngine(version)
+}
+
+// NewContext builds the Context for running entry, relative to docRoot,
+// as the response to req. The body of a POST form is read into $_POST.
+func NewContext(req *http.Request, docRoot, entry string) *Context {
+	return phpengine.NewContext(req, docRoot, entry)
+}
+
+// Versions returns the PHP versions this build can run.
+func Versions() []string {
+	return phpengine.BundledVersions()
+}
@@ -19,11 +19,15 @@ class Wire
     public const TYPE_WORKER_STOP = 0x06;
     public const TYPE_PING = 0x07;
     public const TYPE_ERROR = 0x08;
+    public const TYPE_INVALIDATE = 0x09;
+    public const TYPE_WORKER_STATS = 0x0A;
+    public const TYPE_LOG = 0x0B;
 
     // Flags
     public const FLAG_COMPRESSED = 0x01;
     public const FLAG_CHUNKED = 0x02;
     public const FLAG_FINAL = 0x04;
+    public const FLAG_DEBUG = 0x08;
 
     /**
      * Read a frame from the given stream (default: STDIN).
@@ -16,6 +16,13 @@ class Request
         public readonly string $serverName,
         public readonly string $serverPort,
         public readonly string $protocol,
+        public readonly int $maxExecutionTime = 0,
+        public readonly string $script = '',
+        public readonly bool $https = false,
+        public readonly string $sslProtocol = '',
+        public readonly string $sslCipher = '',
+        public readonly bool $sslSessionResumed = false,
+        public readonly string $alpn = '',
     ) {}
 
     /**
@@ -33,6 +40,13 @@ class Request
             serverName: $headerData['server_name'] ?? '',
             serverPort: $headerData['server_port'] ?? '8080',
             protocol: $headerData['protocol'] ?? 'HTTP/1.1',
+            maxExecutionTime: (int) ($headerData['max_execution_time'] ?? 0),
+            script: $headerData['script'] ?? '',
+            https: (bool) ($headerData['https'] ?? false),
+            sslProtocol: $headerData['ssl_protocol'] ?? '',
+            sslCipher: $headerData['ssl_cipher'] ?? '',
+            sslSessionResumed: (bool) ($headerData['ssl_session_resumed'] ?? false),
+            alpn: $headerData['alpn'] ?? '',
         );
     }
 
@@ -75,6 +89,24 @@ class Request
             'SCRIPT_NAME' => $this->uri,
             'DOCUMENT_ROOT' => getcwd(),
         ];
+        if ($this->script !== '') {
+            $server['SCRIPT_FILENAME'] = $this->script;
+        }
+        // The TLS connection, named as mod_ssl names it
+        if ($this->https) {
+            $server['HTTPS'] = 'on';
+            $server['SSL_PROTOCOL'] = $this->sslProtocol;
+            $server['SSL_CIPHER'] = $this->sslCipher;
+            $server['SSL_SESSION_RESUMED'] = $this->sslSessionResumed ? 'Resumed' : 'Initial';
+            if ($this->alpn !== '') {
+                $server['SSL_ALPN_PROTOCOL'] = $this->alpn;
+            }
+        }
+        if (str_starts_with($this->protocol, 'HTTP/2')) {
+            $server['HTTP2'] = 'on';
+        } elseif (str_starts_with($this->protocol, 'HTTP/3')) {
+            $server['HTTP3'] = 'on';
+        }
         foreach ($this->headers as $key => $value) {
             $server['HTTP_' . strtoupper(str_replace('-', '_', $key))] = $value;
         }
@@ -84,6 +116,12 @@ class Request
         if ($cl = $this->header('content-length')) {
             $server['CONTENT_LENGTH'] = $cl;
         }
+        // What the server offers, from the worker's environment
+        foreach (['MABOO_VERSION', 'MABOO_FEATURES'] as $name) {
+            if (($value = getenv($name)) !== false) {
+                $server[$name] = $value;
+            }
+        }
         return $server;
     }
 }
@@ -55,6 +55,22 @@ class Response
         return $this;
     }
 
+    /**
+     * Have maboo send a file, relative to app.root, in place of the body.
+     * maboo serves byte ranges of it, so the download can be resumed.
+     */
+    public function download(string $path, string $contentType = 'application/octet-stream', ?string $filename = null): self
+    {
+        $this->status = 200;
+        $this->headers['Content-Type'] = $contentType;
+        $this->headers['X-Maboo-Sendfile'] = $path;
+        if ($filename !== null) {
+            $this->headers['Content-Disposition'] = 'attachment; filename="' . addcslashes($filename, '"\\') . '"';
+        }
+        $this->body = '';
+        return $this;
+    }
+
     /**
      * Send the response back to the Go server via protocol.
      */
@@ -4,35 +4,118 @@ declare(strict_types=1);
 
 namespace Maboo\WebSocket;
 
-use Maboo\Protocol\Frame;
 use Maboo\Protocol\Msgpack;
 use Maboo\Protocol\Wire;
 
 class Connection
 {
+    /**
+     * The user this connection belongs to, once accept() named one.
+     */
+    public ?string $userId = null;
+
+    private bool $rejected = false;
+
+    /**
+     * Connections created by Server queue their commands in its outbox,
+     * which answers the current event. Without one, each command is
+     * written straight away as its own frame.
+     *
+     * The request details come from the connect event; $headers holds only
+     * those listed in websocket.forward_headers, and $auth the body the
+     * websocket.auth_endpoint answered with.
+     *
+     * @param array<string, string> $headers
+     */
     public function __construct(
         public readonly string $id,
         public readonly string $remoteAddr = '',
+        private readonly ?Outbox $outbox = null,
+        public readonly string $path = '',
+        public readonly string $queryString = '',
+        public readonly array $headers = [],
+        public readonly string $subprotocol = '',
+        public readonly string $auth = '',
     ) {}
 
+    /**
+     * Build a connection from the connect event's payload.
+     */
+    public static function fromConnect(string $id, string $payload, ?Outbox $outbox = null): self
+    {
+        $info = $payload !== '' ? Msgpack::decode($payload) : [];
+        return new self(
+            $id,
+            remoteAddr: (string) ($info['remote_addr'] ?? ''),
+            outbox: $outbox,
+            path: (string) ($info['path'] ?? ''),
+            queryString: (string) ($info['query'] ?? ''),
+            headers: (array) ($info['headers'] ?? []),
+            subprotocol: (string) ($info['subprotocol'] ?? ''),
+            auth: (string) ($info['auth'] ?? ''),
+        );
+    }
+
+    /**
+     * A forwarded request header, looked up case-insensitively.
+     */
+    public function header(string $name): ?string
+    {
+        foreach ($this->headers as $key => $value) {
+            if (strcasecmp($key, $name) === 0) {
+                return $value;
+            }
+        }
+        return null;
+    }
+
+    /**
+     * The query parameters of the request, e.g. ['token' => '...'].
+     *
+     * @return array<string, mixed>
+     */
+    public function query(): array
+    {
+        parse_str($this->queryString, $params);
+        return $params;
+    }
+
+    /**
+     * Accept the connection, optionally naming the user it belongs to.
+     * Connections are accepted unless rejected, so this is only needed to
+     * assign a user.
+     */
+    public function accept(?string $userId = null, $stream = null): void
+    {
+        $command = ['event' => 'accept', 'conn_id' => $this->id];
+        if ($userId !== null) {
+            $command['user_id'] = $userId;
+            $this->userId = $userId;
+        }
+        $this->command($command, $stream);
+    }
+
+    /**
+     * Refuse the connection: it is closed at once with the close code
+     * (4000-4999 for application codes) and no close event follows.
+     */
+    public function reject(int $code = 1008, string $reason = '', $stream = null): void
+    {
+        $this->rejected = true;
+        $this->command(['event' => 'reject', 'conn_id' => $this->id, 'code' => $code, 'data' => $reason], $stream);
+    }
+
+    public function isRejected(): bool
+    {
+        return $this->rejected;
+    }
+
     /**
      * Send a message to this WebSocket connection via the Go server.
      */
     public function send(string $data, $stream = null): void
     {
-        $headerData = Msgpack::encode([
-            'conn_id' => $this->id,
-            'event' => 'message',
-            'room' => '',
-        ]);
-
-        Wire::writeFrame(new Frame(
-            type: Wire::TYPE_STREAM_DATA,
-            flags: 0,
-            streamId: 0,
-            headers: $headerData,
-            payload: $data,
-        ), $stream);
+        $this->command(['event' => 'message', 'conn_id' => $this->id, 'data' => $data], $stream);
     }
 
     /**
@@ -44,22 +127,103 @@ class Connection
     }
 
     /**
-     * Close this WebSocket connection.
+     * Add this connection to a room. The server confirms with a "joined"
+     * event (see Server::onJoin), or "room_full" if the room already has
+     * its maximum size (see Server::onRoomFull). A $maxSize above 0
+     * becomes the room's limit.
+     */
+    public function join(string $room, int $maxSize = 0, $stream = null): void
+    {
+        $this->command(['event' => 'join', 'conn_id' => $this->id, 'room' => $room, 'max_size' => $maxSize], $stream);
+    }
+
+    /**
+     * Remove this connection from a room, confirmed with a "left" event.
      */
-    public function close($stream = null): void
+    public function leave(string $room, $stream = null): void
     {
-        $headerData = Msgpack::encode([
+        $this->command(['event' => 'leave', 'conn_id' => $this->id, 'room' => $room], $stream);
+    }
+
+    /**
+     * Send a message to every member of a room, optionally leaving out
+     * this connection.
+     */
+    public function broadcast(string $room, string $data, bool $excludeSelf = false, $stream = null): void
+    {
+        $this->command([
+            'event' => $excludeSelf ? 'broadcast_except_sender' : 'message',
             'conn_id' => $this->id,
-            'event' => 'close',
-            'room' => '',
-        ]);
-
-        Wire::writeFrame(new Frame(
-            type: Wire::TYPE_STREAM_CLOSE,
-            flags: 0,
-            streamId: 0,
-            headers: $headerData,
-            payload: '',
-        ), $stream);
+            'room' => $room,
+            'data' => $data,
+        ], $stream);
+    }
+
+    /**
+     * Send a message to every member of the rooms matching a pattern, once
+     * each: "game:42:*" matches every room one level below game:42, and
+     * "game:**" every room below game.
+     */
+    public function broadcastPattern(string $pattern, string $data, bool $excludeSelf = false, $stream = null): void
+    {
+        $this->command([
+            'event' => 'broadcast_pattern',
+            'conn_id' => $this->id,
+            'room' => $pattern,
+            'exclude_sender' => $excludeSelf,
+            'data' => $data,
+        ], $stream);
+    }
+
+    /**
+     * Send a message to every connection of a user, as named by accept(),
+     * optionally leaving out this connection.
+     */
+    public function sendToUser(string $userId, string $data, bool $excludeSelf = false, $stream = null): void
+    {
+        $this->command([
+            'event' => 'message',
+            'conn_id' => $this->id,
+            'user_id' => $userId,
+            'exclude_sender' => $excludeSelf,
+            'data' => $data,
+        ], $stream);
+    }
+
+    /**
+     * Close every connection of a user, e.g. on logout from all devices.
+     */
+    public function disconnectUser(string $userId, int $code = 1000, string $reason = '', $stream = null): void
+    {
+        $this->command(['event' => 'close', 'conn_id' => $this->id, 'user_id' => $userId, 'code' => $code, 'data' => $reason], $stream);
+    }
+
+    /**
+     * Ask who is in a room. The server answers with a "presence" event
+     * (see Server::onPresence).
+     */
+    public function presence(string $room, $stream = null): void
+    {
+        $this->command(['event' => 'presence', 'conn_id' => $this->id, 'room' => $room], $stream);
+    }
+
+    /**
+     * Close this WebSocket connection.
+     */
+    public function close(int $code = 1000, string $reason = '', $stream = null): void
+    {
+        $this->command(['event' => 'close', 'conn_id' => $this->id, 'code' => $code, 'data' => $reason], $stream);
+    }
+
+    /**
+     * @param array<string, mixed> $command
+     */
+    private function command(array $command, $stream): void
+    {
+        if ($this->outbox !== null) {
+            $this->outbox->push($command);
+            return;
+        }
+        Wire::writeFrame(Outbox::frame([$command]), $stream);
     }
 }
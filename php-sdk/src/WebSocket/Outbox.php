@@ -0,0 +1,68 @@
+<?php
+
+declare(strict_types=1);
+
+namespace Maboo\WebSocket;
+
+use Maboo\Protocol\Frame;
+use Maboo\Protocol\Msgpack;
+use Maboo\Protocol\Wire;
+
+/**
+ * Collects the commands issued while handling one event. The Go server
+ * expects exactly one STREAM_DATA frame in answer to every event, so they
+ * are sent together as a batch.
+ */
+class Outbox
+{
+    /** @var list<array<string, mixed>> */
+    private array $commands = [];
+
+    /**
+     * Queue a command: event (message, join, leave, broadcast_except_sender,
+     * accept, reject, close, or allow or deny in answer to authorize),
+     * conn_id, room, exclude_sender, data, user_id and code.
+     *
+     * @param array<string, mixed> $command
+     */
+    public function push(array $command): void
+    {
+        $this->commands[] = $command;
+    }
+
+    /**
+     * Send the queued commands, possibly none, as one batch frame.
+     */
+    public function flush($stream = null): void
+    {
+        Wire::writeFrame(self::frame($this->take()), $stream);
+    }
+
+    /**
+     * Remove and return the queued commands.
+     *
+     * @return list<array<string, mixed>>
+     */
+    public function take(): array
+    {
+        $commands = $this->commands;
+        $this->commands = [];
+        return $commands;
+    }
+
+    /**
+     * Build a batch frame for commands.
+     *
+     * @param list<array<string, mixed>> $commands
+     */
+    public static function frame(array $commands): Frame
+    {
+        return new Frame(
+            type: Wire::TYPE_STREAM_DATA,
+            flags: 0,
+            streamId: 0,
+            headers: Msgpack::encode(['event' => 'batch']),
+            payload: Msgpack::encode($commands),
+        );
+    }
+}
@@ -0,0 +1,123 @@
+<?php
+
+declare(strict_types=1);
+
+namespace Maboo\WebSocket;
+
+/**
+ * Pushes messages to WebSocket clients from ordinary PHP requests, such as
+ * a webhook handler, through POST /internal/ws/publish on maboo's admin
+ * listener, and asks who is in a room through GET /internal/ws/presence.
+ *
+ *     $ws = new Publisher('http://127.0.0.1:9180', getenv('MABOO_PUBLISH_TOKEN'));
+ *     $ws->toRoom('orders.42', ['status' => 'shipped'], event: 'order.shipped');
+ */
+class Publisher
+{
+    public function __construct(
+        private readonly string $adminUrl = 'http://127.0.0.1:9180',
+        private readonly ?string $token = null,
+        private readonly float $timeout = 2.0,
+    ) {}
+
+    /**
+     * Send to every member of a room, except the connection $exclude.
+     *
+     * @return array{recipients: int, delivered: int}
+     */
+    public function toRoom(string $room, mixed $data, ?string $event = null, ?string $exclude = null): array
+    {
+        return $this->publish(['room' => $room], $data, $event, $exclude);
+    }
+
+    /**
+     * Send to every member of the rooms matching a pattern such as
+     * "game:42:*", once each, except the connection $exclude.
+     *
+     * @return array{recipients: int, delivered: int}
+     */
+    public function toPattern(string $pattern, mixed $data, ?string $event = null, ?string $exclude = null): array
+    {
+        return $this->publish(['pattern' => $pattern], $data, $event, $exclude);
+    }
+
+    /**
+     * Send to one connection.
+     *
+     * @return array{recipients: int, delivered: int}
+     */
+    public function toConnection(string $connectionId, mixed $data, ?string $event = null): array
+    {
+        return $this->publish(['connection_id' => $connectionId], $data, $event, null);
+    }
+
+    /**
+     * Send to every connection the WebSocket worker accepted for a user.
+     *
+     * @return array{recipients: int, delivered: int}
+     */
+    public function toUser(string $userId, mixed $data, ?string $event = null, ?string $exclude = null): array
+    {
+        return $this->publish(['user_id' => $userId], $data, $event, $exclude);
+    }
+
+    /**
+     * Who is in a room: user IDs with their connection counts, and the
+     * number of connections including those without a user.
+     *
+     * @return array{room: string, users: array<string, int>, connections: int}
+     */
+    public function presence(string $room): array
+    {
+        return $this->request('GET', '/internal/ws/presence?' . http_build_query(['room' => $room]), null);
+    }
+
+    /**
+     * Strings are sent as text; anything else as JSON. With $event, clients
+     * receive {"event": ..., "data": ...}.
+     *
+     * @param array<string, string> $target
+     * @return array{recipients: int, delivered: int}
+     */
+    private function publish(array $target, mixed $data, ?string $event, ?string $exclude): array
+    {
+        $body = $target + ['data' => $data];
+        if ($event !== null) {
+            $body['event'] = $event;
+        }
+        if ($exclude !== null) {
+            $body['exclude'] = $exclude;
+        }
+
+        return $this->request('POST', '/internal/ws/publish', $body);
+    }
+
+    /**
+     * @param array<string, mixed>|null $body sent as JSON
+     * @return array<string, mixed>
+     */
+    private function request(string $method, string $path, ?array $body): array
+    {
+        $headers = [];
+        $http = ['method' => $method, 'timeout' => $this->timeout, 'ignore_errors' => true];
+        if ($body !== null) {
+            $headers[] = 'Content-Type: application/json';
+            $http['content'] = json_encode($body, JSON_THROW_ON_ERROR | JSON_UNESCAPED_UNICODE);
+        }
+        if ($this->token !== null && $this->token !== '') {
+            $headers[] = 'Authorization: Bearer ' . $this->token;
+        }
+        $http['header'] = implode("\r\n", $headers);
+
+        $url = rtrim($this->adminUrl, '/') . $path;
+        $response = @file_get_contents($url, false, stream_context_create(['http' => $http]));
+        if ($response === false) {
+            throw new \RuntimeException("maboo publish: cannot reach {$url}");
+        }
+        $status = (int) explode(' ', $http_response_header[0] ?? '', 3)[1];
+        if ($status !== 200) {
+            throw new \RuntimeException("maboo publish: HTTP {$status}: " . trim($response));
+        }
+        return json_decode($response, true, 512, JSON_THROW_ON_ERROR);
+    }
+}
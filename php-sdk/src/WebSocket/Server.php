@@ -5,30 +5,61 @@ declare(strict_types=1);
 namespace Maboo\WebSocket;
 
 use Maboo\Protocol\Frame;
+use Maboo\Protocol\Msgpack;
 use Maboo\Protocol\Wire;
 
 class Server
 {
     private ?\Closure $onConnect = null;
+    private ?\Closure $onResume = null;
     private ?\Closure $onMessage = null;
     private ?\Closure $onClose = null;
     private ?\Closure $onError = null;
+    private ?\Closure $onJoin = null;
+    private ?\Closure $onLeave = null;
+    private ?\Closure $onRoomFull = null;
+    private ?\Closure $onPresence = null;
+    private ?\Closure $onTick = null;
+    private ?\Closure $onAuthorize = null;
 
     /** @var array<string, Connection> */
     private array $connections = [];
 
+    private Outbox $outbox;
+
+    public function __construct()
+    {
+        $this->outbox = new Outbox();
+    }
+
     public function onConnect(\Closure $handler): self
     {
         $this->onConnect = $handler;
         return $this;
     }
 
+    /**
+     * Called with the connection when a client resumes a dropped session
+     * (websocket.resume). It keeps its ID, rooms and user. Without this
+     * handler, onConnect is called instead.
+     */
+    public function onResume(\Closure $handler): self
+    {
+        $this->onResume = $handler;
+        return $this;
+    }
+
     public function onMessage(\Closure $handler): self
     {
         $this->onMessage = $handler;
         return $this;
     }
 
+    /**
+     * Called with the connection, the close code and the reason, e.g. 1000
+     * for a normal close, 1009 for an oversized message or 1008 for a
+     * client over websocket.message_rate.
+     */
     public function onClose(\Closure $handler): self
     {
         $this->onClose = $handler;
@@ -41,6 +72,70 @@ class Server
         return $this;
     }
 
+    /**
+     * Called with the connection and room once a join() took effect.
+     */
+    public function onJoin(\Closure $handler): self
+    {
+        $this->onJoin = $handler;
+        return $this;
+    }
+
+    /**
+     * Called with the connection and room once a leave() took effect.
+     */
+    public function onLeave(\Closure $handler): self
+    {
+        $this->onLeave = $handler;
+        return $this;
+    }
+
+    /**
+     * Called with the connection and room when a join() was refused
+     * because the room had reached its maximum size.
+     */
+    public function onRoomFull(\Closure $handler): self
+    {
+        $this->onRoomFull = $handler;
+        return $this;
+    }
+
+    /**
+     * Called with the connection, the room and its presence once a
+     * presence() is answered: ['room' => ..., 'users' => [userId => count],
+     * 'connections' => n]. Presence asked for from this handler is not
+     * answered.
+     */
+    public function onPresence(\Closure $handler): self
+    {
+        $this->onPresence = $handler;
+        return $this;
+    }
+
+    /**
+     * Called every websocket.tick_interval with a connection that has no
+     * ID, for issuing commands, and ['time' => Unix milliseconds,
+     * 'connections' => n, 'rooms' => n, 'users' => n]. Commands must name
+     * their room, user or connection.
+     */
+    public function onTick(\Closure $handler): self
+    {
+        $this->onTick = $handler;
+        return $this;
+    }
+
+    /**
+     * Called with the connection and room before a join() to a private
+     * room (websocket.private_rooms) takes effect. Return true to allow
+     * it, or false or a reason to deny it; the client is told the reason.
+     * Without this handler, private rooms cannot be joined.
+     */
+    public function onAuthorize(\Closure $handler): self
+    {
+        $this->onAuthorize = $handler;
+        return $this;
+    }
+
     /**
      * Get all active connections.
      *
@@ -69,12 +164,12 @@ class Server
      */
     public function run(): void
     {
-        // Signal ready
+        // Signal ready, declaring the features this server implements
         Wire::writeFrame(new Frame(
             type: Wire::TYPE_WORKER_READY,
             flags: 0,
             streamId: 0,
-            headers: '',
+            headers: Msgpack::encode(['features' => ['streaming']]),
             payload: '',
         ));
 
@@ -106,34 +201,135 @@ class Server
         }
     }
 
+    /**
+     * Handle one event on the embedded engine, which runs the worker script
+     * once per event instead of keeping it in a loop. The event arrives in
+     * $_SERVER (MABOO_WS_EVENT, MABOO_WS_CONN_ID, MABOO_WS_ROOM,
+     * MABOO_WS_USER_ID, MABOO_WS_CODE) with its payload in $_POST['data'],
+     * and the commands are answered as a msgpack array in the body.
+     * Connection state does not outlive the request.
+     */
+    public function handleRequest(): void
+    {
+        $header = [
+            'event' => $_SERVER['MABOO_WS_EVENT'] ?? '',
+            'conn_id' => $_SERVER['MABOO_WS_CONN_ID'] ?? '',
+            'room' => $_SERVER['MABOO_WS_ROOM'] ?? '',
+            'user_id' => $_SERVER['MABOO_WS_USER_ID'] ?? '',
+        ];
+        if (isset($_SERVER['MABOO_WS_CODE'])) {
+            $header['code'] = (int) $_SERVER['MABOO_WS_CODE'];
+        }
+        $this->dispatch($header, (string) ($_POST['data'] ?? ''));
+
+        $commands = $this->outbox->take();
+        if ($commands !== []) {
+            header('Content-Type: application/x-msgpack');
+            echo Msgpack::encode($commands);
+        }
+    }
+
     private function handleStream(Frame $frame): void
     {
         try {
-            $header = $frame->decodeHeaders();
-            $connId = $header['conn_id'] ?? '';
+            $this->dispatch($frame->decodeHeaders(), $frame->payload);
+        } catch (\Throwable $e) {
+            if ($this->onError) {
+                ($this->onError)($e);
+            }
+        }
+
+        // Every event is answered with exactly one frame, even when the
+        // handlers issued no commands.
+        $this->outbox->flush();
+    }
+
+    /**
+     * Run the handlers for one event; their commands collect in the outbox.
+     *
+     * @param array<string, mixed> $header
+     */
+    private function dispatch(array $header, string $payload): void
+    {
+        $connId = $header['conn_id'] ?? '';
+        try {
             $event = $header['event'] ?? '';
+            $room = $header['room'] ?? '';
 
             switch ($event) {
                 case 'connect':
-                    $conn = new Connection($connId);
+                    $conn = Connection::fromConnect($connId, $payload, $this->outbox);
                     $this->connections[$connId] = $conn;
                     if ($this->onConnect) {
                         ($this->onConnect)($conn);
                     }
                     break;
 
+                case 'resume':
+                    $conn = Connection::fromConnect($connId, $payload, $this->outbox);
+                    $this->connections[$connId] = $conn;
+                    $handler = $this->onResume ?? $this->onConnect;
+                    if ($handler) {
+                        $handler($conn);
+                    }
+                    break;
+
                 case 'message':
-                    $conn = $this->connections[$connId] ?? new Connection($connId);
+                    $conn = $this->connection($connId);
                     if ($this->onMessage) {
-                        ($this->onMessage)($conn, $frame->payload);
+                        ($this->onMessage)($conn, $payload);
                     }
                     break;
 
                 case 'close':
-                    $conn = $this->connections[$connId] ?? new Connection($connId);
+                    $conn = $this->connection($connId);
                     unset($this->connections[$connId]);
                     if ($this->onClose) {
-                        ($this->onClose)($conn);
+                        ($this->onClose)($conn, (int) ($header['code'] ?? 1006), $payload);
+                    }
+                    break;
+
+                case 'joined':
+                    if ($this->onJoin) {
+                        ($this->onJoin)($this->connection($connId), $room);
+                    }
+                    break;
+
+                case 'left':
+                    if ($this->onLeave) {
+                        ($this->onLeave)($this->connection($connId), $room);
+                    }
+                    break;
+
+                case 'room_full':
+                    if ($this->onRoomFull) {
+                        ($this->onRoomFull)($this->connection($connId), $room);
+                    }
+                    break;
+
+                case 'presence':
+                    if ($this->onPresence) {
+                        $presence = $payload !== '' ? Msgpack::decode($payload) : [];
+                        ($this->onPresence)($this->connection($connId), $room, $presence);
+                    }
+                    break;
+
+                case 'authorize':
+                    $decision = $this->onAuthorize
+                        ? ($this->onAuthorize)($this->connection($connId), $room)
+                        : false;
+                    $this->outbox->push([
+                        'event' => $decision === true ? 'allow' : 'deny',
+                        'conn_id' => $connId,
+                        'room' => $room,
+                        'data' => is_string($decision) ? $decision : '',
+                    ]);
+                    break;
+
+                case 'tick':
+                    if ($this->onTick) {
+                        $tick = $payload !== '' ? Msgpack::decode($payload) : [];
+                        ($this->onTick)($this->connection(''), $tick);
                     }
                     break;
             }
@@ -142,5 +338,15 @@ class Server
                 ($this->onError)($e);
             }
         }
+
+        // A rejected connection gets no close event, so forget it now.
+        if (($this->connections[$connId] ?? null)?->isRejected()) {
+            unset($this->connections[$connId]);
+        }
+    }
+
+    private function connection(string $connId): Connection
+    {
+        return $this->connections[$connId] ?? new Connection($connId, outbox: $this->outbox);
     }
 }
@@ -5,19 +5,33 @@ declare(strict_types=1);
 namespace Maboo;
 
 use Maboo\Protocol\Frame;
+use Maboo\Protocol\Msgpack;
 use Maboo\Protocol\Wire;
 
 class Worker
 {
+    /**
+     * Optional maboo features this worker implements, declared in its
+     * first WORKER_READY. maboo only uses those it offers as well. dump is
+     * declared on top of these when pcntl is loaded.
+     */
+    public const FEATURES = ['stats', 'invalidate', 'timeout', 'sendfile'];
+
     private ?\Closure $handler = null;
     private int $requestCount = 0;
     private int $maxRequests;
     private int $maxMemory;
+    private int $timeLimit;
+    private bool $handling = false;
+    private ?array $statsStart = null;
+    private bool $declared = false;
+    private bool $dumps = false;
 
     public function __construct(int $maxMemory = 128 * 1024 * 1024)
     {
         $this->maxMemory = $maxMemory;
         $this->maxRequests = (int)($_SERVER['MAX_REQUESTS'] ?? 0);
+        $this->timeLimit = (int) ini_get('max_execution_time');
     }
 
     /**
@@ -38,6 +52,9 @@ class Worker
             throw new \RuntimeException('No request handler registered. Call onRequest() before run().');
         }
 
+        register_shutdown_function(fn () => $this->answerFatal());
+        $this->dumps = $this->listenForDumps();
+
         // Signal to Go server that worker is ready
         $this->sendReady();
 
@@ -58,8 +75,18 @@ class Worker
                 continue;
             }
 
+            if ($frame->type === Wire::TYPE_INVALIDATE) {
+                $this->invalidate($frame->decodeHeaders()['files'] ?? []);
+                $this->sendReady();
+                continue;
+            }
+
             if ($frame->type === Wire::TYPE_REQUEST) {
+                if ($frame->flags & Wire::FLAG_DEBUG) {
+                    $this->startStats();
+                }
                 $this->handleRequest($frame);
+                $this->sendStats();
                 $this->requestCount++;
             }
 
@@ -85,6 +112,11 @@ class Worker
             $headerData = $frame->decodeHeaders();
             $request = Request::fromFrame($headerData, $frame->payload);
 
+            // Give up before the server does, so the client gets an answer
+            // from answerFatal rather than a dropped worker.
+            set_time_limit($request->maxExecutionTime > 0 ? $request->maxExecutionTime : $this->timeLimit);
+            $this->handling = true;
+
             // Populate PHP superglobals
             $_SERVER = $request->toServerVars();
             $_GET = $request->query();
@@ -116,8 +148,10 @@ class Worker
 
             $response = new Response();
             ($this->handler)($request, $response);
+            $this->handling = false;
             $response->send();
         } catch (\Throwable $e) {
+            $this->handling = false;
             $response = new Response();
             $response->status(500)
                 ->header('Content-Type', 'text/plain')
@@ -126,13 +160,139 @@ class Worker
         }
     }
 
+    /**
+     * Answer the request in flight when a fatal error, such as exceeding
+     * max_execution_time, ends the worker: 504 for a timeout, 500 for
+     * anything else. The server then starts a new worker.
+     */
+    private function answerFatal(): void
+    {
+        if (!$this->handling) {
+            return;
+        }
+        $this->handling = false;
+        $error = error_get_last();
+        $message = $error['message'] ?? 'worker stopped';
+        $timeout = str_starts_with($message, 'Maximum execution time');
+        $response = new Response();
+        $response->status($timeout ? 504 : 500)
+            ->header('Content-Type', 'text/plain')
+            ->body(($timeout ? 'Gateway Timeout' : 'Internal Server Error') . ': ' . $message . "\n")
+            ->send();
+        $this->sendStats();
+    }
+
+    /**
+     * Note the counters a debug request's statistics are measured from.
+     * Requests without FLAG_DEBUG skip this and sendStats entirely.
+     */
+    private function startStats(): void
+    {
+        if (function_exists('memory_reset_peak_usage')) {
+            memory_reset_peak_usage();
+        }
+        $this->statsStart = $this->opcacheCounters() + [
+            'included_files' => count(get_included_files()),
+        ];
+    }
+
+    /**
+     * Send WORKER_STATS after the response to a debug request: opcache
+     * hits and misses and the files included while it ran, its peak
+     * memory and the realpath cache size.
+     */
+    private function sendStats(): void
+    {
+        if ($this->statsStart === null) {
+            return;
+        }
+        $start = $this->statsStart;
+        $this->statsStart = null;
+        $opcache = $this->opcacheCounters();
+
+        Wire::writeFrame(new Frame(
+            type: Wire::TYPE_WORKER_STATS,
+            flags: 0,
+            streamId: 0,
+            headers: Msgpack::encode([
+                'opcache_hits' => $opcache['hits'] - $start['hits'],
+                'opcache_misses' => $opcache['misses'] - $start['misses'],
+                'included_files' => count(get_included_files()) - $start['included_files'],
+                'peak_memory' => memory_get_peak_usage(),
+                'realpath_cache_size' => realpath_cache_size(),
+            ]),
+            payload: '',
+        ));
+    }
+
+    /**
+     * @return array{hits: int, misses: int}
+     */
+    private function opcacheCounters(): array
+    {
+        $status = function_exists('opcache_get_status') ? opcache_get_status(false) : false;
+        $stats = is_array($status) ? $status['opcache_statistics'] ?? [] : [];
+        return ['hits' => (int) ($stats['hits'] ?? 0), 'misses' => (int) ($stats['misses'] ?? 0)];
+    }
+
+    /**
+     * Drop changed files from opcache so their next include compiles them
+     * again. Classes a file already declared stay loaded until the worker
+     * restarts.
+     */
+    private function invalidate(array $files): void
+    {
+        clearstatcache(true);
+        if (!function_exists('opcache_invalidate')) {
+            return;
+        }
+        foreach ($files as $file) {
+            opcache_invalidate($file, true);
+        }
+    }
+
+    /**
+     * Answer SIGUSR2, which maboo sends for GET /workers/{id}/dump, with
+     * the backtrace of the request in flight in a LOG frame. Without pcntl
+     * the worker does not declare dump, and is never sent the signal.
+     */
+    private function listenForDumps(): bool
+    {
+        if (!function_exists('pcntl_signal')) {
+            return false;
+        }
+        pcntl_async_signals(true);
+        pcntl_signal(SIGUSR2, function (): void {
+            // Between requests, or while the response is being written,
+            // there is nothing to report.
+            if (!$this->handling) {
+                return;
+            }
+            Wire::writeFrame(new Frame(
+                type: Wire::TYPE_LOG,
+                flags: 0,
+                streamId: 0,
+                headers: Msgpack::encode([
+                    'level' => 'info',
+                    'message' => 'backtrace',
+                    'backtrace' => (new \Exception())->getTraceAsString(),
+                ]),
+                payload: '',
+            ));
+        });
+        return true;
+    }
+
     private function sendReady(): void
     {
+        $features = $this->dumps ? [...self::FEATURES, 'dump'] : self::FEATURES;
+        $headers = $this->declared ? '' : Msgpack::encode(['features' => $features]);
+        $this->declared = true;
         Wire::writeFrame(new Frame(
             type: Wire::TYPE_WORKER_READY,
             flags: 0,
             streamId: 0,
-            headers: '',
+            headers: $headers,
             payload: '',
         ));
     }
@@ -0,0 +1,148 @@
+package maboo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/sadewadee/maboo/internal/config"
+	"github.com/sadewadee/maboo/internal/server"
+	"github.com/sadewadee/maboo/internal/worker"
+)
+
+// Pool runs PHP on a set of embedded workers, growing from its minimum to
+// its maximum under load, as the maboo binary's pool does.
+type Pool struct {
+	cfg    *config.Config
+	logger *slog.Logger
+	pool   *worker.Pool
+}
+
+// Option configures a Pool. Options left out keep maboo.yaml's defaults,
+// except that no static files are served.
+type Option func(*options)
+
+type options struct {
+	cfg    *config.Config
+	logger *slog.Logger
+}
+
+// WithRoot sets the application root, app.root: the document root, and
+// the directory relative entries and Request.Entry are taken from.
+func WithRoot(dir string) Option {
+	return func(o *options) { o.cfg.App.Root = dir }
+}
+
+// WithEntry sets the script serving HTTP requests, app.entry, relative to
+// the root. Without it the entry is detected from the framework in use.
+func WithEntry(script string) Option {
+	return func(o *options) { o.cfg.App.Entry = script }
+}
+
+// WithPHPVersion picks the PHP version, php.version; "auto" reads it from
+// composer.json.
+func WithPHPVersion(version string) Option {
+	return func(o *options) { o.cfg.PHP.Version = version }
+}
+
+// WithINI sets a php.ini directive for every worker.
+func WithINI(name, value string) Option {
+	return func(o *options) { o.cfg.PHP.INI[name] = value }
+}
+
+// WithWorkers sets the number of workers started, pool.min_workers, and
+// the most the pool grows to, pool.max_workers.
+func WithWorkers(min, max int) Option {
+	return func(o *options) { o.cfg.Pool.MinWorkers, o.cfg.Pool.MaxWorkers = min, max }
+}
+
+// WithMaxJobs recycles a worker after n requests, pool.max_jobs; 0 keeps
+// workers for good.
+func WithMaxJobs(n int) Option {
+	return func(o *options) { o.cfg.Pool.MaxJobs = n }
+}
+
+// WithRequestTimeout limits how long a request runs, pool.request_timeout.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *options) { o.cfg.Pool.RequestTimeout = config.Duration(d) }
+}
+
+// WithMaxResponseSize limits a response body to n bytes,
+// server.max_response_size; 0 is no limit.
+func WithMaxResponseSize(n int64) Option {
+	return func(o *options) { o.cfg.Server.MaxResponseSize = config.Size(n) }
+}
+
+// WithStatic has Handler serve static files from dir, static.root, before
+// handing requests to PHP.
+func WithStatic(dir string) Option {
+	return func(o *options) { o.cfg.Static.Root = dir }
+}
+
+// WithLogger logs the pool's events and, for Handler, its requests to
+// logger. Without it nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// NewPool configures a pool. It starts no workers until Start.
+func NewPool(opts ...Option) (*Pool, error) {
+	o := options{cfg: config.Default(), logger: slog.New(slog.DiscardHandler)}
+	o.cfg.Static.Root = ""
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("maboo: %w", err)
+	}
+	p := &Pool{cfg: o.cfg, logger: o.logger, pool: worker.NewPool(o.cfg)}
+	p.pool.SetLogger(o.logger)
+	return p, nil
+}
+
+// Start starts the pool's first workers.
+func (p *Pool) Start() error {
+	return p.pool.Start()
+}
+
+// Stop shuts the workers down; the pool cannot be started again.
+func (p *Pool) Stop() error {
+	return p.pool.Stop()
+}
+
+// Exec runs script with ctx on an idle worker, waiting for one up to
+// pool.allocate_timeout.
+func (p *Pool) Exec(ctx *Context, script string) (*Response, error) {
+	return p.pool.Exec(ctx, script)
+}
+
+// Invoke runs r on an idle worker and waits for its response until ctx
+// ends. A deadline on ctx lowers PHP's max_execution_time to fit.
+func (p *Pool) Invoke(ctx context.Context, r Request) (*Response, error) {
+	return p.pool.Invoke(ctx, r)
+}
+
+// Stats is a snapshot of a pool's workers.
+type Stats struct {
+	Workers  int   // running
+	Busy     int   // running a request
+	Idle     int   // waiting for one
+	Requests int64 // served since Start
+}
+
+// Stats returns the pool's current Stats.
+func (p *Pool) Stats() Stats {
+	s := p.pool.Stats()
+	return Stats{Workers: s.TotalWorkers(), Busy: s.BusyWorkers(), Idle: s.IdleWorkers(), Requests: s.TotalRequests()}
+}
+
+// Handler returns an http.Handler serving requests from the pool, with
+// the request IDs, panic recovery and access log of the maboo binary.
+// It answers /health and /ready itself; mounted under a prefix, strip it
+// with http.StripPrefix so PHP sees the paths it routes on.
+func (p *Pool) Handler() http.Handler {
+	router := server.NewRouter(p.cfg, p.pool, p.logger)
+	return server.CoreMiddleware(p.logger, p.logger, nil, p.cfg.Tracing, nil)(router)
+}